@@ -0,0 +1,65 @@
+/*
+Command lmdbdoctor inspects an LMDB environment and the host it is stored on
+and prints findings about risky configurations, such as a nearly full map,
+NoSync without periodic syncing, too few reader slots, lock file permission
+problems, or an environment on a network filesystem.  See package lmdbdoctor
+for the checks performed.
+
+	lmdbdoctor [-n] [-sync] path
+
+The environment is opened read-only.  The exit status is 1 if any finding has
+severity error.
+*/
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/glycerine/lmdb-go/int/lmdbcmd"
+	"github.com/glycerine/lmdb-go/lmdb"
+	"github.com/glycerine/lmdb-go/lmdbdoctor"
+)
+
+func main() {
+	opt := &lmdbdoctor.Options{}
+	flag.BoolVar(&opt.PeriodicSync, "sync", false, "The application calls Env.Sync periodically.")
+	flag.Parse()
+
+	lmdbcmd.PrintVersion()
+
+	if flag.NArg() != 1 {
+		log.Fatalf("exactly one environment path must be specified")
+	}
+
+	findings, err := diagnose(flag.Arg(0), opt)
+	if err != nil {
+		log.Fatal(err)
+	}
+	status := 0
+	for _, f := range findings {
+		fmt.Println(f)
+		if f.Severity == lmdbdoctor.Error {
+			status = 1
+		}
+	}
+	if len(findings) == 0 {
+		fmt.Println("no problems found")
+	}
+	os.Exit(status)
+}
+
+func diagnose(path string, opt *lmdbdoctor.Options) ([]lmdbdoctor.Finding, error) {
+	env, err := lmdb.NewEnv()
+	if err != nil {
+		return nil, err
+	}
+	defer env.Close()
+	err = env.Open(path, lmdbcmd.OpenFlag()|lmdb.Readonly, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return lmdbdoctor.Check(env, opt)
+}
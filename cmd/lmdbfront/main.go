@@ -0,0 +1,63 @@
+/*
+Command lmdbfront rebuilds a named database of an LMDB environment with its
+keys front coded by package lmdbfront.
+
+	lmdbfront [-block items] [-maxdbs n] [-mapsize bytes] path src dst
+
+The items of database src are written to database dst, which is created or
+emptied, in blocks of the given number of items.  src is left unchanged.
+Applications must read dst with package lmdbfront, and drop src once they
+have switched to it.  DupSort databases cannot be front coded.
+*/
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/glycerine/lmdb-go/int/lmdbcmd"
+	"github.com/glycerine/lmdb-go/lmdb"
+	"github.com/glycerine/lmdb-go/lmdbfront"
+)
+
+func main() {
+	block := flag.Int("block", lmdbfront.DefaultBlockSize, "Number of items per block.")
+	maxdbs := flag.Int("maxdbs", 128, "Maximum number of named databases of the environment.")
+	mapsize := flag.Int64("mapsize", 0, "Map size of the environment (default: its current map size).")
+	flag.Parse()
+
+	lmdbcmd.PrintVersion()
+
+	if flag.NArg() != 3 {
+		log.Fatalf("usage: lmdbfront [flags] path src dst")
+	}
+	n, err := rebuild(flag.Arg(0), flag.Arg(1), flag.Arg(2), *block, *maxdbs, *mapsize)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("%s: %d items front coded into %s\n", flag.Arg(1), n, flag.Arg(2))
+}
+
+func rebuild(path, src, dst string, block, maxdbs int, mapsize int64) (int, error) {
+	env, err := lmdb.NewEnv()
+	if err != nil {
+		return 0, err
+	}
+	defer env.Close()
+	err = env.SetMaxDBs(maxdbs)
+	if err != nil {
+		return 0, err
+	}
+	if mapsize > 0 {
+		err = env.SetMapSize(mapsize)
+		if err != nil {
+			return 0, err
+		}
+	}
+	err = env.Open(path, lmdbcmd.OpenFlag(), 0644)
+	if err != nil {
+		return 0, err
+	}
+	return lmdbfront.Rebuild(env, src, dst, block)
+}
@@ -0,0 +1,11 @@
+module github.com/glycerine/lmdb-go/cmd/lmdbfs
+
+go 1.14
+
+require (
+	bazil.org/fuse v0.0.0-20200117225306-7b5117fecadc
+	github.com/glycerine/lmdb-go v0.0.0-00010101000000-000000000000
+	golang.org/x/sys v0.10.0 // indirect
+)
+
+replace github.com/glycerine/lmdb-go => ../..
@@ -0,0 +1,461 @@
+//go:build linux || freebsd
+// +build linux freebsd
+
+/*
+Command lmdbfs mounts an LMDB environment read-only as a FUSE filesystem so
+that its contents can be explored with standard tools like ls, cat and grep.
+
+	lmdbfs [-n] [-refresh interval] [-limit entries] path mountpoint
+
+The root directory of the mount is the main database.  Keys of the main
+database that name a database are directories listing the keys of that
+database, every other key is a file holding its value.  The value files of a
+DupSort database hold every data item of the key, each followed by a newline.
+
+Keys appear as file names with bytes that cannot appear in a name (slash,
+percent, control characters and invalid UTF-8) escaped as %XX, as are the
+leading dot of the keys "." and "..".
+
+The mount serves a snapshot of the environment, held in one read
+transaction.  The snapshot is refreshed every refresh interval and on
+SIGHUP, so updates become visible at the next refresh.  Like any read
+transaction the snapshot keeps the pages it references from being reused,
+so long refresh intervals on a busy environment grow the data file.
+
+lmdbfs unmounts the filesystem when it receives SIGINT or SIGTERM.
+
+lmdbfs is a module of its own, so that its FUSE dependency is not required by
+the users of the lmdb packages.  Build it from its directory:
+
+	cd cmd/lmdbfs && go install
+*/
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+
+	"github.com/glycerine/lmdb-go/int/lmdbcmd"
+	"github.com/glycerine/lmdb-go/lmdb"
+)
+
+func main() {
+	opt := &Options{}
+	flag.DurationVar(&opt.Refresh, "refresh", 10*time.Second, "Interval between snapshot refreshes (0 refreshes only on SIGHUP).")
+	flag.IntVar(&opt.Limit, "limit", 100000, "Maximum number of entries listed per directory.")
+	flag.IntVar(&opt.MaxDBs, "maxdbs", 1024, "Maximum number of named databases served.")
+	flag.Parse()
+
+	lmdbcmd.PrintVersion()
+
+	if flag.NArg() != 2 {
+		log.Fatalf("usage: lmdbfs [flags] path mountpoint")
+	}
+	err := serve(flag.Arg(0), flag.Arg(1), opt)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Options contain the command line options for an lmdbfs command.
+type Options struct {
+	Refresh time.Duration
+	Limit   int
+	MaxDBs  int
+}
+
+func serve(path, mountpoint string, opt *Options) error {
+	env, err := lmdb.NewEnv()
+	if err != nil {
+		return err
+	}
+	defer env.Close()
+	err = env.SetMaxDBs(opt.MaxDBs)
+	if err != nil {
+		return err
+	}
+	err = env.Open(path, lmdbcmd.OpenFlag()|lmdb.Readonly, 0644)
+	if err != nil {
+		return err
+	}
+
+	filesys := &FS{env: env, limit: opt.Limit, valid: opt.Refresh}
+	err = filesys.refresh()
+	if err != nil {
+		return err
+	}
+	defer filesys.close()
+
+	c, err := fuse.Mount(mountpoint,
+		fuse.ReadOnly(),
+		fuse.FSName(path),
+		fuse.Subtype("lmdbfs"),
+	)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+	var tick <-chan time.Time
+	if opt.Refresh > 0 {
+		t := time.NewTicker(opt.Refresh)
+		defer t.Stop()
+		tick = t.C
+	}
+	go func() {
+		for {
+			select {
+			case s := <-sig:
+				if s != syscall.SIGHUP {
+					err := fuse.Unmount(mountpoint)
+					if err != nil {
+						log.Printf("unmount: %v", err)
+					}
+					continue
+				}
+			case <-tick:
+			}
+			err := filesys.refresh()
+			if err != nil {
+				log.Printf("refresh: %v", err)
+			}
+		}
+	}()
+
+	err = fs.Serve(c, filesys)
+	if err != nil {
+		return err
+	}
+	<-c.Ready
+	return c.MountError
+}
+
+// FS is a read-only snapshot of an environment served as a filesystem.  All
+// access to the snapshot transaction is serialized by mu.
+type FS struct {
+	env   *lmdb.Env
+	limit int
+	valid time.Duration
+
+	mu   sync.Mutex
+	txn  *lmdb.Txn
+	time time.Time
+	root lmdb.DBI
+	dbis map[string]lmdb.DBI // named databases by key
+	dups map[lmdb.DBI]bool   // DupSort databases
+}
+
+// refresh replaces the snapshot with a new read transaction.
+func (f *FS) refresh() error {
+	dbis, dups, err := f.openDBIs()
+	if err != nil {
+		return err
+	}
+	txn, err := f.env.BeginTxn(nil, lmdb.Readonly)
+	if err != nil {
+		return err
+	}
+	root, err := txn.OpenRoot(0)
+	if err != nil {
+		txn.Abort()
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.txn != nil {
+		f.txn.Abort()
+	}
+	f.txn = txn
+	f.time = time.Now()
+	f.root = root
+	f.dbis = dbis
+	f.dups = dups
+	return nil
+}
+
+// openDBIs opens every named database.  The handles are opened in a read
+// transaction that is committed so that they remain valid for the snapshots
+// taken afterwards.
+func (f *FS) openDBIs() (dbis map[string]lmdb.DBI, dups map[lmdb.DBI]bool, err error) {
+	dbis = make(map[string]lmdb.DBI)
+	dups = make(map[lmdb.DBI]bool)
+	txn, err := f.env.BeginTxn(nil, lmdb.Readonly)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		if err != nil {
+			txn.Abort()
+		}
+	}()
+	root, err := txn.OpenRoot(0)
+	if err != nil {
+		return nil, nil, err
+	}
+	cur, err := txn.OpenCursor(root)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer cur.Close()
+	for {
+		k, _, err := cur.Get(nil, nil, lmdb.NextNoDup)
+		if lmdb.IsNotFound(err) {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		dbi, err := txn.OpenDBI(string(k), 0)
+		if lmdb.IsErrno(err, lmdb.Incompatible) {
+			// a plain key of the main database.
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		flags, err := txn.Flags(dbi)
+		if err != nil {
+			return nil, nil, err
+		}
+		dbis[string(k)] = dbi
+		dups[dbi] = flags&lmdb.DupSort != 0
+	}
+	return dbis, dups, txn.Commit()
+}
+
+func (f *FS) close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.txn != nil {
+		f.txn.Abort()
+		f.txn = nil
+	}
+}
+
+func (f *FS) attr(a *fuse.Attr) {
+	a.Valid = f.valid
+	a.Mtime = f.time
+	a.Ctime = f.time
+	a.Atime = f.time
+}
+
+// Root implements fs.FS.
+func (f *FS) Root() (fs.Node, error) {
+	return &Dir{fs: f, root: true}, nil
+}
+
+// Dir is a database.
+type Dir struct {
+	fs   *FS
+	root bool
+	name string // key of the database in the main database
+}
+
+// dbi returns the handle of the database in the current snapshot.  The
+// caller must hold fs.mu.
+func (d *Dir) dbi() (lmdb.DBI, error) {
+	if d.root {
+		return d.fs.root, nil
+	}
+	dbi, ok := d.fs.dbis[d.name]
+	if !ok {
+		// the database was dropped since the directory was looked up.
+		return 0, syscall.ENOENT
+	}
+	return dbi, nil
+}
+
+// Attr implements fs.Node.
+func (d *Dir) Attr(ctx context.Context, a *fuse.Attr) error {
+	d.fs.mu.Lock()
+	defer d.fs.mu.Unlock()
+	d.fs.attr(a)
+	a.Mode = os.ModeDir | 0555
+	return nil
+}
+
+// Lookup implements fs.NodeStringLookuper.
+func (d *Dir) Lookup(ctx context.Context, name string) (fs.Node, error) {
+	key, ok := unescapeName(name)
+	if !ok {
+		return nil, syscall.ENOENT
+	}
+	d.fs.mu.Lock()
+	defer d.fs.mu.Unlock()
+	if d.root {
+		if _, ok := d.fs.dbis[string(key)]; ok {
+			return &Dir{fs: d.fs, name: string(key)}, nil
+		}
+	}
+	dbi, err := d.dbi()
+	if err != nil {
+		return nil, err
+	}
+	_, err = d.fs.txn.Get(dbi, key)
+	if lmdb.IsNotFound(err) {
+		return nil, syscall.ENOENT
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &File{dir: d, key: key}, nil
+}
+
+// ReadDirAll implements fs.HandleReadDirAller.
+func (d *Dir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
+	d.fs.mu.Lock()
+	defer d.fs.mu.Unlock()
+	dbi, err := d.dbi()
+	if err != nil {
+		return nil, err
+	}
+	cur, err := d.fs.txn.OpenCursor(dbi)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close()
+
+	var ents []fuse.Dirent
+	for {
+		k, _, err := cur.Get(nil, nil, lmdb.NextNoDup)
+		if lmdb.IsNotFound(err) {
+			return ents, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(ents) == d.fs.limit {
+			log.Printf("listing of %q truncated at %d entries", d.name, d.fs.limit)
+			return ents, nil
+		}
+		typ := fuse.DT_File
+		if d.root {
+			if _, ok := d.fs.dbis[string(k)]; ok {
+				typ = fuse.DT_Dir
+			}
+		}
+		ents = append(ents, fuse.Dirent{Name: escapeName(k), Type: typ})
+	}
+}
+
+// File is the value of a key.
+type File struct {
+	dir *Dir
+	key []byte
+}
+
+// value returns the contents of the file.  The caller must hold fs.mu.
+func (f *File) value() ([]byte, error) {
+	dbi, err := f.dir.dbi()
+	if err != nil {
+		return nil, err
+	}
+	txn := f.dir.fs.txn
+	if !f.dir.fs.dups[dbi] {
+		v, err := txn.Get(dbi, f.key)
+		if lmdb.IsNotFound(err) {
+			return nil, syscall.ENOENT
+		}
+		return v, err
+	}
+
+	cur, err := txn.OpenCursor(dbi)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close()
+	var buf bytes.Buffer
+	_, v, err := cur.Get(f.key, nil, lmdb.Set)
+	for ; err == nil; _, v, err = cur.Get(nil, nil, lmdb.NextDup) {
+		buf.Write(v)
+		buf.WriteByte('\n')
+	}
+	if !lmdb.IsNotFound(err) {
+		return nil, err
+	}
+	if buf.Len() == 0 {
+		return nil, syscall.ENOENT
+	}
+	return buf.Bytes(), nil
+}
+
+// Attr implements fs.Node.
+func (f *File) Attr(ctx context.Context, a *fuse.Attr) error {
+	f.dir.fs.mu.Lock()
+	defer f.dir.fs.mu.Unlock()
+	v, err := f.value()
+	if err != nil {
+		return err
+	}
+	f.dir.fs.attr(a)
+	a.Mode = 0444
+	a.Size = uint64(len(v))
+	return nil
+}
+
+// ReadAll implements fs.HandleReadAller.
+func (f *File) ReadAll(ctx context.Context) ([]byte, error) {
+	f.dir.fs.mu.Lock()
+	defer f.dir.fs.mu.Unlock()
+	return f.value()
+}
+
+// escapeName returns the file name of key.
+func escapeName(key []byte) string {
+	var buf bytes.Buffer
+	for i := 0; i < len(key); {
+		r, size := utf8.DecodeRune(key[i:])
+		switch {
+		case r == utf8.RuneError && size <= 1, r < 0x20, r == 0x7f, r == '/', r == '%':
+			fmt.Fprintf(&buf, "%%%02X", key[i])
+			i++
+		default:
+			buf.Write(key[i : i+size])
+			i += size
+		}
+	}
+	name := buf.String()
+	if name == "." || name == ".." {
+		name = "%2E" + name[1:]
+	}
+	return name
+}
+
+// unescapeName returns the key named name.  It returns false if name is not
+// a valid escaped key, or not the one escapeName returns for its key, so that
+// every key has a single name.
+func unescapeName(name string) ([]byte, bool) {
+	key := make([]byte, 0, len(name))
+	for i := 0; i < len(name); i++ {
+		if name[i] != '%' {
+			key = append(key, name[i])
+			continue
+		}
+		if i+2 >= len(name) {
+			return nil, false
+		}
+		b, err := strconv.ParseUint(name[i+1:i+3], 16, 8)
+		if err != nil {
+			return nil, false
+		}
+		key = append(key, byte(b))
+		i += 2
+	}
+	return key, len(key) > 0 && escapeName(key) == name
+}
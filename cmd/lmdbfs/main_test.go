@@ -0,0 +1,121 @@
+//go:build linux || freebsd
+// +build linux freebsd
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/glycerine/lmdb-go/lmdb"
+)
+
+func TestEscapeName(t *testing.T) {
+	for _, test := range []struct {
+		key  string
+		name string
+	}{
+		{"plain", "plain"},
+		{"é", "é"},
+		{".", "%2E"},
+		{"..", "%2E."},
+		{"...", "..."},
+		{".hidden", ".hidden"},
+		{"a/b", "a%2Fb"},
+		{"100%", "100%25"},
+		{"%2F", "%252F"},
+		{"tab\there", "tab%09here"},
+		{"\x7f", "%7F"},
+		{"\xff\xfe", "%FF%FE"},
+		{"\xc3", "%C3"},
+		{"\x00", "%00"},
+	} {
+		name := escapeName([]byte(test.key))
+		if name != test.name {
+			t.Errorf("escapeName(%q) = %q, want %q", test.key, name, test.name)
+		}
+		key, ok := unescapeName(name)
+		if !ok || !bytes.Equal(key, []byte(test.key)) {
+			t.Errorf("unescapeName(%q) = %q %v, want %q", name, key, ok, test.key)
+		}
+	}
+}
+
+func TestUnescapeName_invalid(t *testing.T) {
+	for _, name := range []string{
+		"",
+		".",
+		"..",
+		"%",
+		"%2",
+		"a%",
+		"%G0",
+		"%2f",    // lowercase hex would name the key of %2F
+		"%41",    // A needs no escape
+		"%2E%2E", // the name of .. is %2E.
+	} {
+		if key, ok := unescapeName(name); ok {
+			t.Errorf("unescapeName(%q) = %q", name, key)
+		}
+	}
+}
+
+func TestFS_openDBIs(t *testing.T) {
+	path, err := ioutil.TempDir("", "lmdbfs-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(path)
+	env, err := lmdb.NewEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer env.Close()
+	if err = env.SetMaxDBs(4); err != nil {
+		t.Fatal(err)
+	}
+	if err = env.Open(path, 0, 0644); err != nil {
+		t.Fatal(err)
+	}
+	err = env.Update(func(txn *lmdb.Txn) error {
+		for name, flags := range map[string]uint{"data": 0, "index": lmdb.DupSort} {
+			dbi, err := txn.OpenDBI(name, lmdb.Create|flags)
+			if err != nil {
+				return err
+			}
+			if err = txn.Put(dbi, []byte("k"), []byte("v"), 0); err != nil {
+				return err
+			}
+		}
+		root, err := txn.OpenRoot(0)
+		if err != nil {
+			return err
+		}
+		return txn.Put(root, []byte("plain"), []byte("v"), 0)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := &FS{env: env}
+	dbis, dups, err := f.openDBIs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dbis) != 2 {
+		t.Errorf("databases %v", dbis)
+	}
+	data, ok := dbis["data"]
+	if !ok || dups[data] {
+		t.Errorf("data: %v dupsort %v", ok, dups[data])
+	}
+	index, ok := dbis["index"]
+	if !ok || !dups[index] {
+		t.Errorf("index: %v dupsort %v", ok, dups[index])
+	}
+	if _, ok := dbis["plain"]; ok {
+		t.Error("plain key served as a database")
+	}
+}
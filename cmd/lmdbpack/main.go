@@ -0,0 +1,450 @@
+/*
+Command lmdbpack builds a compacted LMDB environment from a directory of CSV
+and JSON files, for distributing read-only lookup datasets with applications.
+
+	lmdbpack [-spec spec.json] [-mapsize bytes] [-batch items] [-tmp dir] [-checksum] indir outdir
+
+The spec maps input files to named databases.  It is a JSON object with a
+list of databases, each naming the files it is loaded from (a glob relative
+to indir), their format and the fields forming keys and values:
+
+	{"databases": [
+		{"name": "cities", "files": "cities*.csv", "format": "csv",
+		 "key": ["country", "city"], "value": ["population"]},
+		{"name": "codes", "files": "codes.json", "format": "json",
+		 "key": ["code"], "dupsort": true}
+	]}
+
+CSV files must start with a header row naming their columns.  JSON files
+hold either an array of objects or a sequence of objects, such as JSON
+lines.  A key is the values of its fields joined by keysep (a NUL byte by
+default).  A value made of a single field is that field's raw text;
+otherwise it is a JSON object of the listed fields, or of the whole record
+if value is empty.  Duplicate keys are an error unless the database is
+dupsort.  When -spec is not given, indir/lmdbpack.json is used.
+
+Items are sorted in batches and loaded into a temporary environment, which is
+then copied with compaction into outdir/data.mdb, so that pages are full and
+the tree is laid out in key order.  With -checksum the SHA-256 digest of the
+data file is written to outdir/data.mdb.sha256, in the format read by
+sha256sum -c.  The result is meant to be opened with lmdb.Readonly.
+*/
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/glycerine/lmdb-go/int/lmdbcmd"
+	"github.com/glycerine/lmdb-go/lmdb"
+)
+
+func main() {
+	opt := &Options{}
+	flag.StringVar(&opt.Spec, "spec", "", "Path of the mapping spec (default: indir/lmdbpack.json).")
+	flag.Int64Var(&opt.MapSize, "mapsize", 1<<32, "Map size of the temporary environment.")
+	flag.IntVar(&opt.Batch, "batch", 100000, "Number of items sorted and written per transaction.")
+	flag.StringVar(&opt.TempDir, "tmp", "", "Directory of the temporary environment (default: /dev/shm or the system temporary directory).")
+	flag.BoolVar(&opt.Checksum, "checksum", false, "Write the SHA-256 digest of the data file to data.mdb.sha256.")
+	flag.Parse()
+
+	lmdbcmd.PrintVersion()
+
+	if flag.NArg() != 2 {
+		log.Fatalf("usage: lmdbpack [flags] indir outdir")
+	}
+	if opt.Spec == "" {
+		opt.Spec = filepath.Join(flag.Arg(0), "lmdbpack.json")
+	}
+	spec, err := readSpec(opt.Spec)
+	if err != nil {
+		log.Fatal(err)
+	}
+	counts, err := pack(flag.Arg(0), flag.Arg(1), spec, opt)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, db := range spec.Databases {
+		fmt.Fprintf(os.Stderr, "%s: %d items\n", db.Name, counts[db.Name])
+	}
+}
+
+// Options contain the command line options for an lmdbpack command.
+type Options struct {
+	Spec     string
+	MapSize  int64
+	Batch    int
+	TempDir  string
+	Checksum bool
+}
+
+// Spec maps input files to the databases of the packed environment.
+type Spec struct {
+	Databases []DBSpec `json:"databases"`
+}
+
+// DBSpec describes how a database is loaded.
+type DBSpec struct {
+	Name    string   `json:"name"`
+	Files   string   `json:"files"`
+	Format  string   `json:"format"`
+	Key     []string `json:"key"`
+	KeySep  *string  `json:"keysep"`
+	Value   []string `json:"value"`
+	DupSort bool     `json:"dupsort"`
+}
+
+func readSpec(path string) (*Spec, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	spec := &Spec{}
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	err = dec.Decode(spec)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if len(spec.Databases) == 0 {
+		return nil, fmt.Errorf("%s: no databases", path)
+	}
+	seen := make(map[string]bool)
+	for _, db := range spec.Databases {
+		switch {
+		case db.Name == "":
+			return nil, fmt.Errorf("%s: database without a name", path)
+		case seen[db.Name]:
+			return nil, fmt.Errorf("%s: database %s listed twice", path, db.Name)
+		case db.Files == "":
+			return nil, fmt.Errorf("%s: database %s has no files", path, db.Name)
+		case db.Format != "csv" && db.Format != "json":
+			return nil, fmt.Errorf("%s: database %s: format must be csv or json", path, db.Name)
+		case len(db.Key) == 0:
+			return nil, fmt.Errorf("%s: database %s has no key fields", path, db.Name)
+		}
+		seen[db.Name] = true
+	}
+	return spec, nil
+}
+
+// pack loads the databases of spec from the files in indir and writes the
+// compacted environment to outdir.  It returns the number of items loaded
+// into each database.
+func pack(indir, outdir string, spec *Spec, opt *Options) (map[string]int, error) {
+	if opt.Batch < 1 {
+		opt.Batch = 1
+	}
+	err := os.Mkdir(outdir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	tmp, err := lmdb.OpenTempEnv(&lmdb.TempEnvOptions{
+		Dir:     opt.TempDir,
+		MapSize: opt.MapSize,
+		MaxDBs:  len(spec.Databases),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer tmp.Close()
+
+	counts := make(map[string]int)
+	for _, db := range spec.Databases {
+		n, err := load(tmp, indir, db, opt.Batch)
+		if err != nil {
+			return nil, fmt.Errorf("database %s: %v", db.Name, err)
+		}
+		counts[db.Name] = n
+	}
+
+	err = tmp.CopyFlag(outdir, lmdb.CopyCompact)
+	if err != nil {
+		return nil, err
+	}
+	if opt.Checksum {
+		err = writeChecksum(filepath.Join(outdir, "data.mdb"))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return counts, nil
+}
+
+// item is a record to load, with the file position it was read from.
+type item struct {
+	key, val []byte
+	pos      string
+}
+
+// load writes the records of the files of db into env, sorting each batch
+// before writing it.
+func load(env *lmdb.Env, indir string, db DBSpec, batch int) (int, error) {
+	files, err := filepath.Glob(filepath.Join(indir, db.Files))
+	if err != nil {
+		return 0, err
+	}
+	if len(files) == 0 {
+		return 0, fmt.Errorf("no files match %s", db.Files)
+	}
+	sort.Strings(files)
+
+	var dbi lmdb.DBI
+	flags := uint(lmdb.Create)
+	if db.DupSort {
+		flags |= lmdb.DupSort
+	}
+	err = env.Update(func(txn *lmdb.Txn) (err error) {
+		dbi, err = txn.OpenDBI(db.Name, flags)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var n int
+	var items []item
+	flush := func() error {
+		sort.Slice(items, func(i, j int) bool {
+			c := bytes.Compare(items[i].key, items[j].key)
+			if c == 0 {
+				return bytes.Compare(items[i].val, items[j].val) < 0
+			}
+			return c < 0
+		})
+		err := env.Update(func(txn *lmdb.Txn) error {
+			put := uint(lmdb.NoOverwrite)
+			if db.DupSort {
+				put = lmdb.NoDupData
+			}
+			for _, it := range items {
+				err := txn.Put(dbi, it.key, it.val, put)
+				if lmdb.IsErrno(err, lmdb.KeyExist) {
+					if db.DupSort {
+						continue
+					}
+					return fmt.Errorf("%s: duplicate key %q", it.pos, it.key)
+				}
+				if err != nil {
+					return err
+				}
+				n++
+			}
+			return nil
+		})
+		items = items[:0]
+		return err
+	}
+
+	sep := "\x00"
+	if db.KeySep != nil {
+		sep = *db.KeySep
+	}
+	for _, path := range files {
+		err = readRecords(path, db.Format, func(pos string, rec map[string]string) error {
+			key, val, err := encode(db, sep, rec)
+			if err != nil {
+				return fmt.Errorf("%s: %v", pos, err)
+			}
+			items = append(items, item{key: key, val: val, pos: pos})
+			if len(items) < batch {
+				return nil
+			}
+			return flush()
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	err = flush()
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// encode returns the key and value of rec as described by db.
+func encode(db DBSpec, sep string, rec map[string]string) (key, val []byte, err error) {
+	parts := make([]string, len(db.Key))
+	for i, field := range db.Key {
+		v, ok := rec[field]
+		if !ok {
+			return nil, nil, fmt.Errorf("missing key field %q", field)
+		}
+		parts[i] = v
+	}
+	key = []byte(strings.Join(parts, sep))
+	if len(key) == 0 {
+		return nil, nil, errors.New("empty key")
+	}
+
+	if len(db.Value) == 1 {
+		v, ok := rec[db.Value[0]]
+		if !ok {
+			return nil, nil, fmt.Errorf("missing value field %q", db.Value[0])
+		}
+		return key, []byte(v), nil
+	}
+	obj := rec
+	if len(db.Value) > 0 {
+		obj = make(map[string]string, len(db.Value))
+		for _, field := range db.Value {
+			v, ok := rec[field]
+			if !ok {
+				return nil, nil, fmt.Errorf("missing value field %q", field)
+			}
+			obj[field] = v
+		}
+	}
+	// json.Marshal sorts map keys, so equal records encode equally.
+	val, err = json.Marshal(obj)
+	return key, val, err
+}
+
+// readRecords calls fn with each record of the file at path.  Fields of JSON
+// records that are not strings are passed as their JSON text.
+func readRecords(path, format string, fn func(pos string, rec map[string]string) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if format == "csv" {
+		return readCSV(path, f, fn)
+	}
+	return readJSON(path, f, fn)
+}
+
+func readCSV(path string, r io.Reader, fn func(pos string, rec map[string]string) error) error {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	for n := 1; ; n++ {
+		row, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		rec := make(map[string]string, len(header))
+		for i, name := range header {
+			rec[name] = row[i]
+		}
+		err = fn(fmt.Sprintf("%s: row %d", path, n), rec)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func readJSON(path string, r io.Reader, fn func(pos string, rec map[string]string) error) error {
+	br := bufio.NewReader(r)
+	array, err := startsWithArray(br)
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	dec := json.NewDecoder(br)
+	if array {
+		_, err = dec.Token()
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	for n := 1; ; n++ {
+		if array && !dec.More() {
+			return nil
+		}
+		var obj map[string]json.RawMessage
+		err := dec.Decode(&obj)
+		if err == io.EOF && !array {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: record %d: %v", path, n, err)
+		}
+		rec, err := flatten(obj)
+		if err != nil {
+			return fmt.Errorf("%s: record %d: %v", path, n, err)
+		}
+		err = fn(fmt.Sprintf("%s: record %d", path, n), rec)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// startsWithArray skips leading white space in br and reports whether the
+// next byte opens a JSON array.
+func startsWithArray(br *bufio.Reader) (bool, error) {
+	for {
+		c, err := br.ReadByte()
+		if err != nil {
+			return false, err
+		}
+		switch c {
+		case ' ', '\t', '\r', '\n':
+			continue
+		}
+		return c == '[', br.UnreadByte()
+	}
+}
+
+// flatten converts the fields of obj to strings, keeping non-string values
+// as JSON text.
+func flatten(obj map[string]json.RawMessage) (map[string]string, error) {
+	if obj == nil {
+		return nil, errors.New("record is not an object")
+	}
+	rec := make(map[string]string, len(obj))
+	for name, raw := range obj {
+		var s string
+		if json.Unmarshal(raw, &s) == nil {
+			rec[name] = s
+			continue
+		}
+		rec[name] = string(raw)
+	}
+	return rec, nil
+}
+
+// writeChecksum writes the SHA-256 digest of the file at path to
+// path+".sha256".
+func writeChecksum(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return err
+	}
+	line := fmt.Sprintf("%s  %s\n", hex.EncodeToString(h.Sum(nil)), filepath.Base(path))
+	return ioutil.WriteFile(path+".sha256", []byte(line), 0644)
+}
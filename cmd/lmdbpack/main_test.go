@@ -0,0 +1,131 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/glycerine/lmdb-go/lmdb"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestPack(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lmdbpack-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	indir := filepath.Join(dir, "in")
+	outdir := filepath.Join(dir, "out")
+	if err = os.Mkdir(indir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, indir, map[string]string{
+		"lmdbpack.json": `{"databases": [
+			{"name": "cities", "files": "cities*.csv", "format": "csv",
+			 "key": ["country", "city"], "value": ["population"]},
+			{"name": "codes", "files": "codes*.json", "format": "json",
+			 "key": ["code"], "keysep": "/", "dupsort": true}
+		]}`,
+		"cities1.csv": "country,city,population\nfr,paris,2100000\nde,berlin,3600000\n",
+		"cities2.csv": "city,country,population\nlyon,fr,520000\n",
+		"codes1.json": `[{"code": "a", "n": 1}, {"code": "b", "n": "two"}]`,
+		"codes2.json": "{\"code\": \"a\", \"n\": 3}\n{\"code\": \"a\", \"n\": 1}\n",
+	})
+	spec, err := readSpec(filepath.Join(indir, "lmdbpack.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts, err := pack(indir, outdir, spec, &Options{MapSize: 1 << 20, Batch: 2, Checksum: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts["cities"] != 3 || counts["codes"] != 3 {
+		t.Errorf("counts %v", counts)
+	}
+
+	env, err := lmdb.NewEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer env.Close()
+	if err = env.SetMaxDBs(2); err != nil {
+		t.Fatal(err)
+	}
+	if err = env.Open(outdir, lmdb.Readonly, 0644); err != nil {
+		t.Fatal(err)
+	}
+	dump := func(txn *lmdb.Txn, name string) string {
+		dbi, err := txn.OpenDBI(name, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cur, err := txn.OpenCursor(dbi)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer cur.Close()
+		var items []string
+		k, v, err := cur.Get(nil, nil, lmdb.First)
+		for ; err == nil; k, v, err = cur.Get(nil, nil, lmdb.Next) {
+			items = append(items, strings.Replace(string(k), "\x00", "|", -1)+"="+string(v))
+		}
+		if !lmdb.IsNotFound(err) {
+			t.Fatal(err)
+		}
+		return strings.Join(items, " ")
+	}
+	err = env.View(func(txn *lmdb.Txn) error {
+		if got := dump(txn, "cities"); got != "de|berlin=3600000 fr|lyon=520000 fr|paris=2100000" {
+			t.Errorf("cities %s", got)
+		}
+		if got := dump(txn, "codes"); got != `a={"code":"a","n":"1"} a={"code":"a","n":"3"} b={"code":"b","n":"two"}` {
+			t.Errorf("codes %s", got)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(outdir, "data.mdb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum, err := ioutil.ReadFile(filepath.Join(outdir, "data.mdb.sha256"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := sha256.Sum256(data)
+	if want := hex.EncodeToString(h[:]) + "  data.mdb\n"; string(sum) != want {
+		t.Errorf("checksum %q, want %q", sum, want)
+	}
+}
+
+func TestPack_duplicate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lmdbpack-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeFiles(t, dir, map[string]string{
+		"a.csv": "k,v\nx,1\ny,2\nx,3\n",
+	})
+	spec := &Spec{Databases: []DBSpec{{Name: "a", Files: "a.csv", Format: "csv", Key: []string{"k"}}}}
+	_, err = pack(dir, filepath.Join(dir, "out"), spec, &Options{MapSize: 1 << 20, Batch: 10})
+	if err == nil || !strings.Contains(err.Error(), `duplicate key "x"`) {
+		t.Errorf("duplicate key: %v", err)
+	}
+}
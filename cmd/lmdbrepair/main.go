@@ -0,0 +1,237 @@
+/*
+Command lmdbrepair salvages the readable contents of a damaged LMDB
+environment into a new environment.
+
+The source data file is read with the pure-Go reader of package lmdbpage, so
+files that LMDB refuses to open, or that would crash a process mapping them,
+can still be processed.  Every database (the main database and each named
+database) is walked from the root recorded in the newest valid meta page, and
+each readable item is written into the same database of the destination,
+which must not exist yet.  The source may be an environment directory or
+the data file of an environment created with NoSubdir.  Pages that cannot be
+read are skipped and reported on standard error along with the items lost
+under them, as are the items read but rejected by LMDB, such as keys of a
+damaged size.
+
+	lmdbrepair [-meta 0|1] [-mapsize bytes] [-batch items] src dst
+
+Items salvaged from damaged files may be incomplete.  Always verify the
+result before putting it back in service.
+*/
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"sort"
+
+	"github.com/glycerine/lmdb-go/int/lmdbcmd"
+	"github.com/glycerine/lmdb-go/lmdb"
+	"github.com/glycerine/lmdb-go/lmdbpage"
+)
+
+func main() {
+	opt := &Options{}
+	flag.IntVar(&opt.Meta, "meta", -1, "Use meta page 0 or 1 instead of the newest valid one.")
+	flag.Int64Var(&opt.MapSize, "mapsize", 0, "Map size of the destination (default: twice the source file size).")
+	flag.IntVar(&opt.Batch, "batch", 10000, "Number of items written per transaction.")
+	flag.Parse()
+
+	lmdbcmd.PrintVersion()
+
+	if flag.NArg() != 2 {
+		log.Fatalf("usage: lmdbrepair [flags] src dst")
+	}
+	report, err := repair(flag.Arg(0), flag.Arg(1), opt)
+	if report != nil {
+		report.print()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Options contain the command line options for an lmdbrepair command.
+type Options struct {
+	Meta    int
+	MapSize int64
+	Batch   int
+}
+
+// Report summarizes a salvage.
+type Report struct {
+	Meta     *lmdbpage.Meta
+	Items    map[string]int
+	Skipped  map[string]int
+	Rejected map[string]int
+	Failed   map[string]error
+}
+
+func (r *Report) print() {
+	fmt.Fprintf(os.Stderr, "used meta page %d (txnid %d)\n", r.Meta.Page, r.Meta.TxnID)
+	var names []string
+	for name := range r.Items {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		label := name
+		if label == "" {
+			label = "(main)"
+		}
+		fmt.Fprintf(os.Stderr, "%s: %d items salvaged, %d pages skipped, %d items rejected", label, r.Items[name], r.Skipped[name], r.Rejected[name])
+		if err := r.Failed[name]; err != nil {
+			fmt.Fprintf(os.Stderr, ", failed: %v", err)
+		}
+		fmt.Fprintln(os.Stderr)
+	}
+}
+
+func repair(srcpath, dstpath string, opt *Options) (*Report, error) {
+	src, err := lmdbpage.Open(srcpath)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	var meta *lmdbpage.Meta
+	switch opt.Meta {
+	case 0, 1:
+		meta = src.Metas()[opt.Meta]
+		if meta == nil || meta.Valid() != nil {
+			return nil, fmt.Errorf("meta page %d is not valid", opt.Meta)
+		}
+	default:
+		meta = src.Meta()
+		if meta == nil {
+			return nil, lmdbpage.ErrNotLMDB
+		}
+	}
+
+	w := src.NewWalker()
+	w.Skip = func(err error) {
+		log.Printf("skipped: %v", err)
+	}
+	dbs, err := w.Databases(meta.Main)
+	if err != nil {
+		return nil, err
+	}
+	dbs[""] = meta.Main
+
+	mapSize := opt.MapSize
+	if mapSize == 0 {
+		fi, err := os.Stat(srcpath)
+		if err != nil {
+			return nil, err
+		}
+		mapSize = 2 * fi.Size()
+		if fi.IsDir() {
+			mapSize = 2 * int64(src.NumPages()) * int64(src.PageSize())
+		}
+	}
+	err = os.Mkdir(dstpath, 0755)
+	if err != nil {
+		return nil, err
+	}
+	dst, err := lmdb.NewEnv()
+	if err != nil {
+		return nil, err
+	}
+	defer dst.Close()
+	err = dst.SetMaxDBs(len(dbs))
+	if err != nil {
+		return nil, err
+	}
+	err = dst.SetMapSize(mapSize)
+	if err != nil {
+		return nil, err
+	}
+	err = dst.Open(dstpath, 0, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{
+		Meta:     meta,
+		Items:    make(map[string]int),
+		Skipped:  make(map[string]int),
+		Rejected: make(map[string]int),
+		Failed:   make(map[string]error),
+	}
+	for name, db := range dbs {
+		w.Skipped = 0
+		n, rejected, err := salvage(dst, name, db, w, opt.Batch)
+		report.Items[name] = n
+		report.Skipped[name] = w.Skipped
+		report.Rejected[name] = rejected
+		if err != nil {
+			report.Failed[name] = err
+		}
+	}
+	return report, dst.Sync(true)
+}
+
+// dbFlags are the database flags preserved in the destination.  Their values
+// are shared by LMDB and package lmdbpage.
+const dbFlags = lmdbpage.ReverseKey | lmdbpage.DupSort | lmdbpage.IntegerKey | lmdbpage.DupFixed | lmdbpage.IntegerDup | lmdbpage.ReverseDup
+
+// salvage copies the readable items of db into the database called name in
+// dst, committing every batch items.  It returns the number of items copied
+// and the number of items rejected by LMDB.
+func salvage(dst *lmdb.Env, name string, db lmdbpage.DB, w *lmdbpage.Walker, batch int) (int, int, error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	if batch < 1 {
+		batch = 1
+	}
+	flags := uint(db.Flags) & dbFlags
+	var dbi lmdb.DBI
+	txn, err := dst.BeginTxn(nil, 0)
+	if err != nil {
+		return 0, 0, err
+	}
+	if name == "" {
+		dbi, err = txn.OpenRoot(flags)
+	} else {
+		dbi, err = txn.OpenDBI(name, flags|lmdb.Create)
+	}
+	if err != nil {
+		txn.Abort()
+		return 0, 0, err
+	}
+
+	var n, pending, rejected int
+	err = w.Walk(db, func(k, v []byte) error {
+		err := txn.Put(dbi, k, v, 0)
+		if err != nil {
+			// an item that LMDB rejects (e.g. a damaged key of bad size) is
+			// skipped like a damaged page.
+			log.Printf("%q: skipped item %q: %v", name, k, err)
+			rejected++
+			return nil
+		}
+		n++
+		pending++
+		if pending < batch {
+			return nil
+		}
+		err = txn.Commit()
+		if err != nil {
+			return err
+		}
+		pending = 0
+		txn, err = dst.BeginTxn(nil, 0)
+		return err
+	})
+	if err != nil {
+		if txn != nil {
+			txn.Abort()
+		}
+		return n - pending, rejected, err
+	}
+	return n, rejected, txn.Commit()
+}
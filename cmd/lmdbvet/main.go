@@ -0,0 +1,18 @@
+/*
+Command lmdbvet reports misuses of package lmdb in Go code, as described in
+package lmdbvet.  It is run by go vet:
+
+	go install github.com/glycerine/lmdb-go/cmd/lmdbvet
+	go vet -vettool=$(which lmdbvet) ./...
+*/
+package main
+
+import (
+	"golang.org/x/tools/go/analysis/unitchecker"
+
+	"github.com/glycerine/lmdb-go/lmdbvet"
+)
+
+func main() {
+	unitchecker.Main(lmdbvet.Analyzer)
+}
@@ -0,0 +1,284 @@
+/*
+Package lmdbschema versions the values of an LMDB database so their format
+can evolve without stop-the-world migrations.
+
+Every value written through a Store is an envelope: a one byte schema
+version followed by the payload encoded in that version.  When the payload
+format changes the application increments the current version of its Schema
+and registers a function upgrading payloads of the previous version.  Values
+written in older versions stay in place and are upgraded lazily, one version
+at a time, each time they are read, so the application only ever sees
+payloads of the current version.
+
+Upgrading on read cannot write to the database, because reads happen in
+read-only transactions.  Instead a Store remembers the values it upgraded
+and writes them back opportunistically the next time it writes in an update
+transaction, or when Flush is called, unless they were changed in the
+meantime.  A database converges to the current version as it is used, and
+an application wanting to finish a migration can read every key and Flush.
+
+Every value carries the version byte, so a database must be accessed
+exclusively through a Store (or decoded with Schema.Decode).
+*/
+package lmdbschema
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/glycerine/lmdb-go/lmdb"
+)
+
+// DefaultMaxPending is the number of upgraded values a Store remembers for
+// writing back when MaxPending is zero.
+const DefaultMaxPending = 1024
+
+// ErrCorrupt is returned when a value is not an envelope.
+var ErrCorrupt = errors.New("lmdbschema: value has no version")
+
+// ErrVersion is returned for a value that cannot be brought to the current
+// version, either because it was written by a newer version of the
+// application or because an upgrade is missing.  Such errors are of type
+// *VersionError and errors.Is(err, ErrVersion) is true.
+var ErrVersion = errors.New("lmdbschema: unsupported schema version")
+
+// VersionError describes a value whose version is not supported.
+type VersionError struct {
+	Version uint8 // version of the value
+	Current uint8 // current version of the schema
+}
+
+func (err *VersionError) Error() string {
+	if err.Version > err.Current {
+		return fmt.Sprintf("%v: version %d is newer than %d", ErrVersion, err.Version, err.Current)
+	}
+	return fmt.Sprintf("%v: no upgrade from version %d", ErrVersion, err.Version)
+}
+
+// Is allows errors.Is(err, ErrVersion) to match a *VersionError.
+func (err *VersionError) Is(target error) bool {
+	return target == ErrVersion
+}
+
+// UpgradeFunc converts a payload of a version to the next version.  It must
+// not modify or retain old.
+type UpgradeFunc func(old []byte) ([]byte, error)
+
+// Schema is the current version of a payload format and the upgrades from
+// its previous versions.  A Schema is configured before use and is then safe
+// for concurrent use.
+type Schema struct {
+	current  uint8
+	upgrades map[uint8]UpgradeFunc
+}
+
+// NewSchema returns a Schema whose current version is current.
+func NewSchema(current uint8) *Schema {
+	return &Schema{current: current, upgrades: make(map[uint8]UpgradeFunc)}
+}
+
+// Current returns the current version of s.
+func (s *Schema) Current() uint8 {
+	return s.current
+}
+
+// Register registers fn as the upgrade from version from to version from+1.
+// Register panics if from is not older than the current version or if an
+// upgrade is already registered for it.
+func (s *Schema) Register(from uint8, fn UpgradeFunc) {
+	if from >= s.current {
+		panic(fmt.Sprintf("lmdbschema: upgrade from version %d is not below current version %d", from, s.current))
+	}
+	if _, ok := s.upgrades[from]; ok {
+		panic(fmt.Sprintf("lmdbschema: upgrade from version %d registered twice", from))
+	}
+	s.upgrades[from] = fn
+}
+
+// Encode returns the envelope of payload in the current version.
+func (s *Schema) Encode(payload []byte) []byte {
+	v := make([]byte, len(payload)+1)
+	v[0] = s.current
+	copy(v[1:], payload)
+	return v
+}
+
+// Decode returns the payload of the envelope v in the current version,
+// applying the upgrades from the version of v in turn, and the version v was
+// written in.  If v is current the payload aliases v.
+func (s *Schema) Decode(v []byte) (payload []byte, version uint8, err error) {
+	if len(v) == 0 {
+		return nil, 0, ErrCorrupt
+	}
+	version, payload = v[0], v[1:]
+	if version > s.current {
+		return nil, version, &VersionError{Version: version, Current: s.current}
+	}
+	for from := version; from < s.current; from++ {
+		fn, ok := s.upgrades[from]
+		if !ok {
+			return nil, version, &VersionError{Version: from, Current: s.current}
+		}
+		payload, err = fn(payload)
+		if err != nil {
+			return nil, version, fmt.Errorf("lmdbschema: upgrade from version %d: %v", from, err)
+		}
+	}
+	return payload, version, nil
+}
+
+// Stage returns s as a codec stage, for databases whose values are accessed
+// through lmdb.Txn.GetDecoded and PutEncoded, see lmdb.Env.SetCodecs.
+// Values read in older versions are upgraded, but not written back as by a
+// Store.
+func (s *Schema) Stage() lmdb.CodecStage {
+	return lmdb.CodecStage{
+		Name: "lmdbschema",
+		Kind: lmdb.CodecEnvelope,
+		Encode: func(payload []byte) ([]byte, error) {
+			return s.Encode(payload), nil
+		},
+		Decode: func(v []byte) ([]byte, error) {
+			payload, _, err := s.Decode(v)
+			return payload, err
+		},
+	}
+}
+
+// Store reads and writes the versioned values of the database DBI, which
+// must not be DupSort.  A Store is safe for concurrent use.
+type Store struct {
+	Schema *Schema
+	DBI    lmdb.DBI
+
+	// MaxPending bounds the number of upgraded values remembered for
+	// writing back.  Upgrades beyond it are not written back, but are
+	// upgraded again on their next read.  If zero DefaultMaxPending is used.
+	MaxPending int
+
+	mu      sync.Mutex
+	pending map[string]pendingUpgrade
+	stats   Stats
+}
+
+type pendingUpgrade struct {
+	old []byte // envelope read
+	val []byte // envelope to write
+}
+
+// Stats counts the upgrades done by a Store.
+type Stats struct {
+	Upgraded  uint64 // values upgraded on read
+	Persisted uint64 // upgraded values written back
+	Stale     uint64 // upgraded values not written back because they changed
+	Pending   int    // upgraded values waiting to be written back
+}
+
+// New returns a Store for the values of dbi.
+func New(schema *Schema, dbi lmdb.DBI) *Store {
+	return &Store{Schema: schema, DBI: dbi}
+}
+
+// Get returns the payload of key in the current version.  If the stored
+// value is older it is upgraded, and remembered for writing back.
+func (s *Store) Get(txn *lmdb.Txn, key []byte) ([]byte, error) {
+	v, err := txn.Get(s.DBI, key)
+	if err != nil {
+		return nil, err
+	}
+	payload, version, err := s.Schema.Decode(v)
+	if err != nil {
+		return nil, err
+	}
+	if version != s.Schema.current {
+		s.remember(key, v, payload)
+	}
+	return payload, nil
+}
+
+func (s *Store) remember(key, old, payload []byte) {
+	max := s.MaxPending
+	if max <= 0 {
+		max = DefaultMaxPending
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats.Upgraded++
+	if s.pending == nil {
+		s.pending = make(map[string]pendingUpgrade)
+	}
+	if _, ok := s.pending[string(key)]; !ok && len(s.pending) >= max {
+		return
+	}
+	s.pending[string(key)] = pendingUpgrade{
+		old: append([]byte(nil), old...),
+		val: s.Schema.Encode(payload),
+	}
+}
+
+// Put stores payload, in the current version, under key, and writes back the
+// values upgraded since the last write.
+func (s *Store) Put(txn *lmdb.Txn, key, payload []byte, flags uint) error {
+	s.mu.Lock()
+	delete(s.pending, string(key))
+	s.mu.Unlock()
+	err := txn.Put(s.DBI, key, s.Schema.Encode(payload), flags)
+	if err != nil {
+		return err
+	}
+	return s.Flush(txn)
+}
+
+// Del deletes key, like lmdb.Txn.Del, and writes back the values upgraded
+// since the last write.
+func (s *Store) Del(txn *lmdb.Txn, key []byte) error {
+	s.mu.Lock()
+	delete(s.pending, string(key))
+	s.mu.Unlock()
+	err := txn.Del(s.DBI, key, nil)
+	if err != nil {
+		return err
+	}
+	return s.Flush(txn)
+}
+
+// Flush writes back, in the update transaction txn, the values upgraded
+// since the last write that still hold the value that was upgraded.  If txn
+// does not commit the upgrades are lost, and done again on the next read.
+func (s *Store) Flush(txn *lmdb.Txn) error {
+	s.mu.Lock()
+	pending := s.pending
+	s.pending = nil
+	s.mu.Unlock()
+	var persisted, stale uint64
+	for key, up := range pending {
+		v, err := txn.Get(s.DBI, []byte(key))
+		if lmdb.IsNotFound(err) || err == nil && string(v) != string(up.old) {
+			stale++
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		err = txn.Put(s.DBI, []byte(key), up.val, 0)
+		if err != nil {
+			return err
+		}
+		persisted++
+	}
+	s.mu.Lock()
+	s.stats.Persisted += persisted
+	s.stats.Stale += stale
+	s.mu.Unlock()
+	return nil
+}
+
+// Stats returns the upgrades done by s.
+func (s *Store) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := s.stats
+	stats.Pending = len(s.pending)
+	return stats
+}
@@ -0,0 +1,171 @@
+package lmdbschema
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/glycerine/lmdb-go/int/lmdbtest"
+	"github.com/glycerine/lmdb-go/lmdb"
+)
+
+// testSchema is at version 2: version 0 payloads are names, version 1
+// prefixes them with "name=", and version 2 appends ";".
+func testSchema() *Schema {
+	s := NewSchema(2)
+	s.Register(0, func(old []byte) ([]byte, error) {
+		return append([]byte("name="), old...), nil
+	})
+	s.Register(1, func(old []byte) ([]byte, error) {
+		return append(append([]byte(nil), old...), ';'), nil
+	})
+	return s
+}
+
+func TestSchema_Decode(t *testing.T) {
+	s := testSchema()
+	p, version, err := s.Decode([]byte("\x00bob"))
+	if err != nil || version != 0 || string(p) != "name=bob;" {
+		t.Errorf("decode: %q %d %v", p, version, err)
+	}
+	p, version, err = s.Decode(s.Encode([]byte("name=al;")))
+	if err != nil || version != 2 || string(p) != "name=al;" {
+		t.Errorf("decode: %q %d %v", p, version, err)
+	}
+	_, _, err = s.Decode([]byte("\x03x"))
+	var verr *VersionError
+	if !errors.Is(err, ErrVersion) || !errors.As(err, &verr) || verr.Version != 3 {
+		t.Errorf("unexpected error: %v", err)
+	}
+	_, _, err = NewSchema(1).Decode([]byte("\x00x"))
+	if !errors.Is(err, ErrVersion) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, _, err := s.Decode(nil); err != ErrCorrupt {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestStore(t *testing.T) {
+	env, err := lmdbtest.NewEnv(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lmdbtest.Destroy(env)
+	dbi, err := lmdbtest.OpenRoot(env, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// values written by older versions of the application.
+	err = env.Update(func(txn *lmdb.Txn) error {
+		for k, v := range map[string]string{"a": "\x00ann", "b": "\x01name=bo", "c": "\x00cy"} {
+			err := txn.Put(dbi, []byte(k), []byte(v), 0)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := New(testSchema(), dbi)
+	err = env.View(func(txn *lmdb.Txn) error {
+		for k, want := range map[string]string{"a": "name=ann;", "b": "name=bo;", "c": "name=cy;"} {
+			p, err := s.Get(txn, []byte(k))
+			if err != nil {
+				return err
+			}
+			if string(p) != want {
+				t.Errorf("%s: %q", k, p)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats := s.Stats(); stats.Upgraded != 3 || stats.Pending != 3 {
+		t.Errorf("stats: %+v", stats)
+	}
+
+	// the next write persists the upgrades, except for the value changed
+	// behind the store's back and the key written.
+	err = env.Update(func(txn *lmdb.Txn) error {
+		err := txn.Put(dbi, []byte("c"), []byte("\x01name=cyd"), 0)
+		if err != nil {
+			return err
+		}
+		return s.Put(txn, []byte("a"), []byte("name=anna;"), 0)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats := s.Stats(); stats.Persisted != 1 || stats.Stale != 1 || stats.Pending != 0 {
+		t.Errorf("stats: %+v", stats)
+	}
+	err = env.View(func(txn *lmdb.Txn) error {
+		for k, want := range map[string]string{"a": "\x02name=anna;", "b": "\x02name=bo;", "c": "\x01name=cyd"} {
+			v, err := txn.Get(dbi, []byte(k))
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(v, []byte(want)) {
+				t.Errorf("%s: %q", k, v)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSchema_Register(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("no panic")
+		}
+	}()
+	NewSchema(1).Register(1, nil)
+}
+
+func TestSchema_Stage(t *testing.T) {
+	env, err := lmdbtest.NewEnv(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lmdbtest.Destroy(env)
+	dbi, err := lmdbtest.OpenRoot(env, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := testSchema()
+	err = env.SetCodecs(dbi, s.Stage(), lmdb.ChecksumStage())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = env.Update(func(txn *lmdb.Txn) error {
+		err := txn.PutEncoded(dbi, []byte("a"), []byte("name=al;"), 0)
+		if err != nil {
+			return err
+		}
+		v, err := txn.Get(dbi, []byte("a"))
+		if err != nil {
+			return err
+		}
+		if v[0] != s.Current() || len(v) != 1+8+4 {
+			t.Errorf("stored %q", v)
+		}
+		p, err := txn.GetDecoded(dbi, []byte("a"))
+		if err != nil || string(p) != "name=al;" {
+			t.Errorf("decoded %q %v", p, err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,477 @@
+/*
+Package lmdbsession provides a session and token store with expiring entries
+on top of an LMDB environment, for web services that keep sessions in a local
+database instead of an external cache.
+
+Every entry has an expiration time.  Expired entries are invisible to Get and
+Touch immediately, and are physically removed by Store.Expire (or an Expirer
+running it in the background), which finds them through a secondary index
+ordered by expiration time rather than by scanning the whole store.
+
+Each value is stored prefixed with its 8 byte expiration time, so the
+databases of a Store must not be written by other means.  Store.Stats reports
+the expiration backlog and the work done by sweeps, for tuning the interval
+and batch size of an Expirer.
+*/
+package lmdbsession
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/glycerine/lmdb-go/lmdb"
+)
+
+const stampLen = 8
+
+// ErrCorrupt is returned when a stored value lacks its expiration time.
+var ErrCorrupt = errors.New("lmdbsession: value has no expiration time")
+
+// Store holds entries in Data and indexes their expiration times in Expiry.
+type Store struct {
+	Env    *lmdb.Env
+	Data   lmdb.DBI
+	Expiry lmdb.DBI
+
+	// Now returns the current time.  If Now is nil time.Now is used.
+	Now func() time.Time
+
+	mu    sync.Mutex
+	stats ExpiryStats
+}
+
+// ExpiryStats describes the expiration work of a Store.  The counters cover
+// the sweeps of Expire and SweepNow, including those of an Expirer, since
+// the Store was created.
+type ExpiryStats struct {
+	// Pending is the number of expired entries not yet removed.
+	Pending int
+
+	// Deleted is the number of expired entries removed.
+	Deleted int64
+
+	// Reindexed is the number of expiry index entries found outdated and
+	// moved to the current expiration time of their entry, or removed if
+	// the entry was missing.
+	Reindexed int64
+
+	// Sweeps is the number of sweeps, LastSweep the duration of the most
+	// recent one and SweepTime the total duration of all of them.
+	Sweeps    int64
+	LastSweep time.Duration
+	SweepTime time.Duration
+
+	// DeletionRate is the number of entries removed per second of
+	// sweeping, Deleted over SweepTime.
+	DeletionRate float64
+}
+
+// New returns a Store using the given databases.
+func New(env *lmdb.Env, data, expiry lmdb.DBI) *Store {
+	return &Store{Env: env, Data: data, Expiry: expiry}
+}
+
+// Open opens (creating them if necessary) the databases of the store called
+// name, name+".data" and name+".expiry", within the update txn.
+func Open(env *lmdb.Env, txn *lmdb.Txn, name string) (*Store, error) {
+	data, err := txn.OpenDBI(name+".data", lmdb.Create)
+	if err != nil {
+		return nil, err
+	}
+	expiry, err := txn.OpenDBI(name+".expiry", lmdb.Create)
+	if err != nil {
+		return nil, err
+	}
+	return New(env, data, expiry), nil
+}
+
+func (s *Store) now() time.Time {
+	if s.Now != nil {
+		return s.Now()
+	}
+	return time.Now()
+}
+
+func notFound(op string) error {
+	return &lmdb.OpError{Op: op, Errno: lmdb.NotFound}
+}
+
+// lookup returns the raw value of key and its expiration stamp, reporting
+// expired entries as lmdb.NotFound.
+func (s *Store) lookup(txn *lmdb.Txn, key []byte, op string) (val []byte, stamp uint64, err error) {
+	v, err := txn.Get(s.Data, key)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(v) < stampLen {
+		return nil, 0, ErrCorrupt
+	}
+	stamp = binary.BigEndian.Uint64(v)
+	if int64(stamp) <= s.now().UnixNano() {
+		return nil, stamp, notFound(op)
+	}
+	return v[stampLen:], stamp, nil
+}
+
+// Get returns the value of key.  Missing and expired entries are reported as
+// lmdb.NotFound.
+func (s *Store) Get(txn *lmdb.Txn, key []byte) ([]byte, error) {
+	val, _, err := s.lookup(txn, key, "lmdbsession.Get")
+	return val, err
+}
+
+// TTL returns the time remaining before key expires.
+func (s *Store) TTL(txn *lmdb.Txn, key []byte) (time.Duration, error) {
+	_, stamp, err := s.lookup(txn, key, "lmdbsession.TTL")
+	if err != nil {
+		return 0, err
+	}
+	return time.Unix(0, int64(stamp)).Sub(s.now()), nil
+}
+
+// Set stores val under key, expiring ttl from now.  Set replaces any
+// existing entry, expired or not.
+func (s *Store) Set(txn *lmdb.Txn, key, val []byte, ttl time.Duration) error {
+	err := s.unindex(txn, key)
+	if err != nil {
+		return err
+	}
+	stamp := uint64(s.now().Add(ttl).UnixNano())
+	v := make([]byte, stampLen+len(val))
+	binary.BigEndian.PutUint64(v, stamp)
+	copy(v[stampLen:], val)
+	err = txn.Put(s.Data, key, v, 0)
+	if err != nil {
+		return err
+	}
+	return txn.Put(s.Expiry, expiryKey(stamp, key), nil, 0)
+}
+
+// Touch extends the life of key to ttl from now, keeping its value.  Touch
+// returns lmdb.NotFound if key is missing or expired.
+func (s *Store) Touch(txn *lmdb.Txn, key []byte, ttl time.Duration) error {
+	val, old, err := s.lookup(txn, key, "lmdbsession.Touch")
+	if err != nil {
+		return err
+	}
+	stamp := uint64(s.now().Add(ttl).UnixNano())
+	v := make([]byte, stampLen+len(val))
+	binary.BigEndian.PutUint64(v, stamp)
+	copy(v[stampLen:], val)
+	err = txn.Put(s.Data, key, v, 0)
+	if err != nil {
+		return err
+	}
+	err = txn.Del(s.Expiry, expiryKey(old, key), nil)
+	if err != nil && !lmdb.IsNotFound(err) {
+		return err
+	}
+	return txn.Put(s.Expiry, expiryKey(stamp, key), nil, 0)
+}
+
+// Delete removes key.  Delete returns lmdb.NotFound if key is missing.
+func (s *Store) Delete(txn *lmdb.Txn, key []byte) error {
+	err := s.unindex(txn, key)
+	if err != nil {
+		return err
+	}
+	return txn.Del(s.Data, key, nil)
+}
+
+// unindex removes the expiry index entry of key, if key exists.
+func (s *Store) unindex(txn *lmdb.Txn, key []byte) error {
+	v, err := txn.Get(s.Data, key)
+	if lmdb.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(v) < stampLen {
+		return ErrCorrupt
+	}
+	err = txn.Del(s.Expiry, expiryKey(binary.BigEndian.Uint64(v), key), nil)
+	if lmdb.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+func expiryKey(stamp uint64, key []byte) []byte {
+	k := make([]byte, stampLen+len(key))
+	binary.BigEndian.PutUint64(k, stamp)
+	copy(k[stampLen:], key)
+	return k
+}
+
+// Expire deletes expired entries in a single update transaction, at most
+// limit of them (no limit if limit is not positive), and returns the number
+// deleted.
+//
+// An index entry whose expiration time no longer matches that of its entry,
+// e.g. one left by a crashed process rewriting the entry, is moved to the
+// current expiration time rather than expiring the entry early.  Moved index
+// entries count toward limit, so more entries may have expired even if the
+// result is below limit; SweepNow deletes them until none remains.
+func (s *Store) Expire(limit int) (n int, err error) {
+	start := time.Now()
+	n, reindexed, err := s.expire(limit)
+	if err != nil {
+		return 0, err
+	}
+	s.record(n, reindexed, time.Since(start))
+	return n, nil
+}
+
+// SweepNow deletes expired entries in transactions of at most batch of them
+// until none remain or limit have been deleted (no limit if limit is not
+// positive), and returns the number deleted.  Unlike Expire it may run
+// several transactions, keeping each of them short.
+func (s *Store) SweepNow(limit, batch int) (n int, err error) {
+	if batch <= 0 {
+		batch = 1000
+	}
+	start := time.Now()
+	var reindexed int
+	for limit <= 0 || n < limit {
+		b := batch
+		if limit > 0 && limit-n < b {
+			b = limit - n
+		}
+		d, r, err := s.expire(b)
+		n += d
+		reindexed += r
+		if err != nil {
+			s.record(n, reindexed, time.Since(start))
+			return n, err
+		}
+		if d+r < b {
+			break
+		}
+	}
+	s.record(n, reindexed, time.Since(start))
+	return n, nil
+}
+
+// expire processes at most limit expired index entries, returning the
+// number of entries deleted and of index entries moved.  A total below
+// limit means no expired index entry remains.
+func (s *Store) expire(limit int) (deleted, reindexed int, err error) {
+	now := uint64(s.now().UnixNano())
+	err = s.Env.Update(func(txn *lmdb.Txn) (err error) {
+		deleted, reindexed = 0, 0
+		cur, err := txn.OpenCursor(s.Expiry)
+		if err != nil {
+			return err
+		}
+		defer cur.Close()
+
+		for limit <= 0 || deleted+reindexed < limit {
+			k, _, err := cur.Get(nil, nil, lmdb.First)
+			if lmdb.IsNotFound(err) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if len(k) < stampLen {
+				return ErrCorrupt
+			}
+			stamp := binary.BigEndian.Uint64(k)
+			if stamp > now {
+				return nil
+			}
+			key := append([]byte(nil), k[stampLen:]...)
+			err = cur.Del(0)
+			if err != nil {
+				return err
+			}
+			v, err := txn.Get(s.Data, key)
+			if lmdb.IsNotFound(err) {
+				reindexed++
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if len(v) < stampLen {
+				return ErrCorrupt
+			}
+			if actual := binary.BigEndian.Uint64(v); actual != stamp {
+				err = txn.Put(s.Expiry, expiryKey(actual, key), nil, 0)
+				if err != nil {
+					return err
+				}
+				reindexed++
+				continue
+			}
+			err = txn.Del(s.Data, key, nil)
+			if err != nil {
+				return err
+			}
+			deleted++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return deleted, reindexed, nil
+}
+
+// record adds a sweep to the statistics of s.
+func (s *Store) record(deleted, reindexed int, d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats.Deleted += int64(deleted)
+	s.stats.Reindexed += int64(reindexed)
+	s.stats.Sweeps++
+	s.stats.LastSweep = d
+	s.stats.SweepTime += d
+}
+
+// Stats returns the expiration statistics of s, counting the expired
+// entries pending removal in a read transaction.
+func (s *Store) Stats() (ExpiryStats, error) {
+	s.mu.Lock()
+	stats := s.stats
+	s.mu.Unlock()
+	if stats.SweepTime > 0 {
+		stats.DeletionRate = float64(stats.Deleted) / stats.SweepTime.Seconds()
+	}
+
+	now := uint64(s.now().UnixNano())
+	err := s.Env.View(func(txn *lmdb.Txn) error {
+		txn.RawRead = true
+		cur, err := txn.OpenCursor(s.Expiry)
+		if err != nil {
+			return err
+		}
+		defer cur.Close()
+		for op := uint(lmdb.First); ; op = lmdb.Next {
+			k, _, err := cur.Get(nil, nil, op)
+			if lmdb.IsNotFound(err) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if len(k) < stampLen {
+				return ErrCorrupt
+			}
+			if binary.BigEndian.Uint64(k) > now {
+				return nil
+			}
+			stats.Pending++
+		}
+	})
+	return stats, err
+}
+
+// Reindex rebuilds the expiry index from the entries of the store, within
+// the update txn, and returns the number of entries indexed.  It compacts
+// an index holding entries left by a crash or by other writers, which
+// Expire would otherwise process one by one.
+func (s *Store) Reindex(txn *lmdb.Txn) (int, error) {
+	err := txn.Drop(s.Expiry, false)
+	if err != nil {
+		return 0, err
+	}
+	cur, err := txn.OpenCursor(s.Data)
+	if err != nil {
+		return 0, err
+	}
+	defer cur.Close()
+	n := 0
+	for op := uint(lmdb.First); ; op = lmdb.Next {
+		k, v, err := cur.Get(nil, nil, op)
+		if lmdb.IsNotFound(err) {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+		if len(v) < stampLen {
+			return n, ErrCorrupt
+		}
+		err = txn.Put(s.Expiry, expiryKey(binary.BigEndian.Uint64(v), k), nil, 0)
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+}
+
+// Expirer periodically removes expired entries from a Store.
+type Expirer struct {
+	stop chan struct{}
+	wg   sync.WaitGroup
+
+	mu  sync.Mutex
+	err error
+}
+
+// StartExpirer starts a goroutine that calls s.Expire(batch) every interval,
+// repeating immediately while full batches are being deleted.  The Expirer
+// stops when the environment is closed if Stop was not called before, and
+// reports through Err if the environment is already closed.
+func (s *Store) StartExpirer(interval time.Duration, batch int) *Expirer {
+	e := &Expirer{stop: make(chan struct{})}
+	e.wg.Add(1)
+	err := s.Env.Go("lmdbsession.expirer", func(ctx context.Context) {
+		defer e.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-e.stop:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			for {
+				start := time.Now()
+				n, reindexed, err := s.expire(batch)
+				if err != nil {
+					e.mu.Lock()
+					e.err = err
+					e.mu.Unlock()
+					break
+				}
+				s.record(n, reindexed, time.Since(start))
+				if batch <= 0 || n+reindexed < batch {
+					break
+				}
+				select {
+				case <-e.stop:
+					return
+				case <-ctx.Done():
+					return
+				default:
+				}
+			}
+		}
+	})
+	if err != nil {
+		e.wg.Done()
+		e.err = err
+	}
+	return e
+}
+
+// Err returns the error of the most recent failed expiration, if any.
+func (e *Expirer) Err() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.err
+}
+
+// Stop terminates the expirer and waits for a pass in progress to finish.
+func (e *Expirer) Stop() {
+	close(e.stop)
+	e.wg.Wait()
+}
@@ -0,0 +1,230 @@
+package lmdbsession
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/glycerine/lmdb-go/int/lmdbtest"
+	"github.com/glycerine/lmdb-go/lmdb"
+)
+
+func newStore(t *testing.T) *Store {
+	env, err := lmdbtest.NewEnv(&lmdbtest.EnvOptions{MaxDBs: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s *Store
+	err = env.Update(func(txn *lmdb.Txn) (err error) {
+		s, err = Open(env, txn, "sessions")
+		return err
+	})
+	if err != nil {
+		lmdbtest.Destroy(env)
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestStore(t *testing.T) {
+	s := newStore(t)
+	defer lmdbtest.Destroy(s.Env)
+
+	now := time.Unix(1000, 0)
+	s.Now = func() time.Time { return now }
+
+	err := s.Env.Update(func(txn *lmdb.Txn) (err error) {
+		err = s.Set(txn, []byte("s1"), []byte("alice"), time.Minute)
+		if err != nil {
+			return err
+		}
+		err = s.Set(txn, []byte("s2"), []byte("bob"), time.Hour)
+		if err != nil {
+			return err
+		}
+		// replacing an entry replaces its index entry.
+		err = s.Set(txn, []byte("s2"), []byte("bob"), 2*time.Minute)
+		if err != nil {
+			return err
+		}
+		err = s.Set(txn, []byte("s3"), []byte("carol"), time.Minute)
+		if err != nil {
+			return err
+		}
+		return s.Delete(txn, []byte("s3"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now = now.Add(90 * time.Second)
+	err = s.Env.Update(func(txn *lmdb.Txn) (err error) {
+		_, err = s.Get(txn, []byte("s1"))
+		if !lmdb.IsNotFound(err) {
+			t.Errorf("expired entry: %v", err)
+		}
+		err = s.Touch(txn, []byte("s1"), time.Hour)
+		if !lmdb.IsNotFound(err) {
+			t.Errorf("touch of expired entry: %v", err)
+		}
+		err = s.Touch(txn, []byte("s2"), time.Hour)
+		if err != nil {
+			return err
+		}
+		v, err := s.Get(txn, []byte("s2"))
+		if err != nil {
+			return err
+		}
+		if string(v) != "bob" {
+			t.Errorf("unexpected value: %q", v)
+		}
+		ttl, err := s.TTL(txn, []byte("s2"))
+		if err != nil {
+			return err
+		}
+		if ttl != time.Hour {
+			t.Errorf("unexpected ttl: %v", ttl)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := s.Expire(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("expired %d entries", n)
+	}
+
+	err = s.Env.View(func(txn *lmdb.Txn) (err error) {
+		for dbi, want := range map[lmdb.DBI]uint64{s.Data: 1, s.Expiry: 1} {
+			stat, err := txn.Stat(dbi)
+			if err != nil {
+				return err
+			}
+			if stat.Entries != want {
+				t.Errorf("dbi %d: %d entries", dbi, stat.Entries)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExpirer(t *testing.T) {
+	s := newStore(t)
+	defer lmdbtest.Destroy(s.Env)
+
+	err := s.Env.Update(func(txn *lmdb.Txn) (err error) {
+		for i := 0; i < 10; i++ {
+			err = s.Set(txn, []byte{byte(i)}, []byte("x"), -time.Second)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := s.StartExpirer(time.Millisecond, 3)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var entries uint64
+		err = s.Env.View(func(txn *lmdb.Txn) error {
+			stat, err := txn.Stat(s.Data)
+			if err != nil {
+				return err
+			}
+			entries = stat.Entries
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entries == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d entries remain", entries)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	e.Stop()
+	if e.Err() != nil {
+		t.Error(e.Err())
+	}
+}
+
+func TestStoreSweepNow(t *testing.T) {
+	s := newStore(t)
+	defer lmdbtest.Destroy(s.Env)
+
+	now := time.Unix(1000, 0)
+	s.Now = func() time.Time { return now }
+
+	err := s.Env.Update(func(txn *lmdb.Txn) (err error) {
+		for i := 0; i < 7; i++ {
+			ttl := time.Minute
+			if i >= 5 {
+				ttl = time.Hour
+			}
+			err = s.Set(txn, []byte{byte(i)}, []byte("x"), ttl)
+			if err != nil {
+				return err
+			}
+		}
+		// rewrite an entry without moving its index entry.
+		v := make([]byte, stampLen+1)
+		binary.BigEndian.PutUint64(v, uint64(now.Add(time.Hour).UnixNano()))
+		return txn.Put(s.Data, []byte{0}, v, 0)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now = now.Add(2 * time.Minute)
+	stats, err := s.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Pending != 5 || stats.Sweeps != 0 {
+		t.Errorf("stats before sweep: %+v", stats)
+	}
+
+	n, err := s.SweepNow(0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 4 {
+		t.Errorf("swept %d entries", n)
+	}
+	stats, err = s.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Pending != 0 || stats.Deleted != 4 || stats.Reindexed != 1 || stats.Sweeps != 1 {
+		t.Errorf("stats after sweep: %+v", stats)
+	}
+
+	err = s.Env.Update(func(txn *lmdb.Txn) (err error) {
+		_, err = s.Get(txn, []byte{0})
+		if err != nil {
+			t.Errorf("rewritten entry: %v", err)
+		}
+		n, err := s.Reindex(txn)
+		if n != 3 {
+			t.Errorf("reindexed %d entries", n)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -90,6 +90,30 @@ TxnOp.
 
 See mdb_txn_begin and MDB_MAP_RESIZED.
 
+Bounded resizing
+
+A resize cannot start until every running transaction has terminated.  While
+it waits, transactions begun through the Env queue behind it, and the channel
+returned by Env.ResizePending is closed so that long running transactions can
+return ErrTxnRetry early and be rerun after the resize.  Setting
+Env.ResizeTimeout makes a resize that still cannot start give up with
+ErrResizeTimeout instead of waiting indefinitely.
+
+	err := env.SphynxReader(func(txn *lmdb.Txn, slot int) error {
+		pending := env.ResizePending()
+		s := lmdbscan.New(txn, dbi)
+		defer s.Close()
+		for s.Scan() {
+			select {
+			case <-pending:
+				return lmdbsync.ErrTxnRetry
+			default:
+			}
+			// ...
+		}
+		return s.Err()
+	})
+
 NoLock
 
 When the lmdb.NoLock flag is set on an environment Env handles all transaction
@@ -133,9 +157,16 @@ import (
 type Env struct {
 	*lmdb.Env
 	Handlers HandlerChain
-	ctx      context.Context
-	noLock   bool
-	txnlock  sync.RWMutex
+
+	// ResizeTimeout bounds the time SetMapSize waits for running
+	// transactions to terminate before failing with ErrResizeTimeout.  Zero
+	// waits indefinitely.
+	ResizeTimeout time.Duration
+
+	ctx     context.Context
+	noLock  bool
+	txnlock sync.RWMutex
+	gate    resizeGate
 }
 
 // NewEnv returns an newly allocated Env that wraps env.  If env is nil then
@@ -163,6 +194,7 @@ func NewEnv(env *lmdb.Env, h ...Handler) (*Env, error) {
 		noLock:   noLock,
 		ctx:      context.Background(),
 	}
+	_env.gate.init()
 	return _env, nil
 }
 
@@ -183,12 +215,21 @@ func (r *Env) Open(path string, flags uint, mode os.FileMode) error {
 }
 
 // SetMapSize is a proxy for r.Env.SetMapSize() that blocks while concurrent
-// transactions are in progress.
+// transactions are in progress.  Transactions begun while SetMapSize waits
+// are held back until the map has been resized, and running transactions can
+// yield by watching r.ResizePending.  If r.ResizeTimeout is set SetMapSize
+// gives up with ErrResizeTimeout when the running transactions do not
+// terminate in time.
 func (r *Env) SetMapSize(size int64) error {
 	return r.setMapSize(size, 0)
 }
 
 func (r *Env) setMapSize(size int64, delay time.Duration) error {
+	err := r.gate.begin(r.ResizeTimeout)
+	if err != nil {
+		return err
+	}
+	defer r.gate.end()
 	r.txnlock.Lock()
 	if delay > 0 {
 		// wait before adopting a map size set from another process. hold on to
@@ -196,7 +237,7 @@ func (r *Env) setMapSize(size int64, delay time.Duration) error {
 		// begin while waiting.
 		time.Sleep(delay)
 	}
-	err := r.Env.SetMapSize(size)
+	err = r.Env.SetMapSize(size)
 	r.txnlock.Unlock()
 	return err
 }
@@ -242,6 +283,12 @@ func (r *Env) UpdateLocked(op lmdb.TxnOp) error {
 	return r.runHandler(false, func() error { return r.Env.UpdateLocked(op) }, r.Handlers)
 }
 
+// SphynxReader is a proxy for r.Env.SphynxReader() that takes part in map
+// resizing like View.
+func (r *Env) SphynxReader(srf lmdb.SphynxReadFunc) error {
+	return r.runHandler(true, func() error { return r.Env.SphynxReader(srf) }, r.Handlers)
+}
+
 // WithHandler returns a TxnRunner than handles transaction errors r.Handlers
 // chained with h.
 func (r *Env) WithHandler(h Handler) TxnRunner {
@@ -262,6 +309,8 @@ func (r *Env) runHandler(readonly bool, fn func() error, h Handler) error {
 	}
 }
 func (r *Env) run(readonly bool, fn func() error) error {
+	r.gate.enter()
+	defer r.gate.exit()
 	var err error
 	if r.noLock && !readonly {
 		r.txnlock.Lock()
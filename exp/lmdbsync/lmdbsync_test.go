@@ -548,3 +548,96 @@ func TestEnv_WithHandler_RunTxn(t *testing.T) {
 		t.Errorf("handler was not called")
 	}
 }
+
+func TestEnv_SetMapSize_yield(t *testing.T) {
+	env, err := newEnv(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lmdbtest.Destroy(env.Env)
+	env.ResizeTimeout = 5 * time.Second
+
+	txnopen := make(chan struct{}, 1)
+	errc := make(chan error, 1)
+	var attempts int
+	go func() {
+		// the first attempt runs until the resize asks it to yield.
+		errc <- env.View(func(txn *lmdb.Txn) (err error) {
+			attempts++
+			if attempts > 1 {
+				return nil
+			}
+			pending := env.ResizePending()
+			txnopen <- struct{}{}
+			<-pending
+			return ErrTxnRetry
+		})
+	}()
+
+	<-txnopen
+	err = env.SetMapSize(10 << 20)
+	if err != nil {
+		t.Error(err)
+	}
+	err = <-errc
+	if err != nil {
+		t.Error(err)
+	}
+	if attempts != 2 {
+		t.Errorf("view ran %d times", attempts)
+	}
+}
+
+func TestEnv_SetMapSize_timeout(t *testing.T) {
+	env, err := newEnv(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lmdbtest.Destroy(env.Env)
+	env.ResizeTimeout = 50 * time.Millisecond
+
+	info, err := env.Info()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	txnopen := make(chan struct{})
+	release := make(chan struct{})
+	errc := make(chan error, 1)
+	go func() {
+		// a transaction ignoring ResizePending holds up the resize.
+		errc <- env.View(func(txn *lmdb.Txn) (err error) {
+			txnopen <- struct{}{}
+			<-release
+			return nil
+		})
+	}()
+	<-txnopen
+
+	queued := make(chan struct{})
+	go func() {
+		<-env.ResizePending()
+		// transactions begun while the resize waits are held back.
+		env.View(func(txn *lmdb.Txn) error { return nil })
+		close(queued)
+	}()
+
+	err = env.SetMapSize(info.MapSize * 2)
+	if err != ErrResizeTimeout {
+		t.Errorf("unexpected error: %v", err)
+	}
+	<-queued
+	close(release)
+	err = <-errc
+	if err != nil {
+		t.Error(err)
+	}
+
+	info2, err := env.Info()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info2.MapSize != info.MapSize {
+		t.Errorf("map size changed: %d", info2.MapSize)
+	}
+}
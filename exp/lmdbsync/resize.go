@@ -0,0 +1,105 @@
+package lmdbsync
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrResizeTimeout is returned by Env.SetMapSize, and by the Handlers that
+// resize the map, when running transactions did not terminate within
+// Env.ResizeTimeout.  The map size is left unchanged.
+var ErrResizeTimeout = errors.New("lmdbsync: timed out waiting for transactions to resize the map")
+
+// resizeGate counts the transactions run by an Env and holds back new ones
+// while a resize waits for the running ones to terminate.
+type resizeGate struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	active   int
+	resizing bool
+	pending  chan struct{} // closed while resizing
+}
+
+func (g *resizeGate) init() {
+	g.cond = sync.NewCond(&g.mu)
+	g.pending = make(chan struct{})
+}
+
+// enter blocks while a resize is in progress and then registers a running
+// transaction.
+func (g *resizeGate) enter() {
+	g.mu.Lock()
+	for g.resizing {
+		g.cond.Wait()
+	}
+	g.active++
+	g.mu.Unlock()
+}
+
+func (g *resizeGate) exit() {
+	g.mu.Lock()
+	g.active--
+	if g.active == 0 {
+		g.cond.Broadcast()
+	}
+	g.mu.Unlock()
+}
+
+// begin starts a resize, signals running transactions to yield, and waits
+// for them to terminate.  A timeout of zero waits indefinitely.  If begin
+// returns nil the caller must call end.
+func (g *resizeGate) begin(timeout time.Duration) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for g.resizing {
+		g.cond.Wait()
+	}
+	g.resizing = true
+	close(g.pending)
+
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+		t := time.AfterFunc(timeout, func() {
+			g.mu.Lock()
+			g.cond.Broadcast()
+			g.mu.Unlock()
+		})
+		defer t.Stop()
+	}
+	for g.active > 0 {
+		if !deadline.IsZero() && !time.Now().Before(deadline) {
+			g.finish()
+			return ErrResizeTimeout
+		}
+		g.cond.Wait()
+	}
+	return nil
+}
+
+// end completes a resize started by begin and releases waiting
+// transactions.
+func (g *resizeGate) end() {
+	g.mu.Lock()
+	g.finish()
+	g.mu.Unlock()
+}
+
+func (g *resizeGate) finish() {
+	g.resizing = false
+	g.pending = make(chan struct{})
+	g.cond.Broadcast()
+}
+
+// ResizePending returns a channel that is closed when a call to SetMapSize,
+// possibly made by a Handler, is waiting for running transactions to
+// terminate.  New transactions queue until the resize completes.  Long
+// running transactions, such as scans run with SphynxReader, should select on
+// the channel and return ErrTxnRetry when it closes, which makes the Env rerun
+// them once the map has been resized.
+func (r *Env) ResizePending() <-chan struct{} {
+	r.gate.mu.Lock()
+	defer r.gate.mu.Unlock()
+	return r.gate.pending
+}
@@ -0,0 +1,286 @@
+/*
+Package lmdbtomb provides an opt-in soft-delete layer for an LMDB database.
+Deleting a key through a Store does not remove it but overwrites its value
+with a tombstone that records the time of deletion.  Change data capture
+consumers, replicas and watchers that read the database directly therefore
+observe deletions as ordinary writes instead of missing them.
+
+Tombstones are physically removed by Store.Purge once they are older than a
+retention window, in transactions that each remove a bounded number of keys so
+that purging never holds the write lock for long.  A Purger runs Purge in the
+background.
+
+Every value written by a Store carries a one byte header, so a database must
+be accessed exclusively through a Store (or decoded with Decode).  A second
+database, the index, orders tombstones by deletion time so Purge does not have
+to scan the whole database.
+*/
+package lmdbtomb
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/glycerine/lmdb-go/lmdb"
+)
+
+const (
+	headerLive = 0
+	headerTomb = 1
+
+	// tombLen is the length of an encoded tombstone: header and deletion
+	// time in nanoseconds.
+	tombLen = 1 + 8
+)
+
+// ErrCorrupt is returned when a value does not carry a valid header.
+var ErrCorrupt = errors.New("lmdbtomb: value has no valid header")
+
+// Store implements soft deletion for the database DBI.  Index must be a
+// separate database used only by the Store.
+type Store struct {
+	Env   *lmdb.Env
+	DBI   lmdb.DBI
+	Index lmdb.DBI
+
+	// Now returns the current time.  If Now is nil time.Now is used.
+	Now func() time.Time
+}
+
+// New returns a Store holding its values in dbi and its tombstone index in
+// index.
+func New(env *lmdb.Env, dbi, index lmdb.DBI) *Store {
+	return &Store{Env: env, DBI: dbi, Index: index}
+}
+
+func (s *Store) now() time.Time {
+	if s.Now != nil {
+		return s.Now()
+	}
+	return time.Now()
+}
+
+// Decode interprets a raw value written by a Store.  If v is a tombstone
+// Decode returns the time of deletion and tomb is true.  Otherwise val is the
+// live value, which aliases v.
+func Decode(v []byte) (val []byte, deleted time.Time, tomb bool, err error) {
+	if len(v) == 0 {
+		return nil, time.Time{}, false, ErrCorrupt
+	}
+	switch v[0] {
+	case headerLive:
+		return v[1:], time.Time{}, false, nil
+	case headerTomb:
+		if len(v) != tombLen {
+			return nil, time.Time{}, false, ErrCorrupt
+		}
+		return nil, time.Unix(0, int64(binary.BigEndian.Uint64(v[1:]))), true, nil
+	}
+	return nil, time.Time{}, false, ErrCorrupt
+}
+
+// Put stores val under key, replacing a live value or tombstone.
+func (s *Store) Put(txn *lmdb.Txn, key, val []byte, flags uint) error {
+	p := make([]byte, len(val)+1)
+	p[0] = headerLive
+	copy(p[1:], val)
+	return txn.Put(s.DBI, key, p, flags)
+}
+
+// Get returns the live value of key.  A key that was deleted is reported as
+// lmdb.NotFound, just like a key that never existed.
+func (s *Store) Get(txn *lmdb.Txn, key []byte) ([]byte, error) {
+	v, err := txn.Get(s.DBI, key)
+	if err != nil {
+		return nil, err
+	}
+	val, _, tomb, err := Decode(v)
+	if err != nil {
+		return nil, err
+	}
+	if tomb {
+		return nil, &lmdb.OpError{Op: "lmdbtomb.Get", Errno: lmdb.NotFound}
+	}
+	return val, nil
+}
+
+// Deleted reports whether key holds a tombstone and when it was written.
+func (s *Store) Deleted(txn *lmdb.Txn, key []byte) (time.Time, bool, error) {
+	v, err := txn.Get(s.DBI, key)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	_, deleted, tomb, err := Decode(v)
+	return deleted, tomb, err
+}
+
+// Del replaces the value of key with a tombstone.  Like lmdb.Txn.Del, Del
+// returns lmdb.NotFound if key has no live value.
+func (s *Store) Del(txn *lmdb.Txn, key []byte) error {
+	v, err := txn.Get(s.DBI, key)
+	if err != nil {
+		return err
+	}
+	_, _, tomb, err := Decode(v)
+	if err != nil {
+		return err
+	}
+	if tomb {
+		return &lmdb.OpError{Op: "lmdbtomb.Del", Errno: lmdb.NotFound}
+	}
+
+	var t [tombLen]byte
+	t[0] = headerTomb
+	binary.BigEndian.PutUint64(t[1:], uint64(s.now().UnixNano()))
+	err = txn.Put(s.DBI, key, t[:], 0)
+	if err != nil {
+		return err
+	}
+	return txn.Put(s.Index, indexKey(t[1:], key), nil, 0)
+}
+
+// indexKey returns the index key of a tombstone: the big-endian deletion
+// time followed by the key, so that the index is ordered by age.
+func indexKey(stamp, key []byte) []byte {
+	ik := make([]byte, 0, len(stamp)+len(key))
+	ik = append(ik, stamp...)
+	return append(ik, key...)
+}
+
+// Purge removes tombstones deleted more than retention ago in a single
+// update transaction, removing at most limit keys (no limit if limit is not
+// positive).  Purge returns the number of index entries processed; a result
+// equal to limit means more tombstones may be eligible.
+//
+// Index entries whose key was written again after deletion are discarded
+// without touching the key.
+func (s *Store) Purge(retention time.Duration, limit int) (n int, err error) {
+	var cutoff [8]byte
+	binary.BigEndian.PutUint64(cutoff[:], uint64(s.now().Add(-retention).UnixNano()))
+
+	err = s.Env.Update(func(txn *lmdb.Txn) (err error) {
+		n = 0
+		cur, err := txn.OpenCursor(s.Index)
+		if err != nil {
+			return err
+		}
+		defer cur.Close()
+
+		for limit <= 0 || n < limit {
+			ik, _, err := cur.Get(nil, nil, lmdb.First)
+			if lmdb.IsNotFound(err) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if len(ik) < 8 {
+				return ErrCorrupt
+			}
+			if string(ik[:8]) >= string(cutoff[:]) {
+				return nil
+			}
+			err = s.purgeKey(txn, ik[:8], ik[8:])
+			if err != nil {
+				return err
+			}
+			err = cur.Del(0)
+			if err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// purgeKey deletes key if it still holds the tombstone written at stamp.
+func (s *Store) purgeKey(txn *lmdb.Txn, stamp, key []byte) error {
+	v, err := txn.Get(s.DBI, key)
+	if lmdb.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(v) != tombLen || v[0] != headerTomb || string(v[1:]) != string(stamp) {
+		return nil
+	}
+	return txn.Del(s.DBI, key, nil)
+}
+
+// Purger periodically purges expired tombstones from a Store.
+type Purger struct {
+	stop chan struct{}
+	wg   sync.WaitGroup
+
+	mu  sync.Mutex
+	err error
+}
+
+// StartPurger starts a goroutine that calls s.Purge(retention, batch) every
+// interval, repeating immediately while full batches are being purged.  The
+// Purger stops when the environment is closed if Stop was not called before,
+// and reports through Err if the environment is already closed.
+func (s *Store) StartPurger(retention, interval time.Duration, batch int) *Purger {
+	p := &Purger{stop: make(chan struct{})}
+	p.wg.Add(1)
+	err := s.Env.Go("lmdbtomb.purger", func(ctx context.Context) {
+		defer p.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.stop:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			for {
+				n, err := s.Purge(retention, batch)
+				if err != nil {
+					p.mu.Lock()
+					p.err = err
+					p.mu.Unlock()
+					break
+				}
+				if batch <= 0 || n < batch {
+					break
+				}
+				select {
+				case <-p.stop:
+					return
+				case <-ctx.Done():
+					return
+				default:
+				}
+			}
+		}
+	})
+	if err != nil {
+		p.wg.Done()
+		p.err = err
+	}
+	return p
+}
+
+// Err returns the error of the most recent failed purge, if any.
+func (p *Purger) Err() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+// Stop terminates the purger and waits for a purge in progress to finish.
+func (p *Purger) Stop() {
+	close(p.stop)
+	p.wg.Wait()
+}
@@ -0,0 +1,200 @@
+package lmdbtomb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/glycerine/lmdb-go/int/lmdbtest"
+	"github.com/glycerine/lmdb-go/lmdb"
+)
+
+func newStore(t *testing.T) *Store {
+	env, err := lmdbtest.NewEnv(&lmdbtest.EnvOptions{MaxDBs: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbi, err := lmdbtest.OpenDBI(env, "data", lmdb.Create)
+	if err != nil {
+		lmdbtest.Destroy(env)
+		t.Fatal(err)
+	}
+	index, err := lmdbtest.OpenDBI(env, "tombs", lmdb.Create)
+	if err != nil {
+		lmdbtest.Destroy(env)
+		t.Fatal(err)
+	}
+	return New(env, dbi, index)
+}
+
+func TestStore(t *testing.T) {
+	s := newStore(t)
+	defer lmdbtest.Destroy(s.Env)
+
+	now := time.Unix(1000, 0)
+	s.Now = func() time.Time { return now }
+
+	err := s.Env.Update(func(txn *lmdb.Txn) (err error) {
+		for _, k := range []string{"a", "b", "c"} {
+			err = s.Put(txn, []byte(k), []byte("v"+k), 0)
+			if err != nil {
+				return err
+			}
+		}
+		err = s.Del(txn, []byte("a"))
+		if err != nil {
+			return err
+		}
+		err = s.Del(txn, []byte("a"))
+		if !lmdb.IsNotFound(err) {
+			t.Errorf("second delete: %v", err)
+		}
+		now = now.Add(time.Minute)
+		err = s.Del(txn, []byte("b"))
+		if err != nil {
+			return err
+		}
+		// c is deleted and then resurrected; its index entry is stale.
+		err = s.Del(txn, []byte("c"))
+		if err != nil {
+			return err
+		}
+		return s.Put(txn, []byte("c"), []byte("vc2"), 0)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.Env.View(func(txn *lmdb.Txn) (err error) {
+		_, err = s.Get(txn, []byte("a"))
+		if !lmdb.IsNotFound(err) {
+			t.Errorf("get deleted key: %v", err)
+		}
+		// the tombstone remains visible to raw readers.
+		v, err := txn.Get(s.DBI, []byte("a"))
+		if err != nil {
+			return err
+		}
+		_, deleted, tomb, err := Decode(v)
+		if err != nil {
+			return err
+		}
+		if !tomb || !deleted.Equal(time.Unix(1000, 0)) {
+			t.Errorf("unexpected tombstone: %v %v", tomb, deleted)
+		}
+		v, err = s.Get(txn, []byte("c"))
+		if err != nil {
+			return err
+		}
+		if string(v) != "vc2" {
+			t.Errorf("unexpected value of c: %q", v)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// only the tombstone of a is older than the retention window.
+	now = now.Add(30 * time.Second)
+	n, err := s.Purge(time.Minute, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("purged %d entries", n)
+	}
+
+	now = now.Add(time.Hour)
+	n, err = s.Purge(time.Minute, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("purged %d entries with limit 1", n)
+	}
+	n, err = s.Purge(time.Minute, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("purged %d entries", n)
+	}
+
+	err = s.Env.View(func(txn *lmdb.Txn) (err error) {
+		for _, k := range []string{"a", "b"} {
+			_, err = txn.Get(s.DBI, []byte(k))
+			if !lmdb.IsNotFound(err) {
+				t.Errorf("key %q not purged: %v", k, err)
+			}
+		}
+		v, err := s.Get(txn, []byte("c"))
+		if err != nil {
+			return err
+		}
+		if string(v) != "vc2" {
+			t.Errorf("unexpected value of c: %q", v)
+		}
+		stat, err := txn.Stat(s.Index)
+		if err != nil {
+			return err
+		}
+		if stat.Entries != 0 {
+			t.Errorf("%d index entries remain", stat.Entries)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPurger(t *testing.T) {
+	s := newStore(t)
+	defer lmdbtest.Destroy(s.Env)
+
+	err := s.Env.Update(func(txn *lmdb.Txn) (err error) {
+		for i := 0; i < 10; i++ {
+			k := []byte{byte(i)}
+			err = s.Put(txn, k, k, 0)
+			if err != nil {
+				return err
+			}
+			err = s.Del(txn, k)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := s.StartPurger(0, time.Millisecond, 3)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var entries uint64
+		err = s.Env.View(func(txn *lmdb.Txn) error {
+			stat, err := txn.Stat(s.DBI)
+			if err != nil {
+				return err
+			}
+			entries = stat.Entries
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entries == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d entries remain", entries)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	p.Stop()
+	if p.Err() != nil {
+		t.Error(p.Err())
+	}
+}
@@ -7,4 +7,5 @@ require (
 	github.com/glycerine/idem v0.0.0-20190127113923-7a8083893311
 	github.com/gopherjs/gopherjs v0.0.0-20200217142428-fce0ec30dd00 // indirect
 	github.com/jtolds/gls v4.20.0+incompatible // indirect
+	golang.org/x/tools v0.1.12
 )
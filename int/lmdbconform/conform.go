@@ -0,0 +1,491 @@
+/*
+Package lmdbconform is a conformance suite for the backends reading and
+writing LMDB environments: the cgo bindings of package lmdb, the pure-Go
+reader of package lmdbpage, and alternate implementations to come.  Each
+backend is adapted to the small Env, Txn and Cursor interfaces below and
+validated by Run against identical behavioral expectations, so that a
+backend, or a refactoring of one, can be checked against the others.
+
+Run writes a fixed dataset with package lmdb, the reference, and opens it
+with the backend under test.  Read expectations apply to every backend;
+write expectations are skipped for backends whose Update returns
+ErrReadOnly.
+*/
+package lmdbconform
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"testing"
+
+	"github.com/glycerine/lmdb-go/lmdb"
+)
+
+// Errors backends report for the conditions the suite checks.  Backends
+// translate their own errors so that errors.Is matches these.
+var (
+	ErrNotFound = errors.New("lmdbconform: not found")
+	ErrKeyExist = errors.New("lmdbconform: key exists")
+	ErrReadOnly = errors.New("lmdbconform: backend is read-only")
+)
+
+// Op is a cursor operation, with the semantics of the lmdb operation of the
+// same name.
+type Op int
+
+// Cursor operations.
+const (
+	First Op = iota
+	Last
+	Next
+	Prev
+	NextDup
+	PrevDup
+	NextNoDup
+	PrevNoDup
+	FirstDup
+	LastDup
+	SetKey
+	SetRange
+	GetBoth
+	GetBothRange
+	GetCurrent
+)
+
+var opNames = [...]string{"First", "Last", "Next", "Prev", "NextDup", "PrevDup", "NextNoDup", "PrevNoDup", "FirstDup", "LastDup", "SetKey", "SetRange", "GetBoth", "GetBothRange", "GetCurrent"}
+
+func (op Op) String() string {
+	if op < 0 || int(op) >= len(opNames) {
+		return fmt.Sprintf("Op(%d)", int(op))
+	}
+	return opNames[op]
+}
+
+// Put flags, with the semantics of the lmdb flags of the same names.
+const (
+	NoOverwrite uint = 1 << iota
+	NoDupData
+)
+
+// MaxDBs is the number of named databases backends must be able to open.
+const MaxDBs = 16
+
+// Env is an environment opened by a backend.
+type Env interface {
+	// View runs fn in a read-only transaction.
+	View(fn func(txn Txn) error) error
+
+	// Update runs fn in a write transaction, committed if fn returns nil
+	// and aborted otherwise.  Read-only backends return ErrReadOnly.
+	Update(fn func(txn Txn) error) error
+
+	Close() error
+}
+
+// Txn is a transaction of a backend.  Databases are named, the names being
+// those of the named databases of the environment.
+type Txn interface {
+	// Databases returns the names of the named databases, sorted.
+	Databases() ([]string, error)
+
+	Get(db string, key []byte) ([]byte, error)
+	Put(db string, key, val []byte, flags uint) error
+	Del(db string, key, val []byte) error
+	OpenCursor(db string) (Cursor, error)
+}
+
+// Cursor is a cursor of a backend.
+type Cursor interface {
+	Get(key, val []byte, op Op) ([]byte, []byte, error)
+	Close()
+}
+
+// Opener opens the environment at path, a directory holding an
+// environment written by the suite, with a backend.
+type Opener func(path string) (Env, error)
+
+// dbSpec describes a database of the dataset.
+type dbSpec struct {
+	name  string
+	flags uint
+	items []item // in database order
+}
+
+type item struct {
+	key, val []byte
+}
+
+// dataset returns the databases written by the suite.  Items are listed in
+// the order LMDB stores them.
+func dataset() []dbSpec {
+	var plain, big, fixed []item
+	for i := 0; i < 500; i++ {
+		plain = append(plain, item{[]byte(fmt.Sprintf("k%03d", 2*i)), []byte(fmt.Sprintf("v%03d", 2*i))})
+	}
+	for i := 0; i < 3; i++ {
+		// values larger than a page go to overflow pages.
+		big = append(big, item{[]byte{byte('a' + i)}, bytes.Repeat([]byte{byte('0' + i)}, 10000+i)})
+	}
+	for i := 0; i < 300; i++ {
+		v := make([]byte, 4)
+		binary.BigEndian.PutUint32(v, uint32(i*3))
+		fixed = append(fixed, item{[]byte("f"), v})
+	}
+	fixed = append(fixed, item{[]byte("g"), []byte("\x00\x00\x00\x01")}, item{[]byte("g"), []byte("\x00\x00\x00\x02")})
+	return []dbSpec{
+		{name: "big", items: big},
+		{name: "dups", flags: lmdb.DupSort, items: []item{
+			{[]byte("a"), []byte("1")}, {[]byte("a"), []byte("2")}, {[]byte("a"), []byte("3")},
+			{[]byte("b"), []byte("x")},
+			{[]byte("c"), []byte("p")}, {[]byte("c"), []byte("q")},
+		}},
+		{name: "empty"},
+		{name: "fixed", flags: lmdb.DupSort | lmdb.DupFixed, items: fixed},
+		{name: "plain", items: plain},
+		// keys compared from their last byte.
+		{name: "reverse", flags: lmdb.ReverseKey, items: []item{
+			{[]byte("xa"), []byte("1")}, {[]byte("yb"), []byte("2")}, {[]byte("zb"), []byte("3")}, {[]byte("ac"), []byte("4")},
+		}},
+		{name: "scratch"},
+		{name: "scratchdups", flags: lmdb.DupSort},
+	}
+}
+
+// write writes the dataset to a new environment at path with package lmdb.
+func write(path string) error {
+	env, err := lmdb.OpenEnv(path, &lmdb.Options{MaxDBs: MaxDBs, MapSize: 64 << 20})
+	if err != nil {
+		return err
+	}
+	defer env.Close()
+	return env.Update(func(txn *lmdb.Txn) error {
+		for _, db := range dataset() {
+			dbi, err := txn.OpenDBI(db.name, db.flags|lmdb.Create)
+			if err != nil {
+				return err
+			}
+			for _, it := range db.items {
+				err = txn.Put(dbi, it.key, it.val, 0)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// Run validates the backend opening environments with open.
+func Run(t *testing.T, open Opener) {
+	dir, err := ioutil.TempDir("", "lmdbconform")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	err = write(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env, err := open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer env.Close()
+
+	view := func(t *testing.T, fn func(txn Txn) error) {
+		err := env.View(fn)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Run("Databases", func(t *testing.T) {
+		view(t, func(txn Txn) error { return testDatabases(t, txn) })
+	})
+	t.Run("Scan", func(t *testing.T) {
+		view(t, func(txn Txn) error { return testScan(t, txn) })
+	})
+	t.Run("Get", func(t *testing.T) {
+		view(t, func(txn Txn) error { return testGet(t, txn) })
+	})
+	t.Run("Seek", func(t *testing.T) {
+		view(t, func(txn Txn) error { return testSeek(t, txn) })
+	})
+	t.Run("Dups", func(t *testing.T) {
+		view(t, func(txn Txn) error { return testDups(t, txn) })
+	})
+	t.Run("Write", func(t *testing.T) {
+		testWrite(t, env)
+	})
+}
+
+func testDatabases(t *testing.T, txn Txn) error {
+	names, err := txn.Databases()
+	if err != nil {
+		return err
+	}
+	var want []string
+	for _, db := range dataset() {
+		want = append(want, db.name)
+	}
+	sort.Strings(want)
+	if fmt.Sprint(names) != fmt.Sprint(want) {
+		t.Errorf("databases %q, want %q", names, want)
+	}
+	if _, err := txn.OpenCursor("missing"); err == nil {
+		t.Error("cursor opened on a missing database")
+	}
+	return nil
+}
+
+// scan returns the items of db read with op from the position set by start.
+func scan(txn Txn, db string, start, op Op) ([]item, error) {
+	cur, err := txn.OpenCursor(db)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close()
+	var items []item
+	k, v, err := cur.Get(nil, nil, start)
+	for err == nil {
+		items = append(items, item{k, v})
+		k, v, err = cur.Get(nil, nil, op)
+	}
+	if !errors.Is(err, ErrNotFound) {
+		return nil, fmt.Errorf("%s: %v", op, err)
+	}
+	return items, nil
+}
+
+func equalItems(a, b []item) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i].key, b[i].key) || !bytes.Equal(a[i].val, b[i].val) {
+			return false
+		}
+	}
+	return true
+}
+
+func testScan(t *testing.T, txn Txn) error {
+	for _, db := range dataset() {
+		items, err := scan(txn, db.name, First, Next)
+		if err != nil {
+			return fmt.Errorf("%s: %v", db.name, err)
+		}
+		if !equalItems(items, db.items) {
+			t.Errorf("%s: forward scan returned %d items, want %d", db.name, len(items), len(db.items))
+		}
+		items, err = scan(txn, db.name, Last, Prev)
+		if err != nil {
+			return fmt.Errorf("%s: %v", db.name, err)
+		}
+		for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+			items[i], items[j] = items[j], items[i]
+		}
+		if !equalItems(items, db.items) {
+			t.Errorf("%s: backward scan returned %d items, want %d", db.name, len(items), len(db.items))
+		}
+	}
+	return nil
+}
+
+func testGet(t *testing.T, txn Txn) error {
+	for _, db := range dataset() {
+		if db.flags&lmdb.DupSort != 0 {
+			continue
+		}
+		for _, it := range db.items {
+			v, err := txn.Get(db.name, it.key)
+			if err != nil {
+				return fmt.Errorf("%s: get %q: %v", db.name, it.key, err)
+			}
+			if !bytes.Equal(v, it.val) {
+				t.Errorf("%s: get %q: value of %d bytes", db.name, it.key, len(v))
+			}
+		}
+	}
+	// the first value of a DupSort key.
+	v, err := txn.Get("dups", []byte("c"))
+	if err != nil || string(v) != "p" {
+		t.Errorf("dups: get c: %q %v", v, err)
+	}
+	for _, key := range []string{"k001", "a", "zzz"} {
+		_, err := txn.Get("plain", []byte(key))
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("plain: get %q: unexpected error: %v", key, err)
+		}
+	}
+	return nil
+}
+
+// step is a cursor operation and its expected outcome, an empty wantKey
+// meaning ErrNotFound.
+type step struct {
+	op       Op
+	key, val string
+	wantKey  string
+	wantVal  string
+}
+
+func runSteps(t *testing.T, txn Txn, db string, steps []step) error {
+	cur, err := txn.OpenCursor(db)
+	if err != nil {
+		return err
+	}
+	defer cur.Close()
+	for i, s := range steps {
+		var key, val []byte
+		if s.key != "" {
+			key = []byte(s.key)
+		}
+		if s.val != "" {
+			val = []byte(s.val)
+		}
+		k, v, err := cur.Get(key, val, s.op)
+		if s.wantKey == "" {
+			if !errors.Is(err, ErrNotFound) {
+				t.Errorf("%s: step %d: %s %q %q: got %q %q %v, want not found", db, i, s.op, s.key, s.val, k, v, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: step %d: %s %q %q: %v", db, i, s.op, s.key, s.val, err)
+			continue
+		}
+		if string(k) != s.wantKey || string(v) != s.wantVal {
+			t.Errorf("%s: step %d: %s %q %q: got %q %q, want %q %q", db, i, s.op, s.key, s.val, k, v, s.wantKey, s.wantVal)
+		}
+	}
+	return nil
+}
+
+func testSeek(t *testing.T, txn Txn) error {
+	return runSteps(t, txn, "plain", []step{
+		{op: SetKey, key: "k010", wantKey: "k010", wantVal: "v010"},
+		{op: GetCurrent, wantKey: "k010", wantVal: "v010"},
+		{op: Next, wantKey: "k012", wantVal: "v012"},
+		{op: SetKey, key: "k011"},
+		{op: SetRange, key: "k011", wantKey: "k012", wantVal: "v012"},
+		{op: Prev, wantKey: "k010", wantVal: "v010"},
+		{op: SetRange, key: "a", wantKey: "k000", wantVal: "v000"},
+		{op: Prev},
+		{op: SetRange, key: "k998", wantKey: "k998", wantVal: "v998"},
+		{op: Next},
+		{op: SetRange, key: "k999"},
+		{op: Last, wantKey: "k998", wantVal: "v998"},
+		{op: First, wantKey: "k000", wantVal: "v000"},
+	})
+}
+
+func testDups(t *testing.T, txn Txn) error {
+	err := runSteps(t, txn, "dups", []step{
+		{op: SetKey, key: "a", wantKey: "a", wantVal: "1"},
+		{op: NextDup, wantKey: "a", wantVal: "2"},
+		{op: LastDup, wantKey: "a", wantVal: "3"},
+		{op: NextDup},
+		{op: FirstDup, wantKey: "a", wantVal: "1"},
+		{op: PrevDup},
+		{op: NextNoDup, wantKey: "b", wantVal: "x"},
+		{op: NextNoDup, wantKey: "c", wantVal: "p"},
+		{op: NextNoDup},
+		{op: SetKey, key: "c", wantKey: "c", wantVal: "p"},
+		{op: PrevNoDup, wantKey: "b", wantVal: "x"},
+		{op: PrevNoDup, wantKey: "a", wantVal: "3"},
+		{op: PrevDup, wantKey: "a", wantVal: "2"},
+		{op: GetBoth, key: "c", val: "q", wantKey: "c", wantVal: "q"},
+		{op: GetBoth, key: "c", val: "r"},
+		{op: GetBothRange, key: "a", val: "15", wantKey: "a", wantVal: "2"},
+		{op: GetBothRange, key: "a", val: "4"},
+		{op: SetRange, key: "bb", wantKey: "c", wantVal: "p"},
+	})
+	if err != nil {
+		return err
+	}
+	return runSteps(t, txn, "fixed", []step{
+		{op: SetKey, key: "g", wantKey: "g", wantVal: "\x00\x00\x00\x01"},
+		{op: Prev, wantKey: "f", wantVal: "\x00\x00\x03\x81"},
+		{op: FirstDup, wantKey: "f", wantVal: "\x00\x00\x00\x00"},
+		{op: GetBothRange, key: "f", val: "\x00\x00\x00\x04", wantKey: "f", wantVal: "\x00\x00\x00\x06"},
+		{op: NextDup, wantKey: "f", wantVal: "\x00\x00\x00\x09"},
+		{op: NextNoDup, wantKey: "g", wantVal: "\x00\x00\x00\x01"},
+	})
+}
+
+func testWrite(t *testing.T, env Env) {
+	errAbort := errors.New("abort")
+	err := env.Update(func(txn Txn) error {
+		err := txn.Put("scratch", []byte("k"), []byte("v1"), 0)
+		if err != nil {
+			return err
+		}
+		v, err := txn.Get("scratch", []byte("k"))
+		if err != nil || string(v) != "v1" {
+			t.Errorf("get in the writing transaction: %q %v", v, err)
+		}
+		if err := txn.Put("scratch", []byte("k"), []byte("v2"), NoOverwrite); !errors.Is(err, ErrKeyExist) {
+			t.Errorf("NoOverwrite: unexpected error: %v", err)
+		}
+		if err := txn.Del("scratch", []byte("missing"), nil); !errors.Is(err, ErrNotFound) {
+			t.Errorf("del missing: unexpected error: %v", err)
+		}
+		err = txn.Put("scratchdups", []byte("d"), []byte("1"), 0)
+		if err != nil {
+			return err
+		}
+		err = txn.Put("scratchdups", []byte("d"), []byte("2"), 0)
+		if err != nil {
+			return err
+		}
+		if err := txn.Put("scratchdups", []byte("d"), []byte("1"), NoDupData); !errors.Is(err, ErrKeyExist) {
+			t.Errorf("NoDupData: unexpected error: %v", err)
+		}
+		return nil
+	})
+	if errors.Is(err, ErrReadOnly) {
+		t.Skip("read-only backend")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = env.Update(func(txn Txn) error {
+		err := txn.Put("scratch", []byte("aborted"), nil, 0)
+		if err != nil {
+			return err
+		}
+		err = txn.Del("scratchdups", []byte("d"), []byte("1"))
+		if err != nil {
+			return err
+		}
+		return errAbort
+	})
+	if err != errAbort {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err = env.View(func(txn Txn) error {
+		if _, err := txn.Get("scratch", []byte("aborted")); !errors.Is(err, ErrNotFound) {
+			t.Errorf("aborted put: unexpected error: %v", err)
+		}
+		items, err := scan(txn, "scratchdups", First, Next)
+		if err != nil {
+			return err
+		}
+		if !equalItems(items, []item{{[]byte("d"), []byte("1")}, {[]byte("d"), []byte("2")}}) {
+			t.Errorf("scratchdups: %d items", len(items))
+		}
+		v, err := txn.Get("scratch", []byte("k"))
+		if err != nil || string(v) != "v1" {
+			t.Errorf("committed put: %q %v", v, err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
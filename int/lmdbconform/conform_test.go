@@ -0,0 +1,11 @@
+package lmdbconform
+
+import "testing"
+
+func TestLMDB(t *testing.T) {
+	Run(t, OpenLMDB)
+}
+
+func TestPage(t *testing.T) {
+	Run(t, OpenPage)
+}
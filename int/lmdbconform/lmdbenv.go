@@ -0,0 +1,181 @@
+package lmdbconform
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/glycerine/lmdb-go/lmdb"
+)
+
+var errNoDatabase = errors.New("lmdbconform: no such database")
+
+var lmdbOps = [...]uint{
+	First:        lmdb.First,
+	Last:         lmdb.Last,
+	Next:         lmdb.Next,
+	Prev:         lmdb.Prev,
+	NextDup:      lmdb.NextDup,
+	PrevDup:      lmdb.PrevDup,
+	NextNoDup:    lmdb.NextNoDup,
+	PrevNoDup:    lmdb.PrevNoDup,
+	FirstDup:     lmdb.FirstDup,
+	LastDup:      lmdb.LastDup,
+	SetKey:       lmdb.SetKey,
+	SetRange:     lmdb.SetRange,
+	GetBoth:      lmdb.GetBoth,
+	GetBothRange: lmdb.GetBothRange,
+	GetCurrent:   lmdb.GetCurrent,
+}
+
+// lmdbError translates the errors of package lmdb.
+func lmdbError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case lmdb.IsNotFound(err):
+		return ErrNotFound
+	case lmdb.IsErrno(err, lmdb.KeyExist):
+		return ErrKeyExist
+	}
+	return err
+}
+
+// OpenLMDB opens an environment with the cgo backend, package lmdb.
+func OpenLMDB(path string) (Env, error) {
+	env, err := lmdb.OpenEnv(path, &lmdb.Options{MaxDBs: MaxDBs})
+	if err != nil {
+		return nil, err
+	}
+	e := &lmdbEnv{env: env, dbis: make(map[string]lmdb.DBI)}
+	// named databases are the keys of the root database.
+	err = env.Update(func(txn *lmdb.Txn) error {
+		root, err := txn.OpenRoot(0)
+		if err != nil {
+			return err
+		}
+		cur, err := txn.OpenCursor(root)
+		if err != nil {
+			return err
+		}
+		defer cur.Close()
+		for {
+			k, _, err := cur.Get(nil, nil, lmdb.NextNoDup)
+			if lmdb.IsNotFound(err) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			dbi, err := txn.OpenDBI(string(k), 0)
+			if err != nil {
+				return err
+			}
+			e.dbis[string(k)] = dbi
+		}
+	})
+	if err != nil {
+		env.Close()
+		return nil, err
+	}
+	return e, nil
+}
+
+type lmdbEnv struct {
+	env  *lmdb.Env
+	dbis map[string]lmdb.DBI
+}
+
+func (e *lmdbEnv) View(fn func(txn Txn) error) error {
+	return e.env.View(func(txn *lmdb.Txn) error {
+		return fn(&lmdbTxn{e, txn})
+	})
+}
+
+func (e *lmdbEnv) Update(fn func(txn Txn) error) error {
+	return e.env.Update(func(txn *lmdb.Txn) error {
+		return fn(&lmdbTxn{e, txn})
+	})
+}
+
+func (e *lmdbEnv) Close() error {
+	return e.env.Close()
+}
+
+type lmdbTxn struct {
+	env *lmdbEnv
+	txn *lmdb.Txn
+}
+
+func (t *lmdbTxn) dbi(db string) (lmdb.DBI, error) {
+	dbi, ok := t.env.dbis[db]
+	if !ok {
+		return 0, errNoDatabase
+	}
+	return dbi, nil
+}
+
+func (t *lmdbTxn) Databases() ([]string, error) {
+	var names []string
+	for name := range t.env.dbis {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (t *lmdbTxn) Get(db string, key []byte) ([]byte, error) {
+	dbi, err := t.dbi(db)
+	if err != nil {
+		return nil, err
+	}
+	v, err := t.txn.Get(dbi, key)
+	return v, lmdbError(err)
+}
+
+func (t *lmdbTxn) Put(db string, key, val []byte, flags uint) error {
+	dbi, err := t.dbi(db)
+	if err != nil {
+		return err
+	}
+	var f uint
+	if flags&NoOverwrite != 0 {
+		f |= lmdb.NoOverwrite
+	}
+	if flags&NoDupData != 0 {
+		f |= lmdb.NoDupData
+	}
+	return lmdbError(t.txn.Put(dbi, key, val, f))
+}
+
+func (t *lmdbTxn) Del(db string, key, val []byte) error {
+	dbi, err := t.dbi(db)
+	if err != nil {
+		return err
+	}
+	return lmdbError(t.txn.Del(dbi, key, val))
+}
+
+func (t *lmdbTxn) OpenCursor(db string) (Cursor, error) {
+	dbi, err := t.dbi(db)
+	if err != nil {
+		return nil, err
+	}
+	cur, err := t.txn.OpenCursor(dbi)
+	if err != nil {
+		return nil, err
+	}
+	return lmdbCursor{cur}, nil
+}
+
+type lmdbCursor struct {
+	cur *lmdb.Cursor
+}
+
+func (c lmdbCursor) Get(key, val []byte, op Op) ([]byte, []byte, error) {
+	k, v, err := c.cur.Get(key, val, lmdbOps[op])
+	return k, v, lmdbError(err)
+}
+
+func (c lmdbCursor) Close() {
+	c.cur.Close()
+}
@@ -0,0 +1,266 @@
+package lmdbconform
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sort"
+
+	"github.com/glycerine/lmdb-go/lmdbpage"
+)
+
+// OpenPage opens an environment with the pure-Go reader, package lmdbpage.
+// The reader has no cursors, so the items of a database are read on first
+// use and cursors move over them in memory, using the ordering of the
+// database flags.  The backend is read-only.
+func OpenPage(path string) (Env, error) {
+	f, err := lmdbpage.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	meta := f.Meta()
+	if meta == nil {
+		f.Close()
+		return nil, lmdbpage.ErrNotLMDB
+	}
+	dbs, err := f.NewWalker().Databases(meta.Main)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &pageEnv{f: f, dbs: dbs, items: make(map[string][]item)}, nil
+}
+
+type pageEnv struct {
+	f     *lmdbpage.File
+	dbs   map[string]lmdbpage.DB
+	items map[string][]item
+}
+
+func (e *pageEnv) View(fn func(txn Txn) error) error {
+	return fn(e)
+}
+
+func (e *pageEnv) Update(fn func(txn Txn) error) error {
+	return ErrReadOnly
+}
+
+func (e *pageEnv) Close() error {
+	return e.f.Close()
+}
+
+func (e *pageEnv) Databases() ([]string, error) {
+	var names []string
+	for name := range e.dbs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// load returns the items of db, in storage order.
+func (e *pageEnv) load(db string) ([]item, lmdbpage.DB, error) {
+	d, ok := e.dbs[db]
+	if !ok {
+		return nil, d, errNoDatabase
+	}
+	if items, ok := e.items[db]; ok {
+		return items, d, nil
+	}
+	var items []item
+	err := e.f.NewWalker().Walk(d, func(k, v []byte) error {
+		items = append(items, item{
+			key: append([]byte(nil), k...),
+			val: append([]byte(nil), v...),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, d, err
+	}
+	e.items[db] = items
+	return items, d, nil
+}
+
+func (e *pageEnv) Get(db string, key []byte) ([]byte, error) {
+	c, err := e.cursor(db)
+	if err != nil {
+		return nil, err
+	}
+	_, v, err := c.Get(key, nil, SetKey)
+	return v, err
+}
+
+func (e *pageEnv) Put(db string, key, val []byte, flags uint) error {
+	return ErrReadOnly
+}
+
+func (e *pageEnv) Del(db string, key, val []byte) error {
+	return ErrReadOnly
+}
+
+func (e *pageEnv) OpenCursor(db string) (Cursor, error) {
+	return e.cursor(db)
+}
+
+func (e *pageEnv) cursor(db string) (*pageCursor, error) {
+	items, d, err := e.load(db)
+	if err != nil {
+		return nil, err
+	}
+	return &pageCursor{
+		items:  items,
+		pos:    -1,
+		cmpKey: comparer(d.Flags&lmdbpage.IntegerKey != 0, d.Flags&lmdbpage.ReverseKey != 0),
+		cmpVal: comparer(d.Flags&lmdbpage.IntegerDup != 0, d.Flags&lmdbpage.ReverseDup != 0),
+	}, nil
+}
+
+// comparer returns the function ordering keys or values with the given
+// flags, as LMDB does.
+func comparer(integer, reverse bool) func(a, b []byte) int {
+	switch {
+	case integer:
+		return func(a, b []byte) int {
+			x, y := nativeUint(a), nativeUint(b)
+			switch {
+			case x < y:
+				return -1
+			case x > y:
+				return 1
+			}
+			return 0
+		}
+	case reverse:
+		return func(a, b []byte) int {
+			i, j := len(a)-1, len(b)-1
+			for ; i >= 0 && j >= 0; i, j = i-1, j-1 {
+				if a[i] != b[j] {
+					return int(a[i]) - int(b[j])
+				}
+			}
+			return (i + 1) - (j + 1)
+		}
+	}
+	return bytes.Compare
+}
+
+// nativeUint decodes an integer key, stored in the byte order of the
+// (little-endian) platforms lmdbpage supports.
+func nativeUint(b []byte) uint64 {
+	switch len(b) {
+	case 4:
+		return uint64(binary.LittleEndian.Uint32(b))
+	case 8:
+		return binary.LittleEndian.Uint64(b)
+	}
+	return 0
+}
+
+// pageCursor is a cursor over the items of a database held in memory.
+type pageCursor struct {
+	items  []item
+	pos    int // -1 when unpositioned
+	cmpKey func(a, b []byte) int
+	cmpVal func(a, b []byte) int
+}
+
+func (c *pageCursor) Close() {}
+
+func (c *pageCursor) at(i int) ([]byte, []byte, error) {
+	c.pos = i
+	return c.items[i].key, c.items[i].val, nil
+}
+
+func (c *pageCursor) sameKey(i int) bool {
+	return c.cmpKey(c.items[i].key, c.items[c.pos].key) == 0
+}
+
+// keyRange returns the indexes of the values of key.
+func (c *pageCursor) keyRange(key []byte) (int, int) {
+	lo := sort.Search(len(c.items), func(i int) bool { return c.cmpKey(c.items[i].key, key) >= 0 })
+	hi := lo
+	for hi < len(c.items) && c.cmpKey(c.items[hi].key, key) == 0 {
+		hi++
+	}
+	return lo, hi
+}
+
+func (c *pageCursor) Get(key, val []byte, op Op) ([]byte, []byte, error) {
+	n := len(c.items)
+	if c.pos < 0 {
+		switch op {
+		case Next, NextNoDup:
+			op = First
+		case Prev, PrevNoDup:
+			op = Last
+		case NextDup, PrevDup, FirstDup, LastDup, GetCurrent:
+			return nil, nil, ErrNotFound
+		}
+	}
+	switch op {
+	case First:
+		if n > 0 {
+			return c.at(0)
+		}
+	case Last:
+		if n > 0 {
+			return c.at(n - 1)
+		}
+	case Next:
+		if c.pos+1 < n {
+			return c.at(c.pos + 1)
+		}
+	case Prev:
+		if c.pos > 0 {
+			return c.at(c.pos - 1)
+		}
+	case NextDup:
+		if c.pos+1 < n && c.sameKey(c.pos+1) {
+			return c.at(c.pos + 1)
+		}
+	case PrevDup:
+		if c.pos > 0 && c.sameKey(c.pos-1) {
+			return c.at(c.pos - 1)
+		}
+	case NextNoDup:
+		for i := c.pos + 1; i < n; i++ {
+			if !c.sameKey(i) {
+				return c.at(i)
+			}
+		}
+	case PrevNoDup:
+		// the last value of the previous key.
+		for i := c.pos - 1; i >= 0; i-- {
+			if !c.sameKey(i) {
+				return c.at(i)
+			}
+		}
+	case FirstDup:
+		lo, _ := c.keyRange(c.items[c.pos].key)
+		return c.at(lo)
+	case LastDup:
+		_, hi := c.keyRange(c.items[c.pos].key)
+		return c.at(hi - 1)
+	case GetCurrent:
+		return c.at(c.pos)
+	case SetKey:
+		lo, hi := c.keyRange(key)
+		if lo < hi {
+			return c.at(lo)
+		}
+	case SetRange:
+		lo, _ := c.keyRange(key)
+		if lo < n {
+			return c.at(lo)
+		}
+	case GetBoth, GetBothRange:
+		lo, hi := c.keyRange(key)
+		for i := lo; i < hi; i++ {
+			d := c.cmpVal(c.items[i].val, val)
+			if d == 0 || d > 0 && op == GetBothRange {
+				return c.at(i)
+			}
+		}
+	}
+	return nil, nil, ErrNotFound
+}
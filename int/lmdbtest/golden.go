@@ -0,0 +1,401 @@
+package lmdbtest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/glycerine/lmdb-go/lmdb"
+)
+
+// GoldenUpdateEnv is the environment variable that makes AssertGolden write
+// the golden file instead of comparing against it.
+const GoldenUpdateEnv = "LMDBTEST_UPDATE_GOLDEN"
+
+// dbFlagNames names the persistent database flags in dump output.  Package
+// lmdb does not export the integer flags so their values are given here.
+var dbFlagNames = []struct {
+	flag uint
+	name string
+}{
+	{lmdb.ReverseKey, "reversekey"},
+	{lmdb.DupSort, "dupsort"},
+	{0x08, "integerkey"},
+	{lmdb.DupFixed, "dupfixed"},
+	{0x20, "integerdup"},
+	{lmdb.ReverseDup, "reversedup"},
+}
+
+func formatDBFlags(flags uint) string {
+	var names []string
+	for _, f := range dbFlagNames {
+		if flags&f.flag != 0 {
+			names = append(names, f.name)
+			flags &^= f.flag
+		}
+	}
+	if flags != 0 {
+		names = append(names, fmt.Sprintf("%#x", flags))
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, ",")
+}
+
+// Dump writes a canonical textual dump of every database in env to w.  The
+// main database comes first, followed by the named databases sorted by name.
+// Each database is introduced by a header line giving its name, flags and
+// number of items, followed by one line per item holding the hex encoded key
+// and value.  Items that are printable text are annotated with their quoted
+// form.  The keys of the main database that name databases are not listed
+// as items.
+//
+// The dump depends only on the contents of the databases, not on page layout
+// or transaction history, so it can be compared across runs.
+func Dump(w io.Writer, env *lmdb.Env) error {
+	bw := bufio.NewWriter(w)
+	err := env.View(func(txn *lmdb.Txn) error {
+		root, err := txn.OpenRoot(0)
+		if err != nil {
+			return err
+		}
+		dbis := make(map[string]lmdb.DBI)
+		var plain int
+		err = scanItems(txn, root, func(k, v []byte) error {
+			// keys with values of other sizes are not tried with OpenDBI,
+			// which could otherwise fail with DBS_FULL when every handle
+			// slot is taken.
+			if len(v) != lmdb.DBRecordSize {
+				plain++
+				return nil
+			}
+			dbi, err := txn.OpenDBI(string(k), 0)
+			if lmdb.IsErrno(err, lmdb.Incompatible) {
+				plain++
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			dbis[string(k)] = dbi
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		names := make([]string, 0, len(dbis))
+		for name := range dbis {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		err = dumpDB(bw, txn, "", root, plain, func(k []byte) bool {
+			_, ok := dbis[string(k)]
+			return ok
+		})
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			stat, err := txn.Stat(dbis[name])
+			if err != nil {
+				return err
+			}
+			err = dumpDB(bw, txn, name, dbis[name], int(stat.Entries), nil)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+func dumpDB(w io.Writer, txn *lmdb.Txn, name string, dbi lmdb.DBI, n int, skip func(k []byte) bool) error {
+	flags, err := txn.Flags(dbi)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "database %s flags=%s items=%d\n", strconv.Quote(name), formatDBFlags(flags), n)
+	return scanItems(txn, dbi, func(k, v []byte) error {
+		if skip != nil && skip(k) {
+			return nil
+		}
+		_, err := fmt.Fprintf(w, "\t%x : %x", k, v)
+		if err != nil {
+			return err
+		}
+		if printable(k) && printable(v) {
+			fmt.Fprintf(w, "\t# %q : %q", k, v)
+		}
+		_, err = fmt.Fprintln(w)
+		return err
+	})
+}
+
+func scanItems(txn *lmdb.Txn, dbi lmdb.DBI, fn func(k, v []byte) error) error {
+	cur, err := txn.OpenCursor(dbi)
+	if err != nil {
+		return err
+	}
+	defer cur.Close()
+	for {
+		k, v, err := cur.Get(nil, nil, lmdb.Next)
+		if lmdb.IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		err = fn(k, v)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func printable(b []byte) bool {
+	if !utf8.Valid(b) {
+		return false
+	}
+	for _, r := range string(b) {
+		if r < 0x20 || r == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// AssertGolden fails t unless the dump of env (see Dump) equals the contents
+// of the golden file at path.  When the environment variable named by
+// GoldenUpdateEnv is set to a non-empty value the golden file is written
+// instead, creating its directory if needed.
+func AssertGolden(t testing.TB, env *lmdb.Env, path string) {
+	t.Helper()
+	var buf bytes.Buffer
+	err := Dump(&buf, env)
+	if err != nil {
+		t.Fatalf("dump: %v", err)
+	}
+	got := buf.Bytes()
+
+	if os.Getenv(GoldenUpdateEnv) != "" {
+		err = os.MkdirAll(filepath.Dir(path), 0755)
+		if err == nil {
+			err = ioutil.WriteFile(path, got, 0644)
+		}
+		if err != nil {
+			t.Fatalf("update golden file: %v", err)
+		}
+		return
+	}
+
+	want, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read golden file (set %s=1 to create it): %v", GoldenUpdateEnv, err)
+	}
+	if bytes.Equal(got, want) {
+		return
+	}
+	t.Errorf("database state differs from %s (set %s=1 to update it):\n%s",
+		path, GoldenUpdateEnv, diffLines(string(want), string(got)))
+}
+
+// diffLines returns a line diff of want and got, with lines missing from got
+// prefixed by "-" and unexpected lines by "+".
+func diffLines(want, got string) string {
+	a := strings.SplitAfter(want, "\n")
+	b := strings.SplitAfter(got, "\n")
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:]
+	// and b[j:].  Dumps compared in tests are small.
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var out strings.Builder
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			i++
+			j++
+			continue
+		case j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+			out.WriteString("-" + a[i])
+			i++
+		default:
+			out.WriteString("+" + b[j])
+			j++
+		}
+		if !strings.HasSuffix(out.String(), "\n") {
+			out.WriteString("\n")
+		}
+	}
+	return out.String()
+}
+
+// Load writes the databases of a dump produced by Dump into env, creating
+// the named databases with the flags recorded in the dump.  Items are added
+// to existing contents.  Load fails if the dump is malformed, including when
+// the number of items listed for a database does not match its header.
+func Load(env *lmdb.Env, r io.Reader) error {
+	dbs, err := parseDump(r)
+	if err != nil {
+		return err
+	}
+	return env.Update(func(txn *lmdb.Txn) (err error) {
+		for _, db := range dbs {
+			var dbi lmdb.DBI
+			if db.name == "" {
+				dbi, err = txn.OpenRoot(db.flags)
+			} else {
+				dbi, err = txn.OpenDBI(db.name, db.flags|lmdb.Create)
+			}
+			if err != nil {
+				return err
+			}
+			for _, item := range db.items {
+				err = txn.Put(dbi, item[0], item[1], 0)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+type dumpDatabase struct {
+	name  string
+	flags uint
+	items [][2][]byte
+}
+
+func parseDump(r io.Reader) ([]*dumpDatabase, error) {
+	var dbs []*dumpDatabase
+	var want []int
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 1<<30)
+	for lineno := 1; s.Scan(); lineno++ {
+		line := s.Text()
+		if strings.HasPrefix(line, "\t") {
+			if len(dbs) == 0 {
+				return nil, fmt.Errorf("dump line %d: item outside of a database", lineno)
+			}
+			k, v, err := parseDumpItem(line[1:])
+			if err != nil {
+				return nil, fmt.Errorf("dump line %d: %v", lineno, err)
+			}
+			db := dbs[len(dbs)-1]
+			db.items = append(db.items, [2][]byte{k, v})
+			continue
+		}
+		db, n, err := parseDumpHeader(line)
+		if err != nil {
+			return nil, fmt.Errorf("dump line %d: %v", lineno, err)
+		}
+		dbs = append(dbs, db)
+		want = append(want, n)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	for i, db := range dbs {
+		if len(db.items) != want[i] {
+			return nil, fmt.Errorf("dump database %q: %d items listed, header says %d", db.name, len(db.items), want[i])
+		}
+	}
+	return dbs, nil
+}
+
+func parseDumpHeader(line string) (*dumpDatabase, int, error) {
+	rest := strings.TrimPrefix(line, "database ")
+	if rest == line {
+		return nil, 0, fmt.Errorf("bad database header")
+	}
+	quoted, err := strconv.QuotedPrefix(rest)
+	if err != nil {
+		return nil, 0, fmt.Errorf("bad database name: %v", err)
+	}
+	name, _ := strconv.Unquote(quoted)
+	var flagList string
+	var n int
+	_, err = fmt.Sscanf(rest[len(quoted):], " flags=%s items=%d", &flagList, &n)
+	if err != nil {
+		return nil, 0, fmt.Errorf("bad database header: %v", err)
+	}
+	if n < 0 {
+		return nil, 0, fmt.Errorf("bad item count %d", n)
+	}
+	flags, err := parseDBFlags(flagList)
+	if err != nil {
+		return nil, 0, err
+	}
+	return &dumpDatabase{name: name, flags: flags}, n, nil
+}
+
+func parseDBFlags(s string) (uint, error) {
+	if s == "none" {
+		return 0, nil
+	}
+	var flags uint
+outer:
+	for _, name := range strings.Split(s, ",") {
+		for _, f := range dbFlagNames {
+			if f.name == name {
+				flags |= f.flag
+				continue outer
+			}
+		}
+		return 0, fmt.Errorf("unknown database flag %q", name)
+	}
+	return flags, nil
+}
+
+func parseDumpItem(line string) (k, v []byte, err error) {
+	if i := strings.IndexByte(line, '\t'); i >= 0 {
+		// drop the annotation.
+		line = line[:i]
+	}
+	parts := strings.Split(line, " : ")
+	if len(parts) != 2 {
+		return nil, nil, fmt.Errorf("bad item")
+	}
+	k, err = hex.DecodeString(parts[0])
+	if err != nil {
+		return nil, nil, fmt.Errorf("bad key: %v", err)
+	}
+	v, err = hex.DecodeString(parts[1])
+	if err != nil {
+		return nil, nil, fmt.Errorf("bad value: %v", err)
+	}
+	return k, v, nil
+}
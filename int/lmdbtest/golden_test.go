@@ -0,0 +1,93 @@
+package lmdbtest
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/glycerine/lmdb-go/lmdb"
+)
+
+func goldenEnv(t *testing.T) *lmdb.Env {
+	env, err := NewEnv(&EnvOptions{MaxDBs: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = env.Update(func(txn *lmdb.Txn) (err error) {
+		root, err := txn.OpenRoot(0)
+		if err != nil {
+			return err
+		}
+		err = txn.Put(root, []byte("version"), []byte("1"), 0)
+		if err != nil {
+			return err
+		}
+		tags, err := txn.OpenDBI("tags", lmdb.Create|lmdb.DupSort)
+		if err != nil {
+			return err
+		}
+		for _, tv := range [][2]string{{"go", "lmdb"}, {"go", "cgo"}, {"c", "mdb"}} {
+			err = txn.Put(tags, []byte(tv[0]), []byte(tv[1]), 0)
+			if err != nil {
+				return err
+			}
+		}
+		bin, err := txn.OpenDBI("bin", lmdb.Create)
+		if err != nil {
+			return err
+		}
+		return txn.Put(bin, []byte{0, 1}, []byte{0xff}, 0)
+	})
+	if err != nil {
+		Destroy(env)
+		t.Fatal(err)
+	}
+	return env
+}
+
+func TestAssertGolden(t *testing.T) {
+	env := goldenEnv(t)
+	defer Destroy(env)
+	AssertGolden(t, env, "testdata/golden.dump")
+}
+
+func TestDiffLines(t *testing.T) {
+	diff := diffLines("a\nb\nc\n", "a\nc\nd\n")
+	want := "-b\n+d\n"
+	if diff != want {
+		t.Errorf("diff: %q, want %q", diff, want)
+	}
+	if !strings.HasPrefix(diffLines("x", "y"), "-x\n+y") {
+		t.Errorf("diff without newlines: %q", diffLines("x", "y"))
+	}
+}
+
+func TestLoad(t *testing.T) {
+	env, err := NewEnv(&EnvOptions{MaxDBs: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer Destroy(env)
+
+	f, err := os.Open("testdata/golden.dump")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	err = Load(env, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertGolden(t, env, "testdata/golden.dump")
+
+	for _, bad := range []string{
+		"\t61 : 62\n",
+		"database \"\" flags=none items=2\n\t61 : 62\n",
+		"database \"x\" flags=bogus items=0\n",
+		"database \"\" flags=none items=1\n\t6 : 62\n",
+	} {
+		if Load(env, strings.NewReader(bad)) == nil {
+			t.Errorf("loaded malformed dump %q", bad)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package lmdb
+
+import (
+	"sync"
+	"time"
+)
+
+// AdaptiveBatching makes a BatchWriter adjust its batch size and delay to
+// the latency of its commits and the depth of its queue, within bounds, see
+// BatchWriterOptions.Adaptive.  The zero value selects the defaults
+// described for each field.
+type AdaptiveBatching struct {
+	// MinBatch and MaxBatch bound the batch size, 1 and 4096 if zero.
+	MinBatch int
+	MaxBatch int
+
+	// MinDelay and MaxDelay bound the delay, see
+	// BatchWriterOptions.MaxDelay, 0 and 10ms if zero.
+	MinDelay time.Duration
+	MaxDelay time.Duration
+
+	// TargetLatency is the commit latency below which the writer favors
+	// latency over throughput, 5ms if zero.
+	TargetLatency time.Duration
+
+	// StallLatency is the commit latency counted as a write stall, 100ms if
+	// zero.  OnStall, if not nil, is called by the worker with each stall
+	// and should return quickly.
+	StallLatency time.Duration
+	OnStall      func(WriteStall)
+}
+
+// WriteStall describes a commit of a BatchWriter slower than the stall
+// latency.
+type WriteStall struct {
+	Latency    time.Duration
+	Batch      int // operations committed
+	QueueDepth int // operations queued after the commit
+}
+
+// BatchWriterStats describes the recent behavior of a BatchWriter.
+type BatchWriterStats struct {
+	// Batch and Delay are the current batch size and delay, which vary
+	// with adaptive batching.
+	Batch int
+	Delay time.Duration
+
+	// QueueDepth is the number of operations queued.
+	QueueDepth int
+
+	Commits     uint64
+	LastLatency time.Duration // of the most recent commit
+
+	// Stalls is the number of commits slower than the stall latency.
+	Stalls uint64
+}
+
+// minAdaptiveDelay is the smallest non-zero delay set by adaptive batching.
+const minAdaptiveDelay = 100 * time.Microsecond
+
+// batchAdapter holds the limits of a BatchWriter and adapts them when
+// enabled.  The limits are only changed by the worker, and read under mu
+// by Stats.
+type batchAdapter struct {
+	enabled bool
+	opts    AdaptiveBatching
+
+	mu          sync.Mutex
+	batch       int
+	delay       time.Duration
+	commits     uint64
+	lastLatency time.Duration
+	stalls      uint64
+}
+
+func newBatchAdapter(maxBatch int, maxDelay time.Duration, adaptive *AdaptiveBatching) *batchAdapter {
+	a := &batchAdapter{batch: maxBatch, delay: maxDelay}
+	if adaptive != nil {
+		a.enabled = true
+		a.opts = *adaptive
+	}
+	o := &a.opts
+	if o.MinBatch <= 0 {
+		o.MinBatch = 1
+	}
+	if o.MaxBatch <= 0 {
+		o.MaxBatch = 4096
+	}
+	if o.MaxBatch < o.MinBatch {
+		o.MaxBatch = o.MinBatch
+	}
+	if o.MaxDelay <= 0 {
+		o.MaxDelay = 10 * time.Millisecond
+	}
+	if o.MaxDelay < o.MinDelay {
+		o.MaxDelay = o.MinDelay
+	}
+	if o.TargetLatency <= 0 {
+		o.TargetLatency = 5 * time.Millisecond
+	}
+	if o.StallLatency <= 0 {
+		o.StallLatency = 100 * time.Millisecond
+	}
+	if a.enabled {
+		a.batch = clampInt(a.batch, o.MinBatch, o.MaxBatch)
+		a.delay = clampDuration(a.delay, o.MinDelay, o.MaxDelay)
+	}
+	return a
+}
+
+// limits returns the current batch size and delay.
+func (a *batchAdapter) limits() (int, time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.batch, a.delay
+}
+
+// observe records a commit of n operations that took latency, after which
+// queued operations remain, and adapts the limits.  A slow commit or a
+// backlog grows the batch, so that each commit carries more operations, and
+// a slow commit with a short queue also grows the delay, so that trickling
+// operations wait for the next commit together rather than each paying for
+// one.  Fast commits with a short queue shrink them back, lowering latency.
+func (a *batchAdapter) observe(n int, latency time.Duration, queued int) {
+	a.mu.Lock()
+	a.commits++
+	a.lastLatency = latency
+	stall := latency >= a.opts.StallLatency
+	if stall {
+		a.stalls++
+	}
+	if a.enabled {
+		o := &a.opts
+		switch {
+		case latency > o.TargetLatency || queued >= a.batch:
+			a.batch = clampInt(2*a.batch, o.MinBatch, o.MaxBatch)
+			if latency > o.TargetLatency && queued < a.batch {
+				d := 2 * a.delay
+				if d < minAdaptiveDelay {
+					d = minAdaptiveDelay
+				}
+				a.delay = clampDuration(d, o.MinDelay, o.MaxDelay)
+			}
+		case latency < o.TargetLatency/2 && 4*queued < a.batch:
+			a.batch = clampInt(a.batch-(a.batch+3)/4, o.MinBatch, o.MaxBatch)
+			d := a.delay / 2
+			if d < minAdaptiveDelay {
+				d = 0
+			}
+			a.delay = clampDuration(d, o.MinDelay, o.MaxDelay)
+		}
+	}
+	a.mu.Unlock()
+	if stall && a.opts.OnStall != nil {
+		a.opts.OnStall(WriteStall{Latency: latency, Batch: n, QueueDepth: queued})
+	}
+}
+
+func clampInt(x, min, max int) int {
+	if x < min {
+		return min
+	}
+	if x > max {
+		return max
+	}
+	return x
+}
+
+func clampDuration(x, min, max time.Duration) time.Duration {
+	if x < min {
+		return min
+	}
+	if x > max {
+		return max
+	}
+	return x
+}
+
+// Stats returns the current limits and recent commit statistics of w.
+func (w *BatchWriter) Stats() BatchWriterStats {
+	a := w.adapt
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return BatchWriterStats{
+		Batch:       a.batch,
+		Delay:       a.delay,
+		QueueDepth:  len(w.queue),
+		Commits:     a.commits,
+		LastLatency: a.lastLatency,
+		Stalls:      a.stalls,
+	}
+}
@@ -0,0 +1,70 @@
+package lmdb
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBatchWriter_Adaptive(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+
+	var dbi DBI
+	err := env.Update(func(txn *Txn) (err error) {
+		dbi, err = txn.OpenDBI("adaptive", Create)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	put := func(w *BatchWriter, n int) {
+		errcs := make([]<-chan error, n)
+		for i := range errcs {
+			k := []byte{byte(i >> 8), byte(i)}
+			errcs[i] = w.Enqueue(func(txn *Txn) error { return txn.Put(dbi, k, k, 0) })
+		}
+		for _, errc := range errcs {
+			if err := <-errc; err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// every commit is slower than the target and a stall.
+	var stalls int64
+	w := env.NewBatchWriter(&BatchWriterOptions{
+		MaxBatch: 4,
+		Adaptive: &AdaptiveBatching{
+			MaxBatch:      64,
+			MaxDelay:      time.Millisecond,
+			TargetLatency: time.Nanosecond,
+			StallLatency:  time.Nanosecond,
+			OnStall:       func(WriteStall) { atomic.AddInt64(&stalls, 1) },
+		},
+	})
+	put(w, 500)
+	stats := w.Stats()
+	w.Close()
+	if stats.Batch != 64 || stats.Delay == 0 {
+		t.Errorf("limits after slow commits: %+v", stats)
+	}
+	if stats.Commits == 0 || stats.Stalls != stats.Commits || atomic.LoadInt64(&stalls) != int64(stats.Stalls) {
+		t.Errorf("stalls: %d reported, %+v", stalls, stats)
+	}
+
+	// fast commits of a trickle of writes shrink the limits.
+	w = env.NewBatchWriter(&BatchWriterOptions{
+		MaxBatch: 64,
+		MaxDelay: time.Millisecond,
+		Adaptive: &AdaptiveBatching{MinBatch: 2, TargetLatency: time.Hour},
+	})
+	defer w.Close()
+	for i := 0; i < 50; i++ {
+		put(w, 1)
+	}
+	stats = w.Stats()
+	if stats.Batch != 2 || stats.Delay != 0 || stats.Stalls != 0 {
+		t.Errorf("limits after fast commits: %+v", stats)
+	}
+}
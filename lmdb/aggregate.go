@@ -0,0 +1,268 @@
+package lmdb
+
+/*
+#include <stdlib.h>
+#include "lmdb.h"
+#include "lmdbgo.h"
+*/
+import "C"
+import (
+	"bytes"
+	"container/heap"
+	"sort"
+	"unsafe"
+)
+
+// AggRange is the range of items of a database aggregated by the fold
+// helpers on Txn.  The zero value is the whole database.
+type AggRange struct {
+	// Start is the first key of the range, or the smallest key greater than
+	// it, the first key of the database if empty.
+	Start []byte
+
+	// End is the key ending the range, excluded from it, unbounded if nil.
+	End []byte
+
+	// Batch is the number of items read per call into C, 1 if zero.
+	// Batching makes scans of many small items faster, at the cost of a
+	// C allocation of 32 bytes per item per fold.
+	Batch int
+}
+
+// Fold calls fn with the items of r in dbi, in key order, until fn returns
+// an error, which Fold returns.  The slices passed to fn reference the
+// memory of the database, whether or not txn.RawRead is set, and must not be
+// modified nor retained after fn returns, so that folding allocates nothing
+// per item.
+func (txn *Txn) Fold(dbi DBI, r AggRange, fn func(key, val []byte) error) error {
+	cur, err := txn.OpenCursor(dbi)
+	if err != nil {
+		return err
+	}
+	defer cur.Close()
+
+	n := r.Batch
+	if n <= 0 {
+		n = 1
+	}
+	keys, vals := txn.readSlot.skey, txn.readSlot.sval
+	if n > 1 {
+		size := C.size_t(n) * C.size_t(unsafe.Sizeof(C.MDB_val{}))
+		keys = (*C.MDB_val)(C.malloc(size))
+		vals = (*C.MDB_val)(C.malloc(size))
+		defer C.free(unsafe.Pointer(keys))
+		defer C.free(unsafe.Pointer(vals))
+	}
+	kslice := (*[valMaxSize / unsafe.Sizeof(C.MDB_val{})]C.MDB_val)(unsafe.Pointer(keys))[:n:n]
+	vslice := (*[valMaxSize / unsafe.Sizeof(C.MDB_val{})]C.MDB_val)(unsafe.Pointer(vals))[:n:n]
+
+	start, sn := valBytes(r.Start)
+	end, en := valBytes(r.End)
+	var hasEnd C.int
+	if r.End != nil {
+		hasEnd = 1
+	}
+	op := C.MDB_cursor_op(First)
+	if len(r.Start) > 0 {
+		op = SetRange
+	}
+	for {
+		var done C.size_t
+		ret := C.lmdbgo_mdb_cursor_get_range(
+			txn._txn, C.MDB_dbi(dbi), cur._c, op,
+			(*C.char)(unsafe.Pointer(&start[0])), C.size_t(sn),
+			(*C.char)(unsafe.Pointer(&end[0])), C.size_t(en), hasEnd,
+			keys, vals, C.size_t(n), &done,
+		)
+		txn.profCall(1)
+		for i := 0; i < int(done); i++ {
+			err = fn(getBytes(&kslice[i]), getBytes(&vslice[i]))
+			if err != nil {
+				return err
+			}
+		}
+		err = operrno("mdb_cursor_get", ret)
+		if IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		op = Next
+	}
+}
+
+// Count returns the number of items of r in dbi, counting the values of each
+// key of a DupSort database at once, in a single call into C.  r.Batch is
+// ignored.
+func (txn *Txn) Count(dbi DBI, r AggRange) (int, error) {
+	flags, err := txn.Flags(dbi)
+	if err != nil {
+		return 0, err
+	}
+	var dupsort C.int
+	if flags&DupSort != 0 {
+		dupsort = 1
+	}
+	cur, err := txn.OpenCursor(dbi)
+	if err != nil {
+		return 0, err
+	}
+	defer cur.Close()
+
+	start, sn := valBytes(r.Start)
+	end, en := valBytes(r.End)
+	var hasEnd C.int
+	if r.End != nil {
+		hasEnd = 1
+	}
+	var count C.size_t
+	ret := C.lmdbgo_mdb_cursor_count_range(
+		txn._txn, C.MDB_dbi(dbi), cur._c,
+		(*C.char)(unsafe.Pointer(&start[0])), C.size_t(sn),
+		(*C.char)(unsafe.Pointer(&end[0])), C.size_t(en), hasEnd,
+		dupsort, &count,
+	)
+	txn.profCall(1)
+	return int(count), operrno("mdb_cursor_get", ret)
+}
+
+// AggValue decodes the value aggregated for an item by Sum and GroupBy.  Its
+// arguments follow the rules of Fold.
+type AggValue func(key, val []byte) (float64, error)
+
+// Sum returns the sum of the values decoded by value from the items of r in
+// dbi, stopping at the first error of value.
+func (txn *Txn) Sum(dbi DBI, r AggRange, value AggValue) (float64, error) {
+	var sum float64
+	err := txn.Fold(dbi, r, func(k, v []byte) error {
+		x, err := value(k, v)
+		sum += x
+		return err
+	})
+	return sum, err
+}
+
+// TopK returns the k greatest items of r in dbi according to less, greatest
+// first, items comparing equal keeping key order.  Only the items retained
+// are copied, reusing the memory of those they replace, so that TopK
+// allocates O(k) whatever the size of r.  The KV passed to less follow the
+// rules of Fold.
+func (txn *Txn) TopK(dbi DBI, r AggRange, k int, less func(a, b KV) bool) ([]KV, error) {
+	if k <= 0 {
+		return nil, nil
+	}
+	h := &topKHeap{less: less}
+	err := txn.Fold(dbi, r, func(key, val []byte) error {
+		if len(h.items) < k {
+			h.items = append(h.items, topKItem{KV{cloneBytes(key), cloneBytes(val)}, h.seq})
+			h.seq++
+			if len(h.items) == k {
+				heap.Init(h)
+			}
+			return nil
+		}
+		if !less(h.items[0].KV, KV{key, val}) {
+			return nil
+		}
+		min := &h.items[0]
+		min.Key = append(min.Key[:0], key...)
+		min.Val = append(min.Val[:0], val...)
+		min.seq = h.seq
+		h.seq++
+		heap.Fix(h, 0)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(sort.Reverse(h))
+	items := make([]KV, len(h.items))
+	for i := range h.items {
+		items[i] = h.items[i].KV
+	}
+	return items, nil
+}
+
+type topKItem struct {
+	KV
+	seq uint64 // order of the item in the range
+}
+
+// topKHeap is a min-heap of the greatest items seen, where of two items
+// comparing equal the later one is smaller, being the first to go.
+type topKHeap struct {
+	items []topKItem
+	less  func(a, b KV) bool
+	seq   uint64
+}
+
+func (h *topKHeap) Len() int      { return len(h.items) }
+func (h *topKHeap) Swap(i, j int) { h.items[i], h.items[j] = h.items[j], h.items[i] }
+
+func (h *topKHeap) Less(i, j int) bool {
+	a, b := &h.items[i], &h.items[j]
+	if h.less(a.KV, b.KV) {
+		return true
+	}
+	if h.less(b.KV, a.KV) {
+		return false
+	}
+	return a.seq > b.seq
+}
+
+// Push and Pop are not used, the heap being filled before heap.Init.
+func (h *topKHeap) Push(x interface{}) { h.items = append(h.items, x.(topKItem)) }
+
+func (h *topKHeap) Pop() interface{} {
+	x := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return x
+}
+
+// AggGroup is the aggregate of a group of items, see Txn.GroupBy.
+type AggGroup struct {
+	Key   []byte
+	Count int
+	Sum   float64 // of the values decoded, if any
+}
+
+// KeyPrefix returns a grouping function for Txn.GroupBy mapping keys to
+// their first n bytes, or whole if shorter.
+func KeyPrefix(n int) func(key []byte) []byte {
+	return func(key []byte) []byte {
+		if len(key) > n {
+			return key[:n]
+		}
+		return key
+	}
+}
+
+// GroupBy aggregates the items of r in dbi by the group keys returned by
+// group for their keys, such as KeyPrefix, counting them and summing the
+// values decoded by value if not nil.  The groups are returned in key
+// order; group must map consecutive keys to consecutive groups, as a prefix
+// does, or a group split by others is returned several times.  group may
+// return a slice of its argument, which is copied once per group.
+func (txn *Txn) GroupBy(dbi DBI, r AggRange, group func(key []byte) []byte, value AggValue) ([]AggGroup, error) {
+	var groups []AggGroup
+	err := txn.Fold(dbi, r, func(k, v []byte) error {
+		g := group(k)
+		n := len(groups)
+		if n == 0 || !bytes.Equal(groups[n-1].Key, g) {
+			groups = append(groups, AggGroup{Key: append([]byte{}, g...)})
+			n++
+		}
+		cur := &groups[n-1]
+		cur.Count++
+		if value != nil {
+			x, err := value(k, v)
+			if err != nil {
+				return err
+			}
+			cur.Sum += x
+		}
+		return nil
+	})
+	return groups, err
+}
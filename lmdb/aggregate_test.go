@@ -0,0 +1,249 @@
+package lmdb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"testing"
+)
+
+// setupAgg fills a database with keys "a000".."a099" and "b000".."b049", each
+// valued by its number, and a DupSort database with 3 values per key.
+func setupAgg(t *testing.T, env *Env) (dbi, dups DBI) {
+	err := env.Update(func(txn *Txn) (err error) {
+		dbi, err = txn.OpenDBI("agg", Create)
+		if err != nil {
+			return err
+		}
+		dups, err = txn.OpenDBI("aggdups", Create|DupSort)
+		if err != nil {
+			return err
+		}
+		for _, g := range []struct {
+			prefix string
+			n      int
+		}{{"a", 100}, {"b", 50}} {
+			for i := 0; i < g.n; i++ {
+				k := []byte(fmt.Sprintf("%s%03d", g.prefix, i))
+				if err = txn.Put(dbi, k, []byte(strconv.Itoa(i)), 0); err != nil {
+					return err
+				}
+				for j := 0; j < 3; j++ {
+					if err = txn.Put(dups, k, []byte{byte(j)}, 0); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dbi, dups
+}
+
+func parseAggValue(k, v []byte) (float64, error) {
+	return strconv.ParseFloat(string(v), 64)
+}
+
+func TestTxn_Fold(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+	dbi, _ := setupAgg(t, env)
+
+	for _, batch := range []int{0, 1, 7, 1000} {
+		err := env.View(func(txn *Txn) error {
+			var keys []string
+			err := txn.Fold(dbi, AggRange{Start: []byte("a095"), End: []byte("b003"), Batch: batch}, func(k, v []byte) error {
+				keys = append(keys, string(k))
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			want := "[a095 a096 a097 a098 a099 b000 b001 b002]"
+			if fmt.Sprint(keys) != want {
+				t.Errorf("batch %d: keys %v, want %s", batch, keys, want)
+			}
+
+			n := 0
+			err = txn.Fold(dbi, AggRange{Batch: batch}, func(k, v []byte) error {
+				n++
+				return nil
+			})
+			if err != nil || n != 150 {
+				t.Errorf("batch %d: %d items (%v), want 150", batch, n, err)
+			}
+
+			errStop := errors.New("stop")
+			n = 0
+			err = txn.Fold(dbi, AggRange{Batch: batch}, func(k, v []byte) error {
+				n++
+				if n == 10 {
+					return errStop
+				}
+				return nil
+			})
+			if err != errStop || n != 10 {
+				t.Errorf("batch %d: stopped after %d items with %v", batch, n, err)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestTxn_Count(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+	dbi, dups := setupAgg(t, env)
+
+	err := env.View(func(txn *Txn) error {
+		for _, test := range []struct {
+			db   DBI
+			r    AggRange
+			want int
+		}{
+			{dbi, AggRange{}, 150},
+			{dbi, AggRange{Start: []byte("b")}, 50},
+			{dbi, AggRange{End: []byte("b")}, 100},
+			{dbi, AggRange{Start: []byte("a010"), End: []byte("a020")}, 10},
+			{dbi, AggRange{Start: []byte("c")}, 0},
+			{dbi, AggRange{End: []byte{}}, 0},
+			{dups, AggRange{}, 450},
+			{dups, AggRange{Start: []byte("a098"), End: []byte("b001")}, 9},
+		} {
+			n, err := txn.Count(test.db, test.r)
+			if err != nil {
+				return err
+			}
+			if n != test.want {
+				t.Errorf("count %q..%q = %d, want %d", test.r.Start, test.r.End, n, test.want)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTxn_Sum(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+	dbi, _ := setupAgg(t, env)
+
+	err := env.View(func(txn *Txn) error {
+		sum, err := txn.Sum(dbi, AggRange{End: []byte("b"), Batch: 16}, parseAggValue)
+		if err != nil {
+			return err
+		}
+		if sum != 4950 {
+			t.Errorf("sum %v, want 4950", sum)
+		}
+
+		_, err = txn.Sum(dbi, AggRange{}, func(k, v []byte) (float64, error) {
+			return strconv.ParseFloat(string(k), 64)
+		})
+		if err == nil {
+			t.Errorf("no error decoding keys")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTxn_TopK(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+	dbi, _ := setupAgg(t, env)
+
+	byVal := func(a, b KV) bool {
+		x, _ := strconv.Atoi(string(a.Val))
+		y, _ := strconv.Atoi(string(b.Val))
+		return x < y
+	}
+	err := env.View(func(txn *Txn) error {
+		top, err := txn.TopK(dbi, AggRange{Batch: 32}, 5, byVal)
+		if err != nil {
+			return err
+		}
+		var keys []string
+		for _, kv := range top {
+			keys = append(keys, string(kv.Key))
+		}
+		want := "[a099 a098 a097 a096 a095]"
+		if fmt.Sprint(keys) != want {
+			t.Errorf("top keys %v, want %s", keys, want)
+		}
+
+		top, err = txn.TopK(dbi, AggRange{Start: []byte("a005"), End: []byte("a011")}, 2, byVal)
+		if err != nil {
+			return err
+		}
+		if len(top) != 2 || string(top[0].Key) != "a010" || string(top[1].Key) != "a009" {
+			t.Errorf("top %q", top)
+		}
+		top, err = txn.TopK(dbi, AggRange{Start: []byte("a010"), End: []byte("a011")}, 3, byVal)
+		if err != nil {
+			return err
+		}
+		if len(top) != 1 || string(top[0].Val) != "10" {
+			t.Errorf("top of a single item %q", top)
+		}
+
+		ties := func(a, b KV) bool { return false }
+		top, err = txn.TopK(dbi, AggRange{}, 3, ties)
+		if err != nil {
+			return err
+		}
+		if len(top) != 3 || string(top[0].Key) != "a000" || string(top[2].Key) != "a002" {
+			t.Errorf("ties %q", top)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTxn_GroupBy(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+	dbi, dups := setupAgg(t, env)
+
+	err := env.View(func(txn *Txn) error {
+		groups, err := txn.GroupBy(dbi, AggRange{}, KeyPrefix(1), parseAggValue)
+		if err != nil {
+			return err
+		}
+		want := []AggGroup{{[]byte("a"), 100, 4950}, {[]byte("b"), 50, 1225}}
+		if len(groups) != len(want) {
+			t.Fatalf("groups %+v", groups)
+		}
+		for i := range want {
+			g := groups[i]
+			if !bytes.Equal(g.Key, want[i].Key) || g.Count != want[i].Count || g.Sum != want[i].Sum {
+				t.Errorf("group %d: %+v, want %+v", i, g, want[i])
+			}
+		}
+
+		groups, err = txn.GroupBy(dups, AggRange{Start: []byte("a09"), Batch: 10}, KeyPrefix(3), nil)
+		if err != nil {
+			return err
+		}
+		if len(groups) != 6 || string(groups[0].Key) != "a09" || groups[0].Count != 30 || groups[5].Count != 30 {
+			t.Errorf("dup groups %+v", groups)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
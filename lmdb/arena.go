@@ -0,0 +1,53 @@
+package lmdb
+
+/*
+#include "lmdb.h"
+*/
+import "C"
+
+// arenaMinChunk and arenaMaxChunk bound the size of chunks allocated by an
+// arena, values larger than arenaMaxChunk get a chunk of their own.
+const (
+	arenaMinChunk = 4 << 10
+	arenaMaxChunk = 1 << 20
+)
+
+// arena is a grow-only allocator for value copies made by a single Txn.
+// Instead of allocating a new slice for every value read, values are copied
+// into large chunks which are released together when the transaction
+// terminates.  An arena is not safe for concurrent use, its accesses are
+// serialized by the transaction that owns it.
+type arena struct {
+	chunk []byte // the chunk currently being filled
+	next  int    // size of the next chunk to allocate
+}
+
+func newArena(size int) *arena {
+	if size < arenaMinChunk {
+		size = arenaMinChunk
+	}
+	return &arena{next: size}
+}
+
+// copy returns a copy of the data referenced by val that was allocated from
+// the arena.  The returned slice has its capacity clipped so that appending
+// to it cannot overwrite other values in the arena.
+func (a *arena) copy(val *C.MDB_val) []byte {
+	n := int(val.mv_size)
+	if n == 0 {
+		return []byte{}
+	}
+	if n > cap(a.chunk)-len(a.chunk) {
+		size := a.next
+		if size < n {
+			size = n
+		}
+		a.chunk = make([]byte, 0, size)
+		if a.next < arenaMaxChunk {
+			a.next *= 2
+		}
+	}
+	off := len(a.chunk)
+	a.chunk = append(a.chunk, getBytes(val)...)
+	return a.chunk[off : off+n : off+n]
+}
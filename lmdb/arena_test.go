@@ -0,0 +1,72 @@
+package lmdb
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestTxn_UseArena(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+
+	db, err := openRoot(env, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const n = 1000
+	err = env.Update(func(txn *Txn) (err error) {
+		for i := 0; i < n; i++ {
+			err = txn.Put(db, []byte(fmt.Sprintf("k%04d", i)), []byte(fmt.Sprintf("value-%d", i)), 0)
+			if err != nil {
+				return err
+			}
+		}
+		return txn.Put(db, []byte("big"), make([]byte, 16*arenaMinChunk), 0)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = env.View(func(txn *Txn) (err error) {
+		txn.UseArena(0)
+		vals := make([][]byte, n)
+		for i := range vals {
+			vals[i], err = txn.Get(db, []byte(fmt.Sprintf("k%04d", i)))
+			if err != nil {
+				return err
+			}
+		}
+		for i, v := range vals {
+			expect := fmt.Sprintf("value-%d", i)
+			if string(v) != expect {
+				t.Errorf("unexpected value: %q (!= %q)", v, expect)
+			}
+			if cap(v) != len(v) {
+				t.Errorf("unexpected capacity: %d (!= %d)", cap(v), len(v))
+			}
+		}
+		big, err := txn.Get(db, []byte("big"))
+		if err != nil {
+			return err
+		}
+		if len(big) != 16*arenaMinChunk {
+			t.Errorf("unexpected length: %d", len(big))
+		}
+
+		key := []byte("k0001")
+		allocs := testing.AllocsPerRun(100, func() {
+			_, err := txn.Get(db, key)
+			if err != nil {
+				t.Error(err)
+			}
+		})
+		if unsafeViews && allocs != 0 {
+			t.Errorf("unexpected allocations per Get: %v", allocs)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,419 @@
+package lmdb
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// The audit log encoding records the metadata of the commits of an
+// environment, in files kept outside of it.  The layout of a file is
+//
+//	magic    "LMAU"
+//	version  1 byte
+//	seed     32 bytes
+//	records  until EOF:
+//		length   uvarint
+//		payload  length bytes
+//		digest   32 bytes
+//
+// The payload of a record is the transaction id, the commit time in Unix
+// nanoseconds, the numbers of puts, deletions and range deletions (each a
+// uvarint), the SHA-256 hash of the changes encoded as a changeset (32
+// bytes), and the principal (uvarint length + bytes).  The digest of a record
+// is the SHA-256 hash of the digest of the previous record followed by the
+// payload, the seed standing for the digest of the record preceding the first
+// one, which is the digest of the last record of the previous file of the
+// log, or zeros.  The chain of digests detects records modified, removed or
+// reordered, within a file and across rotations.
+const (
+	auditMagic   = "LMAU"
+	auditVersion = 1
+)
+
+var (
+	errAuditMagic  = errors.New("audit log: bad magic")
+	errAuditDigest = errors.New("audit log: digest mismatch")
+)
+
+// AuditOptions configures an AuditLog.  The zero value selects the defaults
+// described for each field.
+type AuditOptions struct {
+	// MaxSize is the size in bytes beyond which the log file is rotated,
+	// 64MiB if zero.
+	MaxSize int64
+
+	// MaxFiles is the number of rotated files kept, the oldest being
+	// removed.  If zero every rotated file is kept.
+	MaxFiles int
+
+	// Principal is the label recorded as the principal of a commit, see
+	// Env.UpdateContext, "principal" if empty.
+	Principal string
+
+	// Sync flushes the log file to disk after each record.
+	Sync bool
+}
+
+// AuditRecord is a record of an audit log, describing a commit.
+type AuditRecord struct {
+	TxnID     uint64
+	Time      time.Time
+	Puts      int
+	Dels      int
+	Drops     int      // range deletions and Txn.Drop
+	Hash      [32]byte // SHA-256 of the changes encoded as a changeset
+	Principal string
+}
+
+// AuditLog records the metadata of the commits of an environment in an
+// append-only file, see Env.OpenAuditLog.
+type AuditLog struct {
+	env  *Env
+	path string
+	opts AuditOptions
+	sub  *Subscription
+	done chan struct{}
+
+	f    *os.File
+	size int64
+	prev [32]byte
+	buf  []byte
+
+	mu      sync.Mutex
+	records uint64
+	err     error
+}
+
+// OpenAuditLog starts recording the commits of env to the file at path, an
+// independent audit trail for environments whose changes must be accounted
+// for outside the database itself.  Each commit with changes is recorded as
+// its transaction id, time, operation counts, a hash of its changes and its
+// principal, but not the changes themselves, see Env.Trace for those.
+// Records are checksummed and chained by their digests, see ReadAuditLog.
+//
+// An existing log at path is appended to, its chain of digests being
+// verified first, and OpenAuditLog fails if it is damaged.  When the file
+// exceeds opts.MaxSize it is renamed with a timestamp suffix and a new one
+// started.  Commits are recorded in the background, in commit order, as they
+// are published to subscriptions, and writers wait when recording falls
+// behind, so that no commit escapes the log while it is open.  The log must
+// be closed.
+func (env *Env) OpenAuditLog(path string, opts *AuditOptions) (*AuditLog, error) {
+	a := &AuditLog{env: env, path: path, done: make(chan struct{})}
+	if opts != nil {
+		a.opts = *opts
+	}
+	if a.opts.MaxSize <= 0 {
+		a.opts.MaxSize = 64 << 20
+	}
+	if a.opts.Principal == "" {
+		a.opts.Principal = "principal"
+	}
+	err := a.open()
+	if err != nil {
+		return nil, err
+	}
+	sub, err := env.Subscribe(&SubscribeOptions{Overflow: OverflowBlock})
+	if err != nil {
+		a.f.Close()
+		return nil, err
+	}
+	a.sub = sub
+	done, ok := env.register("auditlog", func() { sub.Close() })
+	if !ok {
+		sub.Close()
+		a.f.Close()
+		return nil, errGoClosed
+	}
+	go func() {
+		defer done()
+		a.run()
+	}()
+	return a, nil
+}
+
+// open opens the log file, creating it if needed, and positions the chain
+// after its last record.
+func (a *AuditLog) open() error {
+	f, err := os.OpenFile(a.path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if fi.Size() == 0 {
+		err = a.start(f, [32]byte{})
+		if err != nil {
+			f.Close()
+		}
+		return err
+	}
+	last, err := readAuditFile(f, nil)
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("audit log %s: %v", a.path, err)
+	}
+	a.f, a.size, a.prev = f, fi.Size(), last
+	return nil
+}
+
+// start writes the header of the empty file f, seeded with prev.
+func (a *AuditLog) start(f *os.File, prev [32]byte) error {
+	hdr := append([]byte(auditMagic), auditVersion)
+	hdr = append(hdr, prev[:]...)
+	_, err := f.Write(hdr)
+	if err != nil {
+		return err
+	}
+	a.f, a.size, a.prev = f, int64(len(hdr)), prev
+	return nil
+}
+
+func (a *AuditLog) run() {
+	defer close(a.done)
+	for {
+		ev, err := a.sub.Next(context.Background())
+		if err == ErrSubscriptionClosed {
+			a.setErr(a.f.Close())
+			return
+		}
+		if err == nil {
+			err = a.record(&ev)
+		}
+		if err != nil {
+			a.setErr(err)
+			a.sub.Close()
+		}
+	}
+}
+
+func (a *AuditLog) setErr(err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.err == nil {
+		a.err = err
+	}
+}
+
+// record appends the record of the commit ev, rotating the file first if it
+// is full.
+func (a *AuditLog) record(ev *Event) error {
+	if a.size >= a.opts.MaxSize {
+		err := a.rotate()
+		if err != nil {
+			return err
+		}
+	}
+	rec := AuditRecord{TxnID: uint64(ev.TxnID), Time: ev.Time, Principal: ev.Labels[a.opts.Principal]}
+	names := make(map[DBI]string)
+	for _, op := range ev.Ops {
+		switch op.Type {
+		case BatchPut:
+			rec.Puts++
+		case BatchDel:
+			rec.Dels++
+		case BatchDropRange:
+			rec.Drops++
+		}
+		if n, ok := a.env.dbiName(op.DBI); ok {
+			names[op.DBI] = n.name
+		} else {
+			names[op.DBI] = "#" + strconv.Itoa(int(op.DBI))
+		}
+	}
+	cs, err := ev.Batch().Marshal(names)
+	if err != nil {
+		return err
+	}
+	rec.Hash = sha256.Sum256(cs)
+
+	payload := rec.marshal()
+	h := sha256.New()
+	h.Write(a.prev[:])
+	h.Write(payload)
+	h.Sum(a.prev[:0])
+	a.buf = appendChunk(a.buf[:0], payload)
+	a.buf = append(a.buf, a.prev[:]...)
+	_, err = a.f.Write(a.buf)
+	if err != nil {
+		return err
+	}
+	a.size += int64(len(a.buf))
+	if a.opts.Sync {
+		err = a.f.Sync()
+		if err != nil {
+			return err
+		}
+	}
+	a.mu.Lock()
+	a.records++
+	a.mu.Unlock()
+	return nil
+}
+
+func (rec *AuditRecord) marshal() []byte {
+	buf := appendUvarint(nil, rec.TxnID)
+	buf = appendUvarint(buf, uint64(rec.Time.UnixNano()))
+	buf = appendUvarint(buf, uint64(rec.Puts))
+	buf = appendUvarint(buf, uint64(rec.Dels))
+	buf = appendUvarint(buf, uint64(rec.Drops))
+	buf = append(buf, rec.Hash[:]...)
+	return appendChunk(buf, []byte(rec.Principal))
+}
+
+// rotate renames the full log file with a timestamp suffix, removes the
+// oldest rotated files beyond MaxFiles and starts a new file, seeded with
+// the digest of the last record.
+func (a *AuditLog) rotate() error {
+	err := a.f.Sync()
+	if err == nil {
+		err = a.f.Close()
+	}
+	if err != nil {
+		return err
+	}
+	rotated := a.path + "." + time.Now().UTC().Format("20060102T150405.000000000")
+	err = os.Rename(a.path, rotated)
+	if err != nil {
+		return err
+	}
+	if a.opts.MaxFiles > 0 {
+		old, err := filepath.Glob(a.path + ".*")
+		if err != nil {
+			return err
+		}
+		sort.Strings(old)
+		for len(old) > a.opts.MaxFiles {
+			err = os.Remove(old[0])
+			if err != nil {
+				return err
+			}
+			old = old[1:]
+		}
+	}
+	f, err := os.OpenFile(a.path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	err = a.start(f, a.prev)
+	if err != nil {
+		f.Close()
+	}
+	return err
+}
+
+// Records returns the number of commits recorded since the log was opened.
+func (a *AuditLog) Records() uint64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.records
+}
+
+// Err returns the error that stopped the recording, if any.
+func (a *AuditLog) Err() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.err
+}
+
+// Close stops recording, waits for the commits already published to be
+// recorded and closes the log file.  It returns the error that stopped the
+// recording, if any.
+func (a *AuditLog) Close() error {
+	a.sub.Close()
+	<-a.done
+	return a.Err()
+}
+
+// ReadAuditLog calls fn with every record of the audit log file read from r,
+// in order, verifying the chain of digests.  It returns the seed of the file
+// and the digest of its last record, which is the seed of the file rotated
+// after it, so that the files of a log may be checked to follow each other.
+// It fails for a record whose digest does not match, and stops at the first
+// error of fn, returning it.
+func ReadAuditLog(r io.Reader, fn func(rec AuditRecord) error) (seed, last [32]byte, err error) {
+	seed, err = readAuditHeader(r)
+	if err != nil {
+		return seed, seed, err
+	}
+	last, err = readAuditRecords(r, seed, fn)
+	return seed, last, err
+}
+
+func readAuditFile(r io.Reader, fn func(rec AuditRecord) error) ([32]byte, error) {
+	_, last, err := ReadAuditLog(r, fn)
+	return last, err
+}
+
+func readAuditHeader(r io.Reader) (seed [32]byte, err error) {
+	hdr := make([]byte, len(auditMagic)+1+len(seed))
+	_, err = io.ReadFull(r, hdr)
+	if err == io.ErrUnexpectedEOF || err == io.EOF || (err == nil && string(hdr[:len(auditMagic)]) != auditMagic) {
+		return seed, errAuditMagic
+	}
+	if err != nil {
+		return seed, err
+	}
+	if v := hdr[len(auditMagic)]; v != auditVersion {
+		return seed, fmt.Errorf("audit log: unsupported version %d", v)
+	}
+	copy(seed[:], hdr[len(auditMagic)+1:])
+	return seed, nil
+}
+
+func readAuditRecords(r io.Reader, prev [32]byte, fn func(rec AuditRecord) error) ([32]byte, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return prev, err
+	}
+
+	cr := changesetReader{data: data}
+	for len(cr.data) > 0 {
+		payload := cr.chunk()
+		digest := cr.bytes(len(prev))
+		if cr.err != nil {
+			return prev, cr.err
+		}
+		h := sha256.New()
+		h.Write(prev[:])
+		h.Write(payload)
+		if !bytes.Equal(h.Sum(nil), digest) {
+			return prev, errAuditDigest
+		}
+		copy(prev[:], digest)
+		if fn == nil {
+			continue
+		}
+		pr := changesetReader{data: payload}
+		var rec AuditRecord
+		rec.TxnID = pr.uvarint()
+		rec.Time = time.Unix(0, int64(pr.uvarint()))
+		rec.Puts = int(pr.uvarint())
+		rec.Dels = int(pr.uvarint())
+		rec.Drops = int(pr.uvarint())
+		copy(rec.Hash[:], pr.bytes(len(rec.Hash)))
+		rec.Principal = string(pr.chunk())
+		if pr.err != nil {
+			return prev, pr.err
+		}
+		err = fn(rec)
+		if err != nil {
+			return prev, err
+		}
+	}
+	return prev, nil
+}
@@ -0,0 +1,179 @@
+package lmdb
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+)
+
+func TestEnv_OpenAuditLog(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+	dir, err := ioutil.TempDir("", "mdb_audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+
+	var dbi DBI
+	err = env.Update(func(txn *Txn) (err error) {
+		dbi, err = txn.OpenDBI("accounts", Create)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	commit := func(n int) {
+		ctx := WithLabels(context.Background(), Labels{"principal": "alice"})
+		for i := 0; i < n; i++ {
+			err := env.UpdateContext(ctx, func(txn *Txn) error {
+				k := []byte(fmt.Sprintf("k%03d", i))
+				if err := txn.Put(dbi, k, k, 0); err != nil {
+					return err
+				}
+				return txn.Put(dbi, []byte("last"), k, 0)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	a, err := env.OpenAuditLog(path, &AuditOptions{MaxSize: 512})
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit(20)
+	if err = a.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// reopening continues the chain.
+	a, err = env.OpenAuditLog(path, &AuditOptions{MaxSize: 512})
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit(5)
+	if err = a.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := filepath.Glob(path + ".*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("log not rotated")
+	}
+	sort.Strings(files)
+	files = append(files, path)
+
+	var prev [32]byte
+	var lastID uint64
+	n := 0
+	for _, name := range files {
+		f, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		seed, last, err := ReadAuditLog(f, func(rec AuditRecord) error {
+			if rec.TxnID <= lastID || rec.Puts != 2 || rec.Principal != "alice" {
+				t.Errorf("unexpected record %+v", rec)
+			}
+			lastID = rec.TxnID
+			n++
+			return nil
+		})
+		f.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if seed != prev {
+			t.Errorf("%s does not follow the previous file", name)
+		}
+		prev = last
+	}
+	if n != 25 {
+		t.Errorf("%d records", n)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-40] ^= 1
+	_, _, err = ReadAuditLog(bytes.NewReader(data), nil)
+	if err != errAuditDigest {
+		t.Errorf("tampered log: %v", err)
+	}
+}
+
+func TestEnv_OpenAuditLog_concurrent(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+	dir, err := ioutil.TempDir("", "mdb_audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+
+	dbi, err := openRoot(env, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := env.OpenAuditLog(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const writers, updates = 8, 250
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < updates; i++ {
+				err := env.Update(func(txn *Txn) error {
+					return txn.Put(dbi, []byte{byte(w)}, []byte(fmt.Sprint(i)), 0)
+				})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	if err = a.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var lastID uint64
+	n := 0
+	_, _, err = ReadAuditLog(f, func(rec AuditRecord) error {
+		if rec.TxnID <= lastID {
+			return fmt.Errorf("txn %d recorded after txn %d", rec.TxnID, lastID)
+		}
+		lastID = rec.TxnID
+		n++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != writers*updates {
+		t.Errorf("%d records", n)
+	}
+}
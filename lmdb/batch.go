@@ -0,0 +1,194 @@
+package lmdb
+
+import (
+	"bytes"
+	"errors"
+)
+
+var errBatchOpType = errors.New("unknown batch operation type")
+
+// BatchOpType identifies the kind of an operation stored in a WriteBatch.
+type BatchOpType byte
+
+// The operations that may be recorded in a WriteBatch.
+const (
+	BatchPut       BatchOpType = iota + 1 // Store Key/Val with Flags.
+	BatchDel                              // Delete Key (and Val for DupSort databases).
+	BatchDropRange                        // Delete all keys in [Key, End).
+)
+
+// BatchOp is a single operation recorded in a WriteBatch.
+type BatchOp struct {
+	Type  BatchOpType
+	DBI   DBI
+	Key   []byte
+	Val   []byte
+	End   []byte // exclusive upper bound of a BatchDropRange, nil for no bound
+	Flags uint
+}
+
+// WriteBatch is a builder for a list of writes, possibly spanning several
+// databases, that are applied atomically in a single transaction with
+// Env.Apply or Txn.Apply.  The zero value is an empty batch ready to use.
+//
+// A WriteBatch keeps its own copies of the keys and values given to it, so
+// callers may reuse their buffers.  A batch is not modified by being applied
+// and may be applied any number of times, or cleared with Reset and refilled.
+// A WriteBatch is not safe for concurrent use.
+type WriteBatch struct {
+	ops []BatchOp
+}
+
+// NewWriteBatch returns an empty WriteBatch.
+func NewWriteBatch() *WriteBatch {
+	return &WriteBatch{}
+}
+
+// Put records a Txn.Put of key and val in dbi.
+func (b *WriteBatch) Put(dbi DBI, key, val []byte) *WriteBatch {
+	return b.PutFlags(dbi, key, val, 0)
+}
+
+// PutFlags records a Txn.Put of key and val in dbi with the given flags.
+func (b *WriteBatch) PutFlags(dbi DBI, key, val []byte, flags uint) *WriteBatch {
+	b.ops = append(b.ops, BatchOp{
+		Type:  BatchPut,
+		DBI:   dbi,
+		Key:   cloneBytes(key),
+		Val:   cloneBytes(val),
+		Flags: flags,
+	})
+	return b
+}
+
+// Del records a Txn.Del of key in dbi.  As with Txn.Del, val is ignored
+// unless dbi has the DupSort flag.  Deleting a key that does not exist is not
+// an error when the batch is applied.
+func (b *WriteBatch) Del(dbi DBI, key, val []byte) *WriteBatch {
+	b.ops = append(b.ops, BatchOp{
+		Type: BatchDel,
+		DBI:  dbi,
+		Key:  cloneBytes(key),
+		Val:  cloneBytes(val),
+	})
+	return b
+}
+
+// DropRange records the deletion of every item in dbi with a key greater than
+// or equal to start and less than end.  An empty start begins at the first
+// key and a nil end continues through the last key.
+func (b *WriteBatch) DropRange(dbi DBI, start, end []byte) *WriteBatch {
+	b.ops = append(b.ops, BatchOp{
+		Type: BatchDropRange,
+		DBI:  dbi,
+		Key:  cloneBytes(start),
+		End:  cloneBytes(end),
+	})
+	return b
+}
+
+// Len returns the number of operations recorded in b.
+func (b *WriteBatch) Len() int {
+	return len(b.ops)
+}
+
+// Ops returns the operations recorded in b, in order.  The returned slice and
+// the byte slices it references must not be modified.
+func (b *WriteBatch) Ops() []BatchOp {
+	return b.ops
+}
+
+// Reset removes all operations from b so that it may be reused.
+func (b *WriteBatch) Reset() {
+	for i := range b.ops {
+		b.ops[i] = BatchOp{}
+	}
+	b.ops = b.ops[:0]
+}
+
+// Apply performs the operations in b within txn, in the order they were
+// recorded.  Apply stops at the first failing operation and returns its
+// error; the caller decides whether to abort txn.
+func (txn *Txn) Apply(b *WriteBatch) error {
+	for i := range b.ops {
+		err := txn.applyOp(&b.ops[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (txn *Txn) applyOp(op *BatchOp) error {
+	switch op.Type {
+	case BatchPut:
+		return txn.Put(op.DBI, op.Key, op.Val, op.Flags)
+	case BatchDel:
+		err := txn.Del(op.DBI, op.Key, op.Val)
+		if IsNotFound(err) {
+			return nil
+		}
+		return err
+	case BatchDropRange:
+		return txn.dropRange(op.DBI, op.Key, op.End)
+	}
+	return errBatchOpType
+}
+
+// dropRange deletes the items in dbi with keys in [start, end).
+func (txn *Txn) dropRange(dbi DBI, start, end []byte) error {
+	_, err := txn.dropRangeLimit(dbi, start, end, 0)
+	return err
+}
+
+// dropRangeLimit deletes at most limit keys (all keys if limit is not
+// positive) in dbi within [start, end), each with all of its values, and
+// returns the number of keys deleted.
+func (txn *Txn) dropRangeLimit(dbi DBI, start, end []byte, limit int) (n int, err error) {
+	cur, err := txn.OpenCursor(dbi)
+	if err != nil {
+		return 0, err
+	}
+	defer cur.Close()
+
+	var k []byte
+	if len(start) == 0 {
+		k, _, err = cur.Get(nil, nil, First)
+	} else {
+		k, _, err = cur.Get(start, nil, SetRange)
+	}
+	for err == nil && (limit <= 0 || n < limit) {
+		if end != nil && bytes.Compare(k, end) >= 0 {
+			return n, nil
+		}
+		err = cur.Del(NoDupData)
+		if err != nil {
+			return n, err
+		}
+		n++
+		// After a deletion the cursor already references the following
+		// item, which MDB_NEXT will return.
+		k, _, err = cur.Get(nil, nil, Next)
+	}
+	if err == nil || IsNotFound(err) {
+		return n, nil
+	}
+	return n, err
+}
+
+// Apply applies the operations in b atomically in a new Update transaction.
+// Either every operation in b is committed or none are.
+func (env *Env) Apply(b *WriteBatch) error {
+	return env.Update(func(txn *Txn) error {
+		return txn.Apply(b)
+	})
+}
+
+func cloneBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	p := make([]byte, len(b))
+	copy(p, b)
+	return p
+}
@@ -0,0 +1,112 @@
+package lmdb
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestEnv_Apply(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+
+	var db1, db2 DBI
+	err := env.Update(func(txn *Txn) (err error) {
+		db1, err = txn.OpenDBI("batch1", Create)
+		if err != nil {
+			return err
+		}
+		db2, err = txn.OpenDBI("batch2", Create|DupSort)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 10; i++ {
+			err = txn.Put(db1, []byte(fmt.Sprintf("k%d", i)), []byte("v"), 0)
+			if err != nil {
+				return err
+			}
+			err = txn.Put(db2, []byte(fmt.Sprintf("k%d", i)), []byte("a"), 0)
+			if err != nil {
+				return err
+			}
+			err = txn.Put(db2, []byte(fmt.Sprintf("k%d", i)), []byte("b"), 0)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := []byte("new")
+	b := NewWriteBatch()
+	b.Put(db1, key, []byte("value"))
+	key[0] = 'x' // the batch must not alias the caller's memory
+	b.Del(db1, []byte("k0"), nil)
+	b.Del(db1, []byte("missing"), nil)
+	b.Del(db2, []byte("k9"), []byte("a"))
+	b.DropRange(db2, []byte("k2"), []byte("k5"))
+	b.DropRange(db1, []byte("k7"), []byte("k8"))
+	b.DropRange(db2, []byte("k8"), nil)
+	if b.Len() != 7 {
+		t.Errorf("unexpected batch length: %d", b.Len())
+	}
+	err = env.Apply(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = env.View(func(txn *Txn) (err error) {
+		items, err := txn.ScanReverse(db1, nil, 0)
+		if err != nil {
+			return err
+		}
+		expect := []string{"new", "k9", "k8", "k6", "k5", "k4", "k3", "k2", "k1"}
+		if got := kvKeys(items); fmt.Sprint(got) != fmt.Sprint(expect) {
+			t.Errorf("unexpected keys in db1: %q (!= %q)", got, expect)
+		}
+		items, err = txn.ScanReverse(db2, nil, 0)
+		if err != nil {
+			return err
+		}
+		expect = []string{"k7", "k7", "k6", "k6", "k5", "k5", "k1", "k1", "k0", "k0"}
+		if got := kvKeys(items); fmt.Sprint(got) != fmt.Sprint(expect) {
+			t.Errorf("unexpected keys in db2: %q (!= %q)", got, expect)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+
+	// a failing operation must leave the database untouched.
+	b.Reset()
+	b.Put(db1, []byte("k1"), []byte("other"))
+	b.PutFlags(db1, []byte("k2"), []byte("other"), NoOverwrite)
+	err = env.Apply(b)
+	if !IsErrno(err, KeyExist) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	err = env.View(func(txn *Txn) (err error) {
+		v, err := txn.Get(db1, []byte("k1"))
+		if err != nil {
+			return err
+		}
+		if string(v) != "v" {
+			t.Errorf("unexpected value: %q", v)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func kvKeys(items []KV) []string {
+	var keys []string
+	for _, item := range items {
+		keys = append(keys, string(item.Key))
+	}
+	return keys
+}
@@ -0,0 +1,377 @@
+package lmdb
+
+import (
+	"errors"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// ErrBatchWriterClosed is returned for operations submitted to a BatchWriter
+// after Close has been called.
+var ErrBatchWriterClosed = errors.New("batch writer is closed")
+
+// BatchWriterOptions configures a BatchWriter.  The zero value selects the
+// defaults described for each field.
+type BatchWriterOptions struct {
+	// MaxBatch is the maximum number of operations committed together, 256
+	// if zero.
+	MaxBatch int
+
+	// MaxDelay is how long the writer waits for more operations to arrive
+	// once it has received one.  With the default of zero a batch contains
+	// whatever was queued while the previous batch committed.
+	MaxDelay time.Duration
+
+	// QueueSize is the capacity of the queue of pending operations, 1024 if
+	// zero.  Submitting blocks while the queue is full.
+	QueueSize int
+
+	// Quotas, if not nil, admits the writes submitted with EnqueueCharged
+	// and DoCharged, and QuotaPolicy determines what happens to those that
+	// would exceed a quota.  Admission happens on submission, so that an
+	// over-quota write never enters a transaction shared with other writes.
+	Quotas      *Quotas
+	QuotaPolicy QuotaPolicy
+
+	// Coalesce makes the puts submitted with EnqueuePut and DoPut to the
+	// same key of a database within one batch result in a single put, of
+	// the last value submitted or, if Merge is not nil, of the values merged
+	// in submission order.  Setting Merge implies Coalesce.
+	Coalesce bool
+	Merge    MergeFunc
+
+	// RangeLocks serializes the operations submitted with DoLocked, and
+	// may be shared with other code coordinating on the same keys.  A new
+	// RangeLocker is used if nil.
+	RangeLocks *RangeLocker
+
+	// Adaptive, if not nil, makes the writer adapt its batch size and
+	// delay, starting from MaxBatch and MaxDelay, to the latency of its
+	// commits and the depth of its queue, see Stats.
+	Adaptive *AdaptiveBatching
+}
+
+// BatchWriter is a worker goroutine that groups independently submitted
+// update operations into shared transactions, so that many small writes pay
+// for a single commit.  Each operation runs in its own sub-transaction, so a
+// failing operation is rolled back without affecting the rest of its batch.
+// Operations must not depend on running in any particular transaction,
+// except that operations submitted by one goroutine run in order.
+//
+// Because the worker owns its OS thread, operations may be submitted from
+// any goroutine without calling runtime.LockOSThread.
+type BatchWriter struct {
+	coalesced uint64 // atomic; first for alignment
+
+	env   *Env
+	adapt *batchAdapter
+	queue chan *batchWrite
+	done  chan struct{}
+
+	mu     sync.RWMutex
+	closed bool
+
+	quotas      *Quotas
+	quotaPolicy QuotaPolicy
+	wake        chan struct{} // signals deferred writes to an idle worker
+	deferMu     sync.Mutex
+	deferred    []*batchWrite
+
+	coalesce bool
+	merge    MergeFunc
+
+	loadMu sync.Mutex
+	loads  map[loadKey]*loadCall
+
+	locks *RangeLocker
+}
+
+type batchWrite struct {
+	op    TxnOp
+	errc  chan error
+	flush bool
+
+	charges  []Charge
+	reserved bool // charges were admitted by the quotas
+
+	put  *batchPut
+	into int // index in the batch of the put this put was coalesced into
+}
+
+// NewBatchWriter starts a BatchWriter for env.  A nil opts selects the
+// defaults.  The BatchWriter should be closed before env is closed, which
+// otherwise closes it.
+func (env *Env) NewBatchWriter(opts *BatchWriterOptions) *BatchWriter {
+	var o BatchWriterOptions
+	if opts != nil {
+		o = *opts
+	}
+	if o.MaxBatch <= 0 {
+		o.MaxBatch = 256
+	}
+	if o.QueueSize <= 0 {
+		o.QueueSize = 1024
+	}
+	if o.RangeLocks == nil {
+		o.RangeLocks = NewRangeLocker()
+	}
+	w := &BatchWriter{
+		env:   env,
+		adapt: newBatchAdapter(o.MaxBatch, o.MaxDelay, o.Adaptive),
+		queue: make(chan *batchWrite, o.QueueSize),
+		done:  make(chan struct{}),
+
+		quotas:      o.Quotas,
+		quotaPolicy: o.QuotaPolicy,
+		wake:        make(chan struct{}, 1),
+
+		coalesce: o.Coalesce || o.Merge != nil,
+		merge:    o.Merge,
+
+		locks: o.RangeLocks,
+	}
+	done, ok := env.register("batchwriter", func() { w.Close() })
+	if !ok {
+		done = func() {}
+	}
+	go func() {
+		defer done()
+		w.loop()
+	}()
+	return w
+}
+
+// Enqueue submits op and returns immediately.  The returned channel receives
+// the result once the batch containing op has committed: the error returned
+// by op, or the error that prevented the commit.  Callers not interested in
+// the result may ignore the channel.
+func (w *BatchWriter) Enqueue(op TxnOp) <-chan error {
+	return w.submit(&batchWrite{op: op, errc: make(chan error, 1)})
+}
+
+// Do submits op and waits for its result, see Enqueue.
+func (w *BatchWriter) Do(op TxnOp) error {
+	return <-w.Enqueue(op)
+}
+
+// EnqueueCharged is like Enqueue for an operation whose effect on the quotas
+// of the writer is described by charges.  If the writer has quotas and op
+// would exceed one of them, op is refused with a *QuotaError (see
+// ErrQuotaExceeded) or deferred according to BatchWriterOptions.QuotaPolicy.
+// The charges are added to the usage of the quotas once op commits.  A
+// deferred op may run after operations submitted later by the same
+// goroutine.
+func (w *BatchWriter) EnqueueCharged(op TxnOp, charges ...Charge) <-chan error {
+	bw := &batchWrite{op: op, errc: make(chan error, 1), charges: charges}
+	if w.quotas == nil {
+		return w.submit(bw)
+	}
+	err := w.quotas.reserve(charges)
+	if err == nil {
+		bw.reserved = true
+		return w.submit(bw)
+	}
+	if w.quotaPolicy != QuotaDefer {
+		bw.errc <- err
+		return bw.errc
+	}
+	return w.submitDeferred(bw)
+}
+
+// DoCharged submits op with charges and waits for its result, see
+// EnqueueCharged.
+func (w *BatchWriter) DoCharged(op TxnOp, charges ...Charge) error {
+	return <-w.EnqueueCharged(op, charges...)
+}
+
+// Flush waits until every operation submitted before the call, including
+// deferred ones, has been committed.  Flush returns the error of the last
+// commit, if any.
+func (w *BatchWriter) Flush() error {
+	return <-w.submit(&batchWrite{flush: true, errc: make(chan error, 1)})
+}
+
+func (w *BatchWriter) submit(bw *batchWrite) <-chan error {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		if bw.reserved {
+			w.quotas.release(bw.charges)
+		}
+		bw.errc <- ErrBatchWriterClosed
+		return bw.errc
+	}
+	w.queue <- bw
+	return bw.errc
+}
+
+// submitDeferred holds bw until the worker is idle.
+func (w *BatchWriter) submitDeferred(bw *batchWrite) <-chan error {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		bw.errc <- ErrBatchWriterClosed
+		return bw.errc
+	}
+	w.deferMu.Lock()
+	w.deferred = append(w.deferred, bw)
+	w.deferMu.Unlock()
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+	return bw.errc
+}
+
+// Close commits the operations already submitted and stops the worker.
+// Operations submitted after Close fail with ErrBatchWriterClosed.
+func (w *BatchWriter) Close() error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return nil
+	}
+	w.closed = true
+	close(w.queue)
+	w.mu.Unlock()
+	<-w.done
+	return nil
+}
+
+func (w *BatchWriter) loop() {
+	defer close(w.done)
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	maxBatch, _ := w.adapt.limits()
+	batch := make([]*batchWrite, 0, maxBatch)
+	for {
+		closed := false
+		select {
+		case bw, ok := <-w.queue:
+			if !ok {
+				closed = true
+				batch = batch[:0]
+				break
+			}
+			batch = append(batch[:0], bw)
+			batch = w.fill(batch)
+		case <-w.wake:
+			batch = batch[:0]
+		}
+		batch = w.addDeferred(batch, closed)
+		if len(batch) > 0 {
+			start := time.Now()
+			w.commit(batch)
+			w.adapt.observe(len(batch), time.Since(start), len(w.queue))
+		}
+		for i := range batch {
+			batch[i] = nil
+		}
+		if closed && len(batch) == 0 {
+			return
+		}
+	}
+}
+
+// addDeferred adds deferred writes to batch if no other write is queued, or
+// every deferred write before a flush request ending batch or if the writer
+// is closed.  If deferred writes remain the worker is woken up again.
+func (w *BatchWriter) addDeferred(batch []*batchWrite, closed bool) []*batchWrite {
+	w.deferMu.Lock()
+	defer w.deferMu.Unlock()
+	if len(w.deferred) == 0 {
+		return batch
+	}
+	n := len(w.deferred)
+	if len(batch) > 0 && batch[len(batch)-1].flush {
+		flush := batch[len(batch)-1]
+		batch = append(append(batch[:len(batch)-1], w.deferred...), flush)
+	} else if closed || len(w.queue) == 0 {
+		maxBatch, _ := w.adapt.limits()
+		if room := maxBatch - len(batch); n > room {
+			n = room
+		}
+		batch = append(batch, w.deferred[:n]...)
+	} else {
+		n = 0
+	}
+	copy(w.deferred, w.deferred[n:])
+	for i := len(w.deferred) - n; i < len(w.deferred); i++ {
+		w.deferred[i] = nil
+	}
+	w.deferred = w.deferred[:len(w.deferred)-n]
+	if len(w.deferred) > 0 {
+		select {
+		case w.wake <- struct{}{}:
+		default:
+		}
+	}
+	return batch
+}
+
+// fill adds queued operations to batch until it is full, the queue is empty
+// (or MaxDelay has elapsed), or a flush request is reached.
+func (w *BatchWriter) fill(batch []*batchWrite) []*batchWrite {
+	maxBatch, maxDelay := w.adapt.limits()
+	var timeout <-chan time.Time
+	if maxDelay > 0 {
+		timer := time.NewTimer(maxDelay)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	for len(batch) < maxBatch && !batch[len(batch)-1].flush {
+		if timeout == nil {
+			select {
+			case bw, ok := <-w.queue:
+				if !ok {
+					return batch
+				}
+				batch = append(batch, bw)
+			default:
+				return batch
+			}
+			continue
+		}
+		select {
+		case bw, ok := <-w.queue:
+			if !ok {
+				return batch
+			}
+			batch = append(batch, bw)
+		case <-timeout:
+			return batch
+		}
+	}
+	return batch
+}
+
+// commit runs the operations of batch in one update and delivers results.
+func (w *BatchWriter) commit(batch []*batchWrite) {
+	if w.coalesce {
+		w.coalescePuts(batch)
+	}
+	errs := make([]error, len(batch))
+	err := w.env.UpdateLocked(func(txn *Txn) error {
+		for i, bw := range batch {
+			if bw.op != nil {
+				errs[i] = txn.Sub(bw.op)
+			}
+		}
+		return nil
+	})
+	for i := len(batch) - 1; i >= 0; i-- {
+		if batch[i].into > 0 {
+			errs[i] = errs[batch[i].into]
+		} else if errs[i] == nil {
+			errs[i] = err
+		}
+	}
+	for i, bw := range batch {
+		if w.quotas != nil && bw.charges != nil {
+			w.quotas.settle(bw.charges, bw.reserved, errs[i] == nil)
+		}
+		bw.errc <- errs[i]
+	}
+}
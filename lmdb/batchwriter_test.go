@@ -0,0 +1,95 @@
+package lmdb
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBatchWriter(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+
+	dbi, err := openRoot(env, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := env.NewBatchWriter(&BatchWriterOptions{MaxBatch: 8, MaxDelay: time.Millisecond})
+	var wg sync.WaitGroup
+	errc := make(chan error, 40)
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 10; i++ {
+				k := []byte(fmt.Sprintf("k%d-%d", g, i))
+				errc <- w.Do(func(txn *Txn) error {
+					return txn.Put(dbi, k, k, 0)
+				})
+			}
+		}(g)
+	}
+	wg.Wait()
+	close(errc)
+	for err := range errc {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	// a failing operation does not affect the rest of its batch.
+	errFail := errors.New("fail")
+	bad := w.Enqueue(func(txn *Txn) error {
+		err := txn.Put(dbi, []byte("bad"), []byte("bad"), 0)
+		if err != nil {
+			return err
+		}
+		return errFail
+	})
+	good := w.Enqueue(func(txn *Txn) error {
+		return txn.Put(dbi, []byte("good"), []byte("good"), 0)
+	})
+	err = w.Flush()
+	if err != nil {
+		t.Error(err)
+	}
+	if err := <-bad; err != errFail {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := <-good; err != nil {
+		t.Error(err)
+	}
+
+	err = w.Close()
+	if err != nil {
+		t.Error(err)
+	}
+	err = w.Do(func(txn *Txn) error { return nil })
+	if err != ErrBatchWriterClosed {
+		t.Errorf("unexpected error after close: %v", err)
+	}
+
+	err = env.View(func(txn *Txn) error {
+		stat, err := txn.Stat(dbi)
+		if err != nil {
+			return err
+		}
+		if stat.Entries != 41 {
+			t.Errorf("unexpected number of entries: %d", stat.Entries)
+		}
+		ok, err := txn.Has(dbi, []byte("bad"))
+		if err != nil {
+			return err
+		}
+		if ok {
+			t.Errorf("failed operation was committed")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -3,6 +3,7 @@ package lmdb
 import (
 	crand "crypto/rand"
 	"encoding/binary"
+	"fmt"
 	"math/rand"
 	"sync/atomic"
 	"testing"
@@ -93,6 +94,161 @@ func BenchmarkTxn_Put(b *testing.B) {
 	}
 }
 
+// insert batches of variable length duplicates, value by value.
+func BenchmarkCursor_PutDup_loop(b *testing.B) {
+	benchmarkPutDups(b, func(cur *Cursor, key []byte, vals [][]byte) error {
+		for _, v := range vals {
+			err := cur.Put(key, v, 0)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// insert batches of variable length duplicates using PutDupBatch.
+func BenchmarkCursor_PutDupBatch(b *testing.B) {
+	benchmarkPutDups(b, func(cur *Cursor, key []byte, vals [][]byte) error {
+		_, err := cur.PutDupBatch(key, vals, 0)
+		return err
+	})
+}
+
+func benchmarkPutDups(b *testing.B, put func(cur *Cursor, key []byte, vals [][]byte) error) {
+	env := setup(b)
+	defer clean(env, b)
+	err := env.SetMapSize(benchDBMapSize)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	vals := make([][]byte, 50)
+	for i := range vals {
+		vals[i] = []byte(fmt.Sprintf("posting-%d", i*7919))
+	}
+	err = env.Update(func(txn *Txn) (err error) {
+		dbi, err := txn.OpenDBI("benchdups", Create|DupSort)
+		if err != nil {
+			return err
+		}
+		cur, err := txn.OpenCursor(dbi)
+		if err != nil {
+			return err
+		}
+		defer cur.Close()
+		b.ResetTimer()
+		defer b.StopTimer()
+		for i := 0; i < b.N; i++ {
+			err = put(cur, []byte(fmt.Sprint(i%200)), vals)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		b.Error(err)
+	}
+}
+
+// find the 1 in 100 keys with a 9 byte suffix, filtering in Go.
+func BenchmarkCursor_Get_sparse(b *testing.B) {
+	benchmarkSparseScan(b, func(cur *Cursor) (int, error) {
+		n := 0
+		for {
+			k, _, err := cur.Get(nil, nil, Next)
+			if IsNotFound(err) {
+				return n, nil
+			}
+			if err != nil {
+				return n, err
+			}
+			if len(k) == 9 {
+				n++
+			}
+		}
+	})
+}
+
+// find the 1 in 100 keys with a 9 byte suffix using GetMatch.
+func BenchmarkCursor_GetMatch_sparse(b *testing.B) {
+	f := &KeyFilter{MinLen: 9}
+	benchmarkSparseScan(b, func(cur *Cursor) (int, error) {
+		n := 0
+		for {
+			_, _, err := cur.GetMatch(f, Next)
+			if IsNotFound(err) {
+				return n, nil
+			}
+			if err != nil {
+				return n, err
+			}
+			n++
+		}
+	})
+}
+
+func benchmarkSparseScan(b *testing.B, scan func(cur *Cursor) (int, error)) {
+	env := setup(b)
+	defer clean(env, b)
+	err := env.SetMapSize(benchDBMapSize)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	const n = 10000
+	var dbi DBI
+	err = env.Update(func(txn *Txn) (err error) {
+		dbi, err = txn.OpenDBI("benchsparse", Create)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < n; i++ {
+			k := fmt.Sprintf("%08d", i)
+			if i%100 == 0 {
+				k += "x"
+			}
+			err = txn.Put(dbi, []byte(k), []byte("v"), 0)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	err = env.View(func(txn *Txn) (err error) {
+		txn.RawRead = true
+		cur, err := txn.OpenCursor(dbi)
+		if err != nil {
+			return err
+		}
+		defer cur.Close()
+		b.ResetTimer()
+		defer b.StopTimer()
+		for i := 0; i < b.N; i++ {
+			_, _, err = cur.Get(nil, nil, First)
+			if err != nil {
+				return err
+			}
+			found, err := scan(cur)
+			if err != nil {
+				return err
+			}
+			if found != n/100-1 {
+				return fmt.Errorf("found %d keys", found)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		b.Error(err)
+	}
+}
+
 // repeatedly put (overwrite) keys using the PutReserve method.
 func BenchmarkTxn_PutReserve(b *testing.B) {
 	initRandSource(b)
@@ -0,0 +1,151 @@
+package lmdb
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+var errScanPosition = errors.New("malformed scan position")
+
+// ScanPosition is the position at which a bounded scan resumes, see
+// Txn.BoundedScan.  A nil *ScanPosition is the first item of a database.
+type ScanPosition struct {
+	Key []byte
+	Val []byte // used in DupSort databases only
+
+	// After is true for a position following Key (and Val), where a scan
+	// that was interrupted resumes, and false for a position at the first
+	// key greater than or equal to Key, where a scan starts.
+	After bool
+}
+
+// ScanFrom returns the position of the first item with a key greater than
+// or equal to key.
+func ScanFrom(key []byte) *ScanPosition {
+	return &ScanPosition{Key: cloneBytes(key)}
+}
+
+// MarshalBinary encodes pos, e.g. as a continuation token returned to a
+// client.
+func (pos *ScanPosition) MarshalBinary() ([]byte, error) {
+	b := make([]byte, 1, 1+binary.MaxVarintLen64+len(pos.Key)+len(pos.Val))
+	if pos.After {
+		b[0] = 1
+	}
+	b = appendUvarint(b, uint64(len(pos.Key)))
+	b = append(b, pos.Key...)
+	return append(b, pos.Val...), nil
+}
+
+// UnmarshalBinary decodes a position encoded by MarshalBinary.
+func (pos *ScanPosition) UnmarshalBinary(b []byte) error {
+	if len(b) < 1 || b[0] > 1 {
+		return errScanPosition
+	}
+	n, m := binary.Uvarint(b[1:])
+	if m <= 0 || uint64(len(b)-1-m) < n {
+		return errScanPosition
+	}
+	key := b[1+m : 1+m+int(n)]
+	*pos = ScanPosition{
+		Key:   cloneBytes(key),
+		Val:   cloneBytes(b[1+m+int(n):]),
+		After: b[0] == 1,
+	}
+	return nil
+}
+
+// ScanLimits bound a scan made with Txn.BoundedScan.  The zero value does
+// not bound the scan.
+type ScanLimits struct {
+	// Context interrupts the scan when done, if not nil.
+	Context context.Context
+
+	// Deadline interrupts the scan when reached, if not zero.
+	Deadline time.Time
+
+	// MaxItems interrupts the scan after that many items, if positive.
+	MaxItems int
+
+	// CheckEvery is the number of items between checks of Context and
+	// Deadline, 64 if zero, so that checking the clock does not slow down
+	// scans of small items.
+	CheckEvery int
+}
+
+// BoundedScan calls fn for the items of dbi from pos, in key order, until
+// the end of the database or until one of the limits is exceeded, so that
+// request-scoped scans do not hold a transaction, and a reader slot,
+// indefinitely.  If the scan is interrupted BoundedScan returns the position
+// from which to resume it, typically in another transaction, and nil if it
+// reached the end of the database.  Being interrupted is not an error; the
+// error returned is that of fn, which stops the scan, or of the cursor.  A
+// nil limits only stops at the end.
+//
+// The key and value passed to fn are only valid until fn returns if txn has
+// RawRead set.  Items written between the transactions of a resumed scan are
+// seen if they follow the position resumed from.
+func (txn *Txn) BoundedScan(dbi DBI, pos *ScanPosition, limits *ScanLimits, fn func(k, v []byte) error) (*ScanPosition, error) {
+	var lim ScanLimits
+	if limits != nil {
+		lim = *limits
+	}
+	if lim.CheckEvery <= 0 {
+		lim.CheckEvery = 64
+	}
+	flags, err := txn.Flags(dbi)
+	if err != nil {
+		return nil, err
+	}
+	cur, err := txn.OpenCursor(dbi)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close()
+	dupsort := flags&DupSort != 0
+
+	var k, v, last, lastVal []byte
+	switch {
+	case pos == nil || (len(pos.Key) == 0 && !pos.After):
+		k, v, err = cur.Get(nil, nil, First)
+	case pos.After:
+		k, v, err = cur.seekAfter(pos.Key, pos.Val, dupsort)
+	default:
+		k, v, err = cur.Get(pos.Key, nil, SetRange)
+	}
+	for n := 0; err == nil; n++ {
+		if n > 0 && lim.exceeded(n) {
+			next := &ScanPosition{Key: cloneBytes(last), After: true}
+			if dupsort {
+				next.Val = cloneBytes(lastVal)
+			}
+			return next, nil
+		}
+		err = fn(k, v)
+		if err != nil {
+			return nil, err
+		}
+		last, lastVal = k, v
+		k, v, err = cur.Get(nil, nil, Next)
+	}
+	if IsNotFound(err) {
+		return nil, nil
+	}
+	return nil, err
+}
+
+// exceeded returns true if a scan that visited n items must stop.
+func (lim *ScanLimits) exceeded(n int) bool {
+	if lim.MaxItems > 0 && n >= lim.MaxItems {
+		return true
+	}
+	if n%lim.CheckEvery != 0 {
+		return false
+	}
+	if lim.Context != nil && lim.Context.Err() != nil {
+		return true
+	}
+	return !lim.Deadline.IsZero() && !time.Now().Before(lim.Deadline)
+}
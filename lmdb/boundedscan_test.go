@@ -0,0 +1,108 @@
+package lmdb
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestTxn_BoundedScan(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+
+	var dbi DBI
+	err := env.Update(func(txn *Txn) (err error) {
+		dbi, err = txn.OpenDBI("bounded", Create|DupSort)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 10; i++ {
+			for j := 0; j < 3; j++ {
+				err = txn.Put(dbi, []byte(fmt.Sprintf("k%02d", i)), []byte(fmt.Sprintf("v%d", j)), 0)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// resume a scan limited to 7 items per transaction until it completes,
+	// round-tripping the position as a client would.
+	var items []string
+	var pos *ScanPosition
+	for rounds := 0; ; rounds++ {
+		if rounds > 10 {
+			t.Fatal("scan does not complete")
+		}
+		err = env.View(func(txn *Txn) (err error) {
+			pos, err = txn.BoundedScan(dbi, pos, &ScanLimits{MaxItems: 7}, func(k, v []byte) error {
+				items = append(items, string(k)+"="+string(v))
+				return nil
+			})
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pos == nil {
+			break
+		}
+		b, err := pos.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		pos = new(ScanPosition)
+		err = pos.UnmarshalBinary(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(items) != 30 || items[0] != "k00=v0" || items[7] != "k02=v1" || items[29] != "k09=v2" {
+		t.Errorf("items: %q", items)
+	}
+
+	// an expired context stops the scan at the first check.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = env.View(func(txn *Txn) (err error) {
+		n := 0
+		pos, err = txn.BoundedScan(dbi, ScanFrom([]byte("k05")), &ScanLimits{Context: ctx, CheckEvery: 4}, func(k, v []byte) error {
+			n++
+			return nil
+		})
+		if n != 4 {
+			t.Errorf("%d items before the check", n)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pos == nil || string(pos.Key) != "k06" || string(pos.Val) != "v0" || !pos.After {
+		t.Errorf("position: %+v", pos)
+	}
+
+	// so does a past deadline.
+	err = env.View(func(txn *Txn) (err error) {
+		pos, err = txn.BoundedScan(dbi, nil, &ScanLimits{Deadline: time.Now(), CheckEvery: 1}, func(k, v []byte) error {
+			return nil
+		})
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pos == nil || string(pos.Key) != "k00" || string(pos.Val) != "v0" {
+		t.Errorf("position: %+v", pos)
+	}
+
+	var bad ScanPosition
+	if err := bad.UnmarshalBinary([]byte{0, 9, 'k'}); err == nil {
+		t.Error("malformed position decoded")
+	}
+}
@@ -0,0 +1,189 @@
+package lmdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// The changeset encoding is a stable binary representation of a WriteBatch
+// that may be shipped between processes, stored, and replayed later.  DBI
+// handles are only meaningful inside the process that opened them so the
+// encoding refers to databases by name instead.
+//
+// The layout is
+//
+//	magic    "LMCS"
+//	version  1 byte
+//	count    uvarint
+//	ops      count times:
+//		type   1 byte
+//		flags  uvarint
+//		dbi    uvarint length + name bytes
+//		key    uvarint length + bytes
+//		val    uvarint length + bytes
+//		end    uvarint (0 for nil, else length+1) + bytes
+//
+// The version is increased whenever the layout changes.  Unmarshal rejects
+// versions it does not know.
+const (
+	changesetMagic   = "LMCS"
+	changesetVersion = 1
+)
+
+var (
+	errChangesetMagic     = errors.New("changeset: bad magic")
+	errChangesetTruncated = errors.New("changeset: truncated data")
+	errChangesetTrailing  = errors.New("changeset: trailing data")
+)
+
+// Marshal encodes the operations in b as a changeset.  Because DBI handles
+// are local to an Env, names maps every DBI used by b to the name it was
+// opened with (the root database may be mapped to the empty string).
+// Marshal returns an error if b uses a DBI missing from names.
+func (b *WriteBatch) Marshal(names map[DBI]string) ([]byte, error) {
+	buf := make([]byte, 0, 64)
+	buf = append(buf, changesetMagic...)
+	buf = append(buf, changesetVersion)
+	buf = appendUvarint(buf, uint64(len(b.ops)))
+	for i := range b.ops {
+		op := &b.ops[i]
+		name, ok := names[op.DBI]
+		if !ok {
+			return nil, fmt.Errorf("changeset: no name for dbi %d", op.DBI)
+		}
+		buf = append(buf, byte(op.Type))
+		buf = appendUvarint(buf, uint64(op.Flags))
+		buf = appendChunk(buf, []byte(name))
+		buf = appendChunk(buf, op.Key)
+		buf = appendChunk(buf, op.Val)
+		if op.End == nil {
+			buf = appendUvarint(buf, 0)
+		} else {
+			buf = appendUvarint(buf, uint64(len(op.End))+1)
+			buf = append(buf, op.End...)
+		}
+	}
+	return buf, nil
+}
+
+// Unmarshal replaces the contents of b with the operations encoded in data
+// by Marshal.  The dbis map resolves database names in the changeset to open
+// DBI handles.  If Unmarshal returns an error b is left empty.
+func (b *WriteBatch) Unmarshal(data []byte, dbis map[string]DBI) error {
+	b.Reset()
+	err := b.unmarshal(data, dbis)
+	if err != nil {
+		b.Reset()
+	}
+	return err
+}
+
+func (b *WriteBatch) unmarshal(data []byte, dbis map[string]DBI) error {
+	r := changesetReader{data: data}
+	magic := r.bytes(len(changesetMagic))
+	if r.err == nil && string(magic) != changesetMagic {
+		return errChangesetMagic
+	}
+	version := r.bytes(1)
+	if r.err == nil && version[0] != changesetVersion {
+		return fmt.Errorf("changeset: unsupported version %d", version[0])
+	}
+	count := r.uvarint()
+	if r.err != nil {
+		return r.err
+	}
+	// each op takes at least six bytes, which bounds allocation for corrupt
+	// counts.
+	if count > uint64(len(r.data))/6 {
+		return errChangesetTruncated
+	}
+	b.ops = make([]BatchOp, 0, int(count))
+	for i := uint64(0); i < count; i++ {
+		var op BatchOp
+		typ := r.bytes(1)
+		if r.err != nil {
+			return r.err
+		}
+		op.Type = BatchOpType(typ[0])
+		if op.Type < BatchPut || op.Type > BatchDropRange {
+			return errBatchOpType
+		}
+		op.Flags = uint(r.uvarint())
+		name := string(r.chunk())
+		op.Key = cloneBytes(r.chunk())
+		op.Val = cloneBytes(r.chunk())
+		endLen := r.uvarint()
+		if endLen > 0 {
+			op.End = cloneBytes(r.bytes(int(endLen - 1)))
+		}
+		if r.err != nil {
+			return r.err
+		}
+		dbi, ok := dbis[name]
+		if !ok {
+			return fmt.Errorf("changeset: unknown database %q", name)
+		}
+		op.DBI = dbi
+		b.ops = append(b.ops, op)
+	}
+	if len(r.data) != 0 {
+		return errChangesetTrailing
+	}
+	return nil
+}
+
+func appendUvarint(buf []byte, x uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], x)
+	return append(buf, tmp[:n]...)
+}
+
+func appendChunk(buf, p []byte) []byte {
+	buf = appendUvarint(buf, uint64(len(p)))
+	return append(buf, p...)
+}
+
+// changesetReader consumes a changeset, remembering the first error
+// encountered so that callers may check once after several reads.
+type changesetReader struct {
+	data []byte
+	err  error
+}
+
+func (r *changesetReader) bytes(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(r.data) {
+		r.err = errChangesetTruncated
+		return nil
+	}
+	p := r.data[:n:n]
+	r.data = r.data[n:]
+	return p
+}
+
+func (r *changesetReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	x, n := binary.Uvarint(r.data)
+	if n <= 0 {
+		r.err = errChangesetTruncated
+		return 0
+	}
+	r.data = r.data[n:]
+	return x
+}
+
+func (r *changesetReader) chunk() []byte {
+	n := r.uvarint()
+	if n > uint64(len(r.data)) {
+		if r.err == nil {
+			r.err = errChangesetTruncated
+		}
+		return nil
+	}
+	return r.bytes(int(n))
+}
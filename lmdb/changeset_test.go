@@ -0,0 +1,74 @@
+package lmdb
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestWriteBatch_Marshal(t *testing.T) {
+	b := NewWriteBatch()
+	b.Put(1, []byte("k1"), []byte("v1"))
+	b.PutFlags(2, []byte("k2"), nil, NoOverwrite)
+	b.Del(1, []byte("k3"), nil)
+	b.DropRange(2, []byte("a"), nil)
+	b.DropRange(2, nil, []byte{})
+
+	data, err := b.Marshal(map[DBI]string{1: "one", 2: ""})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b2 WriteBatch
+	err = b2.Unmarshal(data, map[string]DBI{"one": 10, "": 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b2.Len() != b.Len() {
+		t.Fatalf("unexpected length: %d (!= %d)", b2.Len(), b.Len())
+	}
+	for i, op := range b2.Ops() {
+		orig := b.Ops()[i]
+		dbi := map[DBI]DBI{1: 10, 2: 20}[orig.DBI]
+		if op.Type != orig.Type || op.DBI != dbi || op.Flags != orig.Flags {
+			t.Errorf("op %d: unexpected op %+v (!= %+v)", i, op, orig)
+		}
+		if !bytes.Equal(op.Key, orig.Key) || !bytes.Equal(op.Val, orig.Val) {
+			t.Errorf("op %d: unexpected data %q=%q (!= %q=%q)", i, op.Key, op.Val, orig.Key, orig.Val)
+		}
+		if (op.End == nil) != (orig.End == nil) || !bytes.Equal(op.End, orig.End) {
+			t.Errorf("op %d: unexpected end %q (!= %q)", i, op.End, orig.End)
+		}
+	}
+
+	// encoding is deterministic
+	data2, err := b2.Marshal(map[DBI]string{10: "one", 20: ""})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(data, data2) {
+		t.Errorf("re-encoded changeset differs")
+	}
+
+	_, err = b.Marshal(map[DBI]string{1: "one"})
+	if err == nil {
+		t.Errorf("expected error for unnamed dbi")
+	}
+	err = b2.Unmarshal(data, map[string]DBI{"one": 10})
+	if err == nil {
+		t.Errorf("expected error for unknown database name")
+	}
+	if b2.Len() != 0 {
+		t.Errorf("batch not empty after failed unmarshal")
+	}
+	for i := 0; i < len(data); i++ {
+		err = b2.Unmarshal(data[:i], map[string]DBI{"one": 10, "": 20})
+		if err == nil {
+			t.Errorf("expected error for truncated changeset of length %d", i)
+		}
+	}
+	err = b2.Unmarshal(append(data, 0), map[string]DBI{"one": 10, "": 20})
+	if err != errChangesetTrailing {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,234 @@
+package lmdb
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+)
+
+// DefaultCheckpointDB is the database recording the checkpoints of jobs.
+const DefaultCheckpointDB = "lmdb-checkpoints"
+
+// Checkpoint is the progress of a long-running job iterating over the keys of
+// a database, see Env.RunCheckpointed.
+type Checkpoint struct {
+	Job string
+
+	// Key is the last key processed, nil before the first one.
+	Key []byte
+
+	// Started and TxnID are the ids of the transactions that saved the
+	// first and the last checkpoints of the job, see Txn.ID.  Changes
+	// committed before Started are seen by the job; those committed after
+	// it may not be, for keys already processed.
+	Started uintptr
+	TxnID   uintptr
+
+	Items   uint64 // items processed
+	Updated time.Time
+	Done    bool
+}
+
+// checkpoint value flags.
+const (
+	checkpointDone = 1 << iota
+	checkpointHasKey
+)
+
+func checkpointDB(txn *Txn, name string, flags uint) (DBI, error) {
+	if name == "" {
+		name = DefaultCheckpointDB
+	}
+	return txn.OpenDBI(name, flags)
+}
+
+// LoadCheckpoint returns the checkpoint of job recorded in db, or
+// DefaultCheckpointDB if db is empty, and whether there is one.
+func LoadCheckpoint(txn *Txn, db, job string) (Checkpoint, bool, error) {
+	cp := Checkpoint{Job: job}
+	dbi, err := checkpointDB(txn, db, 0)
+	if IsNotFound(err) {
+		return cp, false, nil
+	}
+	if err != nil {
+		return cp, false, err
+	}
+	v, err := txn.Get(dbi, []byte(job))
+	if IsNotFound(err) {
+		return cp, false, nil
+	}
+	if err != nil {
+		return cp, false, err
+	}
+	r := changesetReader{data: v}
+	flags := r.uvarint()
+	cp.Started = uintptr(r.uvarint())
+	cp.TxnID = uintptr(r.uvarint())
+	cp.Items = r.uvarint()
+	cp.Updated = time.Unix(0, int64(r.uvarint()))
+	key := r.chunk()
+	if r.err != nil {
+		return cp, false, fmt.Errorf("checkpoint %q: %v", job, r.err)
+	}
+	if flags&checkpointHasKey != 0 {
+		cp.Key = cloneBytes(key)
+	}
+	cp.Done = flags&checkpointDone != 0
+	return cp, true, nil
+}
+
+// SaveCheckpoint records cp in db, or DefaultCheckpointDB if db is empty, as
+// of txn: cp.TxnID and cp.Updated are set, and cp.Started too if zero.  Saved
+// in the transaction writing the results of the items processed, a
+// checkpoint makes the job resume exactly after them.
+func SaveCheckpoint(txn *Txn, db string, cp *Checkpoint) error {
+	dbi, err := checkpointDB(txn, db, Create)
+	if err != nil {
+		return err
+	}
+	cp.TxnID = txn.ID()
+	if cp.Started == 0 {
+		cp.Started = cp.TxnID
+	}
+	cp.Updated = time.Now()
+	var flags uint64
+	if cp.Done {
+		flags |= checkpointDone
+	}
+	if cp.Key != nil {
+		flags |= checkpointHasKey
+	}
+	v := appendUvarint(nil, flags)
+	v = appendUvarint(v, uint64(cp.Started))
+	v = appendUvarint(v, uint64(cp.TxnID))
+	v = appendUvarint(v, cp.Items)
+	v = appendUvarint(v, uint64(cp.Updated.UnixNano()))
+	v = appendChunk(v, cp.Key)
+	return txn.Put(dbi, []byte(cp.Job), v, 0)
+}
+
+// ClearCheckpoint removes the checkpoint of job from db, or
+// DefaultCheckpointDB if db is empty, so that the job starts over.
+func ClearCheckpoint(txn *Txn, db, job string) error {
+	dbi, err := checkpointDB(txn, db, 0)
+	if IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	err = txn.Del(dbi, []byte(job), nil)
+	if IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// CheckpointOptions configures Env.RunCheckpointed.  The zero value selects
+// the defaults described for each field.
+type CheckpointOptions struct {
+	// DB is the database recording the checkpoint, DefaultCheckpointDB if
+	// empty.  It takes one of the named databases allowed by MaxDBs.
+	DB string
+
+	// Batch is the number of items processed per transaction, 1000 if
+	// zero.
+	Batch int
+
+	// Progress, if not nil, is called with the checkpoint saved after each
+	// transaction commits.
+	Progress func(Checkpoint)
+}
+
+// CheckpointFunc processes an item of the database iterated by
+// Env.RunCheckpointed, in the update transaction txn.  The slices must not
+// be retained.
+type CheckpointFunc func(txn *Txn, key, val []byte) error
+
+// RunCheckpointed runs job over the items of dbi in key order, calling fn in
+// update transactions of opts.Batch items, each of which also saves the
+// checkpoint of the job, see SaveCheckpoint.  A job interrupted by an error,
+// which is returned along with the checkpoint, or by a crash resumes after
+// the last key processed when run again, rather than rescanning dbi, until
+// it completes and its checkpoint is marked done.  Running a completed job
+// does nothing, until ClearCheckpoint is called.
+//
+// Each transaction sees the changes committed before it, so that keys added
+// past the checkpoint while the job is interrupted are processed.  The
+// duplicates of a key of a DupSort database are processed in the same
+// transaction.
+func (env *Env) RunCheckpointed(dbi DBI, job string, opts *CheckpointOptions, fn CheckpointFunc) (Checkpoint, error) {
+	var o CheckpointOptions
+	if opts != nil {
+		o = *opts
+	}
+	if o.Batch <= 0 {
+		o.Batch = 1000
+	}
+	var cp Checkpoint
+	err := env.View(func(txn *Txn) (err error) {
+		cp, _, err = LoadCheckpoint(txn, o.DB, job)
+		return err
+	})
+	for err == nil && !cp.Done {
+		next := cp
+		err = env.Update(func(txn *Txn) error {
+			next = cp
+			err := next.advance(txn, dbi, o.Batch, fn)
+			if err != nil {
+				return err
+			}
+			return SaveCheckpoint(txn, o.DB, &next)
+		})
+		if err == nil {
+			cp = next
+			if o.Progress != nil {
+				o.Progress(cp)
+			}
+		}
+	}
+	return cp, err
+}
+
+// advance processes up to batch items after cp.Key, completing the batch
+// with the remaining duplicates of its last key, and marks cp done at the
+// end of dbi.
+func (cp *Checkpoint) advance(txn *Txn, dbi DBI, batch int, fn CheckpointFunc) error {
+	cur, err := txn.OpenCursor(dbi)
+	if err != nil {
+		return err
+	}
+	defer cur.Close()
+	var k, v []byte
+	if cp.Key == nil {
+		k, v, err = cur.Get(nil, nil, First)
+	} else {
+		k, v, err = cur.Get(cp.Key, nil, SetRange)
+		if err == nil && bytes.Equal(k, cp.Key) {
+			k, v, err = cur.Get(nil, nil, NextNoDup)
+		}
+	}
+	var last []byte
+	for n := 0; ; n++ {
+		if IsNotFound(err) {
+			cp.Done = true
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if n >= batch && !bytes.Equal(k, last) {
+			return nil
+		}
+		err = fn(txn, k, v)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(k, last) {
+			last = cloneBytes(k)
+			cp.Key = last
+		}
+		cp.Items++
+		k, v, err = cur.Get(nil, nil, Next)
+	}
+}
@@ -0,0 +1,106 @@
+package lmdb
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestEnv_RunCheckpointed(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+
+	var src, dst DBI
+	err := env.Update(func(txn *Txn) (err error) {
+		src, err = txn.OpenDBI("src", Create)
+		if err != nil {
+			return err
+		}
+		dst, err = txn.OpenDBI("dst", Create)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 250; i++ {
+			k := []byte(fmt.Sprintf("k%03d", i))
+			if err = txn.Put(src, k, k, 0); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errCrash := errors.New("crash")
+	calls := 0
+	copyItem := func(txn *Txn, k, v []byte) error {
+		calls++
+		if calls == 120 {
+			return errCrash
+		}
+		n := 0
+		old, err := txn.Get(dst, k)
+		if err == nil {
+			n = int(old[0])
+		} else if !IsNotFound(err) {
+			return err
+		}
+		return txn.Put(dst, k, []byte{byte(n + 1)}, 0)
+	}
+	opts := &CheckpointOptions{Batch: 50}
+	cp, err := env.RunCheckpointed(src, "copy", opts, copyItem)
+	if err != errCrash {
+		t.Fatalf("first run: %v", err)
+	}
+	if cp.Done || cp.Items != 100 || string(cp.Key) != "k099" || cp.Started == 0 {
+		t.Errorf("checkpoint after crash %+v", cp)
+	}
+
+	var saved []Checkpoint
+	opts.Progress = func(cp Checkpoint) { saved = append(saved, cp) }
+	cp, err = env.RunCheckpointed(src, "copy", opts, copyItem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cp.Done || cp.Items != 250 || cp.TxnID <= cp.Started || len(saved) != 3 {
+		t.Errorf("final checkpoint %+v, %d saved", cp, len(saved))
+	}
+
+	err = env.View(func(txn *Txn) error {
+		for i := 0; i < 250; i++ {
+			v, err := txn.Get(dst, []byte(fmt.Sprintf("k%03d", i)))
+			if err != nil {
+				return err
+			}
+			if v[0] != 1 {
+				t.Errorf("k%03d processed %d times", i, v[0])
+			}
+		}
+		got, ok, err := LoadCheckpoint(txn, "", "copy")
+		if err != nil || !ok || !got.Done || got.TxnID != cp.TxnID {
+			t.Errorf("loaded %+v %v %v", got, ok, err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a completed job does not run again until cleared.
+	calls = 0
+	if _, err = env.RunCheckpointed(src, "copy", opts, copyItem); err != nil || calls != 0 {
+		t.Errorf("completed job ran %d items: %v", calls, err)
+	}
+	err = env.Update(func(txn *Txn) error {
+		return ClearCheckpoint(txn, "", "copy")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls = 1000
+	cp, err = env.RunCheckpointed(src, "copy", nil, copyItem)
+	if err != nil || cp.Items != 250 || calls != 1250 {
+		t.Errorf("cleared job: %+v %d %v", cp, calls, err)
+	}
+}
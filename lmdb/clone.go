@@ -0,0 +1,95 @@
+package lmdb
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// TB is the part of testing.TB used by Env.CloneToTemp.
+type TB interface {
+	Helper()
+	Cleanup(func())
+	Fatalf(format string, args ...interface{})
+}
+
+// CopyToTemp makes a compacting copy of env in a new temporary directory and
+// opens it with the configuration of env: the same flags, map size, reader
+// and database limits, and transaction defaults set through Options.  The
+// Readonly flag is not carried over, so that the clone can be modified
+// freely without touching the files of env.
+//
+// The caller must close the clone and remove dir when done with it.
+func (env *Env) CopyToTemp() (clone *Env, dir string, err error) {
+	flags, err := env.Flags()
+	if err != nil {
+		return nil, "", err
+	}
+	info, err := env.Info()
+	if err != nil {
+		return nil, "", err
+	}
+
+	dir, err = ioutil.TempDir("", "lmdb-clone-")
+	if err != nil {
+		return nil, "", err
+	}
+	defer func() {
+		if err != nil {
+			if clone != nil {
+				clone.Close()
+			}
+			os.RemoveAll(dir)
+		}
+	}()
+	path := dir
+	if flags&NoSubdir != 0 {
+		path = filepath.Join(dir, "data.mdb")
+	}
+	err = env.CopyFlag(path, CopyCompact)
+	if err != nil {
+		return nil, "", err
+	}
+
+	clone, err = NewEnvMaxReaders(env.maxReaders)
+	if err != nil {
+		return nil, "", err
+	}
+	if env.maxDBs != 0 {
+		err = clone.SetMaxDBs(env.maxDBs)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+	err = clone.SetMapSize(info.MapSize)
+	if err != nil {
+		return nil, "", err
+	}
+	clone.viewRawRead = env.viewRawRead
+	clone.updateFlags = env.updateFlags
+	clone.checkMapExtent = env.checkMapExtent
+	env.rkeyMu.Lock()
+	clone.rkeyReserved = env.rkeyReserved
+	env.rkeyMu.Unlock()
+	err = clone.Open(path, flags&^Readonly, 0644)
+	if err != nil {
+		return nil, "", err
+	}
+	return clone, dir, nil
+}
+
+// CloneToTemp is CopyToTemp for tests.  It fails the test if the copy cannot
+// be made, and closes and removes the clone when the test and its subtests
+// complete.
+func (env *Env) CloneToTemp(t TB) *Env {
+	t.Helper()
+	clone, dir, err := env.CopyToTemp()
+	if err != nil {
+		t.Fatalf("clone environment: %v", err)
+	}
+	t.Cleanup(func() {
+		clone.Close()
+		os.RemoveAll(dir)
+	})
+	return clone
+}
@@ -0,0 +1,77 @@
+package lmdb
+
+import (
+	"os"
+	"testing"
+)
+
+func TestEnv_CloneToTemp(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+
+	var dbi DBI
+	err := env.Update(func(txn *Txn) (err error) {
+		dbi, err = txn.OpenDBI("clone", Create)
+		if err != nil {
+			return err
+		}
+		for _, k := range []string{"a", "b", "c"} {
+			err = txn.Put(dbi, []byte(k), []byte(k+k), 0)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var path string
+	t.Run("clone", func(t *testing.T) {
+		clone := env.CloneToTemp(t)
+		path, err = clone.Path()
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = clone.Update(func(txn *Txn) (err error) {
+			// a second named database requires the MaxDBs of env.
+			_, err = txn.OpenDBI("other", Create)
+			if err != nil {
+				return err
+			}
+			cdbi, err := txn.OpenDBI("clone", 0)
+			if err != nil {
+				return err
+			}
+			v, err := txn.Get(cdbi, []byte("b"))
+			if err != nil {
+				return err
+			}
+			if string(v) != "bb" {
+				t.Errorf("clone value: %q", v)
+			}
+			return txn.Put(cdbi, []byte("b"), []byte("changed"), 0)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("clone not removed: %v", err)
+	}
+
+	err = env.View(func(txn *Txn) (err error) {
+		v, err := txn.Get(dbi, []byte("b"))
+		if err != nil {
+			return err
+		}
+		if string(v) != "bb" {
+			t.Errorf("original modified: %q", v)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,80 @@
+package lmdb
+
+import (
+	"sync/atomic"
+)
+
+// MergeFunc combines two values submitted for the same key of dbi to a
+// BatchWriter, prev before val, into the value to put instead of both, e.g.
+// the sum of two counter increments.  The arguments must not be retained,
+// but the result may be one of them.
+type MergeFunc func(dbi DBI, key, prev, val []byte) []byte
+
+// batchPut is a put submitted with EnqueuePut.
+type batchPut struct {
+	dbi   DBI
+	key   []byte
+	val   []byte
+	flags uint
+}
+
+type putKey struct {
+	dbi DBI
+	key string
+}
+
+// EnqueuePut submits a put of key and val in dbi, see Enqueue.  The writer
+// keeps copies of key and val.  If the writer coalesces writes (see
+// BatchWriterOptions.Coalesce), puts without flags of the same key queued
+// together result in a single put, and share its result.  A put coalesced
+// into a later one is not visible to the operations submitted between them.
+func (w *BatchWriter) EnqueuePut(dbi DBI, key, val []byte, flags uint) <-chan error {
+	put := &batchPut{dbi: dbi, key: cloneBytes(key), val: cloneBytes(val), flags: flags}
+	return w.submit(&batchWrite{
+		op: func(txn *Txn) error {
+			return txn.Put(put.dbi, put.key, put.val, put.flags)
+		},
+		errc: make(chan error, 1),
+		put:  put,
+	})
+}
+
+// DoPut submits a put and waits for its result, see EnqueuePut.
+func (w *BatchWriter) DoPut(dbi DBI, key, val []byte, flags uint) error {
+	return <-w.EnqueuePut(dbi, key, val, flags)
+}
+
+// Coalesced returns the number of puts that were coalesced into later ones
+// instead of being performed.
+func (w *BatchWriter) Coalesced() uint64 {
+	return atomic.LoadUint64(&w.coalesced)
+}
+
+// coalescePuts removes from batch the puts followed by a put of the same
+// key, merging their values into it if the writer has a MergeFunc.
+func (w *BatchWriter) coalescePuts(batch []*batchWrite) {
+	var last map[putKey]int
+	var n uint64
+	for i, bw := range batch {
+		if bw.put == nil || bw.put.flags != 0 {
+			continue
+		}
+		if last == nil {
+			last = make(map[putKey]int)
+		}
+		k := putKey{dbi: bw.put.dbi, key: string(bw.put.key)}
+		if j, ok := last[k]; ok {
+			prev := batch[j]
+			if w.merge != nil {
+				bw.put.val = w.merge(k.dbi, bw.put.key, prev.put.val, bw.put.val)
+			}
+			prev.op = nil
+			prev.into = i
+			n++
+		}
+		last[k] = i
+	}
+	if n > 0 {
+		atomic.AddUint64(&w.coalesced, n)
+	}
+}
@@ -0,0 +1,73 @@
+package lmdb
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestBatchWriter_Coalesce(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+
+	dbi, err := openRoot(env, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := func(dbi DBI, key, prev, val []byte) []byte {
+		n := binary.BigEndian.Uint64(prev) + binary.BigEndian.Uint64(val)
+		b := make([]byte, 8)
+		binary.BigEndian.PutUint64(b, n)
+		return b
+	}
+	for _, merge := range []MergeFunc{nil, sum} {
+		w := env.NewBatchWriter(&BatchWriterOptions{Coalesce: true, Merge: merge})
+
+		// queue the puts while the writer is busy so they share a batch.
+		started, block := make(chan struct{}), make(chan struct{})
+		busy := w.Enqueue(func(txn *Txn) error {
+			close(started)
+			<-block
+			return nil
+		})
+		<-started
+		var errcs []<-chan error
+		val := make([]byte, 8)
+		for i := 1; i <= 10; i++ {
+			binary.BigEndian.PutUint64(val, uint64(i))
+			errcs = append(errcs, w.EnqueuePut(dbi, []byte("gauge"), val, 0))
+		}
+		errcs = append(errcs, w.EnqueuePut(dbi, []byte("other"), val, 0))
+		close(block)
+		if err := <-busy; err != nil {
+			t.Fatal(err)
+		}
+		for _, errc := range errcs {
+			if err := <-errc; err != nil {
+				t.Error(err)
+			}
+		}
+		if n := w.Coalesced(); n != 9 {
+			t.Errorf("coalesced %d puts", n)
+		}
+		w.Close()
+
+		want := uint64(10)
+		if merge != nil {
+			want = 55
+		}
+		err = env.View(func(txn *Txn) error {
+			v, err := txn.Get(dbi, []byte("gauge"))
+			if err != nil {
+				return err
+			}
+			if n := binary.BigEndian.Uint64(v); n != want {
+				t.Errorf("value %d, want %d", n, want)
+			}
+			_, err = txn.Get(dbi, []byte("other"))
+			return err
+		})
+		if err != nil {
+			t.Error(err)
+		}
+	}
+}
@@ -0,0 +1,458 @@
+package lmdb
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CodecKind is the kind of a CodecStage, which determines where it may
+// appear in a chain, see Env.SetCodecs.
+type CodecKind int
+
+// The kinds of codec stages, in the order they must be applied when
+// encoding.
+const (
+	// CodecValidate stages check values without changing them.
+	CodecValidate CodecKind = iota
+
+	// CodecEnvelope stages wrap values in a format envelope, e.g. a schema
+	// version.
+	CodecEnvelope
+
+	CodecCompress
+	CodecEncrypt
+
+	// CodecChecksum stages protect the stored bytes.
+	CodecChecksum
+)
+
+var codecKindNames = [...]string{"validate", "envelope", "compress", "encrypt", "checksum"}
+
+func (k CodecKind) String() string {
+	if k < 0 || int(k) >= len(codecKindNames) {
+		return fmt.Sprintf("CodecKind(%d)", int(k))
+	}
+	return codecKindNames[k]
+}
+
+// CodecStage is a step of the transformation of the values of a database
+// between their application form and their stored form.  Encode is applied
+// when writing and Decode, its inverse, when reading; either may be nil for
+// stages that leave values unchanged in that direction.  Neither may modify
+// its argument, which may be read-only memory of the map, but the result may
+// alias it.
+type CodecStage struct {
+	Name   string
+	Kind   CodecKind
+	Encode func(val []byte) ([]byte, error)
+	Decode func(val []byte) ([]byte, error)
+}
+
+// ErrCodecOrder indicates a chain of codec stages in an invalid order.
+// Env.SetCodecs then returns a *CodecOrderError for which
+// errors.Is(err, ErrCodecOrder) is true.
+var ErrCodecOrder = errors.New("invalid codec stage order")
+
+// CodecOrderError describes a stage out of place in a codec chain.
+type CodecOrderError struct {
+	Stage  string // name of the stage
+	Reason string
+}
+
+func (err *CodecOrderError) Error() string {
+	return fmt.Sprintf("%v: stage %s: %s", ErrCodecOrder, err.Stage, err.Reason)
+}
+
+// Is allows errors.Is(err, ErrCodecOrder) to match a *CodecOrderError.
+func (err *CodecOrderError) Is(target error) bool {
+	return target == ErrCodecOrder
+}
+
+// ErrCodec indicates that a stage failed to encode or decode a value.  Such
+// failures return a *CodecError for which errors.Is(err, ErrCodec) is true.
+var ErrCodec = errors.New("codec failure")
+
+// ErrChecksum is the error of a ChecksumStage decoding a value whose
+// checksum does not match.
+var ErrChecksum = errors.New("checksum mismatch")
+
+// CodecError describes the failure of a codec stage.
+type CodecError struct {
+	DBI    DBI
+	Stage  string
+	Decode bool // whether the stage was decoding
+	Err    error
+}
+
+func (err *CodecError) Error() string {
+	op := "encode"
+	if err.Decode {
+		op = "decode"
+	}
+	return fmt.Sprintf("%v: dbi %d: %s %s: %v", ErrCodec, err.DBI, err.Stage, op, err.Err)
+}
+
+// Is allows errors.Is(err, ErrCodec) to match a *CodecError.
+func (err *CodecError) Is(target error) bool {
+	return target == ErrCodec
+}
+
+// Unwrap returns the error of the stage.
+func (err *CodecError) Unwrap() error {
+	return err.Err
+}
+
+// CodecStageStats counts the work of a codec stage.  BytesIn and BytesOut
+// are the sizes of the values given to and returned by its Encode, e.g. to
+// measure a compression ratio.
+type CodecStageStats struct {
+	Name       string
+	Kind       CodecKind
+	Encodes    uint64
+	Decodes    uint64
+	Errors     uint64
+	BytesIn    uint64
+	BytesOut   uint64
+	EncodeTime time.Duration
+	DecodeTime time.Duration
+}
+
+// codecRegistry holds the codec chains of the databases of an Env, see
+// Env.SetCodecs.
+type codecRegistry struct {
+	mu     sync.RWMutex
+	chains map[DBI][]*codecStage
+}
+
+// codecStage is a configured stage and its counters, updated atomically,
+// and kept first for their alignment.
+type codecStage struct {
+	encodes, decodes, errors uint64
+	bytesIn, bytesOut        uint64
+	encodeTime, decodeTime   int64
+
+	CodecStage
+}
+
+// SetCodecs configures the chain of stages transforming the values of dbi,
+// such as validation, compression, encryption and checksums, applied by
+// Txn.PutEncoded, Txn.GetDecoded and their Cursor counterparts.  Values are
+// encoded through stages in order and decoded in reverse order.  The other
+// methods of Txn and Cursor, and the features built on them (subscriptions,
+// indexes, exports), see the stored values.  Calling SetCodecs without
+// stages removes the chain of dbi, and configuring a chain resets its
+// statistics.
+//
+// The kinds of the stages must not decrease along the chain, so that values
+// are validated in their application form, compressed before they are
+// encrypted and checksummed as stored, and a chain has at most one
+// compression, encryption and checksum stage.  SetCodecs fails with an error
+// for which errors.Is(err, ErrCodecOrder) is true otherwise.
+//
+// Stages whose encoding is not deterministic, like EncryptStage, must not be
+// used with DupSort databases, whose values are compared.
+func (env *Env) SetCodecs(dbi DBI, stages ...CodecStage) error {
+	seen := make(map[CodecKind]bool)
+	for i, s := range stages {
+		if s.Kind < CodecValidate || s.Kind > CodecChecksum {
+			return &CodecOrderError{Stage: s.Name, Reason: "unknown kind " + s.Kind.String()}
+		}
+		if i > 0 && s.Kind < stages[i-1].Kind {
+			prev := stages[i-1]
+			return &CodecOrderError{Stage: s.Name, Reason: fmt.Sprintf("%s after %s stage %s", s.Kind, prev.Kind, prev.Name)}
+		}
+		if seen[s.Kind] && s.Kind >= CodecCompress {
+			return &CodecOrderError{Stage: s.Name, Reason: "second " + s.Kind.String() + " stage"}
+		}
+		seen[s.Kind] = true
+	}
+	if c, _ := env.decodeCache.Load().(*decodeCache); c != nil {
+		defer c.flushDBI(dbi)
+	}
+	r := &env.codecs
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(stages) == 0 {
+		delete(r.chains, dbi)
+		return nil
+	}
+	if r.chains == nil {
+		r.chains = make(map[DBI][]*codecStage)
+	}
+	chain := make([]*codecStage, len(stages))
+	for i, s := range stages {
+		chain[i] = &codecStage{CodecStage: s}
+	}
+	r.chains[dbi] = chain
+	return nil
+}
+
+// CodecStats returns the statistics of the stages configured for dbi, in
+// chain order.
+func (env *Env) CodecStats(dbi DBI) []CodecStageStats {
+	chain := env.codecs.chain(dbi)
+	stats := make([]CodecStageStats, len(chain))
+	for i, s := range chain {
+		stats[i] = CodecStageStats{
+			Name:       s.Name,
+			Kind:       s.Kind,
+			Encodes:    atomic.LoadUint64(&s.encodes),
+			Decodes:    atomic.LoadUint64(&s.decodes),
+			Errors:     atomic.LoadUint64(&s.errors),
+			BytesIn:    atomic.LoadUint64(&s.bytesIn),
+			BytesOut:   atomic.LoadUint64(&s.bytesOut),
+			EncodeTime: time.Duration(atomic.LoadInt64(&s.encodeTime)),
+			DecodeTime: time.Duration(atomic.LoadInt64(&s.decodeTime)),
+		}
+	}
+	return stats
+}
+
+// costly reports whether the chain of dbi decompresses or decrypts values,
+// whose decoding is worth caching, see EnableDecodeCache.
+func (r *codecRegistry) costly(dbi DBI) bool {
+	for _, s := range r.chain(dbi) {
+		if s.Decode != nil && (s.Kind == CodecCompress || s.Kind == CodecEncrypt) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *codecRegistry) chain(dbi DBI) []*codecStage {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.chains[dbi]
+}
+
+// encode returns val encoded through the chain of dbi.
+func (r *codecRegistry) encode(dbi DBI, val []byte) ([]byte, error) {
+	for _, s := range r.chain(dbi) {
+		if s.Encode == nil {
+			continue
+		}
+		start := time.Now()
+		out, err := s.Encode(val)
+		atomic.AddInt64(&s.encodeTime, int64(time.Since(start)))
+		atomic.AddUint64(&s.encodes, 1)
+		if err != nil {
+			atomic.AddUint64(&s.errors, 1)
+			return nil, &CodecError{DBI: dbi, Stage: s.Name, Err: err}
+		}
+		atomic.AddUint64(&s.bytesIn, uint64(len(val)))
+		atomic.AddUint64(&s.bytesOut, uint64(len(out)))
+		val = out
+	}
+	return val, nil
+}
+
+// decode returns val decoded through the chain of dbi, in reverse order.
+func (r *codecRegistry) decode(dbi DBI, val []byte) ([]byte, error) {
+	chain := r.chain(dbi)
+	for i := len(chain) - 1; i >= 0; i-- {
+		s := chain[i]
+		if s.Decode == nil {
+			continue
+		}
+		start := time.Now()
+		out, err := s.Decode(val)
+		atomic.AddInt64(&s.decodeTime, int64(time.Since(start)))
+		atomic.AddUint64(&s.decodes, 1)
+		if err != nil {
+			atomic.AddUint64(&s.errors, 1)
+			return nil, &CodecError{DBI: dbi, Stage: s.Name, Decode: true, Err: err}
+		}
+		val = out
+	}
+	return val, nil
+}
+
+// GetDecoded is Get followed by the decoding of the value through the codec
+// chain of dbi, see Env.SetCodecs.  With RawRead the value may still
+// reference the memory of the map, e.g. when no stage changes it.  In
+// read-only transactions the value may come from the decode cache, see
+// Env.EnableDecodeCache.
+func (txn *Txn) GetDecoded(dbi DBI, key []byte) ([]byte, error) {
+	cache := txn.decodeCacheFor(dbi)
+	if cache != nil {
+		if v, ok := cache.get(dbi, key, txn.ID()); ok {
+			return v, nil
+		}
+	}
+	v, err := txn.Get(dbi, key)
+	if err != nil {
+		return nil, err
+	}
+	v, err = txn.env.codecs.decode(dbi, v)
+	if err == nil && cache != nil {
+		v = cloneBytes(v)
+		cache.put(dbi, key, v, txn.ID())
+	}
+	return v, err
+}
+
+// PutEncoded is Put of val encoded through the codec chain of dbi, see
+// Env.SetCodecs.
+func (txn *Txn) PutEncoded(dbi DBI, key, val []byte, flags uint) error {
+	v, err := txn.env.codecs.encode(dbi, val)
+	if err != nil {
+		return err
+	}
+	return txn.Put(dbi, key, v, flags)
+}
+
+// GetDecoded is Get followed by the decoding of the value through the codec
+// chain of the database of c, see Env.SetCodecs.  Stages whose encoding is
+// deterministic let setval be given in application form with GetBoth.
+func (c *Cursor) GetDecoded(setkey, setval []byte, op uint) (key, val []byte, err error) {
+	dbi := c.DBI()
+	if setval != nil {
+		setval, err = c.txn.env.codecs.encode(dbi, setval)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	key, val, err = c.Get(setkey, setval, op)
+	if err != nil {
+		return nil, nil, err
+	}
+	val, err = c.txn.env.codecs.decode(dbi, val)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, val, nil
+}
+
+// PutEncoded is Put of val encoded through the codec chain of the database
+// of c, see Env.SetCodecs.
+func (c *Cursor) PutEncoded(key, val []byte, flags uint) error {
+	v, err := c.txn.env.codecs.encode(c.DBI(), val)
+	if err != nil {
+		return err
+	}
+	return c.Put(key, v, flags)
+}
+
+// ValidateStage returns a CodecValidate stage checking values with fn, both
+// when they are written and when they are read.
+func ValidateStage(name string, fn func(val []byte) error) CodecStage {
+	check := func(val []byte) ([]byte, error) {
+		return val, fn(val)
+	}
+	return CodecStage{Name: name, Kind: CodecValidate, Encode: check, Decode: check}
+}
+
+// The first byte of a value encoded by CompressStage.
+const (
+	compressStored   = 0
+	compressDeflated = 1
+)
+
+// CompressStage returns a CodecCompress stage compressing values with
+// DEFLATE at level, see compress/flate.  Values that do not shrink are
+// stored as is, behind the same one byte header.
+func CompressStage(level int) (CodecStage, error) {
+	if _, err := flate.NewWriter(ioutil.Discard, level); err != nil {
+		return CodecStage{}, err
+	}
+	encode := func(val []byte) ([]byte, error) {
+		var buf bytes.Buffer
+		buf.WriteByte(compressDeflated)
+		w, err := flate.NewWriter(&buf, level)
+		if err != nil {
+			return nil, err
+		}
+		_, err = w.Write(val)
+		if err == nil {
+			err = w.Close()
+		}
+		if err != nil {
+			return nil, err
+		}
+		if buf.Len() > len(val) {
+			return append([]byte{compressStored}, val...), nil
+		}
+		return buf.Bytes(), nil
+	}
+	decode := func(val []byte) ([]byte, error) {
+		if len(val) == 0 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		switch val[0] {
+		case compressStored:
+			return val[1:], nil
+		case compressDeflated:
+			return ioutil.ReadAll(flate.NewReader(bytes.NewReader(val[1:])))
+		}
+		return nil, fmt.Errorf("unknown compression %d", val[0])
+	}
+	return CodecStage{Name: "deflate", Kind: CodecCompress, Encode: encode, Decode: decode}, nil
+}
+
+// EncryptStage returns a CodecEncrypt stage encrypting values with AES-GCM
+// under key, of 16, 24 or 32 bytes.  Each value is stored behind a random
+// nonce, so that encoding is not deterministic.
+func EncryptStage(key []byte) (CodecStage, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return CodecStage{}, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return CodecStage{}, err
+	}
+	encode := func(val []byte) ([]byte, error) {
+		n := aead.NonceSize()
+		out := make([]byte, n, n+len(val)+aead.Overhead())
+		_, err := io.ReadFull(rand.Reader, out)
+		if err != nil {
+			return nil, err
+		}
+		return aead.Seal(out, out, val, nil), nil
+	}
+	decode := func(val []byte) ([]byte, error) {
+		n := aead.NonceSize()
+		if len(val) < n {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return aead.Open(nil, val[:n], val[n:], nil)
+	}
+	return CodecStage{Name: "aes-gcm", Kind: CodecEncrypt, Encode: encode, Decode: decode}, nil
+}
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// ChecksumStage returns a CodecChecksum stage appending the CRC-32C of
+// values, big-endian, and failing with ErrChecksum for values read whose
+// checksum does not match.
+func ChecksumStage() CodecStage {
+	encode := func(val []byte) ([]byte, error) {
+		out := make([]byte, len(val)+4)
+		copy(out, val)
+		binary.BigEndian.PutUint32(out[len(val):], crc32.Checksum(val, crc32c))
+		return out, nil
+	}
+	decode := func(val []byte) ([]byte, error) {
+		if len(val) < 4 {
+			return nil, ErrChecksum
+		}
+		n := len(val) - 4
+		if binary.BigEndian.Uint32(val[n:]) != crc32.Checksum(val[:n], crc32c) {
+			return nil, ErrChecksum
+		}
+		return val[:n], nil
+	}
+	return CodecStage{Name: "crc32c", Kind: CodecChecksum, Encode: encode, Decode: decode}
+}
@@ -0,0 +1,139 @@
+package lmdb
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestEnv_SetCodecs(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+
+	var dbi DBI
+	err := env.Update(func(txn *Txn) (err error) {
+		dbi, err = txn.OpenDBI("codec", Create)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	compress, err := CompressStage(6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypt, err := EncryptStage(bytes.Repeat([]byte{7}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	errEmpty := errors.New("empty value")
+	validate := ValidateStage("nonempty", func(val []byte) error {
+		if len(val) == 0 {
+			return errEmpty
+		}
+		return nil
+	})
+
+	err = env.SetCodecs(dbi, validate, encrypt, compress)
+	if !errors.Is(err, ErrCodecOrder) {
+		t.Errorf("compression after encryption: %v", err)
+	}
+	err = env.SetCodecs(dbi, ChecksumStage(), ChecksumStage())
+	if !errors.Is(err, ErrCodecOrder) {
+		t.Errorf("two checksums: %v", err)
+	}
+	err = env.SetCodecs(dbi, validate, compress, encrypt, ChecksumStage())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	val := bytes.Repeat([]byte("compressible "), 100)
+	err = env.Update(func(txn *Txn) error {
+		if err := txn.PutEncoded(dbi, []byte("k"), val, 0); err != nil {
+			return err
+		}
+		err := txn.PutEncoded(dbi, []byte("empty"), nil, 0)
+		if !errors.Is(err, ErrCodec) || !errors.Is(err, errEmpty) {
+			t.Errorf("invalid value: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = env.View(func(txn *Txn) error {
+		stored, err := txn.Get(dbi, []byte("k"))
+		if err != nil {
+			return err
+		}
+		if len(stored) >= len(val) || bytes.Contains(stored, []byte("compressible")) {
+			t.Errorf("stored %d bytes in clear", len(stored))
+		}
+		v, err := txn.GetDecoded(dbi, []byte("k"))
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(v, val) {
+			t.Errorf("decoded %q", v)
+		}
+		cur, err := txn.OpenCursor(dbi)
+		if err != nil {
+			return err
+		}
+		defer cur.Close()
+		k, v, err := cur.GetDecoded(nil, nil, First)
+		if err != nil {
+			return err
+		}
+		if string(k) != "k" || !bytes.Equal(v, val) {
+			t.Errorf("cursor decoded %q=%q", k, v)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// corrupt the stored value behind the chain.
+	err = env.Update(func(txn *Txn) error {
+		stored, err := txn.Get(dbi, []byte("k"))
+		if err != nil {
+			return err
+		}
+		stored[0] ^= 1
+		if err = txn.Put(dbi, []byte("k"), stored, 0); err != nil {
+			return err
+		}
+		_, err = txn.GetDecoded(dbi, []byte("k"))
+		if !errors.Is(err, ErrChecksum) {
+			t.Errorf("corrupt value: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats := env.CodecStats(dbi)
+	if len(stats) != 4 {
+		t.Fatalf("stats %+v", stats)
+	}
+	if s := stats[0]; s.Kind != CodecValidate || s.Encodes != 2 || s.Errors != 1 || s.Decodes != 2 {
+		t.Errorf("validate stats %+v", s)
+	}
+	if s := stats[1]; s.Name != "deflate" || s.Encodes != 1 || s.BytesIn <= s.BytesOut {
+		t.Errorf("compress stats %+v", s)
+	}
+	if s := stats[3]; s.Kind != CodecChecksum || s.Decodes != 3 || s.Errors != 1 {
+		t.Errorf("checksum stats %+v", s)
+	}
+
+	if err = env.SetCodecs(dbi); err != nil {
+		t.Fatal(err)
+	}
+	if stats = env.CodecStats(dbi); len(stats) != 0 {
+		t.Errorf("chain not removed: %+v", stats)
+	}
+}
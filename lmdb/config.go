@@ -0,0 +1,204 @@
+package lmdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// envFlagNames names the environment flags, in the order they are printed.
+var envFlagNames = []struct {
+	flag uint
+	name string
+}{
+	{FixedMap, "FixedMap"},
+	{NoSubdir, "NoSubdir"},
+	{Readonly, "Readonly"},
+	{WriteMap, "WriteMap"},
+	{NoMetaSync, "NoMetaSync"},
+	{NoSync, "NoSync"},
+	{MapAsync, "MapAsync"},
+	{NoTLS, "NoTLS"},
+	{NoLock, "NoLock"},
+	{NoReadahead, "NoReadahead"},
+	{NoMemInit, "NoMemInit"},
+	{PrevSnapshot, "PrevSnapshot"},
+}
+
+// EnvFlags is a set of environment flags that prints, and encodes as JSON
+// text, as the names of the flags joined by "|".
+type EnvFlags uint
+
+func (f EnvFlags) String() string {
+	var names []string
+	rest := uint(f)
+	for _, n := range envFlagNames {
+		if rest&n.flag != 0 {
+			names = append(names, n.name)
+			rest &^= n.flag
+		}
+	}
+	if rest != 0 {
+		names = append(names, fmt.Sprintf("%#x", rest))
+	}
+	if len(names) == 0 {
+		return "0"
+	}
+	return strings.Join(names, "|")
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (f EnvFlags) MarshalText() ([]byte, error) {
+	return []byte(f.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (f *EnvFlags) UnmarshalText(text []byte) error {
+	*f = 0
+	s := string(text)
+	if s == "0" || s == "" {
+		return nil
+	}
+outer:
+	for _, name := range strings.Split(s, "|") {
+		for _, n := range envFlagNames {
+			if n.name == name {
+				*f |= EnvFlags(n.flag)
+				continue outer
+			}
+		}
+		var x uint
+		_, err := fmt.Sscanf(name, "%v", &x)
+		if err != nil {
+			return fmt.Errorf("unknown environment flag %q", name)
+		}
+		*f |= EnvFlags(x)
+	}
+	return nil
+}
+
+// Config is the effective configuration of an open environment, as returned
+// by Env.Config.  It can be printed, encoded as JSON, and compared with the
+// configuration of another environment using Diff, e.g. to find out why two
+// deployments behave differently.
+type Config struct {
+	Path       string   `json:"path"`
+	Flags      EnvFlags `json:"flags"`
+	MapSize    int64    `json:"map_size"`
+	PageSize   int      `json:"page_size"`
+	MaxReaders int      `json:"max_readers"`
+	MaxDBs     int      `json:"max_dbs"` // zero if SetMaxDBs was not called through env
+	MaxKeySize int      `json:"max_key_size"`
+
+	// Sync summarizes the durability of commits made by Update: "full",
+	// "nometasync", "mapasync" or "nosync".
+	Sync string `json:"sync"`
+
+	// Transaction defaults set through Options.
+	ViewRawRead    bool     `json:"view_raw_read"`
+	UpdateFlags    EnvFlags `json:"update_flags"`
+	CheckMapExtent bool     `json:"check_map_extent"`
+
+	// ReadSlots is the size of the pool of read slots used by read
+	// transactions and SphynxReader is true if UseSphynxReader has been
+	// called.
+	ReadSlots    int  `json:"read_slots"`
+	SphynxReader bool `json:"sphynx_reader"`
+}
+
+// Config returns the effective configuration of the open environment.
+func (env *Env) Config() (*Config, error) {
+	path, err := env.Path()
+	if err != nil {
+		return nil, err
+	}
+	flags, err := env.Flags()
+	if err != nil {
+		return nil, err
+	}
+	var info EnvInfo
+	err = env.InfoInto(&info)
+	if err != nil {
+		return nil, err
+	}
+	var stat Stat
+	err = env.StatInto(&stat)
+	if err != nil {
+		return nil, err
+	}
+	return &Config{
+		Path:           path,
+		Flags:          EnvFlags(flags),
+		MapSize:        info.MapSize,
+		PageSize:       int(stat.PSize),
+		MaxReaders:     int(info.MaxReaders),
+		MaxDBs:         env.maxDBs,
+		MaxKeySize:     env.MaxKeySize(),
+		Sync:           syncPolicy(flags | env.updateFlags),
+		ViewRawRead:    env.viewRawRead,
+		UpdateFlags:    EnvFlags(env.updateFlags),
+		CheckMapExtent: env.checkMapExtent,
+		ReadSlots:      len(env.readSlots),
+		SphynxReader:   env.readWorker != nil || env.sphynxPool != nil,
+	}, nil
+}
+
+func syncPolicy(flags uint) string {
+	switch {
+	case flags&NoSync != 0:
+		return "nosync"
+	case flags&WriteMap != 0 && flags&MapAsync != 0:
+		return "mapasync"
+	case flags&NoMetaSync != 0:
+		return "nometasync"
+	}
+	return "full"
+}
+
+// String formats c with one "name: value" line per field.
+func (c *Config) String() string {
+	var buf bytes.Buffer
+	v := reflect.ValueOf(c).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		fmt.Fprintf(&buf, "%s: %v\n", v.Type().Field(i).Name, v.Field(i).Interface())
+	}
+	return buf.String()
+}
+
+// JSON returns c encoded as indented JSON.
+func (c *Config) JSON() []byte {
+	b, _ := json.MarshalIndent(c, "", "  ")
+	return b
+}
+
+// ConfigDiff is a field whose value differs between two Configs.
+type ConfigDiff struct {
+	Field string
+	A, B  interface{}
+}
+
+func (d ConfigDiff) String() string {
+	return fmt.Sprintf("%s: %v != %v", d.Field, d.A, d.B)
+}
+
+// Diff returns the fields of c whose values differ in other, in the order
+// of the fields of Config.  Path is ignored since environments compared are
+// normally at different paths.
+func (c *Config) Diff(other *Config) []ConfigDiff {
+	var diffs []ConfigDiff
+	a := reflect.ValueOf(c).Elem()
+	b := reflect.ValueOf(other).Elem()
+	for i := 0; i < a.NumField(); i++ {
+		name := a.Type().Field(i).Name
+		if name == "Path" {
+			continue
+		}
+		x, y := a.Field(i).Interface(), b.Field(i).Interface()
+		if x != y {
+			diffs = append(diffs, ConfigDiff{Field: name, A: x, B: y})
+		}
+	}
+	return diffs
+}
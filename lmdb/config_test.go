@@ -0,0 +1,62 @@
+package lmdb
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestEnv_Config(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+
+	config, err := env.Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Flags&NoTLS == 0 || config.Sync != "full" || config.PageSize == 0 || config.MaxDBs != 64<<10 {
+		t.Errorf("unexpected config:\n%v", config)
+	}
+	if !strings.Contains(config.String(), "Flags: NoTLS\n") {
+		t.Errorf("unexpected string:\n%v", config)
+	}
+
+	var decoded Config
+	err = json.Unmarshal(config.JSON(), &decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := config.Diff(&decoded); len(diff) != 0 || decoded.Path != config.Path {
+		t.Errorf("config changed in JSON round trip: %v", diff)
+	}
+
+	path, err := ioutil.TempDir("", "mdb_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(path)
+	other, err := OpenEnv(path, &Options{MaxDBs: 64 << 10, Flags: NoSync, ViewRawRead: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	otherConfig, err := other.Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var fields []string
+	for _, d := range config.Diff(otherConfig) {
+		fields = append(fields, d.String())
+	}
+	want := []string{
+		"Flags: NoTLS != NoSync|NoTLS",
+		"Sync: full != nosync",
+		"ViewRawRead: false != true",
+	}
+	if strings.Join(fields, "\n") != strings.Join(want, "\n") {
+		t.Errorf("diff:\n%s\nwant:\n%s", strings.Join(fields, "\n"), strings.Join(want, "\n"))
+	}
+}
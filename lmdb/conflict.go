@@ -0,0 +1,261 @@
+package lmdb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// ErrChangesetBase indicates changesets merged although they were not made
+// against the same snapshot.
+var ErrChangesetBase = errors.New("changesets have different base transactions")
+
+// ErrConflict indicates a merge of changesets failed by FailOnConflict.
+// The failures are returned as *ConflictError values for which
+// errors.Is(err, ErrConflict) is true.
+var ErrConflict = errors.New("changeset conflict")
+
+const changesetBaseMagic = "LMCB"
+
+// Changeset is a WriteBatch of edits made against a snapshot of an
+// environment, e.g. by an offline client, to be merged with those of others
+// before being applied to the central environment, see MergeChangesets.
+type Changeset struct {
+	// BaseTxnID is the id of the transaction the edits were made against,
+	// see Txn.ID.
+	BaseTxnID uintptr
+	Batch     *WriteBatch
+}
+
+// NewChangeset returns an empty Changeset based on the snapshot of txn.
+func NewChangeset(txn *Txn) *Changeset {
+	return &Changeset{BaseTxnID: txn.ID(), Batch: NewWriteBatch()}
+}
+
+// Marshal encodes cs, as WriteBatch.Marshal along with its base.
+func (cs *Changeset) Marshal(names map[DBI]string) ([]byte, error) {
+	batch, err := cs.Batch.Marshal(names)
+	if err != nil {
+		return nil, err
+	}
+	buf := append([]byte(changesetBaseMagic), changesetVersion)
+	buf = appendUvarint(buf, uint64(cs.BaseTxnID))
+	return append(buf, batch...), nil
+}
+
+// Unmarshal replaces the contents of cs with those encoded in data by
+// Marshal, see WriteBatch.Unmarshal.
+func (cs *Changeset) Unmarshal(data []byte, dbis map[string]DBI) error {
+	r := changesetReader{data: data}
+	magic := r.bytes(len(changesetBaseMagic))
+	if r.err == nil && string(magic) != changesetBaseMagic {
+		return errChangesetMagic
+	}
+	version := r.bytes(1)
+	if r.err == nil && version[0] != changesetVersion {
+		return fmt.Errorf("changeset: unsupported version %d", version[0])
+	}
+	base := r.uvarint()
+	if r.err != nil {
+		return r.err
+	}
+	if cs.Batch == nil {
+		cs.Batch = NewWriteBatch()
+	}
+	err := cs.Batch.Unmarshal(r.data, dbis)
+	if err != nil {
+		return err
+	}
+	cs.BaseTxnID = uintptr(base)
+	return nil
+}
+
+// Conflict is a key written differently by two changesets.  Ours and Theirs
+// are the operations of each changeset affecting the key, in order: writes
+// of the key and ranges dropped around it.
+type Conflict struct {
+	DBI    DBI
+	Key    []byte
+	Ours   []BatchOp
+	Theirs []BatchOp
+}
+
+// ConflictError describes a conflict failing a merge.
+type ConflictError struct {
+	Conflict Conflict
+}
+
+func (err *ConflictError) Error() string {
+	return fmt.Sprintf("%v: dbi %d: key %q", ErrConflict, err.Conflict.DBI, err.Conflict.Key)
+}
+
+// Is allows errors.Is(err, ErrConflict) to match a *ConflictError.
+func (err *ConflictError) Is(target error) bool {
+	return target == ErrConflict
+}
+
+// ConflictResolver decides a conflict met by MergeChangesets, returning the
+// operations to apply to the key instead of those of both changesets, or an
+// error failing the merge.  Dropped ranges returned apply to the key only.
+type ConflictResolver func(c Conflict) ([]BatchOp, error)
+
+// OursWins resolves conflicts in favor of the first changeset.
+func OursWins(c Conflict) ([]BatchOp, error) { return c.Ours, nil }
+
+// TheirsWins resolves conflicts in favor of the second changeset.
+func TheirsWins(c Conflict) ([]BatchOp, error) { return c.Theirs, nil }
+
+// FailOnConflict fails merges with a *ConflictError.
+func FailOnConflict(c Conflict) ([]BatchOp, error) { return nil, &ConflictError{Conflict: c} }
+
+// DetectConflicts returns the keys written differently by ours and theirs,
+// ordered by database and key, or ErrChangesetBase if they are not based on
+// the same snapshot.  Keys are compared bytewise.  A key conflicts when both
+// changesets affect it, one of them with a Put or a Del, and the operations
+// differ, unless both delete it.  Ranges dropped by both never conflict with
+// each other, both being deletions, and keys of DupSort databases conflict
+// as a whole, whichever of their values are written.
+func DetectConflicts(ours, theirs *Changeset) ([]Conflict, error) {
+	if ours.BaseTxnID != theirs.BaseTxnID {
+		return nil, ErrChangesetBase
+	}
+	a, b := newChangesetIndex(ours.Batch), newChangesetIndex(theirs.Batch)
+	var conflicts []Conflict
+	check := func(dbi DBI, key string) {
+		opsA, opsB := a.affecting(dbi, key), b.affecting(dbi, key)
+		if len(opsA) == 0 || len(opsB) == 0 || sameEffect(opsA, opsB) {
+			return
+		}
+		conflicts = append(conflicts, Conflict{DBI: dbi, Key: []byte(key), Ours: opsA, Theirs: opsB})
+	}
+	for k := range a.points {
+		check(k.dbi, k.key)
+	}
+	for k := range b.points {
+		if _, ok := a.points[k]; !ok {
+			check(k.dbi, k.key)
+		}
+	}
+	sort.Slice(conflicts, func(i, j int) bool {
+		ci, cj := &conflicts[i], &conflicts[j]
+		if ci.DBI != cj.DBI {
+			return ci.DBI < cj.DBI
+		}
+		return bytes.Compare(ci.Key, cj.Key) < 0
+	})
+	return conflicts, nil
+}
+
+// MergeChangesets merges theirs into ours, returning a changeset applying
+// both, with the operations on each conflicting key replaced by those
+// returned by resolve, along with the conflicts.  The operations not in
+// conflict are kept in order, those of ours first, those of theirs on keys
+// ours writes alike being dropped, and the resolutions come last, so that
+// they prevail over the ranges dropped by either changeset.
+func MergeChangesets(ours, theirs *Changeset, resolve ConflictResolver) (*Changeset, []Conflict, error) {
+	conflicts, err := DetectConflicts(ours, theirs)
+	if err != nil {
+		return nil, nil, err
+	}
+	resolved := make(map[changesetKey]bool, len(conflicts))
+	for _, c := range conflicts {
+		resolved[changesetKey{c.DBI, string(c.Key)}] = true
+	}
+	merged := &Changeset{BaseTxnID: ours.BaseTxnID, Batch: NewWriteBatch()}
+	for _, op := range ours.Batch.ops {
+		if op.Type == BatchDropRange || !resolved[changesetKey{op.DBI, string(op.Key)}] {
+			merged.Batch.ops = append(merged.Batch.ops, op)
+		}
+	}
+	// keys written by both without conflict are written alike, once.
+	a := newChangesetIndex(ours.Batch)
+	for _, op := range theirs.Batch.ops {
+		k := changesetKey{op.DBI, string(op.Key)}
+		if op.Type == BatchDropRange || !resolved[k] && a.points[k] == nil {
+			merged.Batch.ops = append(merged.Batch.ops, op)
+		}
+	}
+	for _, c := range conflicts {
+		ops, err := resolve(c)
+		if err != nil {
+			return nil, conflicts, err
+		}
+		for _, op := range ops {
+			if op.Type == BatchDropRange {
+				op = BatchOp{Type: BatchDel, DBI: c.DBI, Key: c.Key}
+			}
+			merged.Batch.ops = append(merged.Batch.ops, op)
+		}
+	}
+	return merged, conflicts, nil
+}
+
+type changesetKey struct {
+	dbi DBI
+	key string
+}
+
+// changesetIndex locates the operations of a WriteBatch by key.
+type changesetIndex struct {
+	points map[changesetKey][]int
+	ranges map[DBI][]int
+	ops    []BatchOp
+}
+
+func newChangesetIndex(b *WriteBatch) *changesetIndex {
+	x := &changesetIndex{
+		points: make(map[changesetKey][]int),
+		ranges: make(map[DBI][]int),
+		ops:    b.ops,
+	}
+	for i, op := range b.ops {
+		if op.Type == BatchDropRange {
+			x.ranges[op.DBI] = append(x.ranges[op.DBI], i)
+			continue
+		}
+		k := changesetKey{op.DBI, string(op.Key)}
+		x.points[k] = append(x.points[k], i)
+	}
+	return x
+}
+
+// affecting returns the operations affecting key in dbi, in order.
+func (x *changesetIndex) affecting(dbi DBI, key string) []BatchOp {
+	idx := append([]int(nil), x.points[changesetKey{dbi, key}]...)
+	for _, i := range x.ranges[dbi] {
+		op := &x.ops[i]
+		if bytes.Compare([]byte(key), op.Key) >= 0 && (op.End == nil || bytes.Compare([]byte(key), op.End) < 0) {
+			idx = append(idx, i)
+		}
+	}
+	sort.Ints(idx)
+	ops := make([]BatchOp, len(idx))
+	for j, i := range idx {
+		ops[j] = x.ops[i]
+	}
+	return ops
+}
+
+// sameEffect reports whether two sequences of operations on a key leave it
+// in the same state: they are equal, or both end by deleting the key.
+func sameEffect(a, b []BatchOp) bool {
+	deletes := func(ops []BatchOp) bool {
+		last := ops[len(ops)-1]
+		return last.Type == BatchDropRange || last.Type == BatchDel && len(last.Val) == 0
+	}
+	if deletes(a) && deletes(b) {
+		return true
+	}
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		x, y := &a[i], &b[i]
+		if x.Type != y.Type || x.Flags != y.Flags || !bytes.Equal(x.Key, y.Key) ||
+			!bytes.Equal(x.Val, y.Val) || !bytes.Equal(x.End, y.End) || (x.End == nil) != (y.End == nil) {
+			return false
+		}
+	}
+	return true
+}
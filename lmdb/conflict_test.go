@@ -0,0 +1,142 @@
+package lmdb
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestMergeChangesets(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+
+	var dbi DBI
+	var base uintptr
+	err := env.Update(func(txn *Txn) (err error) {
+		dbi, err = txn.OpenDBI("merge", Create)
+		if err != nil {
+			return err
+		}
+		for _, k := range []string{"a", "b", "c", "d", "m", "x"} {
+			if err = txn.Put(dbi, []byte(k), []byte("0"), 0); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ours, theirs *Changeset
+	err = env.View(func(txn *Txn) error {
+		ours, theirs = NewChangeset(txn), NewChangeset(txn)
+		base = txn.ID()
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ours.Batch.
+		Put(dbi, []byte("a"), []byte("ours")).
+		Put(dbi, []byte("b"), []byte("same")).
+		Del(dbi, []byte("c"), nil).
+		DropRange(dbi, []byte("l"), []byte("n")).
+		Put(dbi, []byte("o"), []byte("ours"))
+	theirs.Batch.
+		Put(dbi, []byte("a"), []byte("theirs")).
+		Put(dbi, []byte("b"), []byte("same")).
+		Del(dbi, []byte("c"), nil).
+		Put(dbi, []byte("d"), []byte("theirs")).
+		Put(dbi, []byte("m"), []byte("theirs")).
+		DropRange(dbi, []byte("m"), nil)
+
+	format := func(conflicts []Conflict) string {
+		var s []string
+		for _, c := range conflicts {
+			s = append(s, fmt.Sprintf("%s:%d/%d", c.Key, len(c.Ours), len(c.Theirs)))
+		}
+		return strings.Join(s, " ")
+	}
+	conflicts, err := DetectConflicts(ours, theirs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// "m" is put by theirs inside the range dropped by ours, but dropped by
+	// theirs too, and "o" is put by ours in the range dropped by theirs.
+	want := "a:1/1 o:1/1"
+	if got := format(conflicts); got != want {
+		t.Errorf("conflicts %s, want %s", got, want)
+	}
+
+	data, err := theirs.Marshal(map[DBI]string{dbi: "merge"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Changeset
+	err = decoded.Unmarshal(data, map[string]DBI{"merge": dbi})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.BaseTxnID != base || decoded.Batch.Len() != theirs.Batch.Len() {
+		t.Fatalf("decoded changeset %+v", decoded)
+	}
+
+	state := func(merged *Changeset) string {
+		var s []string
+		// applied in a transaction rolled back.
+		err := env.Update(func(txn *Txn) error {
+			err := txn.Apply(merged.Batch)
+			if err != nil {
+				return err
+			}
+			cur, err := txn.OpenCursor(dbi)
+			if err != nil {
+				return err
+			}
+			defer cur.Close()
+			for k, v, err := cur.Get(nil, nil, First); err == nil; k, v, err = cur.Get(nil, nil, Next) {
+				s = append(s, fmt.Sprintf("%s=%s", k, v))
+			}
+			return errors.New("rollback")
+		})
+		if err.Error() != "rollback" {
+			t.Fatal(err)
+		}
+		return strings.Join(s, " ")
+	}
+
+	for _, test := range []struct {
+		name    string
+		resolve ConflictResolver
+		want    string
+	}{
+		{"ours", OursWins, "a=ours b=same d=theirs o=ours"},
+		{"theirs", TheirsWins, "a=theirs b=same d=theirs"},
+		{"custom", func(c Conflict) ([]BatchOp, error) {
+			return []BatchOp{{Type: BatchPut, DBI: c.DBI, Key: c.Key, Val: []byte("both")}}, nil
+		}, "a=both b=same d=theirs o=both"},
+	} {
+		merged, _, err := MergeChangesets(ours, &decoded, test.resolve)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if merged.BaseTxnID != base {
+			t.Errorf("%s: base %d", test.name, merged.BaseTxnID)
+		}
+		if got := state(merged); got != test.want {
+			t.Errorf("%s: merged state %s, want %s", test.name, got, test.want)
+		}
+	}
+
+	_, conflicts, err = MergeChangesets(ours, theirs, FailOnConflict)
+	var cerr *ConflictError
+	if !errors.As(err, &cerr) || !errors.Is(err, ErrConflict) || string(cerr.Conflict.Key) != "a" || len(conflicts) != 2 {
+		t.Errorf("failed merge: %v", err)
+	}
+
+	other := &Changeset{BaseTxnID: base + 1, Batch: NewWriteBatch()}
+	if _, err := DetectConflicts(ours, other); err != ErrChangesetBase {
+		t.Errorf("different bases: %v", err)
+	}
+}
@@ -0,0 +1,143 @@
+package lmdb
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+var errCoopLockNoLock = errors.New("cooperative locking requires the NoLock flag")
+
+// CoopLock provides the external synchronization that LMDB requires of
+// environments opened with NoLock: write transactions exclude each other
+// and read transactions, across goroutines and across processes sharing the
+// lock file.  Without it a writer may reuse pages that a reader in another
+// process still reads, since NoLock also disables the reader table.
+//
+// Within a process a CoopLock is a sync.RWMutex; across processes it is an
+// flock(2) of its file, held shared while any local read transaction is
+// active and exclusively for a write transaction.  Unlike LMDB's own locking
+// readers and a writer never run concurrently, so a goroutine must not begin
+// a write transaction while it holds a read transaction, which would
+// deadlock.  Every process using the environment must use a CoopLock on the
+// same file.  CoopLock is not available on Windows.
+type CoopLock struct {
+	f  *os.File
+	mu sync.RWMutex
+
+	// rmu protects readers, the number of local holders of the shared lock.
+	rmu     sync.Mutex
+	readers int
+}
+
+// NewCoopLock opens (creating it if possible) the lock file at path.  The
+// file is opened read-only if it cannot be created or written, e.g. on a
+// read-only filesystem, which still allows it to be locked.
+func NewCoopLock(path string) (*CoopLock, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		f, err = os.Open(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &CoopLock{f: f}, nil
+}
+
+// Close closes the lock file, releasing any lock held.
+func (l *CoopLock) Close() error {
+	return l.f.Close()
+}
+
+// RLock acquires the lock for a read transaction.
+func (l *CoopLock) RLock() error {
+	l.mu.RLock()
+	l.rmu.Lock()
+	defer l.rmu.Unlock()
+	if l.readers == 0 {
+		err := flock(l.f, false)
+		if err != nil {
+			l.mu.RUnlock()
+			return err
+		}
+	}
+	l.readers++
+	return nil
+}
+
+// RUnlock releases a lock acquired by RLock.
+func (l *CoopLock) RUnlock() error {
+	l.rmu.Lock()
+	defer l.mu.RUnlock()
+	defer l.rmu.Unlock()
+	l.readers--
+	if l.readers == 0 {
+		return funlock(l.f)
+	}
+	return nil
+}
+
+// Lock acquires the lock for a write transaction.
+func (l *CoopLock) Lock() error {
+	l.mu.Lock()
+	err := flock(l.f, true)
+	if err != nil {
+		l.mu.Unlock()
+	}
+	return err
+}
+
+// Unlock releases a lock acquired by Lock.
+func (l *CoopLock) Unlock() error {
+	defer l.mu.Unlock()
+	return funlock(l.f)
+}
+
+// coopLockPath returns the default path of the cooperative lock file of an
+// environment at path.
+func coopLockPath(path string, flags uint) string {
+	if flags&NoSubdir != 0 {
+		return path + "-coop"
+	}
+	return filepath.Join(path, "coop.lock")
+}
+
+// Txn.coop values.
+const (
+	coopNone = iota
+	coopRead
+	coopWrite
+)
+
+// coopAcquire takes the cooperative lock of the environment, if any, for a
+// new top-level txn.
+func (txn *Txn) coopAcquire() error {
+	l := txn.env.coop
+	if l == nil {
+		return nil
+	}
+	if txn.readonly {
+		err := l.RLock()
+		if err == nil {
+			txn.coop = coopRead
+		}
+		return err
+	}
+	err := l.Lock()
+	if err == nil {
+		txn.coop = coopWrite
+	}
+	return err
+}
+
+// coopRelease releases the cooperative lock held by txn, if any.
+func (txn *Txn) coopRelease() {
+	switch txn.coop {
+	case coopRead:
+		txn.env.coop.RUnlock()
+	case coopWrite:
+		txn.env.coop.Unlock()
+	}
+	txn.coop = coopNone
+}
@@ -0,0 +1,142 @@
+//go:build !windows
+// +build !windows
+
+package lmdb
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCoopLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mdb_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "lock")
+
+	// separate CoopLocks on one file behave like separate processes.
+	l1, err := NewCoopLock(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l1.Close()
+	l2, err := NewCoopLock(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l2.Close()
+
+	err = l1.RLock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = l2.RLock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = l2.RUnlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	locked := make(chan error)
+	go func() {
+		locked <- l2.Lock()
+	}()
+	select {
+	case <-locked:
+		t.Fatal("writer locked while a reader holds the lock")
+	case <-time.After(50 * time.Millisecond):
+	}
+	err = l1.RUnlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = <-locked
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = l2.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOpenEnv_CoopLock(t *testing.T) {
+	path, err := ioutil.TempDir("", "mdb_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(path)
+
+	_, err = OpenEnv(path, &Options{CoopLock: true})
+	if err != errCoopLockNoLock {
+		t.Errorf("CoopLock without NoLock: %v", err)
+	}
+
+	env, err := OpenEnv(path, &Options{Flags: NoLock, CoopLock: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer env.Close()
+
+	viewing := make(chan struct{})
+	release := make(chan struct{})
+	viewDone := make(chan error)
+	go func() {
+		viewDone <- env.View(func(txn *Txn) error {
+			close(viewing)
+			<-release
+			return nil
+		})
+	}()
+	<-viewing
+	updated := make(chan error)
+	go func() {
+		updated <- env.Update(func(txn *Txn) error {
+			dbi, err := txn.OpenRoot(0)
+			if err != nil {
+				return err
+			}
+			return txn.Put(dbi, []byte("k"), []byte("v"), 0)
+		})
+	}()
+	select {
+	case <-updated:
+		t.Fatal("update ran during a view")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	err = <-viewDone
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = <-updated
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a reset transaction does not hold the lock.
+	txn, err := env.BeginTxn(nil, Readonly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	txn.Reset()
+	err = env.Update(func(txn *Txn) error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = txn.Renew()
+	if err != nil {
+		t.Fatal(err)
+	}
+	txn.Abort()
+	_, err = os.Stat(filepath.Join(path, "coop.lock"))
+	if err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,16 @@
+package lmdb
+
+import (
+	"errors"
+	"os"
+)
+
+var errCoopLockWindows = errors.New("cooperative locking is not supported on Windows")
+
+func flock(f *os.File, exclusive bool) error {
+	return errCoopLockWindows
+}
+
+func funlock(f *os.File) error {
+	return errCoopLockWindows
+}
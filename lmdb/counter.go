@@ -0,0 +1,89 @@
+package lmdb
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var errCounterValue = errors.New("counter shard value is not 8 bytes")
+
+// Counter is an int64 counter spread over several shard keys so that
+// frequent increments from many goroutines do not all read and rewrite the
+// same item.  Increments are applied by a BatchWriter, each goroutine
+// writing to the shard selected by its goroutine id, and reads sum the
+// shards.
+//
+// The shard keys are the counter key followed by a two byte big-endian shard
+// number; each holds a big-endian int64.
+type Counter struct {
+	w      *BatchWriter
+	dbi    DBI
+	key    []byte
+	shards int
+}
+
+// NewCounter returns a Counter stored under key in dbi using the given
+// number of shards (at least 1, at most 65536).  Increments are submitted to
+// w.
+func NewCounter(w *BatchWriter, dbi DBI, key []byte, shards int) *Counter {
+	if shards < 1 {
+		shards = 1
+	}
+	if shards > 1<<16 {
+		shards = 1 << 16
+	}
+	return &Counter{w: w, dbi: dbi, key: cloneBytes(key), shards: shards}
+}
+
+func (c *Counter) shardKey(i int) []byte {
+	k := make([]byte, len(c.key)+2)
+	copy(k, c.key)
+	binary.BigEndian.PutUint16(k[len(c.key):], uint16(i))
+	return k
+}
+
+// Add submits an increment of the counter by delta and returns immediately.
+// The returned channel receives the result of the write, see
+// BatchWriter.Enqueue.
+func (c *Counter) Add(delta int64) <-chan error {
+	k := c.shardKey(curGID() % c.shards)
+	return c.w.Enqueue(func(txn *Txn) error {
+		v, err := c.shardValue(txn, k)
+		if err != nil {
+			return err
+		}
+		var buf [8]byte
+		binary.BigEndian.PutUint64(buf[:], uint64(v+delta))
+		return txn.Put(c.dbi, k, buf[:], 0)
+	})
+}
+
+// Value returns the sum of the shards of the counter as seen by txn.
+// Increments that have not been committed by the BatchWriter are not
+// included.
+func (c *Counter) Value(txn *Txn) (int64, error) {
+	var sum int64
+	for i := 0; i < c.shards; i++ {
+		v, err := c.shardValue(txn, c.shardKey(i))
+		if err != nil {
+			return 0, err
+		}
+		sum += v
+	}
+	return sum, nil
+}
+
+func (c *Counter) shardValue(txn *Txn, k []byte) (int64, error) {
+	var buf [8]byte
+	n, err := txn.GetInto(c.dbi, k, buf[:])
+	if IsNotFound(err) {
+		return 0, nil
+	}
+	if err == ErrShortBuffer || (err == nil && n != 8) {
+		return 0, errCounterValue
+	}
+	if err != nil {
+		return 0, err
+	}
+	return int64(binary.BigEndian.Uint64(buf[:])), nil
+}
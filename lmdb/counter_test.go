@@ -0,0 +1,60 @@
+package lmdb
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestCounter(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+
+	dbi, err := openRoot(env, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := env.NewBatchWriter(nil)
+	defer w.Close()
+	c := NewCounter(w, dbi, []byte("hits"), 4)
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				c.Add(1)
+			}
+			if err := <-c.Add(-10); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	err = w.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = env.View(func(txn *Txn) error {
+		v, err := c.Value(txn)
+		if err != nil {
+			return err
+		}
+		if v != 8*90 {
+			t.Errorf("unexpected counter value: %d", v)
+		}
+		stat, err := txn.Stat(dbi)
+		if err != nil {
+			return err
+		}
+		if stat.Entries > 4 {
+			t.Errorf("too many shard keys: %d", stat.Entries)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -8,7 +8,9 @@ package lmdb
 */
 import "C"
 import (
+	"errors"
 	"runtime"
+	"syscall"
 	"unsafe"
 )
 
@@ -54,6 +56,12 @@ const (
 	AppendDup   = C.MDB_APPENDDUP   // Append an item to the database (DupSort).
 )
 
+// The special flags, which the flags passed to PutDupBatch may not include.
+const (
+	putMultiple = C.MDB_MULTIPLE
+	putReserve  = C.MDB_RESERVE
+)
+
 // Cursor operates on data inside a transaction and holds a position in the
 // database.
 //
@@ -146,6 +154,22 @@ var zerobyte = []byte{0}
 //
 // See mdb_cursor_get.
 func (c *Cursor) Get(setkey, setval []byte, op uint) (key, val []byte, err error) {
+	return c.get(setkey, setval, op, true)
+}
+
+// GetKey moves the cursor like Get but returns only the key.  The value is
+// never copied into Go memory, which makes key-only scans (auditing,
+// counting, building in-memory indexes) cheap when values are large.  LMDB
+// resolves values lazily, so the pages of large values are not touched
+// either.
+//
+// See mdb_cursor_get.
+func (c *Cursor) GetKey(setkey, setval []byte, op uint) (key []byte, err error) {
+	key, _, err = c.get(setkey, setval, op, false)
+	return key, err
+}
+
+func (c *Cursor) get(setkey, setval []byte, op uint, wantVal bool) (key, val []byte, err error) {
 	c.txn.readSlot.mu.Lock()
 	//vv("Cursor.Get called by gid=%v with slot %v", curGID(), c.txn.readSlot.slot)
 	defer c.txn.readSlot.mu.Unlock()
@@ -181,6 +205,9 @@ func (c *Cursor) Get(setkey, setval []byte, op uint) (key, val []byte, err error
 			p := make([]byte, len(setkey))
 			copy(p, setkey)
 			key = p
+			if c.txn.prof != nil {
+				c.txn.prof.copied += int64(len(p))
+			}
 		}
 	} else {
 		if c.txn.readSlot.skey == nil {
@@ -188,6 +215,78 @@ func (c *Cursor) Get(setkey, setval []byte, op uint) (key, val []byte, err error
 		}
 		key = c.txn.bytes(c.txn.readSlot.skey)
 	}
+	if wantVal {
+		val = c.txn.bytes(c.txn.readSlot.sval)
+	}
+	return key, val, nil
+}
+
+// KeyFilter is a predicate on keys evaluated in C by Cursor.GetMatch.  A key
+// matches if it begins with Prefix and its length is at least MinLen and, if
+// MaxLen is not zero, at most MaxLen.
+type KeyFilter struct {
+	Prefix []byte
+	MinLen int
+	MaxLen int
+}
+
+// matchNextOp maps the ops accepted by GetMatch to the op that continues the
+// scan after the first step.
+var matchNextOp = map[uint]uint{
+	First:     Next,
+	Last:      Prev,
+	Next:      Next,
+	Prev:      Prev,
+	NextNoDup: NextNoDup,
+	PrevNoDup: PrevNoDup,
+	NextDup:   NextDup,
+	PrevDup:   PrevDup,
+}
+
+var errMatchOp = errors.New("GetMatch: unsupported cursor op")
+
+// GetMatch moves the cursor with op and then keeps moving it in the same
+// direction until it reaches an item whose key matches f, which it returns
+// like Get.  The items skipped never cross into Go, so sparse selective scans
+// pay one cgo call per match instead of one per item.  Op must be First,
+// Last, Next, Prev, NextNoDup, PrevNoDup, NextDup or PrevDup; First and Last
+// continue with Next and Prev.  When no item matches GetMatch returns a
+// NotFound error and leaves the cursor at the end of the database (or of
+// the duplicates of the current key).
+//
+// Keys are examined in database order, so a scan for a prefix does not stop
+// after the last key with that prefix.  Position the cursor with SetRange and
+// check the keys returned to bound such scans.
+//
+// See mdb_cursor_get.
+func (c *Cursor) GetMatch(f *KeyFilter, op uint) (key, val []byte, err error) {
+	next, ok := matchNextOp[op]
+	if !ok {
+		return nil, nil, errMatchOp
+	}
+	c.txn.readSlot.mu.Lock()
+	defer c.txn.readSlot.mu.Unlock()
+
+	prefix := eb
+	if len(f.Prefix) > 0 {
+		prefix = f.Prefix
+	}
+	maxLen := C.size_t(^C.size_t(0))
+	if f.MaxLen > 0 {
+		maxLen = C.size_t(f.MaxLen)
+	}
+	ret := C.lmdbgo_mdb_cursor_get_match(
+		c._c, c.txn.readSlot.skey, c.txn.readSlot.sval,
+		C.MDB_cursor_op(op), C.MDB_cursor_op(next),
+		(*C.char)(unsafe.Pointer(&prefix[0])), C.size_t(len(f.Prefix)),
+		C.size_t(f.MinLen), maxLen,
+	)
+	c.txn.profCall(1)
+	err = operrno("mdb_cursor_get", ret)
+	if err != nil {
+		return nil, nil, err
+	}
+	key = c.txn.bytes(c.txn.readSlot.skey)
 	val = c.txn.bytes(c.txn.readSlot.sval)
 	return key, val, nil
 }
@@ -205,6 +304,7 @@ func (c *Cursor) getVal0(op uint) error {
 		key,
 		val,
 		C.MDB_cursor_op(op))
+	c.txn.profCall(1)
 	return operrno("mdb_cursor_get", ret)
 }
 
@@ -225,6 +325,7 @@ func (c *Cursor) getVal1(setkey []byte, op uint) error {
 		val,
 		C.MDB_cursor_op(op),
 	)
+	c.txn.profCall(1)
 	return operrno("mdb_cursor_get", ret)
 }
 
@@ -245,11 +346,40 @@ func (c *Cursor) getVal2(setkey, setval []byte, op uint) error {
 		val,
 		C.MDB_cursor_op(op),
 	)
+	c.txn.profCall(1)
 	return operrno("mdb_cursor_get", ret)
 }
 
+// HasDup returns true if the pair (key, val) is present in the cursor's
+// database.  HasDup does not copy the item out of the database.  On success
+// the cursor is positioned at the item, as with the GetBoth op.
+//
+// See mdb_cursor_get.
+func (c *Cursor) HasDup(key, val []byte) (bool, error) {
+	c.txn.readSlot.mu.Lock()
+	defer c.txn.readSlot.mu.Unlock()
+
+	var err error
+	switch {
+	case len(key) == 0:
+		return false, nil
+	case len(val) == 0:
+		err = c.getVal1(key, Set)
+	default:
+		err = c.getVal2(key, val, GetBoth)
+	}
+	if IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 func (c *Cursor) putNilKey(flags uint) error {
 	ret := C.lmdbgo_mdb_cursor_put2(c._c, nil, 0, nil, 0, C.uint(flags))
+	c.txn.profCall(0)
 	return operrno("mdb_cursor_put", ret)
 }
 
@@ -267,16 +397,28 @@ func (c *Cursor) Put(key, val []byte, flags uint) error {
 	ret := C.lmdbgo_mdb_cursor_put2(
 		c._c,
 		(*C.char)(unsafe.Pointer(&key[0])), C.size_t(len(key)),
-		(*C.char)(unsafe.Pointer(&val[0])), C.size_t(len(val)),
+		(*C.char)(unsafe.Pointer(&val[0])), C.size_t(vn),
 		C.uint(flags),
 	)
+	c.txn.profCall(0)
+	if ret == success && c.txn.sampled() {
+		c.txn.addHotWrite(c.DBI(), key)
+	}
+	if ret == success {
+		c.txn.captureOp(BatchPut, c.DBI(), key, val[:vn])
+	}
 	return operrno("mdb_cursor_put", ret)
 }
 
 // PutReserve returns a []byte of length n that can be written to, potentially
 // avoiding a memcopy.  The returned byte slice is only valid in txn's thread,
 // before it has terminated.
+//
+// PutReserve returns an error if the package was built with the lmdbsafe tag.
 func (c *Cursor) PutReserve(key []byte, n int, flags uint) ([]byte, error) {
+	if !unsafeViews {
+		return nil, errReserveUnsafe
+	}
 	if len(key) == 0 {
 		return nil, c.putNilKey(flags)
 	}
@@ -288,12 +430,17 @@ func (c *Cursor) PutReserve(key []byte, n int, flags uint) ([]byte, error) {
 		c.txn.readSlot.sval,
 		C.uint(flags|C.MDB_RESERVE),
 	)
+	c.txn.profCall(0)
 	err := operrno("mdb_cursor_put", ret)
 	if err != nil {
 		// jea: no! *c.txn.val = C.MDB_val{}
 		return nil, err
 	}
+	if c.txn.sampled() {
+		c.txn.addHotWrite(c.DBI(), key)
+	}
 	b := getBytes(c.txn.readSlot.sval)
+	c.txn.captureReserve(c.DBI(), key, b)
 	return b, nil
 }
 
@@ -317,14 +464,88 @@ func (c *Cursor) PutMulti(key []byte, page []byte, stride int, flags uint) error
 		(*C.char)(unsafe.Pointer(&page[0])), C.size_t(vn), C.size_t(stride),
 		C.uint(flags|C.MDB_MULTIPLE),
 	)
+	c.txn.profCall(0)
+	if ret == success && c.txn.capture {
+		for i := 0; i < vn*stride; i += stride {
+			c.txn.captureOp(BatchPut, c.DBI(), key, page[i:i+stride])
+		}
+	}
 	return operrno("mdb_cursor_put", ret)
 }
 
+// PutDupBatch stores an item for key with each of vals in a single cgo call.
+// It is meant for DupSort databases whose values vary in size, where
+// PutMulti cannot be used, such as posting lists of secondary indexes.  The
+// values are copied into one buffer and stored in order by a loop in C, so
+// the cost of a cgo call is paid once per batch instead of once per value.
+//
+// flags are passed to every store.  They may not include MDB_MULTIPLE or
+// MDB_RESERVE, which make PutDupBatch fail with syscall.EINVAL.  Without
+// NoDupData values already present under key are skipped silently; with it
+// they stop the batch with KeyExist.  PutDupBatch returns the number of
+// values stored before any error.
+//
+// See mdb_cursor_put.
+func (c *Cursor) PutDupBatch(key []byte, vals [][]byte, flags uint) (int, error) {
+	if flags&(putMultiple|putReserve) != 0 {
+		return 0, _operrno("mdb_cursor_put", int(syscall.EINVAL))
+	}
+	if len(vals) == 0 {
+		return 0, nil
+	}
+	if len(key) == 0 {
+		return 0, c.putNilKey(flags)
+	}
+	size := 0
+	for _, v := range vals {
+		size += len(v)
+	}
+	// the trailing byte keeps &buf[0] valid when every value is empty.
+	buf := make([]byte, 0, size+1)
+	vns := make([]C.size_t, len(vals))
+	for i, v := range vals {
+		buf = append(buf, v...)
+		vns[i] = C.size_t(len(v))
+	}
+	buf = append(buf, 0)
+
+	var done C.size_t
+	ret := C.lmdbgo_mdb_cursor_putdups(
+		c._c,
+		(*C.char)(unsafe.Pointer(&key[0])), C.size_t(len(key)),
+		(*C.char)(unsafe.Pointer(&buf[0])), &vns[0], C.size_t(len(vals)),
+		C.uint(flags), &done,
+	)
+	c.txn.profCall(0)
+	for _, v := range vals[:int(done)] {
+		c.txn.captureOp(BatchPut, c.DBI(), key, v)
+	}
+	return int(done), operrno("mdb_cursor_put", ret)
+}
+
 // Del deletes the item referred to by the cursor from the database.
 //
 // See mdb_cursor_del.
 func (c *Cursor) Del(flags uint) error {
+	var key, val []byte
+	if c.txn.capture {
+		var err error
+		key, val, err = c.Get(nil, nil, GetCurrent)
+		if err != nil {
+			return err
+		}
+		key = cloneBytes(key)
+		if flags&NoDupData != 0 {
+			val = nil
+		} else {
+			val = cloneBytes(val)
+		}
+	}
 	ret := C.mdb_cursor_del(c._c, C.uint(flags))
+	c.txn.profCall(0)
+	if ret == success {
+		c.txn.captureOp(BatchDel, c.DBI(), key, val)
+	}
 	return operrno("mdb_cursor_del", ret)
 }
 
@@ -8,6 +8,7 @@ import (
 	"os"
 	"reflect"
 	"runtime"
+	"syscall"
 	"testing"
 	"time"
 )
@@ -163,7 +164,46 @@ func TestCursor_bytesBuffer(t *testing.T) {
 	}
 }
 
+func TestCursor_Put_emptyValue(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+
+	var db DBI
+	err := env.Update(func(txn *Txn) (err error) {
+		db, err = txn.CreateDBI("testing")
+		if err != nil {
+			return err
+		}
+		cur, err := txn.OpenCursor(db)
+		if err != nil {
+			return err
+		}
+		defer cur.Close()
+		return cur.Put([]byte("k"), nil, 0)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = env.View(func(txn *Txn) (err error) {
+		v, err := txn.Get(db, []byte("k"))
+		if err != nil {
+			return err
+		}
+		if len(v) != 0 {
+			return fmt.Errorf("empty value stored as %q", v)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestCursor_PutReserve(t *testing.T) {
+	if !unsafeViews {
+		t.Skip("PutReserve is not supported in lmdbsafe builds")
+	}
 	env := setup(t)
 	defer clean(env, t)
 
@@ -546,6 +586,216 @@ func TestCursor_PutMulti(t *testing.T) {
 	}
 }
 
+func TestCursor_PutDupBatch(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+
+	key := []byte("k")
+	vals := [][]byte{
+		[]byte("posting-3"),
+		[]byte("p1"),
+		[]byte("post-2"),
+		[]byte("p1"),
+	}
+
+	var dbi DBI
+	err := env.Update(func(txn *Txn) (err error) {
+		dbi, err = txn.OpenRoot(Create | DupSort)
+		if err != nil {
+			return err
+		}
+		cur, err := txn.OpenCursor(dbi)
+		if err != nil {
+			return err
+		}
+		defer cur.Close()
+
+		n, err := cur.PutDupBatch(key, vals, 0)
+		if err != nil {
+			return err
+		}
+		if n != len(vals) {
+			t.Errorf("stored %d values, want %d", n, len(vals))
+		}
+		n, err = cur.PutDupBatch(key, [][]byte{[]byte("new"), []byte("p1"), []byte("unreached")}, NoDupData)
+		if !IsErrno(err, KeyExist) {
+			t.Errorf("existing value with NoDupData: %v", err)
+		}
+		if n != 1 {
+			t.Errorf("stored %d values before KeyExist, want 1", n)
+		}
+		n, err = cur.PutDupBatch(key, nil, 0)
+		if n != 0 || err != nil {
+			t.Errorf("empty batch: %d %v", n, err)
+		}
+		for _, flags := range []uint{putMultiple, putReserve} {
+			n, err = cur.PutDupBatch(key, [][]byte{[]byte("unreached")}, flags)
+			if n != 0 || !IsErrnoSys(err, syscall.EINVAL) {
+				t.Errorf("flags %#x: %d %v", flags, n, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := []string{"k=new", "k=p1", "k=post-2", "k=posting-3"}
+	var items []string
+	err = env.View(func(txn *Txn) (err error) {
+		items, err = dumpItems(txn, dbi)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(items, expect) {
+		t.Errorf("unexpected items %q (!= %q)", items, expect)
+	}
+}
+
+func TestCursor_GetMatch(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+
+	var dbi DBI
+	err := env.Update(func(txn *Txn) (err error) {
+		dbi, err = txn.OpenDBI("match", Create)
+		if err != nil {
+			return err
+		}
+		for _, k := range []string{"a", "ab", "abc", "b", "ba", "bab", "c", "cab"} {
+			err = txn.Put(dbi, []byte(k), []byte("v"+k), 0)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		f    KeyFilter
+		op   uint
+		want []string
+	}{
+		{KeyFilter{}, First, []string{"a", "ab", "abc", "b", "ba", "bab", "c", "cab"}},
+		{KeyFilter{Prefix: []byte("b")}, First, []string{"b", "ba", "bab"}},
+		{KeyFilter{Prefix: []byte("b")}, Last, []string{"bab", "ba", "b"}},
+		{KeyFilter{MinLen: 2, MaxLen: 2}, First, []string{"ab", "ba"}},
+		{KeyFilter{MinLen: 3}, Last, []string{"cab", "bab", "abc"}},
+		{KeyFilter{Prefix: []byte("ca"), MaxLen: 2}, First, nil},
+	} {
+		err = env.View(func(txn *Txn) (err error) {
+			cur, err := txn.OpenCursor(dbi)
+			if err != nil {
+				return err
+			}
+			defer cur.Close()
+			var keys []string
+			op := test.op
+			for {
+				k, v, err := cur.GetMatch(&test.f, op)
+				if IsNotFound(err) {
+					break
+				}
+				if err != nil {
+					return err
+				}
+				if string(v) != "v"+string(k) {
+					t.Errorf("key %q: value %q", k, v)
+				}
+				keys = append(keys, string(k))
+				op = matchNextOp[op]
+			}
+			if !reflect.DeepEqual(keys, test.want) {
+				t.Errorf("filter %+v op %d: %q, want %q", test.f, test.op, keys, test.want)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = env.View(func(txn *Txn) (err error) {
+		cur, err := txn.OpenCursor(dbi)
+		if err != nil {
+			return err
+		}
+		defer cur.Close()
+		_, _, err = cur.GetMatch(&KeyFilter{}, SetRange)
+		if err != errMatchOp {
+			t.Errorf("unsupported op: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCursor_GetKey(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+
+	var dbi DBI
+	err := env.Update(func(txn *Txn) (err error) {
+		dbi, err = txn.OpenDBI("keys", Create)
+		if err != nil {
+			return err
+		}
+		for _, k := range []string{"a", "b", "c"} {
+			err = txn.Put(dbi, []byte(k), make([]byte, 64<<10), 0)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = env.View(func(txn *Txn) (err error) {
+		cur, err := txn.OpenCursor(dbi)
+		if err != nil {
+			return err
+		}
+		defer cur.Close()
+		var keys []string
+		for {
+			k, err := cur.GetKey(nil, nil, Next)
+			if IsNotFound(err) {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			keys = append(keys, string(k))
+		}
+		if !reflect.DeepEqual(keys, []string{"a", "b", "c"}) {
+			t.Errorf("keys: %q", keys)
+		}
+
+		setkey := []byte("b")
+		k, err := cur.GetKey(setkey, nil, Set)
+		if err != nil {
+			return err
+		}
+		setkey[0] = 'x'
+		if string(k) != "b" {
+			t.Errorf("Set key: %q", k)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestCursor_Del(t *testing.T) {
 	env := setup(t)
 	defer clean(env, t)
@@ -706,6 +956,60 @@ func TestCursor_Count_DupSort(t *testing.T) {
 
 // This test verifies the behavior of Cursor.Count when DupSort is not enabled
 // on the database.
+func TestCursor_HasDup(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+
+	var db DBI
+	err := env.Update(func(txn *Txn) (err error) {
+		db, err = txn.OpenDBI("testingdup", Create|DupSort)
+		if err != nil {
+			return err
+		}
+		for _, v := range []string{"v0", "v1", "v2"} {
+			err = txn.Put(db, []byte("k"), []byte(v), 0)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = env.View(func(txn *Txn) (err error) {
+		cur, err := txn.OpenCursor(db)
+		if err != nil {
+			return err
+		}
+		defer cur.Close()
+
+		for _, test := range []struct {
+			k, v   string
+			expect bool
+		}{
+			{"k", "v1", true},
+			{"k", "v3", false},
+			{"j", "v1", false},
+			{"k", "", true},
+			{"j", "", false},
+		} {
+			ok, err := cur.HasDup([]byte(test.k), []byte(test.v))
+			if err != nil {
+				return err
+			}
+			if ok != test.expect {
+				t.Errorf("HasDup(%q, %q) = %v (!= %v)", test.k, test.v, ok, test.expect)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
 func TestCursor_Count_noDupSort(t *testing.T) {
 	env := setup(t)
 	defer clean(env, t)
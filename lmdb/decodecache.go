@@ -0,0 +1,256 @@
+package lmdb
+
+import (
+	"container/list"
+	"context"
+	"sync"
+)
+
+// DefaultDecodeCacheBytes is the size of the decode cache enabled by
+// EnableDecodeCache when maxBytes is not positive.
+const DefaultDecodeCacheBytes = 4 << 20
+
+// decodeEntryOverhead is the memory accounted for each entry of the decode
+// cache in addition to its key and value.
+const decodeEntryOverhead = 64
+
+// DecodeCacheStats are the counters of the decode cache of an Env.
+type DecodeCacheStats struct {
+	Hits          uint64
+	Misses        uint64
+	Invalidations uint64 // entries removed by writes
+	Flushes       uint64 // events missed, emptying the cache
+	Entries       int
+	Bytes         int64
+}
+
+// decodeCache is a LRU cache of the values decoded by Txn.GetDecoded, kept
+// consistent with the writes to the environment by a Subscription.
+//
+// An entry holds the value of a key decoded in the snapshot of transaction
+// id from, and seen is the id of the last commit whose changes were
+// applied to the cache.  An entry is valid for a snapshot id between from
+// and seen: the writes of the key committed after from and up to seen
+// removed it.  Entries are only added for snapshots not older than seen,
+// whose later writes remove them when they are applied.
+type decodeCache struct {
+	env *Env
+	sub *Subscription
+	max int64
+
+	mu      sync.Mutex
+	entries map[decodeKey]*list.Element
+	lru     list.List // of *decodeEntry, most recently used first
+	seen    uintptr
+	gap     bool // commits were dropped since seen
+	bytes   int64
+	stats   DecodeCacheStats
+}
+
+type decodeKey struct {
+	dbi DBI
+	key string
+}
+
+type decodeEntry struct {
+	decodeKey
+	val  []byte
+	from uintptr
+}
+
+// EnableDecodeCache caches the values decoded by Txn.GetDecoded in read-only
+// transactions, for the databases whose codec chains decompress or decrypt
+// values, up to maxBytes of keys and values, DefaultDecodeCacheBytes if not
+// positive, so that values read repeatedly are not decoded on every access.
+// The values returned from the cache are shared and must not be modified.
+//
+// The cache follows the writes committed to env with a Subscription, which
+// removes the entries of the keys written, and serves a transaction only
+// once the writes committed up to its snapshot have been applied.  Writes
+// made by other processes are not seen, so the cache must not be enabled for
+// an environment they write to.  If the subscription overflows the cache is
+// emptied.  Enabling the cache again replaces it with an empty one.
+// EnableDecodeCache and DisableDecodeCache must not be called concurrently.
+func (env *Env) EnableDecodeCache(maxBytes int64) error {
+	if maxBytes <= 0 {
+		maxBytes = DefaultDecodeCacheBytes
+	}
+	c := &decodeCache{
+		env:     env,
+		max:     maxBytes,
+		entries: make(map[decodeKey]*list.Element),
+	}
+	sub, err := env.Subscribe(&SubscribeOptions{Overflow: OverflowDrop})
+	if err != nil {
+		return err
+	}
+	c.sub = sub
+	// the writers that began before the subscription, whose changes are
+	// not captured, have committed once this one begins.
+	err = env.Update(func(txn *Txn) error {
+		c.seen = txn.ID() - 1
+		return nil
+	})
+	if err != nil {
+		sub.Close()
+		return err
+	}
+	done, ok := env.register("decode-cache", func() { sub.Close() })
+	if !ok {
+		sub.Close()
+		return errGoClosed
+	}
+	go func() {
+		defer done()
+		c.follow()
+	}()
+	old, _ := env.decodeCache.Load().(*decodeCache)
+	env.decodeCache.Store(c)
+	if old != nil {
+		old.sub.Close()
+	}
+	return nil
+}
+
+// DisableDecodeCache stops caching decoded values and empties the cache.
+func (env *Env) DisableDecodeCache() {
+	old, _ := env.decodeCache.Load().(*decodeCache)
+	if old != nil {
+		env.decodeCache.Store((*decodeCache)(nil))
+		old.sub.Close()
+	}
+}
+
+// DecodeCacheStats returns the counters of the decode cache of env, zero if
+// it is disabled.
+func (env *Env) DecodeCacheStats() DecodeCacheStats {
+	c, _ := env.decodeCache.Load().(*decodeCache)
+	if c == nil {
+		return DecodeCacheStats{}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.stats
+	s.Entries = len(c.entries)
+	s.Bytes = c.bytes
+	return s
+}
+
+// decodeCacheFor returns the decode cache serving the reads of dbi by txn,
+// nil if none.
+func (txn *Txn) decodeCacheFor(dbi DBI) *decodeCache {
+	if !txn.readonly {
+		return nil
+	}
+	c, _ := txn.env.decodeCache.Load().(*decodeCache)
+	if c == nil || !txn.env.codecs.costly(dbi) {
+		return nil
+	}
+	return c
+}
+
+// follow applies the changes committed to the environment to the cache,
+// until the subscription is closed.  The entries then remain valid for the
+// snapshots up to the last commit applied.
+func (c *decodeCache) follow() {
+	for {
+		ev, err := c.sub.Next(context.Background())
+		if err != nil {
+			return
+		}
+		c.apply(&ev)
+	}
+}
+
+func (c *decodeCache) apply(ev *Event) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if ev.Gap != 0 {
+		// the writes of the dropped commits are unknown, so puts are
+		// refused until the next event moves seen past them.
+		c.flushLocked()
+		c.gap = true
+		return
+	}
+	for _, op := range ev.Ops {
+		if op.Type != BatchDropRange {
+			if e, ok := c.entries[decodeKey{op.DBI, string(op.Key)}]; ok {
+				c.remove(e)
+				c.stats.Invalidations++
+			}
+			continue
+		}
+		for k, e := range c.entries {
+			if k.dbi == op.DBI && k.key >= string(op.Key) && (op.End == nil || k.key < string(op.End)) {
+				c.remove(e)
+				c.stats.Invalidations++
+			}
+		}
+	}
+	// events are published in commit order, so the commits up to TxnID
+	// have all been applied.
+	c.seen = ev.TxnID
+	c.gap = false
+}
+
+// get returns the value of key in dbi cached for snapshot id, if any.
+func (c *decodeCache) get(dbi DBI, key []byte, id uintptr) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[decodeKey{dbi, string(key)}]
+	if !ok || id > c.seen || id < e.Value.(*decodeEntry).from {
+		c.stats.Misses++
+		return nil, false
+	}
+	c.stats.Hits++
+	c.lru.MoveToFront(e)
+	return e.Value.(*decodeEntry).val, true
+}
+
+// put caches val, the value of key in dbi decoded in snapshot id, unless
+// the writes up to id are not yet applied, or commits were dropped since
+// the last event applied.
+func (c *decodeCache) put(dbi DBI, key, val []byte, id uintptr) {
+	size := int64(len(key) + len(val) + decodeEntryOverhead)
+	if size > c.max {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.gap || id < c.seen {
+		return
+	}
+	k := decodeKey{dbi, string(key)}
+	if e, ok := c.entries[k]; ok {
+		c.remove(e)
+	}
+	for c.bytes+size > c.max {
+		c.remove(c.lru.Back())
+	}
+	c.entries[k] = c.lru.PushFront(&decodeEntry{decodeKey: k, val: val, from: id})
+	c.bytes += size
+}
+
+func (c *decodeCache) remove(e *list.Element) {
+	de := c.lru.Remove(e).(*decodeEntry)
+	delete(c.entries, de.decodeKey)
+	c.bytes -= int64(len(de.key) + len(de.val) + decodeEntryOverhead)
+}
+
+func (c *decodeCache) flushLocked() {
+	c.entries = make(map[decodeKey]*list.Element)
+	c.lru.Init()
+	c.bytes = 0
+	c.stats.Flushes++
+}
+
+// flushDBI removes the entries of dbi, e.g. when its codecs change.
+func (c *decodeCache) flushDBI(dbi DBI) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k, e := range c.entries {
+		if k.dbi == dbi {
+			c.remove(e)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package lmdb
+
+import (
+	"bytes"
+	"container/list"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// waitDecodeCache waits for the decode cache of env to apply the commits up
+// to id.
+func waitDecodeCache(t *testing.T, env *Env, id uintptr) {
+	t.Helper()
+	c := env.decodeCache.Load().(*decodeCache)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		c.mu.Lock()
+		seen := c.seen
+		c.mu.Unlock()
+		if seen >= id {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("decode cache at %d, waiting for %d", seen, id)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestEnv_EnableDecodeCache(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+
+	var dbi, plain DBI
+	err := env.Update(func(txn *Txn) (err error) {
+		dbi, err = txn.OpenDBI("compressed", Create)
+		if err != nil {
+			return err
+		}
+		plain, err = txn.OpenDBI("plain", Create)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stage, err := CompressStage(9)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = env.SetCodecs(dbi, stage); err != nil {
+		t.Fatal(err)
+	}
+	if err = env.EnableDecodeCache(4 << 10); err != nil {
+		t.Fatal(err)
+	}
+	defer env.DisableDecodeCache()
+
+	put := func(key, val string) uintptr {
+		var id uintptr
+		err := env.Update(func(txn *Txn) error {
+			id = txn.ID()
+			if err := txn.PutEncoded(plain, []byte(key), []byte(val), 0); err != nil {
+				return err
+			}
+			return txn.PutEncoded(dbi, []byte(key), []byte(val), 0)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	get := func(txn *Txn, key string) string {
+		v, err := txn.GetDecoded(dbi, []byte(key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = txn.GetDecoded(plain, []byte(key)); err != nil {
+			t.Fatal(err)
+		}
+		return string(v)
+	}
+
+	waitDecodeCache(t, env, put("a", "one"))
+	err = env.View(func(txn *Txn) error {
+		for i := 0; i < 3; i++ {
+			if v := get(txn, "a"); v != "one" {
+				t.Errorf("read %q", v)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// databases without compression are not cached.
+	if s := env.DecodeCacheStats(); s.Hits != 2 || s.Misses != 1 || s.Entries != 1 {
+		t.Errorf("stats %+v", s)
+	}
+
+	// a snapshot older than a write still reads its own value.
+	old, err := env.BeginTxn(nil, Readonly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitDecodeCache(t, env, put("a", "two"))
+	if v := get(old, "a"); v != "one" {
+		t.Errorf("old snapshot read %q", v)
+	}
+	err = env.View(func(txn *Txn) error {
+		if v := get(txn, "a"); v != "two" {
+			t.Errorf("read %q after write", v)
+		}
+		if v := get(txn, "a"); v != "two" {
+			t.Errorf("cached read %q after write", v)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := get(old, "a"); v != "one" {
+		t.Errorf("old snapshot read %q from cache", v)
+	}
+	old.Abort()
+	if s := env.DecodeCacheStats(); s.Invalidations != 1 || s.Hits != 3 {
+		t.Errorf("stats %+v", s)
+	}
+
+	// write transactions see their own writes.
+	err = env.Update(func(txn *Txn) error {
+		if err := txn.PutEncoded(dbi, []byte("a"), []byte("three"), 0); err != nil {
+			return err
+		}
+		if v := get(txn, "a"); v != "three" {
+			t.Errorf("write transaction read %q", v)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the cache is bounded.
+	var id uintptr
+	err = env.Update(func(txn *Txn) error {
+		id = txn.ID()
+		for i := 0; i < 100; i++ {
+			err := txn.PutEncoded(dbi, []byte(fmt.Sprint("k", i)), bytes.Repeat([]byte{'v'}, 100), 0)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitDecodeCache(t, env, id)
+	err = env.View(func(txn *Txn) error {
+		for i := 0; i < 100; i++ {
+			if _, err := txn.GetDecoded(dbi, []byte(fmt.Sprint("k", i))); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := env.DecodeCacheStats(); s.Bytes > 4<<10 || s.Entries == 0 || s.Entries >= 100 {
+		t.Errorf("stats %+v", s)
+	}
+}
+
+func TestDecodeCache_seen(t *testing.T) {
+	c := &decodeCache{max: 1 << 10, entries: make(map[decodeKey]*list.Element), seen: 10}
+	k := []byte("k")
+	c.put(1, k, []byte("v"), 10)
+	if _, ok := c.get(1, k, 10); !ok {
+		t.Fatal("entry not cached")
+	}
+	// snapshots whose commits are not applied yet are not served.
+	if _, ok := c.get(1, k, 12); ok {
+		t.Error("served a snapshot ahead of the applied commits")
+	}
+	c.apply(&Event{TxnID: 11, Ops: []BatchOp{{Type: BatchPut, DBI: 1, Key: []byte("x")}}})
+	if _, ok := c.get(1, k, 12); ok {
+		t.Error("served a snapshot ahead of the applied commits")
+	}
+	if v, ok := c.get(1, k, 11); !ok || string(v) != "v" {
+		t.Errorf("read %q %v after an unrelated commit", v, ok)
+	}
+	// an applied write of the key removes its entry for later snapshots.
+	c.apply(&Event{TxnID: 12, Ops: []BatchOp{{Type: BatchPut, DBI: 1, Key: k}}})
+	if v, ok := c.get(1, k, 12); ok {
+		t.Errorf("read %q after the key was written", v)
+	}
+	if c.seen != 12 {
+		t.Errorf("seen %d", c.seen)
+	}
+}
+
+func TestDecodeCache_gap(t *testing.T) {
+	c := &decodeCache{max: 1 << 10, entries: make(map[decodeKey]*list.Element), seen: 10}
+	k := []byte("k")
+	c.put(1, k, []byte("v10"), 10)
+	// commit 12 writes k and is dropped.
+	c.apply(&Event{Gap: 1})
+	if _, ok := c.get(1, k, 10); ok {
+		t.Error("entry kept across a gap")
+	}
+	c.put(1, k, []byte("v11"), 11)
+	c.apply(&Event{TxnID: 13, Ops: []BatchOp{{Type: BatchPut, DBI: 1, Key: []byte("x")}}})
+	if v, ok := c.get(1, k, 13); ok {
+		t.Errorf("read %q put before the dropped commit was applied", v)
+	}
+	// puts resume once an event follows the gap.
+	c.put(1, k, []byte("v13"), 13)
+	if v, ok := c.get(1, k, 13); !ok || string(v) != "v13" {
+		t.Errorf("read %q %v after the gap", v, ok)
+	}
+}
@@ -0,0 +1,258 @@
+package lmdb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultIndexStateDB is the database recording the state of the indexes
+// built by BuildIndexes.
+const DefaultIndexStateDB = "lmdb-indexes"
+
+var errIndexOrder = errors.New("index database must use the default key and value order")
+
+// IndexFunc returns the index keys of the primary item (key, val).  It must
+// not retain its arguments.
+type IndexFunc func(key, val []byte) [][]byte
+
+// IndexSpec describes a secondary index of a primary database: a DupSort
+// database mapping each index key to the keys of the primary items it was
+// derived from, the layout read by IndexCursor.
+type IndexSpec struct {
+	Name string // recorded in the state database
+	DBI  DBI
+	Func IndexFunc
+}
+
+// BuildIndexOptions configures BuildIndexes.  The zero value selects the
+// defaults described for each field.
+type BuildIndexOptions struct {
+	// Workers is the number of goroutines scanning the primary database,
+	// GOMAXPROCS if zero.
+	Workers int
+
+	// StateDB is the database recording the state of the indexes,
+	// DefaultIndexStateDB if empty.
+	StateDB string
+
+	// BatchSize is the number of index entries written per transaction,
+	// 100000 if zero.
+	BatchSize int
+}
+
+// IndexState is the state of an index recorded by DeferIndexes and
+// BuildIndexes.
+type IndexState struct {
+	// Ready is true once the index reflects its primary database, as of
+	// the snapshot TxnID, and may be queried.
+	Ready bool
+	TxnID uintptr
+}
+
+func indexStateDB(txn *Txn, name string, flags uint) (DBI, error) {
+	if name == "" {
+		name = DefaultIndexStateDB
+	}
+	return txn.OpenDBI(name, flags)
+}
+
+// DeferIndexes records the indexes names as not queryable in txn, the first
+// transaction of a bulk load that does not maintain them, e.g. one that
+// writes the primary database with Append.  BuildIndexes makes them
+// queryable again.  stateDB is the state database, DefaultIndexStateDB if
+// empty.
+func DeferIndexes(txn *Txn, stateDB string, names ...string) error {
+	dbi, err := indexStateDB(txn, stateDB, Create)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		err = txn.Put(dbi, []byte(name), []byte("deferred"), 0)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetIndexState returns the state of index name recorded in stateDB, or
+// DefaultIndexStateDB if stateDB is empty.  An index never deferred is
+// ready, with a zero TxnID.
+func GetIndexState(txn *Txn, stateDB, name string) (IndexState, error) {
+	dbi, err := indexStateDB(txn, stateDB, 0)
+	if IsNotFound(err) {
+		return IndexState{Ready: true}, nil
+	}
+	if err != nil {
+		return IndexState{}, err
+	}
+	v, err := txn.Get(dbi, []byte(name))
+	if IsNotFound(err) {
+		return IndexState{Ready: true}, nil
+	}
+	if err != nil {
+		return IndexState{}, err
+	}
+	s := string(v)
+	if !strings.HasPrefix(s, "ready ") {
+		return IndexState{}, nil
+	}
+	id, err := strconv.ParseUint(s[len("ready "):], 10, 64)
+	if err != nil {
+		return IndexState{}, fmt.Errorf("index %q: bad state %q", name, v)
+	}
+	return IndexState{Ready: true, TxnID: uintptr(id)}, nil
+}
+
+// indexEntry is an entry of an index being built.
+type indexEntry struct {
+	key, primary []byte
+}
+
+// BuildIndexes rebuilds the indexes of specs from the primary database in
+// one parallel pass, see ParallelScan, and marks them ready as of the
+// snapshot scanned.  The entries are sorted in memory and written in order
+// with Append and AppendDup, which fills pages without traversing the
+// B-tree, replacing the previous contents of the index databases.  The
+// indexes are marked as not queryable until the last entry is written.
+//
+// The primary database must not be written during the build, which fails
+// with ErrSnapshotChanged otherwise.  Every entry is held in memory.
+func (env *Env) BuildIndexes(primary DBI, specs []IndexSpec, opts *BuildIndexOptions) error {
+	var o BuildIndexOptions
+	if opts != nil {
+		o = *opts
+	}
+	if o.Workers <= 0 {
+		o.Workers = runtime.GOMAXPROCS(0)
+	}
+	if o.BatchSize <= 0 {
+		o.BatchSize = 100000
+	}
+	names := make([]string, len(specs))
+	for i, spec := range specs {
+		names[i] = spec.Name
+	}
+	err := env.Update(func(txn *Txn) error {
+		for _, spec := range specs {
+			flags, err := txn.Flags(spec.DBI)
+			if err != nil {
+				return err
+			}
+			if flags&(ReverseKey|ReverseDup|integerDup) != 0 || flags&DupSort == 0 {
+				return errIndexOrder
+			}
+		}
+		return DeferIndexes(txn, o.StateDB, names...)
+	})
+	if err != nil {
+		return err
+	}
+
+	// entries[w][i] are those of worker w for specs[i].
+	entries := make([][][]indexEntry, o.Workers)
+	for w := range entries {
+		entries[w] = make([][]indexEntry, len(specs))
+	}
+	snapshot, err := env.parallelScan(primary, o.Workers, func(w int, k, v []byte) error {
+		var pk []byte
+		for i, spec := range specs {
+			for _, ik := range spec.Func(k, v) {
+				if pk == nil {
+					pk = cloneBytes(k)
+				}
+				entries[w][i] = append(entries[w][i], indexEntry{key: cloneBytes(ik), primary: pk})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for i, spec := range specs {
+		var all []indexEntry
+		for w := range entries {
+			all = append(all, entries[w][i]...)
+			entries[w][i] = nil
+		}
+		sort.Slice(all, func(a, b int) bool {
+			if c := bytes.Compare(all[a].key, all[b].key); c != 0 {
+				return c < 0
+			}
+			return bytes.Compare(all[a].primary, all[b].primary) < 0
+		})
+		err = env.loadIndex(spec.DBI, all, o.BatchSize)
+		if err != nil {
+			return fmt.Errorf("index %q: %v", spec.Name, err)
+		}
+	}
+
+	return env.Update(func(txn *Txn) error {
+		dbi, err := indexStateDB(txn, o.StateDB, 0)
+		if err != nil {
+			return err
+		}
+		ready := []byte("ready " + strconv.FormatUint(uint64(snapshot), 10))
+		for _, name := range names {
+			err = txn.Put(dbi, []byte(name), ready, 0)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// loadIndex replaces the contents of dbi with the sorted entries, batch
+// entries per transaction.
+func (env *Env) loadIndex(dbi DBI, entries []indexEntry, batch int) error {
+	first := true
+	var prev *indexEntry
+	for len(entries) > 0 || first {
+		n := batch
+		if n > len(entries) {
+			n = len(entries)
+		}
+		err := env.Update(func(txn *Txn) error {
+			if first {
+				err := txn.Drop(dbi, false)
+				if err != nil {
+					return err
+				}
+			}
+			cur, err := txn.OpenCursor(dbi)
+			if err != nil {
+				return err
+			}
+			defer cur.Close()
+			for j := range entries[:n] {
+				e := &entries[j]
+				flags := uint(Append)
+				if prev != nil && bytes.Equal(prev.key, e.key) {
+					if bytes.Equal(prev.primary, e.primary) {
+						continue
+					}
+					flags = AppendDup
+				}
+				err = cur.Put(e.key, e.primary, flags)
+				if err != nil {
+					return err
+				}
+				prev = e
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		first = false
+		entries = entries[n:]
+	}
+	return nil
+}
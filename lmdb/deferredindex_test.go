@@ -0,0 +1,121 @@
+package lmdb
+
+import (
+	"bytes"
+	"fmt"
+	"sync/atomic"
+	"testing"
+)
+
+func TestSplitKeys(t *testing.T) {
+	first, last := []byte("user/0000"), []byte("user/9999")
+	splits := splitKeys(first, last, 4)
+	if len(splits) != 3 {
+		t.Fatalf("%d splits", len(splits))
+	}
+	prev := first
+	for _, s := range splits {
+		if bytes.Compare(prev, s) >= 0 || bytes.Compare(s, last) > 0 {
+			t.Errorf("split %q out of order", s)
+		}
+		prev = s
+	}
+	if splits := splitKeys([]byte("a"), []byte("a\x00"), 4); len(splits) != 0 {
+		t.Errorf("splits of adjacent keys: %q", splits)
+	}
+}
+
+func TestEnv_BuildIndexes(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+
+	var primary, byCity DBI
+	err := env.Update(func(txn *Txn) (err error) {
+		primary, err = txn.OpenDBI("users", Create)
+		if err != nil {
+			return err
+		}
+		byCity, err = txn.OpenDBI("users_by_city", Create|DupSort)
+		if err != nil {
+			return err
+		}
+		if err = DeferIndexes(txn, "", "by_city"); err != nil {
+			return err
+		}
+		// bulk load without maintaining the index.
+		for i := 0; i < 1000; i++ {
+			k := []byte(fmt.Sprintf("user/%04d", i))
+			if err = txn.Put(primary, k, []byte(fmt.Sprintf("city%d", i%7)), Append); err != nil {
+				return err
+			}
+		}
+		// a stale entry replaced by the build.
+		return txn.Put(byCity, []byte("nowhere"), []byte("user/0000"), 0)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = env.View(func(txn *Txn) error {
+		s, err := GetIndexState(txn, "", "by_city")
+		if err == nil && s.Ready {
+			t.Error("deferred index is ready")
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var visited int64
+	err = env.ParallelScan(primary, 4, func(w int, k, v []byte) error {
+		atomic.AddInt64(&visited, 1)
+		return nil
+	})
+	if err != nil || visited != 1000 {
+		t.Fatalf("ParallelScan visited %d items: %v", visited, err)
+	}
+
+	spec := IndexSpec{Name: "by_city", DBI: byCity, Func: func(k, v []byte) [][]byte {
+		return [][]byte{v}
+	}}
+	err = env.BuildIndexes(primary, []IndexSpec{spec}, &BuildIndexOptions{Workers: 4, BatchSize: 300})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = env.View(func(txn *Txn) error {
+		s, err := GetIndexState(txn, "", "by_city")
+		if err != nil {
+			return err
+		}
+		if !s.Ready || s.TxnID == 0 {
+			t.Errorf("state after build: %+v", s)
+		}
+		cur, err := txn.OpenIndexCursor(byCity, primary)
+		if err != nil {
+			return err
+		}
+		defer cur.Close()
+		n := 0
+		for {
+			ik, _, v, err := cur.Get(nil, Next)
+			if IsNotFound(err) {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(ik, v) {
+				t.Errorf("index key %q for value %q", ik, v)
+			}
+			n++
+		}
+		if n != 1000 {
+			t.Errorf("%d index entries", n)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
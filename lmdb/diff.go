@@ -0,0 +1,180 @@
+package lmdb
+
+import (
+	"bytes"
+	"errors"
+)
+
+var (
+	errDiffOp    = errors.New("DiffCursor supports only First, Next and SetRange")
+	errDiffFlags = errors.New("DiffCursor databases must both or neither be DupSort")
+)
+
+// DiffKind tells how an entry differs between the databases compared by a
+// DiffCursor.
+type DiffKind int
+
+// Kinds of differences.
+const (
+	DiffAdded   DiffKind = iota + 1 // Only in the second database.
+	DiffRemoved                     // Only in the first database.
+	DiffChanged                     // In both, with different values.
+)
+
+func (k DiffKind) String() string {
+	switch k {
+	case DiffAdded:
+		return "added"
+	case DiffRemoved:
+		return "removed"
+	case DiffChanged:
+		return "changed"
+	}
+	return "DiffKind(?)"
+}
+
+// Diff is an entry differing between two databases.  A and B are its values
+// in the first and second databases, nil where it is absent.
+type Diff struct {
+	Kind DiffKind
+	Key  []byte
+	A, B []byte
+}
+
+// DiffCursor walks two databases in key order within one transaction and
+// returns the entries that differ between them, e.g. to reconcile an index
+// with the one expected from its source, or to compare a database with a
+// copy of it.  Entries of DupSort databases are (key, value) pairs, only
+// ever added or removed.
+//
+// Keys, and values of DupSort databases, are compared bytewise, so the
+// databases must not use IntegerKey, ReverseKey, IntegerDup, ReverseDup or
+// custom comparison functions.
+type DiffCursor struct {
+	a, b    mergeHead
+	curA    *Cursor
+	curB    *Cursor
+	dupsort bool
+
+	// advA and advB tell which cursors Next moves, those of the entries of
+	// the last difference returned.
+	advA, advB bool
+	positioned bool
+}
+
+// OpenDiffCursor opens a DiffCursor comparing b to a: entries of b missing
+// from a are added, entries of a missing from b are removed.  The cursor
+// must be closed before txn terminates.
+func (txn *Txn) OpenDiffCursor(a, b DBI) (*DiffCursor, error) {
+	fa, err := txn.Flags(a)
+	if err != nil {
+		return nil, err
+	}
+	fb, err := txn.Flags(b)
+	if err != nil {
+		return nil, err
+	}
+	if fa&DupSort != fb&DupSort {
+		return nil, errDiffFlags
+	}
+	d := &DiffCursor{dupsort: fa&DupSort != 0}
+	d.curA, err = txn.OpenCursor(a)
+	if err != nil {
+		return nil, err
+	}
+	d.curB, err = txn.OpenCursor(b)
+	if err != nil {
+		d.curA.Close()
+		return nil, err
+	}
+	return d, nil
+}
+
+// Close closes the cursors of d.
+func (d *DiffCursor) Close() {
+	d.curA.Close()
+	d.curB.Close()
+}
+
+// Get moves d and returns the difference it points to.  The op First
+// positions d at the first difference, SetRange at the first one with a key
+// greater than or equal to setkey, and Next at the following one; Next on
+// an unpositioned cursor is the same as First.  When there is no such
+// difference Get returns a NotFound error.
+func (d *DiffCursor) Get(setkey []byte, op uint) (Diff, error) {
+	var err error
+	switch op {
+	case First, SetRange:
+		err = d.move(d.curA, &d.a, setkey, op)
+		if err == nil {
+			err = d.move(d.curB, &d.b, setkey, op)
+		}
+	case Next:
+		if !d.positioned {
+			return d.Get(nil, First)
+		}
+		if d.advA {
+			err = d.move(d.curA, &d.a, nil, Next)
+		}
+		if err == nil && d.advB {
+			err = d.move(d.curB, &d.b, nil, Next)
+		}
+	default:
+		return Diff{}, errDiffOp
+	}
+	if err != nil {
+		return Diff{}, err
+	}
+	d.positioned = true
+
+	for {
+		d.advA, d.advB = false, false
+		c := 0
+		switch {
+		case !d.a.ok && !d.b.ok:
+			return Diff{}, &OpError{Op: "mdb_cursor_get", Errno: NotFound}
+		case !d.b.ok:
+			c = -1
+		case !d.a.ok:
+			c = 1
+		default:
+			c = bytes.Compare(d.a.key, d.b.key)
+			if c == 0 && d.dupsort {
+				c = bytes.Compare(d.a.val, d.b.val)
+			}
+		}
+		switch {
+		case c < 0:
+			d.advA = true
+			return Diff{Kind: DiffRemoved, Key: d.a.key, A: d.a.val}, nil
+		case c > 0:
+			d.advB = true
+			return Diff{Kind: DiffAdded, Key: d.b.key, B: d.b.val}, nil
+		}
+		d.advA, d.advB = true, true
+		if !d.dupsort && !bytes.Equal(d.a.val, d.b.val) {
+			return Diff{Kind: DiffChanged, Key: d.a.key, A: d.a.val, B: d.b.val}, nil
+		}
+		err = d.move(d.curA, &d.a, nil, Next)
+		if err == nil {
+			err = d.move(d.curB, &d.b, nil, Next)
+		}
+		if err != nil {
+			return Diff{}, err
+		}
+	}
+}
+
+// move moves cur with op and records the entry it points to in h, if any.
+func (d *DiffCursor) move(cur *Cursor, h *mergeHead, setkey []byte, op uint) error {
+	k, v, err := cur.Get(setkey, nil, op)
+	if IsNotFound(err) {
+		*h = mergeHead{}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	*h = mergeHead{key: k, val: v, ok: true}
+	return nil
+}
@@ -0,0 +1,94 @@
+package lmdb
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestDiffCursor(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+
+	type item struct{ k, v string }
+	var dbis []DBI
+	err := env.Update(func(txn *Txn) (err error) {
+		for i, spec := range []struct {
+			flags uint
+			items []item
+		}{
+			{0, []item{{"a", "1"}, {"b", "2"}, {"c", "3"}, {"e", "5"}}},
+			{0, []item{{"b", "2"}, {"c", "x"}, {"d", "4"}, {"e", "5"}, {"f", "6"}}},
+			{DupSort, []item{{"a", "1"}, {"a", "2"}, {"b", "1"}}},
+			{DupSort, []item{{"a", "2"}, {"a", "3"}, {"b", "1"}, {"c", "1"}}},
+		} {
+			dbi, err := txn.OpenDBI(fmt.Sprintf("diff%d", i), Create|spec.flags)
+			if err != nil {
+				return err
+			}
+			for _, it := range spec.items {
+				err = txn.Put(dbi, []byte(it.k), []byte(it.v), 0)
+				if err != nil {
+					return err
+				}
+			}
+			dbis = append(dbis, dbi)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = env.View(func(txn *Txn) error {
+		diff := func(a, b DBI, setkey []byte, op uint) []string {
+			d, err := txn.OpenDiffCursor(a, b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer d.Close()
+			var diffs []string
+			for {
+				x, err := d.Get(setkey, op)
+				if IsNotFound(err) {
+					return diffs
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				diffs = append(diffs, fmt.Sprintf("%v %s %s>%s", x.Kind, x.Key, x.A, x.B))
+				op = Next
+			}
+		}
+		for _, test := range []struct {
+			a, b   DBI
+			setkey string
+			op     uint
+			want   []string
+		}{
+			{dbis[0], dbis[1], "", Next, []string{"removed a 1>", "changed c 3>x", "added d >4", "added f >6"}},
+			{dbis[1], dbis[0], "", First, []string{"added a >1", "changed c x>3", "removed d 4>", "removed f 6>"}},
+			{dbis[0], dbis[1], "d", SetRange, []string{"added d >4", "added f >6"}},
+			{dbis[0], dbis[0], "", First, nil},
+			{dbis[2], dbis[3], "", First, []string{"removed a 1>", "added a >3", "added c >1"}},
+		} {
+			var setkey []byte
+			if test.setkey != "" {
+				setkey = []byte(test.setkey)
+			}
+			diffs := diff(test.a, test.b, setkey, test.op)
+			if !reflect.DeepEqual(diffs, test.want) {
+				t.Errorf("diff %d %d from %q: %q, want %q", test.a, test.b, test.setkey, diffs, test.want)
+			}
+		}
+
+		_, err := txn.OpenDiffCursor(dbis[0], dbis[2])
+		if err != errDiffFlags {
+			t.Errorf("diff of a DupSort database with another: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,237 @@
+package lmdb
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"sync"
+)
+
+var errDupCoalescerNotDupSort = errors.New("DupCoalescer: database is not DupSort")
+
+// integerDup is MDB_INTEGERDUP, which databases created by other programs
+// may have.
+const integerDup = 0x20
+
+// DupCoalescerOptions configures a DupCoalescer.  The zero value selects the
+// defaults described for each field.
+type DupCoalescerOptions struct {
+	// MaxValues is the number of values buffered for one key that triggers
+	// a flush, 256 if zero.
+	MaxValues int
+
+	// MaxBytes is the size of the values buffered for all keys that
+	// triggers a flush, 1 MiB if zero.
+	MaxBytes int
+}
+
+// DupCoalescer buffers values added to the keys of a DupSort database and
+// stores the values of each key together, so that workloads appending many
+// values to few keys, such as logs or index posting lists, traverse the
+// B-tree once per key and flush rather than once per value.  Values are
+// stored with Cursor.PutMulti in a DupFixed database when they have the same
+// size, and with Cursor.PutDupBatch otherwise, with AppendDup when they sort
+// after the values already stored.
+//
+// Buffered values are not visible to transactions until flushed.  A
+// DupCoalescer is safe for concurrent use.
+type DupCoalescer struct {
+	env       *Env
+	dbi       DBI
+	flags     uint
+	maxValues int
+	maxBytes  int
+
+	mu    sync.Mutex
+	keys  map[string][][]byte
+	bytes int
+	stats DupCoalescerStats
+}
+
+// DupCoalescerStats reports the activity of a DupCoalescer.
+type DupCoalescerStats struct {
+	Buffered int    // values waiting for a flush
+	Added    uint64 // values added
+	Flushes  uint64 // flushes that stored values
+	Puts     uint64 // per key batches stored
+}
+
+// NewDupCoalescer returns a DupCoalescer for dbi, which must be a DupSort
+// database of env.  A nil opts selects the defaults.
+func (env *Env) NewDupCoalescer(dbi DBI, opts *DupCoalescerOptions) (*DupCoalescer, error) {
+	var o DupCoalescerOptions
+	if opts != nil {
+		o = *opts
+	}
+	if o.MaxValues <= 0 {
+		o.MaxValues = 256
+	}
+	if o.MaxBytes <= 0 {
+		o.MaxBytes = 1 << 20
+	}
+	var flags uint
+	err := env.View(func(txn *Txn) (err error) {
+		flags, err = txn.Flags(dbi)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if flags&DupSort == 0 {
+		return nil, errDupCoalescerNotDupSort
+	}
+	return &DupCoalescer{
+		env:       env,
+		dbi:       dbi,
+		flags:     flags,
+		maxValues: o.MaxValues,
+		maxBytes:  o.MaxBytes,
+		keys:      make(map[string][][]byte),
+	}, nil
+}
+
+// Add buffers a copy of val for key, and flushes every buffered value in an
+// update if key then has MaxValues values or all keys MaxBytes bytes.  The
+// error is that of the flush, after which the values stay buffered.
+func (c *DupCoalescer) Add(key, val []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	vals := append(c.keys[string(key)], cloneBytes(val))
+	c.keys[string(key)] = vals
+	c.bytes += len(val)
+	c.stats.Buffered++
+	c.stats.Added++
+	if len(vals) < c.maxValues && c.bytes < c.maxBytes {
+		return nil
+	}
+	return c.update()
+}
+
+// Flush stores every buffered value in an update.
+func (c *DupCoalescer) Flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.keys) == 0 {
+		return nil
+	}
+	return c.update()
+}
+
+// FlushTxn stores every buffered value in txn, a write transaction of the
+// environment of c.  The values are no longer buffered once FlushTxn
+// succeeds, so they are lost if txn is then aborted.
+func (c *DupCoalescer) FlushTxn(txn *Txn) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.keys) == 0 {
+		return nil
+	}
+	err := c.flush(txn)
+	if err != nil {
+		return err
+	}
+	c.reset()
+	return nil
+}
+
+// update stores the buffered values in an update, and forgets them once
+// committed.  The caller holds c.mu.
+func (c *DupCoalescer) update() error {
+	err := c.env.Update(c.flush)
+	if err != nil {
+		return err
+	}
+	c.reset()
+	return nil
+}
+
+// reset forgets the flushed values.  The caller holds c.mu.
+func (c *DupCoalescer) reset() {
+	c.stats.Flushes++
+	c.stats.Buffered = 0
+	c.keys = make(map[string][][]byte)
+	c.bytes = 0
+}
+
+// Stats returns the activity of c.
+func (c *DupCoalescer) Stats() DupCoalescerStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+// flush stores the buffered values in txn.  The caller holds c.mu and
+// resets c once txn commits.
+func (c *DupCoalescer) flush(txn *Txn) error {
+	keys := make([]string, 0, len(c.keys))
+	for k := range c.keys {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	cur, err := txn.OpenCursor(c.dbi)
+	if err != nil {
+		return err
+	}
+	defer cur.Close()
+	for _, k := range keys {
+		err = c.put(cur, []byte(k), c.keys[k])
+		if err != nil {
+			return err
+		}
+		c.stats.Puts++
+	}
+	return nil
+}
+
+// put stores vals under key with cur.
+func (c *DupCoalescer) put(cur *Cursor, key []byte, vals [][]byte) error {
+	if c.flags&DupFixed != 0 && sameSize(vals) {
+		stride := len(vals[0])
+		page := make([]byte, 0, stride*len(vals))
+		for _, v := range vals {
+			page = append(page, v...)
+		}
+		return cur.PutMulti(key, page, stride, 0)
+	}
+	var flags uint
+	// the values must be sorted and distinct to be appended, which is only
+	// known for the default ordering.
+	if c.flags&(ReverseDup|integerDup) == 0 {
+		sort.Slice(vals, func(i, j int) bool { return bytes.Compare(vals[i], vals[j]) < 0 })
+		vals = uniqueSorted(vals)
+		_, last, err := cur.Get(key, nil, SetKey)
+		if err == nil {
+			_, last, err = cur.Get(nil, nil, LastDup)
+		}
+		switch {
+		case IsNotFound(err):
+			flags = AppendDup
+		case err != nil:
+			return err
+		case bytes.Compare(last, vals[0]) < 0:
+			flags = AppendDup
+		}
+	}
+	_, err := cur.PutDupBatch(key, vals, flags)
+	return err
+}
+
+func sameSize(vals [][]byte) bool {
+	for _, v := range vals[1:] {
+		if len(v) != len(vals[0]) {
+			return false
+		}
+	}
+	return len(vals[0]) > 0
+}
+
+// uniqueSorted removes the repeated values of sorted vals.
+func uniqueSorted(vals [][]byte) [][]byte {
+	out := vals[:1]
+	for _, v := range vals[1:] {
+		if !bytes.Equal(v, out[len(out)-1]) {
+			out = append(out, v)
+		}
+	}
+	return out
+}
@@ -0,0 +1,144 @@
+package lmdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"testing"
+)
+
+// dupValues returns the values of key in dbi.
+func dupValues(t *testing.T, env *Env, dbi DBI, key string) []string {
+	var vals []string
+	err := env.View(func(txn *Txn) error {
+		cur, err := txn.OpenCursor(dbi)
+		if err != nil {
+			return err
+		}
+		defer cur.Close()
+		_, v, err := cur.Get([]byte(key), nil, SetKey)
+		for err == nil {
+			vals = append(vals, string(v))
+			_, v, err = cur.Get(nil, nil, NextDup)
+		}
+		if IsNotFound(err) {
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return vals
+}
+
+func TestDupCoalescer(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+
+	var dbi, plain DBI
+	err := env.Update(func(txn *Txn) (err error) {
+		dbi, err = txn.OpenDBI("postings", Create|DupSort)
+		if err != nil {
+			return err
+		}
+		plain, err = txn.OpenDBI("plain", Create)
+		if err != nil {
+			return err
+		}
+		// a value sorting after the ones added keeps AppendDup off.
+		return txn.Put(dbi, []byte("b"), []byte("zz"), 0)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := env.NewDupCoalescer(plain, nil); err == nil {
+		t.Error("coalescer created for a database without DupSort")
+	}
+
+	c, err := env.NewDupCoalescer(dbi, &DupCoalescerOptions{MaxValues: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 7; i >= 0; i-- {
+		for _, k := range []string{"a", "b"} {
+			if err := c.Add([]byte(k), []byte(fmt.Sprintf("v%d", i))); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := c.Add([]byte("a"), []byte("v3")); err != nil {
+		t.Fatal(err)
+	}
+	if vals := dupValues(t, env, dbi, "a"); len(vals) != 0 {
+		t.Errorf("values visible before the flush: %q", vals)
+	}
+
+	// the tenth value of a, counting the repeated one, flushes both keys.
+	if err := c.Add([]byte("a"), []byte("v9")); err != nil {
+		t.Fatal(err)
+	}
+	stats := c.Stats()
+	if stats.Buffered != 0 || stats.Added != 18 || stats.Flushes != 1 || stats.Puts != 2 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+	want := "[v0 v1 v2 v3 v4 v5 v6 v7 v9]"
+	if vals := dupValues(t, env, dbi, "a"); fmt.Sprint(vals) != want {
+		t.Errorf("a = %v", vals)
+	}
+	want = "[v0 v1 v2 v3 v4 v5 v6 v7 zz]"
+	if vals := dupValues(t, env, dbi, "b"); fmt.Sprint(vals) != want {
+		t.Errorf("b = %v", vals)
+	}
+
+	// appended after the values stored.
+	if err := c.Add([]byte("a"), []byte("w0")); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if vals := dupValues(t, env, dbi, "a"); len(vals) != 10 || vals[9] != "w0" {
+		t.Errorf("a = %v", vals)
+	}
+}
+
+func TestDupCoalescer_fixed(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+
+	var dbi DBI
+	err := env.Update(func(txn *Txn) (err error) {
+		dbi, err = txn.OpenDBI("fixed", Create|DupSort|DupFixed)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := env.NewDupCoalescer(dbi, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := uint32(0); i < 100; i++ {
+		v := make([]byte, 4)
+		binary.BigEndian.PutUint32(v, 99-i)
+		if err := c.Add([]byte("k"), v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = env.Update(c.FlushTxn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vals := dupValues(t, env, dbi, "k")
+	if len(vals) != 100 {
+		t.Fatalf("%d values", len(vals))
+	}
+	for i, v := range vals {
+		if binary.BigEndian.Uint32([]byte(v)) != uint32(i) {
+			t.Fatalf("value %d = %x", i, v)
+		}
+	}
+	if stats := c.Stats(); stats.Buffered != 0 || stats.Flushes != 1 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
@@ -14,6 +14,8 @@ import (
 	"os"
 	"runtime"
 	"sync"
+	"sync/atomic"
+	"time"
 	"unsafe"
 
 	"github.com/glycerine/idem"
@@ -43,6 +45,11 @@ const (
 	NoLock      = C.MDB_NOLOCK     // Danger zone. LMDB does not use any locks.
 	NoReadahead = C.MDB_NORDAHEAD  // Disable readahead. Requires OS support.
 	NoMemInit   = C.MDB_NOMEMINIT  // Disable LMDB memory initialization.
+
+	// Danger zone. Open the previous snapshot instead of the latest one,
+	// see OpenWithRecovery.  The first write transaction committed makes
+	// it the latest snapshot, discarding the one skipped.
+	PrevSnapshot = C.MDB_PREVSNAPSHOT
 )
 
 // These flags are exclusively used in the Env.CopyFlags and Env.CopyFDFlags
@@ -78,6 +85,59 @@ type Env struct {
 	// maximum total readers
 	maxReaders int
 
+	// maximum named databases, as last set by SetMaxDBs
+	maxDBs int
+
+	// path passed to a successful Open, returned by Path without a cgo call
+	path string
+
+	// prof aggregates transaction profiles, see SetProfiling.
+	prof envProfile
+
+	// hot counts frequently written keys, see TrackHotKeys.
+	hot hotKeyTracker
+
+	// tempDir is the directory of an environment opened by OpenTempEnv,
+	// removed by close.
+	tempDir string
+
+	// coop synchronizes the transactions of a NoLock environment, see
+	// Options.CoopLock.
+	coop *CoopLock
+
+	// readonly is set when env was opened with the Readonly flag.
+	readonly bool
+
+	// subs holds the change subscriptions, see Subscribe.
+	subs subscriptions
+
+	// slow reports slow transactions, see SetSlowTxnLogger.
+	slow slowTxnLogger
+
+	// goros accounts for background goroutines, see Goroutines.
+	goros goroutines
+
+	// dbiNames holds the names of the databases opened, see Trace.
+	dbiNames dbiNames
+
+	// fence is the marker removed by Close, see OpenFenced.
+	fence *fence
+
+	// pins holds the snapshots pinned on purpose, see PinSnapshot.
+	pins snapshotPins
+
+	// codecs holds the value codec chains of databases, see SetCodecs.
+	codecs codecRegistry
+
+	// decodeCache holds the *decodeCache of EnableDecodeCache, nil if
+	// disabled.
+	decodeCache atomic.Value
+
+	// fin holds the finalizer policy, see SetFinalizerPolicy.  liveTxns
+	// counts the top-level transactions not yet terminated.
+	fin      envFinalizer
+	liveTxns int32
+
 	// rkeyMu and rkeyCond protects rkeyAvail and rkey
 	rkeyMu   sync.Mutex
 	rkeyCond *sync.Cond
@@ -85,6 +145,26 @@ type Env struct {
 	// available slots for readers are stored here
 	rkeyAvail []int
 
+	// rkeyReserved slots are kept for critical readers, of which
+	// rkeyCritical hold a slot, see ReserveReaders.  rkeyWaiting readers
+	// wait for a slot.
+	rkeyReserved int
+	rkeyCritical int
+	rkeyWaiting  int
+
+	// admission holds the *ReaderAdmission of SetReaderAdmission, nil if
+	// none.  rkeyRefused and rkeyDelayed count the readers it refused and
+	// queued.
+	admission   atomic.Value
+	rkeyRefused uint64
+	rkeyDelayed uint64
+
+	// ordinary readers take a ticket and are served in ticket order, see
+	// getReadSlot.  rkeyPaths counts the slots taken by each path.
+	rkeyTicket  uint64
+	rkeyServing uint64
+	rkeyPaths   [numReadPaths]readPathCounts
+
 	// keep a static pool of these, size maxReaders,
 	// to avoid a C.malloc() allocation on each read.
 	readSlots []*ReadSlot
@@ -93,6 +173,15 @@ type Env struct {
 
 	//readWorker []*sphynxReadWorker // size will be maxReaders
 	readWorker *sphynxReadWorker // elastic sizing of goro pool possible?
+
+	// sphynxPool runs the SphynxReader jobs instead of readWorker, see
+	// UseSphynxPool.
+	sphynxPool *SphynxPool
+
+	// transaction defaults configured through Options.
+	viewRawRead    bool
+	updateFlags    uint
+	checkMapExtent bool
 }
 
 type ReadSlot struct {
@@ -102,6 +191,7 @@ type ReadSlot struct {
 	mu       sync.Mutex // only one user at a time, and protect refCount/owner
 	refCount int
 	owner    int
+	path     readPath // by which the slot was taken
 }
 
 func newReadSlot(i int) (rs *ReadSlot) {
@@ -136,15 +226,55 @@ func (rs *ReadSlot) free() {
 // in the values of rs to be usable. ReturnReadSlot
 // must be called with rs again when done reading.
 func (env *Env) GetOrWaitForReadSlot() (rs *ReadSlot, err error) {
+	return env.getReadSlot(readPathDirect)
+}
+
+// getReadSlot is GetOrWaitForReadSlot for a reader coming by path.  Ordinary
+// readers, direct or SphynxReader jobs, take the free slots in the order they
+// asked for them, so that neither path can starve the other.  Critical
+// readers may take the slots reserved by ReserveReaders and go first.
+func (env *Env) getReadSlot(path readPath) (rs *ReadSlot, err error) {
+	critical := path == readPathCritical
+	if !critical {
+		err = env.admitReader()
+		if err != nil {
+			return nil, err
+		}
+	}
 	env.rkeyMu.Lock()
 	defer env.rkeyMu.Unlock()
 
-	for len(env.rkeyAvail) == 0 {
+	var ticket uint64
+	if !critical {
+		ticket = env.rkeyTicket
+		env.rkeyTicket++
+	}
+	var start time.Time
+	for !env.readSlotFree(critical) || !critical && ticket != env.rkeyServing {
 		// Wait for a ReadSlot to become available.
 		// We can block here, waiting forever if nobody else stops
 		// reading. So make sure other read transactions finish,
 		// and are as short as possible.
+		if start.IsZero() {
+			start = time.Now()
+		}
+		env.rkeyWaiting++
 		env.rkeyCond.Wait()
+		env.rkeyWaiting--
+	}
+	if !critical {
+		env.rkeyServing++
+		// the next ticket may be able to take another free slot.
+		if env.rkeyWaiting > 0 {
+			env.rkeyCond.Broadcast()
+		}
+	}
+	pc := &env.rkeyPaths[path]
+	pc.acquired++
+	pc.held++
+	if !start.IsZero() {
+		pc.waited++
+		pc.waitTime += time.Since(start)
 	}
 	i := env.rkeyAvail[0]
 	env.rkeyAvail = env.rkeyAvail[1:]
@@ -157,6 +287,10 @@ func (env *Env) GetOrWaitForReadSlot() (rs *ReadSlot, err error) {
 	}
 	rs.refCount = 1
 	rs.owner = curGID()
+	rs.path = path
+	if critical {
+		env.rkeyCritical++
+	}
 	//vv("slot %v retreived from avail pool, now owned by gid=%v", i, rs.owner)
 	rs.mu.Unlock()
 	return
@@ -178,12 +312,21 @@ func (env *Env) ReturnReadSlot(rs *ReadSlot) {
 		//vv("returned to avail, slot %v  from gid=%v", rs.slot, rs.owner)
 
 		rs.owner = 0 // not owned anymore
+		if rs.path == readPathCritical {
+			env.rkeyCritical--
+		}
+		env.rkeyPaths[rs.path].held--
+		// only the waiter holding the next ticket, or a critical
+		// reader, may take the slot, so every waiter is woken.
+		waiting := env.rkeyWaiting > 0
 
 		// can't use defer because we want to signal unlocked,
 		// to avoid spinning on Cond locks and missing the wake-up signal.
 		rs.mu.Unlock()
 		env.rkeyMu.Unlock()
-		env.rkeyCond.Signal()
+		if waiting {
+			env.rkeyCond.Broadcast()
+		}
 		return
 	}
 	rs.mu.Unlock()
@@ -227,18 +370,16 @@ func NewEnvMaxReaders(maxReaders int) (*Env, error) {
 	err := env.SetMaxReaders(maxReaders)
 	panicOn(err)
 
-	runtime.SetFinalizer(env, (*Env).Close)
+	runtime.SetFinalizer(env, (*Env).finalize)
 	return env, nil
 }
 
 // UseSphynxReader must be called before env.SphynxReader.
 // We lazily allocate the SphynxReader so it doesn't consume
-// a goroutine when it won't be used.
+// a goroutine when it won't be used.  It is UseSphynxQueue
+// with the default SphynxQueueOptions.
 func (env *Env) UseSphynxReader() {
-	if env.readWorker == nil {
-		env.readWorker = newSphynxReadWorker()
-	}
-
+	env.UseSphynxQueue(SphynxQueueOptions{})
 }
 
 // Open an environment handle. If this function fails Close() must be called to
@@ -249,6 +390,10 @@ func (env *Env) Open(path string, flags uint, mode os.FileMode) error {
 	cpath := C.CString(path)
 	defer C.free(unsafe.Pointer(cpath))
 	ret := C.mdb_env_open(env._env, cpath, C.uint(NoTLS|flags), C.mdb_mode_t(mode))
+	if ret == success {
+		env.path = path
+		env.readonly = flags&Readonly != 0
+	}
 	return operrno("mdb_env_open", ret)
 }
 
@@ -316,6 +461,9 @@ func (env *Env) ReaderCheck() (int, error) {
 }
 
 func (env *Env) close() bool {
+	// background goroutines may use env until they exit.
+	env.stopGoroutines()
+
 	env.closeLock.Lock()
 	//vv("env.close() called. stack=\n%v", stack())
 	if env._env == nil {
@@ -338,14 +486,24 @@ func (env *Env) close() bool {
 		env.readSlots[i].free()
 	}
 
+	if env.tempDir != "" {
+		os.RemoveAll(env.tempDir)
+	}
+	if env.coop != nil {
+		env.coop.Close()
+	}
 	return true
 }
 
 // Close shuts down the environment, releases the memory map, and clears the
-// finalizer on env.
+// finalizer on env.  The marker of an environment opened with OpenFenced is
+// removed first.
 //
 // See mdb_env_close.
 func (env *Env) Close() error {
+	if env.fence != nil && env._env != nil {
+		env.clearFence()
+	}
 	if env.close() {
 		runtime.SetFinalizer(env, nil)
 		return nil
@@ -405,18 +563,34 @@ type Stat struct {
 //
 // See mdb_env_stat.
 func (env *Env) Stat() (*Stat, error) {
-	var _stat C.MDB_stat
-	ret := C.mdb_env_stat(env._env, &_stat)
-	if ret != success {
-		return nil, operrno("mdb_env_stat", ret)
+	stat := new(Stat)
+	err := env.StatInto(stat)
+	if err != nil {
+		return nil, err
+	}
+	return stat, nil
+}
+
+// StatInto is like Stat but fills in stat instead of allocating a new Stat,
+// for collectors polling the environment at a high rate.
+//
+// See mdb_env_stat.
+func (env *Env) StatInto(stat *Stat) error {
+	s := C.lmdbgo_mdb_env_stat(env._env)
+	if s.rc != success {
+		return operrno("mdb_env_stat", s.rc)
 	}
-	stat := Stat{PSize: uint(_stat.ms_psize),
+	stat.fill(&s.stat)
+	return nil
+}
+
+func (stat *Stat) fill(_stat *C.MDB_stat) {
+	*stat = Stat{PSize: uint(_stat.ms_psize),
 		Depth:         uint(_stat.ms_depth),
 		BranchPages:   uint64(_stat.ms_branch_pages),
 		LeafPages:     uint64(_stat.ms_leaf_pages),
 		OverflowPages: uint64(_stat.ms_overflow_pages),
 		Entries:       uint64(_stat.ms_entries)}
-	return &stat, nil
 }
 
 // EnvInfo contains information an environment.
@@ -434,19 +608,31 @@ type EnvInfo struct {
 //
 // See mdb_env_info.
 func (env *Env) Info() (*EnvInfo, error) {
-	var _info C.MDB_envinfo
-	ret := C.mdb_env_info(env._env, &_info)
-	if ret != success {
-		return nil, operrno("mdb_env_info", ret)
+	info := new(EnvInfo)
+	err := env.InfoInto(info)
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// InfoInto is like Info but fills in info instead of allocating a new
+// EnvInfo, for collectors polling the environment at a high rate.
+//
+// See mdb_env_info.
+func (env *Env) InfoInto(info *EnvInfo) error {
+	s := C.lmdbgo_mdb_env_info(env._env)
+	if s.rc != success {
+		return operrno("mdb_env_info", s.rc)
 	}
-	info := EnvInfo{
-		MapSize:    int64(_info.me_mapsize),
-		LastPNO:    int64(_info.me_last_pgno),
-		LastTxnID:  int64(_info.me_last_txnid),
-		MaxReaders: uint(_info.me_maxreaders),
-		NumReaders: uint(_info.me_numreaders),
+	*info = EnvInfo{
+		MapSize:    int64(s.info.me_mapsize),
+		LastPNO:    int64(s.info.me_last_pgno),
+		LastTxnID:  int64(s.info.me_last_txnid),
+		MaxReaders: uint(s.info.me_maxreaders),
+		NumReaders: uint(s.info.me_numreaders),
 	}
-	return &info, nil
+	return nil
 }
 
 // Sync flushes buffers to disk.  If force is true a synchronous flush occurs
@@ -489,8 +675,13 @@ func (env *Env) Flags() (uint, error) {
 // Path returns the path argument passed to Open.  Path returns a non-nil error
 // if env.Open() was not previously called.
 //
+// Path is cached after a successful Open and does not allocate.
+//
 // See mdb_env_get_path.
 func (env *Env) Path() (string, error) {
+	if env.path != "" {
+		return env.path, nil
+	}
 	var cpath *C.char
 	ret := C.mdb_env_get_path(env._env, &cpath)
 	if ret != success {
@@ -551,6 +742,9 @@ func (env *Env) SetMaxDBs(size int) error {
 		return errNegSize
 	}
 	ret := C.mdb_env_set_maxdbs(env._env, C.MDB_dbi(size))
+	if ret == success {
+		env.maxDBs = size
+	}
 	return operrno("mdb_env_set_maxdbs", ret)
 }
 
@@ -646,6 +840,9 @@ func (env *Env) RunTxn(flags uint, fn TxnOp) error {
 //
 // Any call to Commit, Abort, Reset or Renew on a Txn created by View will
 // panic.
+//
+// If the environment was opened with Options.ViewRawRead the Txn passed to fn
+// has RawRead set.
 func (env *Env) View(fn TxnOp) error {
 	return env.run(false, Readonly, fn)
 }
@@ -673,8 +870,11 @@ func (env *Env) View(fn TxnOp) error {
 //
 // Any call to Commit, Abort, Reset or Renew on a Txn created by Update will
 // panic.
+//
+// If the environment was opened with Options.UpdateFlags those flags are
+// passed when beginning the transaction.
 func (env *Env) Update(fn TxnOp) error {
-	return env.run(true, 0, fn)
+	return env.run(true, env.updateFlags, fn)
 }
 
 // UpdateLocked behaves like Update but does not lock the calling goroutine to
@@ -695,7 +895,7 @@ func (env *Env) Update(fn TxnOp) error {
 // Any call to Commit, Abort, Reset or Renew on a Txn created by UpdateLocked
 // will panic.
 func (env *Env) UpdateLocked(fn TxnOp) error {
-	return env.run(false, 0, fn)
+	return env.run(false, env.updateFlags, fn)
 }
 
 func (env *Env) run(lock bool, flags uint, fn TxnOp) error {
@@ -707,6 +907,9 @@ func (env *Env) run(lock bool, flags uint, fn TxnOp) error {
 	if err != nil {
 		return err
 	}
+	if flags&Readonly != 0 {
+		txn.RawRead = env.viewRawRead
+	}
 	return txn.runOpTerm(fn)
 }
 
@@ -764,20 +967,26 @@ func (env *Env) newSphynxReadJob(f SphynxReadFunc) (j *sphynxReadJob) {
 // The Lion(txn) + Eagle(goroutine) = Sphynx, a hybrid creature.
 //
 // The riddle is still a mystery.
+//
+// Jobs wait in the queue configured by UseSphynxQueue while
+// every read slot is held; SphynxReader returns
+// ErrSphynxQueueFull without running srf if the queue
+// has no room for it.  With UseSphynxPool srf runs
+// on a thread of the shared pool instead.
 func (env *Env) SphynxReader(srf SphynxReadFunc) (err error) {
-	if env.readWorker == nil {
-		panic("must call env.UseSphynxReader first")
+	if p := env.sphynxPool; p != nil {
+		return p.run(env, srf)
+	}
+	w := env.readWorker
+	if w == nil {
+		panic("must call env.UseSphynxReader or env.UseSphynxPool first")
 	}
 	job := env.newSphynxReadJob(srf)
-
-	// block until we can get a read slot
-	job.readSlot, err = env.GetOrWaitForReadSlot()
-	panicOn(err)
-
-	//env.readWorker[job.readSlot.slot].jobsCh <- job
-	env.readWorker.jobsCh <- job
-	<-job.done
-	return job.err
+	err = w.submit(job)
+	if err != nil {
+		return err
+	}
+	return w.wait(job)
 }
 
 type sphynxReadWorker struct {
@@ -785,17 +994,33 @@ type sphynxReadWorker struct {
 	halt   *idem.Halter
 
 	childGoro []*idem.Halter
+
+	// queue configuration and metrics, see SphynxStats.
+	policy  SphynxQueuePolicy
+	timeout time.Duration
+	stats   sphynxStats
 }
 
-func newSphynxReadWorker() *sphynxReadWorker {
+func newSphynxReadWorker(env *Env, opts SphynxQueueOptions) *sphynxReadWorker {
+	size := opts.Size
+	if size <= 0 {
+		size = env.maxReaders
+	}
 	w := &sphynxReadWorker{
-		jobsCh: make(chan *sphynxReadJob),
-		halt:   idem.NewHalter(),
+		jobsCh:  make(chan *sphynxReadJob, size),
+		halt:    idem.NewHalter(),
+		policy:  opts.Policy,
+		timeout: opts.Timeout,
+	}
+	done, ok := env.register("sphynx-reader", func() { w.halt.ReqStop.Close() })
+	if !ok {
+		done = func() {}
 	}
 	go func() {
 		runtime.LockOSThread()
 		defer runtime.UnlockOSThread()
 
+		defer done()
 		defer w.halt.Done.Close()
 		for {
 			select {
@@ -807,13 +1032,34 @@ func newSphynxReadWorker() *sphynxReadWorker {
 				}
 				return
 			case job := <-w.jobsCh:
+				// jobs stay queued while readers are saturated.
+				rs, err := env.getReadSlot(readPathSphynx)
+				atomic.AddInt64(&w.stats.queued, -1)
+				if err == nil && w.halt.ReqStop.IsClosed() {
+					env.ReturnReadSlot(rs)
+					err = errSphynxStopped
+				}
+				if err != nil {
+					job.err = err
+					close(job.done)
+					continue
+				}
+				job.readSlot = rs
 				hlt := idem.NewHalter()
 				w.childGoro = append(w.childGoro, hlt)
+				jobDone, ok := env.register("sphynx-reader-job", nil)
+				if !ok {
+					jobDone = func() {}
+				}
 				go func(hlt *idem.Halter) {
 					runtime.LockOSThread()
 					defer runtime.UnlockOSThread()
-					defer close(job.done)
+					defer jobDone()
 					defer hlt.Done.Close()
+					// closed first, see sphynxReadWorker.wait.
+					defer close(job.done)
+					atomic.AddInt64(&w.stats.running, 1)
+					defer atomic.AddInt64(&w.stats.running, -1)
 
 					gid := curGID()
 					job.readSlot.mu.Lock()
@@ -825,7 +1071,12 @@ func newSphynxReadWorker() *sphynxReadWorker {
 					//defer vv("defer firing, done with slot %v from job", slot) // never seen
 
 					txn, err := beginTxnWithReadSlot(job.env, nil, job.flags, job.readSlot)
-					panicOn(err)
+					if err != nil {
+						// e.g. a *MapIOError, or a failed coopAcquire.
+						job.env.ReturnReadSlot(job.readSlot)
+						job.err = err
+						return
+					}
 					//vv("called beginTxnWithReadSlot(slot %v) on gid=%v", slot, gid)
 
 					// run the read-only txn code on this safely locked
@@ -246,6 +246,74 @@ func TestEnv_SetMapSize(t *testing.T) {
 	}
 }
 
+func TestEnv_StatInto(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+
+	err := env.Update(func(txn *Txn) (err error) {
+		dbi, err := txn.OpenRoot(0)
+		if err != nil {
+			return err
+		}
+		return txn.Put(dbi, []byte("k"), []byte("v"), 0)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stat Stat
+	var info EnvInfo
+	allocs := testing.AllocsPerRun(100, func() {
+		err = env.StatInto(&stat)
+		if err == nil {
+			err = env.InfoInto(&info)
+		}
+		if err == nil {
+			_, err = env.Path()
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if allocs != 0 {
+		t.Errorf("%v allocations per poll", allocs)
+	}
+
+	want, err := env.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat != *want || stat.Entries != 1 {
+		t.Errorf("StatInto: %+v, Stat: %+v", stat, *want)
+	}
+	wantInfo, err := env.Info()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info != *wantInfo || info.LastTxnID == 0 {
+		t.Errorf("InfoInto: %+v, Info: %+v", info, *wantInfo)
+	}
+
+	err = env.View(func(txn *Txn) (err error) {
+		dbi, err := txn.OpenRoot(0)
+		if err != nil {
+			return err
+		}
+		var dbstat Stat
+		err = txn.StatInto(dbi, &dbstat)
+		if err != nil {
+			return err
+		}
+		if dbstat.Entries != 1 {
+			t.Errorf("Txn.StatInto: %+v", dbstat)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
 func TestEnv_ReaderList(t *testing.T) {
 	env := setup(t)
 	defer clean(env, t)
@@ -0,0 +1,266 @@
+package lmdb
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// ErrGroupBudget indicates that opening or growing an environment of an
+// EnvGroup would exceed the map size budget of the group.  Such operations
+// fail with a *GroupBudgetError for which errors.Is(err, ErrGroupBudget) is
+// true.
+var ErrGroupBudget = errors.New("environment group map size budget exceeded")
+
+var errGroupClosed = errors.New("environment group is closed")
+
+var errNotInGroup = errors.New("environment is not in the group")
+
+// GroupBudgetError describes a map size refused by an EnvGroup.
+type GroupBudgetError struct {
+	Path      string // path of the environment
+	MapSize   int64  // map size requested for the environment
+	Available int64  // map size the group could grant the environment
+}
+
+func (err *GroupBudgetError) Error() string {
+	return fmt.Sprintf("%v: %s: map size %d, %d available", ErrGroupBudget, err.Path, err.MapSize, err.Available)
+}
+
+// Is allows errors.Is(err, ErrGroupBudget) to match a *GroupBudgetError.
+func (err *GroupBudgetError) Is(target error) bool {
+	return target == ErrGroupBudget
+}
+
+// EnvGroup manages the environments of an application opening many of them,
+// e.g. one per tenant or per partition, within a budget for the sum of their
+// map sizes.  The map size of an environment reserves address space rather
+// than memory, but the address space of a process, and the disk space the
+// environments may grow to, are finite, so an EnvGroup lets the application
+// hand out map sizes from a process-wide budget instead of sizing each
+// environment for the worst case.  Environments are opened small and grown
+// on demand with Grow.
+//
+// EnvGroup is safe for concurrent use.
+type EnvGroup struct {
+	mu     sync.Mutex
+	budget int64
+	envs   map[*Env]*groupMember
+	closed bool
+}
+
+type groupMember struct {
+	path    string
+	mapSize int64
+}
+
+// NewEnvGroup returns an empty group whose environments may have map sizes
+// adding up to budget bytes.  A budget of zero is unlimited.
+func NewEnvGroup(budget int64) *EnvGroup {
+	return &EnvGroup{budget: budget, envs: make(map[*Env]*groupMember)}
+}
+
+// Open opens an environment with OpenEnv and adds it to g.  The map size of
+// the environment, opts.MapSize or the size recorded in an existing
+// environment, must fit in the budget, or Open fails with a
+// *GroupBudgetError after closing the environment.
+func (g *EnvGroup) Open(path string, opts *Options) (*Env, error) {
+	if opts != nil && opts.MapSize != 0 {
+		g.mu.Lock()
+		avail, err := g.availableLocked()
+		g.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		if avail >= 0 && opts.MapSize > avail {
+			return nil, &GroupBudgetError{Path: path, MapSize: opts.MapSize, Available: avail}
+		}
+	}
+	env, err := OpenEnv(path, opts)
+	if err != nil {
+		return nil, err
+	}
+	err = g.Add(env)
+	if err != nil {
+		env.Close()
+		return nil, err
+	}
+	return env, nil
+}
+
+// Add adds an open environment to g, counting its current map size against
+// the budget.
+func (g *EnvGroup) Add(env *Env) error {
+	path, err := env.Path()
+	if err != nil {
+		return err
+	}
+	info, err := env.Info()
+	if err != nil {
+		return err
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if _, ok := g.envs[env]; ok {
+		return nil
+	}
+	avail, err := g.availableLocked()
+	if err != nil {
+		return err
+	}
+	if avail >= 0 && info.MapSize > avail {
+		return &GroupBudgetError{Path: path, MapSize: info.MapSize, Available: avail}
+	}
+	g.envs[env] = &groupMember{path: path, mapSize: info.MapSize}
+	return nil
+}
+
+// Remove removes env from g, releasing its share of the budget, without
+// closing it.
+func (g *EnvGroup) Remove(env *Env) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.envs, env)
+}
+
+// Available returns the part of the budget not granted to environments, or
+// -1 if the budget is unlimited.
+func (g *EnvGroup) Available() int64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	avail, _ := g.availableLocked()
+	return avail
+}
+
+func (g *EnvGroup) availableLocked() (int64, error) {
+	if g.closed {
+		return 0, errGroupClosed
+	}
+	if g.budget == 0 {
+		return -1, nil
+	}
+	avail := g.budget
+	for _, m := range g.envs {
+		avail -= m.mapSize
+	}
+	if avail < 0 {
+		avail = 0
+	}
+	return avail, nil
+}
+
+// Grow increases the map size of env, a member of g, so that at least need
+// more bytes fit beyond the pages in use.  The map size is doubled, or
+// increased by need if that is more, and capped to what the budget allows,
+// so that many environments growing in turn share the budget.  Grow fails
+// with a *GroupBudgetError if the budget cannot accommodate need.  As with
+// Env.SetMapSize, no transaction may be active in env.  Grow returns the new
+// map size.
+func (g *EnvGroup) Grow(env *Env, need int64) (int64, error) {
+	info, err := env.Info()
+	if err != nil {
+		return 0, err
+	}
+	stat, err := env.Stat()
+	if err != nil {
+		return 0, err
+	}
+	used := (info.LastPNO + 1) * int64(stat.PSize)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	m, ok := g.envs[env]
+	if !ok {
+		return 0, errNotInGroup
+	}
+	avail, err := g.availableLocked()
+	if err != nil {
+		return 0, err
+	}
+	min := used + need
+	if min <= m.mapSize {
+		return m.mapSize, nil
+	}
+	size := 2 * m.mapSize
+	if size < min {
+		size = min
+	}
+	if avail >= 0 {
+		max := m.mapSize + avail
+		if min > max {
+			return 0, &GroupBudgetError{Path: m.path, MapSize: min, Available: max}
+		}
+		if size > max {
+			size = max
+		}
+	}
+	err = env.SetMapSize(size)
+	if err != nil {
+		return 0, err
+	}
+	// LMDB rounds the map size to whole pages.
+	info, err = env.Info()
+	if err != nil {
+		return 0, err
+	}
+	m.mapSize = info.MapSize
+	return m.mapSize, nil
+}
+
+// EnvGroupStats describes the environments of an EnvGroup.
+type EnvGroupStats struct {
+	Budget  int64 // zero if unlimited
+	MapSize int64 // sum of the map sizes of the environments
+	Used    int64 // sum of the sizes of the pages in use
+	Envs    []EnvGroupMember
+}
+
+// EnvGroupMember describes an environment of an EnvGroup.
+type EnvGroupMember struct {
+	Path    string
+	MapSize int64
+	Used    int64 // size of the pages in use
+}
+
+// Stats returns the map sizes and usage of the environments of g, sorted
+// by path.
+func (g *EnvGroup) Stats() (*EnvGroupStats, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	s := &EnvGroupStats{Budget: g.budget}
+	for env, m := range g.envs {
+		info, err := env.Info()
+		if err != nil {
+			return nil, err
+		}
+		stat, err := env.Stat()
+		if err != nil {
+			return nil, err
+		}
+		used := (info.LastPNO + 1) * int64(stat.PSize)
+		s.MapSize += m.mapSize
+		s.Used += used
+		s.Envs = append(s.Envs, EnvGroupMember{Path: m.path, MapSize: m.mapSize, Used: used})
+	}
+	sort.Slice(s.Envs, func(i, j int) bool { return s.Envs[i].Path < s.Envs[j].Path })
+	return s, nil
+}
+
+// Close closes every environment of g and returns the first error
+// encountered.  Environments must not be used once Close is called, nor
+// opened through g.
+func (g *EnvGroup) Close() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.closed = true
+	var first error
+	for env := range g.envs {
+		err := env.Close()
+		if err != nil && first == nil {
+			first = err
+		}
+		delete(g.envs, env)
+	}
+	return first
+}
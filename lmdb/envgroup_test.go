@@ -0,0 +1,80 @@
+package lmdb
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEnvGroup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mdb_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	const mb = 1 << 20
+	g := NewEnvGroup(8 * mb)
+	var envs []*Env
+	for _, name := range []string{"a", "b"} {
+		path := filepath.Join(dir, name)
+		err := os.Mkdir(path, 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		env, err := g.Open(path, &Options{MapSize: 2 * mb})
+		if err != nil {
+			t.Fatal(err)
+		}
+		envs = append(envs, env)
+	}
+	if avail := g.Available(); avail != 4*mb {
+		t.Errorf("available %d", avail)
+	}
+	_, err = g.Open(filepath.Join(dir, "c"), &Options{MapSize: 5 * mb})
+	var berr *GroupBudgetError
+	if !errors.Is(err, ErrGroupBudget) || !errors.As(err, &berr) || berr.Available != 4*mb {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// growth doubles the map size within the budget.
+	size, err := g.Grow(envs[0], 3*mb)
+	if err != nil || size != 4*mb {
+		t.Fatalf("grow: %d %v", size, err)
+	}
+	size, err = g.Grow(envs[1], 3*mb)
+	if err != nil || size != 4*mb {
+		t.Fatalf("grow to the budget: %d %v", size, err)
+	}
+	_, err = g.Grow(envs[1], 8*mb)
+	if !errors.Is(err, ErrGroupBudget) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	info, err := envs[0].Info()
+	if err != nil || info.MapSize != 4*mb {
+		t.Errorf("map size %d %v", info.MapSize, err)
+	}
+
+	stats, err := g.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.MapSize != 8*mb || len(stats.Envs) != 2 || stats.Envs[0].Path != filepath.Join(dir, "a") || stats.Used == 0 {
+		t.Errorf("stats: %+v", stats)
+	}
+
+	g.Remove(envs[1])
+	if avail := g.Available(); avail != 4*mb {
+		t.Errorf("available %d after remove", avail)
+	}
+	envs[1].Close()
+	err = g.Close()
+	if err != nil {
+		t.Error(err)
+	}
+	if _, err := envs[0].Info(); err == nil {
+		t.Error("environment not closed")
+	}
+}
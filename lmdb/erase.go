@@ -0,0 +1,139 @@
+package lmdb
+
+import (
+	"errors"
+	"time"
+)
+
+// DefaultEraseBatch is the number of keys deleted per transaction by
+// EraseRange when EraseOptions.Batch is not positive.
+const DefaultEraseBatch = 1000
+
+// EraseOptions controls EraseRange and ErasePrefix.
+type EraseOptions struct {
+	// Batch is the maximum number of keys deleted by each update
+	// transaction.  Bounding transactions keeps an erase of a large range
+	// from blocking other writers or inflating the map with dirty pages.
+	Batch int
+
+	// Progress, if not nil, is called after each transaction commits.
+	Progress func(EraseProgress)
+}
+
+// EraseProgress reports the state of a running erase.
+type EraseProgress struct {
+	DBI     DBI // database the last transaction deleted from
+	Deleted int // keys deleted from DBI so far
+	Total   int // keys deleted from all databases so far
+	Txns    int // transactions committed so far
+}
+
+// EraseAudit records a completed (or failed) erase, suitable for retention
+// in a compliance log.  Keys are counted once regardless of how many
+// duplicate values were removed with them.
+type EraseAudit struct {
+	Start    []byte
+	End      []byte // nil when the range is unbounded
+	DBIs     []DBI
+	Deleted  map[DBI]int
+	Txns     int
+	Started  time.Time
+	Finished time.Time
+	Err      error // the error that stopped the erase, if any
+}
+
+// EraseRange deletes every key in [start, end) from each of dbis, so that a
+// namespace spread over data, index, history or reference databases is
+// removed together.  An empty start begins with the first key and a nil end
+// continues through the last key.
+//
+// The deletion is performed in a sequence of update transactions that each
+// delete at most opts.Batch keys, so it is not atomic: if EraseRange fails
+// part of the range may remain, and calling it again resumes the erase.  The
+// returned audit record is never nil and describes the work that was
+// committed.
+func (env *Env) EraseRange(dbis []DBI, start, end []byte, opts *EraseOptions) (*EraseAudit, error) {
+	var o EraseOptions
+	if opts != nil {
+		o = *opts
+	}
+	if o.Batch <= 0 {
+		o.Batch = DefaultEraseBatch
+	}
+	audit := &EraseAudit{
+		Start:   cloneBytes(start),
+		End:     cloneBytes(end),
+		DBIs:    append([]DBI(nil), dbis...),
+		Deleted: make(map[DBI]int, len(dbis)),
+		Started: time.Now(),
+	}
+	var total int
+	for _, dbi := range dbis {
+		for {
+			var n int
+			err := env.Update(func(txn *Txn) (err error) {
+				n, err = txn.dropRangeLimit(dbi, start, end, o.Batch)
+				return err
+			})
+			if err != nil {
+				audit.Err = err
+				audit.Finished = time.Now()
+				return audit, err
+			}
+			if n == 0 {
+				break
+			}
+			audit.Txns++
+			audit.Deleted[dbi] += n
+			total += n
+			if o.Progress != nil {
+				o.Progress(EraseProgress{
+					DBI:     dbi,
+					Deleted: audit.Deleted[dbi],
+					Total:   total,
+					Txns:    audit.Txns,
+				})
+			}
+			if n < o.Batch {
+				break
+			}
+		}
+	}
+	audit.Finished = time.Now()
+	return audit, nil
+}
+
+// ErrEmptyPrefix is returned by ErasePrefix for an empty prefix, which every
+// key begins with.  EraseRange(dbis, nil, nil, opts) erases whole databases.
+var ErrEmptyPrefix = errors.New("erase: empty prefix")
+
+// ErasePrefix deletes every key beginning with prefix from each of dbis.  See
+// EraseRange.  An empty prefix fails with ErrEmptyPrefix, without deleting
+// anything.
+func (env *Env) ErasePrefix(dbis []DBI, prefix []byte, opts *EraseOptions) (*EraseAudit, error) {
+	if len(prefix) == 0 {
+		now := time.Now()
+		audit := &EraseAudit{
+			DBIs:     append([]DBI(nil), dbis...),
+			Deleted:  make(map[DBI]int),
+			Started:  now,
+			Finished: now,
+			Err:      ErrEmptyPrefix,
+		}
+		return audit, ErrEmptyPrefix
+	}
+	return env.EraseRange(dbis, prefix, prefixEnd(prefix), opts)
+}
+
+// prefixEnd returns the smallest key greater than every key beginning with
+// prefix, or nil if there is no such key.
+func prefixEnd(prefix []byte) []byte {
+	end := cloneBytes(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package lmdb
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestEnv_ErasePrefix(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+
+	var data, index DBI
+	err := env.Update(func(txn *Txn) (err error) {
+		data, err = txn.OpenDBI("erasedata", Create)
+		if err != nil {
+			return err
+		}
+		index, err = txn.OpenDBI("eraseindex", Create|DupSort)
+		if err != nil {
+			return err
+		}
+		for _, user := range []string{"u1/", "u2/", "u3/"} {
+			for i := 0; i < 25; i++ {
+				k := []byte(fmt.Sprintf("%s%02d", user, i))
+				err = txn.Put(data, k, []byte("v"), 0)
+				if err != nil {
+					return err
+				}
+				err = txn.Put(index, k, []byte("a"), 0)
+				if err != nil {
+					return err
+				}
+				err = txn.Put(index, k, []byte("b"), 0)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var progress []EraseProgress
+	audit, err := env.ErasePrefix([]DBI{data, index}, []byte("u2/"), &EraseOptions{
+		Batch:    10,
+		Progress: func(p EraseProgress) { progress = append(progress, p) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if audit.Deleted[data] != 25 || audit.Deleted[index] != 25 {
+		t.Errorf("unexpected deletion counts: %v", audit.Deleted)
+	}
+	if audit.Txns != 6 || len(progress) != 6 {
+		t.Errorf("unexpected transaction count: %d (%d progress reports)", audit.Txns, len(progress))
+	}
+	if len(progress) > 0 && progress[len(progress)-1].Total != 50 {
+		t.Errorf("unexpected final progress: %+v", progress[len(progress)-1])
+	}
+	if !bytes.Equal(audit.End, []byte("u20")) {
+		t.Errorf("unexpected range end: %q", audit.End)
+	}
+	if audit.Finished.Before(audit.Started) {
+		t.Errorf("audit finished before it started")
+	}
+
+	// an empty prefix would erase everything.
+	for _, prefix := range [][]byte{nil, {}} {
+		audit, err = env.ErasePrefix([]DBI{data, index}, prefix, nil)
+		if err != ErrEmptyPrefix || audit.Err != ErrEmptyPrefix || audit.Txns != 0 {
+			t.Errorf("empty prefix %q: %v %+v", prefix, err, audit)
+		}
+	}
+
+	err = env.View(func(txn *Txn) (err error) {
+		for _, dbi := range []DBI{data, index} {
+			stat, err := txn.Stat(dbi)
+			if err != nil {
+				return err
+			}
+			keys := stat.Entries
+			if dbi == index {
+				keys /= 2
+			}
+			if keys != 50 {
+				t.Errorf("dbi %d: %d keys remain", dbi, keys)
+			}
+			_, err = txn.Get(dbi, []byte("u2/00"))
+			if !IsNotFound(err) {
+				t.Errorf("dbi %d: erased key found: %v", dbi, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPrefixEnd(t *testing.T) {
+	for _, test := range []struct {
+		prefix, end []byte
+	}{
+		{nil, nil},
+		{[]byte{0xff, 0xff}, nil},
+		{[]byte("ab"), []byte("ac")},
+		{[]byte{'a', 0xff}, []byte("b")},
+	} {
+		end := prefixEnd(test.prefix)
+		if !bytes.Equal(end, test.end) || (end == nil) != (test.end == nil) {
+			t.Errorf("prefixEnd(%q) = %q, want %q", test.prefix, end, test.end)
+		}
+	}
+}
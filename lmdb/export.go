@@ -0,0 +1,258 @@
+package lmdb
+
+import (
+	"errors"
+	"unsafe"
+)
+
+// DefaultExportBatch is the number of items written per destination
+// transaction by Export when ExportOptions.Batch is not positive.
+const DefaultExportBatch = 10000
+
+// DBRecordSize is the size of the MDB_db record stored in the main database
+// as the value of a named database.  Items of the main database with values
+// of other sizes are not named databases.
+const DBRecordSize = int(8 + 5*unsafe.Sizeof(uintptr(0)))
+
+var errExportSameEnv = errors.New("export source and destination are the same environment")
+
+// ExportItem is an item passed through the Transforms of an Export.  DB is
+// the name of the database the item is read from, "" for the main database.
+// A Transform may change any field, including DB to move the item to another
+// database of the destination.
+type ExportItem struct {
+	DB  string
+	Key []byte
+	Val []byte
+}
+
+// Transform rewrites an item during Export, e.g. to re-encode or re-encrypt
+// its value or to change its key.  Returning false drops the item.  An error
+// stops the export.  The slices of item are owned by the Transform, which may
+// modify them in place or replace them.
+type Transform func(item *ExportItem) (keep bool, err error)
+
+// ExportOptions controls Export.
+type ExportOptions struct {
+	// DBs names the databases to export, "" being the main database.  The
+	// keys of the main database that name other databases are never
+	// exported as items.
+	DBs []string
+
+	// Transforms are applied in order to every item read.
+	Transforms []Transform
+
+	// Batch is the maximum number of items written by each transaction on
+	// the destination.
+	Batch int
+
+	// Append writes items with the Append flag (AppendDup in DupSort
+	// databases), which is much faster but fails with KeyExist unless the
+	// destination databases are empty and the Transforms preserve key order.
+	Append bool
+
+	// Progress, if not nil, is called after each destination transaction
+	// commits.
+	Progress func(ExportStats)
+}
+
+// ExportStats counts the work done by Export.
+type ExportStats struct {
+	Read    int // items read from the source
+	Written int // items written to the destination
+	Dropped int // items dropped by a Transform
+	Txns    int // destination transactions committed
+}
+
+// Export copies the databases named in opts.DBs from a consistent snapshot of
+// env into dst, passing each item through opts.Transforms.  Destination
+// databases are created with the flags of the source databases.  Export is
+// the basic mechanism for migrating the schema or encoding of live data: env
+// remains writable while the export runs, and changes committed after Export
+// began are not seen.
+//
+// The snapshot is read in a single read transaction but the items are
+// written to dst in transactions of at most opts.Batch items, so the export
+// is not atomic on the destination.  The returned stats describe the work
+// committed, including when Export fails.
+func (env *Env) Export(dst *Env, opts *ExportOptions) (ExportStats, error) {
+	var o ExportOptions
+	if opts != nil {
+		o = *opts
+	}
+	if o.Batch <= 0 {
+		o.Batch = DefaultExportBatch
+	}
+	var stats ExportStats
+	if dst == env {
+		return stats, errExportSameEnv
+	}
+
+	e := &exporter{
+		dst:     dst,
+		opts:    &o,
+		stats:   &stats,
+		flags:   make(map[string]uint),
+		created: make(map[string]bool),
+	}
+	err := env.View(func(txn *Txn) (err error) {
+		// Transforms own the slices they are given.
+		txn.RawRead = false
+		for _, name := range o.DBs {
+			err = e.exportDB(txn, name)
+			if err != nil {
+				return err
+			}
+		}
+		return e.flush()
+	})
+	return stats, err
+}
+
+type exporter struct {
+	dst   *Env
+	opts  *ExportOptions
+	stats *ExportStats
+	flags map[string]uint // flags of the destination databases by name
+	batch []ExportItem
+
+	// created holds the destination databases opened by a committed
+	// transaction.
+	created map[string]bool
+}
+
+func (e *exporter) exportDB(txn *Txn, name string) error {
+	var dbi DBI
+	var err error
+	if name == "" {
+		dbi, err = txn.OpenRoot(0)
+	} else {
+		dbi, err = txn.OpenDBI(name, 0)
+	}
+	if err != nil {
+		return err
+	}
+	flags, err := txn.Flags(dbi)
+	if err != nil {
+		return err
+	}
+	e.flags[name] = flags
+
+	cur, err := txn.OpenCursor(dbi)
+	if err != nil {
+		return err
+	}
+	defer cur.Close()
+	for {
+		k, v, err := cur.Get(nil, nil, Next)
+		if IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if name == "" {
+			isDB, err := txn.isDBName(k, v)
+			if err != nil {
+				return err
+			}
+			if isDB {
+				continue
+			}
+		}
+		e.stats.Read++
+		item := ExportItem{DB: name, Key: k, Val: v}
+		keep, err := e.transform(&item)
+		if err != nil {
+			return err
+		}
+		if !keep {
+			e.stats.Dropped++
+			continue
+		}
+		if _, ok := e.flags[item.DB]; !ok {
+			e.flags[item.DB] = flags
+		}
+		e.batch = append(e.batch, item)
+		if len(e.batch) >= e.opts.Batch {
+			err = e.flush()
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (e *exporter) transform(item *ExportItem) (bool, error) {
+	for _, t := range e.opts.Transforms {
+		keep, err := t(item)
+		if err != nil || !keep {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// flush writes the pending items to the destination in one transaction,
+// creating any destination database not created yet, even if empty.
+func (e *exporter) flush() error {
+	if len(e.batch) == 0 && len(e.created) == len(e.flags) {
+		return nil
+	}
+	err := e.dst.Update(func(txn *Txn) (err error) {
+		dbis := make(map[string]DBI)
+		for name, flags := range e.flags {
+			if name == "" {
+				dbis[name], err = txn.OpenRoot(flags)
+			} else {
+				dbis[name], err = txn.OpenDBI(name, flags|Create)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		for _, item := range e.batch {
+			dbi := dbis[item.DB]
+			var putFlags uint
+			if e.opts.Append {
+				putFlags = Append
+				if e.flags[item.DB]&DupSort != 0 {
+					putFlags = AppendDup
+				}
+			}
+			err = txn.Put(dbi, item.Key, item.Val, putFlags)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for name := range e.flags {
+		e.created[name] = true
+	}
+	e.stats.Written += len(e.batch)
+	e.stats.Txns++
+	e.batch = e.batch[:0]
+	if e.opts.Progress != nil {
+		e.opts.Progress(*e.stats)
+	}
+	return nil
+}
+
+// isDBName reports whether the item k, v of the main database is the record
+// of a named database.  Without a free DBI slot, when MaxDBs is zero or
+// reached, a database not yet open cannot be told apart from a value of the
+// same size, and is reported as a value.
+func (txn *Txn) isDBName(k, v []byte) (bool, error) {
+	if len(v) != DBRecordSize {
+		return false, nil
+	}
+	_, err := txn.OpenDBI(string(k), 0)
+	if IsErrno(err, Incompatible) || IsErrno(err, DBsFull) {
+		return false, nil
+	}
+	return err == nil, err
+}
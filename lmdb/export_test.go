@@ -0,0 +1,169 @@
+package lmdb
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestEnv_Export(t *testing.T) {
+	src := setup(t)
+	defer clean(src, t)
+	dst := setup(t)
+	defer clean(dst, t)
+
+	err := src.Update(func(txn *Txn) (err error) {
+		root, err := txn.OpenRoot(0)
+		if err != nil {
+			return err
+		}
+		err = txn.Put(root, []byte("version"), []byte("1"), 0)
+		if err != nil {
+			return err
+		}
+		users, err := txn.OpenDBI("users", Create)
+		if err != nil {
+			return err
+		}
+		for _, k := range []string{"ann", "bob", "cat", "dan"} {
+			err = txn.Put(users, []byte(k), []byte(k+"@example.com"), 0)
+			if err != nil {
+				return err
+			}
+		}
+		tags, err := txn.OpenDBI("tags", Create|DupSort)
+		if err != nil {
+			return err
+		}
+		for _, kv := range [][2]string{{"go", "cgo"}, {"go", "lmdb"}, {"c", "mdb"}} {
+			err = txn.Put(tags, []byte(kv[0]), []byte(kv[1]), 0)
+			if err != nil {
+				return err
+			}
+		}
+		_, err = txn.OpenDBI("empty", Create)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var progress []ExportStats
+	stats, err := src.Export(dst, &ExportOptions{
+		DBs: []string{"", "users", "tags", "empty"},
+		Transforms: []Transform{
+			func(item *ExportItem) (bool, error) {
+				return !bytes.Equal(item.Key, []byte("bob")), nil
+			},
+			func(item *ExportItem) (bool, error) {
+				if item.DB == "users" {
+					item.Val = bytes.ToUpper(item.Val)
+				}
+				if item.DB == "" {
+					item.DB = "meta"
+				}
+				return true, nil
+			},
+		},
+		Batch:    3,
+		Progress: func(s ExportStats) { progress = append(progress, s) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ExportStats{Read: 8, Written: 7, Dropped: 1, Txns: 3}
+	if stats != want {
+		t.Errorf("stats: %+v, want %+v", stats, want)
+	}
+	if len(progress) != 3 || progress[2] != want {
+		t.Errorf("progress: %+v", progress)
+	}
+
+	err = dst.View(func(txn *Txn) (err error) {
+		for name, want := range map[string][]string{
+			"meta":  {"version=1"},
+			"users": {"ann=ANN@EXAMPLE.COM", "cat=CAT@EXAMPLE.COM", "dan=DAN@EXAMPLE.COM"},
+			"tags":  {"c=mdb", "go=cgo", "go=lmdb"},
+			"empty": nil,
+		} {
+			dbi, err := txn.OpenDBI(name, 0)
+			if err != nil {
+				return err
+			}
+			items, err := dumpItems(txn, dbi)
+			if err != nil {
+				return err
+			}
+			if !reflect.DeepEqual(items, want) {
+				t.Errorf("%s: %q, want %q", name, items, want)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the destination is not empty so appending fails.
+	_, err = src.Export(dst, &ExportOptions{DBs: []string{"users"}, Append: true})
+	if !IsErrno(err, KeyExist) {
+		t.Errorf("append to existing items: %v", err)
+	}
+
+	_, err = src.Export(src, nil)
+	if err != errExportSameEnv {
+		t.Errorf("export to self: %v", err)
+	}
+}
+
+func TestEnv_Export_noMaxDBs(t *testing.T) {
+	path, err := ioutil.TempDir("", "mdb_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(path)
+	src, err := OpenEnv(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	dst := setup(t)
+	defer clean(dst, t)
+
+	// a value the size of a database record is not taken for one without
+	// a free DBI slot to check it.
+	val := bytes.Repeat([]byte{'v'}, DBRecordSize)
+	err = src.Update(func(txn *Txn) error {
+		root, err := txn.OpenRoot(0)
+		if err != nil {
+			return err
+		}
+		return txn.Put(root, []byte("k"), val, 0)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = src.Export(dst, &ExportOptions{DBs: []string{""}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = dst.View(func(txn *Txn) error {
+		root, err := txn.OpenRoot(0)
+		if err != nil {
+			return err
+		}
+		v, err := txn.Get(root, []byte("k"))
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(v, val) {
+			t.Errorf("exported %q", v)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,194 @@
+package lmdb
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// DefaultFenceDB is the database holding the marker of OpenFenced.
+const DefaultFenceDB = "lmdb-fence"
+
+var fenceKey = []byte("open")
+
+// FenceOptions configures the checks run by OpenFenced after an unclean
+// shutdown.
+type FenceOptions struct {
+	// DB is the named database holding the marker, DefaultFenceDB if
+	// empty.  It takes one of the named databases allowed by MaxDBs.
+	DB string
+
+	// ReaderCheck clears the reader lock table entries of dead processes,
+	// see Env.ReaderCheck.
+	ReaderCheck bool
+
+	// Verify names databases whose every item is read, so that damaged
+	// pages of critical data are found at startup rather than by requests.
+	// The empty name is the root database.
+	Verify []string
+
+	// Repairs run in order after the checks, e.g. to replay an intent log
+	// kept by the application.  The first one failing fails OpenFenced.
+	Repairs []func(env *Env) error
+}
+
+// FenceReport describes what OpenFenced found and did.
+type FenceReport struct {
+	// Unclean is true if the previous session did not close the
+	// environment with Close, e.g. because the process crashed.
+	Unclean bool
+
+	// Previous describes the unclean session: its process id, host and
+	// open time.
+	Previous string
+
+	// StaleReaders is the number of reader entries cleared by ReaderCheck.
+	StaleReaders int
+
+	// Verified maps the databases of Verify to the number of items read.
+	Verified map[string]int
+}
+
+// fence is the marker of an environment opened with OpenFenced.
+type fence struct {
+	db string
+}
+
+// OpenFenced opens the environment at path like OpenEnv, and, if the
+// previous session using it ended without Close, runs the checks and repairs
+// configured by fence before returning it, so that the application never
+// sees an environment left inconsistent by a crash.  It then writes a
+// marker that Close removes.  A nil fence only clears stale readers.  If a
+// check or repair fails the environment is closed and the error returned
+// with the report.
+//
+// The marker only describes one session at a time, so an environment
+// shared by several processes should be fenced by one of them.
+func OpenFenced(path string, opts *Options, fence *FenceOptions) (*Env, *FenceReport, error) {
+	var fo FenceOptions
+	if fence != nil {
+		fo = *fence
+	} else {
+		fo.ReaderCheck = true
+	}
+	if fo.DB == "" {
+		fo.DB = DefaultFenceDB
+	}
+	report := &FenceReport{}
+
+	env, err := OpenEnv(path, opts)
+	if err != nil {
+		return nil, report, err
+	}
+	err = env.checkFence(&fo, report)
+	if err != nil {
+		env.Close()
+		return nil, report, err
+	}
+	return env, report, nil
+}
+
+// checkFence runs the checks of fo if the marker of an unclean session is
+// present, then writes the marker of this session.
+func (env *Env) checkFence(fo *FenceOptions, report *FenceReport) error {
+	err := env.View(func(txn *Txn) error {
+		dbi, err := txn.OpenDBI(fo.DB, 0)
+		if IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		v, err := txn.Get(dbi, fenceKey)
+		if IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		report.Unclean = true
+		report.Previous = string(v)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if report.Unclean {
+		if fo.ReaderCheck {
+			report.StaleReaders, err = env.ReaderCheck()
+			if err != nil {
+				return err
+			}
+		}
+		for _, name := range fo.Verify {
+			n, err := env.verifyDB(name)
+			if err != nil {
+				return fmt.Errorf("verify %q: %v", name, err)
+			}
+			if report.Verified == nil {
+				report.Verified = make(map[string]int)
+			}
+			report.Verified[name] = n
+		}
+		for _, repair := range fo.Repairs {
+			err = repair(env)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	host, _ := os.Hostname()
+	marker := fmt.Sprintf("pid=%d host=%s time=%s", os.Getpid(), host, time.Now().UTC().Format(time.RFC3339))
+	err = env.Update(func(txn *Txn) error {
+		dbi, err := txn.OpenDBI(fo.DB, Create)
+		if err != nil {
+			return err
+		}
+		return txn.Put(dbi, fenceKey, []byte(marker), 0)
+	})
+	if err != nil {
+		return err
+	}
+	env.fence = &fence{db: fo.DB}
+	return nil
+}
+
+// verifyDB reads every item of the database name and returns their number.
+func (env *Env) verifyDB(name string) (int, error) {
+	n := 0
+	err := env.View(func(txn *Txn) error {
+		txn.RawRead = true
+		var dbi DBI
+		var err error
+		if name == "" {
+			dbi, err = txn.OpenRoot(0)
+		} else {
+			dbi, err = txn.OpenDBI(name, 0)
+		}
+		if err != nil {
+			return err
+		}
+		_, err = txn.BoundedScan(dbi, nil, nil, func(k, v []byte) error {
+			n++
+			return nil
+		})
+		return err
+	})
+	return n, err
+}
+
+// clearFence removes the marker written by OpenFenced, marking a clean
+// shutdown.
+func (env *Env) clearFence() {
+	f := env.fence
+	env.fence = nil
+	env.Update(func(txn *Txn) error {
+		dbi, err := txn.OpenDBI(f.db, 0)
+		if err != nil {
+			return err
+		}
+		return txn.Del(dbi, fenceKey, nil)
+	})
+}
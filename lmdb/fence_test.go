@@ -0,0 +1,82 @@
+package lmdb
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestOpenFenced(t *testing.T) {
+	path, err := ioutil.TempDir("", "mdb_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(path)
+	opts := &Options{MaxDBs: 2}
+
+	repaired := 0
+	fo := &FenceOptions{
+		ReaderCheck: true,
+		Verify:      []string{"", "critical"},
+		Repairs:     []func(env *Env) error{func(env *Env) error { repaired++; return nil }},
+	}
+
+	env, report, err := OpenFenced(path, opts, fo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Unclean {
+		t.Error("new environment reported unclean")
+	}
+	err = env.Update(func(txn *Txn) error {
+		dbi, err := txn.OpenDBI("critical", Create)
+		if err != nil {
+			return err
+		}
+		for _, k := range []string{"a", "b", "c"} {
+			if err := txn.Put(dbi, []byte(k), []byte(k), 0); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a crash leaves the marker.
+	env.fence = nil
+	env.Close()
+
+	env, report, err = OpenFenced(path, opts, fo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Unclean || !strings.Contains(report.Previous, "pid=") {
+		t.Errorf("unexpected report: %+v", report)
+	}
+	if repaired != 1 || report.Verified["critical"] != 3 || report.Verified[""] != 2 {
+		t.Errorf("repaired %d, verified %v", repaired, report.Verified)
+	}
+	env.Close()
+
+	// a clean close removes it.
+	env, report, err = OpenFenced(path, opts, fo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Unclean || repaired != 1 {
+		t.Errorf("clean shutdown reported unclean: %+v", report)
+	}
+	env.fence = nil
+	env.Close()
+
+	// a failing repair keeps the environment from the application.
+	errRepair := errors.New("repair failed")
+	fo.Repairs = []func(env *Env) error{func(env *Env) error { return errRepair }}
+	env, report, err = OpenFenced(path, opts, fo)
+	if err != errRepair || env != nil || !report.Unclean {
+		t.Errorf("failed repair: env %v, err %v, report %+v", env, err, report)
+	}
+}
@@ -0,0 +1,171 @@
+package lmdb
+
+import (
+	"log"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// FinalizerPolicy is what an Env that becomes unreachable without being
+// closed does when finalized, see Env.SetFinalizerPolicy.
+type FinalizerPolicy int
+
+// Finalizer policies.
+const (
+	// FinalizeClose closes the environment, unless transactions or read
+	// slots are outstanding, in which case closing is refused and tried
+	// again once the Env is found unreachable by the next collection.
+	FinalizeClose FinalizerPolicy = iota
+
+	// FinalizeDrain waits for the outstanding transactions and read slots
+	// to be released, e.g. by the finalizers of unreachable transactions,
+	// then closes the environment.
+	FinalizeDrain
+
+	// FinalizeLog only reports the Env, leaving the environment open.
+	FinalizeLog
+
+	// FinalizeNone removes the finalizer.
+	FinalizeNone
+)
+
+func (p FinalizerPolicy) String() string {
+	switch p {
+	case FinalizeClose:
+		return "close"
+	case FinalizeDrain:
+		return "drain"
+	case FinalizeLog:
+		return "log"
+	case FinalizeNone:
+		return "none"
+	}
+	return "FinalizerPolicy(?)"
+}
+
+// FinalizerReport describes an Env finalized without being closed.
+type FinalizerReport struct {
+	Policy FinalizerPolicy
+	Path   string
+
+	// Txns and ReadSlots are the transactions, not yet terminated, and the
+	// read slots held when the Env was finalized.
+	Txns      int
+	ReadSlots int
+
+	// Closed is true if the environment was closed, false if it was left
+	// open.  With FinalizeDrain the report is made once Txns and ReadSlots
+	// are released and the environment closed.
+	Closed bool
+}
+
+// envFinalizer holds the policy set with SetFinalizerPolicy.
+type envFinalizer struct {
+	mu      sync.Mutex
+	policy  FinalizerPolicy
+	report  func(FinalizerReport)
+	refused bool // closing was refused, and reported, once
+}
+
+// drainInterval is the interval at which FinalizeDrain checks for
+// outstanding transactions.
+const drainInterval = 10 * time.Millisecond
+
+// SetFinalizerPolicy sets what env does when it becomes unreachable without
+// being closed, FinalizeClose by default.  Closing an environment while
+// transactions are live corrupts the memory they use, so the finalizer never
+// closes it under them: transactions begun and not yet terminated are
+// counted, along with the read slots held, see GetOrWaitForReadSlot.
+// report, if not nil, is called with each finalization, which is otherwise
+// logged with the standard logger unless closed by default; a refusal to
+// close is reported once.
+func (env *Env) SetFinalizerPolicy(p FinalizerPolicy, report func(FinalizerReport)) {
+	f := &env.fin
+	f.mu.Lock()
+	f.policy = p
+	f.report = report
+	f.mu.Unlock()
+	runtime.SetFinalizer(env, nil)
+	if p != FinalizeNone {
+		runtime.SetFinalizer(env, (*Env).finalize)
+	}
+}
+
+// outstanding returns the number of live top-level transactions of env and
+// of read slots held.
+func (env *Env) outstanding() (txns, slots int) {
+	env.rkeyMu.Lock()
+	slots = env.maxReaders - len(env.rkeyAvail)
+	env.rkeyMu.Unlock()
+	return int(atomic.LoadInt32(&env.liveTxns)), slots
+}
+
+func (env *Env) finalize() {
+	env.closeLock.RLock()
+	closed := env._env == nil
+	env.closeLock.RUnlock()
+	if closed {
+		return
+	}
+	f := &env.fin
+	f.mu.Lock()
+	policy, report := f.policy, f.report
+	r := FinalizerReport{Policy: policy, Path: env.path}
+	r.Txns, r.ReadSlots = env.outstanding()
+	busy := r.Txns > 0 || r.ReadSlots > 0
+	switch {
+	case policy == FinalizeClose && busy:
+		// try again once the transactions are finalized too.
+		runtime.SetFinalizer(env, nil)
+		runtime.SetFinalizer(env, (*Env).finalize)
+		if f.refused {
+			f.mu.Unlock()
+			return
+		}
+		f.refused = true
+	case policy == FinalizeDrain && busy:
+		f.mu.Unlock()
+		go env.drainClose(r, report)
+		return
+	}
+	f.mu.Unlock()
+
+	if policy != FinalizeLog && !busy {
+		r.Closed = env.Close() == nil
+		if r.Closed && policy == FinalizeClose && report == nil {
+			// the default, logged only when refused.
+			return
+		}
+	}
+	r.send(report)
+}
+
+// drainClose closes env once its transactions and read slots are released,
+// and reports it.
+func (env *Env) drainClose(r FinalizerReport, report func(FinalizerReport)) {
+	for {
+		txns, slots := env.outstanding()
+		if txns == 0 && slots == 0 {
+			r.Closed = env.Close() == nil
+			r.send(report)
+			return
+		}
+		time.Sleep(drainInterval)
+	}
+}
+
+// send calls report with r, or logs r if report is nil.
+func (r FinalizerReport) send(report func(FinalizerReport)) {
+	if report != nil {
+		report(r)
+		return
+	}
+	if r.Closed {
+		log.Printf("lmdb: closed unreachable environment %q (policy %v)", r.Path, r.Policy)
+		return
+	}
+	log.Printf("lmdb: unreachable environment %q left open (policy %v): %d transactions and %d read slots outstanding",
+		r.Path, r.Policy, r.Txns, r.ReadSlots)
+}
@@ -0,0 +1,77 @@
+package lmdb
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestEnv_FinalizerPolicy(t *testing.T) {
+	for _, policy := range []FinalizerPolicy{FinalizeClose, FinalizeDrain, FinalizeLog} {
+		env := setup(t)
+		path := env.path
+		reports := make(chan FinalizerReport, 4)
+		env.SetFinalizerPolicy(policy, func(r FinalizerReport) { reports <- r })
+
+		txn, err := env.BeginTxn(nil, Readonly)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if txns, slots := env.outstanding(); txns != 1 || slots != 1 {
+			t.Errorf("%v: %d transactions and %d read slots outstanding", policy, txns, slots)
+		}
+
+		env.finalize()
+		var r FinalizerReport
+		if policy != FinalizeDrain {
+			r = <-reports
+			if r.Closed || r.Txns != 1 || r.ReadSlots != 1 || r.Policy != policy || r.Path != path {
+				t.Errorf("%v: busy report %+v", policy, r)
+			}
+		}
+		// the environment is still usable.
+		_, err = txn.OpenRoot(0)
+		if err != nil {
+			t.Errorf("%v: %v", policy, err)
+		}
+		if policy == FinalizeClose {
+			// the refusal is reported once.
+			env.finalize()
+			select {
+			case r = <-reports:
+				t.Errorf("%v: repeated report %+v", policy, r)
+			default:
+			}
+		}
+		txn.Abort()
+
+		switch policy {
+		case FinalizeDrain:
+			select {
+			case r = <-reports:
+			case <-time.After(5 * time.Second):
+				t.Fatalf("%v: not drained", policy)
+			}
+		case FinalizeClose:
+			env.finalize()
+			r = <-reports
+		case FinalizeLog:
+			env.finalize()
+			r = <-reports
+			if r.Closed || r.Txns != 0 {
+				t.Errorf("%v: idle report %+v", policy, r)
+			}
+			clean(env, t)
+			continue
+		}
+		// a drained environment reports what it waited for.
+		if !r.Closed || policy == FinalizeDrain && r.Txns != 1 {
+			t.Errorf("%v: report %+v", policy, r)
+		}
+		if env.Close() == nil {
+			t.Errorf("%v: environment not closed", policy)
+		}
+		env.SetFinalizerPolicy(FinalizeNone, nil)
+		os.RemoveAll(path)
+	}
+}
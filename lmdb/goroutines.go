@@ -0,0 +1,131 @@
+package lmdb
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+var errGoClosed = errors.New("environment is closed")
+
+// GoroutineInfo describes the goroutines of an environment running under a
+// name, see Env.Goroutines.
+type GoroutineInfo struct {
+	Name   string
+	Count  int
+	Oldest time.Time // start of the oldest one
+}
+
+// goroutines accounts for the background goroutines of an environment.
+type goroutines struct {
+	mu      sync.Mutex
+	running map[*goroutine]struct{}
+	closed  bool
+	wg      sync.WaitGroup
+}
+
+type goroutine struct {
+	name    string
+	started time.Time
+	stop    func()
+}
+
+// register accounts for a goroutine about to start under name.  stop, if
+// not nil, asks it to exit and is called when env is closed.  The returned
+// function must be called when the goroutine exits.  register returns false
+// if env is closing.
+func (env *Env) register(name string, stop func()) (done func(), ok bool) {
+	r := &env.goros
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil, false
+	}
+	if r.running == nil {
+		r.running = make(map[*goroutine]struct{})
+	}
+	g := &goroutine{name: name, started: time.Now(), stop: stop}
+	r.running[g] = struct{}{}
+	r.wg.Add(1)
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			r.mu.Lock()
+			delete(r.running, g)
+			r.mu.Unlock()
+			r.wg.Done()
+		})
+	}, true
+}
+
+// stopGoroutines stops the registered goroutines and waits for them to exit.
+// Goroutines can no longer be registered afterwards.
+func (env *Env) stopGoroutines() {
+	r := &env.goros
+	r.mu.Lock()
+	r.closed = true
+	var stops []func()
+	for g := range r.running {
+		if g.stop != nil {
+			stops = append(stops, g.stop)
+		}
+	}
+	r.mu.Unlock()
+	for _, stop := range stops {
+		stop()
+	}
+	r.wg.Wait()
+}
+
+// Go runs fn in a new goroutine accounted to env under name, see
+// Goroutines.  ctx is canceled when env is closed, and Close waits for fn to
+// return before closing the environment, so fn may use env until it
+// returns but must not close it.  Packages running background work on an
+// environment, such as sweepers and replication loops, start it with Go so
+// that it cannot outlive the environment.  Go returns an error without
+// calling fn if env is closed.
+func (env *Env) Go(name string, fn func(ctx context.Context)) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	done, ok := env.register(name, cancel)
+	if !ok {
+		cancel()
+		return errGoClosed
+	}
+	go func() {
+		defer done()
+		defer cancel()
+		fn(ctx)
+	}()
+	return nil
+}
+
+// Goroutines reports the background goroutines running on behalf of env,
+// grouped by name and sorted by name: those of the package, such as the
+// workers of BatchWriter and SphynxReader, and those started with Go.  It
+// reports nothing once Close has returned, which embedders can verify to
+// detect leaks.
+func (env *Env) Goroutines() []GoroutineInfo {
+	r := &env.goros
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	byName := make(map[string]*GoroutineInfo)
+	for g := range r.running {
+		info, ok := byName[g.name]
+		if !ok {
+			info = &GoroutineInfo{Name: g.name, Oldest: g.started}
+			byName[g.name] = info
+		}
+		info.Count++
+		if g.started.Before(info.Oldest) {
+			info.Oldest = g.started
+		}
+	}
+	report := make([]GoroutineInfo, 0, len(byName))
+	for _, info := range byName {
+		report = append(report, *info)
+	}
+	sort.Slice(report, func(i, j int) bool { return report[i].Name < report[j].Name })
+	return report
+}
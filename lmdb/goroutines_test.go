@@ -0,0 +1,63 @@
+package lmdb
+
+import (
+	"context"
+	"testing"
+)
+
+func TestEnv_Goroutines(t *testing.T) {
+	env := setup(t)
+	path, err := env.Path()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { clean(env, t) }()
+
+	started := make(chan struct{})
+	stopped := make(chan struct{})
+	err = env.Go("sweeper", func(ctx context.Context) {
+		close(started)
+		<-ctx.Done()
+		close(stopped)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	w := env.NewBatchWriter(nil)
+	env.UseSphynxReader()
+
+	report := env.Goroutines()
+	names := make(map[string]int)
+	for _, g := range report {
+		names[g.Name] = g.Count
+		if g.Oldest.IsZero() {
+			t.Errorf("%s: no start time", g.Name)
+		}
+	}
+	if names["sweeper"] != 1 || names["batchwriter"] != 1 || names["sphynx-reader"] != 1 || len(report) != 3 {
+		t.Errorf("goroutines: %+v", report)
+	}
+
+	// Close stops the goroutines the caller left running.
+	err = env.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-stopped
+	if report := env.Goroutines(); len(report) != 0 {
+		t.Errorf("goroutines after close: %+v", report)
+	}
+	if err := w.Do(func(txn *Txn) error { return nil }); err != ErrBatchWriterClosed {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := env.Go("late", func(ctx context.Context) {}); err == nil {
+		t.Error("goroutine started on a closed environment")
+	}
+
+	// let clean remove the directory.
+	env, err = OpenEnv(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
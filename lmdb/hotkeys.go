@@ -0,0 +1,222 @@
+package lmdb
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// HotKeyOptions controls the write tracking enabled by Env.TrackHotKeys.
+type HotKeyOptions struct {
+	// SampleRate records one in SampleRate successful writes, 16 if zero.
+	// Counts are scaled back up, so they are estimates.
+	SampleRate int
+
+	// Capacity is the number of distinct keys counted per window, 64 if
+	// zero.  When more keys are written the least written key is evicted and
+	// its count inherited by the newcomer, so the most written keys are
+	// always retained, with counts that may be overestimated by at most
+	// the inherited count.
+	Capacity int
+
+	// Window is the period over which rates are measured, 10s if zero.
+	Window time.Duration
+
+	// Threshold is the rate in commits per second above which a key is
+	// reported by HotKeys, 20 if zero.
+	Threshold float64
+}
+
+// HotKey describes a key rewritten by many commits during the last complete
+// tracking window.
+type HotKey struct {
+	DBI       DBI
+	Key       []byte
+	Commits   int64         // estimated commits that wrote Key
+	Overcount int64         // upper bound on the overestimate of Commits
+	Rate      float64       // Commits per second
+	Window    time.Duration // length of the window measured
+}
+
+// hotKeyTracker aggregates sampled writes of committed transactions.
+type hotKeyTracker struct {
+	rate uint32 // sample rate, 0 when tracking is disabled
+	n    uint32 // writes seen, selects the samples
+
+	mu    sync.Mutex
+	opts  HotKeyOptions
+	start time.Time
+	cur   map[hotKeyID]*hotCount
+	last  []HotKey
+}
+
+type hotKeyID struct {
+	dbi DBI
+	key string
+}
+
+type hotCount struct {
+	n   int64
+	err int64
+}
+
+// hotWrite is a sampled write held by a txn until it commits.
+type hotWrite struct {
+	dbi DBI
+	key string
+}
+
+// TrackHotKeys starts sampling the keys written by the transactions of env
+// to find keys rewritten at high rates, see HotKeys.  Such keys serialize the
+// writers that touch them and rewrite a page path on every commit; they are
+// better served by a sharded Counter or by appending to a DupSort log that
+// is folded periodically.  A nil opts stops tracking and discards the
+// counts.  Writes are only counted once their outermost transaction
+// commits, and a key written several times by one transaction counts once.
+//
+// Tracking costs an atomic addition per write.
+func (env *Env) TrackHotKeys(opts *HotKeyOptions) {
+	h := &env.hot
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if opts == nil {
+		atomic.StoreUint32(&h.rate, 0)
+		h.cur = nil
+		h.last = nil
+		return
+	}
+	o := *opts
+	if o.SampleRate <= 0 {
+		o.SampleRate = 16
+	}
+	if o.Capacity <= 0 {
+		o.Capacity = 64
+	}
+	if o.Window <= 0 {
+		o.Window = 10 * time.Second
+	}
+	if o.Threshold <= 0 {
+		o.Threshold = 20
+	}
+	h.opts = o
+	h.start = time.Now()
+	h.cur = make(map[hotKeyID]*hotCount, o.Capacity)
+	h.last = nil
+	atomic.StoreUint32(&h.rate, uint32(o.SampleRate))
+}
+
+// HotKeys returns the keys written at a rate above HotKeyOptions.Threshold
+// during the last complete window, hottest first.  HotKeys returns nil if
+// tracking is disabled or no window has completed.
+func (env *Env) HotKeys() []HotKey {
+	h := &env.hot
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.cur == nil {
+		return nil
+	}
+	h.roll(time.Now())
+	var hot []HotKey
+	for _, k := range h.last {
+		if k.Rate < h.opts.Threshold {
+			break
+		}
+		k.Key = cloneBytes(k.Key)
+		hot = append(hot, k)
+	}
+	return hot
+}
+
+// roll closes the current window if it has elapsed.
+func (h *hotKeyTracker) roll(now time.Time) {
+	elapsed := now.Sub(h.start)
+	if elapsed < h.opts.Window {
+		return
+	}
+	scale := int64(h.opts.SampleRate)
+	h.last = h.last[:0]
+	for id, c := range h.cur {
+		commits := c.n * scale
+		h.last = append(h.last, HotKey{
+			DBI:       id.dbi,
+			Key:       []byte(id.key),
+			Commits:   commits,
+			Overcount: c.err * scale,
+			Rate:      float64(commits) / elapsed.Seconds(),
+			Window:    elapsed,
+		})
+	}
+	sort.Slice(h.last, func(i, j int) bool {
+		return h.last[i].Commits > h.last[j].Commits
+	})
+	h.cur = make(map[hotKeyID]*hotCount, h.opts.Capacity)
+	h.start = now
+}
+
+// record counts the sampled writes of a committed transaction.
+func (h *hotKeyTracker) record(writes []hotWrite) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.cur == nil {
+		return
+	}
+	h.roll(time.Now())
+	seen := make(map[hotKeyID]bool, len(writes))
+	for _, w := range writes {
+		id := hotKeyID(w)
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		if c, ok := h.cur[id]; ok {
+			c.n++
+			continue
+		}
+		if len(h.cur) < h.opts.Capacity {
+			h.cur[id] = &hotCount{n: 1}
+			continue
+		}
+		// Evict the least written key, as in the Space-Saving algorithm.
+		var minID hotKeyID
+		var min *hotCount
+		for id, c := range h.cur {
+			if min == nil || c.n < min.n {
+				minID, min = id, c
+			}
+		}
+		delete(h.cur, minID)
+		h.cur[id] = &hotCount{n: min.n + 1, err: min.n}
+	}
+}
+
+// sampled reports whether a successful write is selected by the sample rate
+// of the environment.
+func (txn *Txn) sampled() bool {
+	rate := atomic.LoadUint32(&txn.env.hot.rate)
+	return rate != 0 && atomic.AddUint32(&txn.env.hot.n, 1)%rate == 0
+}
+
+// sampleWrite records a successful write of key to dbi if it is sampled.
+func (txn *Txn) sampleWrite(dbi DBI, key []byte) {
+	if txn.sampled() {
+		txn.addHotWrite(dbi, key)
+	}
+}
+
+func (txn *Txn) addHotWrite(dbi DBI, key []byte) {
+	txn.hot = append(txn.hot, hotWrite{dbi: dbi, key: string(key)})
+}
+
+// commitHotKeys passes the sampled writes of a committed txn to its parent,
+// or to the environment if txn is not nested.
+func (txn *Txn) commitHotKeys() {
+	if len(txn.hot) == 0 {
+		return
+	}
+	if txn.parent != nil {
+		txn.parent.hot = append(txn.parent.hot, txn.hot...)
+	} else {
+		txn.env.hot.record(txn.hot)
+	}
+}
@@ -0,0 +1,80 @@
+package lmdb
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestEnv_HotKeys(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+
+	var dbi DBI
+	err := env.Update(func(txn *Txn) (err error) {
+		dbi, err = txn.OpenDBI("hot", Create)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env.TrackHotKeys(&HotKeyOptions{SampleRate: 1, Capacity: 4, Window: time.Hour, Threshold: 1e-9})
+	for i := 0; i < 20; i++ {
+		err = env.Update(func(txn *Txn) (err error) {
+			// Repeated writes within a txn count once.
+			for j := 0; j < 3; j++ {
+				err = txn.Put(dbi, []byte("hot"), []byte("v"), 0)
+				if err != nil {
+					return err
+				}
+			}
+			// Cold keys overflow the capacity and evict each other.
+			return txn.Put(dbi, []byte(fmt.Sprintf("cold%d", i)), []byte("v"), 0)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Aborted writes are not counted.
+	err = env.Update(func(txn *Txn) (err error) {
+		err = txn.Put(dbi, []byte("hot"), []byte("v"), 0)
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("abort")
+	})
+	if err == nil {
+		t.Fatal("expected abort")
+	}
+
+	if hot := env.HotKeys(); hot != nil {
+		t.Errorf("hot keys reported before the window completed: %v", hot)
+	}
+
+	// Close the window.
+	env.hot.mu.Lock()
+	env.hot.start = env.hot.start.Add(-time.Hour)
+	env.hot.mu.Unlock()
+
+	hot := env.HotKeys()
+	if len(hot) != 4 {
+		t.Fatalf("hot keys: %v", hot)
+	}
+	if string(hot[0].Key) != "hot" || hot[0].DBI != dbi || hot[0].Commits != 20 || hot[0].Overcount != 0 {
+		t.Errorf("hottest key: %+v", hot[0])
+	}
+	if hot[0].Rate <= 0 || hot[0].Window < time.Hour {
+		t.Errorf("rate %v window %v", hot[0].Rate, hot[0].Window)
+	}
+	for _, k := range hot[1:] {
+		if k.Commits != k.Overcount+1 {
+			t.Errorf("evicted count not inherited: %+v", k)
+		}
+	}
+
+	env.TrackHotKeys(nil)
+	if hot := env.HotKeys(); hot != nil {
+		t.Errorf("hot keys reported after tracking stopped: %v", hot)
+	}
+}
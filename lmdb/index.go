@@ -0,0 +1,119 @@
+package lmdb
+
+import (
+	"errors"
+	"fmt"
+)
+
+var errIndexOp = errors.New("IndexCursor does not support this op")
+
+// ErrDanglingIndex indicates an index entry whose primary key is missing
+// from the primary database.  IndexCursor returns such entries as
+// *DanglingIndexError values for which errors.Is(err, ErrDanglingIndex) is
+// true.
+var ErrDanglingIndex = errors.New("index entry without primary record")
+
+// DanglingIndexError describes an index entry whose primary record is
+// missing.
+type DanglingIndexError struct {
+	IndexKey   []byte
+	PrimaryKey []byte
+}
+
+func (err *DanglingIndexError) Error() string {
+	return fmt.Sprintf("%v: index key %q: primary key %q", ErrDanglingIndex, err.IndexKey, err.PrimaryKey)
+}
+
+// Is allows errors.Is(err, ErrDanglingIndex) to match a *DanglingIndexError.
+func (err *DanglingIndexError) Is(target error) bool {
+	return target == ErrDanglingIndex
+}
+
+// IndexCursor iterates a secondary index stored as a DupSort database
+// mapping each index key to the keys of the primary records it refers to,
+// and resolves each entry to its primary record in the same transaction.
+// Items are returned as (index key, primary key, value) triples, in the
+// order of the index.
+//
+// Entries whose primary record is missing, e.g. because the index was not
+// updated along with the primary database, are returned as a
+// *DanglingIndexError, or skipped if SkipDangling is set.
+type IndexCursor struct {
+	cur     *Cursor
+	primary DBI
+
+	// SkipDangling makes Get move past entries whose primary record is
+	// missing instead of returning them as errors.
+	SkipDangling bool
+}
+
+// OpenIndexCursor opens an IndexCursor over the DupSort database index whose
+// values are keys of primary.  The cursor must be closed before txn
+// terminates.
+func (txn *Txn) OpenIndexCursor(index, primary DBI) (*IndexCursor, error) {
+	cur, err := txn.OpenCursor(index)
+	if err != nil {
+		return nil, err
+	}
+	return &IndexCursor{cur: cur, primary: primary}, nil
+}
+
+// Close closes the cursor of the index.
+func (c *IndexCursor) Close() {
+	c.cur.Close()
+}
+
+// Cursor returns the underlying cursor of the index, e.g. to delete the
+// current entry.
+func (c *IndexCursor) Cursor() *Cursor {
+	return c.cur
+}
+
+// Get moves c as Cursor.Get moves a cursor of the index, with setkey an
+// index key, and returns the entry it points to along with its primary
+// record.  Supported ops are First, Last, Next, Prev, NextDup, PrevDup,
+// NextNoDup, PrevNoDup, FirstDup, LastDup, Set, SetKey, SetRange and
+// GetCurrent.  When skipping dangling entries, ops positioning c within the
+// values of a single index key (NextDup, PrevDup, FirstDup, LastDup, Set and
+// SetKey) stay within that key.
+func (c *IndexCursor) Get(setkey []byte, op uint) (indexKey, primaryKey, val []byte, err error) {
+	var next uint
+	switch op {
+	case First, Next, NextNoDup, SetRange:
+		next = Next
+	case Last, Prev, PrevNoDup:
+		next = Prev
+	case NextDup, FirstDup, Set, SetKey:
+		next = NextDup
+	case PrevDup, LastDup:
+		next = PrevDup
+	case GetCurrent:
+	default:
+		return nil, nil, nil, errIndexOp
+	}
+
+	for {
+		indexKey, primaryKey, err = c.cur.Get(setkey, nil, op)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if op == Set {
+			// Set does not return the key.
+			indexKey = setkey
+		}
+		val, err = c.cur.txn.Get(c.primary, primaryKey)
+		if err == nil {
+			return indexKey, primaryKey, val, nil
+		}
+		if !IsNotFound(err) {
+			return nil, nil, nil, err
+		}
+		if !c.SkipDangling || next == 0 {
+			return nil, nil, nil, &DanglingIndexError{
+				IndexKey:   cloneBytes(indexKey),
+				PrimaryKey: cloneBytes(primaryKey),
+			}
+		}
+		setkey, op = nil, next
+	}
+}
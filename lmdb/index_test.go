@@ -0,0 +1,96 @@
+package lmdb
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestIndexCursor(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+
+	var primary, index DBI
+	err := env.Update(func(txn *Txn) (err error) {
+		primary, err = txn.OpenDBI("people", Create)
+		if err != nil {
+			return err
+		}
+		index, err = txn.OpenDBI("people_by_city", Create|DupSort)
+		if err != nil {
+			return err
+		}
+		people := []struct{ id, name, city string }{
+			{"1", "ann", "oslo"},
+			{"2", "bob", "rome"},
+			{"3", "cid", "oslo"},
+			{"4", "dan", "lima"},
+		}
+		for _, p := range people {
+			err = txn.Put(primary, []byte(p.id), []byte(p.name), 0)
+			if err != nil {
+				return err
+			}
+			err = txn.Put(index, []byte(p.city), []byte(p.id), 0)
+			if err != nil {
+				return err
+			}
+		}
+		// a stale entry left by a deleted record.
+		return txn.Put(index, []byte("oslo"), []byte("9"), 0)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = env.View(func(txn *Txn) error {
+		c, err := txn.OpenIndexCursor(index, primary)
+		if err != nil {
+			return err
+		}
+		defer c.Close()
+
+		k, pk, v, err := c.Get([]byte("oslo"), SetKey)
+		if err != nil || string(k) != "oslo" || string(pk) != "1" || string(v) != "ann" {
+			t.Errorf("SetKey: %q %q %q %v", k, pk, v, err)
+		}
+		_, _, _, err = c.Get(nil, NextDup)
+		if err != nil {
+			t.Error(err)
+		}
+		_, _, _, err = c.Get(nil, NextDup)
+		var derr *DanglingIndexError
+		if !errors.Is(err, ErrDanglingIndex) || !errors.As(err, &derr) || string(derr.PrimaryKey) != "9" {
+			t.Errorf("unexpected error: %v", err)
+		}
+
+		c.SkipDangling = true
+		var names []string
+		for op := uint(First); ; op = Next {
+			_, _, v, err := c.Get(nil, op)
+			if IsNotFound(err) {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			names = append(names, string(v))
+		}
+		if want := "dan ann cid bob"; strings.Join(names, " ") != want {
+			t.Errorf("names %q, want %q", names, want)
+		}
+
+		// the last entry for oslo is dangling.
+		_, _, _, err = c.Get([]byte("oslo"), SetKey)
+		if err == nil {
+			_, pk, _, err = c.Get(nil, LastDup)
+		}
+		if err != nil || string(pk) != "3" {
+			t.Errorf("LastDup: %q %v", pk, err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,163 @@
+package lmdb
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Labels attribute the transactions of a caller, e.g. a tenant or request
+// id, in profiles and slow transaction reports.  Labels are attached to a
+// context with WithLabels and to transactions with Env.ViewContext and
+// Env.UpdateContext.
+type Labels map[string]string
+
+// String formats l as comma separated key=value pairs sorted by key, the
+// form used to group profiles by labels.
+func (l Labels) String() string {
+	keys := make([]string, 0, len(l))
+	for k := range l {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(l[k])
+	}
+	return b.String()
+}
+
+type labelsKey struct{}
+
+// WithLabels returns a context carrying the labels of ctx, if any, extended
+// with labels, which take precedence.
+func WithLabels(ctx context.Context, labels Labels) context.Context {
+	merged := make(Labels)
+	for k, v := range LabelsFromContext(ctx) {
+		merged[k] = v
+	}
+	for k, v := range labels {
+		merged[k] = v
+	}
+	return context.WithValue(ctx, labelsKey{}, merged)
+}
+
+// LabelsFromContext returns the labels carried by ctx.  The returned map
+// must not be modified.
+func LabelsFromContext(ctx context.Context) Labels {
+	l, _ := ctx.Value(labelsKey{}).(Labels)
+	return l
+}
+
+// ViewContext is like View but attaches ctx to the transaction, see
+// Txn.Context, so that its labels attribute the transaction.  If ctx is
+// already done its error is returned without beginning a transaction.
+func (env *Env) ViewContext(ctx context.Context, fn TxnOp) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return env.runContext(ctx, false, Readonly, fn)
+}
+
+// UpdateContext is like Update but attaches ctx to the transaction, see
+// Txn.Context, so that its labels attribute the transaction.  If ctx is
+// already done its error is returned without beginning a transaction.
+func (env *Env) UpdateContext(ctx context.Context, fn TxnOp) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return env.runContext(ctx, true, env.updateFlags, fn)
+}
+
+// Context returns the context attached to txn by ViewContext or
+// UpdateContext, or to its parent if txn is nested, and
+// context.Background() otherwise.  Operations run by fn may use it to
+// observe cancellation, as transactions are never interrupted.
+func (txn *Txn) Context() context.Context {
+	if txn.ctx == nil {
+		return context.Background()
+	}
+	return txn.ctx
+}
+
+// SlowTxn describes a transaction reported by the logger set with
+// Env.SetSlowTxnLogger.
+type SlowTxn struct {
+	Duration time.Duration // from begin (or Renew) to termination (or Reset)
+	Write    bool
+	Labels   Labels
+}
+
+// slowTxnLogger holds the logger set with SetSlowTxnLogger.
+type slowTxnLogger struct {
+	enabled   int32
+	mu        sync.Mutex
+	threshold time.Duration
+	fn        func(SlowTxn)
+}
+
+// SetSlowTxnLogger makes env call fn with every top-level transaction that
+// lasted threshold or more, once it terminates, along with the labels of
+// its context.  A zero threshold reports every transaction, turning fn into
+// a tracing hook.  fn is called by the goroutine terminating the
+// transaction and should return quickly.  A nil fn disables the logger.
+func (env *Env) SetSlowTxnLogger(threshold time.Duration, fn func(SlowTxn)) {
+	l := &env.slow
+	l.mu.Lock()
+	l.threshold = threshold
+	l.fn = fn
+	var v int32
+	if fn != nil {
+		v = 1
+	}
+	atomic.StoreInt32(&l.enabled, v)
+	l.mu.Unlock()
+}
+
+// startSlow records when txn began if slow transactions are logged.
+func (txn *Txn) startSlow() {
+	if txn.parent == nil && atomic.LoadInt32(&txn.env.slow.enabled) != 0 {
+		txn.start = time.Now()
+	}
+}
+
+// logSlow reports txn to the slow transaction logger if it lasted long
+// enough.
+func (txn *Txn) logSlow() {
+	if txn.start.IsZero() {
+		return
+	}
+	d := time.Since(txn.start)
+	txn.start = time.Time{}
+	l := &txn.env.slow
+	l.mu.Lock()
+	threshold, fn := l.threshold, l.fn
+	l.mu.Unlock()
+	if fn == nil || d < threshold {
+		return
+	}
+	fn(SlowTxn{Duration: d, Write: !txn.readonly, Labels: txn.labels})
+}
+
+// runContext runs fn like run with ctx attached to the transaction.  A read
+// transaction of a critical ctx takes its slot from the reserve, see
+// WithCritical.
+func (env *Env) runContext(ctx context.Context, lock bool, flags uint, fn TxnOp) error {
+	op := func(txn *Txn) error {
+		txn.ctx = ctx
+		txn.labels = LabelsFromContext(ctx)
+		return fn(txn)
+	}
+	if flags&Readonly == 0 || !IsCritical(ctx) {
+		return env.run(lock, flags, op)
+	}
+	return env.runCritical(lock, flags, op)
+}
@@ -0,0 +1,134 @@
+package lmdb
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestLabels(t *testing.T) {
+	ctx := WithLabels(context.Background(), Labels{"tenant": "a", "req": "1"})
+	ctx = WithLabels(ctx, Labels{"req": "2"})
+	if s := LabelsFromContext(ctx).String(); s != "req=2,tenant=a" {
+		t.Errorf("labels %q", s)
+	}
+	if l := LabelsFromContext(context.Background()); l != nil {
+		t.Errorf("unexpected labels %v", l)
+	}
+}
+
+func TestEnv_ProfileByLabels_bounded(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+	env.SetProfiling(true)
+
+	for i := 0; i < MaxProfileLabelSets+10; i++ {
+		ctx := WithLabels(context.Background(), Labels{"request": fmt.Sprint(i)})
+		err := env.ViewContext(ctx, func(txn *Txn) error { return nil })
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	byLabels := env.ProfileByLabels()
+	if len(byLabels) != MaxProfileLabelSets+1 {
+		t.Errorf("%d profiles", len(byLabels))
+	}
+	if r := byLabels[ProfileOtherLabels]; r.ReadTxns != 10 {
+		t.Errorf("other labels: %+v", r)
+	}
+	if r := byLabels["request=0"]; r.ReadTxns != 1 {
+		t.Errorf("first labels: %+v", r)
+	}
+}
+
+func TestEnv_UpdateContext(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+
+	dbi, err := openRoot(env, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.SetProfiling(true)
+	var slow []SlowTxn
+	env.SetSlowTxnLogger(0, func(st SlowTxn) {
+		slow = append(slow, st)
+	})
+
+	ctx := WithLabels(context.Background(), Labels{"tenant": "a"})
+	err = env.UpdateContext(ctx, func(txn *Txn) error {
+		if txn.Context() != ctx {
+			t.Error("context not attached")
+		}
+		return txn.Sub(func(txn *Txn) error {
+			if txn.Context() != ctx {
+				t.Error("context not inherited")
+			}
+			return txn.Put(dbi, []byte("k"), []byte("v"), 0)
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = env.ViewContext(WithLabels(context.Background(), Labels{"tenant": "b"}), func(txn *Txn) error {
+		_, err := txn.Get(dbi, []byte("k"))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = env.View(func(txn *Txn) error {
+		if txn.Context() != context.Background() {
+			t.Error("unexpected context")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	byLabels := env.ProfileByLabels()
+	if r := byLabels["tenant=a"]; r.WriteTxns != 2 || r.CgoCalls != 1 {
+		t.Errorf("tenant a: %+v", r)
+	}
+	if r := byLabels["tenant=b"]; r.ReadTxns != 1 || r.CgoCalls != 1 {
+		t.Errorf("tenant b: %+v", r)
+	}
+	if len(byLabels) != 2 {
+		t.Errorf("profiles %v", byLabels)
+	}
+	if r := env.Profile(); r.Txns() != 4 {
+		t.Errorf("total: %+v", r)
+	}
+
+	// nested transactions are not reported on their own.
+	if len(slow) != 3 {
+		t.Fatalf("%d slow txns reported", len(slow))
+	}
+	if !slow[0].Write || slow[0].Labels["tenant"] != "a" {
+		t.Errorf("first txn %+v", slow[0])
+	}
+	if slow[1].Write || slow[1].Labels["tenant"] != "b" {
+		t.Errorf("second txn %+v", slow[1])
+	}
+
+	env.SetSlowTxnLogger(time.Hour, func(st SlowTxn) {
+		t.Errorf("unexpected slow txn %+v", st)
+	})
+	err = env.View(func(txn *Txn) error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.SetSlowTxnLogger(0, nil)
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = env.UpdateContext(cancelled, func(txn *Txn) error {
+		t.Error("txn begun with a cancelled context")
+		return nil
+	})
+	if err != context.Canceled {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -129,6 +129,16 @@ the runtime's thread locking implementation.  In such situations updates
 desired by the goroutine in question must be proxied by a goroutine with a
 known state (i.e.  "locked" or "unlocked").  See the included examples for more
 details about dealing with such situations.
+
+
+Several Environments
+
+A process may open many environments at once, each with its own Env.  Each
+environment configured with UseSphynxReader runs its SphynxReader jobs on its
+own goroutines, each locked to an OS thread while it runs, so a process
+reading dozens of environments that way may pin dozens of times as many
+threads.  Environments configured with UseSphynxPool instead share the fixed
+set of threads of a SphynxPool, the jobs carrying their environment.
 */
 
 package lmdb
@@ -1,6 +1,7 @@
 /* lmdbgo.c
  * Helper utilities for github.com/bmatsuo/lmdb-go/lmdb
  * */
+#include <string.h>
 #include "lmdb.h"
 #include "lmdbgo.h"
 #include "_cgo_export.h"
@@ -23,6 +24,21 @@ int lmdbgo_mdb_reader_list(MDB_env *env, size_t ctx) {
 	return mdb_reader_list(env, 0, (void *)ctx);
 }
 
+static int lmdbgo_count_reader(const char *msg, void *ctx) {
+    (*(int *)ctx)++;
+    return 0;
+}
+
+int lmdbgo_mdb_reader_count(MDB_env *env, int *count) {
+    // count the entries of the reader table held by readers of any process.
+    // mdb_reader_list reports one line per reader after a header, or a
+    // single line when there are none.
+    int n = 0;
+    int rc = mdb_reader_list(env, &lmdbgo_count_reader, &n);
+    *count = n > 0 ? n - 1 : 0;
+    return rc;
+}
+
 int lmdbgo_mdb_del(MDB_txn *txn, MDB_dbi dbi, char *kdata, size_t kn, char *vdata, size_t vn) {
     MDB_val key, val;
     LMDBGO_SET_VAL(&key, kn, kdata);
@@ -30,6 +46,70 @@ int lmdbgo_mdb_del(MDB_txn *txn, MDB_dbi dbi, char *kdata, size_t kn, char *vdat
     return mdb_del(txn, dbi, &key, &val);
 }
 
+int lmdbgo_mdb_delbatch(MDB_txn *txn, MDB_dbi dbi, char *kdata, size_t *kns, size_t count, char *found, size_t *done) {
+    // delete count keys of sizes kns, stored back to back at kdata, with all
+    // their values.  found[i] is set to 1 if key i existed.  done receives
+    // the number of keys processed.
+    MDB_val key;
+    size_t i;
+    int rc;
+    for (i = 0; i < count; i++) {
+        LMDBGO_SET_VAL(&key, kns[i], kdata);
+        rc = mdb_del(txn, dbi, &key, NULL);
+        if (rc == MDB_NOTFOUND) {
+            found[i] = 0;
+        } else if (rc == MDB_SUCCESS) {
+            found[i] = 1;
+        } else {
+            *done = i;
+            return rc;
+        }
+        kdata += kns[i];
+    }
+    *done = count;
+    return MDB_SUCCESS;
+}
+
+int lmdbgo_mdb_get_dupbatch(MDB_txn *txn, MDB_dbi dbi, char *kdata, size_t *kns, size_t count, MDB_val *vals, size_t nvals, size_t *vcounts, size_t *done) {
+    // look up count keys of sizes kns, stored back to back at kdata, storing
+    // all values of key i in vals and their number in vcounts[i].  done
+    // receives the number of keys processed, which is less than count if
+    // the values of the next key do not fit in the nvals left.
+    MDB_cursor *cur;
+    MDB_val key, val;
+    size_t i, n = 0, k;
+    int rc;
+    *done = 0;
+    rc = mdb_cursor_open(txn, dbi, &cur);
+    if (rc != MDB_SUCCESS) {
+        return rc;
+    }
+    for (i = 0; i < count; i++) {
+        LMDBGO_SET_VAL(&key, kns[i], kdata);
+        k = 0;
+        rc = mdb_cursor_get(cur, &key, &val, MDB_SET_KEY);
+        while (rc == MDB_SUCCESS) {
+            if (n+k == nvals) {
+                mdb_cursor_close(cur);
+                return MDB_SUCCESS;
+            }
+            vals[n+k] = val;
+            k++;
+            rc = mdb_cursor_get(cur, &key, &val, MDB_NEXT_DUP);
+        }
+        if (rc != MDB_NOTFOUND) {
+            mdb_cursor_close(cur);
+            return rc;
+        }
+        vcounts[i] = k;
+        n += k;
+        kdata += kns[i];
+        *done = i + 1;
+    }
+    mdb_cursor_close(cur);
+    return MDB_SUCCESS;
+}
+
 int lmdbgo_mdb_get(MDB_txn *txn, MDB_dbi dbi, char *kdata, size_t kn, MDB_val *val) {
     MDB_val key;
     LMDBGO_SET_VAL(&key, kn, kdata);
@@ -70,13 +150,134 @@ int lmdbgo_mdb_cursor_putmulti(MDB_cursor *cur, char *kdata, size_t kn, char *vd
     return mdb_cursor_put(cur, &key, &val[0], flags);
 }
 
+int lmdbgo_mdb_cursor_putdups(MDB_cursor *cur, char *kdata, size_t kn, char *vdata, size_t *vns, size_t count, unsigned int flags, size_t *done) {
+    // store count values of sizes vns, stored back to back at vdata, under
+    // key.  done receives the number of values stored.
+    MDB_val key, val;
+    size_t i;
+    int rc;
+    LMDBGO_SET_VAL(&key, kn, kdata);
+    for (i = 0; i < count; i++) {
+        LMDBGO_SET_VAL(&val, vns[i], vdata);
+        rc = mdb_cursor_put(cur, &key, &val, flags);
+        if (rc != MDB_SUCCESS) {
+            *done = i;
+            return rc;
+        }
+        vdata += vns[i];
+    }
+    *done = count;
+    return MDB_SUCCESS;
+}
+
 int lmdbgo_mdb_cursor_get1(MDB_cursor *cur, char *kdata, size_t kn, MDB_val *key, MDB_val *val, MDB_cursor_op op) {
     LMDBGO_SET_VAL(key, kn, kdata);
     return mdb_cursor_get(cur, key, val, op);
 }
 
+int lmdbgo_mdb_cursor_get_match(MDB_cursor *cur, MDB_val *key, MDB_val *val, MDB_cursor_op op, MDB_cursor_op next, char *pdata, size_t pn, size_t minlen, size_t maxlen) {
+    // move the cursor with op and then with next until the key begins with
+    // the pn bytes at pdata and its size is between minlen and maxlen.
+    int rc;
+    for (;;) {
+        rc = mdb_cursor_get(cur, key, val, op);
+        if (rc != MDB_SUCCESS) {
+            return rc;
+        }
+        if (key->mv_size >= minlen && key->mv_size <= maxlen &&
+                key->mv_size >= pn && memcmp(key->mv_data, pdata, pn) == 0) {
+            return MDB_SUCCESS;
+        }
+        op = next;
+    }
+}
+
 int lmdbgo_mdb_cursor_get2(MDB_cursor *cur, char *kdata, size_t kn, char *vdata, size_t vn, MDB_val *key, MDB_val *val, MDB_cursor_op op) {
     LMDBGO_SET_VAL(key, kn, kdata);
     LMDBGO_SET_VAL(val, vn, vdata);
     return mdb_cursor_get(cur, key, val, op);
 }
+
+int lmdbgo_mdb_cursor_get_range(MDB_txn *txn, MDB_dbi dbi, MDB_cursor *cur, MDB_cursor_op op, char *sdata, size_t sn, char *edata, size_t en, int hasend, MDB_val *keys, MDB_val *vals, size_t n, size_t *done) {
+    // read up to n items into keys and vals, moving cur with op, whose key
+    // for MDB_SET_RANGE is the sn bytes at sdata, and then with MDB_NEXT.
+    // the range ends, with MDB_NOTFOUND, before the first key at least the
+    // en bytes at edata if hasend is set.
+    MDB_val end;
+    size_t i;
+    int rc = MDB_SUCCESS;
+    LMDBGO_SET_VAL(&end, en, edata);
+    for (i = 0; i < n; i++) {
+        if (op == MDB_SET_RANGE) {
+            LMDBGO_SET_VAL(&keys[i], sn, sdata);
+        }
+        rc = mdb_cursor_get(cur, &keys[i], &vals[i], op);
+        if (rc != MDB_SUCCESS) {
+            break;
+        }
+        if (hasend && mdb_cmp(txn, dbi, &keys[i], &end) >= 0) {
+            rc = MDB_NOTFOUND;
+            break;
+        }
+        op = MDB_NEXT;
+    }
+    *done = i;
+    return rc;
+}
+
+int lmdbgo_mdb_cursor_count_range(MDB_txn *txn, MDB_dbi dbi, MDB_cursor *cur, char *sdata, size_t sn, char *edata, size_t en, int hasend, int dupsort, size_t *count) {
+    // count the items from the first key at least the sn bytes at sdata, or
+    // the first item if sn is zero, up to the first key at least the en
+    // bytes at edata if hasend is set.  the values of a key of a DupSort
+    // database are counted at once.
+    MDB_val key, val, end;
+    MDB_cursor_op op = MDB_FIRST;
+    mdb_size_t dups;
+    size_t total = 0;
+    int rc;
+    LMDBGO_SET_VAL(&end, en, edata);
+    if (sn > 0) {
+        LMDBGO_SET_VAL(&key, sn, sdata);
+        op = MDB_SET_RANGE;
+    }
+    for (;;) {
+        rc = mdb_cursor_get(cur, &key, &val, op);
+        if (rc != MDB_SUCCESS) {
+            break;
+        }
+        if (hasend && mdb_cmp(txn, dbi, &key, &end) >= 0) {
+            break;
+        }
+        if (dupsort) {
+            rc = mdb_cursor_count(cur, &dups);
+            if (rc != MDB_SUCCESS) {
+                break;
+            }
+            total += dups;
+            op = MDB_NEXT_NODUP;
+        } else {
+            total++;
+            op = MDB_NEXT;
+        }
+    }
+    *count = total;
+    return rc == MDB_NOTFOUND ? MDB_SUCCESS : rc;
+}
+
+lmdbgo_Stat lmdbgo_mdb_env_stat(MDB_env *env) {
+    lmdbgo_Stat s;
+    s.rc = mdb_env_stat(env, &s.stat);
+    return s;
+}
+
+lmdbgo_Stat lmdbgo_mdb_stat(MDB_txn *txn, MDB_dbi dbi) {
+    lmdbgo_Stat s;
+    s.rc = mdb_stat(txn, dbi, &s.stat);
+    return s;
+}
+
+lmdbgo_EnvInfo lmdbgo_mdb_env_info(MDB_env *env) {
+    lmdbgo_EnvInfo s;
+    s.rc = mdb_env_info(env, &s.info);
+    return s;
+}
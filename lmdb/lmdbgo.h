@@ -18,14 +18,30 @@
  *      https://github.com/bmatsuo/lmdb-go/issues/63
  * */
 int lmdbgo_mdb_del(MDB_txn *txn, MDB_dbi dbi, char *kdata, size_t kn, char *vdata, size_t vn);
+int lmdbgo_mdb_delbatch(MDB_txn *txn, MDB_dbi dbi, char *kdata, size_t *kns, size_t count, char *found, size_t *done);
 int lmdbgo_mdb_get(MDB_txn *txn, MDB_dbi dbi, char *kdata, size_t kn, MDB_val *val);
+int lmdbgo_mdb_get_dupbatch(MDB_txn *txn, MDB_dbi dbi, char *kdata, size_t *kns, size_t count, MDB_val *vals, size_t nvals, size_t *vcounts, size_t *done);
 int lmdbgo_mdb_put1(MDB_txn *txn, MDB_dbi dbi, char *kdata, size_t kn, MDB_val *val, unsigned int flags);
 int lmdbgo_mdb_put2(MDB_txn *txn, MDB_dbi dbi, char *kdata, size_t kn, char *vdata, size_t vn, unsigned int flags);
 int lmdbgo_mdb_cursor_put1(MDB_cursor *cur, char *kdata, size_t kn, MDB_val *val, unsigned int flags);
 int lmdbgo_mdb_cursor_put2(MDB_cursor *cur, char *kdata, size_t kn, char *vdata, size_t vn, unsigned int flags);
 int lmdbgo_mdb_cursor_putmulti(MDB_cursor *cur, char *kdata, size_t kn, char *vdata, size_t vn, size_t vstride, unsigned int flags);
+int lmdbgo_mdb_cursor_putdups(MDB_cursor *cur, char *kdata, size_t kn, char *vdata, size_t *vns, size_t count, unsigned int flags, size_t *done);
 int lmdbgo_mdb_cursor_get1(MDB_cursor *cur, char *kdata, size_t kn, MDB_val *key, MDB_val *val, MDB_cursor_op op);
+int lmdbgo_mdb_cursor_get_match(MDB_cursor *cur, MDB_val *key, MDB_val *val, MDB_cursor_op op, MDB_cursor_op next, char *pdata, size_t pn, size_t minlen, size_t maxlen);
 int lmdbgo_mdb_cursor_get2(MDB_cursor *cur, char *kdata, size_t kn, char *vdata, size_t vn, MDB_val *key, MDB_val *val, MDB_cursor_op op);
+int lmdbgo_mdb_cursor_get_range(MDB_txn *txn, MDB_dbi dbi, MDB_cursor *cur, MDB_cursor_op op, char *sdata, size_t sn, char *edata, size_t en, int hasend, MDB_val *keys, MDB_val *vals, size_t n, size_t *done);
+int lmdbgo_mdb_cursor_count_range(MDB_txn *txn, MDB_dbi dbi, MDB_cursor *cur, char *sdata, size_t sn, char *edata, size_t en, int hasend, int dupsort, size_t *count);
+
+/* Proxy functions for lmdb stat/info operations returning their result by
+ * value.  Passing the address of a Go variable to C makes the variable
+ * escape to the heap, so the plain functions cost an allocation per call.
+ * */
+typedef struct{ int rc; MDB_stat stat; } lmdbgo_Stat;
+typedef struct{ int rc; MDB_envinfo info; } lmdbgo_EnvInfo;
+lmdbgo_Stat lmdbgo_mdb_env_stat(MDB_env *env);
+lmdbgo_Stat lmdbgo_mdb_stat(MDB_txn *txn, MDB_dbi dbi);
+lmdbgo_EnvInfo lmdbgo_mdb_env_info(MDB_env *env);
 
 /* ConstCString wraps a null-terminated (const char *) because Go's type system
  * does not represent the 'cosnt' qualifier directly on a function argument and
@@ -38,5 +54,6 @@ typedef struct{ const char *p; } lmdbgo_ConstCString;
  * lmdbgo_mdb_reader_list_bridge external Go func.
  * */
 int lmdbgo_mdb_reader_list(MDB_env *env, size_t ctx);
+int lmdbgo_mdb_reader_count(MDB_env *env, int *count);
 
 #endif
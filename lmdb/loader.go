@@ -0,0 +1,166 @@
+package lmdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Values stored by GetOrLoad are prefixed with their expiration time in
+// nanoseconds since the Unix epoch, zero if they do not expire.
+const loadStampLen = 8
+
+// ErrLoadedValue is returned by GetOrLoad for a stored value that lacks the
+// expiration prefix GetOrLoad writes.
+var ErrLoadedValue = errors.New("value was not stored by GetOrLoad")
+
+// ErrLoaderPanic is returned by GetOrLoad to the calls waiting for a loader
+// that panicked, as a *LoaderPanicError.  The panic goes on in the call
+// that ran the loader.
+var ErrLoaderPanic = errors.New("loader panicked")
+
+// LoaderPanicError holds the value a loader passed to GetOrLoad panicked
+// with.
+type LoaderPanicError struct {
+	Value interface{}
+}
+
+func (err *LoaderPanicError) Error() string {
+	return fmt.Sprintf("%v: %v", ErrLoaderPanic, err.Value)
+}
+
+// Is allows errors.Is(err, ErrLoaderPanic) to match a *LoaderPanicError.
+func (err *LoaderPanicError) Is(target error) bool {
+	return target == ErrLoaderPanic
+}
+
+type loadKey struct {
+	dbi DBI
+	key string
+}
+
+// loadCall is a load in progress or whose result is being committed.
+type loadCall struct {
+	done chan struct{}
+	val  []byte
+	err  error
+}
+
+// GetOrLoad implements a read-through cache over dbi.  It returns the value
+// stored under key if there is one that has not expired.  Otherwise it calls
+// loader and returns its result, which is stored in dbi through w, expiring
+// ttl from now (never if ttl is zero).  Concurrent calls for a missing key
+// share a single call to loader, and calls made until its result has been
+// committed wait for that result instead of loading again.
+//
+// Values are stored prefixed with their expiration time, so dbi must only be
+// written through GetOrLoad, though deleting a key with Txn.Del is a valid
+// way to invalidate it.  Expired values are replaced when next loaded, not
+// deleted.  Errors returned by loader are passed to the callers and not
+// cached, and if loader panics the other callers fail with ErrLoaderPanic.
+// A failure to store a loaded value is not reported; the next call loads it
+// again.  The returned value is shared between the callers of a load and
+// must not be modified.
+func (w *BatchWriter) GetOrLoad(dbi DBI, key []byte, loader func() ([]byte, error), ttl time.Duration) ([]byte, error) {
+	val, ok, err := w.getLoaded(dbi, key)
+	if ok || err != nil {
+		return val, err
+	}
+
+	lk := loadKey{dbi: dbi, key: string(key)}
+	w.loadMu.Lock()
+	if c, ok := w.loads[lk]; ok {
+		w.loadMu.Unlock()
+		<-c.done
+		return c.val, c.err
+	}
+	c := &loadCall{done: make(chan struct{})}
+	if w.loads == nil {
+		w.loads = make(map[loadKey]*loadCall)
+	}
+	w.loads[lk] = c
+	w.loadMu.Unlock()
+
+	forget := func() {
+		w.loadMu.Lock()
+		delete(w.loads, lk)
+		w.loadMu.Unlock()
+	}
+
+	// a load of key may have committed between the lookup above and the
+	// registration of c.
+	val, ok, err = w.getLoaded(dbi, key)
+	if !ok && err == nil {
+		val, err = c.load(loader, forget)
+	}
+	c.val, c.err = val, err
+	close(c.done)
+	if ok || err != nil {
+		forget()
+		return val, err
+	}
+
+	var stamp uint64
+	if ttl > 0 {
+		stamp = uint64(time.Now().Add(ttl).UnixNano())
+	}
+	v := make([]byte, loadStampLen+len(val))
+	binary.BigEndian.PutUint64(v, stamp)
+	copy(v[loadStampLen:], val)
+	// the caller may reuse key once GetOrLoad returns.
+	k := cloneBytes(key)
+	errc := w.Enqueue(func(txn *Txn) error {
+		return txn.Put(dbi, k, v, 0)
+	})
+	done, ok := w.env.register("batchwriter-load", nil)
+	if !ok {
+		done = func() {}
+	}
+	go func() {
+		defer done()
+		<-errc
+		forget()
+	}()
+	return val, nil
+}
+
+// load calls loader.  If loader panics the calls waiting for c are released
+// with a *LoaderPanicError and forget is called before the panic goes on.
+func (c *loadCall) load(loader func() ([]byte, error), forget func()) ([]byte, error) {
+	defer func() {
+		if p := recover(); p != nil {
+			c.err = &LoaderPanicError{Value: p}
+			close(c.done)
+			forget()
+			panic(p)
+		}
+	}()
+	return loader()
+}
+
+// getLoaded returns the unexpired value stored under key by GetOrLoad, if
+// any.
+func (w *BatchWriter) getLoaded(dbi DBI, key []byte) (val []byte, ok bool, err error) {
+	err = w.env.View(func(txn *Txn) error {
+		// the value outlives txn even if the env sets ViewRawRead.
+		txn.RawRead = false
+		v, err := txn.Get(dbi, key)
+		if IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(v) < loadStampLen {
+			return ErrLoadedValue
+		}
+		stamp := binary.BigEndian.Uint64(v)
+		if stamp != 0 && int64(stamp) <= time.Now().UnixNano() {
+			return nil
+		}
+		val, ok = v[loadStampLen:], true
+		return nil
+	})
+	return val, ok, err
+}
@@ -0,0 +1,189 @@
+package lmdb
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBatchWriter_GetOrLoad(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+
+	dbi, err := openRoot(env, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := env.NewBatchWriter(nil)
+	defer w.Close()
+
+	var calls int32
+	release := make(chan struct{})
+	loader := func() ([]byte, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return []byte("loaded"), nil
+	}
+
+	// concurrent loads of a key share one call to the loader.
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err := w.GetOrLoad(dbi, []byte("k"), loader, 0)
+			if err != nil {
+				t.Error(err)
+			} else if !bytes.Equal(v, []byte("loaded")) {
+				t.Errorf("unexpected value: %q", v)
+			}
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("loader called %d times", n)
+	}
+
+	// the stored value is returned without loading.
+	err = w.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := w.GetOrLoad(dbi, []byte("k"), func() ([]byte, error) {
+		t.Error("unexpected load")
+		return nil, nil
+	}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(v, []byte("loaded")) {
+		t.Errorf("unexpected value: %q", v)
+	}
+
+	// loader errors are returned and not cached.
+	errLoad := errors.New("load failed")
+	_, err = w.GetOrLoad(dbi, []byte("e"), func() ([]byte, error) { return nil, errLoad }, 0)
+	if err != errLoad {
+		t.Errorf("unexpected error: %v", err)
+	}
+	v, err = w.GetOrLoad(dbi, []byte("e"), func() ([]byte, error) { return []byte("ok"), nil }, 0)
+	if err != nil || string(v) != "ok" {
+		t.Errorf("unexpected result: %q %v", v, err)
+	}
+
+	// expired values are loaded again.
+	n := 0
+	ttlLoader := func() ([]byte, error) {
+		n++
+		return []byte{byte(n)}, nil
+	}
+	for i := 0; i < 2; i++ {
+		_, err = w.GetOrLoad(dbi, []byte("t"), ttlLoader, 20*time.Millisecond)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = w.Flush()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n != 1 {
+		t.Errorf("loader called %d times before expiration", n)
+	}
+	time.Sleep(30 * time.Millisecond)
+	v, err = w.GetOrLoad(dbi, []byte("t"), ttlLoader, 20*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 || !bytes.Equal(v, []byte{2}) {
+		t.Errorf("expired value not reloaded: %d %v", n, v)
+	}
+
+	// values not written by GetOrLoad are rejected.
+	err = env.Update(func(txn *Txn) error {
+		return txn.Put(dbi, []byte("raw"), []byte("x"), 0)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = w.GetOrLoad(dbi, []byte("raw"), ttlLoader, 0)
+	if err != ErrLoadedValue {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// the key may be reused once GetOrLoad returns.
+	key := []byte("reused")
+	_, err = w.GetOrLoad(dbi, key, ttlLoader, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(key, "xxxxxx")
+	err = w.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = env.View(func(txn *Txn) error {
+		_, err := txn.Get(dbi, []byte("reused"))
+		return err
+	})
+	if err != nil {
+		t.Errorf("loaded value not stored under its key: %v", err)
+	}
+}
+
+func TestBatchWriter_GetOrLoad_panic(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+
+	dbi, err := openRoot(env, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := env.NewBatchWriter(nil)
+	defer w.Close()
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	panicked := make(chan interface{})
+	go func() {
+		defer func() { panicked <- recover() }()
+		w.GetOrLoad(dbi, []byte("k"), func() ([]byte, error) {
+			close(started)
+			<-release
+			panic("boom")
+		}, 0)
+	}()
+	<-started
+
+	// a call waiting for the loader fails instead of blocking forever.
+	errc := make(chan error)
+	go func() {
+		_, err := w.GetOrLoad(dbi, []byte("k"), func() ([]byte, error) {
+			return []byte("v"), nil
+		}, 0)
+		errc <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	if p := <-panicked; p != "boom" {
+		t.Errorf("recovered %v", p)
+	}
+	err = <-errc
+	var perr *LoaderPanicError
+	if !errors.Is(err, ErrLoaderPanic) || !errors.As(err, &perr) || perr.Value != "boom" {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// the key is loaded again.
+	v, err := w.GetOrLoad(dbi, []byte("k"), func() ([]byte, error) {
+		return []byte("v"), nil
+	}, 0)
+	if err != nil || string(v) != "v" {
+		t.Errorf("unexpected result: %q %v", v, err)
+	}
+}
@@ -0,0 +1,149 @@
+package lmdb
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrEnvShared indicates that an environment opened with Options.Exclusive
+// is in use by other processes.  Such errors are *EnvSharedError values for
+// which errors.Is(err, ErrEnvShared) is true.
+var ErrEnvShared = errors.New("environment is used by other processes")
+
+var errExclusiveNoLock = errors.New("exclusive open requires the lock file, which NoLock disables")
+
+// EnvSharedError lists the other processes found using an environment.
+type EnvSharedError struct {
+	Path string // path of the environment
+	PIDs []int  // other processes using the lock file
+}
+
+func (err *EnvSharedError) Error() string {
+	return fmt.Sprintf("%v: %s: pids %v", ErrEnvShared, err.Path, err.PIDs)
+}
+
+// Is allows errors.Is(err, ErrEnvShared) to match an *EnvSharedError.
+func (err *EnvSharedError) Is(target error) bool {
+	return target == ErrEnvShared
+}
+
+// LockUser is a process using the lock file of an environment.
+type LockUser struct {
+	PID int
+
+	// Locked is true if the process holds a lock on the lock file, which
+	// every process keeps from opening the environment until closing it.
+	// Locks are only visible on Linux.
+	Locked bool
+
+	// Readers is the number of reader table slots owned by the process.
+	// Slots are kept by threads that ran read transactions, so a process
+	// with an open environment may own none.
+	Readers int
+}
+
+// LockUsers returns the processes using the lock file of env, including the
+// calling process, ordered by PID.  It combines the owners of reader table
+// slots with, on Linux, the holders of locks on the lock file listed in
+// /proc/locks.  Environments opened with NoLock have no lock file and
+// LockUsers returns no users.
+func (env *Env) LockUsers() ([]LockUser, error) {
+	flags, err := env.Flags()
+	if err != nil {
+		return nil, err
+	}
+	if flags&NoLock != 0 {
+		return nil, nil
+	}
+	users := make(map[int]*LockUser)
+	user := func(pid int) *LockUser {
+		u, ok := users[pid]
+		if !ok {
+			u = &LockUser{PID: pid}
+			users[pid] = u
+		}
+		return u
+	}
+
+	err = env.ReaderList(func(line string) error {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			return nil
+		}
+		pid, err := strconv.Atoi(fields[0])
+		if err == nil {
+			user(pid).Readers++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	lock, err := env.lockPath()
+	if err != nil {
+		return nil, err
+	}
+	pids, err := lockHolders(lock)
+	if err != nil {
+		return nil, err
+	}
+	for _, pid := range pids {
+		user(pid).Locked = true
+	}
+
+	list := make([]LockUser, 0, len(users))
+	for _, u := range users {
+		list = append(list, *u)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].PID < list[j].PID })
+	return list, nil
+}
+
+// lockPath returns the path of the lock file of the open environment.
+func (env *Env) lockPath() (string, error) {
+	path, err := env.Path()
+	if err != nil {
+		return "", err
+	}
+	flags, err := env.Flags()
+	if err != nil {
+		return "", err
+	}
+	if flags&NoSubdir != 0 {
+		return path + "-lock", nil
+	}
+	return filepath.Join(path, "lock.mdb"), nil
+}
+
+// checkExclusive fails with an *EnvSharedError if processes other than the
+// caller use env.
+func (env *Env) checkExclusive() error {
+	flags, err := env.Flags()
+	if err != nil {
+		return err
+	}
+	if flags&NoLock != 0 {
+		return errExclusiveNoLock
+	}
+	users, err := env.LockUsers()
+	if err != nil {
+		return err
+	}
+	var pids []int
+	for _, u := range users {
+		if u.PID != os.Getpid() {
+			pids = append(pids, u.PID)
+		}
+	}
+	if len(pids) == 0 {
+		return nil
+	}
+	path, _ := env.Path()
+	return &EnvSharedError{Path: path, PIDs: pids}
+}
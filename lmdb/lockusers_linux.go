@@ -0,0 +1,53 @@
+package lmdb
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// lockHolders returns the processes holding a lock on the file at path, as
+// listed in /proc/locks.
+func lockHolders(path string) ([]int, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil, nil
+	}
+	dev := uint64(st.Dev)
+	major := (dev>>8)&0xfff | (dev>>32)&^0xfff
+	minor := dev&0xff | (dev>>12)&^0xff
+	// e.g. "1: POSIX  ADVISORY  READ 1234 08:01:5678 0 0"
+	id := fmt.Sprintf("%02x:%02x:%d", major, minor, st.Ino)
+
+	f, err := os.Open("/proc/locks")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	seen := make(map[int]bool)
+	var pids []int
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 6 || fields[1] == "->" {
+			continue
+		}
+		if fields[5] != id {
+			continue
+		}
+		pid, err := strconv.Atoi(fields[4])
+		if err != nil || pid <= 0 || seen[pid] {
+			continue
+		}
+		seen[pid] = true
+		pids = append(pids, pid)
+	}
+	return pids, s.Err()
+}
@@ -0,0 +1,90 @@
+package lmdb
+
+import (
+	"bufio"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"runtime"
+	"testing"
+)
+
+// TestLockUsers_holder is run as a child process by TestOpenEnv_Exclusive to
+// keep an environment open.
+func TestLockUsers_holder(t *testing.T) {
+	path := os.Getenv("LMDB_TEST_HOLD_ENV")
+	if path == "" {
+		t.Skip("not a child process")
+	}
+	env, err := OpenEnv(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer env.Close()
+	os.Stdout.WriteString("ready\n")
+	ioutil.ReadAll(os.Stdin)
+}
+
+func TestOpenEnv_Exclusive(t *testing.T) {
+	path, err := ioutil.TempDir("", "mdb_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(path)
+
+	env, err := OpenEnv(path, &Options{Exclusive: true})
+	if err != nil {
+		t.Fatalf("exclusive open of an unused environment: %v", err)
+	}
+	err = env.View(func(txn *Txn) error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	users, err := env.LockUsers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || users[0].PID != os.Getpid() {
+		t.Errorf("users: %+v", users)
+	}
+	env.Close()
+
+	if runtime.GOOS != "linux" {
+		t.Skip("lock holders are only visible on Linux")
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^TestLockUsers_holder$")
+	cmd.Env = append(os.Environ(), "LMDB_TEST_HOLD_ENV="+path)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cmd.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Wait()
+	defer stdin.Close()
+	line, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil || line != "ready\n" {
+		t.Fatalf("child: %q %v", line, err)
+	}
+
+	_, err = OpenEnv(path, &Options{Exclusive: true})
+	var shared *EnvSharedError
+	if !errors.Is(err, ErrEnvShared) || !errors.As(err, &shared) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(shared.PIDs) != 1 || shared.PIDs[0] != cmd.Process.Pid {
+		t.Errorf("pids %v, want [%d]", shared.PIDs, cmd.Process.Pid)
+	}
+
+	_, err = OpenEnv(path, &Options{Exclusive: true, Flags: NoLock})
+	if err != errExclusiveNoLock {
+		t.Errorf("exclusive NoLock: %v", err)
+	}
+}
@@ -0,0 +1,82 @@
+package lmdb
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ErrMapIO indicates that the data file is shorter than the extent of the
+// database recorded in its meta pages, typically because the file was
+// truncated or damaged outside of LMDB.  Touching the missing pages through
+// the memory map would crash the process with SIGBUS inside cgo.
+//
+// Errors returned by Env.CheckMapExtent, and by transactions begun in an
+// environment opened with Options.CheckMapExtent, are *MapIOError values for
+// which errors.Is(err, ErrMapIO) is true.
+//
+// There is no way to repair the file in place.  Recovery consists of closing
+// the environment and either restoring the data file from a backup, or
+// reopening it with the PrevSnapshot flag if only the most recent commit was
+// lost, or salvaging whatever remains readable into a new environment.
+var ErrMapIO = errors.New("data file is shorter than the mapped database")
+
+// MapIOError describes a data file that fails the map extent check.
+type MapIOError struct {
+	Path     string // path of the data file
+	FileSize int64  // actual size of the data file
+	Required int64  // size implied by the last used page
+}
+
+func (err *MapIOError) Error() string {
+	return fmt.Sprintf("%v: %s is %d bytes, %d bytes required", ErrMapIO, err.Path, err.FileSize, err.Required)
+}
+
+// Is allows errors.Is(err, ErrMapIO) to match a *MapIOError.
+func (err *MapIOError) Is(target error) bool {
+	return target == ErrMapIO
+}
+
+// dataPath returns the path of the data file of the open environment.
+func (env *Env) dataPath() (string, error) {
+	path, err := env.Path()
+	if err != nil {
+		return "", err
+	}
+	flags, err := env.Flags()
+	if err != nil {
+		return "", err
+	}
+	if flags&NoSubdir != 0 {
+		return path, nil
+	}
+	return filepath.Join(path, "data.mdb"), nil
+}
+
+// CheckMapExtent verifies that the data file is large enough to hold every
+// page up to the last page used by the most recent commit.  A failing check
+// returns a *MapIOError (see ErrMapIO).
+func (env *Env) CheckMapExtent() error {
+	info, err := env.Info()
+	if err != nil {
+		return err
+	}
+	stat, err := env.Stat()
+	if err != nil {
+		return err
+	}
+	path, err := env.dataPath()
+	if err != nil {
+		return err
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	required := (info.LastPNO + 1) * int64(stat.PSize)
+	if fi.Size() < required {
+		return &MapIOError{Path: path, FileSize: fi.Size(), Required: required}
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package lmdb
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEnv_CheckMapExtent(t *testing.T) {
+	path, err := ioutil.TempDir("", "mdb_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(path)
+
+	env, err := OpenEnv(path, &Options{CheckMapExtent: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = env.Update(func(txn *Txn) (err error) {
+		dbi, err := txn.OpenRoot(0)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 64; i++ {
+			err = txn.Put(dbi, []byte{byte(i)}, make([]byte, 256), 0)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = env.CheckMapExtent()
+	if err != nil {
+		t.Fatalf("intact environment: %v", err)
+	}
+	stat, err := env.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.Close()
+
+	// keep only the meta pages, which are all that is needed to open.
+	err = os.Truncate(filepath.Join(path, "data.mdb"), 2*int64(stat.PSize))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env, err = OpenEnv(path, &Options{CheckMapExtent: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer env.Close()
+
+	err = env.View(func(txn *Txn) error {
+		t.Errorf("transaction began on a truncated data file")
+		return nil
+	})
+	if !errors.Is(err, ErrMapIO) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var mapErr *MapIOError
+	if !errors.As(err, &mapErr) {
+		t.Fatalf("error is not a *MapIOError: %T", err)
+	}
+	if mapErr.FileSize >= mapErr.Required {
+		t.Errorf("file size %d not below required %d", mapErr.FileSize, mapErr.Required)
+	}
+
+	// the read slot taken by the failed transaction must have been returned.
+	for i := 0; i < 2*defaultMaxReaders(t, env); i++ {
+		err = env.View(func(txn *Txn) error { return nil })
+		if !errors.Is(err, ErrMapIO) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+}
+
+func defaultMaxReaders(t *testing.T, env *Env) int {
+	n, err := env.MaxReaders()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestEnv_CheckMapExtent_sphynx(t *testing.T) {
+	path, err := ioutil.TempDir("", "mdb_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(path)
+
+	env, err := OpenEnv(path, &Options{CheckMapExtent: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = env.Update(func(txn *Txn) (err error) {
+		dbi, err := txn.OpenRoot(0)
+		if err != nil {
+			return err
+		}
+		return txn.Put(dbi, []byte("k"), make([]byte, 8192), 0)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stat, err := env.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.Close()
+	err = os.Truncate(filepath.Join(path, "data.mdb"), 2*int64(stat.PSize))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env, err = OpenEnv(path, &Options{CheckMapExtent: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer env.Close()
+	env.UseSphynxReader()
+
+	// every failed job must return its read slot.
+	for i := 0; i < 2*defaultMaxReaders(t, env); i++ {
+		err = env.SphynxReader(func(txn *Txn, readslot int) error {
+			t.Errorf("transaction began on a truncated data file")
+			return nil
+		})
+		if !errors.Is(err, ErrMapIO) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+}
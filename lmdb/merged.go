@@ -0,0 +1,127 @@
+package lmdb
+
+import (
+	"bytes"
+	"errors"
+)
+
+var errMergedOp = errors.New("MergedCursor supports only First, Next and SetRange")
+
+// MergedCursor iterates the union of several databases in key order, as if
+// they were one database.  It is meant for read paths over data partitioned
+// across databases, e.g. generational or per-tenant databases.  Each item is
+// returned with the index of its source among the databases the cursor was
+// opened with.  Items with equal keys in several databases are all
+// returned, in source order, so a caller wanting the newest version of a key
+// can order the databases accordingly and skip repeated keys.
+//
+// Keys are compared bytewise, so the databases must not use IntegerKey,
+// ReverseKey or a custom comparison function.  In DupSort databases every
+// value is returned.
+type MergedCursor struct {
+	txn   *Txn
+	curs  []*Cursor
+	heads []mergeHead
+	cur   int // source of the last item returned, -1 if none
+
+	// positioned is set once First or SetRange has been applied.
+	positioned bool
+}
+
+type mergeHead struct {
+	key, val []byte
+	ok       bool
+}
+
+// OpenMergedCursor opens a MergedCursor over dbis.  The cursor must be closed
+// before txn terminates.
+func (txn *Txn) OpenMergedCursor(dbis ...DBI) (*MergedCursor, error) {
+	m := &MergedCursor{
+		txn:   txn,
+		curs:  make([]*Cursor, 0, len(dbis)),
+		heads: make([]mergeHead, len(dbis)),
+		cur:   -1,
+	}
+	for _, dbi := range dbis {
+		c, err := txn.OpenCursor(dbi)
+		if err != nil {
+			m.Close()
+			return nil, err
+		}
+		m.curs = append(m.curs, c)
+	}
+	return m, nil
+}
+
+// Close closes the cursors of m.
+func (m *MergedCursor) Close() {
+	for _, c := range m.curs {
+		c.Close()
+	}
+	m.curs = nil
+}
+
+// Get moves m and returns the item it points to along with its source, the
+// index of its database in the list given to OpenMergedCursor.  The op
+// First positions m at the smallest key of all databases, SetRange at the
+// smallest key greater than or equal to setkey, and Next at the following
+// item; Next on an unpositioned cursor is the same as First.  When there is
+// no such item Get returns a NotFound error.
+func (m *MergedCursor) Get(setkey []byte, op uint) (key, val []byte, source int, err error) {
+	switch op {
+	case First, SetRange:
+		for i, c := range m.curs {
+			var k, v []byte
+			if op == First {
+				k, v, err = c.Get(nil, nil, First)
+			} else {
+				k, v, err = c.Get(setkey, nil, SetRange)
+			}
+			err = m.setHead(i, k, v, err)
+			if err != nil {
+				return nil, nil, -1, err
+			}
+		}
+	case Next:
+		if !m.positioned {
+			return m.Get(nil, First)
+		}
+		if m.cur < 0 {
+			return nil, nil, -1, &OpError{Op: "mdb_cursor_get", Errno: NotFound}
+		}
+		k, v, err := m.curs[m.cur].Get(nil, nil, Next)
+		err = m.setHead(m.cur, k, v, err)
+		if err != nil {
+			return nil, nil, -1, err
+		}
+	default:
+		return nil, nil, -1, errMergedOp
+	}
+
+	m.positioned = true
+	m.cur = -1
+	for i := range m.heads {
+		h := &m.heads[i]
+		if h.ok && (m.cur < 0 || bytes.Compare(h.key, m.heads[m.cur].key) < 0) {
+			m.cur = i
+		}
+	}
+	if m.cur < 0 {
+		return nil, nil, -1, &OpError{Op: "mdb_cursor_get", Errno: NotFound}
+	}
+	h := &m.heads[m.cur]
+	return h.key, h.val, m.cur, nil
+}
+
+// setHead records the item at which source i is positioned, if any.
+func (m *MergedCursor) setHead(i int, k, v []byte, err error) error {
+	if IsNotFound(err) {
+		m.heads[i] = mergeHead{}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	m.heads[i] = mergeHead{key: k, val: v, ok: true}
+	return nil
+}
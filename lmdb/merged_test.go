@@ -0,0 +1,83 @@
+package lmdb
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestMergedCursor(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+
+	var dbis []DBI
+	err := env.Update(func(txn *Txn) (err error) {
+		for i, keys := range [][]string{{"b", "d", "f"}, {"a", "d", "g"}, {}} {
+			dbi, err := txn.OpenDBI(fmt.Sprintf("gen%d", i), Create)
+			if err != nil {
+				return err
+			}
+			for _, k := range keys {
+				err = txn.Put(dbi, []byte(k), []byte(fmt.Sprint(i)), 0)
+				if err != nil {
+					return err
+				}
+			}
+			dbis = append(dbis, dbi)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = env.View(func(txn *Txn) (err error) {
+		m, err := txn.OpenMergedCursor(dbis...)
+		if err != nil {
+			return err
+		}
+		defer m.Close()
+
+		scan := func(setkey []byte, op uint) []string {
+			var items []string
+			for {
+				k, v, src, err := m.Get(setkey, op)
+				if IsNotFound(err) {
+					return items
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(v) != fmt.Sprint(src) {
+					t.Errorf("item %s=%s from source %d", k, v, src)
+				}
+				items = append(items, fmt.Sprintf("%s%d", k, src))
+				op = Next
+			}
+		}
+		items := scan(nil, Next)
+		if !reflect.DeepEqual(items, []string{"a1", "b0", "d0", "d1", "f0", "g1"}) {
+			t.Errorf("items: %q", items)
+		}
+		_, _, _, err = m.Get(nil, Next)
+		if !IsNotFound(err) {
+			t.Errorf("next after the last item: %v", err)
+		}
+		items = scan([]byte("c"), SetRange)
+		if !reflect.DeepEqual(items, []string{"d0", "d1", "f0", "g1"}) {
+			t.Errorf("items from c: %q", items)
+		}
+		items = scan([]byte("h"), SetRange)
+		if len(items) != 0 {
+			t.Errorf("items from h: %q", items)
+		}
+		_, _, _, err = m.Get(nil, Last)
+		if err != errMergedOp {
+			t.Errorf("unsupported op: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,200 @@
+package lmdb
+
+import (
+	"os"
+)
+
+// Options configures an environment opened with OpenEnv.  The zero value
+// is usable and results in the same configuration as NewEnv followed by
+// Env.Open(path, 0, 0644).
+type Options struct {
+	// MaxReaders is the maximum number of concurrent read transactions.  It
+	// defaults to 256, see NewEnvMaxReaders.
+	MaxReaders int
+
+	// CriticalReaders is the number of read slots reserved for critical
+	// readers, see Env.ReserveReaders.
+	CriticalReaders int
+
+	// ReaderAdmission, if not nil, configures the admission control of new
+	// read transactions, see Env.SetReaderAdmission.
+	ReaderAdmission *ReaderAdmission
+
+	// FinalizerPolicy is what the Env does if it becomes unreachable without
+	// being closed, see Env.SetFinalizerPolicy.
+	FinalizerPolicy FinalizerPolicy
+
+	// MaxDBs is the maximum number of named databases, see Env.SetMaxDBs.
+	MaxDBs int
+
+	// MapSize is the size of the memory map, see Env.SetMapSize.  Zero keeps
+	// the LMDB default (or the size of an existing environment).
+	MapSize int64
+
+	// ExpectPageSize, if not zero, is the page size the environment is
+	// expected to have.  It does not choose the page size: LMDB creates
+	// every environment with DefaultPageSize, so another size is only found
+	// in an existing environment created elsewhere, e.g. on a system with
+	// larger OS pages.  OpenEnv fails with a *PageSizeError (see
+	// ErrPageSize) without creating any file if a new environment would get
+	// another size, and after opening if an existing environment has
+	// another size.
+	ExpectPageSize int
+
+	// Flags are passed to Env.Open.
+	Flags uint
+
+	// Mode is the permission of files created by Env.Open, 0644 if zero.
+	Mode os.FileMode
+
+	// ViewRawRead sets Txn.RawRead on every transaction created by View (and
+	// by RunTxn with the Readonly flag), so that reads do not copy values.
+	ViewRawRead bool
+
+	// UpdateFlags are transaction flags added to every transaction created
+	// by Update and UpdateLocked.  Only NoSync and NoMetaSync are meaningful,
+	// e.g. NoMetaSync relaxes durability of individual updates without
+	// changing the environment flags seen by other writers.
+	UpdateFlags uint
+
+	// CheckMapExtent makes every new transaction verify that the data file
+	// still covers the pages in use before any of them is touched, see
+	// Env.CheckMapExtent.  Transactions then fail with ErrMapIO instead of
+	// crashing the process on a truncated file.  The check costs a stat of
+	// the data file per transaction.
+	CheckMapExtent bool
+
+	// SelfTest runs Env.SelfTest after opening, so that OpenEnv fails with
+	// a *SelfTestError (see ErrSelfTest) instead of returning an
+	// environment whose meta pages or first and last items are unreadable.
+	SelfTest bool
+
+	// Exclusive makes OpenEnv fail with an *EnvSharedError (see
+	// ErrEnvShared) listing the other processes using the environment, see
+	// Env.LockUsers.  The check is made once the environment is open, so a
+	// process opening it later is only refused if it also sets Exclusive.
+	// Exclusive cannot be combined with NoLock.
+	Exclusive bool
+
+	// CoopLock synchronizes the transactions of an environment opened with
+	// NoLock through a CoopLock, so that NoLock can be used safely by
+	// several goroutines and processes, e.g. for a dataset on a read-only
+	// filesystem where LMDB cannot create its lock file.  The lock file is
+	// at CoopLockPath or, if empty, next to the data file.  Read and write
+	// transactions exclude each other, see CoopLock.
+	CoopLock     bool
+	CoopLockPath string
+
+	// ReadonlyFS opens an immutable environment, such as a prebuilt dataset
+	// shipped in a container image, from a read-only filesystem.  It adds
+	// the Readonly and NoLock flags so that the lock file is neither created
+	// nor opened; locking is unnecessary as long as no process writes to the
+	// environment.  Write transactions fail with ErrReadonly.
+	ReadonlyFS bool
+}
+
+// OpenEnv creates an environment, configures it according to opts, and
+// opens it at path.  A nil opts is equivalent to the zero Options.  If
+// OpenEnv fails the environment is closed before returning.
+func OpenEnv(path string, opts *Options) (*Env, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	maxReaders := opts.MaxReaders
+	if maxReaders == 0 {
+		maxReaders = 256
+	}
+	mode := opts.Mode
+	if mode == 0 {
+		mode = 0644
+	}
+
+	env, err := NewEnvMaxReaders(maxReaders)
+	if err != nil {
+		return nil, err
+	}
+	err = env.configure(path, opts, mode)
+	if err != nil {
+		env.Close()
+		return nil, err
+	}
+	return env, nil
+}
+
+// configure applies opts to the unopened env and opens it at path.
+func (env *Env) configure(path string, opts *Options, mode os.FileMode) (err error) {
+	if opts.MaxDBs != 0 {
+		err = env.SetMaxDBs(opts.MaxDBs)
+		if err != nil {
+			return err
+		}
+	}
+	if opts.MapSize != 0 {
+		err = env.SetMapSize(opts.MapSize)
+		if err != nil {
+			return err
+		}
+	}
+	if opts.CriticalReaders != 0 {
+		err = env.ReserveReaders(opts.CriticalReaders)
+		if err != nil {
+			return err
+		}
+	}
+	if opts.FinalizerPolicy != FinalizeClose {
+		env.SetFinalizerPolicy(opts.FinalizerPolicy, nil)
+	}
+	if opts.ReaderAdmission != nil {
+		err = env.SetReaderAdmission(opts.ReaderAdmission)
+		if err != nil {
+			return err
+		}
+	}
+	env.viewRawRead = opts.ViewRawRead
+	env.updateFlags = opts.UpdateFlags & (NoSync | NoMetaSync)
+	env.checkMapExtent = opts.CheckMapExtent
+
+	flags := opts.Flags
+	if opts.ReadonlyFS {
+		flags |= Readonly | NoLock
+	}
+	if opts.CoopLock {
+		if flags&NoLock == 0 {
+			return errCoopLockNoLock
+		}
+		lockPath := opts.CoopLockPath
+		if lockPath == "" {
+			lockPath = coopLockPath(path, flags)
+		}
+		env.coop, err = NewCoopLock(lockPath)
+		if err != nil {
+			return err
+		}
+	}
+	if opts.ExpectPageSize != 0 {
+		err = checkNewPageSize(path, flags, opts.ExpectPageSize)
+		if err != nil {
+			return err
+		}
+	}
+	err = env.Open(path, flags, mode)
+	if err != nil {
+		return err
+	}
+	if opts.ExpectPageSize != 0 {
+		err = env.checkPageSize(opts.ExpectPageSize)
+		if err != nil {
+			return err
+		}
+	}
+	if opts.Exclusive {
+		err = env.checkExclusive()
+		if err != nil {
+			return err
+		}
+	}
+	if opts.SelfTest {
+		return env.SelfTest()
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package lmdb
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOpenEnv(t *testing.T) {
+	path, err := ioutil.TempDir("", "mdb_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(path)
+
+	env, err := OpenEnv(path, &Options{
+		MaxReaders:  16,
+		MaxDBs:      4,
+		MapSize:     8 << 20,
+		ViewRawRead: true,
+		UpdateFlags: NoSync | NoMetaSync | Readonly,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer env.Close()
+
+	if env.updateFlags != NoSync|NoMetaSync {
+		t.Errorf("unexpected update flags: %#x", env.updateFlags)
+	}
+	maxReaders, err := env.MaxReaders()
+	if err != nil {
+		t.Error(err)
+	}
+	if maxReaders != 16 {
+		t.Errorf("unexpected max readers: %d", maxReaders)
+	}
+	info, err := env.Info()
+	if err != nil {
+		t.Error(err)
+	} else if info.MapSize != 8<<20 {
+		t.Errorf("unexpected map size: %d", info.MapSize)
+	}
+
+	var dbi DBI
+	err = env.Update(func(txn *Txn) (err error) {
+		dbi, err = txn.CreateDBI("opts")
+		if err != nil {
+			return err
+		}
+		return txn.Put(dbi, []byte("k"), []byte("v"), 0)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = env.View(func(txn *Txn) (err error) {
+		if !txn.RawRead {
+			t.Errorf("View transaction does not have RawRead set")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+
+	_, err = OpenEnv(path+"/does-not-exist", nil)
+	if !IsNotExist(err) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestOpenEnv_ExpectPageSize(t *testing.T) {
+	path, err := ioutil.TempDir("", "mdb_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(path)
+
+	psize := DefaultPageSize()
+	_, err = OpenEnv(path, &Options{ExpectPageSize: 2 * psize})
+	if !errors.Is(err, ErrPageSize) {
+		t.Errorf("new environment with page size %d: %v", 2*psize, err)
+	}
+	if _, err := os.Stat(filepath.Join(path, "data.mdb")); !os.IsNotExist(err) {
+		t.Errorf("data file created: %v", err)
+	}
+
+	env, err := OpenEnv(path, &Options{ExpectPageSize: psize})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = env.Update(func(txn *Txn) (err error) {
+		dbi, err := txn.OpenRoot(0)
+		if err != nil {
+			return err
+		}
+		return txn.Put(dbi, []byte("k"), []byte("v"), 0)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.Close()
+
+	_, err = OpenEnv(path, &Options{ExpectPageSize: psize / 2})
+	var perr *PageSizeError
+	if !errors.As(err, &perr) {
+		t.Fatalf("existing environment with page size %d: %v", psize/2, err)
+	}
+	if perr.New || perr.PageSize != psize || perr.Want != psize/2 {
+		t.Errorf("unexpected error: %#v", perr)
+	}
+
+	env, err = OpenEnv(path, &Options{ExpectPageSize: psize})
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.Close()
+}
+
+func TestOpenEnv_ReadonlyFS(t *testing.T) {
+	path, err := ioutil.TempDir("", "mdb_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(path)
+
+	env, err := OpenEnv(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = env.Update(func(txn *Txn) (err error) {
+		dbi, err := txn.OpenRoot(0)
+		if err != nil {
+			return err
+		}
+		return txn.Put(dbi, []byte("k"), []byte("v"), 0)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.Close()
+	lock := filepath.Join(path, "lock.mdb")
+	err = os.Remove(lock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env, err = OpenEnv(path, &Options{ReadonlyFS: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer env.Close()
+	_, err = os.Stat(lock)
+	if !os.IsNotExist(err) {
+		t.Errorf("lock file created: %v", err)
+	}
+	err = env.View(func(txn *Txn) (err error) {
+		dbi, err := txn.OpenRoot(0)
+		if err != nil {
+			return err
+		}
+		v, err := txn.Get(dbi, []byte("k"))
+		if err != nil {
+			return err
+		}
+		if string(v) != "v" {
+			t.Errorf("value %q", v)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = env.Update(func(txn *Txn) error {
+		t.Errorf("write transaction began")
+		return nil
+	})
+	if err != ErrReadonly {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,203 @@
+package lmdb
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"sync"
+)
+
+// ErrOverlayStale is returned by Overlay.Flush when a write committed to the
+// environment after the snapshot of the overlay was pinned, so that the
+// staged writes may no longer be valid.
+var ErrOverlayStale = errors.New("overlay: environment changed since its snapshot")
+
+var errOverlayClosed = errors.New("overlay is closed")
+
+// Overlay stages writes in memory on top of a snapshot of an environment,
+// for "dry-run then commit" workflows: reads through the overlay see the
+// snapshot with the staged writes applied, so that e.g. a new configuration
+// can be validated as if it were written, and the staged writes are then
+// either discarded or flushed to the environment atomically as one batch.
+//
+// The snapshot is pinned by a read transaction held until Flush or Discard,
+// which must be called promptly, as for any long-lived reader.  Databases
+// written through an overlay must not use DupSort, and are read in bytewise
+// key order.  An Overlay is safe for concurrent use.
+type Overlay struct {
+	env *Env
+
+	mu    sync.Mutex
+	txn   *Txn // nil once flushed or discarded
+	dbs   map[DBI]map[string]overlayItem
+	batch WriteBatch
+}
+
+// overlayItem is the staged value of a key, or its deletion.
+type overlayItem struct {
+	val     []byte
+	deleted bool
+}
+
+// NewOverlay returns an Overlay over the current snapshot of env.
+func (env *Env) NewOverlay() (*Overlay, error) {
+	txn, err := env.BeginTxn(nil, Readonly)
+	if err != nil {
+		return nil, err
+	}
+	return &Overlay{env: env, txn: txn, dbs: make(map[DBI]map[string]overlayItem)}, nil
+}
+
+// Put stages the write of val under key in dbi.
+func (o *Overlay) Put(dbi DBI, key, val []byte) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.txn == nil {
+		return errOverlayClosed
+	}
+	o.staged(dbi)[string(key)] = overlayItem{val: cloneBytes(val)}
+	o.batch.Put(dbi, key, val)
+	return nil
+}
+
+// Del stages the deletion of key from dbi.  Unlike Txn.Del it does not fail
+// if key is absent.
+func (o *Overlay) Del(dbi DBI, key []byte) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.txn == nil {
+		return errOverlayClosed
+	}
+	o.staged(dbi)[string(key)] = overlayItem{deleted: true}
+	o.batch.Del(dbi, key, nil)
+	return nil
+}
+
+func (o *Overlay) staged(dbi DBI) map[string]overlayItem {
+	m, ok := o.dbs[dbi]
+	if !ok {
+		m = make(map[string]overlayItem)
+		o.dbs[dbi] = m
+	}
+	return m
+}
+
+// Len returns the number of writes staged in o.
+func (o *Overlay) Len() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.batch.Len()
+}
+
+// Get returns the value of key in dbi as staged in o or, if o has no write
+// of key, as read from the snapshot.  A value read from the snapshot is only
+// valid until o is flushed or discarded.
+func (o *Overlay) Get(dbi DBI, key []byte) ([]byte, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.txn == nil {
+		return nil, errOverlayClosed
+	}
+	if item, ok := o.dbs[dbi][string(key)]; ok {
+		if item.deleted {
+			return nil, &OpError{Op: "mdb_get", Errno: NotFound}
+		}
+		return item.val, nil
+	}
+	return o.txn.Get(dbi, key)
+}
+
+// Scan calls fn in key order for the items of dbi with keys from start,
+// inclusive, to end, exclusive, as seen through o.  A nil start scans from
+// the first key and a nil end to the last.  Scan stops at the first error of
+// fn and returns it.  The arguments of fn are only valid until it returns.
+func (o *Overlay) Scan(dbi DBI, start, end []byte, fn func(k, v []byte) error) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.txn == nil {
+		return errOverlayClosed
+	}
+
+	staged := o.dbs[dbi]
+	keys := make([]string, 0, len(staged))
+	for k := range staged {
+		if (start == nil || k >= string(start)) && (end == nil || k < string(end)) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	cur, err := o.txn.OpenCursor(dbi)
+	if err != nil {
+		return err
+	}
+	defer cur.Close()
+	var k, v []byte
+	if start == nil {
+		k, v, err = cur.Get(nil, nil, First)
+	} else {
+		k, v, err = cur.Get(start, nil, SetRange)
+	}
+	for {
+		if err != nil && !IsNotFound(err) {
+			return err
+		}
+		more := err == nil && (end == nil || bytes.Compare(k, end) < 0)
+		if !more && len(keys) == 0 {
+			return nil
+		}
+		if len(keys) > 0 && (!more || keys[0] <= string(k)) {
+			if more && keys[0] == string(k) {
+				k, v, err = cur.Get(nil, nil, Next)
+			}
+			item := staged[keys[0]]
+			if !item.deleted {
+				if err := fn([]byte(keys[0]), item.val); err != nil {
+					return err
+				}
+			}
+			keys = keys[1:]
+			continue
+		}
+		if err := fn(k, v); err != nil {
+			return err
+		}
+		k, v, err = cur.Get(nil, nil, Next)
+	}
+}
+
+// Discard drops the writes staged in o and releases its snapshot.
+func (o *Overlay) Discard() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.close()
+}
+
+func (o *Overlay) close() {
+	if o.txn != nil {
+		o.txn.Abort()
+		o.txn = nil
+	}
+	o.dbs = nil
+	o.batch.Reset()
+}
+
+// Flush writes the staged writes to the environment in one transaction, in
+// the order they were staged, and releases the snapshot of o.  It fails with
+// ErrOverlayStale, writing nothing, if the environment changed since the
+// snapshot was pinned.  After Flush, successful or not, o may not be used.
+func (o *Overlay) Flush() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.txn == nil {
+		return errOverlayClosed
+	}
+	defer o.close()
+	base := o.txn.ID()
+	return o.env.Update(func(txn *Txn) error {
+		if txn.ID() != base+1 {
+			return ErrOverlayStale
+		}
+		return txn.Apply(&o.batch)
+	})
+}
@@ -0,0 +1,104 @@
+package lmdb
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestOverlay(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+
+	var dbi DBI
+	err := env.Update(func(txn *Txn) (err error) {
+		dbi, err = txn.OpenDBI("config", Create)
+		if err != nil {
+			return err
+		}
+		for _, k := range []string{"a", "c", "e"} {
+			if err = txn.Put(dbi, []byte(k), []byte(k+"0"), 0); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scan := func(o *Overlay, start, end []byte) string {
+		var items []string
+		err := o.Scan(dbi, start, end, func(k, v []byte) error {
+			items = append(items, string(k)+"="+string(v))
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.Join(items, " ")
+	}
+
+	o, err := env.NewOverlay()
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.Put(dbi, []byte("b"), []byte("b1"))
+	o.Put(dbi, []byte("c"), []byte("c1"))
+	o.Del(dbi, []byte("e"))
+	o.Put(dbi, []byte("f"), []byte("f1"))
+	if s := scan(o, nil, nil); s != "a=a0 b=b1 c=c1 f=f1" {
+		t.Errorf("scan: %s", s)
+	}
+	if s := scan(o, []byte("b"), []byte("f")); s != "b=b1 c=c1" {
+		t.Errorf("range scan: %s", s)
+	}
+	if _, err := o.Get(dbi, []byte("e")); !IsNotFound(err) {
+		t.Errorf("deleted key: %v", err)
+	}
+	if v, err := o.Get(dbi, []byte("a")); err != nil || string(v) != "a0" {
+		t.Errorf("snapshot key: %q %v", v, err)
+	}
+	o.Discard()
+	if err = o.Put(dbi, []byte("x"), nil); err != errOverlayClosed {
+		t.Errorf("put after discard: %v", err)
+	}
+
+	o, err = env.NewOverlay()
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.Del(dbi, []byte("a"))
+	o.Put(dbi, []byte("d"), []byte("d1"))
+	if err = o.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	o, err = env.NewOverlay()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := scan(o, nil, nil); s != "c=c0 d=d1 e=e0" {
+		t.Errorf("after flush: %s", s)
+	}
+
+	// a write committed meanwhile makes the overlay stale.
+	o.Put(dbi, []byte("z"), []byte("z1"))
+	err = env.Update(func(txn *Txn) error {
+		return txn.Put(dbi, []byte("y"), []byte("y0"), 0)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = o.Flush(); err != ErrOverlayStale {
+		t.Errorf("stale flush: %v", err)
+	}
+	err = env.View(func(txn *Txn) error {
+		_, err := txn.Get(dbi, []byte("z"))
+		if !IsNotFound(err) {
+			t.Errorf("stale overlay written: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
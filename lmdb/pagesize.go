@@ -0,0 +1,84 @@
+package lmdb
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// maxPageSize is the largest page size LMDB creates (MAX_PAGESIZE in mdb.c).
+const maxPageSize = 0x8000
+
+// ErrPageSize indicates that an environment does not have the page size
+// expected with Options.ExpectPageSize.  Errors returned by OpenEnv for this
+// reason are *PageSizeError values for which errors.Is(err, ErrPageSize) is
+// true.
+var ErrPageSize = errors.New("page size mismatch")
+
+// PageSizeError describes an environment whose page size differs from the
+// one expected.
+type PageSizeError struct {
+	Path     string // path of the data file
+	PageSize int    // page size of the data file, or of a new data file
+	Want     int    // expected page size
+	New      bool   // the data file did not exist yet
+}
+
+func (err *PageSizeError) Error() string {
+	if err.New {
+		return fmt.Sprintf("%v: %s would be created with %d byte pages, %d expected", ErrPageSize, err.Path, err.PageSize, err.Want)
+	}
+	return fmt.Sprintf("%v: %s has %d byte pages, %d expected", ErrPageSize, err.Path, err.PageSize, err.Want)
+}
+
+// Is allows errors.Is(err, ErrPageSize) to match a *PageSizeError.
+func (err *PageSizeError) Is(target error) bool {
+	return target == ErrPageSize
+}
+
+// DefaultPageSize returns the page size of environments created by this
+// process, which is the OS page size limited to 32KiB.  LMDB offers no way to
+// choose another size; an existing environment keeps the page size it was
+// created with, even when opened on a system with a different OS page size.
+func DefaultPageSize() int {
+	psize := os.Getpagesize()
+	if psize > maxPageSize {
+		psize = maxPageSize
+	}
+	return psize
+}
+
+// checkNewPageSize fails if opening path with flags would create a data file
+// whose page size is not want.
+func checkNewPageSize(path string, flags uint, want int) error {
+	if flags&NoSubdir == 0 {
+		path = filepath.Join(path, "data.mdb")
+	}
+	fi, err := os.Stat(path)
+	if err == nil && fi.Size() > 0 || err != nil && !os.IsNotExist(err) {
+		// an existing data file is checked once it is open.
+		return nil
+	}
+	if psize := DefaultPageSize(); psize != want {
+		return &PageSizeError{Path: path, PageSize: psize, Want: want, New: true}
+	}
+	return nil
+}
+
+// checkPageSize fails if the open environment does not have pages of size
+// want.
+func (env *Env) checkPageSize(want int) error {
+	stat, err := env.Stat()
+	if err != nil {
+		return err
+	}
+	if int(stat.PSize) == want {
+		return nil
+	}
+	path, err := env.dataPath()
+	if err != nil {
+		return err
+	}
+	return &PageSizeError{Path: path, PageSize: int(stat.PSize), Want: want}
+}
@@ -0,0 +1,166 @@
+package lmdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"sync"
+)
+
+// ErrSnapshotChanged is returned by ParallelScan when a write committed
+// while its workers began their transactions, so that they did not all read
+// the same snapshot.
+var ErrSnapshotChanged = errors.New("ParallelScan: workers read different snapshots")
+
+// ParallelScan calls fn for every item of dbi from workers goroutines, each
+// scanning a range of keys in its own read transaction, so that CPU-bound
+// processing of a large database, such as building indexes, uses several
+// cores.  Each worker calls fn in key order, with its index, but the calls
+// of different workers are concurrent.  The key and value passed to fn are
+// only valid until fn returns.
+//
+// The key space between the first and last keys is split evenly, so
+// workers share the items evenly only if the keys are spread evenly.
+// ParallelScan returns the first error of fn, which stops every worker, and
+// ErrSnapshotChanged if a write committed while the workers started.
+func (env *Env) ParallelScan(dbi DBI, workers int, fn func(worker int, k, v []byte) error) error {
+	_, err := env.parallelScan(dbi, workers, fn)
+	return err
+}
+
+// parallelScan is ParallelScan, also returning the id of the snapshot
+// scanned.
+func (env *Env) parallelScan(dbi DBI, workers int, fn func(worker int, k, v []byte) error) (uintptr, error) {
+	if workers < 1 {
+		workers = 1
+	}
+	var first, last []byte
+	var id uintptr
+	err := env.View(func(txn *Txn) (err error) {
+		txn.RawRead = false
+		id = txn.ID()
+		first, _, err = txn.First(dbi)
+		if IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		last, _, err = txn.Last(dbi)
+		return err
+	})
+	if err != nil || first == nil {
+		return id, err
+	}
+	splits := splitKeys(first, last, workers)
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+		stop     bool
+		ids      = make([]uintptr, len(splits)+1)
+	)
+	fail := func(err error) {
+		mu.Lock()
+		if firstErr == nil {
+			firstErr = err
+		}
+		stop = true
+		mu.Unlock()
+	}
+	stopped := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return stop
+	}
+	for w := 0; w <= len(splits); w++ {
+		var start, end []byte
+		if w > 0 {
+			start = splits[w-1]
+		}
+		if w < len(splits) {
+			end = splits[w]
+		}
+		wg.Add(1)
+		go func(w int, start, end []byte) {
+			defer wg.Done()
+			err := env.View(func(txn *Txn) error {
+				txn.RawRead = true
+				ids[w] = txn.ID()
+				cur, err := txn.OpenCursor(dbi)
+				if err != nil {
+					return err
+				}
+				defer cur.Close()
+				var k, v []byte
+				if start == nil {
+					k, v, err = cur.Get(nil, nil, First)
+				} else {
+					k, v, err = cur.Get(start, nil, SetRange)
+				}
+				for n := 0; err == nil && (end == nil || bytes.Compare(k, end) < 0); n++ {
+					if n%1024 == 1023 && stopped() {
+						return nil
+					}
+					err = fn(w, k, v)
+					if err != nil {
+						return err
+					}
+					k, v, err = cur.Get(nil, nil, Next)
+				}
+				if IsNotFound(err) {
+					return nil
+				}
+				return err
+			})
+			if err != nil {
+				fail(err)
+			}
+		}(w, start, end)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return 0, firstErr
+	}
+	for _, id := range ids[1:] {
+		if id != ids[0] {
+			return 0, ErrSnapshotChanged
+		}
+	}
+	return ids[0], nil
+}
+
+// splitKeys returns up to n-1 increasing keys splitting the key space from
+// first to last, inclusive, in n ranges of equal extent.  The keys share the
+// common prefix of first and last, followed by 8 bytes interpolated between
+// the following bytes of first and last.
+func splitKeys(first, last []byte, n int) [][]byte {
+	p := 0
+	for p < len(first) && p < len(last) && first[p] == last[p] {
+		p++
+	}
+	word := func(b []byte) uint64 {
+		var buf [8]byte
+		if p < len(b) {
+			copy(buf[:], b[p:])
+		}
+		return binary.BigEndian.Uint64(buf[:])
+	}
+	a, b := word(first), word(last)
+	if n < 2 || b <= a {
+		return nil
+	}
+	step := (b - a) / uint64(n)
+	var splits [][]byte
+	for i := 1; i < n; i++ {
+		key := make([]byte, p+8)
+		copy(key, first[:p])
+		binary.BigEndian.PutUint64(key[p:], a+step*uint64(i))
+		if bytes.Compare(key, first) <= 0 || (len(splits) > 0 && bytes.Equal(key, splits[len(splits)-1])) {
+			continue
+		}
+		splits = append(splits, key)
+	}
+	return splits
+}
@@ -0,0 +1,125 @@
+package lmdb
+
+import (
+	"sort"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+// snapshotPins is the set of snapshots pinned on purpose in an Env, see
+// Env.PinSnapshot.
+type snapshotPins struct {
+	mu   sync.Mutex
+	pins map[*SnapshotPin]struct{}
+}
+
+// SnapshotPin registers a read transaction held open on purpose, see
+// Env.PinSnapshot.
+type SnapshotPin struct {
+	env     *Env
+	purpose string
+	txnID   uintptr
+	since   time.Time
+	once    sync.Once
+}
+
+// PinReport describes a pinned snapshot and its cost.
+type PinReport struct {
+	Purpose string
+	TxnID   uintptr // id of the snapshot, see Txn.ID
+	Since   time.Time
+
+	// HeldPages is the number of pages freed by the transactions committed
+	// since the snapshot, which cannot be reused while it is pinned and
+	// make the environment grow instead.  Older readers, pinned or not, may
+	// hold some of them too.
+	HeldPages int64
+}
+
+// PinSnapshot registers the read transaction txn as held open on purpose,
+// e.g. by a backup or a SharedSnapshot, so that PinReports can attribute the
+// growth of the environment to the purposes of long-lived snapshots: LMDB
+// cannot reuse the pages freed after the oldest snapshot still read.  The
+// pin must be released, before txn is terminated.
+func (env *Env) PinSnapshot(txn *Txn, purpose string) *SnapshotPin {
+	p := &SnapshotPin{env: env, purpose: purpose, txnID: txn.ID(), since: time.Now()}
+	ps := &env.pins
+	ps.mu.Lock()
+	if ps.pins == nil {
+		ps.pins = make(map[*SnapshotPin]struct{})
+	}
+	ps.pins[p] = struct{}{}
+	ps.mu.Unlock()
+	return p
+}
+
+// Release unregisters p.  Calls after the first have no effect.
+func (p *SnapshotPin) Release() {
+	p.once.Do(func() {
+		ps := &p.env.pins
+		ps.mu.Lock()
+		delete(ps.pins, p)
+		ps.mu.Unlock()
+	})
+}
+
+// PinReports returns the snapshots pinned in env, oldest first, with the
+// number of pages each of them keeps from reuse, counted by reading the
+// freelist.
+func (env *Env) PinReports() ([]PinReport, error) {
+	ps := &env.pins
+	ps.mu.Lock()
+	reports := make([]PinReport, 0, len(ps.pins))
+	for p := range ps.pins {
+		reports = append(reports, PinReport{Purpose: p.purpose, TxnID: p.txnID, Since: p.since})
+	}
+	ps.mu.Unlock()
+	if len(reports) == 0 {
+		return reports, nil
+	}
+	sort.Slice(reports, func(i, j int) bool {
+		if reports[i].TxnID != reports[j].TxnID {
+			return reports[i].TxnID < reports[j].TxnID
+		}
+		return reports[i].Since.Before(reports[j].Since)
+	})
+
+	// the freelist maps the id of the transaction that freed pages to the
+	// list of their numbers, preceded by its length, as native size_t.
+	err := env.View(func(txn *Txn) error {
+		txn.RawRead = true
+		cur, err := txn.OpenCursor(0)
+		if err != nil {
+			return err
+		}
+		defer cur.Close()
+		for op := uint(First); ; op = Next {
+			k, v, err := cur.Get(nil, nil, op)
+			if IsNotFound(err) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			freedBy := nativeSize(k)
+			n := int64(nativeSize(v))
+			// a snapshot keeps the pages freed by its own transaction id
+			// and later ones, see mdb_page_alloc.
+			for i := range reports {
+				if uint64(reports[i].TxnID) > freedBy {
+					break
+				}
+				reports[i].HeldPages += n
+			}
+		}
+	})
+	return reports, err
+}
+
+// nativeSize returns the size_t at the start of b, in native byte order.
+func nativeSize(b []byte) uint64 {
+	var x uintptr
+	copy((*[unsafe.Sizeof(x)]byte)(unsafe.Pointer(&x))[:], b)
+	return uint64(x)
+}
@@ -0,0 +1,75 @@
+package lmdb
+
+import (
+	"testing"
+)
+
+func TestEnv_PinReports(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+
+	var dbi DBI
+	write := func(n int) {
+		err := env.Update(func(txn *Txn) (err error) {
+			dbi, err = txn.OpenDBI("data", Create)
+			if err != nil {
+				return err
+			}
+			for i := 0; i < n; i++ {
+				k := []byte{byte(i >> 8), byte(i)}
+				if err = txn.Put(dbi, k, make([]byte, 100), 0); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(500)
+
+	txn, err := env.BeginTxn(nil, Readonly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer txn.Abort()
+	pin := env.PinSnapshot(txn, "backup")
+	reports, err := env.PinReports()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 1 || reports[0].Purpose != "backup" || reports[0].TxnID != txn.ID() {
+		t.Fatalf("unexpected reports %+v", reports)
+	}
+	held := reports[0].HeldPages
+
+	// rewriting the database frees its pages, which the pin holds.
+	for i := 0; i < 3; i++ {
+		write(500)
+	}
+	reports, err = env.PinReports()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 1 || reports[0].HeldPages <= held {
+		t.Errorf("pin holds %d pages, %d before the writes", reports[0].HeldPages, held)
+	}
+
+	pin.Release()
+	pin.Release()
+	reports, err = env.PinReports()
+	if err != nil || len(reports) != 0 {
+		t.Errorf("reports after release: %+v %v", reports, err)
+	}
+
+	s, err := env.NewSharedSnapshot(&SharedSnapshotOptions{Readers: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reports, err = env.PinReports()
+	if err != nil || len(reports) != 1 || reports[0].Purpose != "shared-snapshot" {
+		t.Errorf("shared snapshot reports: %+v %v", reports, err)
+	}
+	s.Close()
+}
@@ -0,0 +1,79 @@
+package lmdb
+
+// The presets below bundle Options for common uses of an environment.  Each
+// Open function opens path with the Options returned by the matching
+// function, which may be adjusted before calling OpenEnv instead.  Every
+// preset reserves a 1 GiB map, which costs address space rather than disk or
+// memory until pages are written, and allows 64 named databases.
+
+const (
+	presetMapSize = 1 << 30
+	presetMaxDBs  = 64
+)
+
+// DurableOptions favors safety over write throughput.  Every commit is
+// synced to disk before Update returns, so a committed transaction survives
+// an operating system crash or power loss, at the cost of one or two fsyncs
+// per commit; group small writes with a BatchWriter to amortize them.
+// Transactions check that the data file covers the map (CheckMapExtent)
+// and opening checks the meta pages and boundary items (SelfTest), so that
+// a damaged file is reported as an error rather than a crash.
+func DurableOptions() *Options {
+	return &Options{
+		MapSize:        presetMapSize,
+		MaxDBs:         presetMaxDBs,
+		CheckMapExtent: true,
+		SelfTest:       true,
+	}
+}
+
+// OpenDurable opens path with DurableOptions.
+func OpenDurable(path string) (*Env, error) {
+	return OpenEnv(path, DurableOptions())
+}
+
+// FastCacheOptions favors write throughput over durability, for data that
+// can be rebuilt, such as a cache.  Writes go directly to a writable map
+// (WriteMap) that is never synced explicitly (NoSync, MapAsync): the
+// operating system writes pages back at its own pace.  A process crash loses
+// nothing committed, but an operating system crash or power loss may lose
+// recent commits or leave the environment corrupt, in which case it must be
+// deleted.  Readahead is disabled (NoReadahead) as cache lookups are random.
+// With WriteMap, stray writes through pointers into the map can corrupt the
+// environment.
+func FastCacheOptions() *Options {
+	return &Options{
+		MapSize: presetMapSize,
+		MaxDBs:  presetMaxDBs,
+		Flags:   WriteMap | MapAsync | NoSync | NoReadahead,
+	}
+}
+
+// OpenFastCache opens path with FastCacheOptions.
+func OpenFastCache(path string) (*Env, error) {
+	return OpenEnv(path, FastCacheOptions())
+}
+
+// ReadMostlyOptions suits many concurrent readers and occasional writers.
+// It allows 1024 concurrent read transactions, 16 of which are reserved for
+// critical readers such as health checks (see ReserveReaders), so that a
+// burst of scans cannot starve them.  Readahead is disabled (NoReadahead),
+// which keeps random lookups in a database larger than memory from evicting
+// useful pages.  Commits are synced, apart from the meta page (NoMetaSync),
+// so an operating system crash may roll back the last commit but cannot
+// corrupt the environment.
+func ReadMostlyOptions() *Options {
+	return &Options{
+		MaxReaders:      1024,
+		CriticalReaders: 16,
+		MapSize:         presetMapSize,
+		MaxDBs:          presetMaxDBs,
+		Flags:           NoReadahead,
+		UpdateFlags:     NoMetaSync,
+	}
+}
+
+// OpenReadMostly opens path with ReadMostlyOptions.
+func OpenReadMostly(path string) (*Env, error) {
+	return OpenEnv(path, ReadMostlyOptions())
+}
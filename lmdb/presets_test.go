@@ -0,0 +1,60 @@
+package lmdb
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestPresets(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		open  func(string) (*Env, error)
+		flags uint
+	}{
+		{"durable", OpenDurable, 0},
+		{"fastcache", OpenFastCache, WriteMap | MapAsync | NoSync | NoReadahead},
+		{"readmostly", OpenReadMostly, NoReadahead},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			path, err := ioutil.TempDir("", "mdb_test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(path)
+
+			// twice, to open the environment created by the first time.
+			for i := 0; i < 2; i++ {
+				env, err := test.open(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				flags, err := env.Flags()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if flags&test.flags != test.flags {
+					t.Errorf("flags %#x lack %#x", flags, test.flags)
+				}
+				info, err := env.Info()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if info.MapSize != presetMapSize {
+					t.Errorf("map size %d", info.MapSize)
+				}
+				err = env.Update(func(txn *Txn) error {
+					dbi, err := txn.OpenDBI("db", Create)
+					if err != nil {
+						return err
+					}
+					return txn.Put(dbi, []byte("k"), []byte("v"), 0)
+				})
+				if err != nil {
+					t.Error(err)
+				}
+				env.Close()
+			}
+		})
+	}
+}
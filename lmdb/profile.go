@@ -0,0 +1,196 @@
+package lmdb
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// MaxProfileLabelSets bounds the number of distinct Labels profiled
+// separately by ProfileByLabels, so that labels with values such as request
+// ids do not grow the profile without limit.
+const MaxProfileLabelSets = 1000
+
+// ProfileOtherLabels is the key under which ProfileByLabels counts the
+// transactions of the Labels beyond the first MaxProfileLabelSets.
+const ProfileOtherLabels = "(other)"
+
+// ProfileReport aggregates the work done by the transactions of an
+// environment while profiling is enabled with Env.SetProfiling.
+type ProfileReport struct {
+	ReadTxns  int64 // read transactions terminated
+	WriteTxns int64 // write transactions terminated, including nested ones
+
+	// CgoCalls counts calls into LMDB made by Get, Put, Del and the
+	// cursor operations, including their batched variants.  CursorMoves
+	// counts the cursor gets among them.  MaxCgoCalls is the largest number
+	// of calls made by a single transaction.
+	CgoCalls    int64
+	CursorMoves int64
+	MaxCgoCalls int64
+
+	// BytesCopied counts the key and value bytes copied into Go memory
+	// and BytesRaw those returned as views of the memory map because of
+	// RawRead.
+	BytesCopied int64
+	BytesRaw    int64
+}
+
+// Txns returns the number of transactions in the report.
+func (r *ProfileReport) Txns() int64 {
+	return r.ReadTxns + r.WriteTxns
+}
+
+// String formats the report, followed by hints about the APIs that would
+// reduce the work measured.
+func (r *ProfileReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "txns: %d (%d read, %d write)\n", r.Txns(), r.ReadTxns, r.WriteTxns)
+	fmt.Fprintf(&b, "cgo calls: %d (%.1f per txn, max %d)\n", r.CgoCalls, r.perTxn(r.CgoCalls), r.MaxCgoCalls)
+	fmt.Fprintf(&b, "cursor moves: %d (%.1f per txn)\n", r.CursorMoves, r.perTxn(r.CursorMoves))
+	fmt.Fprintf(&b, "bytes copied: %d (%.1f per txn)\n", r.BytesCopied, r.perTxn(r.BytesCopied))
+	fmt.Fprintf(&b, "bytes raw: %d\n", r.BytesRaw)
+	if r.BytesCopied > 0 && r.BytesCopied >= r.BytesRaw {
+		fmt.Fprintf(&b, "hint: RawRead (or Options.ViewRawRead) would avoid copying %d bytes\n", r.BytesCopied)
+	}
+	if r.perTxn(r.CgoCalls) >= 100 {
+		b.WriteString("hint: many cgo calls per txn; consider GetMatch, GetKey, GetDupBatch, DelBatch, PutDupBatch or PutMulti\n")
+	}
+	return b.String()
+}
+
+func (r *ProfileReport) perTxn(n int64) float64 {
+	if r.Txns() == 0 {
+		return 0
+	}
+	return float64(n) / float64(r.Txns())
+}
+
+// envProfile accumulates the profiles of terminated transactions, overall
+// and per Labels.
+type envProfile struct {
+	enabled  int32
+	mu       sync.Mutex
+	report   ProfileReport
+	byLabels map[string]*ProfileReport
+}
+
+// txnProfile counts the work of one transaction.  It is only touched by the
+// goroutine running the transaction.
+type txnProfile struct {
+	calls  int64
+	moves  int64
+	copied int64
+	raw    int64
+}
+
+// SetProfiling enables or disables counting the cgo calls, cursor moves and
+// bytes copied by the transactions of env, see Env.Profile.  Transactions
+// begun while profiling is disabled are not counted.  Profiling costs a few
+// additions per operation and one lock per transaction.
+func (env *Env) SetProfiling(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&env.prof.enabled, v)
+}
+
+// Profile returns the aggregate profile of the transactions terminated since
+// profiling was enabled or last reset.
+func (env *Env) Profile() ProfileReport {
+	env.prof.mu.Lock()
+	defer env.prof.mu.Unlock()
+	return env.prof.report
+}
+
+// ProfileByLabels returns the aggregate profiles of the labeled
+// transactions, see Env.ViewContext, keyed by the String form of their
+// Labels, for up to MaxProfileLabelSets distinct Labels; the others are
+// counted together under ProfileOtherLabels.  Unlabeled transactions are
+// only counted by Profile.
+func (env *Env) ProfileByLabels() map[string]ProfileReport {
+	env.prof.mu.Lock()
+	defer env.prof.mu.Unlock()
+	m := make(map[string]ProfileReport, len(env.prof.byLabels))
+	for k, r := range env.prof.byLabels {
+		m[k] = *r
+	}
+	return m
+}
+
+// ResetProfile clears the aggregate profiles.
+func (env *Env) ResetProfile() {
+	env.prof.mu.Lock()
+	env.prof.report = ProfileReport{}
+	env.prof.byLabels = nil
+	env.prof.mu.Unlock()
+}
+
+// startProfile attaches a profile to a new txn if profiling is enabled.
+func (txn *Txn) startProfile() {
+	if atomic.LoadInt32(&txn.env.prof.enabled) != 0 {
+		txn.prof = &txnProfile{}
+	}
+}
+
+// profCall counts a call into LMDB that moved a cursor moves times.  txn may
+// be nil for a closed cursor.
+func (txn *Txn) profCall(moves int) {
+	if txn == nil {
+		return
+	}
+	if p := txn.prof; p != nil {
+		p.calls++
+		p.moves += int64(moves)
+	}
+}
+
+// flushProfile adds the profile of txn to its environment and clears it.
+func (txn *Txn) flushProfile() {
+	p := txn.prof
+	if p == nil {
+		return
+	}
+	var key string
+	if len(txn.labels) > 0 {
+		key = txn.labels.String()
+	}
+	ep := &txn.env.prof
+	ep.mu.Lock()
+	ep.report.add(txn.readonly, p)
+	if key != "" {
+		r := ep.byLabels[key]
+		if r == nil && len(ep.byLabels) >= MaxProfileLabelSets {
+			key = ProfileOtherLabels
+			r = ep.byLabels[key]
+		}
+		if r == nil {
+			if ep.byLabels == nil {
+				ep.byLabels = make(map[string]*ProfileReport)
+			}
+			r = &ProfileReport{}
+			ep.byLabels[key] = r
+		}
+		r.add(txn.readonly, p)
+	}
+	ep.mu.Unlock()
+	*p = txnProfile{}
+}
+
+// add counts the transaction profile p in r.
+func (r *ProfileReport) add(readonly bool, p *txnProfile) {
+	if readonly {
+		r.ReadTxns++
+	} else {
+		r.WriteTxns++
+	}
+	r.CgoCalls += p.calls
+	r.CursorMoves += p.moves
+	r.BytesCopied += p.copied
+	r.BytesRaw += p.raw
+	if p.calls > r.MaxCgoCalls {
+		r.MaxCgoCalls = p.calls
+	}
+}
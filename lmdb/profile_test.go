@@ -0,0 +1,86 @@
+package lmdb
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEnv_Profile(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+
+	var dbi DBI
+	err := env.Update(func(txn *Txn) (err error) {
+		dbi, err = txn.OpenDBI("prof", Create)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env.SetProfiling(true)
+	err = env.Update(func(txn *Txn) (err error) {
+		for _, k := range []string{"a", "b", "c"} {
+			err = txn.Put(dbi, []byte(k), []byte("value"), 0)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = env.View(func(txn *Txn) (err error) {
+		_, err = txn.Get(dbi, []byte("a"))
+		if err != nil {
+			return err
+		}
+		cur, err := txn.OpenCursor(dbi)
+		if err != nil {
+			return err
+		}
+		defer cur.Close()
+		for {
+			_, _, err = cur.Get(nil, nil, Next)
+			if IsNotFound(err) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.SetProfiling(false)
+	err = env.View(func(txn *Txn) (err error) {
+		_, err = txn.Get(dbi, []byte("a"))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := env.Profile()
+	want := ProfileReport{
+		ReadTxns:    1,
+		WriteTxns:   1,
+		CgoCalls:    8, // 3 puts, 1 get, 4 cursor gets (the last one fails)
+		CursorMoves: 4,
+		MaxCgoCalls: 5,
+		BytesCopied: 5 + 3*(1+5),
+	}
+	if r != want {
+		t.Errorf("profile:\n%v\nwant:\n%v", &r, &want)
+	}
+	if !strings.Contains(r.String(), "hint: RawRead") {
+		t.Errorf("no RawRead hint:\n%v", &r)
+	}
+
+	env.ResetProfile()
+	if r := env.Profile(); r != (ProfileReport{}) {
+		t.Errorf("profile not reset: %+v", r)
+	}
+}
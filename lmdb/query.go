@@ -0,0 +1,413 @@
+package lmdb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var errQueryOp = errors.New("invalid query op")
+
+// QueryOp compares the keys of an index, or of the primary database, to a
+// value in a condition of a Query.
+type QueryOp int
+
+// Query ops.
+const (
+	Eq        QueryOp = iota // Equal to the value.
+	Lt                       // Less than the value.
+	Le                       // Less than or equal to the value.
+	Gt                       // Greater than the value.
+	Ge                       // Greater than or equal to the value.
+	HasPrefix                // Beginning with the value.
+)
+
+// Query selects records of a primary database by conditions on the keys of
+// its secondary indexes, DupSort databases mapping index keys to primary
+// keys as read by IndexCursor, and on its own keys.  A Query is built by
+// chaining calls from Q and compiled into a plan of cursor scans when run:
+//
+//	items, err := lmdb.Q(users).Where(byCity, lmdb.Eq, city).Where(byAge, lmdb.Ge, age).Limit(10).All(txn)
+//
+// The plan scans the most selective index, an equality before a bounded
+// range before a half-open one, resolves the records through the primary
+// database, probes the other indexes with an equality for the primary key of
+// each entry and checks the others against the set of primary keys they
+// contain in range, read beforehand.  Without conditions on indexes the
+// plan scans the primary database.  Explain describes the plan.
+//
+// Keys are compared bytewise, so the databases must not use IntegerKey,
+// ReverseKey or a custom comparison function.
+type Query struct {
+	primary DBI
+	keys    keyBounds    // on primary keys
+	conds   []*indexCond // one per index, in order of appearance
+	limit   int
+	skip    bool
+	err     error
+}
+
+type indexCond struct {
+	dbi DBI
+	b   keyBounds
+}
+
+// Q starts a query of the records of the primary database dbi.
+func Q(dbi DBI) *Query {
+	return &Query{primary: dbi}
+}
+
+// Where restricts q to the records with an entry in index whose key compares
+// to value as op tells.  Conditions on the same index combine into a single
+// range of it, and an index equal to the primary database of q restricts its
+// keys, as Range does.
+func (q *Query) Where(index DBI, op QueryOp, value []byte) *Query {
+	b := &q.keys
+	if index != q.primary {
+		var c *indexCond
+		for _, cond := range q.conds {
+			if cond.dbi == index {
+				c = cond
+			}
+		}
+		if c == nil {
+			c = &indexCond{dbi: index}
+			q.conds = append(q.conds, c)
+		}
+		b = &c.b
+	}
+	value = cloneBytes(value)
+	switch op {
+	case Eq:
+		b.setLo(value, false)
+		b.setHi(value, true)
+	case Lt:
+		b.setHi(value, false)
+	case Le:
+		b.setHi(value, true)
+	case Gt:
+		b.setLo(value, true)
+	case Ge:
+		b.setLo(value, false)
+	case HasPrefix:
+		b.setLo(value, false)
+		if end := prefixEnd(value); end != nil {
+			b.setHi(end, false)
+		}
+	default:
+		if q.err == nil {
+			q.err = fmt.Errorf("%v: %d", errQueryOp, op)
+		}
+	}
+	return q
+}
+
+// Range restricts q to the records with primary keys from start, included,
+// to end, excluded.  A nil start or end leaves the range unbounded on that
+// side.
+func (q *Query) Range(start, end []byte) *Query {
+	if start != nil {
+		q.keys.setLo(cloneBytes(start), false)
+	}
+	if end != nil {
+		q.keys.setHi(cloneBytes(end), false)
+	}
+	return q
+}
+
+// Limit makes q return at most n records, all of them if n is not positive.
+func (q *Query) Limit(n int) *Query {
+	q.limit = n
+	return q
+}
+
+// SkipDangling makes q skip the index entries whose primary record is
+// missing, instead of failing with a *DanglingIndexError.
+func (q *Query) SkipDangling() *Query {
+	q.skip = true
+	return q
+}
+
+// queryPlan is a compiled Query.
+type queryPlan struct {
+	scan   *indexCond // nil to scan the primary database
+	probes []*indexCond
+	joins  []*indexCond
+}
+
+func (q *Query) plan() queryPlan {
+	var p queryPlan
+	best := -1
+	for _, c := range q.conds {
+		if rank := c.b.rank(); rank > best {
+			p.scan, best = c, rank
+		}
+	}
+	for _, c := range q.conds {
+		switch {
+		case c == p.scan:
+		case c.b.isEq():
+			p.probes = append(p.probes, c)
+		default:
+			p.joins = append(p.joins, c)
+		}
+	}
+	return p
+}
+
+// Explain describes the plan of q, one step per line.
+func (q *Query) Explain() string {
+	if q.err != nil {
+		return fmt.Sprintf("invalid query: %v\n", q.err)
+	}
+	p := q.plan()
+	var s strings.Builder
+	if p.scan == nil {
+		fmt.Fprintf(&s, "scan primary dbi %d %v\n", q.primary, &q.keys)
+	} else {
+		fmt.Fprintf(&s, "scan index dbi %d %v\n", p.scan.dbi, &p.scan.b)
+		if q.keys.bounded() {
+			fmt.Fprintf(&s, "  filter primary key %v\n", &q.keys)
+		}
+	}
+	for _, c := range p.probes {
+		fmt.Fprintf(&s, "  probe index dbi %d %v\n", c.dbi, &c.b)
+	}
+	for _, c := range p.joins {
+		fmt.Fprintf(&s, "  join index dbi %d %v (hashed)\n", c.dbi, &c.b)
+	}
+	if p.scan != nil {
+		fmt.Fprintf(&s, "  fetch primary dbi %d\n", q.primary)
+	}
+	if q.limit > 0 {
+		fmt.Fprintf(&s, "  limit %d\n", q.limit)
+	}
+	return s.String()
+}
+
+// Each calls fn with the primary key and record of each record selected by
+// q, in the order of the index scanned, see Explain, until fn returns an
+// error, which Each returns.  The slices follow the rules of Txn.Get.
+func (q *Query) Each(txn *Txn, fn func(key, val []byte) error) error {
+	if q.err != nil {
+		return q.err
+	}
+	p := q.plan()
+
+	joins := make([]map[string]struct{}, len(p.joins))
+	for i, c := range p.joins {
+		set := make(map[string]struct{})
+		err := c.b.scan(txn, c.dbi, func(k, pk []byte) error {
+			set[string(pk)] = struct{}{}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		joins[i] = set
+	}
+	probes := make([]*Cursor, len(p.probes))
+	for i, c := range p.probes {
+		cur, err := txn.OpenCursor(c.dbi)
+		if err != nil {
+			return err
+		}
+		defer cur.Close()
+		probes[i] = cur
+	}
+	match := func(pk []byte) (bool, error) {
+		for i, cur := range probes {
+			ok, err := cur.HasDup(p.probes[i].b.lo, pk)
+			if !ok || err != nil {
+				return false, err
+			}
+		}
+		for _, set := range joins {
+			if _, ok := set[string(pk)]; !ok {
+				return false, nil
+			}
+		}
+		return true, nil
+	}
+
+	n := 0
+	emit := func(k, v []byte) error {
+		err := fn(k, v)
+		if err != nil {
+			return err
+		}
+		n++
+		if q.limit > 0 && n >= q.limit {
+			return errQueryLimit
+		}
+		return nil
+	}
+	var err error
+	if p.scan == nil {
+		err = q.keys.scan(txn, q.primary, func(k, v []byte) error {
+			ok, err := match(k)
+			if !ok || err != nil {
+				return err
+			}
+			return emit(k, v)
+		})
+	} else {
+		err = p.scan.b.scan(txn, p.scan.dbi, func(ik, pk []byte) error {
+			if !q.keys.contains(pk) {
+				return nil
+			}
+			ok, err := match(pk)
+			if !ok || err != nil {
+				return err
+			}
+			v, err := txn.Get(q.primary, pk)
+			if IsNotFound(err) {
+				if q.skip {
+					return nil
+				}
+				return &DanglingIndexError{IndexKey: cloneBytes(ik), PrimaryKey: cloneBytes(pk)}
+			}
+			if err != nil {
+				return err
+			}
+			return emit(pk, v)
+		})
+	}
+	if err == errQueryLimit {
+		return nil
+	}
+	return err
+}
+
+// errQueryLimit stops the scan of a query at its limit.
+var errQueryLimit = errors.New("query limit reached")
+
+// All returns the primary keys and records selected by q, as Each.
+func (q *Query) All(txn *Txn) ([]KV, error) {
+	var items []KV
+	err := q.Each(txn, func(k, v []byte) error {
+		items = append(items, KV{Key: k, Val: v})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// keyBounds is a range of keys, unbounded on a side until set.
+type keyBounds struct {
+	lo, hi         []byte
+	hasLo, hasHi   bool
+	loExcl, hiIncl bool
+}
+
+// setLo raises the lower bound of b to k, excluded if excl.
+func (b *keyBounds) setLo(k []byte, excl bool) {
+	c := bytes.Compare(k, b.lo)
+	if !b.hasLo || c > 0 || c == 0 && excl {
+		b.lo, b.hasLo, b.loExcl = k, true, excl
+	}
+}
+
+// setHi lowers the upper bound of b to k, included if incl.
+func (b *keyBounds) setHi(k []byte, incl bool) {
+	c := bytes.Compare(k, b.hi)
+	if !b.hasHi || c < 0 || c == 0 && !incl {
+		b.hi, b.hasHi, b.hiIncl = k, true, incl
+	}
+}
+
+func (b *keyBounds) isEq() bool {
+	return b.hasLo && b.hasHi && !b.loExcl && b.hiIncl && bytes.Equal(b.lo, b.hi)
+}
+
+func (b *keyBounds) bounded() bool {
+	return b.hasLo || b.hasHi
+}
+
+// rank orders bounds by expected selectivity.
+func (b *keyBounds) rank() int {
+	switch {
+	case b.isEq():
+		return 3
+	case b.hasLo && b.hasHi:
+		return 2
+	case b.bounded():
+		return 1
+	}
+	return 0
+}
+
+func (b *keyBounds) aboveLo(k []byte) bool {
+	if !b.hasLo {
+		return true
+	}
+	c := bytes.Compare(k, b.lo)
+	return c > 0 || c == 0 && !b.loExcl
+}
+
+func (b *keyBounds) belowHi(k []byte) bool {
+	if !b.hasHi {
+		return true
+	}
+	c := bytes.Compare(k, b.hi)
+	return c < 0 || c == 0 && b.hiIncl
+}
+
+func (b *keyBounds) contains(k []byte) bool {
+	return b.aboveLo(k) && b.belowHi(k)
+}
+
+// scan calls fn with the items of dbi within b, in order.
+func (b *keyBounds) scan(txn *Txn, dbi DBI, fn func(k, v []byte) error) error {
+	cur, err := txn.OpenCursor(dbi)
+	if err != nil {
+		return err
+	}
+	defer cur.Close()
+	var k, v []byte
+	if len(b.lo) == 0 {
+		k, v, err = cur.Get(nil, nil, First)
+	} else {
+		k, v, err = cur.Get(b.lo, nil, SetRange)
+	}
+	for ; err == nil; k, v, err = cur.Get(nil, nil, Next) {
+		if !b.aboveLo(k) {
+			continue
+		}
+		if !b.belowHi(k) {
+			return nil
+		}
+		err = fn(k, v)
+		if err != nil {
+			return err
+		}
+	}
+	if IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// String formats b for Query.Explain.
+func (b *keyBounds) String() string {
+	if b.isEq() {
+		return fmt.Sprintf("= %q", b.lo)
+	}
+	lo, hi := "(-inf", "+inf)"
+	if b.hasLo {
+		lo = fmt.Sprintf("[%q", b.lo)
+		if b.loExcl {
+			lo = fmt.Sprintf("(%q", b.lo)
+		}
+	}
+	if b.hasHi {
+		hi = fmt.Sprintf("%q)", b.hi)
+		if b.hiIncl {
+			hi = fmt.Sprintf("%q]", b.hi)
+		}
+	}
+	return "in " + lo + ", " + hi
+}
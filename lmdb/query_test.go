@@ -0,0 +1,160 @@
+package lmdb
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// setupQuery fills a primary database of users u01..u12 with secondary
+// indexes of their city and age.
+func setupQuery(t *testing.T, env *Env) (users, byCity, byAge DBI) {
+	cities := []string{"oslo", "rome", "lima"}
+	err := env.Update(func(txn *Txn) (err error) {
+		users, err = txn.OpenDBI("users", Create)
+		if err != nil {
+			return err
+		}
+		byCity, err = txn.OpenDBI("users-city", Create|DupSort)
+		if err != nil {
+			return err
+		}
+		byAge, err = txn.OpenDBI("users-age", Create|DupSort)
+		if err != nil {
+			return err
+		}
+		for i := 1; i <= 12; i++ {
+			id := []byte(fmt.Sprintf("u%02d", i))
+			city := cities[i%3]
+			age := fmt.Sprint(20 + 5*(i%4))
+			if err = txn.Put(users, id, []byte(city+"/"+age), 0); err != nil {
+				return err
+			}
+			if err = txn.Put(byCity, []byte(city), id, 0); err != nil {
+				return err
+			}
+			if err = txn.Put(byAge, []byte(age), id, 0); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return users, byCity, byAge
+}
+
+func queryKeys(t *testing.T, env *Env, q *Query) string {
+	var keys []string
+	err := env.View(func(txn *Txn) error {
+		items, err := q.All(txn)
+		for _, kv := range items {
+			keys = append(keys, string(kv.Key))
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Join(keys, " ")
+}
+
+func TestQuery(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+	users, byCity, byAge := setupQuery(t, env)
+
+	// cities: u03 u06 u09 u12 oslo, u01 u04 u07 u10 rome, u02 u05 u08 u11
+	// lima; ages: u04 u08 u12 20, u01 u05 u09 25, u02 u06 u10 30, u03 u07
+	// u11 35.
+	for _, test := range []struct {
+		q    *Query
+		want string
+	}{
+		{Q(users), "u01 u02 u03 u04 u05 u06 u07 u08 u09 u10 u11 u12"},
+		{Q(users).Range([]byte("u05"), []byte("u08")), "u05 u06 u07"},
+		{Q(users).Where(users, Gt, []byte("u10")), "u11 u12"},
+		{Q(users).Where(users, HasPrefix, []byte("u0")).Limit(3), "u01 u02 u03"},
+		{Q(users).Where(byCity, Eq, []byte("rome")), "u01 u04 u07 u10"},
+		{Q(users).Where(byCity, HasPrefix, []byte("l")), "u02 u05 u08 u11"},
+		{Q(users).Where(byAge, Ge, []byte("30")), "u02 u06 u10 u03 u07 u11"},
+		{Q(users).Where(byAge, Gt, []byte("20")).Where(byAge, Le, []byte("30")), "u01 u05 u09 u02 u06 u10"},
+		{Q(users).Where(byAge, Lt, []byte("30")).Where(byCity, Eq, []byte("oslo")), "u09 u12"},
+		{Q(users).Where(byAge, Eq, []byte("30")).Where(byCity, Eq, []byte("rome")), "u10"},
+		{Q(users).Where(byCity, Eq, []byte("oslo")).Range(nil, []byte("u09")), "u03 u06"},
+		{Q(users).Where(byCity, Eq, []byte("oslo")).Where(byCity, Eq, []byte("rome")), ""},
+		{Q(users).Where(byCity, Eq, []byte("paris")), ""},
+	} {
+		got := queryKeys(t, env, test.q)
+		if got != test.want {
+			t.Errorf("query\n%s: %q, want %q", test.q.Explain(), got, test.want)
+		}
+	}
+}
+
+func TestQuery_Explain(t *testing.T) {
+	q := Q(1).Where(2, Ge, []byte("b")).Where(3, Eq, []byte("x")).Where(4, Lt, []byte("z")).Range([]byte("a"), nil).Limit(5)
+	want := `scan index dbi 3 = "x"
+  filter primary key in ["a", +inf)
+  join index dbi 2 in ["b", +inf) (hashed)
+  join index dbi 4 in (-inf, "z") (hashed)
+  fetch primary dbi 1
+  limit 5
+`
+	if got := q.Explain(); got != want {
+		t.Errorf("explain\n%s\nwant\n%s", got, want)
+	}
+
+	q = Q(1).Where(2, HasPrefix, []byte("ab")).Where(3, HasPrefix, []byte{0xff})
+	want = `scan index dbi 2 in ["ab", "ac")
+  join index dbi 3 in ["\xff", +inf) (hashed)
+  fetch primary dbi 1
+`
+	if got := q.Explain(); got != want {
+		t.Errorf("explain\n%s\nwant\n%s", got, want)
+	}
+
+	if got := Q(1).Explain(); got != "scan primary dbi 1 in (-inf, +inf)\n" {
+		t.Errorf("explain %q", got)
+	}
+}
+
+func TestQuery_Errors(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+	users, byCity, _ := setupQuery(t, env)
+
+	err := env.Update(func(txn *Txn) error {
+		return txn.Del(users, []byte("u04"), nil)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = env.View(func(txn *Txn) error {
+		_, err := Q(users).Where(byCity, Eq, []byte("rome")).All(txn)
+		if !errors.Is(err, ErrDanglingIndex) {
+			t.Errorf("dangling entry: %v", err)
+		}
+		items, err := Q(users).Where(byCity, Eq, []byte("rome")).SkipDangling().All(txn)
+		if err != nil || len(items) != 3 {
+			t.Errorf("skipping dangling entries: %d items, %v", len(items), err)
+		}
+
+		_, err = Q(users).Where(byCity, QueryOp(42), nil).All(txn)
+		if err == nil {
+			t.Errorf("no error for an invalid op")
+		}
+
+		errStop := errors.New("stop")
+		err = Q(users).Each(txn, func(k, v []byte) error { return errStop })
+		if err != errStop {
+			t.Errorf("each: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
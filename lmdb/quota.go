@@ -0,0 +1,152 @@
+package lmdb
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+)
+
+// ErrQuotaExceeded indicates that a write submitted to a BatchWriter would
+// exceed a quota.  Such writes fail with a *QuotaError for which
+// errors.Is(err, ErrQuotaExceeded) is true.
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// QuotaError describes a write refused by a quota.
+type QuotaError struct {
+	Quota string // name of the quota
+	Limit int64  // limit of the quota
+	Usage int64  // usage, including writes admitted but not yet committed
+	Bytes int64  // charge of the refused write
+}
+
+func (err *QuotaError) Error() string {
+	return fmt.Sprintf("%v: %s: %d + %d bytes over the limit of %d", ErrQuotaExceeded, err.Quota, err.Usage, err.Bytes, err.Limit)
+}
+
+// Is allows errors.Is(err, ErrQuotaExceeded) to match a *QuotaError.
+func (err *QuotaError) Is(target error) bool {
+	return target == ErrQuotaExceeded
+}
+
+// QuotaPolicy determines what a BatchWriter does with writes that would
+// exceed a quota.
+type QuotaPolicy int
+
+// The policies applied to over-quota writes.
+const (
+	// QuotaReject fails over-quota writes with a *QuotaError without
+	// running them.
+	QuotaReject QuotaPolicy = iota
+
+	// QuotaDefer runs over-quota writes only when no other write is queued
+	// (or when Flush or Close is called), so that a tenant over its quota
+	// slows down instead of failing, without delaying the others.
+	QuotaDefer
+)
+
+// Charge is the amount a write adds to a quota, in bytes or any other unit
+// the application accounts in.  Negative charges, e.g. for deletions, are
+// never refused.
+type Charge struct {
+	Quota string
+	Bytes int64
+}
+
+// DBIQuota returns the name of a quota on the database dbi, for quotas per
+// database rather than per tenant.
+func DBIQuota(dbi DBI) string {
+	return "dbi:" + strconv.FormatUint(uint64(dbi), 10)
+}
+
+// Quotas holds the limits and usage of a set of named quotas, e.g. one per
+// tenant or per database (see DBIQuota), against which a BatchWriter admits
+// writes.  Usage is not persisted: the application sets the initial usage of
+// each quota with SetUsage, typically from its own accounting, and describes
+// the effect of each write with the charges it is submitted with.  Quotas is
+// safe for concurrent use.
+type Quotas struct {
+	mu      sync.Mutex
+	limits  map[string]int64
+	usage   map[string]int64
+	pending map[string]int64 // positive charges of admitted writes
+}
+
+// NewQuotas returns an empty set of quotas.
+func NewQuotas() *Quotas {
+	return &Quotas{
+		limits:  make(map[string]int64),
+		usage:   make(map[string]int64),
+		pending: make(map[string]int64),
+	}
+}
+
+// SetLimit sets the limit of quota.  A negative limit removes it, leaving the
+// quota unlimited.
+func (q *Quotas) SetLimit(quota string, limit int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if limit < 0 {
+		delete(q.limits, quota)
+		return
+	}
+	q.limits[quota] = limit
+}
+
+// SetUsage sets the usage of quota, excluding writes not yet committed.
+func (q *Quotas) SetUsage(quota string, usage int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.usage[quota] = usage
+}
+
+// Usage returns the committed usage of quota and the charges of the writes
+// admitted but not yet committed.
+func (q *Quotas) Usage(quota string) (usage, pending int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.usage[quota], q.pending[quota]
+}
+
+// reserve admits a write with the given charges, adding them to the pending
+// charges, unless one of them would exceed its quota.
+func (q *Quotas) reserve(charges []Charge) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, c := range charges {
+		limit, ok := q.limits[c.Quota]
+		if !ok || c.Bytes <= 0 {
+			continue
+		}
+		usage := q.usage[c.Quota] + q.pending[c.Quota]
+		if usage+c.Bytes > limit {
+			return &QuotaError{Quota: c.Quota, Limit: limit, Usage: usage, Bytes: c.Bytes}
+		}
+	}
+	for _, c := range charges {
+		if c.Bytes > 0 {
+			q.pending[c.Quota] += c.Bytes
+		}
+	}
+	return nil
+}
+
+// settle removes the charges of a write admitted by reserve from the pending
+// charges and, if the write committed, adds them to the usage.
+func (q *Quotas) settle(charges []Charge, reserved, committed bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, c := range charges {
+		if reserved && c.Bytes > 0 {
+			q.pending[c.Quota] -= c.Bytes
+		}
+		if committed {
+			q.usage[c.Quota] += c.Bytes
+		}
+	}
+}
+
+// release removes the charges of an admitted write that was not submitted.
+func (q *Quotas) release(charges []Charge) {
+	q.settle(charges, true, false)
+}
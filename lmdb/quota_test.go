@@ -0,0 +1,130 @@
+package lmdb
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestBatchWriter_Quotas(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+
+	dbi, err := openRoot(env, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	put := func(key string) TxnOp {
+		return func(txn *Txn) error {
+			return txn.Put(dbi, []byte(key), []byte(key), 0)
+		}
+	}
+
+	q := NewQuotas()
+	q.SetLimit("a", 10)
+	q.SetLimit(DBIQuota(dbi), 100)
+	q.SetUsage("a", 4)
+	w := env.NewBatchWriter(&BatchWriterOptions{Quotas: q})
+	defer w.Close()
+
+	err = w.DoCharged(put("a1"), Charge{"a", 6}, Charge{DBIQuota(dbi), 6})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage, pending := q.Usage("a"); usage != 10 || pending != 0 {
+		t.Errorf("usage %d, pending %d", usage, pending)
+	}
+
+	// refused writes do not run nor charge the other quotas.
+	err = w.DoCharged(put("a2"), Charge{DBIQuota(dbi), 1}, Charge{"a", 1})
+	var qerr *QuotaError
+	if !errors.Is(err, ErrQuotaExceeded) || !errors.As(err, &qerr) || qerr.Quota != "a" || qerr.Usage != 10 {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if usage, _ := q.Usage(DBIQuota(dbi)); usage != 6 {
+		t.Errorf("database usage %d", usage)
+	}
+
+	// deletions are admitted and free quota.
+	err = w.DoCharged(func(txn *Txn) error {
+		return txn.Del(dbi, []byte("a1"), nil)
+	}, Charge{"a", -6})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = w.DoCharged(put("a2"), Charge{"a", 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// failing writes release their charges.
+	errFail := errors.New("fail")
+	err = w.DoCharged(func(txn *Txn) error { return errFail }, Charge{"a", 5})
+	if err != errFail {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if usage, pending := q.Usage("a"); usage != 5 || pending != 0 {
+		t.Errorf("usage %d, pending %d", usage, pending)
+	}
+	err = env.View(func(txn *Txn) error {
+		_, err := txn.Get(dbi, []byte("a2"))
+		return err
+	})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBatchWriter_QuotaDefer(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+
+	dbi, err := openRoot(env, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := NewQuotas()
+	q.SetLimit("t", 0)
+	w := env.NewBatchWriter(&BatchWriterOptions{Quotas: q, QuotaPolicy: QuotaDefer})
+
+	var order []string
+	started, block := make(chan struct{}), make(chan struct{})
+	first := w.Enqueue(func(txn *Txn) error {
+		close(started)
+		<-block
+		return nil
+	})
+	<-started
+	deferred := w.EnqueueCharged(func(txn *Txn) error {
+		order = append(order, "deferred")
+		return txn.Put(dbi, []byte("d"), []byte("d"), 0)
+	}, Charge{"t", 1})
+	later := w.Enqueue(func(txn *Txn) error {
+		order = append(order, "later")
+		return nil
+	})
+	close(block)
+	for _, c := range []<-chan error{first, later, deferred} {
+		if err := <-c; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(order) != 2 || order[0] != "later" {
+		t.Errorf("order: %v", order)
+	}
+	if usage, _ := q.Usage("t"); usage != 1 {
+		t.Errorf("usage %d", usage)
+	}
+
+	// deferred writes are committed by Close.
+	deferred = w.EnqueueCharged(func(txn *Txn) error {
+		return txn.Put(dbi, []byte("e"), []byte("e"), 0)
+	}, Charge{"t", 1})
+	w.Close()
+	if err := <-deferred; err != nil {
+		t.Fatal(err)
+	}
+	err = w.DoCharged(func(txn *Txn) error { return nil }, Charge{"t", 1})
+	if err != ErrBatchWriterClosed {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
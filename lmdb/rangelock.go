@@ -0,0 +1,172 @@
+package lmdb
+
+import (
+	"bytes"
+	"context"
+)
+
+// KeyRange is the range of keys of a database from Start, inclusive, to
+// End, exclusive, in lexicographic byte order.  A nil Start begins at the
+// smallest key and a nil End extends past the largest key.
+type KeyRange struct {
+	DBI   DBI
+	Start []byte
+	End   []byte
+}
+
+// KeyRangeOf returns the range holding key alone.
+func KeyRangeOf(dbi DBI, key []byte) KeyRange {
+	end := make([]byte, len(key)+1)
+	copy(end, key)
+	return KeyRange{DBI: dbi, Start: key, End: end}
+}
+
+// overlaps reports whether r and s share a key.
+func (r KeyRange) overlaps(s KeyRange) bool {
+	if r.DBI != s.DBI {
+		return false
+	}
+	return (r.End == nil || s.Start == nil || bytes.Compare(s.Start, r.End) < 0) &&
+		(s.End == nil || r.Start == nil || bytes.Compare(r.Start, s.End) < 0)
+}
+
+// RangeLocker grants advisory locks on key ranges to the goroutines of a
+// process, so that logical operations spanning several transactions, such as
+// a read followed by a dependent write, exclude the operations on
+// overlapping ranges while the others proceed.  The locks are not known to
+// LMDB and only exclude code that takes them.
+//
+// A lock covers its ranges at once, so that goroutines locking several
+// ranges cannot deadlock, and locks are granted in the order requested among
+// overlapping requests, so that a stream of small locks cannot starve a
+// large one.
+type RangeLocker struct {
+	mu   chan struct{} // a mutex that can be waited for with a context
+	reqs []*rangeLockReq
+}
+
+type rangeLockReq struct {
+	ranges  []KeyRange
+	ready   chan struct{}
+	granted bool
+}
+
+// RangeLock is a lock held on ranges, released by Unlock.
+type RangeLock struct {
+	l   *RangeLocker
+	req *rangeLockReq
+}
+
+// NewRangeLocker returns a RangeLocker holding no lock.
+func NewRangeLocker() *RangeLocker {
+	return &RangeLocker{mu: make(chan struct{}, 1)}
+}
+
+func (l *RangeLocker) lock()   { l.mu <- struct{}{} }
+func (l *RangeLocker) unlock() { <-l.mu }
+
+// Lock waits until no lock held or requested before covers a range
+// overlapping one of ranges, then locks ranges.  If ctx is done first Lock
+// returns its error and no lock.
+func (l *RangeLocker) Lock(ctx context.Context, ranges ...KeyRange) (*RangeLock, error) {
+	req := &rangeLockReq{ranges: ranges, ready: make(chan struct{})}
+	l.lock()
+	l.reqs = append(l.reqs, req)
+	l.grant()
+	l.unlock()
+	select {
+	case <-req.ready:
+		return &RangeLock{l: l, req: req}, nil
+	case <-ctx.Done():
+	}
+	// the lock may have been granted meanwhile; it is released either way.
+	l.release(req)
+	return nil, ctx.Err()
+}
+
+// TryLock locks ranges if no lock held or requested overlaps them, and
+// returns nil otherwise.
+func (l *RangeLocker) TryLock(ranges ...KeyRange) *RangeLock {
+	l.lock()
+	defer l.unlock()
+	req := &rangeLockReq{ranges: ranges}
+	for _, r := range l.reqs {
+		if r.overlaps(req) {
+			return nil
+		}
+	}
+	req.granted = true
+	l.reqs = append(l.reqs, req)
+	return &RangeLock{l: l, req: req}
+}
+
+// Unlock releases the ranges of lk.  Unlock must be called once.
+func (lk *RangeLock) Unlock() {
+	lk.l.release(lk.req)
+}
+
+// release removes req, granted or waiting, and grants the requests it held
+// back.
+func (l *RangeLocker) release(req *rangeLockReq) {
+	l.lock()
+	defer l.unlock()
+	for i, r := range l.reqs {
+		if r == req {
+			l.reqs = append(l.reqs[:i], l.reqs[i+1:]...)
+			break
+		}
+	}
+	l.grant()
+}
+
+// grant grants the waiting requests overlapping no earlier request.  The
+// caller holds l.mu.
+func (l *RangeLocker) grant() {
+	for i, req := range l.reqs {
+		if req.granted {
+			continue
+		}
+		free := true
+		for _, r := range l.reqs[:i] {
+			if r.overlaps(req) {
+				free = false
+				break
+			}
+		}
+		if free {
+			req.granted = true
+			close(req.ready)
+		}
+	}
+}
+
+// overlaps reports whether a range of req overlaps a range of s.
+func (req *rangeLockReq) overlaps(s *rangeLockReq) bool {
+	for _, r := range req.ranges {
+		for _, q := range s.ranges {
+			if r.overlaps(q) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// RangeLocks returns the RangeLocker of w, see BatchWriterOptions.RangeLocks.
+func (w *BatchWriter) RangeLocks() *RangeLocker {
+	return w.locks
+}
+
+// DoLocked locks ranges in the RangeLocker of w, submits op and waits for
+// its result, see Do, then unlocks ranges.  Operations on overlapping ranges
+// thus commit in the order their locks were granted, while code holding a
+// lock on the same RangeLocker excludes them.  If ctx is done before the
+// lock is granted op is not submitted and the error of ctx is returned.
+func (w *BatchWriter) DoLocked(ctx context.Context, ranges []KeyRange, op TxnOp) error {
+	lk, err := w.locks.Lock(ctx, ranges...)
+	if err != nil {
+		return err
+	}
+	defer lk.Unlock()
+	return w.Do(op)
+}
@@ -0,0 +1,164 @@
+package lmdb
+
+import (
+	"context"
+	"encoding/binary"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestKeyRange_overlaps(t *testing.T) {
+	b := func(s string) []byte { return []byte(s) }
+	for _, test := range []struct {
+		r, s KeyRange
+		want bool
+	}{
+		{KeyRange{1, b("a"), b("c")}, KeyRange{1, b("b"), b("d")}, true},
+		{KeyRange{1, b("a"), b("c")}, KeyRange{1, b("c"), b("d")}, false},
+		{KeyRange{1, b("a"), b("c")}, KeyRange{2, b("a"), b("c")}, false},
+		{KeyRange{1, nil, nil}, KeyRange{1, b("x"), b("y")}, true},
+		{KeyRange{1, b("x"), nil}, KeyRange{1, nil, b("x")}, false},
+		{KeyRangeOf(1, b("k")), KeyRange{1, b("k\x00"), nil}, false},
+		{KeyRangeOf(1, b("k")), KeyRange{1, nil, b("k\x00")}, true},
+	} {
+		if got := test.r.overlaps(test.s); got != test.want {
+			t.Errorf("%q overlaps %q = %v", test.r, test.s, got)
+		}
+		if got := test.s.overlaps(test.r); got != test.want {
+			t.Errorf("%q overlaps %q = %v", test.s, test.r, got)
+		}
+	}
+}
+
+func TestRangeLocker(t *testing.T) {
+	ctx := context.Background()
+	l := NewRangeLocker()
+	am, err := l.Lock(ctx, KeyRange{1, []byte("a"), []byte("m")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.TryLock(KeyRangeOf(1, []byte("b"))) != nil {
+		t.Error("overlapping TryLock succeeded")
+	}
+	other := l.TryLock(KeyRangeOf(2, []byte("b")))
+	if other == nil {
+		t.Error("TryLock of another database failed")
+	} else {
+		other.Unlock()
+	}
+
+	// kz waits for am, and np, which only overlaps kz, waits behind it.
+	order := make(chan string, 2)
+	var wg sync.WaitGroup
+	lockAfter := func(name string, r KeyRange, delay time.Duration) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			time.Sleep(delay)
+			lk, err := l.Lock(ctx, r)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			order <- name
+			lk.Unlock()
+		}()
+	}
+	lockAfter("kz", KeyRange{1, []byte("k"), []byte("z")}, 0)
+	time.Sleep(20 * time.Millisecond)
+	lockAfter("np", KeyRange{1, []byte("n"), []byte("p")}, 0)
+
+	// a context done while waiting leaves no lock.
+	cctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, err := l.Lock(cctx, KeyRangeOf(1, []byte("c"))); err != context.DeadlineExceeded {
+		t.Errorf("err = %v, want DeadlineExceeded", err)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	select {
+	case name := <-order:
+		t.Fatalf("%s locked while am was held", name)
+	default:
+	}
+	am.Unlock()
+	wg.Wait()
+	if first, second := <-order, <-order; first != "kz" || second != "np" {
+		t.Errorf("locked in order %s, %s", first, second)
+	}
+	if len(l.reqs) != 0 {
+		t.Errorf("%d requests left", len(l.reqs))
+	}
+}
+
+func TestBatchWriter_DoLocked(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+
+	var dbi DBI
+	err := env.Update(func(txn *Txn) (err error) {
+		dbi, err = txn.OpenRoot(0)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := env.NewBatchWriter(nil)
+	defer w.Close()
+
+	// read-modify-write across transactions loses no increment when the
+	// logical operations lock the key.
+	key := []byte("counter")
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 10; i++ {
+				lk, err := w.RangeLocks().Lock(context.Background(), KeyRangeOf(dbi, key))
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				var n uint64
+				err = env.View(func(txn *Txn) error {
+					v, err := txn.Get(dbi, key)
+					if err == nil {
+						n = binary.BigEndian.Uint64(v)
+					} else if !IsNotFound(err) {
+						return err
+					}
+					return nil
+				})
+				if err == nil {
+					err = w.Do(func(txn *Txn) error {
+						v := make([]byte, 8)
+						binary.BigEndian.PutUint64(v, n+1)
+						return txn.Put(dbi, key, v, 0)
+					})
+				}
+				lk.Unlock()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	err = w.DoLocked(context.Background(), []KeyRange{KeyRangeOf(dbi, key)}, func(txn *Txn) error {
+		v, err := txn.Get(dbi, key)
+		if err != nil {
+			return err
+		}
+		if n := binary.BigEndian.Uint64(v); n != 80 {
+			t.Errorf("counter = %d, want 80", n)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,153 @@
+package lmdb
+
+/*
+#include "lmdb.h"
+#include "lmdbgo.h"
+*/
+import "C"
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrReadersLow indicates a read transaction refused by the admission
+// control of Env.SetReaderAdmission.  Refusals are returned as
+// *ReadersLowError values for which errors.Is(err, ErrReadersLow) is true.
+var ErrReadersLow = errors.New("reader table headroom below reserve")
+
+var errReaderAdmission = errors.New("reader admission reserve must leave at least one entry of the reader table")
+
+// ReadersLowError describes a read transaction refused for lack of free
+// entries in the reader table.
+type ReadersLowError struct {
+	Free    int // free entries of the reader table when refused
+	Reserve int
+}
+
+func (err *ReadersLowError) Error() string {
+	return fmt.Sprintf("%v: %d free entries, %d reserved", ErrReadersLow, err.Free, err.Reserve)
+}
+
+// Is allows errors.Is(err, ErrReadersLow) to match a *ReadersLowError.
+func (err *ReadersLowError) Is(target error) bool {
+	return target == ErrReadersLow
+}
+
+// ReadersHeadroom describes the remaining capacity of the reader table of an
+// environment, shared by the processes using it, and of the read slots of an
+// Env, which only bound the readers of the process, see ReadSlotStats.  A
+// read transaction begun while the table is full fails with
+// MDB_READERS_FULL.
+type ReadersHeadroom struct {
+	Entries int // entries of the reader table, see Env.MaxReaders
+	Used    int // entries held by readers of any process
+	Free    int
+
+	// FreeSlots is the number of read slots of the Env held by no reader.
+	FreeSlots int
+}
+
+// ReadersHeadroom returns the remaining capacity of the reader table of env,
+// read by scanning it.  Entries left by crashed processes count as used
+// until cleared by Env.ReaderCheck.
+func (env *Env) ReadersHeadroom() (ReadersHeadroom, error) {
+	var h ReadersHeadroom
+	var err error
+	h.Entries, err = env.MaxReaders()
+	if err != nil {
+		return h, err
+	}
+	var used C.int
+	ret := C.lmdbgo_mdb_reader_count(env._env, &used)
+	if ret < 0 {
+		return h, operrno("mdb_reader_list", ret)
+	}
+	h.Used = int(used)
+	h.Free = h.Entries - h.Used
+	env.rkeyMu.Lock()
+	h.FreeSlots = len(env.rkeyAvail)
+	env.rkeyMu.Unlock()
+	return h, nil
+}
+
+// ReaderAdmission configures the admission control of new read
+// transactions, see Env.SetReaderAdmission.
+type ReaderAdmission struct {
+	// Reserve is the number of free entries of the reader table below
+	// which new read transactions are not admitted.
+	Reserve int
+
+	// Queue is how long a read transaction not admitted waits for free
+	// entries before failing with a *ReadersLowError, zero to fail at
+	// once.
+	Queue time.Duration
+
+	// Poll is the interval at which waiting transactions check the reader
+	// table again, 1ms if zero, since readers of other processes leave
+	// without notice.
+	Poll time.Duration
+}
+
+// SetReaderAdmission makes env refuse, or queue, the new read transactions
+// of ordinary readers while fewer than a.Reserve entries of the reader table
+// would be left free, so that a process sharing the environment with others
+// degrades predictably, with *ReadersLowError from the calls beginning
+// transactions, instead of failing with MDB_READERS_FULL wherever the table
+// happens to fill up.  The reserve also leaves room for critical readers,
+// see WithCritical, which are always admitted.  The table is scanned each
+// time a read transaction takes a read slot, see ReadersHeadroom.  A nil a
+// removes admission control.
+func (env *Env) SetReaderAdmission(a *ReaderAdmission) error {
+	if a != nil {
+		entries, err := env.MaxReaders()
+		if err != nil {
+			return err
+		}
+		if a.Reserve < 0 || a.Reserve >= entries {
+			return errReaderAdmission
+		}
+		c := *a
+		if c.Poll <= 0 {
+			c.Poll = time.Millisecond
+		}
+		a = &c
+	}
+	env.admission.Store(a)
+	return nil
+}
+
+// admitReader waits until an ordinary reader may take an entry of the
+// reader table, according to the admission control of env, or returns a
+// *ReadersLowError.
+func (env *Env) admitReader() error {
+	a, _ := env.admission.Load().(*ReaderAdmission)
+	if a == nil {
+		return nil
+	}
+	var deadline time.Time
+	for {
+		h, err := env.ReadersHeadroom()
+		if err != nil {
+			return err
+		}
+		if h.Free > a.Reserve {
+			if !deadline.IsZero() {
+				env.rkeyMu.Lock()
+				env.rkeyDelayed++
+				env.rkeyMu.Unlock()
+			}
+			return nil
+		}
+		if deadline.IsZero() {
+			deadline = time.Now().Add(a.Queue)
+		}
+		if !time.Now().Before(deadline) {
+			env.rkeyMu.Lock()
+			env.rkeyRefused++
+			env.rkeyMu.Unlock()
+			return &ReadersLowError{Free: h.Free, Reserve: a.Reserve}
+		}
+		time.Sleep(a.Poll)
+	}
+}
@@ -0,0 +1,106 @@
+package lmdb
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestReaderAdmission(t *testing.T) {
+	path, err := ioutil.TempDir("", "mdb_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(path)
+
+	env, err := OpenEnv(path, &Options{MaxReaders: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer env.Close()
+
+	h, err := env.ReadersHeadroom()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Entries != 4 || h.Used != 0 || h.Free != 4 || h.FreeSlots != 4 {
+		t.Errorf("headroom of an idle env %+v", h)
+	}
+
+	if err := env.SetReaderAdmission(&ReaderAdmission{Reserve: 4}); err == nil {
+		t.Error("reserving every entry succeeded")
+	}
+	err = env.SetReaderAdmission(&ReaderAdmission{Reserve: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var txns []*Txn
+	for i := 0; i < 2; i++ {
+		txn, err := env.BeginTxn(nil, Readonly)
+		if err != nil {
+			t.Fatal(err)
+		}
+		txns = append(txns, txn)
+	}
+	h, err = env.ReadersHeadroom()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Used != 2 || h.Free != 2 || h.FreeSlots != 2 {
+		t.Errorf("headroom with 2 readers %+v", h)
+	}
+
+	_, err = env.BeginTxn(nil, Readonly)
+	var low *ReadersLowError
+	if !errors.As(err, &low) || !errors.Is(err, ErrReadersLow) || low.Free != 2 || low.Reserve != 2 {
+		t.Errorf("reader beyond the reserve: %v", err)
+	}
+	err = env.View(func(txn *Txn) error { return nil })
+	if !errors.Is(err, ErrReadersLow) {
+		t.Errorf("view beyond the reserve: %v", err)
+	}
+	err = env.ViewContext(WithCritical(context.Background()), func(txn *Txn) error { return nil })
+	if err != nil {
+		t.Errorf("critical reader: %v", err)
+	}
+	// updates do not take entries of the reader table.
+	err = env.Update(func(txn *Txn) error { return nil })
+	if err != nil {
+		t.Errorf("update: %v", err)
+	}
+
+	err = env.SetReaderAdmission(&ReaderAdmission{Reserve: 2, Queue: 10 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		txns[0].Abort()
+	}()
+	err = env.View(func(txn *Txn) error { return nil })
+	if err != nil {
+		t.Errorf("queued reader: %v", err)
+	}
+	txns[1].Abort()
+
+	stats := env.ReadSlotStats()
+	if stats.Refused != 2 || stats.Delayed != 1 {
+		t.Errorf("refused %d, delayed %d", stats.Refused, stats.Delayed)
+	}
+
+	err = env.SetReaderAdmission(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		txn, err := env.BeginTxn(nil, Readonly)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer txn.Abort()
+	}
+}
@@ -0,0 +1,155 @@
+package lmdb
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"time"
+)
+
+var errReserveReaders = errors.New("reserved readers must leave at least one slot unreserved")
+
+type criticalKey struct{}
+
+// WithCritical returns a context marking the read transactions begun with it
+// by Env.ViewContext as critical, e.g. health checks or interactive queries.
+// Critical readers may take the slots kept by Env.ReserveReaders, so that
+// they still get a slot while ordinary readers hold every other one.
+func WithCritical(ctx context.Context) context.Context {
+	return context.WithValue(ctx, criticalKey{}, true)
+}
+
+// IsCritical reports whether ctx was returned by WithCritical, or derives
+// from such a context.
+func IsCritical(ctx context.Context) bool {
+	c, _ := ctx.Value(criticalKey{}).(bool)
+	return c
+}
+
+// ReserveReaders keeps n of the read slots of env (see NewEnvMaxReaders) for
+// critical readers, see WithCritical.  Ordinary readers wait rather than
+// take one of the last n free slots, unless critical readers already hold
+// that many, so that a storm of scans cannot starve the probes that would
+// detect it.  Critical readers take any free slot and only wait when every
+// slot is held.  n must leave at least one slot to ordinary readers; zero
+// removes the reservation.  Write transactions do not use read slots and are
+// not affected.
+func (env *Env) ReserveReaders(n int) error {
+	if n < 0 || n >= env.maxReaders {
+		return errReserveReaders
+	}
+	env.rkeyMu.Lock()
+	env.rkeyReserved = n
+	env.rkeyMu.Unlock()
+	// a smaller reservation may admit waiting readers.
+	env.rkeyCond.Broadcast()
+	return nil
+}
+
+// readSlotFree reports whether a critical or ordinary reader may take one
+// of the free read slots.  The caller holds rkeyMu.
+func (env *Env) readSlotFree(critical bool) bool {
+	free := len(env.rkeyAvail)
+	if critical {
+		return free > 0
+	}
+	kept := env.rkeyReserved - env.rkeyCritical
+	if kept < 0 {
+		kept = 0
+	}
+	return free > kept
+}
+
+// ReadSlotStats reports the use of the read slots of an environment.
+type ReadSlotStats struct {
+	Slots    int // read slots of the environment, see NewEnvMaxReaders
+	Free     int // slots held by no reader
+	Reserved int // slots kept for critical readers, see ReserveReaders
+	Critical int // slots held by critical readers
+	Waiting  int // readers waiting for a slot
+
+	// Refused and Delayed count the readers refused and queued by the
+	// admission control of SetReaderAdmission.
+	Refused uint64
+	Delayed uint64
+
+	// DirectPath, SphynxPath and CriticalPath describe the slots taken by
+	// the read transactions begun directly (BeginTxn, View, NewRawReadTxn,
+	// GetOrWaitForReadSlot...), by SphynxReader jobs and by critical
+	// readers, all drawn from the same pool.
+	DirectPath   ReadPathStats
+	SphynxPath   ReadPathStats
+	CriticalPath ReadPathStats
+}
+
+// ReadPathStats describes the slots taken by one way of acquiring them.
+type ReadPathStats struct {
+	Held     int    // slots currently held
+	Acquired uint64 // slots taken
+	Waited   uint64 // slots taken after waiting
+	WaitTime time.Duration
+}
+
+// readPath is a way of acquiring a read slot, see ReadSlotStats.
+type readPath int
+
+const (
+	readPathDirect readPath = iota
+	readPathSphynx
+	readPathCritical
+	numReadPaths
+)
+
+// readPathCounts are the counters behind ReadPathStats, protected by
+// rkeyMu.
+type readPathCounts struct {
+	held     int
+	acquired uint64
+	waited   uint64
+	waitTime time.Duration
+}
+
+func (c *readPathCounts) stats() ReadPathStats {
+	return ReadPathStats{Held: c.held, Acquired: c.acquired, Waited: c.waited, WaitTime: c.waitTime}
+}
+
+// ReadSlotStats returns the current use of the read slots of env.  Readers
+// waiting while Free is not zero are ordinary readers kept out of the
+// reserved slots.
+func (env *Env) ReadSlotStats() ReadSlotStats {
+	env.rkeyMu.Lock()
+	defer env.rkeyMu.Unlock()
+	return ReadSlotStats{
+		Slots:    env.maxReaders,
+		Free:     len(env.rkeyAvail),
+		Reserved: env.rkeyReserved,
+		Critical: env.rkeyCritical,
+		Waiting:  env.rkeyWaiting,
+		Refused:  env.rkeyRefused,
+		Delayed:  env.rkeyDelayed,
+
+		DirectPath:   env.rkeyPaths[readPathDirect].stats(),
+		SphynxPath:   env.rkeyPaths[readPathSphynx].stats(),
+		CriticalPath: env.rkeyPaths[readPathCritical].stats(),
+	}
+}
+
+// runCritical is run for a read transaction of a critical reader.
+func (env *Env) runCritical(lock bool, flags uint, fn TxnOp) error {
+	if lock {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+	}
+	rs, err := env.getReadSlot(readPathCritical)
+	if err != nil {
+		return err
+	}
+	// once begun the transaction returns rs when it terminates.
+	txn, err := beginTxnWithReadSlot(env, nil, flags, rs)
+	if err != nil {
+		env.ReturnReadSlot(rs)
+		return err
+	}
+	txn.RawRead = env.viewRawRead
+	return txn.runOpTerm(fn)
+}
@@ -0,0 +1,185 @@
+package lmdb
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestReserveReaders(t *testing.T) {
+	path, err := ioutil.TempDir("", "mdb_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(path)
+
+	env, err := OpenEnv(path, &Options{MaxReaders: 4, CriticalReaders: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer env.Close()
+
+	if err := env.ReserveReaders(4); err == nil {
+		t.Error("reserving every slot succeeded")
+	}
+
+	// ordinary readers hold every unreserved slot.
+	var held []*ReadSlot
+	for i := 0; i < 3; i++ {
+		rs, err := env.GetOrWaitForReadSlot()
+		if err != nil {
+			t.Fatal(err)
+		}
+		held = append(held, rs)
+	}
+
+	got := make(chan *ReadSlot)
+	go func() {
+		rs, _ := env.GetOrWaitForReadSlot()
+		got <- rs
+	}()
+	deadline := time.Now().Add(10 * time.Second)
+	for env.ReadSlotStats().Waiting != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("ordinary reader did not wait for a slot")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// the critical reader takes the reserved slot.
+	ctx := WithCritical(context.Background())
+	err = env.ViewContext(ctx, func(txn *Txn) error {
+		stats := env.ReadSlotStats()
+		if stats.Critical != 1 || stats.Free != 0 {
+			t.Errorf("unexpected stats in critical reader: %+v", stats)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-got:
+		t.Fatal("ordinary reader took the reserved slot")
+	default:
+	}
+
+	// a slot returned by an ordinary reader admits the waiter.
+	env.ReturnReadSlot(held[0])
+	held[0] = <-got
+	for _, rs := range held {
+		env.ReturnReadSlot(rs)
+	}
+
+	stats := env.ReadSlotStats()
+	if d := stats.DirectPath; d.Acquired != 4 || d.Waited != 1 || d.Held != 0 || d.WaitTime <= 0 {
+		t.Errorf("direct path stats = %+v", d)
+	}
+	if c := stats.CriticalPath; c.Acquired != 1 || c.Waited != 0 || c.Held != 0 {
+		t.Errorf("critical path stats = %+v", c)
+	}
+	stats.DirectPath, stats.CriticalPath = ReadPathStats{}, ReadPathStats{}
+	want := ReadSlotStats{Slots: 4, Free: 4, Reserved: 1}
+	if stats != want {
+		t.Errorf("stats = %+v, want %+v", stats, want)
+	}
+
+	// without a reservation ordinary readers may take every slot.
+	err = env.ReserveReaders(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	held = held[:0]
+	for i := 0; i < 4; i++ {
+		rs, err := env.GetOrWaitForReadSlot()
+		if err != nil {
+			t.Fatal(err)
+		}
+		held = append(held, rs)
+	}
+	for _, rs := range held {
+		env.ReturnReadSlot(rs)
+	}
+}
+
+func TestReadSlotFairness(t *testing.T) {
+	path, err := ioutil.TempDir("", "mdb_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(path)
+
+	env, err := OpenEnv(path, &Options{MaxReaders: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer env.Close()
+	env.UseSphynxReader()
+
+	waitFor := func(n int) {
+		t.Helper()
+		deadline := time.Now().Add(10 * time.Second)
+		for env.ReadSlotStats().Waiting != n {
+			if time.Now().After(deadline) {
+				t.Fatalf("%d readers waiting, want %d", env.ReadSlotStats().Waiting, n)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	var held []*ReadSlot
+	for i := 0; i < 2; i++ {
+		rs, err := env.GetOrWaitForReadSlot()
+		if err != nil {
+			t.Fatal(err)
+		}
+		held = append(held, rs)
+	}
+
+	// a SphynxReader job asks for a slot before a direct reader.
+	order := make(chan string, 2)
+	release := make(chan struct{})
+	go env.SphynxReader(func(txn *Txn, slot int) error {
+		order <- "sphynx"
+		<-release
+		return nil
+	})
+	waitFor(1)
+	go func() {
+		txn, err := env.NewRawReadTxn()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		order <- "direct"
+		txn.Abort()
+	}()
+	waitFor(2)
+
+	env.ReturnReadSlot(held[0])
+	if first := <-order; first != "sphynx" {
+		t.Errorf("%s reader served first", first)
+	}
+	stats := env.ReadSlotStats()
+	if stats.SphynxPath.Held != 1 || stats.SphynxPath.Waited != 1 || stats.DirectPath.Held != 1 {
+		t.Errorf("stats = %+v", stats)
+	}
+	env.ReturnReadSlot(held[1])
+	if second := <-order; second != "direct" {
+		t.Errorf("%s reader served second", second)
+	}
+	close(release)
+}
+
+func TestIsCritical(t *testing.T) {
+	ctx := context.Background()
+	if IsCritical(ctx) {
+		t.Error("background context is critical")
+	}
+	ctx = WithLabels(WithCritical(ctx), Labels{"probe": "health"})
+	if !IsCritical(ctx) {
+		t.Error("derived context is not critical")
+	}
+}
@@ -0,0 +1,92 @@
+package lmdb
+
+import (
+	"errors"
+	"sync"
+)
+
+var errMultiReaderDone = errors.New("MultiReader used after ReadMulti returned")
+
+// MultiReader reads one snapshot of an environment on behalf of several
+// goroutines, see Env.ReadMulti.  Its methods may be called concurrently;
+// they are serialized on the read transaction, so a MultiReader suits
+// CPU-bound processing of the items read rather than parallel I/O.  Values
+// returned are copies owned by the caller.
+type MultiReader struct {
+	mu  sync.Mutex
+	txn *Txn // nil once ReadMulti returned
+}
+
+// ReadMulti calls fn with a MultiReader over a read transaction, so that
+// goroutines started by fn read from the same snapshot of every database.
+// fn must wait for those goroutines before returning: the transaction ends
+// when fn returns, after which the MultiReader fails with an error.
+// ReadMulti returns the error of fn.
+func (env *Env) ReadMulti(fn func(r *MultiReader) error) error {
+	return env.View(func(txn *Txn) error {
+		txn.RawRead = false
+		r := &MultiReader{txn: txn}
+		defer func() {
+			r.mu.Lock()
+			r.txn = nil
+			r.mu.Unlock()
+		}()
+		return fn(r)
+	})
+}
+
+// do runs op on the transaction of r.
+func (r *MultiReader) do(op func(txn *Txn) error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.txn == nil {
+		return errMultiReaderDone
+	}
+	return op(r.txn)
+}
+
+// ID returns the identifier of the snapshot read by r.
+func (r *MultiReader) ID() (id uintptr, err error) {
+	err = r.do(func(txn *Txn) error {
+		id = txn.ID()
+		return nil
+	})
+	return id, err
+}
+
+// Get returns a copy of the value of key in dbi, see Txn.Get.
+func (r *MultiReader) Get(dbi DBI, key []byte) (val []byte, err error) {
+	err = r.do(func(txn *Txn) error {
+		val, err = txn.Get(dbi, key)
+		return err
+	})
+	return val, err
+}
+
+// Has reports whether dbi holds key, see Txn.Has.
+func (r *MultiReader) Has(dbi DBI, key []byte) (ok bool, err error) {
+	err = r.do(func(txn *Txn) error {
+		ok, err = txn.Has(dbi, key)
+		return err
+	})
+	return ok, err
+}
+
+// Scan returns copies of up to limit items of dbi from pos, in key order,
+// and the position following them, or nil at the end of the database, see
+// Txn.BoundedScan.  A limit less than or equal to zero returns every item
+// from pos.  Paging through a database with Scan lets goroutines process
+// one page while another is read.
+func (r *MultiReader) Scan(dbi DBI, pos *ScanPosition, limit int) (items []KV, next *ScanPosition, err error) {
+	err = r.do(func(txn *Txn) error {
+		next, err = txn.BoundedScan(dbi, pos, &ScanLimits{MaxItems: limit}, func(k, v []byte) error {
+			items = append(items, KV{Key: k, Val: v})
+			return nil
+		})
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return items, next, nil
+}
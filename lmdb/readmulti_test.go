@@ -0,0 +1,102 @@
+package lmdb
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestEnv_ReadMulti(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+
+	var dbis [2]DBI
+	err := env.Update(func(txn *Txn) (err error) {
+		for i := range dbis {
+			dbis[i], err = txn.OpenDBI(fmt.Sprintf("db%d", i), Create)
+			if err != nil {
+				return err
+			}
+			for j := 0; j < 100; j++ {
+				err = txn.Put(dbis[i], []byte(fmt.Sprintf("k%03d", j)), []byte("old"), 0)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var kept *MultiReader
+	err = env.ReadMulti(func(r *MultiReader) error {
+		kept = r
+		// a commit after the snapshot is not seen by the readers.
+		err := env.Update(func(txn *Txn) error {
+			for _, dbi := range dbis {
+				if err := txn.Put(dbi, []byte("k050"), []byte("new"), 0); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		var wg sync.WaitGroup
+		errs := make(chan error, 8)
+		for g := 0; g < 8; g++ {
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+				dbi := dbis[g%2]
+				for j := 0; j < 100; j++ {
+					v, err := r.Get(dbi, []byte(fmt.Sprintf("k%03d", j)))
+					if err != nil {
+						errs <- err
+						return
+					}
+					if string(v) != "old" {
+						errs <- fmt.Errorf("k%03d = %q", j, v)
+						return
+					}
+				}
+			}(g)
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			return err
+		}
+
+		// paging through a database.
+		var pos *ScanPosition
+		n := 0
+		for {
+			items, next, err := r.Scan(dbis[1], pos, 30)
+			if err != nil {
+				return err
+			}
+			n += len(items)
+			if next == nil {
+				break
+			}
+			pos = next
+		}
+		if n != 100 {
+			t.Errorf("scanned %d items, want 100", n)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = kept.Get(dbis[0], []byte("k000"))
+	if err != errMultiReaderDone {
+		t.Errorf("err = %v after ReadMulti returned", err)
+	}
+}
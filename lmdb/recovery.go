@@ -0,0 +1,273 @@
+package lmdb
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// IsCorruption returns true if err proves the data file of an environment
+// damaged rather than, e.g., missing, locked or short: a Corrupted,
+// PageNotFound or Invalid errno, returned by the open or met by the reads of
+// SelfTest.  Other SelfTest failures, ErrMapIO and Panic do not tell the
+// data file is damaged, and are not corruption.  OpenWithRecovery recovers
+// from the errors satisfying IsCorruption.
+func IsCorruption(err error) bool {
+	var st *SelfTestError
+	if errors.As(err, &st) {
+		err = st.Err
+	}
+	for _, errno := range []Errno{Corrupted, PageNotFound, Invalid} {
+		if IsErrno(err, errno) {
+			return true
+		}
+	}
+	return false
+}
+
+// RecoveryPolicy configures the steps taken by OpenWithRecovery when an
+// environment fails to open.  Steps left unconfigured are skipped.
+type RecoveryPolicy struct {
+	// Options are used to open the environment.  With SelfTest, damage
+	// met by the reads of the test is recovered from as well.
+	Options *Options
+
+	// PrevSnapshot tries the snapshot preceding the latest commit first,
+	// which recovers from a damaged last commit at the cost of losing it.
+	PrevSnapshot bool
+
+	// Backups are environment directories made by Env.Copy (or files, for
+	// copies made with NoSubdir), newest first.  The first one passing
+	// SelfTest is restored.
+	Backups []string
+
+	// Salvage, if not nil, is called last with the path of the damaged data
+	// file and an empty directory in which to create a new environment from
+	// whatever remains readable, e.g. with the lmdbpage package as the
+	// lmdbrepair command does.
+	Salvage func(damaged, dir string) error
+}
+
+// RecoveryStep records a step taken by OpenWithRecovery.
+type RecoveryStep struct {
+	// Action is "quarantine", "prevsnapshot", "backup" or "salvage".
+	Action string
+
+	// Path is the file written by the step for "quarantine", and the
+	// source of the restored data for "backup" and "salvage".
+	Path string
+
+	// Err is the reason the step failed, nil if it succeeded.
+	Err error
+}
+
+func (s RecoveryStep) String() string {
+	if s.Err != nil {
+		return fmt.Sprintf("%s %s: %v", s.Action, s.Path, s.Err)
+	}
+	return fmt.Sprintf("%s %s: ok", s.Action, s.Path)
+}
+
+// RecoveryReport describes what OpenWithRecovery did.
+type RecoveryReport struct {
+	// OpenErr is the error of the initial open, nil if the environment
+	// opened without recovery.
+	OpenErr error
+
+	// Steps are the steps taken, in order.
+	Steps []RecoveryStep
+
+	// Recovered is the action of the step that produced the returned
+	// environment, empty if no recovery took place.
+	Recovered string
+}
+
+// ErrUnrecovered indicates that every step of a recovery policy failed.
+// OpenWithRecovery then returns a *RecoveryError for which
+// errors.Is(err, ErrUnrecovered) is true.
+var ErrUnrecovered = errors.New("environment could not be recovered")
+
+// RecoveryError describes an environment that OpenWithRecovery failed to
+// recover.
+type RecoveryError struct {
+	Path string // path of the environment
+	Err  error  // error of the initial open
+}
+
+func (err *RecoveryError) Error() string {
+	return fmt.Sprintf("%v: %s: %v", ErrUnrecovered, err.Path, err.Err)
+}
+
+// Is allows errors.Is(err, ErrUnrecovered) to match a *RecoveryError.
+func (err *RecoveryError) Is(target error) bool {
+	return target == ErrUnrecovered
+}
+
+// Unwrap returns the error of the initial open.
+func (err *RecoveryError) Unwrap() error {
+	return err.Err
+}
+
+// OpenWithRecovery opens the environment at path like OpenEnv.  If opening
+// fails with a Corrupted, PageNotFound or Invalid errno, which prove the data
+// file damaged, the data file is first copied next to itself with a
+// ".damaged-" suffix and the steps configured in policy are tried in order
+// until one yields an environment that opens: opening the previous snapshot,
+// restoring each backup, and salvaging into a new environment.  Restoring
+// replaces the data file at path.  Errors of other kinds are returned as is,
+// including the failures of SelfTest other than those errnos, as its checks
+// can fail on data that is intact.
+//
+// The report lists every step taken and is returned even if recovery fails,
+// in which case the error is a *RecoveryError.  An environment recovered from
+// its previous snapshot remains open exclusively until its first write
+// transaction commits, which makes the snapshot permanent.
+//
+// No other process may use the environment during recovery.
+func OpenWithRecovery(path string, policy *RecoveryPolicy) (*Env, *RecoveryReport, error) {
+	if policy == nil {
+		policy = &RecoveryPolicy{}
+	}
+	var opts Options
+	if policy.Options != nil {
+		opts = *policy.Options
+	}
+	report := &RecoveryReport{}
+
+	env, err := OpenEnv(path, &opts)
+	if err == nil {
+		return env, report, nil
+	}
+	if !IsCorruption(err) {
+		return nil, report, err
+	}
+	report.OpenErr = err
+
+	data := dataFile(path, opts.Flags)
+	damaged := fmt.Sprintf("%s.damaged-%d", data, time.Now().Unix())
+	err = copyFile(damaged, data)
+	report.Steps = append(report.Steps, RecoveryStep{Action: "quarantine", Path: damaged, Err: err})
+	if err != nil {
+		return nil, report, &RecoveryError{Path: path, Err: report.OpenErr}
+	}
+
+	try := func(action, src string, restore func() error) *Env {
+		err := restore()
+		if err == nil {
+			var env *Env
+			env, err = OpenEnv(path, &opts)
+			if err == nil {
+				report.Steps = append(report.Steps, RecoveryStep{Action: action, Path: src})
+				report.Recovered = action
+				return env
+			}
+		}
+		report.Steps = append(report.Steps, RecoveryStep{Action: action, Path: src, Err: err})
+		return nil
+	}
+
+	if policy.PrevSnapshot {
+		opts.Flags |= PrevSnapshot
+		env := try("prevsnapshot", data, func() error { return nil })
+		opts.Flags &^= PrevSnapshot
+		if env != nil {
+			return env, report, nil
+		}
+	}
+	for _, backup := range policy.Backups {
+		backup := backup
+		env := try("backup", backup, func() error { return restoreBackup(data, backup, opts.MaxDBs) })
+		if env != nil {
+			return env, report, nil
+		}
+	}
+	if policy.Salvage != nil {
+		env := try("salvage", damaged, func() error { return salvageInto(data, damaged, policy.Salvage) })
+		if env != nil {
+			return env, report, nil
+		}
+	}
+	return nil, report, &RecoveryError{Path: path, Err: report.OpenErr}
+}
+
+// dataFile returns the path of the data file of the environment at path.
+func dataFile(path string, flags uint) string {
+	if flags&NoSubdir != 0 {
+		return path
+	}
+	return filepath.Join(path, "data.mdb")
+}
+
+// restoreBackup verifies the backup environment at backup, which may hold up
+// to maxDBs named databases, and copies it over the data file data.
+func restoreBackup(data, backup string, maxDBs int) error {
+	src, err := openBackup(backup, &Options{MaxDBs: maxDBs, SelfTest: true})
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	return replaceData(data, src.Copy)
+}
+
+// openBackup opens the backup environment at path, a directory or a file
+// made by Env.Copy, read-only, with opts.
+func openBackup(path string, opts *Options) (*Env, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		opts.Flags |= NoSubdir
+	}
+	opts.ReadonlyFS = true
+	return OpenEnv(path, opts)
+}
+
+// salvageInto runs salvage from damaged into a temporary directory and moves
+// the result over the data file data.
+func salvageInto(data, damaged string, salvage func(damaged, dir string) error) error {
+	return replaceData(data, func(dir string) error {
+		return salvage(damaged, dir)
+	})
+}
+
+// replaceData calls write with a temporary directory next to data in which
+// it creates an environment, and renames the data file of that environment
+// over data.
+func replaceData(data string, write func(dir string) error) error {
+	tmp, err := ioutil.TempDir(filepath.Dir(data), ".recover-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	err = write(tmp)
+	if err != nil {
+		return err
+	}
+	return os.Rename(filepath.Join(tmp, "data.mdb"), data)
+}
+
+// copyFile copies the file src to the new file dst.
+func copyFile(dst, src string) error {
+	r, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	w, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	if err == nil {
+		err = w.Sync()
+	}
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
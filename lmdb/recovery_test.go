@@ -0,0 +1,284 @@
+package lmdb
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// damage overwrites n bytes of the data file of the environment at path
+// with zeros, from offset off.
+func damage(t *testing.T, path string, off int64, n int) {
+	t.Helper()
+	f, err := os.OpenFile(filepath.Join(path, "data.mdb"), os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	_, err = f.WriteAt(make([]byte, n), off)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOpenWithRecovery_PrevSnapshot(t *testing.T) {
+	path, err := ioutil.TempDir("", "mdb_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(path)
+
+	env, err := OpenEnv(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	put := func(key string, n int) {
+		err := env.Update(func(txn *Txn) error {
+			root, err := txn.OpenRoot(0)
+			if err != nil {
+				return err
+			}
+			return txn.Put(root, []byte(key), make([]byte, n), 0)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	put("a", 16)
+	info, err := env.Info()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stat, err := env.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the overflow pages of the last commit extend the file.
+	put("b", 64<<10)
+	env.Close()
+
+	// the pages of the last commit, its root among them, are overwritten.
+	damage(t, path, (info.LastPNO+1)*int64(stat.PSize), 2*int(stat.PSize))
+	policy := &RecoveryPolicy{Options: &Options{SelfTest: true}, PrevSnapshot: true}
+	env, report, err := OpenWithRecovery(path, policy)
+	if err != nil {
+		t.Fatalf("recovery: %v (%v)", err, report.Steps)
+	}
+	if !errors.Is(report.OpenErr, ErrSelfTest) || !IsCorruption(report.OpenErr) {
+		t.Errorf("open error: %v", report.OpenErr)
+	}
+	if report.Recovered != "prevsnapshot" || len(report.Steps) != 2 || report.Steps[0].Action != "quarantine" {
+		t.Errorf("report: %+v", report)
+	}
+	if _, err := os.Stat(report.Steps[0].Path); err != nil {
+		t.Errorf("quarantined file: %v", err)
+	}
+	err = env.View(func(txn *Txn) error {
+		root, err := txn.OpenRoot(0)
+		if err != nil {
+			return err
+		}
+		_, err = txn.Get(root, []byte("a"))
+		if err != nil {
+			return err
+		}
+		_, err = txn.Get(root, []byte("b"))
+		if !IsNotFound(err) {
+			t.Errorf("item of the lost commit: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	put("c", 16)
+	env.Close()
+
+	env, err = OpenEnv(path, &Options{SelfTest: true})
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	env.Close()
+}
+
+func TestOpenWithRecovery_Backup(t *testing.T) {
+	path, err := ioutil.TempDir("", "mdb_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(path)
+	env, err := OpenEnv(path, &Options{MaxDBs: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = env.Update(func(txn *Txn) error {
+		dbi, err := txn.OpenDBI("db", Create)
+		if err != nil {
+			return err
+		}
+		return txn.Put(dbi, []byte("k"), []byte("v"), 0)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	backup, err := ioutil.TempDir("", "mdb_backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(backup)
+	err = env.Copy(backup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stat, err := env.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.Close()
+
+	// both meta pages.
+	damage(t, path, 0, 2*int(stat.PSize))
+	missing := filepath.Join(backup, "missing")
+	policy := &RecoveryPolicy{Options: &Options{MaxDBs: 4}, Backups: []string{missing, backup}}
+	renv, report, err := OpenWithRecovery(path, policy)
+	if err != nil {
+		t.Fatalf("recovery: %v (%v)", err, report.Steps)
+	}
+	defer renv.Close()
+	if report.Recovered != "backup" || len(report.Steps) != 3 {
+		t.Fatalf("report: %+v", report)
+	}
+	if s := report.Steps[1]; s.Path != missing || s.Err == nil {
+		t.Errorf("missing backup: %v", s)
+	}
+	if s := report.Steps[2]; s.Path != backup || s.Err != nil {
+		t.Errorf("restored backup: %v", s)
+	}
+	err = renv.View(func(txn *Txn) error {
+		dbi, err := txn.OpenDBI("db", 0)
+		if err != nil {
+			return err
+		}
+		v, err := txn.Get(dbi, []byte("k"))
+		if err == nil && string(v) != "v" {
+			t.Errorf("value: %q", v)
+		}
+		return err
+	})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestOpenWithRecovery_Unrecovered(t *testing.T) {
+	path, err := ioutil.TempDir("", "mdb_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(path)
+	env, err := OpenEnv(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = env.Update(func(txn *Txn) error {
+		root, err := txn.OpenRoot(0)
+		if err != nil {
+			return err
+		}
+		return txn.Put(root, []byte("k"), make([]byte, 64<<10), 0)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.Close()
+	damage(t, path, 0, 4096)
+
+	salvaged := false
+	policy := &RecoveryPolicy{Salvage: func(damaged, dir string) error {
+		salvaged = true
+		return errors.New("nothing readable")
+	}}
+	_, report, err := OpenWithRecovery(path, policy)
+	if !errors.Is(err, ErrUnrecovered) || !IsCorruption(report.OpenErr) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if !salvaged || report.Recovered != "" || len(report.Steps) != 2 || report.Steps[1].Err == nil {
+		t.Errorf("report: %+v", report)
+	}
+}
+
+func TestOpenWithRecovery_SelfTest(t *testing.T) {
+	path, err := ioutil.TempDir("", "mdb_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(path)
+	env, err := OpenEnv(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = env.Update(func(txn *Txn) error {
+		root, err := txn.OpenRoot(0)
+		if err != nil {
+			return err
+		}
+		return txn.Put(root, []byte("k"), make([]byte, 64<<10), 0)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stat, err := env.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.Close()
+	data := filepath.Join(path, "data.mdb")
+	err = os.Truncate(data, 4*int64(stat.PSize))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a failed check of SelfTest does not prove the data damaged, so the
+	// data file is left alone.
+	policy := &RecoveryPolicy{
+		Options: &Options{SelfTest: true},
+		Salvage: func(damaged, dir string) error {
+			t.Error("salvaged")
+			return nil
+		},
+	}
+	_, report, err := OpenWithRecovery(path, policy)
+	if !errors.Is(err, ErrSelfTest) || errors.Is(err, ErrUnrecovered) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if len(report.Steps) != 0 || report.OpenErr != nil {
+		t.Errorf("report: %+v", report)
+	}
+	fi, err := os.Stat(data)
+	if err != nil || fi.Size() != 4*int64(stat.PSize) {
+		t.Errorf("data file: %v %v", fi, err)
+	}
+}
+
+func TestIsCorruption(t *testing.T) {
+	for _, test := range []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{_operrno("mdb_env_open", int(Corrupted)), true},
+		{_operrno("mdb_get", int(PageNotFound)), true},
+		{_operrno("mdb_env_open", int(Invalid)), true},
+		{_operrno("mdb_txn_begin", int(Panic)), false},
+		{_operrno("mdb_env_open", int(NotFound)), false},
+		{&MapIOError{Path: "data.mdb", FileSize: 1, Required: 2}, false},
+		{&SelfTestError{Check: "walk", Err: _operrno("mdb_cursor_get", int(Corrupted))}, true},
+		{&SelfTestError{Check: "walk", Err: errors.New("keys out of order")}, false},
+	} {
+		if got := IsCorruption(test.err); got != test.want {
+			t.Errorf("IsCorruption(%v) = %v", test.err, got)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package lmdb
+
+import (
+	"bytes"
+	"errors"
+)
+
+var errRenameOverlap = errors.New("source and destination prefixes overlap")
+
+// Rename moves the value of oldKey in dbi to newKey and deletes oldKey.  If
+// dbi has the DupSort flag every value of oldKey is moved.  Rename returns
+// NotFound if oldKey does not exist and KeyExist if newKey already exists; in
+// either case dbi is unchanged.  Renaming a key to itself does nothing.
+//
+// For databases without DupSort the value is copied straight from its old
+// location into space reserved for newKey, avoiding an intermediate copy.
+func (txn *Txn) Rename(dbi DBI, oldKey, newKey []byte) error {
+	ok, err := txn.Has(dbi, oldKey)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return &OpError{Op: "mdb_get", Errno: NotFound}
+	}
+	if bytes.Equal(oldKey, newKey) {
+		return nil
+	}
+	ok, err = txn.Has(dbi, newKey)
+	if err != nil {
+		return err
+	}
+	if ok {
+		return &OpError{Op: "mdb_put", Errno: KeyExist}
+	}
+	flags, err := txn.Flags(dbi)
+	if err != nil {
+		return err
+	}
+
+	if flags&DupSort != 0 {
+		return txn.renameDups(dbi, oldKey, newKey)
+	}
+	err = txn.renameValue(dbi, oldKey, newKey)
+	if err != nil {
+		return err
+	}
+	return txn.Del(dbi, oldKey, nil)
+}
+
+// renameValue copies the single value of oldKey to newKey.
+func (txn *Txn) renameValue(dbi DBI, oldKey, newKey []byte) error {
+	if !unsafeViews {
+		v, err := txn.Get(dbi, oldKey)
+		if err != nil {
+			return err
+		}
+		return txn.Put(dbi, newKey, v, NoOverwrite)
+	}
+	n, err := txn.GetInto(dbi, oldKey, nil)
+	if err != nil && err != ErrShortBuffer {
+		return err
+	}
+	// Reserving space may split pages and move the old value, so it is read
+	// again only after the reservation has been made.
+	buf, err := txn.PutReserve(dbi, newKey, n, NoOverwrite)
+	if err != nil {
+		return err
+	}
+	_, err = txn.GetInto(dbi, oldKey, buf)
+	return err
+}
+
+// renameDups moves every value of oldKey to newKey.
+func (txn *Txn) renameDups(dbi DBI, oldKey, newKey []byte) error {
+	cur, err := txn.OpenCursor(dbi)
+	if err != nil {
+		return err
+	}
+	defer cur.Close()
+
+	// The values are copied out before writing since writes may move the
+	// pages they live on.
+	var vals [][]byte
+	_, v, err := cur.Get(oldKey, nil, Set)
+	for err == nil {
+		vals = append(vals, cloneBytes(v))
+		_, v, err = cur.Get(nil, nil, NextDup)
+	}
+	if !IsNotFound(err) {
+		return err
+	}
+	for _, v := range vals {
+		err = txn.Put(dbi, newKey, v, 0)
+		if err != nil {
+			return err
+		}
+	}
+	_, _, err = cur.Get(oldKey, nil, Set)
+	if err != nil {
+		return err
+	}
+	return cur.Del(NoDupData)
+}
+
+// MoveRange renames every key in dbi beginning with srcPrefix so that it
+// begins with dstPrefix instead, as if by Rename, and returns the number of
+// keys moved.  MoveRange returns an error if one prefix is a prefix of the
+// other (unless they are equal, which moves nothing), because moved keys
+// would land inside the source range.  If a destination key already exists
+// MoveRange stops with KeyExist and the caller should abort txn.
+func (txn *Txn) MoveRange(dbi DBI, srcPrefix, dstPrefix []byte) (int, error) {
+	if bytes.Equal(srcPrefix, dstPrefix) {
+		return 0, nil
+	}
+	if bytes.HasPrefix(srcPrefix, dstPrefix) || bytes.HasPrefix(dstPrefix, srcPrefix) {
+		return 0, errRenameOverlap
+	}
+
+	cur, err := txn.OpenCursor(dbi)
+	if err != nil {
+		return 0, err
+	}
+	defer cur.Close()
+
+	var n int
+	for {
+		// Each renamed key is deleted, so the next key to move is always the
+		// first one at or after srcPrefix.
+		k, _, err := cur.Get(srcPrefix, nil, SetRange)
+		if IsNotFound(err) {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+		if !bytes.HasPrefix(k, srcPrefix) {
+			return n, nil
+		}
+		newKey := make([]byte, 0, len(dstPrefix)+len(k)-len(srcPrefix))
+		newKey = append(newKey, dstPrefix...)
+		newKey = append(newKey, k[len(srcPrefix):]...)
+		err = txn.Rename(dbi, cloneBytes(k), newKey)
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+}
@@ -0,0 +1,148 @@
+package lmdb
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestTxn_Rename(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+
+	err := env.Update(func(txn *Txn) (err error) {
+		dbi, err := txn.OpenDBI("rename", Create)
+		if err != nil {
+			return err
+		}
+		dups, err := txn.OpenDBI("renamedup", Create|DupSort)
+		if err != nil {
+			return err
+		}
+		big := bytes.Repeat([]byte("x"), 3000)
+		err = txn.Put(dbi, []byte("old"), big, 0)
+		if err != nil {
+			return err
+		}
+		err = txn.Put(dbi, []byte("other"), []byte("o"), 0)
+		if err != nil {
+			return err
+		}
+		for _, v := range []string{"a", "b", "c"} {
+			err = txn.Put(dups, []byte("old"), []byte(v), 0)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = txn.Rename(dbi, []byte("old"), []byte("new"))
+		if err != nil {
+			return err
+		}
+		v, err := txn.Get(dbi, []byte("new"))
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(v, big) {
+			t.Errorf("renamed value differs")
+		}
+		ok, err := txn.Has(dbi, []byte("old"))
+		if err != nil {
+			return err
+		}
+		if ok {
+			t.Errorf("old key remains")
+		}
+
+		err = txn.Rename(dbi, []byte("new"), []byte("other"))
+		if !IsErrno(err, KeyExist) {
+			t.Errorf("rename onto existing key: %v", err)
+		}
+		err = txn.Rename(dbi, []byte("missing"), []byte("x"))
+		if !IsNotFound(err) {
+			t.Errorf("rename of missing key: %v", err)
+		}
+		err = txn.Rename(dbi, []byte("new"), []byte("new"))
+		if err != nil {
+			t.Errorf("rename to itself: %v", err)
+		}
+
+		err = txn.Rename(dups, []byte("old"), []byte("new"))
+		if err != nil {
+			return err
+		}
+		got, err := dumpItems(txn, dups)
+		if err != nil {
+			return err
+		}
+		if fmt.Sprint(got) != "[new=a new=b new=c]" {
+			t.Errorf("unexpected dup values: %v", got)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTxn_MoveRange(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+
+	err := env.Update(func(txn *Txn) (err error) {
+		dbi, err := txn.OpenDBI("moverange", Create)
+		if err != nil {
+			return err
+		}
+		for _, k := range []string{"a/1", "a/2", "a/3", "b/1", "c/1"} {
+			err = txn.Put(dbi, []byte(k), []byte(k), 0)
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err = txn.MoveRange(dbi, []byte("a/"), []byte("a/x/"))
+		if err != errRenameOverlap {
+			t.Errorf("overlapping prefixes: %v", err)
+		}
+		n, err := txn.MoveRange(dbi, []byte("a/"), []byte("d/"))
+		if err != nil {
+			return err
+		}
+		if n != 3 {
+			t.Errorf("moved %d keys", n)
+		}
+
+		got, err := dumpItems(txn, dbi)
+		if err != nil {
+			return err
+		}
+		if fmt.Sprint(got) != "[b/1=b/1 c/1=c/1 d/1=a/1 d/2=a/2 d/3=a/3]" {
+			t.Errorf("unexpected contents: %v", got)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// dumpItems returns the items of dbi formatted as "key=val".
+func dumpItems(txn *Txn, dbi DBI) ([]string, error) {
+	cur, err := txn.OpenCursor(dbi)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close()
+	var items []string
+	for {
+		k, v, err := cur.Get(nil, nil, Next)
+		if IsNotFound(err) {
+			return items, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, string(k)+"="+string(v))
+	}
+}
@@ -0,0 +1,356 @@
+package lmdb
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+)
+
+// DefaultSagaDB is the database holding the saga log of a SagaCoordinator
+// and the step records of its participants.
+const DefaultSagaDB = "lmdb-sagas"
+
+// ErrSagaAborted indicates that a step of a saga failed, so that the steps
+// committed before it were compensated.  SagaCoordinator.Run fails with a
+// *SagaError for which errors.Is(err, ErrSagaAborted) is true.
+var ErrSagaAborted = errors.New("saga aborted")
+
+var (
+	errSagaExists  = errors.New("saga: id already used")
+	errSagaNoSteps = errors.New("saga: no steps")
+	errSagaRecord  = errors.New("saga: bad log record")
+)
+
+// SagaError describes a saga aborted by the failure of one of its steps.
+type SagaError struct {
+	ID   string // id of the saga
+	Step string // name of the step that failed
+	Err  error  // error of the step
+
+	// CompensateErr is the error of a compensation that failed, leaving
+	// the saga to a later call to Recover, or nil if every committed step
+	// was compensated.
+	CompensateErr error
+}
+
+func (err *SagaError) Error() string {
+	msg := fmt.Sprintf("%v: %s: step %s: %v", ErrSagaAborted, err.ID, err.Step, err.Err)
+	if err.CompensateErr != nil {
+		msg += fmt.Sprintf(" (compensation failed: %v)", err.CompensateErr)
+	}
+	return msg
+}
+
+// Is allows errors.Is(err, ErrSagaAborted) to match a *SagaError.
+func (err *SagaError) Is(target error) bool {
+	return target == ErrSagaAborted
+}
+
+// SagaFunc is the operation or compensation of a saga step, run in an update
+// transaction of the environment of the step.  arg is the argument of the
+// SagaCall, persisted in the saga log so that Recover may compensate the step
+// after a restart.
+type SagaFunc func(txn *Txn, arg []byte) error
+
+// SagaCall is a step of a saga: the name of a step registered with the
+// coordinator and its argument.
+type SagaCall struct {
+	Step string
+	Arg  []byte
+}
+
+type sagaStep struct {
+	env        *Env
+	do         SagaFunc
+	compensate SagaFunc
+}
+
+// The saga log maps the id of a saga to its state followed by its calls,
+// each encoded as two chunks, the step name and the argument.
+const (
+	sagaRunning   = 'r'
+	sagaCommitted = 'c'
+)
+
+// SagaCoordinator runs logical transactions spanning several environments,
+// which LMDB cannot commit atomically, as sagas: each step commits in its own
+// environment, and if one fails the steps committed before it are undone by
+// their compensations, in reverse order.
+//
+// A saga is recorded in the log environment of the coordinator before its
+// first step, and each step writes a record in the DefaultSagaDB database of
+// its environment in the same transaction as its operation, so that after a
+// crash Recover knows exactly which steps committed and compensates them.  A
+// saga is committed once the log records it so, after its last step.
+// Compensations must therefore be able to undo their step at any later time
+// and be idempotent with respect to the state the step left.
+//
+// Every environment involved needs a free named database, see MaxDBs.
+// SagaCoordinator is safe for concurrent use.
+type SagaCoordinator struct {
+	log *Env
+
+	mu      sync.Mutex
+	steps   map[string]*sagaStep
+	running map[string]bool
+}
+
+// NewSagaCoordinator returns a coordinator keeping its saga log in env.
+func NewSagaCoordinator(env *Env) *SagaCoordinator {
+	return &SagaCoordinator{
+		log:     env,
+		steps:   make(map[string]*sagaStep),
+		running: make(map[string]bool),
+	}
+}
+
+// Register adds the step name, running do in env, undone by compensate.
+// Steps must be registered before the sagas using them are run or
+// recovered.
+func (c *SagaCoordinator) Register(name string, env *Env, do, compensate SagaFunc) {
+	c.mu.Lock()
+	c.steps[name] = &sagaStep{env: env, do: do, compensate: compensate}
+	c.mu.Unlock()
+}
+
+func (c *SagaCoordinator) step(name string) (*sagaStep, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, ok := c.steps[name]
+	if !ok {
+		return nil, fmt.Errorf("saga: unknown step %q", name)
+	}
+	return s, nil
+}
+
+// Run runs the saga id made of calls, in order, each in its own transaction.
+// If a step fails Run compensates the committed steps and returns a
+// *SagaError.  The id must not be that of a saga still in the log.
+func (c *SagaCoordinator) Run(id string, calls ...SagaCall) error {
+	if len(calls) == 0 {
+		return errSagaNoSteps
+	}
+	steps := make([]*sagaStep, len(calls))
+	for i, call := range calls {
+		s, err := c.step(call.Step)
+		if err != nil {
+			return err
+		}
+		steps[i] = s
+	}
+
+	rec := []byte{sagaRunning}
+	for _, call := range calls {
+		rec = appendChunk(rec, []byte(call.Step))
+		rec = appendChunk(rec, call.Arg)
+	}
+	// the saga is marked running before it is logged, so that a concurrent
+	// Recover does not take it for an interrupted one.
+	c.mu.Lock()
+	if c.running[id] {
+		c.mu.Unlock()
+		return errSagaExists
+	}
+	c.running[id] = true
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.running, id)
+		c.mu.Unlock()
+	}()
+	err := c.log.Update(func(txn *Txn) error {
+		dbi, err := txn.OpenDBI(DefaultSagaDB, Create)
+		if err != nil {
+			return err
+		}
+		return txn.Put(dbi, []byte(id), rec, NoOverwrite)
+	})
+	if IsErrno(err, KeyExist) {
+		return errSagaExists
+	}
+	if err != nil {
+		return err
+	}
+
+	for i, call := range calls {
+		err = steps[i].env.Update(func(txn *Txn) error {
+			err := steps[i].do(txn, call.Arg)
+			if err != nil {
+				return err
+			}
+			dbi, err := txn.OpenDBI(DefaultSagaDB, Create)
+			if err != nil {
+				return err
+			}
+			return txn.Put(dbi, sagaStepKey(id, i), nil, 0)
+		})
+		if err != nil {
+			serr := &SagaError{ID: id, Step: call.Step, Err: err}
+			serr.CompensateErr = c.compensate(id, calls[:i])
+			return serr
+		}
+	}
+
+	rec[0] = sagaCommitted
+	err = c.log.Update(func(txn *Txn) error {
+		dbi, err := txn.OpenDBI(DefaultSagaDB, 0)
+		if err != nil {
+			return err
+		}
+		return txn.Put(dbi, []byte(id), rec, 0)
+	})
+	if err != nil {
+		// the saga is left running and Recover will compensate it.
+		return err
+	}
+	return c.finish(id, calls)
+}
+
+// sagaStepKey returns the key of the record of step i of saga id in the
+// environment of the step.
+func sagaStepKey(id string, i int) []byte {
+	return []byte(id + "\x00" + strconv.Itoa(i))
+}
+
+// compensate undoes the committed calls of saga id in reverse order, then
+// removes the saga from the log.  A step is committed if its record is
+// present, and its compensation removes the record in the same transaction.
+func (c *SagaCoordinator) compensate(id string, calls []SagaCall) error {
+	for i := len(calls) - 1; i >= 0; i-- {
+		s, err := c.step(calls[i].Step)
+		if err != nil {
+			return err
+		}
+		err = s.env.Update(func(txn *Txn) error {
+			dbi, err := txn.OpenDBI(DefaultSagaDB, 0)
+			if IsNotFound(err) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			key := sagaStepKey(id, i)
+			_, err = txn.Get(dbi, key)
+			if IsNotFound(err) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			err = s.compensate(txn, calls[i].Arg)
+			if err != nil {
+				return err
+			}
+			return txn.Del(dbi, key, nil)
+		})
+		if err != nil {
+			return fmt.Errorf("step %s: %v", calls[i].Step, err)
+		}
+	}
+	return c.forget(id)
+}
+
+// finish removes the step records of the committed saga id, then the saga
+// itself from the log.
+func (c *SagaCoordinator) finish(id string, calls []SagaCall) error {
+	for i, call := range calls {
+		s, err := c.step(call.Step)
+		if err != nil {
+			return err
+		}
+		err = s.env.Update(func(txn *Txn) error {
+			dbi, err := txn.OpenDBI(DefaultSagaDB, 0)
+			if err == nil {
+				err = txn.Del(dbi, sagaStepKey(id, i), nil)
+			}
+			if IsNotFound(err) {
+				return nil
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return c.forget(id)
+}
+
+func (c *SagaCoordinator) forget(id string) error {
+	return c.log.Update(func(txn *Txn) error {
+		dbi, err := txn.OpenDBI(DefaultSagaDB, 0)
+		if err != nil {
+			return err
+		}
+		err = txn.Del(dbi, []byte(id), nil)
+		if IsNotFound(err) {
+			return nil
+		}
+		return err
+	})
+}
+
+// Recover completes the sagas of the log interrupted by a crash or by a
+// failed compensation, other than those being run by c: the steps of a saga
+// that did not commit are compensated, and the records of a committed saga
+// removed.  It returns the number of sagas recovered.  Recover is meant to be
+// called after a restart, once every step is registered, and may be called
+// again after a failure.
+func (c *SagaCoordinator) Recover() (int, error) {
+	type saga struct {
+		id        string
+		committed bool
+		calls     []SagaCall
+	}
+	var sagas []saga
+	err := c.log.View(func(txn *Txn) error {
+		dbi, err := txn.OpenDBI(DefaultSagaDB, 0)
+		if IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		_, err = txn.BoundedScan(dbi, nil, nil, func(k, v []byte) error {
+			if len(v) == 0 {
+				return errSagaRecord
+			}
+			s := saga{id: string(k), committed: v[0] == sagaCommitted}
+			r := changesetReader{data: v[1:]}
+			for len(r.data) > 0 && r.err == nil {
+				step := string(r.chunk())
+				arg := cloneBytes(r.chunk())
+				s.calls = append(s.calls, SagaCall{Step: step, Arg: arg})
+			}
+			if r.err != nil {
+				return errSagaRecord
+			}
+			sagas = append(sagas, s)
+			return nil
+		})
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, s := range sagas {
+		c.mu.Lock()
+		running := c.running[s.id]
+		c.mu.Unlock()
+		if running {
+			continue
+		}
+		if s.committed {
+			err = c.finish(s.id, s.calls)
+		} else {
+			err = c.compensate(s.id, s.calls)
+		}
+		if err != nil {
+			return n, fmt.Errorf("saga %s: %v", s.id, err)
+		}
+		n++
+	}
+	return n, nil
+}
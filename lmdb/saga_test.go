@@ -0,0 +1,190 @@
+package lmdb
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSagaCoordinator(t *testing.T) {
+	logEnv := setup(t)
+	defer clean(logEnv, t)
+	a := setup(t)
+	defer clean(a, t)
+	b := setup(t)
+	defer clean(b, t)
+
+	put := func(txn *Txn, arg []byte) error {
+		dbi, err := txn.OpenDBI("data", Create)
+		if err != nil {
+			return err
+		}
+		return txn.Put(dbi, arg, arg, 0)
+	}
+	del := func(txn *Txn, arg []byte) error {
+		dbi, err := txn.OpenDBI("data", 0)
+		if err != nil {
+			return err
+		}
+		return txn.Del(dbi, arg, nil)
+	}
+	has := func(env *Env, key string) bool {
+		var ok bool
+		err := env.View(func(txn *Txn) error {
+			dbi, err := txn.OpenDBI("data", 0)
+			if IsNotFound(err) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			_, err = txn.Get(dbi, []byte(key))
+			ok = err == nil
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ok
+	}
+
+	errStep := errors.New("step failed")
+	failCompensation := true
+	c := NewSagaCoordinator(logEnv)
+	c.Register("a", a, put, del)
+	c.Register("b", b, put, del)
+	c.Register("fail", b, func(txn *Txn, arg []byte) error { return errStep }, nil)
+	c.Register("flaky", b, put, func(txn *Txn, arg []byte) error {
+		if failCompensation {
+			return errStep
+		}
+		return del(txn, arg)
+	})
+
+	err := c.Run("s1", SagaCall{"a", []byte("x")}, SagaCall{"b", []byte("x")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !has(a, "x") || !has(b, "x") {
+		t.Error("committed saga not applied")
+	}
+
+	err = c.Run("s2", SagaCall{"a", []byte("y")}, SagaCall{"b", []byte("y")}, SagaCall{"fail", nil})
+	var serr *SagaError
+	if !errors.Is(err, ErrSagaAborted) || !errors.As(err, &serr) || serr.Step != "fail" || serr.Err != errStep {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if serr.CompensateErr != nil || has(a, "y") || has(b, "y") {
+		t.Errorf("aborted saga not compensated: %v", serr.CompensateErr)
+	}
+
+	err = c.Run("s3", SagaCall{"a", []byte("z")}, SagaCall{"flaky", []byte("z")}, SagaCall{"fail", nil})
+	if !errors.As(err, &serr) || serr.CompensateErr == nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !has(a, "z") || !has(b, "z") {
+		t.Error("steps compensated despite a failed compensation")
+	}
+	failCompensation = false
+	n, err := c.Recover()
+	if err != nil || n != 1 {
+		t.Fatalf("recovered %d sagas: %v", n, err)
+	}
+	if has(a, "z") || has(b, "z") {
+		t.Error("recovered saga not compensated")
+	}
+	if n, err = c.Recover(); err != nil || n != 0 {
+		t.Errorf("recovered %d sagas again: %v", n, err)
+	}
+
+	// the ids of finished sagas may be reused.
+	if err = c.Run("s1", SagaCall{"a", []byte("w")}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSagaCoordinator_Recover_running(t *testing.T) {
+	logEnv := setup(t)
+	defer clean(logEnv, t)
+	a := setup(t)
+	defer clean(a, t)
+
+	var compensated bool
+	c := NewSagaCoordinator(logEnv)
+	c.Register("a", a, func(txn *Txn, arg []byte) error { return nil }, func(txn *Txn, arg []byte) error {
+		compensated = true
+		return nil
+	})
+
+	// a full subscription to the log holds Run just after the saga is
+	// logged, until it is read.
+	sub, err := logEnv.Subscribe(&SubscribeOptions{Buffer: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+	err = logEnv.Update(func(txn *Txn) error {
+		dbi, err := txn.OpenDBI("other", Create)
+		if err != nil {
+			return err
+		}
+		return txn.Put(dbi, []byte("k"), nil, 0)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ran := make(chan error)
+	go func() {
+		ran <- c.Run("s", SagaCall{"a", nil})
+	}()
+	for {
+		var logged bool
+		err = logEnv.View(func(txn *Txn) error {
+			dbi, err := txn.OpenDBI(DefaultSagaDB, 0)
+			if err == nil {
+				_, err = txn.Get(dbi, []byte("s"))
+			}
+			logged = err == nil
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if logged {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	recovered := make(chan error, 1)
+	go func() {
+		n, err := c.Recover()
+		if err == nil && n != 0 {
+			t.Errorf("recovered %d sagas", n)
+		}
+		recovered <- err
+	}()
+	select {
+	case err = <-recovered:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(time.Second):
+		t.Error("Recover took over the running saga")
+	}
+
+	go func() {
+		for {
+			if _, err := sub.Next(context.Background()); err != nil {
+				return
+			}
+		}
+	}()
+	if err = <-ran; err != nil {
+		t.Fatal(err)
+	}
+	if compensated {
+		t.Error("running saga compensated")
+	}
+}
@@ -0,0 +1,180 @@
+package lmdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrSandboxLimit indicates that a callback run by ViewSandboxed exceeded
+// one of its limits.  Such callbacks fail with a *SandboxLimitError for which
+// errors.Is(err, ErrSandboxLimit) is true.
+var ErrSandboxLimit = errors.New("sandbox limit exceeded")
+
+var errSandboxDone = errors.New("SandboxTxn used after ViewSandboxed returned")
+
+// SandboxLimitError describes the limit exceeded by a sandboxed callback.
+type SandboxLimitError struct {
+	Limit string // "entries", "bytes", "deadline" or "context"
+	Max   int64  // the limit, for entries and bytes
+	Err   error  // the error of the context, for context
+}
+
+func (err *SandboxLimitError) Error() string {
+	switch err.Limit {
+	case "deadline":
+		return fmt.Sprintf("%v: deadline", ErrSandboxLimit)
+	case "context":
+		return fmt.Sprintf("%v: context: %v", ErrSandboxLimit, err.Err)
+	}
+	return fmt.Sprintf("%v: more than %d %s", ErrSandboxLimit, err.Max, err.Limit)
+}
+
+// Is allows errors.Is(err, ErrSandboxLimit) to match a *SandboxLimitError.
+func (err *SandboxLimitError) Is(target error) bool {
+	return target == ErrSandboxLimit
+}
+
+// SandboxLimits bound the reads of a callback run by ViewSandboxed.  The
+// zero value does not bound them.
+type SandboxLimits struct {
+	// MaxEntries is the number of items the callback may read, if positive.
+	MaxEntries int64
+
+	// MaxBytes is the size of the keys and values the callback may read,
+	// if positive.
+	MaxBytes int64
+
+	// Deadline ends the reads of the callback when reached, if not zero.
+	Deadline time.Time
+
+	// Context ends the reads of the callback when done, if not nil.
+	Context context.Context
+}
+
+// SandboxTxn is the restricted read transaction given to a callback by
+// ViewSandboxed.  It exposes no Txn, so the callback can neither write nor
+// read without being accounted for.  The keys and values it returns are
+// copies owned by the callback.
+type SandboxTxn struct {
+	txn     *Txn // nil once ViewSandboxed returned
+	lim     SandboxLimits
+	entries int64
+	bytes   int64
+	err     *SandboxLimitError
+}
+
+// ViewSandboxed runs fn, typically supplied by a plugin or another untrusted
+// extension, in a read transaction whose reads are bounded by limits, so
+// that fn cannot monopolize a read slot.  Once fn exceeds a limit the read
+// failing and every later one return a *SandboxLimitError (see
+// ErrSandboxLimit), which ViewSandboxed returns whatever fn returns.  A nil
+// limits does not bound fn.
+//
+// Limits are enforced when fn reads.  A callback that blocks or computes
+// without reading keeps its slot, so fn should also be bounded by the code
+// that runs it, e.g. by leaving out plugins that miss deadlines.
+func (env *Env) ViewSandboxed(limits *SandboxLimits, fn func(s *SandboxTxn) error) error {
+	s := &SandboxTxn{}
+	if limits != nil {
+		s.lim = *limits
+	}
+	if err := s.check(0, 0); err != nil {
+		return err
+	}
+	err := env.View(func(txn *Txn) error {
+		txn.RawRead = false
+		s.txn = txn
+		defer func() { s.txn = nil }()
+		return fn(s)
+	})
+	if s.err != nil {
+		return s.err
+	}
+	return err
+}
+
+// Usage returns the number of items and the size of the keys and values
+// read by s.
+func (s *SandboxTxn) Usage() (entries, bytes int64) {
+	return s.entries, s.bytes
+}
+
+// check accounts for reading entries items of size bytes and returns the
+// limit exceeded, if any.
+func (s *SandboxTxn) check(entries, bytes int64) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.entries += entries
+	s.bytes += bytes
+	switch {
+	case s.lim.MaxEntries > 0 && s.entries > s.lim.MaxEntries:
+		s.err = &SandboxLimitError{Limit: "entries", Max: s.lim.MaxEntries}
+	case s.lim.MaxBytes > 0 && s.bytes > s.lim.MaxBytes:
+		s.err = &SandboxLimitError{Limit: "bytes", Max: s.lim.MaxBytes}
+	case !s.lim.Deadline.IsZero() && !time.Now().Before(s.lim.Deadline):
+		s.err = &SandboxLimitError{Limit: "deadline"}
+	case s.lim.Context != nil && s.lim.Context.Err() != nil:
+		s.err = &SandboxLimitError{Limit: "context", Err: s.lim.Context.Err()}
+	}
+	if s.err != nil {
+		return s.err
+	}
+	return nil
+}
+
+func (s *SandboxTxn) live() error {
+	if s.txn == nil {
+		return errSandboxDone
+	}
+	return s.check(0, 0)
+}
+
+// Get returns a copy of the value of key in dbi, see Txn.Get.
+func (s *SandboxTxn) Get(dbi DBI, key []byte) ([]byte, error) {
+	if err := s.live(); err != nil {
+		return nil, err
+	}
+	v, err := s.txn.Get(dbi, key)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.check(1, int64(len(key)+len(v))); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// Scan calls fn with copies of the items of dbi from pos, in key order,
+// until the end of the database, an error returned by fn, or a limit, and
+// returns the position following the last item passed to fn, see
+// Txn.BoundedScan.  Reaching a limit is an error here, but the position
+// returned still allows a scan to be resumed under other limits.
+func (s *SandboxTxn) Scan(dbi DBI, pos *ScanPosition, fn func(k, v []byte) error) (*ScanPosition, error) {
+	if err := s.live(); err != nil {
+		return pos, err
+	}
+	var last KV
+	visited := false
+	next, err := s.txn.BoundedScan(dbi, pos, nil, func(k, v []byte) error {
+		if err := s.check(1, int64(len(k)+len(v))); err != nil {
+			return err
+		}
+		last, visited = KV{Key: k, Val: v}, true
+		return fn(k, v)
+	})
+	if err == nil {
+		return next, nil
+	}
+	if !visited {
+		return pos, err
+	}
+	next = &ScanPosition{Key: last.Key, After: true}
+	flags, ferr := s.txn.Flags(dbi)
+	if ferr == nil && flags&DupSort != 0 {
+		next.Val = last.Val
+	}
+	return next, err
+}
@@ -0,0 +1,107 @@
+package lmdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestEnv_ViewSandboxed(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+
+	var dbi DBI
+	err := env.Update(func(txn *Txn) (err error) {
+		dbi, err = txn.OpenRoot(0)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 50; i++ {
+			err = txn.Put(dbi, []byte(fmt.Sprintf("k%02d", i)), []byte("0123456789"), 0)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a plugin swallowing the limit error still fails.
+	var pos *ScanPosition
+	n := 0
+	err = env.ViewSandboxed(&SandboxLimits{MaxEntries: 20}, func(s *SandboxTxn) error {
+		pos, _ = s.Scan(dbi, nil, func(k, v []byte) error {
+			n++
+			return nil
+		})
+		return nil
+	})
+	var lerr *SandboxLimitError
+	if !errors.As(err, &lerr) || lerr.Limit != "entries" || !errors.Is(err, ErrSandboxLimit) {
+		t.Fatalf("err = %v", err)
+	}
+	if n != 20 || pos == nil || string(pos.Key) != "k19" {
+		t.Errorf("scanned %d items, position %+v", n, pos)
+	}
+
+	// resumed under new limits.
+	err = env.ViewSandboxed(nil, func(s *SandboxTxn) error {
+		pos, err = s.Scan(dbi, pos, func(k, v []byte) error {
+			n++
+			return nil
+		})
+		return err
+	})
+	if err != nil || n != 50 || pos != nil {
+		t.Errorf("resumed scan: %v, %d items, position %+v", err, n, pos)
+	}
+
+	// 13 bytes per item.
+	var kept *SandboxTxn
+	err = env.ViewSandboxed(&SandboxLimits{MaxBytes: 30}, func(s *SandboxTxn) error {
+		kept = s
+		for i := 0; i < 3; i++ {
+			if _, err := s.Get(dbi, []byte(fmt.Sprintf("k%02d", i))); err != nil {
+				if i != 2 {
+					t.Errorf("Get %d: %v", i, err)
+				}
+				return err
+			}
+		}
+		return nil
+	})
+	if !errors.As(err, &lerr) || lerr.Limit != "bytes" {
+		t.Errorf("err = %v", err)
+	}
+	if entries, bytes := kept.Usage(); entries != 3 || bytes != 39 {
+		t.Errorf("usage %d entries, %d bytes", entries, bytes)
+	}
+	if _, err := kept.Get(dbi, []byte("k00")); err == nil {
+		t.Error("Get after ViewSandboxed returned succeeded")
+	}
+
+	err = env.ViewSandboxed(&SandboxLimits{Deadline: time.Now().Add(-time.Second)}, func(s *SandboxTxn) error {
+		t.Error("callback run after the deadline")
+		return nil
+	})
+	if !errors.As(err, &lerr) || lerr.Limit != "deadline" {
+		t.Errorf("err = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	err = env.ViewSandboxed(&SandboxLimits{Context: ctx}, func(s *SandboxTxn) error {
+		if _, err := s.Get(dbi, []byte("k00")); err != nil {
+			return err
+		}
+		cancel()
+		_, err := s.Get(dbi, []byte("k01"))
+		return err
+	})
+	if !errors.As(err, &lerr) || lerr.Limit != "context" || lerr.Err != context.Canceled {
+		t.Errorf("err = %v", err)
+	}
+}
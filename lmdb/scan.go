@@ -0,0 +1,234 @@
+package lmdb
+
+import (
+	"time"
+)
+
+// KV is a key-value pair returned by the range helpers on Txn.  If the Txn
+// that produced a KV has RawRead set, Key and Val reference readonly memory
+// that must not be accessed after the transaction has terminated.
+type KV struct {
+	Key []byte
+	Val []byte
+}
+
+// ScanReverse returns up to limit items from dbi in descending key order,
+// starting at the largest key less than or equal to fromKey.  If fromKey is
+// empty the scan starts at the last key in the database.  A limit less than
+// or equal to zero returns all items at or before fromKey.
+//
+// In a DupSort database all values of fromKey are returned, largest value
+// first, before moving on to smaller keys.
+//
+// The positioning is done with SetRange followed by Prev, which is the
+// correct way to seek backwards with an LMDB cursor.  When fromKey is greater
+// than every key in dbi SetRange reports NotFound and the scan begins at the
+// Last item instead.
+func (txn *Txn) ScanReverse(dbi DBI, fromKey []byte, limit int) ([]KV, error) {
+	flags, err := txn.Flags(dbi)
+	if err != nil {
+		return nil, err
+	}
+	dupsort := flags&DupSort != 0
+
+	cur, err := txn.OpenCursor(dbi)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close()
+
+	k, v, err := cur.seekReverse(fromKey, dupsort)
+	var items []KV
+	for err == nil {
+		items = append(items, KV{Key: k, Val: v})
+		if limit > 0 && len(items) >= limit {
+			return items, nil
+		}
+		k, v, err = cur.Get(nil, nil, Prev)
+	}
+	if IsNotFound(err) {
+		return items, nil
+	}
+	return nil, err
+}
+
+// seekReverse positions c on the last item whose key is less than or equal to
+// fromKey and returns it.
+func (c *Cursor) seekReverse(fromKey []byte, dupsort bool) (k, v []byte, err error) {
+	if len(fromKey) == 0 {
+		return c.Get(nil, nil, Last)
+	}
+	k, v, err = c.Get(fromKey, nil, SetRange)
+	if IsNotFound(err) {
+		// fromKey is past the end of the database.
+		return c.Get(nil, nil, Last)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if string(k) != string(fromKey) {
+		// SetRange landed on the first key greater than fromKey.
+		return c.Get(nil, nil, Prev)
+	}
+	if dupsort {
+		// MDB_LAST_DUP only reports the value, the key is unchanged.
+		_, v, err = c.Get(nil, nil, LastDup)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return k, v, nil
+}
+
+// First returns the item with the smallest key in dbi.  In a DupSort
+// database the smallest value of that key is returned.  First returns an
+// error satisfying IsNotFound if dbi is empty.
+func (txn *Txn) First(dbi DBI) (key, val []byte, err error) {
+	return txn.getBoundary(dbi, nil, First)
+}
+
+// Last returns the item with the largest key in dbi.  In a DupSort database
+// the largest value of that key is returned.  Last returns an error
+// satisfying IsNotFound if dbi is empty.
+func (txn *Txn) Last(dbi DBI) (key, val []byte, err error) {
+	return txn.getBoundary(dbi, nil, Last)
+}
+
+// FirstDup returns the smallest value stored under key in the DupSort
+// database dbi.  An empty key, which LMDB cannot store, is reported as by
+// Txn.Get, with an error satisfying IsErrno(err, BadValSize).
+func (txn *Txn) FirstDup(dbi DBI, key []byte) ([]byte, error) {
+	_, val, err := txn.getBoundary(dbi, key, FirstDup)
+	return val, err
+}
+
+// LastDup returns the largest value stored under key in the DupSort database
+// dbi.  An empty key is reported as by FirstDup.
+func (txn *Txn) LastDup(dbi DBI, key []byte) ([]byte, error) {
+	_, val, err := txn.getBoundary(dbi, key, LastDup)
+	return val, err
+}
+
+// getBoundary opens a short-lived cursor in dbi and performs op.  For
+// FirstDup and LastDup the cursor is first positioned at key.
+func (txn *Txn) getBoundary(dbi DBI, key []byte, op uint) ([]byte, []byte, error) {
+	dup := op == FirstDup || op == LastDup
+	if dup && len(key) == 0 {
+		// Cursor.Get would not position the cursor at an empty key.
+		return nil, nil, &OpError{Op: "mdb_cursor_get", Errno: BadValSize}
+	}
+	cur, err := txn.OpenCursor(dbi)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer cur.Close()
+
+	if dup {
+		_, _, err = cur.Get(key, nil, Set)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	k, v, err := cur.Get(nil, nil, op)
+	if err != nil {
+		return nil, nil, err
+	}
+	if dup {
+		// FirstDup and LastDup do not report the key.
+		k = key
+	}
+	return k, v, nil
+}
+
+// NonSnapshotScan calls fn for every item in dbi with a key greater than or
+// equal to start, in key order, like a cursor scan in a View transaction.
+// Unlike such a scan, NonSnapshotScan terminates its read transaction every
+// interval and resumes after the last item visited in a new transaction.
+//
+// NOT A CONSISTENT SNAPSHOT.  Writes committed during the scan may or may not
+// be observed, and items may be observed in a state that never existed as a
+// whole (e.g. an index entry without its record).  In exchange a long scan
+// no longer pins old pages, which would prevent LMDB from reusing them and
+// cause the database file to grow while the scan runs.
+//
+// The key and value passed to fn are only valid until fn returns.  If fn
+// returns an error the scan stops and the error is returned.  An interval
+// less than or equal to zero uses one transaction per item.
+func (env *Env) NonSnapshotScan(dbi DBI, start []byte, interval time.Duration, fn func(k, v []byte) error) error {
+	var lastKey, lastVal []byte
+	resume := false
+	for {
+		done := true
+		err := env.View(func(txn *Txn) (err error) {
+			txn.RawRead = true
+			flags, err := txn.Flags(dbi)
+			if err != nil {
+				return err
+			}
+			cur, err := txn.OpenCursor(dbi)
+			if err != nil {
+				return err
+			}
+			defer cur.Close()
+
+			var k, v []byte
+			if resume {
+				k, v, err = cur.seekAfter(lastKey, lastVal, flags&DupSort != 0)
+			} else if len(start) == 0 {
+				k, v, err = cur.Get(nil, nil, First)
+			} else {
+				k, v, err = cur.Get(start, nil, SetRange)
+			}
+			deadline := time.Now().Add(interval)
+			for err == nil {
+				err = fn(k, v)
+				if err != nil {
+					return err
+				}
+				if !time.Now().Before(deadline) {
+					lastKey = append(lastKey[:0], k...)
+					lastVal = append(lastVal[:0], v...)
+					resume = true
+					done = false
+					return nil
+				}
+				k, v, err = cur.Get(nil, nil, Next)
+			}
+			if IsNotFound(err) {
+				return nil
+			}
+			return err
+		})
+		if err != nil || done {
+			return err
+		}
+	}
+}
+
+// seekAfter positions c on the first item following the item (key, val) and
+// returns it.  The item itself need not exist any more.
+func (c *Cursor) seekAfter(key, val []byte, dupsort bool) (k, v []byte, err error) {
+	if dupsort {
+		_, v, err = c.Get(key, val, GetBothRange)
+		if err == nil {
+			if string(v) == string(val) {
+				return c.Get(nil, nil, Next)
+			}
+			return c.Get(nil, nil, GetCurrent)
+		}
+		if !IsNotFound(err) {
+			return nil, nil, err
+		}
+		// key is gone or has no values after val.
+		k, v, err = c.Get(key, nil, SetRange)
+		if err == nil && string(k) == string(key) {
+			return c.Get(nil, nil, NextNoDup)
+		}
+		return k, v, err
+	}
+	k, v, err = c.Get(key, nil, SetRange)
+	if err == nil && string(k) == string(key) {
+		return c.Get(nil, nil, Next)
+	}
+	return k, v, err
+}
@@ -0,0 +1,250 @@
+package lmdb
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestTxn_ScanReverse(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+
+	var dbi DBI
+	err := env.Update(func(txn *Txn) (err error) {
+		dbi, err = txn.OpenDBI("reverse", Create)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 10; i += 2 {
+			err = txn.Put(dbi, []byte(fmt.Sprintf("k%d", i)), []byte(fmt.Sprintf("v%d", i)), 0)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		from   string
+		limit  int
+		expect []string
+	}{
+		{"", 0, []string{"k8", "k6", "k4", "k2", "k0"}},
+		{"", 2, []string{"k8", "k6"}},
+		{"k4", 0, []string{"k4", "k2", "k0"}},
+		{"k5", 2, []string{"k4", "k2"}},
+		{"k9", 1, []string{"k8"}},
+		{"zzz", 3, []string{"k8", "k6", "k4"}},
+		{"a", 0, nil},
+		{"k0", 5, []string{"k0"}},
+	} {
+		err = env.View(func(txn *Txn) (err error) {
+			items, err := txn.ScanReverse(dbi, []byte(test.from), test.limit)
+			if err != nil {
+				return err
+			}
+			var keys []string
+			for _, item := range items {
+				keys = append(keys, string(item.Key))
+				if "v"+string(item.Key[1:]) != string(item.Val) {
+					t.Errorf("from %q: unexpected value %q for key %q", test.from, item.Val, item.Key)
+				}
+			}
+			if fmt.Sprint(keys) != fmt.Sprint(test.expect) {
+				t.Errorf("from %q limit %d: unexpected keys %q (!= %q)", test.from, test.limit, keys, test.expect)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestTxn_ScanReverse_dupSort(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+
+	var dbi DBI
+	err := env.Update(func(txn *Txn) (err error) {
+		dbi, err = txn.OpenDBI("reversedup", Create|DupSort)
+		if err != nil {
+			return err
+		}
+		for _, kv := range [][2]string{{"a", "1"}, {"a", "2"}, {"b", "1"}, {"b", "2"}, {"b", "3"}, {"c", "1"}} {
+			err = txn.Put(dbi, []byte(kv[0]), []byte(kv[1]), 0)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = env.View(func(txn *Txn) (err error) {
+		items, err := txn.ScanReverse(dbi, []byte("b"), 0)
+		if err != nil {
+			return err
+		}
+		var got []string
+		for _, item := range items {
+			got = append(got, string(item.Key)+string(item.Val))
+		}
+		expect := []string{"b3", "b2", "b1", "a2", "a1"}
+		if fmt.Sprint(got) != fmt.Sprint(expect) {
+			t.Errorf("unexpected items %q (!= %q)", got, expect)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTxn_First_Last(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+
+	var dbi DBI
+	err := env.Update(func(txn *Txn) (err error) {
+		dbi, err = txn.OpenDBI("boundary", Create|DupSort)
+		if err != nil {
+			return err
+		}
+		_, _, err = txn.First(dbi)
+		if !IsNotFound(err) {
+			t.Errorf("unexpected error on empty database: %v", err)
+		}
+		for _, kv := range [][2]string{{"b", "2"}, {"a", "2"}, {"a", "1"}, {"c", "3"}, {"c", "1"}} {
+			err = txn.Put(dbi, []byte(kv[0]), []byte(kv[1]), 0)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = env.View(func(txn *Txn) (err error) {
+		k, v, err := txn.First(dbi)
+		if err != nil {
+			return err
+		}
+		if string(k) != "a" || string(v) != "1" {
+			t.Errorf("unexpected first item: %q=%q", k, v)
+		}
+		k, v, err = txn.Last(dbi)
+		if err != nil {
+			return err
+		}
+		if string(k) != "c" || string(v) != "3" {
+			t.Errorf("unexpected last item: %q=%q", k, v)
+		}
+		v, err = txn.FirstDup(dbi, []byte("c"))
+		if err != nil {
+			return err
+		}
+		if string(v) != "1" {
+			t.Errorf("unexpected first dup: %q", v)
+		}
+		v, err = txn.LastDup(dbi, []byte("a"))
+		if err != nil {
+			return err
+		}
+		if string(v) != "2" {
+			t.Errorf("unexpected last dup: %q", v)
+		}
+		_, err = txn.LastDup(dbi, []byte("z"))
+		if !IsNotFound(err) {
+			t.Errorf("unexpected error for missing key: %v", err)
+		}
+		_, err = txn.FirstDup(dbi, nil)
+		if !IsErrno(err, BadValSize) {
+			t.Errorf("unexpected error for empty key: %v", err)
+		}
+		_, err = txn.LastDup(dbi, []byte{})
+		if !IsErrno(err, BadValSize) {
+			t.Errorf("unexpected error for empty key: %v", err)
+		}
+		_, err = txn.Get(dbi, nil)
+		if !IsErrno(err, BadValSize) {
+			t.Errorf("unexpected error for empty key in Get: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestEnv_NonSnapshotScan(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+
+	var dbi, dupdbi DBI
+	err := env.Update(func(txn *Txn) (err error) {
+		dbi, err = txn.OpenDBI("nonsnapshot", Create)
+		if err != nil {
+			return err
+		}
+		dupdbi, err = txn.OpenDBI("nonsnapshotdup", Create|DupSort)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 20; i++ {
+			k := []byte(fmt.Sprintf("k%02d", i))
+			err = txn.Put(dbi, k, []byte("v"), 0)
+			if err != nil {
+				return err
+			}
+			for j := 0; j < 3; j++ {
+				err = txn.Put(dupdbi, k, []byte(fmt.Sprintf("v%d", j)), 0)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// an interval of zero renews the transaction after every item
+	var keys []string
+	err = env.NonSnapshotScan(dbi, []byte("k05"), 0, func(k, v []byte) error {
+		keys = append(keys, string(k))
+		if len(keys) == 3 {
+			// items written during the scan may be observed.
+			return env.Update(func(txn *Txn) error {
+				return txn.Put(dbi, []byte("k10a"), []byte("v"), 0)
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 16 || keys[0] != "k05" || keys[6] != "k10a" || keys[15] != "k19" {
+		t.Errorf("unexpected keys: %q", keys)
+	}
+
+	var items []string
+	err = env.NonSnapshotScan(dupdbi, nil, 0, func(k, v []byte) error {
+		items = append(items, string(k)+string(v))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 60 || items[0] != "k00v0" || items[4] != "k01v1" || items[59] != "k19v2" {
+		t.Errorf("unexpected items: %q", items)
+	}
+}
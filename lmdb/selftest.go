@@ -0,0 +1,162 @@
+package lmdb
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrSelfTest indicates that an environment failed SelfTest.  Errors
+// returned by SelfTest, and by OpenEnv with Options.SelfTest, are
+// *SelfTestError values for which errors.Is(err, ErrSelfTest) is true.
+var ErrSelfTest = errors.New("self test failed")
+
+// maxTreeDepth bounds the depth of a sane B-tree.  Even with the smallest
+// pages and largest keys a tree of this depth holds more items than fit in
+// any map.
+const maxTreeDepth = 32
+
+// SelfTestError describes the first check failed by SelfTest.
+type SelfTestError struct {
+	Path  string // path of the environment
+	DB    string // database being checked, "" for the main database
+	Check string // short name of the failed check
+	Err   error  // the problem found
+}
+
+func (err *SelfTestError) Error() string {
+	return fmt.Sprintf("%v: %s: db %q: %s: %v", ErrSelfTest, err.Path, err.DB, err.Check, err.Err)
+}
+
+// Is allows errors.Is(err, ErrSelfTest) to match a *SelfTestError.
+func (err *SelfTestError) Is(target error) bool {
+	return target == ErrSelfTest
+}
+
+// Unwrap returns the problem found, e.g. a *MapIOError or an Errno.
+func (err *SelfTestError) Unwrap() error {
+	return err.Err
+}
+
+// SelfTest checks that env can be served from before the application
+// relies on it.  It validates the figures of the meta page against each
+// other and against the data file (see CheckMapExtent), then begins a read
+// transaction and reads the first and last item of the main database and of
+// every named database.  Damage in the pages on those paths, the ones
+// touched first by most applications, fails the test.  A failing test
+// returns a *SelfTestError (see ErrSelfTest).
+//
+// Finding the named databases requires scanning the keys of the main
+// database, so SelfTest is slow if the main database holds many items
+// itself.  SelfTest is not a full consistency check: pages not on the paths
+// to the first and last items are not read.
+func (env *Env) SelfTest() error {
+	path, err := env.Path()
+	if err != nil {
+		return err
+	}
+	fail := func(db, check string, err error) error {
+		return &SelfTestError{Path: path, DB: db, Check: check, Err: err}
+	}
+
+	info, err := env.Info()
+	if err != nil {
+		return fail("", "meta", err)
+	}
+	stat, err := env.Stat()
+	if err != nil {
+		return fail("", "meta", err)
+	}
+	psize := int64(stat.PSize)
+	if psize < 512 || psize > maxPageSize || psize&(psize-1) != 0 {
+		return fail("", "meta", fmt.Errorf("invalid page size %d", psize))
+	}
+	used := (info.LastPNO + 1) * psize
+	if used > info.MapSize {
+		return fail("", "meta", fmt.Errorf("%d bytes in use exceed the map size %d", used, info.MapSize))
+	}
+	err = env.CheckMapExtent()
+	if err != nil {
+		return fail("", "extent", err)
+	}
+
+	err = env.View(func(txn *Txn) (err error) {
+		txn.RawRead = true
+		root, err := txn.OpenRoot(0)
+		if err != nil {
+			return fail("", "open", err)
+		}
+		err = txn.selfTestDB(root, "", info, fail)
+		if err != nil {
+			return err
+		}
+
+		cur, err := txn.OpenCursor(root)
+		if err != nil {
+			return fail("", "open", err)
+		}
+		defer cur.Close()
+		for {
+			k, v, err := cur.Get(nil, nil, Next)
+			if IsNotFound(err) {
+				return nil
+			}
+			if err != nil {
+				return fail("", "scan", err)
+			}
+			isDB, err := txn.isDBName(k, v)
+			if err != nil {
+				return fail(string(k), "open", err)
+			}
+			if !isDB {
+				continue
+			}
+			dbi, err := txn.OpenDBI(string(k), 0)
+			if err != nil {
+				return fail(string(k), "open", err)
+			}
+			err = txn.selfTestDB(dbi, string(k), info, fail)
+			if err != nil {
+				return err
+			}
+		}
+	})
+	if err != nil && !errors.Is(err, ErrSelfTest) {
+		return fail("", "txn", err)
+	}
+	return err
+}
+
+// selfTestDB checks the statistics of dbi and reads its first and last
+// items.
+func (txn *Txn) selfTestDB(dbi DBI, name string, info *EnvInfo, fail func(db, check string, err error) error) error {
+	stat, err := txn.Stat(dbi)
+	if err != nil {
+		return fail(name, "stat", err)
+	}
+	if stat.Depth > maxTreeDepth {
+		return fail(name, "stat", fmt.Errorf("tree depth %d", stat.Depth))
+	}
+	pages := stat.BranchPages + stat.LeafPages + stat.OverflowPages
+	if pages > uint64(info.LastPNO+1) {
+		return fail(name, "stat", fmt.Errorf("%d pages but last page number is %d", pages, info.LastPNO))
+	}
+
+	cur, err := txn.OpenCursor(dbi)
+	if err != nil {
+		return fail(name, "open", err)
+	}
+	defer cur.Close()
+	for _, op := range []uint{First, Last} {
+		_, _, err = cur.Get(nil, nil, op)
+		if IsNotFound(err) {
+			if stat.Entries != 0 {
+				return fail(name, "read", fmt.Errorf("no items found but %d recorded", stat.Entries))
+			}
+			return nil
+		}
+		if err != nil {
+			return fail(name, "read", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package lmdb
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEnv_SelfTest(t *testing.T) {
+	path, err := ioutil.TempDir("", "mdb_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(path)
+
+	env, err := OpenEnv(path, &Options{MaxDBs: 4, SelfTest: true})
+	if err != nil {
+		t.Fatalf("empty environment: %v", err)
+	}
+	err = env.Update(func(txn *Txn) (err error) {
+		for _, name := range []string{"a", "b", "empty"} {
+			dbi, err := txn.OpenDBI(name, Create)
+			if err != nil {
+				return err
+			}
+			if name == "empty" {
+				continue
+			}
+			for i := 0; i < 64; i++ {
+				err = txn.Put(dbi, []byte{byte(i)}, make([]byte, 256), 0)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		root, err := txn.OpenRoot(0)
+		if err != nil {
+			return err
+		}
+		return txn.Put(root, []byte("plain"), []byte("value"), 0)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = env.SelfTest()
+	if err != nil {
+		t.Fatalf("intact environment: %v", err)
+	}
+	stat, err := env.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.Close()
+
+	env, err = OpenEnv(path, &Options{MaxDBs: 4, SelfTest: true})
+	if err != nil {
+		t.Fatalf("reopened environment: %v", err)
+	}
+	env.Close()
+
+	err = os.Truncate(filepath.Join(path, "data.mdb"), 2*int64(stat.PSize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = OpenEnv(path, &Options{MaxDBs: 4, SelfTest: true})
+	if !errors.Is(err, ErrSelfTest) || !errors.Is(err, ErrMapIO) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var stErr *SelfTestError
+	if !errors.As(err, &stErr) || stErr.Check != "extent" || stErr.Path != path {
+		t.Errorf("unexpected error: %#v", err)
+	}
+}
+
+func TestEnv_SelfTest_noMaxDBs(t *testing.T) {
+	path, err := ioutil.TempDir("", "mdb_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(path)
+	env, err := OpenEnv(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer env.Close()
+	err = env.Update(func(txn *Txn) error {
+		root, err := txn.OpenRoot(0)
+		if err != nil {
+			return err
+		}
+		return txn.Put(root, []byte("k"), make([]byte, DBRecordSize), 0)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// without a free DBI slot a value the size of a database record is
+	// not mistaken for a damaged database.
+	err = env.SelfTest()
+	if err != nil {
+		t.Fatalf("intact environment: %v", err)
+	}
+}
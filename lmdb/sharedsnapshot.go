@@ -0,0 +1,262 @@
+package lmdb
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var errSnapshotClosed = errors.New("shared snapshot is closed")
+
+// SharedSnapshotOptions configures a SharedSnapshot.  The zero value selects
+// the defaults described for each field.
+type SharedSnapshotOptions struct {
+	// Interval is the time between refreshes of the snapshot, 100ms if
+	// zero.
+	Interval time.Duration
+
+	// Readers is the number of read transactions serving the snapshot,
+	// which bounds the number of concurrent calls to View that do not wait,
+	// GOMAXPROCS if zero.
+	Readers int
+}
+
+// SharedSnapshot serves reads to many goroutines from a snapshot of an
+// environment refreshed periodically, for read-mostly workloads tolerating
+// reads up to one refresh interval stale: instead of beginning a read
+// transaction, and taking a read slot, per request, requests borrow one of a
+// fixed set of transactions kept open on the current snapshot.
+//
+// A SharedSnapshot uses Readers read slots, twice that while it refreshes,
+// and pins a snapshot for an interval at a time, so writers cannot reuse the
+// pages freed meanwhile, see Env.PinReports.  It must be closed.
+//
+// The transactions of a snapshot are begun one after the other, and renewed
+// until they read the same commit.  Under a stream of commits too fast for
+// them to agree after a few rounds, they are kept as they are: calls to View
+// may then see a commit that a later call does not, every snapshot read is
+// pinned, and TxnID reports the oldest.
+type SharedSnapshot struct {
+	env  *Env
+	opts SharedSnapshotOptions
+
+	cur      atomic.Value // *snapshotGen
+	mu       sync.Mutex   // serializes refreshes
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+
+	closed int32
+}
+
+// snapshotGen is a set of read transactions begun together, lent to
+// readers through pool until retired.
+type snapshotGen struct {
+	id      uintptr // oldest snapshot read by txns
+	started time.Time
+	txns    []*Txn
+	pins    []*SnapshotPin // one per snapshot read by txns
+	pool    chan *Txn
+	retired chan struct{}
+}
+
+// NewSharedSnapshot returns a SharedSnapshot of env, refreshed in the
+// background every opts.Interval until closed.
+func (env *Env) NewSharedSnapshot(opts *SharedSnapshotOptions) (*SharedSnapshot, error) {
+	s := &SharedSnapshot{env: env, stop: make(chan struct{}), done: make(chan struct{})}
+	if opts != nil {
+		s.opts = *opts
+	}
+	if s.opts.Interval <= 0 {
+		s.opts.Interval = 100 * time.Millisecond
+	}
+	if s.opts.Readers <= 0 {
+		s.opts.Readers = runtime.GOMAXPROCS(0)
+	}
+	gen, err := s.begin()
+	if err != nil {
+		return nil, err
+	}
+	s.cur.Store(gen)
+	err = env.Go("shared-snapshot", s.run)
+	if err != nil {
+		s.retire(gen)
+		return nil, err
+	}
+	return s, nil
+}
+
+// begin begins the transactions of a new generation.
+func (s *SharedSnapshot) begin() (*snapshotGen, error) {
+	gen := &snapshotGen{
+		started: time.Now(),
+		pool:    make(chan *Txn, s.opts.Readers),
+		retired: make(chan struct{}),
+	}
+	for i := 0; i < s.opts.Readers; i++ {
+		txn, err := s.env.BeginTxn(nil, Readonly)
+		if err != nil {
+			for _, txn := range gen.txns {
+				txn.Abort()
+			}
+			return nil, err
+		}
+		gen.txns = append(gen.txns, txn)
+		gen.pool <- txn
+	}
+	err := alignSnapshots(gen.txns)
+	if err != nil {
+		for _, txn := range gen.txns {
+			txn.Abort()
+		}
+		return nil, err
+	}
+	pinned := make(map[uintptr]bool)
+	for _, txn := range gen.txns {
+		id := txn.ID()
+		if pinned[id] {
+			continue
+		}
+		pinned[id] = true
+		gen.pins = append(gen.pins, s.env.PinSnapshot(txn, "shared-snapshot"))
+		if gen.id == 0 || id < gen.id {
+			gen.id = id
+		}
+	}
+	return gen, nil
+}
+
+// snapshotAlignRounds bounds the renewals of the transactions of a
+// generation reading older commits than the others.
+const snapshotAlignRounds = 8
+
+// alignSnapshots renews the transactions of txns that read an older commit
+// than the newest of them, until they all read the same commit or
+// snapshotAlignRounds rounds were made.
+func alignSnapshots(txns []*Txn) error {
+	for round := 0; round < snapshotAlignRounds; round++ {
+		var newest uintptr
+		for _, txn := range txns {
+			if id := txn.ID(); id > newest {
+				newest = id
+			}
+		}
+		aligned := true
+		for _, txn := range txns {
+			if txn.ID() == newest {
+				continue
+			}
+			aligned = false
+			txn.Reset()
+			if err := txn.Renew(); err != nil {
+				return err
+			}
+		}
+		if aligned {
+			return nil
+		}
+	}
+	return nil
+}
+
+// retire waits for the transactions of gen to be returned, aborts them and
+// makes the readers waiting on gen move to the current generation.
+func (s *SharedSnapshot) retire(gen *snapshotGen) {
+	for _, pin := range gen.pins {
+		pin.Release()
+	}
+	for range gen.txns {
+		txn := <-gen.pool
+		txn.Abort()
+	}
+	close(gen.retired)
+}
+
+func (s *SharedSnapshot) run(ctx context.Context) {
+	defer close(s.done)
+	ticker := time.NewTicker(s.opts.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ctx.Done():
+			s.close()
+			return
+		case <-ticker.C:
+		}
+		// a failed refresh keeps serving the previous snapshot.
+		s.Refresh()
+	}
+}
+
+// Refresh replaces the snapshot served by s with the current state of the
+// environment, without waiting for the next interval, e.g. after a write
+// that readers must see.  It waits for the calls to View using the previous
+// snapshot to return.
+func (s *SharedSnapshot) Refresh() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if atomic.LoadInt32(&s.closed) != 0 {
+		return errSnapshotClosed
+	}
+	gen, err := s.begin()
+	if err != nil {
+		return err
+	}
+	old := s.cur.Load().(*snapshotGen)
+	s.cur.Store(gen)
+	s.retire(old)
+	return nil
+}
+
+// View calls fn with a read transaction of the snapshot, waiting for one to
+// be free if Readers calls are already running.  The transaction is shared
+// with other calls in turn: fn must not terminate, reset or otherwise keep
+// it, nor values read from it with RawRead, beyond its return, and must not
+// call Refresh or Close, which wait for it.
+func (s *SharedSnapshot) View(fn TxnOp) error {
+	for {
+		if atomic.LoadInt32(&s.closed) != 0 {
+			return errSnapshotClosed
+		}
+		gen := s.cur.Load().(*snapshotGen)
+		select {
+		case txn := <-gen.pool:
+			defer func() { gen.pool <- txn }()
+			return txn.runOp(fn)
+		case <-gen.retired:
+		}
+	}
+}
+
+// TxnID returns the id of the snapshot served by s, see Txn.ID, the oldest
+// one if its transactions did not agree.
+func (s *SharedSnapshot) TxnID() uintptr {
+	return s.cur.Load().(*snapshotGen).id
+}
+
+// Age returns the time since the snapshot served by s was taken.
+func (s *SharedSnapshot) Age() time.Duration {
+	return time.Since(s.cur.Load().(*snapshotGen).started)
+}
+
+// Close stops refreshing s and releases its transactions once the running
+// calls to View return.  Later calls to View fail.
+func (s *SharedSnapshot) Close() {
+	s.stopOnce.Do(func() { close(s.stop) })
+	<-s.done
+	s.close()
+}
+
+func (s *SharedSnapshot) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if atomic.SwapInt32(&s.closed, 1) != 0 {
+		return
+	}
+	s.retire(s.cur.Load().(*snapshotGen))
+}
@@ -0,0 +1,157 @@
+package lmdb
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSharedSnapshot(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+
+	var dbi DBI
+	err := env.Update(func(txn *Txn) (err error) {
+		dbi, err = txn.OpenDBI("counter", Create)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	set := func(n int) {
+		err := env.Update(func(txn *Txn) error {
+			return txn.Put(dbi, []byte("n"), []byte(strconv.Itoa(n)), 0)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	set(1)
+
+	s, err := env.NewSharedSnapshot(&SharedSnapshotOptions{Interval: 5 * time.Millisecond, Readers: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func() int {
+		var n int
+		err := s.View(func(txn *Txn) error {
+			v, err := txn.Get(dbi, []byte("n"))
+			if err != nil {
+				return err
+			}
+			n, err = strconv.Atoi(string(v))
+			return err
+		})
+		if err != nil {
+			t.Error(err)
+		}
+		return n
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if n := get(); n < 1 {
+					t.Errorf("read %d", n)
+					return
+				}
+			}
+		}()
+	}
+
+	set(2)
+	deadline := time.Now().Add(5 * time.Second)
+	for get() != 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("snapshot not refreshed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	wg.Wait()
+
+	set(3)
+	if err = s.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if n := get(); n != 3 {
+		t.Errorf("read %d after Refresh", n)
+	}
+
+	s.Close()
+	s.Close()
+	if err = s.View(func(txn *Txn) error { return nil }); err != errSnapshotClosed {
+		t.Errorf("view after close: %v", err)
+	}
+}
+
+func TestSharedSnapshot_aligned(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+
+	var dbi DBI
+	err := env.Update(func(txn *Txn) (err error) {
+		dbi, err = txn.OpenDBI("counter", Create)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := env.NewSharedSnapshot(&SharedSnapshotOptions{Interval: time.Hour, Readers: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			err := env.Update(func(txn *Txn) error {
+				return txn.Put(dbi, []byte("n"), []byte(strconv.Itoa(i)), 0)
+			})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			time.Sleep(10 * time.Microsecond)
+		}
+	}()
+	defer wg.Wait()
+	defer close(stop)
+
+	aligned := 0
+	for i := 0; i < 100; i++ {
+		if err := s.Refresh(); err != nil {
+			t.Fatal(err)
+		}
+		gen := s.cur.Load().(*snapshotGen)
+		ids := make(map[uintptr]bool)
+		oldest := gen.txns[0].ID()
+		for _, txn := range gen.txns {
+			ids[txn.ID()] = true
+			if txn.ID() < oldest {
+				oldest = txn.ID()
+			}
+		}
+		if len(gen.pins) != len(ids) || gen.id != oldest {
+			t.Fatalf("%d pins for %d snapshots, id %d oldest %d", len(gen.pins), len(ids), gen.id, oldest)
+		}
+		if len(ids) == 1 {
+			aligned++
+		}
+	}
+	if aligned == 0 {
+		t.Error("no generation read a single snapshot")
+	}
+}
@@ -0,0 +1,178 @@
+package lmdb
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// SphynxPoolOptions configures a SphynxPool.  The zero value selects the
+// defaults described for each field.
+type SphynxPoolOptions struct {
+	// Workers is the number of goroutines, each locked to an OS thread,
+	// running jobs, GOMAXPROCS if zero.
+	Workers int
+
+	// Queue is the number of jobs holding a read slot that wait for a free
+	// worker before SphynxReader blocks, Workers if zero.
+	Queue int
+}
+
+// SphynxPool runs the SphynxReader jobs of several environments on a fixed
+// set of goroutines locked to OS threads, see Env.UseSphynxPool, so that the
+// threads pinned by a process do not grow with the number of environments
+// it opens.
+type SphynxPool struct {
+	workers int
+	jobs    chan *sphynxReadJob
+	stop    chan struct{}
+	wg      sync.WaitGroup
+
+	// mu orders the jobs sent before Close, which the workers drain, and
+	// the calls to run after it.
+	mu     sync.RWMutex
+	closed bool
+
+	queued    int64
+	running   int64
+	submitted uint64
+}
+
+// SphynxPoolStats reports the activity of a SphynxPool.
+type SphynxPoolStats struct {
+	Workers   int
+	Queued    int    // jobs holding a read slot, waiting for a worker
+	Running   int    // jobs running in a read transaction
+	Submitted uint64 // jobs submitted by every environment
+}
+
+// NewSphynxPool starts a SphynxPool.  It must be closed once the
+// environments using it are closed.
+func NewSphynxPool(opts *SphynxPoolOptions) *SphynxPool {
+	var o SphynxPoolOptions
+	if opts != nil {
+		o = *opts
+	}
+	if o.Workers <= 0 {
+		o.Workers = runtime.GOMAXPROCS(0)
+	}
+	if o.Queue <= 0 {
+		o.Queue = o.Workers
+	}
+	p := &SphynxPool{
+		workers: o.Workers,
+		jobs:    make(chan *sphynxReadJob, o.Queue),
+		stop:    make(chan struct{}),
+	}
+	p.wg.Add(o.Workers)
+	for i := 0; i < o.Workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+// UseSphynxPool makes SphynxReader run the jobs of env on the threads of p,
+// shared with other environments, instead of threads of its own, see
+// UseSphynxReader.  A job takes a read slot of env, waiting in turn with
+// the other readers of env if they hold every slot, before it waits for a
+// worker of p, so that a saturated environment does not hold up the workers
+// serving the others.  It must be called before SphynxReader.
+func (env *Env) UseSphynxPool(p *SphynxPool) {
+	env.sphynxPool = p
+}
+
+// run runs f in a read transaction of env on a worker of p.
+func (p *SphynxPool) run(env *Env, f SphynxReadFunc) error {
+	done, ok := env.register("sphynx-pool-job", nil)
+	if !ok {
+		return errGoClosed
+	}
+	defer done()
+	atomic.AddUint64(&p.submitted, 1)
+	rs, err := env.getReadSlot(readPathSphynx)
+	if err != nil {
+		return err
+	}
+	job := env.newSphynxReadJob(f)
+	job.readSlot = rs
+	p.mu.RLock()
+	if p.closed {
+		p.mu.RUnlock()
+		env.ReturnReadSlot(rs)
+		return errSphynxStopped
+	}
+	atomic.AddInt64(&p.queued, 1)
+	p.jobs <- job
+	p.mu.RUnlock()
+	<-job.done
+	return job.err
+}
+
+func (p *SphynxPool) work() {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	defer p.wg.Done()
+	for {
+		select {
+		case <-p.stop:
+			// jobs still queued never run.
+			for {
+				select {
+				case job := <-p.jobs:
+					atomic.AddInt64(&p.queued, -1)
+					job.env.ReturnReadSlot(job.readSlot)
+					job.err = errSphynxStopped
+					close(job.done)
+				default:
+					return
+				}
+			}
+		case job := <-p.jobs:
+			atomic.AddInt64(&p.queued, -1)
+			atomic.AddInt64(&p.running, 1)
+			p.runJob(job)
+			atomic.AddInt64(&p.running, -1)
+			close(job.done)
+		}
+	}
+}
+
+// runJob runs job in a read transaction begun, and terminated, on the
+// thread of the calling worker.
+func (p *SphynxPool) runJob(job *sphynxReadJob) {
+	rs := job.readSlot
+	rs.mu.Lock()
+	rs.owner = curGID()
+	rs.mu.Unlock()
+	txn, err := beginTxnWithReadSlot(job.env, nil, job.flags, rs)
+	if err != nil {
+		job.env.ReturnReadSlot(rs)
+		job.err = err
+		return
+	}
+	// once begun the transaction returns rs when it terminates.
+	defer txn.Abort()
+	job.err = job.f(txn, rs.slot)
+}
+
+// Stats returns the activity of p.
+func (p *SphynxPool) Stats() SphynxPoolStats {
+	return SphynxPoolStats{
+		Workers:   p.workers,
+		Queued:    int(atomic.LoadInt64(&p.queued)),
+		Running:   int(atomic.LoadInt64(&p.running)),
+		Submitted: atomic.LoadUint64(&p.submitted),
+	}
+}
+
+// Close stops the workers of p once the running jobs return.  Jobs still
+// queued, and later calls to SphynxReader of the environments using p, fail.
+func (p *SphynxPool) Close() {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.stop)
+	}
+	p.mu.Unlock()
+	p.wg.Wait()
+}
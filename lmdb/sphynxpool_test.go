@@ -0,0 +1,105 @@
+package lmdb
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestSphynxPool(t *testing.T) {
+	pool := NewSphynxPool(&SphynxPoolOptions{Workers: 2})
+	defer pool.Close()
+
+	// several environments, each with more read slots than the pool has
+	// threads.
+	var envs []*Env
+	var dbis []DBI
+	for i := 0; i < 4; i++ {
+		path, err := ioutil.TempDir("", "mdb_test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(path)
+		env, err := OpenEnv(path, &Options{MaxReaders: 8, MaxDBs: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer env.Close()
+		env.UseSphynxPool(pool)
+		var dbi DBI
+		err = env.Update(func(txn *Txn) (err error) {
+			dbi, err = txn.OpenDBI("db", Create)
+			if err != nil {
+				return err
+			}
+			return txn.Put(dbi, []byte("env"), []byte(fmt.Sprint(i)), 0)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		envs = append(envs, env)
+		dbis = append(dbis, dbi)
+	}
+	if cfg, err := envs[0].Config(); err != nil || !cfg.SphynxReader {
+		t.Errorf("config %+v %v", cfg, err)
+	}
+
+	var running, maxRunning int32
+	var wg sync.WaitGroup
+	for j := 0; j < 50; j++ {
+		for i, env := range envs {
+			wg.Add(1)
+			go func(i int, env *Env) {
+				defer wg.Done()
+				err := env.SphynxReader(func(txn *Txn, slot int) error {
+					n := atomic.AddInt32(&running, 1)
+					defer atomic.AddInt32(&running, -1)
+					for {
+						max := atomic.LoadInt32(&maxRunning)
+						if n <= max || atomic.CompareAndSwapInt32(&maxRunning, max, n) {
+							break
+						}
+					}
+					v, err := txn.Get(dbis[i], []byte("env"))
+					if err != nil {
+						return err
+					}
+					if string(v) != fmt.Sprint(i) {
+						return fmt.Errorf("env %d read %q", i, v)
+					}
+					return nil
+				})
+				if err != nil {
+					t.Error(err)
+				}
+			}(i, env)
+		}
+	}
+	wg.Wait()
+
+	if maxRunning > 2 {
+		t.Errorf("%d jobs ran at once on 2 workers", maxRunning)
+	}
+	stats := pool.Stats()
+	if stats.Workers != 2 || stats.Submitted != 200 || stats.Queued != 0 || stats.Running != 0 {
+		t.Errorf("pool stats %+v", stats)
+	}
+	for _, env := range envs {
+		rs := env.ReadSlotStats()
+		if rs.SphynxPath.Acquired != 50 || rs.SphynxPath.Held != 0 || rs.Free != 8 {
+			t.Errorf("read slot stats %+v", rs)
+		}
+	}
+
+	pool.Close()
+	err := envs[0].SphynxReader(func(txn *Txn, slot int) error {
+		t.Error("job ran after the pool was closed")
+		return nil
+	})
+	if err != errSphynxStopped {
+		t.Errorf("closed pool: %v", err)
+	}
+}
@@ -0,0 +1,141 @@
+package lmdb
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// ErrSphynxQueueFull is returned by SphynxReader when the job queue has no
+// room for the job, at once with SphynxReject or after the timeout with
+// SphynxBlock.
+var ErrSphynxQueueFull = errors.New("sphynx reader queue is full")
+
+var errSphynxStopped = errors.New("sphynx reader stopped")
+
+// SphynxQueuePolicy selects what SphynxReader does when the job queue is
+// full.
+type SphynxQueuePolicy int
+
+const (
+	// SphynxBlock waits for room in the queue, for at most the timeout of
+	// the queue if it is not zero.
+	SphynxBlock SphynxQueuePolicy = iota
+
+	// SphynxReject fails at once.
+	SphynxReject
+)
+
+// SphynxQueueOptions configure the queue of jobs waiting for a read slot,
+// see UseSphynxQueue.  The zero value queues up to maxReaders jobs and
+// blocks without timeout when the queue is full.
+type SphynxQueueOptions struct {
+	Size    int // jobs held, maxReaders if zero
+	Policy  SphynxQueuePolicy
+	Timeout time.Duration // for SphynxBlock, zero waits forever
+}
+
+// UseSphynxQueue is UseSphynxReader with a bounded queue configured by
+// opts.  Jobs are queued while every read slot is held, so that a burst of
+// SphynxReader calls when readers are saturated fails fast, or after a
+// bounded wait, instead of piling up.  opts is ignored if the reader is
+// already in use.
+//
+// The worker waits for a read slot without watching for Env.Close, so
+// closing env while a job is waiting blocks until a read slot is returned:
+// end the read transactions of env before closing it.
+func (env *Env) UseSphynxQueue(opts SphynxQueueOptions) {
+	if env.readWorker == nil {
+		env.readWorker = newSphynxReadWorker(env, opts)
+	}
+}
+
+// SphynxStats reports the activity of the SphynxReader queue.
+type SphynxStats struct {
+	Capacity  int    // jobs the queue holds
+	Queued    int    // jobs waiting for a read slot
+	Running   int    // jobs running in a read transaction
+	Submitted uint64 // calls to SphynxReader
+	Rejected  uint64 // jobs refused at once by SphynxReject
+	TimedOut  uint64 // jobs given up after the timeout of SphynxBlock
+}
+
+// SphynxStats returns the activity of the SphynxReader queue, or the zero
+// value if UseSphynxReader was not called.
+func (env *Env) SphynxStats() SphynxStats {
+	w := env.readWorker
+	if w == nil {
+		return SphynxStats{}
+	}
+	return SphynxStats{
+		Capacity:  cap(w.jobsCh),
+		Queued:    int(atomic.LoadInt64(&w.stats.queued)),
+		Running:   int(atomic.LoadInt64(&w.stats.running)),
+		Submitted: atomic.LoadUint64(&w.stats.submitted),
+		Rejected:  atomic.LoadUint64(&w.stats.rejected),
+		TimedOut:  atomic.LoadUint64(&w.stats.timedOut),
+	}
+}
+
+// sphynxStats are the counters behind SphynxStats.
+type sphynxStats struct {
+	queued    int64
+	running   int64
+	submitted uint64
+	rejected  uint64
+	timedOut  uint64
+}
+
+// submit queues job according to the policy of w.
+func (w *sphynxReadWorker) submit(job *sphynxReadJob) error {
+	atomic.AddUint64(&w.stats.submitted, 1)
+	// counted before the send so that the worker never sees it negative.
+	atomic.AddInt64(&w.stats.queued, 1)
+	select {
+	case <-w.halt.ReqStop.Chan:
+		atomic.AddInt64(&w.stats.queued, -1)
+		return errSphynxStopped
+	case w.jobsCh <- job:
+		return nil
+	default:
+	}
+	if w.policy == SphynxReject {
+		atomic.AddInt64(&w.stats.queued, -1)
+		atomic.AddUint64(&w.stats.rejected, 1)
+		return ErrSphynxQueueFull
+	}
+	var timeout <-chan time.Time
+	if w.timeout > 0 {
+		t := time.NewTimer(w.timeout)
+		defer t.Stop()
+		timeout = t.C
+	}
+	select {
+	case <-w.halt.ReqStop.Chan:
+		atomic.AddInt64(&w.stats.queued, -1)
+		return errSphynxStopped
+	case w.jobsCh <- job:
+		return nil
+	case <-timeout:
+		atomic.AddInt64(&w.stats.queued, -1)
+		atomic.AddUint64(&w.stats.timedOut, 1)
+		return ErrSphynxQueueFull
+	}
+}
+
+// wait returns the result of the queued job.  A job is done before the
+// worker stops if it was dispatched, so a job still pending once the worker
+// stopped never ran.
+func (w *sphynxReadWorker) wait(job *sphynxReadJob) error {
+	select {
+	case <-job.done:
+		return job.err
+	case <-w.halt.Done.Chan:
+	}
+	select {
+	case <-job.done:
+		return job.err
+	default:
+		return errSphynxStopped
+	}
+}
@@ -0,0 +1,107 @@
+package lmdb
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+// saturateSphynx opens an environment with two read slots, held by the
+// returned slots, and fills its SphynxReader queue.  The results of the
+// queued jobs are sent on the returned channel.
+func saturateSphynx(t *testing.T, opts SphynxQueueOptions) (*Env, []*ReadSlot, chan error) {
+	path, err := ioutil.TempDir("", "mdb_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(path) })
+	env, err := OpenEnv(path, &Options{MaxReaders: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { env.Close() })
+	env.UseSphynxQueue(opts)
+
+	var held []*ReadSlot
+	for i := 0; i < 2; i++ {
+		rs, err := env.GetOrWaitForReadSlot()
+		if err != nil {
+			t.Fatal(err)
+		}
+		held = append(held, rs)
+	}
+
+	// one job waits in the worker for a slot and one in the queue.
+	results := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			results <- env.SphynxReader(func(txn *Txn, readslot int) error {
+				return nil
+			})
+		}()
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for env.SphynxStats().Queued != 2 || len(env.readWorker.jobsCh) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("jobs not queued: %+v", env.SphynxStats())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return env, held, results
+}
+
+func TestSphynxQueueReject(t *testing.T) {
+	env, held, results := saturateSphynx(t, SphynxQueueOptions{Size: 1, Policy: SphynxReject})
+
+	start := time.Now()
+	err := env.SphynxReader(func(txn *Txn, readslot int) error {
+		t.Error("rejected job ran")
+		return nil
+	})
+	if err != ErrSphynxQueueFull {
+		t.Fatalf("err = %v, want ErrSphynxQueueFull", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("rejection took %v", d)
+	}
+
+	for _, rs := range held {
+		env.ReturnReadSlot(rs)
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-results; err != nil {
+			t.Error(err)
+		}
+	}
+
+	stats := env.SphynxStats()
+	want := SphynxStats{Capacity: 1, Submitted: 3, Rejected: 1}
+	if stats != want {
+		t.Errorf("stats = %+v, want %+v", stats, want)
+	}
+}
+
+func TestSphynxQueueTimeout(t *testing.T) {
+	env, held, results := saturateSphynx(t, SphynxQueueOptions{Size: 1, Timeout: 20 * time.Millisecond})
+
+	err := env.SphynxReader(func(txn *Txn, readslot int) error {
+		t.Error("timed out job ran")
+		return nil
+	})
+	if err != ErrSphynxQueueFull {
+		t.Fatalf("err = %v, want ErrSphynxQueueFull", err)
+	}
+	if stats := env.SphynxStats(); stats.TimedOut != 1 || stats.Rejected != 0 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+
+	for _, rs := range held {
+		env.ReturnReadSlot(rs)
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-results; err != nil {
+			t.Error(err)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package lmdb
+
+/*
+#include <stdlib.h>
+#include <stdio.h>
+#include "lmdb.h"
+#include "lmdbgo.h"
+*/
+import "C"
+
+import (
+	"unsafe"
+)
+
+// Many applications key their databases by string.  Converting such keys
+// with []byte(key) allocates a copy on every call, which shows up quickly in
+// allocation profiles.  The methods in this file pass the string data to LMDB
+// directly instead.  LMDB never writes through key pointers so handing it the
+// (immutable) string memory is safe.
+
+// GetStringKey is like Get but takes a string key and does not allocate a
+// []byte copy of it.
+//
+// See mdb_get.
+func (txn *Txn) GetStringKey(dbi DBI, key string) ([]byte, error) {
+	kdata, kn := strVal(key)
+	ret := C.lmdbgo_mdb_get(
+		txn._txn, C.MDB_dbi(dbi),
+		kdata, C.size_t(kn),
+		txn.readSlot.sval,
+	)
+	err := operrno("mdb_get", ret)
+	if err != nil {
+		return nil, err
+	}
+	b := txn.bytes(txn.readSlot.sval)
+	return b, nil
+}
+
+// PutStringKey is like Put but takes a string key and does not allocate a
+// []byte copy of it.
+//
+// See mdb_put.
+func (txn *Txn) PutStringKey(dbi DBI, key string, val []byte, flags uint) error {
+	if len(key) == 0 {
+		return txn.putNilKey(dbi, flags)
+	}
+	kdata, kn := strVal(key)
+	vn := len(val)
+	if vn == 0 {
+		val = []byte{0}
+	}
+
+	ret := C.lmdbgo_mdb_put2(
+		txn._txn, C.MDB_dbi(dbi),
+		kdata, C.size_t(kn),
+		(*C.char)(unsafe.Pointer(&val[0])), C.size_t(vn),
+		C.uint(flags),
+	)
+	if ret == success && txn.capture {
+		txn.captureOp(BatchPut, dbi, []byte(key), val[:vn])
+	}
+	return operrno("mdb_put", ret)
+}
+
+// DelStringKey is like Del but takes a string key and does not allocate a
+// []byte copy of it.  DelStringKey ignores val unless dbi has the DupSort
+// flag.
+//
+// See mdb_del.
+func (txn *Txn) DelStringKey(dbi DBI, key string, val []byte) error {
+	kdata, kn := strVal(key)
+	vdata, vn := valBytes(val)
+	ret := C.lmdbgo_mdb_del(
+		txn._txn, C.MDB_dbi(dbi),
+		kdata, C.size_t(kn),
+		(*C.char)(unsafe.Pointer(&vdata[0])), C.size_t(vn),
+	)
+	if ret == success && txn.capture {
+		txn.captureOp(BatchDel, dbi, []byte(key), val)
+	}
+	return operrno("mdb_del", ret)
+}
@@ -0,0 +1,64 @@
+package lmdb
+
+import (
+	"testing"
+)
+
+func TestTxn_StringKey(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+
+	db, err := openRoot(env, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = env.Update(func(txn *Txn) (err error) {
+		err = txn.PutStringKey(db, "k0", []byte("v0"), 0)
+		if err != nil {
+			return err
+		}
+		err = txn.PutStringKey(db, "k1", []byte("v1"), 0)
+		if err != nil {
+			return err
+		}
+		return txn.DelStringKey(db, "k0", nil)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = env.View(func(txn *Txn) (err error) {
+		txn.RawRead = true
+		_, err = txn.GetStringKey(db, "k0")
+		if !IsNotFound(err) {
+			t.Errorf("unexpected error: %v", err)
+		}
+		v, err := txn.GetStringKey(db, "k1")
+		if err != nil {
+			return err
+		}
+		if string(v) != "v1" {
+			t.Errorf("unexpected value: %q (!= %q)", v, "v1")
+		}
+		_, err = txn.GetStringKey(db, "")
+		if err == nil {
+			t.Errorf("expected error for empty key")
+		}
+
+		key := string([]byte("k1"))
+		allocs := testing.AllocsPerRun(100, func() {
+			_, err := txn.GetStringKey(db, key)
+			if err != nil {
+				t.Error(err)
+			}
+		})
+		if unsafeViews && allocs != 0 {
+			t.Errorf("unexpected allocations: %v", allocs)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+}
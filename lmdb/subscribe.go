@@ -0,0 +1,505 @@
+package lmdb
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrSubscriptionClosed is returned by Subscription.Next once the
+// subscription has been closed and its buffered events consumed.
+var ErrSubscriptionClosed = errors.New("subscription is closed")
+
+var errSpillDBI = errors.New("OverflowSpill requires a Spill database")
+
+// OverflowPolicy selects what a Subscription does with an event when its
+// buffer is full.
+type OverflowPolicy int
+
+// Overflow policies.
+const (
+	// OverflowBlock makes the committing writer wait, after its commit,
+	// until the consumer has made room for the event.  No events are lost
+	// but a slow consumer slows down every writer.
+	OverflowBlock OverflowPolicy = iota
+
+	// OverflowDrop discards events while the buffer is full and delivers
+	// a gap marker, an Event with a non-zero Gap, in their place once there
+	// is room again.
+	OverflowDrop
+
+	// OverflowSpill writes events that do not fit in the buffer to the
+	// database SubscribeOptions.Spill, in a transaction of their own, and
+	// reads them back as the consumer catches up.
+	OverflowSpill
+)
+
+// SubscribeOptions configures a Subscription.
+type SubscribeOptions struct {
+	// Buffer is the number of events held in memory, 1024 if zero.
+	Buffer int
+
+	// Overflow is the policy applied when the buffer is full.
+	Overflow OverflowPolicy
+
+	// Spill is the database to which OverflowSpill writes events.  It must
+	// be dedicated to the subscription, which empties it when created and
+	// when closed.
+	Spill DBI
+
+	// DBIs restricts the events to changes of the given databases.  If
+	// empty, changes of every database are delivered.
+	DBIs []DBI
+}
+
+// Event describes the changes made by a committed write transaction, in the
+// order they were made.  Puts are recorded with the flags they were given
+// dropped, as replaying them must reproduce their effect, not repeat their
+// checks.  Txn.Drop is recorded as a BatchDropRange without bounds.
+type Event struct {
+	// Seq numbers the commits of the environment seen by its subscriptions,
+	// starting at 1.  Commits without changes to the subscribed databases
+	// produce no event, so delivered Seq values may skip numbers.  Events
+	// are published in commit order, so Seq and TxnID only increase.
+	Seq uint64
+
+	// Time is when the commit was published, just after it completed.
+	Time time.Time
+
+	// TxnID is the id of the committed transaction, see Txn.ID, and Labels
+	// those of its context, see Env.UpdateContext.  Events read back from
+	// the Spill database of OverflowSpill have neither.
+	TxnID  uintptr
+	Labels Labels
+
+	Ops []BatchOp
+
+	// Gap is non-zero for gap markers, which have no Ops: Gap events were
+	// dropped by OverflowDrop, or could not be spilled, the first of them
+	// being Seq.
+	Gap uint64
+}
+
+// Batch returns a WriteBatch holding the operations of e, to apply them
+// elsewhere or encode them with WriteBatch.Marshal.
+func (e *Event) Batch() *WriteBatch {
+	return &WriteBatch{ops: e.Ops}
+}
+
+// Subscription delivers the changes committed to an environment, a change
+// data capture stream for watchers, caches and replicas.  Changes are
+// recorded by the write methods of Txn and Cursor while a subscription
+// exists and published once their outermost transaction commits.  Nested
+// transactions contribute their changes when they commit.
+//
+// Events are buffered in memory up to SubscribeOptions.Buffer, beyond which
+// the overflow policy decides between slowing down writers, dropping events
+// with an explicit gap marker, or spilling events to a database, so that a
+// slow consumer neither grows memory without bound nor misses events
+// silently.
+type Subscription struct {
+	env    *Env
+	opts   SubscribeOptions
+	filter map[DBI]bool
+
+	mu     sync.Mutex
+	space  *sync.Cond // signaled when the buffer has room
+	notify chan struct{}
+	queue  []Event
+	closed bool
+
+	gapSeq, gap uint64 // pending gap marker of OverflowDrop
+	dropped     uint64
+
+	spilled    int            // events in the Spill database
+	spillNames map[DBI]string // names of the DBIs of spilled events
+	spillDBIs  map[string]DBI
+}
+
+// subscriptions is the set of subscriptions of an Env.
+type subscriptions struct {
+	n    int32 // number of subscriptions, read by beginning transactions
+	mu   sync.Mutex
+	list []*Subscription
+
+	// commits are published after the writer lock is released, so a
+	// committing transaction takes a ticket while it still holds the lock
+	// and publishes when pubTurn reaches it.  Tickets are atomic rather than
+	// under pubMu, which is held while publishing and so possibly while
+	// spilling in a write transaction.
+	tickets uint64
+	pubMu   sync.Mutex
+	pubCond *sync.Cond
+	pubTurn uint64
+	seq     uint64
+}
+
+// Subscribe creates a Subscription to the changes committed to env from now
+// on.  A nil opts selects the defaults.  Subscriptions must be closed.
+//
+// With OverflowBlock the consumer of a subscription must not be a goroutine
+// that commits write transactions, and with OverflowSpill it must not call
+// Next while it has a write transaction open, as either would deadlock.
+func (env *Env) Subscribe(opts *SubscribeOptions) (*Subscription, error) {
+	s := &Subscription{
+		env:    env,
+		notify: make(chan struct{}, 1),
+	}
+	if opts != nil {
+		s.opts = *opts
+	}
+	if s.opts.Buffer <= 0 {
+		s.opts.Buffer = 1024
+	}
+	s.space = sync.NewCond(&s.mu)
+	if len(s.opts.DBIs) > 0 {
+		s.filter = make(map[DBI]bool)
+		for _, dbi := range s.opts.DBIs {
+			s.filter[dbi] = true
+		}
+	}
+	if s.opts.Overflow == OverflowSpill {
+		if s.opts.Spill == 0 {
+			return nil, errSpillDBI
+		}
+		s.spillNames = make(map[DBI]string)
+		s.spillDBIs = make(map[string]DBI)
+		err := env.updateUncaptured(func(txn *Txn) error {
+			return txn.Drop(s.opts.Spill, false)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	env.subs.mu.Lock()
+	env.subs.list = append(env.subs.list, s)
+	atomic.StoreInt32(&env.subs.n, int32(len(env.subs.list)))
+	env.subs.mu.Unlock()
+	return s, nil
+}
+
+// Close stops the delivery of new events.  Events buffered in memory may
+// still be read with Next, while spilled events are discarded as Close
+// empties the Spill database.
+func (s *Subscription) Close() error {
+	subs := &s.env.subs
+	subs.mu.Lock()
+	for i, x := range subs.list {
+		if x == s {
+			subs.list = append(subs.list[:i:i], subs.list[i+1:]...)
+			break
+		}
+	}
+	atomic.StoreInt32(&subs.n, int32(len(subs.list)))
+	subs.mu.Unlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	s.space.Broadcast()
+	s.signal()
+	if s.spilled > 0 {
+		s.spilled = 0
+		return s.env.updateUncaptured(func(txn *Txn) error {
+			return txn.Drop(s.opts.Spill, false)
+		})
+	}
+	return nil
+}
+
+// Dropped returns the number of events discarded by OverflowDrop.
+func (s *Subscription) Dropped() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dropped
+}
+
+// Next waits for the next event and returns it.  Next returns the error of
+// ctx if it is done first, and ErrSubscriptionClosed once s is closed and
+// has no buffered events left.  Next must not be called concurrently.
+func (s *Subscription) Next(ctx context.Context) (Event, error) {
+	for {
+		s.mu.Lock()
+		ev, ok, err := s.pop()
+		closed := s.closed
+		s.mu.Unlock()
+		if ok || err != nil {
+			return ev, err
+		}
+		if closed {
+			return Event{}, ErrSubscriptionClosed
+		}
+
+		select {
+		case <-s.notify:
+		case <-ctx.Done():
+			return Event{}, ctx.Err()
+		}
+	}
+}
+
+// pop removes the next event from the buffer, the pending gap marker or the
+// Spill database, in that order.
+func (s *Subscription) pop() (Event, bool, error) {
+	if len(s.queue) == 0 && s.gap > 0 {
+		ev := Event{Seq: s.gapSeq, Gap: s.gap}
+		s.gap = 0
+		return ev, true, nil
+	}
+	if len(s.queue) == 0 && s.spilled > 0 {
+		err := s.unspill()
+		if err != nil {
+			return Event{}, false, err
+		}
+	}
+	if len(s.queue) == 0 {
+		return Event{}, false, nil
+	}
+	ev := s.queue[0]
+	s.queue[0] = Event{}
+	s.queue = s.queue[1:]
+	s.space.Broadcast()
+	return ev, true, nil
+}
+
+func (s *Subscription) signal() {
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+// publish delivers the changes of a commit to s.  The caller holds
+// env.subs.pubMu and publishes commits in their order.
+func (s *Subscription) publish(seq uint64, now time.Time, id uintptr, labels Labels, ops []BatchOp) {
+	if s.filter != nil {
+		var matched []BatchOp
+		for _, op := range ops {
+			if s.filter[op.DBI] {
+				matched = append(matched, op)
+			}
+		}
+		ops = matched
+	}
+	if len(ops) == 0 {
+		return
+	}
+	ev := Event{Seq: seq, Time: now, TxnID: id, Labels: labels, Ops: ops}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	full := func() bool { return len(s.queue) >= s.opts.Buffer }
+	switch s.opts.Overflow {
+	case OverflowBlock:
+		for full() && !s.closed {
+			s.space.Wait()
+		}
+		if s.closed {
+			return
+		}
+	case OverflowDrop:
+		if s.gap > 0 && !full() {
+			s.queue = append(s.queue, Event{Seq: s.gapSeq, Gap: s.gap})
+			s.gap = 0
+		}
+		if full() {
+			if s.gap == 0 {
+				s.gapSeq = seq
+			}
+			s.gap++
+			s.dropped++
+			return
+		}
+	case OverflowSpill:
+		if s.spilled > 0 || full() {
+			err := s.spill(&ev)
+			if err == nil {
+				s.spilled++
+				return
+			}
+			// a failed spill is reported as a gap rather than lost.
+			s.gapSeq, s.gap = seq, 1
+			s.dropped++
+			return
+		}
+	}
+	s.queue = append(s.queue, ev)
+	s.signal()
+}
+
+// spill writes ev to the Spill database under its big-endian Seq, as its
+// big-endian Time in nanoseconds followed by its Ops as a changeset.  DBIs
+// are encoded by number, which is only meaningful within the process.
+func (s *Subscription) spill(ev *Event) error {
+	names := make(map[DBI]string)
+	for _, op := range ev.Ops {
+		name, ok := s.spillNames[op.DBI]
+		if !ok {
+			name = strconv.Itoa(int(op.DBI))
+			s.spillNames[op.DBI] = name
+			s.spillDBIs[name] = op.DBI
+		}
+		names[op.DBI] = name
+	}
+	cs, err := ev.Batch().Marshal(names)
+	if err != nil {
+		return err
+	}
+	val := make([]byte, 8+len(cs))
+	binary.BigEndian.PutUint64(val, uint64(ev.Time.UnixNano()))
+	copy(val[8:], cs)
+	var key [8]byte
+	binary.BigEndian.PutUint64(key[:], ev.Seq)
+	return s.env.updateUncaptured(func(txn *Txn) error {
+		return txn.Put(s.opts.Spill, key[:], val, 0)
+	})
+}
+
+// unspill moves up to a buffer of events from the Spill database to the
+// queue.
+func (s *Subscription) unspill() error {
+	return s.env.updateUncaptured(func(txn *Txn) error {
+		cur, err := txn.OpenCursor(s.opts.Spill)
+		if err != nil {
+			return err
+		}
+		defer cur.Close()
+		for len(s.queue) < s.opts.Buffer && s.spilled > 0 {
+			k, v, err := cur.Get(nil, nil, First)
+			if err != nil {
+				return err
+			}
+			if len(v) < 8 {
+				return errChangesetTruncated
+			}
+			var b WriteBatch
+			err = b.Unmarshal(v[8:], s.spillDBIs)
+			if err != nil {
+				return err
+			}
+			s.queue = append(s.queue, Event{
+				Seq:  binary.BigEndian.Uint64(k),
+				Time: time.Unix(0, int64(binary.BigEndian.Uint64(v))),
+				Ops:  b.ops,
+			})
+			err = cur.Del(0)
+			if err != nil {
+				return err
+			}
+			s.spilled--
+		}
+		return nil
+	})
+}
+
+// updateUncaptured runs fn in a write transaction whose changes are not
+// published to subscriptions.
+func (env *Env) updateUncaptured(fn TxnOp) error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	txn, err := beginTxn(env, nil, 0)
+	if err != nil {
+		return err
+	}
+	txn.capture = false
+	return txn.runOpTerm(fn)
+}
+
+// reservePublication takes the place of a transaction in the order of
+// publication.  It must be called before mdb_txn_commit, while the writer
+// lock orders commits, and the ticket passed to publishChanges.
+func (env *Env) reservePublication() uint64 {
+	return atomic.AddUint64(&env.subs.tickets, 1) - 1
+}
+
+// publishChanges delivers the changes of committed transaction id, with the
+// labels of its context, to the subscriptions of env once the commits of all
+// earlier tickets are published.  Empty ops give up the place of a failed
+// commit.
+func (env *Env) publishChanges(ticket uint64, id uintptr, labels Labels, ops []BatchOp) {
+	subs := &env.subs
+	subs.pubMu.Lock()
+	defer subs.pubMu.Unlock()
+	if subs.pubCond == nil {
+		subs.pubCond = sync.NewCond(&subs.pubMu)
+	}
+	for subs.pubTurn != ticket {
+		subs.pubCond.Wait()
+	}
+	defer func() {
+		subs.pubTurn++
+		subs.pubCond.Broadcast()
+	}()
+	if len(ops) == 0 {
+		return
+	}
+	subs.mu.Lock()
+	list := append([]*Subscription(nil), subs.list...)
+	subs.mu.Unlock()
+	subs.seq++
+	now := time.Now()
+	for _, s := range list {
+		s.publish(subs.seq, now, id, labels, ops)
+	}
+}
+
+// capturing reports whether a new top-level write transaction must record
+// its changes.
+func (env *Env) capturing() bool {
+	return atomic.LoadInt32(&env.subs.n) > 0
+}
+
+// captureOp records a change made by txn if it is being captured.
+func (txn *Txn) captureOp(typ BatchOpType, dbi DBI, key, val []byte) {
+	if !txn.capture {
+		return
+	}
+	txn.changes = append(txn.changes, BatchOp{
+		Type: typ,
+		DBI:  dbi,
+		Key:  cloneBytes(key),
+		Val:  cloneBytes(val),
+	})
+}
+
+// captureReserve records a PutReserve, whose value is only known once the
+// caller has filled buf and is read before txn commits.
+func (txn *Txn) captureReserve(dbi DBI, key, buf []byte) {
+	if !txn.capture {
+		return
+	}
+	txn.captureOp(BatchPut, dbi, key, nil)
+	txn.changes[len(txn.changes)-1].Val = buf
+	txn.reserved = append(txn.reserved, len(txn.changes)-1)
+}
+
+// settleReserved copies the values written through PutReserve, which are
+// only valid until txn commits.
+func (txn *Txn) settleReserved() {
+	for _, i := range txn.reserved {
+		txn.changes[i].Val = cloneBytes(txn.changes[i].Val)
+	}
+	txn.reserved = nil
+}
+
+// commitChanges passes the captured changes of a committed nested txn on to
+// its parent, and returns those of a top-level txn to be published.
+func (txn *Txn) commitChanges() []BatchOp {
+	if !txn.capture || len(txn.changes) == 0 {
+		return nil
+	}
+	if txn.parent != nil {
+		txn.parent.changes = append(txn.parent.changes, txn.changes...)
+		return nil
+	}
+	return txn.changes
+}
@@ -0,0 +1,316 @@
+package lmdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func eventString(ev Event) string {
+	if ev.Gap > 0 {
+		return fmt.Sprintf("gap %d@%d", ev.Gap, ev.Seq)
+	}
+	s := ""
+	for _, op := range ev.Ops {
+		switch op.Type {
+		case BatchPut:
+			s += fmt.Sprintf("put %s=%s;", op.Key, op.Val)
+		case BatchDel:
+			s += fmt.Sprintf("del %s;", op.Key)
+		case BatchDropRange:
+			s += "drop;"
+		}
+	}
+	return s
+}
+
+func nextEvents(t *testing.T, s *Subscription, n int) []string {
+	t.Helper()
+	var evs []string
+	for i := 0; i < n; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		ev, err := s.Next(ctx)
+		cancel()
+		if err != nil {
+			t.Fatal(err)
+		}
+		evs = append(evs, eventString(ev))
+	}
+	return evs
+}
+
+func TestSubscribe(t *testing.T) {
+	if !unsafeViews {
+		t.Skip("PutReserve is not supported in lmdbsafe builds")
+	}
+	env := setup(t)
+	defer clean(env, t)
+
+	dbi, err := openRoot(env, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := env.Subscribe(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	err = env.Update(func(txn *Txn) error {
+		err := txn.Put(dbi, []byte("a"), []byte("1"), 0)
+		if err != nil {
+			return err
+		}
+		buf, err := txn.PutReserve(dbi, []byte("b"), 1, 0)
+		if err != nil {
+			return err
+		}
+		buf[0] = '2'
+		// the changes of an aborted nested txn are not published.
+		errAbort := errors.New("abort")
+		err = txn.Sub(func(txn *Txn) error {
+			err := txn.Put(dbi, []byte("x"), []byte("x"), 0)
+			if err != nil {
+				return err
+			}
+			return errAbort
+		})
+		if err != errAbort {
+			return fmt.Errorf("sub: %v", err)
+		}
+		return txn.Sub(func(txn *Txn) error {
+			return txn.Del(dbi, []byte("a"), nil)
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// aborted transactions publish nothing.
+	err = env.Update(func(txn *Txn) error {
+		err := txn.Put(dbi, []byte("y"), []byte("y"), 0)
+		if err != nil {
+			return err
+		}
+		return errors.New("abort")
+	})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	err = env.Update(func(txn *Txn) error {
+		cur, err := txn.OpenCursor(dbi)
+		if err != nil {
+			return err
+		}
+		defer cur.Close()
+		_, _, err = cur.Get(nil, nil, First)
+		if err != nil {
+			return err
+		}
+		err = cur.Del(0)
+		if err != nil {
+			return err
+		}
+		return txn.Drop(dbi, false)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	evs := nextEvents(t, s, 2)
+	want := []string{"put a=1;put b=2;del a;", "del b;drop;"}
+	if fmt.Sprint(evs) != fmt.Sprint(want) {
+		t.Errorf("events %q, want %q", evs, want)
+	}
+
+	err = s.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Next(context.Background())
+	if err != ErrSubscriptionClosed {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestSubscribe_overflow(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+
+	var dbi, other, spill DBI
+	err := env.Update(func(txn *Txn) (err error) {
+		dbi, err = txn.OpenDBI("data", Create)
+		if err != nil {
+			return err
+		}
+		other, err = txn.OpenDBI("other", Create)
+		if err != nil {
+			return err
+		}
+		spill, err = txn.OpenDBI("spill", Create)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	drop, err := env.Subscribe(&SubscribeOptions{Buffer: 2, Overflow: OverflowDrop, DBIs: []DBI{dbi}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer drop.Close()
+	spilling, err := env.Subscribe(&SubscribeOptions{Buffer: 2, Overflow: OverflowSpill, Spill: spill, DBIs: []DBI{dbi}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spilling.Close()
+
+	for i := 0; i < 5; i++ {
+		err = env.Update(func(txn *Txn) error {
+			err := txn.Put(other, []byte("o"), []byte("o"), 0)
+			if err != nil {
+				return err
+			}
+			return txn.Put(dbi, []byte{'k'}, []byte{byte('0' + i)}, 0)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// commits to other databases are filtered out but consume sequence
+	// numbers; the gap marker starts at the first dropped commit.
+	evs := nextEvents(t, drop, 3)
+	want := []string{"put k=0;", "put k=1;", "gap 3@3"}
+	if fmt.Sprint(evs) != fmt.Sprint(want) {
+		t.Errorf("drop events %q, want %q", evs, want)
+	}
+	if n := drop.Dropped(); n != 3 {
+		t.Errorf("dropped %d", n)
+	}
+
+	evs = nextEvents(t, spilling, 5)
+	want = []string{"put k=0;", "put k=1;", "put k=2;", "put k=3;", "put k=4;"}
+	if fmt.Sprint(evs) != fmt.Sprint(want) {
+		t.Errorf("spill events %q, want %q", evs, want)
+	}
+	err = env.View(func(txn *Txn) error {
+		stat, err := txn.Stat(spill)
+		if err != nil {
+			return err
+		}
+		if stat.Entries != 0 {
+			t.Errorf("%d events left in the spill database", stat.Entries)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = env.Subscribe(&SubscribeOptions{Overflow: OverflowSpill})
+	if err == nil {
+		t.Error("expected an error without a spill database")
+	}
+}
+
+func TestSubscribe_block(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+
+	dbi, err := openRoot(env, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := env.Subscribe(&SubscribeOptions{Buffer: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	done := make(chan error)
+	go func() {
+		for i := 0; i < 3; i++ {
+			err := env.Update(func(txn *Txn) error {
+				return txn.Put(dbi, []byte{'k'}, []byte{byte('0' + i)}, 0)
+			})
+			if err != nil {
+				done <- err
+				return
+			}
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("writer did not block on a full buffer")
+	case <-time.After(50 * time.Millisecond):
+	}
+	evs := nextEvents(t, s, 3)
+	want := []string{"put k=0;", "put k=1;", "put k=2;"}
+	if fmt.Sprint(evs) != fmt.Sprint(want) {
+		t.Errorf("events %q, want %q", evs, want)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSubscribe_order(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+
+	dbi, err := openRoot(env, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := env.Subscribe(&SubscribeOptions{Buffer: 64})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	const writers, updates = 8, 2000
+	errc := make(chan error, 1)
+	go func() {
+		// keep reading after a violation, so that writers do not block.
+		var last Event
+		var order error
+		for i := 0; i < writers*updates; i++ {
+			ev, err := s.Next(context.Background())
+			if err != nil {
+				errc <- err
+				return
+			}
+			if i > 0 && order == nil && (ev.Seq <= last.Seq || ev.TxnID <= last.TxnID) {
+				order = fmt.Errorf("event seq %d txn %d after seq %d txn %d",
+					ev.Seq, ev.TxnID, last.Seq, last.TxnID)
+			}
+			last = ev
+		}
+		errc <- order
+	}()
+
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < updates; i++ {
+				err := env.Update(func(txn *Txn) error {
+					return txn.Put(dbi, []byte{byte(w)}, []byte(fmt.Sprint(i)), 0)
+				})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+}
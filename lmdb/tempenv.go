@@ -0,0 +1,65 @@
+package lmdb
+
+import (
+	"io/ioutil"
+	"os"
+	"runtime"
+)
+
+// TempEnvOptions configures an environment opened with OpenTempEnv.
+type TempEnvOptions struct {
+	// Dir is the directory in which the environment directory is created.
+	// If empty, /dev/shm is used on Linux when it is writable, so that the
+	// data stays in memory, and os.TempDir() otherwise.
+	Dir string
+
+	// MapSize is the size of the memory map, 1GB if zero.  Data written to
+	// a tmpfs counts against its size limit, usually half the memory.
+	MapSize int64
+
+	// MaxDBs is the maximum number of named databases.
+	MaxDBs int
+}
+
+// OpenTempEnv opens a throwaway environment in a new directory, for
+// algorithms that need more temporary sorted storage than comfortably fits
+// in the Go heap, such as external sorts and the visited sets of graph
+// traversals.  The environment is opened with NoSync, NoMetaSync and
+// WriteMap since its content need not survive a crash, and its directory is
+// removed when the environment is closed.
+func OpenTempEnv(opts *TempEnvOptions) (*Env, error) {
+	var o TempEnvOptions
+	if opts != nil {
+		o = *opts
+	}
+	if o.MapSize == 0 {
+		o.MapSize = 1 << 30
+	}
+	dir, err := tempEnvDir(o.Dir)
+	if err != nil {
+		return nil, err
+	}
+	env, err := OpenEnv(dir, &Options{
+		MaxDBs:  o.MaxDBs,
+		MapSize: o.MapSize,
+		Flags:   NoSync | NoMetaSync | WriteMap,
+		Mode:    0600,
+	})
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	env.tempDir = dir
+	return env, nil
+}
+
+// tempEnvDir creates the directory of a temporary environment in parent.
+func tempEnvDir(parent string) (string, error) {
+	if parent == "" && runtime.GOOS == "linux" {
+		dir, err := ioutil.TempDir("/dev/shm", "lmdb-temp-")
+		if err == nil {
+			return dir, nil
+		}
+	}
+	return ioutil.TempDir(parent, "lmdb-temp-")
+}
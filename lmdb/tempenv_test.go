@@ -0,0 +1,61 @@
+package lmdb
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestOpenTempEnv(t *testing.T) {
+	parent, err := ioutil.TempDir("", "mdb_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(parent)
+
+	env, err := OpenTempEnv(&TempEnvOptions{Dir: parent, MaxDBs: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path, err := env.Path()
+	if err != nil {
+		t.Fatal(err)
+	}
+	flags, err := env.Flags()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flags&NoSync == 0 {
+		t.Errorf("flags %#x lack NoSync", flags)
+	}
+	err = env.Update(func(txn *Txn) (err error) {
+		dbi, err := txn.OpenDBI("spill", Create)
+		if err != nil {
+			return err
+		}
+		return txn.Put(dbi, []byte("k"), []byte("v"), 0)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = env.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = os.Stat(path)
+	if !os.IsNotExist(err) {
+		t.Errorf("temporary environment not removed: %v", err)
+	}
+
+	// the default location is used when Dir is empty.
+	env, err = OpenTempEnv(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.Close()
+}
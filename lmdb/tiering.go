@@ -0,0 +1,702 @@
+package lmdb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultTierWindow is the time after which keys neither written nor read
+// are archived when TierOptions.Window is zero.
+const DefaultTierWindow = 30 * 24 * time.Hour
+
+// tierLabel labels the transactions of a Tiering deleting the keys it
+// archived, so that their deletions are not propagated to the archive.
+const tierLabel = "lmdb.tiering"
+
+var errTieringClosed = errors.New("tiering is closed")
+var errTierDupSort = errors.New("tiering: DupSort databases are not supported")
+
+// TierOptions configures a Tiering.
+type TierOptions struct {
+	// DBIs are the databases of the primary environment whose cold keys are
+	// archived.  They must have been opened by name, and must not use
+	// DupSort.
+	DBIs []DBI
+
+	// Window is the time after which a key neither written nor read
+	// through Tiering.Get is cold, DefaultTierWindow if zero.
+	Window time.Duration
+
+	// Batch is the number of keys moved per pair of transactions,
+	// DefaultExportBatch if zero.
+	Batch int
+
+	// MaxKeys bounds the number of keys whose last access is kept per
+	// database, 100000 if zero.  While a database is full, the keys whose
+	// access is not kept are considered last accessed at the latest such
+	// access, so that none of them is archived early.
+	MaxKeys int
+
+	// Interval, if positive, makes the Tiering migrate cold keys in the
+	// background at that interval.  Otherwise migrations are made by
+	// calling Migrate.
+	Interval time.Duration
+
+	// CompressLevel, if not zero, compresses the archived values with
+	// CompressStage at that level, replacing the codec chains of the
+	// databases of the archive.  Otherwise their chains, if any, apply.
+	CompressLevel int
+}
+
+// TierStats are the counters of a Tiering.
+type TierStats struct {
+	Passes    uint64 // migrations made
+	Moved     uint64 // keys moved to the archive
+	Bytes     uint64 // bytes of keys and values moved, before compression
+	Tracked   int    // keys whose last access is known
+	Untracked uint64 // accesses not kept, see TierOptions.MaxKeys
+}
+
+// Tiering keeps the map of an environment small and hot by moving the keys
+// of some of its databases that are neither written nor read for a window
+// of time to the databases of the same names of an archive environment,
+// e.g. on cheaper storage, opened with NoSync and compressing values, see
+// TierOptions.CompressLevel.  Archived keys are read through Get and Scan,
+// which look up the archive for keys absent from the primary environment.
+//
+// The time of the last access of each key is kept in memory, for up to
+// TierOptions.MaxKeys keys per database written or read through Get since
+// the Tiering started; other keys are considered last accessed when it
+// started, or when an access was last not kept, so that nothing is archived
+// before a whole window has elapsed.  Writes are followed with a
+// Subscription, which slows writers down if the Tiering falls behind.
+//
+// A key written again after being archived is read from the primary
+// environment, its archived copy being replaced when it is archived again.
+// Archived keys are deleted through Del.  Deletions are propagated to the
+// archive shortly after they commit, during which Get and Scan still return
+// the archived copies.
+// Keys are compared bytewise, so the databases must not use IntegerKey,
+// ReverseKey or custom comparison functions.
+type Tiering struct {
+	env, archive *Env
+	opts         TierOptions
+	dbis         map[DBI]DBI // archive database of each primary database
+	sub          *Subscription
+	started      int64
+	stop         chan struct{}
+	done         chan struct{}
+	ticker       chan struct{} // closed once the background migrations stop
+
+	migrateMu sync.Mutex // serializes migrations
+
+	mu     sync.Mutex
+	access map[DBI]map[string]int64 // Unix nanoseconds of the last access
+	full   map[DBI]int64            // last access not kept in access
+	stats  TierStats
+	err    error
+	closed bool
+}
+
+// NewTiering starts archiving the cold keys of env to archive.  The
+// databases of the archive are created as needed.
+func (env *Env) NewTiering(archive *Env, opts *TierOptions) (*Tiering, error) {
+	t := &Tiering{
+		env:     env,
+		archive: archive,
+		dbis:    make(map[DBI]DBI),
+		started: time.Now().UnixNano(),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+		ticker:  make(chan struct{}),
+		access:  make(map[DBI]map[string]int64),
+		full:    make(map[DBI]int64),
+	}
+	if opts != nil {
+		t.opts = *opts
+	}
+	if t.opts.Window <= 0 {
+		t.opts.Window = DefaultTierWindow
+	}
+	if t.opts.Batch <= 0 {
+		t.opts.Batch = DefaultExportBatch
+	}
+	if t.opts.MaxKeys <= 0 {
+		t.opts.MaxKeys = 100000
+	}
+	var compress CodecStage
+	if t.opts.CompressLevel != 0 {
+		var err error
+		compress, err = CompressStage(t.opts.CompressLevel)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := archive.Update(func(txn *Txn) error {
+		for _, dbi := range t.opts.DBIs {
+			n, ok := env.dbiName(dbi)
+			if !ok {
+				return fmt.Errorf("tiering: dbi %d was not opened by name", dbi)
+			}
+			if n.flags&DupSort != 0 {
+				return errTierDupSort
+			}
+			var adbi DBI
+			var err error
+			if n.name == "" {
+				adbi, err = txn.OpenRoot(0)
+			} else {
+				adbi, err = txn.OpenDBI(n.name, Create)
+			}
+			if err != nil {
+				return err
+			}
+			t.dbis[dbi] = adbi
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if t.opts.CompressLevel != 0 {
+		for _, adbi := range t.dbis {
+			if err := archive.SetCodecs(adbi, compress); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	sub, err := env.Subscribe(&SubscribeOptions{Overflow: OverflowBlock, DBIs: t.opts.DBIs})
+	if err != nil {
+		return nil, err
+	}
+	t.sub = sub
+	done, ok := env.register("tiering", t.shutdown)
+	if !ok {
+		sub.Close()
+		return nil, errGoClosed
+	}
+	go func() {
+		defer done()
+		t.follow()
+	}()
+	if t.opts.Interval > 0 {
+		done, ok := env.register("tiering-migrate", t.shutdown)
+		if !ok {
+			close(t.ticker)
+			t.shutdown()
+			return nil, errGoClosed
+		}
+		go func() {
+			defer done()
+			t.migrateEvery(t.opts.Interval)
+		}()
+	} else {
+		close(t.ticker)
+	}
+	return t, nil
+}
+
+// follow records the writes committed to the primary environment, and
+// propagates the deletions of keys to the archive.
+func (t *Tiering) follow() {
+	defer close(t.done)
+	for {
+		ev, err := t.sub.Next(context.Background())
+		if err != nil {
+			return
+		}
+		if _, ok := ev.Labels[tierLabel]; ok {
+			continue
+		}
+		if err := t.record(&ev); err != nil {
+			t.setErr(err)
+		}
+	}
+}
+
+// record notes the writes of the commit ev, and deletes the keys it deletes
+// from the archive.
+func (t *Tiering) record(ev *Event) error {
+	now := ev.Time.UnixNano()
+	var dels []BatchOp
+	t.mu.Lock()
+	for _, op := range ev.Ops {
+		access := t.accessed(op.DBI)
+		switch op.Type {
+		case BatchPut:
+			t.note(op.DBI, op.Key, now)
+		case BatchDel:
+			delete(access, string(op.Key))
+			dels = append(dels, op)
+		case BatchDropRange:
+			for k := range access {
+				if k >= string(op.Key) && (op.End == nil || k < string(op.End)) {
+					delete(access, k)
+				}
+			}
+			dels = append(dels, op)
+		}
+	}
+	t.mu.Unlock()
+	if len(dels) == 0 {
+		return nil
+	}
+	return t.archive.Update(func(txn *Txn) error {
+		for _, op := range dels {
+			adbi := t.dbis[op.DBI]
+			if op.Type == BatchDel {
+				err := txn.Del(adbi, op.Key, nil)
+				if err != nil && !IsNotFound(err) {
+					return err
+				}
+				continue
+			}
+			if err := dropArchived(txn, adbi, op.Key, op.End); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// dropArchived deletes the keys of dbi in [start, end), end being unbounded
+// if nil.
+func dropArchived(txn *Txn, dbi DBI, start, end []byte) error {
+	cur, err := txn.OpenCursor(dbi)
+	if err != nil {
+		return err
+	}
+	defer cur.Close()
+	k, _, err := cur.Get(start, nil, seekOp(start))
+	for ; err == nil; k, _, err = cur.Get(nil, nil, Next) {
+		if end != nil && bytes.Compare(k, end) >= 0 {
+			return nil
+		}
+		if err = cur.Del(0); err != nil {
+			return err
+		}
+	}
+	if IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// seekOp returns the cursor operation positioning a cursor at the first key
+// not less than start, nil being the first key.
+func seekOp(start []byte) uint {
+	if start == nil {
+		return First
+	}
+	return SetRange
+}
+
+// accessed returns the access times of dbi.  t.mu must be held.
+func (t *Tiering) accessed(dbi DBI) map[string]int64 {
+	access := t.access[dbi]
+	if access == nil {
+		access = make(map[string]int64)
+		t.access[dbi] = access
+	}
+	return access
+}
+
+// note records an access to key at now, or raises the access time of the
+// keys not tracked if dbi already tracks MaxKeys keys.  t.mu must be held.
+func (t *Tiering) note(dbi DBI, key []byte, now int64) {
+	access := t.accessed(dbi)
+	if _, ok := access[string(key)]; !ok && len(access) >= t.opts.MaxKeys {
+		if now > t.full[dbi] {
+			t.full[dbi] = now
+		}
+		t.stats.Untracked++
+		return
+	}
+	access[string(key)] = now
+}
+
+// untracked returns the time of the last access assumed for the keys of dbi
+// not tracked.  t.mu must be held.
+func (t *Tiering) untracked(dbi DBI) int64 {
+	if full := t.full[dbi]; full > t.started {
+		return full
+	}
+	return t.started
+}
+
+// cold reports whether key was last accessed before cutoff.  t.mu must be
+// held.
+func (t *Tiering) cold(dbi DBI, key []byte, cutoff int64) bool {
+	last, ok := t.access[dbi][string(key)]
+	if !ok {
+		last = t.untracked(dbi)
+	}
+	return last < cutoff
+}
+
+func (t *Tiering) touch(dbi DBI, key []byte) {
+	now := time.Now().UnixNano()
+	t.mu.Lock()
+	t.note(dbi, key, now)
+	t.mu.Unlock()
+}
+
+func (t *Tiering) setErr(err error) {
+	t.mu.Lock()
+	if t.err == nil {
+		t.err = err
+	}
+	t.mu.Unlock()
+}
+
+// Err returns the first error met by the Tiering in the background, while
+// propagating deletions or migrating keys.
+func (t *Tiering) Err() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.err
+}
+
+// Stats returns the counters of t.
+func (t *Tiering) Stats() TierStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := t.stats
+	for _, access := range t.access {
+		s.Tracked += len(access)
+	}
+	return s
+}
+
+// Get returns the value of key in dbi as seen by txn, a transaction of the
+// primary environment, or its archived value if the key is absent from it.
+// Archived values are copied.  Reading a key from the primary environment
+// counts as an access; archived keys stay archived until written again.
+func (t *Tiering) Get(txn *Txn, dbi DBI, key []byte) ([]byte, error) {
+	v, err := txn.Get(dbi, key)
+	if err == nil {
+		t.touch(dbi, key)
+		return v, nil
+	}
+	adbi, ok := t.dbis[dbi]
+	if !IsNotFound(err) || !ok {
+		return nil, err
+	}
+	err = t.archive.View(func(atxn *Txn) error {
+		atxn.RawRead = true
+		v, err = atxn.GetDecoded(adbi, key)
+		if err == nil {
+			v = cloneBytes(v)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// Del deletes key from dbi through txn, a write transaction of the primary
+// environment, whether the key is archived or not.  The deletion of an
+// archived key, absent from the primary environment, is recorded by a Put
+// and a Del of the key in txn, so that it reaches the archive if txn
+// commits.  A key found in neither environment is reported as by Txn.Del.
+func (t *Tiering) Del(txn *Txn, dbi DBI, key []byte) error {
+	err := txn.Del(dbi, key, nil)
+	adbi, ok := t.dbis[dbi]
+	if !IsNotFound(err) || !ok {
+		return err
+	}
+	aerr := t.archive.View(func(atxn *Txn) error {
+		atxn.RawRead = true
+		_, err := atxn.Get(adbi, key)
+		return err
+	})
+	if IsNotFound(aerr) {
+		return err
+	}
+	if aerr != nil {
+		return aerr
+	}
+	if err = txn.Put(dbi, key, nil, 0); err != nil {
+		return err
+	}
+	return txn.Del(dbi, key, nil)
+}
+
+// Scan calls fn with the keys of dbi in [start, end), end being unbounded if
+// nil, and their values, in key order, those of txn, a transaction of the
+// primary environment, prevailing over the archived ones.  The slices
+// passed to fn are valid until it returns.  An error returned by fn stops
+// the scan and is returned.
+func (t *Tiering) Scan(txn *Txn, dbi DBI, start, end []byte, fn func(k, v []byte) error) error {
+	adbi, ok := t.dbis[dbi]
+	if !ok {
+		return fmt.Errorf("tiering: dbi %d is not tiered", dbi)
+	}
+	cur, err := txn.OpenCursor(dbi)
+	if err != nil {
+		return err
+	}
+	defer cur.Close()
+	return t.archive.View(func(atxn *Txn) error {
+		acur, err := atxn.OpenCursor(adbi)
+		if err != nil {
+			return err
+		}
+		defer acur.Close()
+		var p, a mergeHead
+		set := func(h *mergeHead, k, v []byte, err error) error {
+			*h = mergeHead{}
+			if IsNotFound(err) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if end == nil || bytes.Compare(k, end) < 0 {
+				*h = mergeHead{key: k, val: v, ok: true}
+			}
+			return nil
+		}
+		k, v, err := cur.Get(start, nil, seekOp(start))
+		if err = set(&p, k, v, err); err != nil {
+			return err
+		}
+		k, v, err = acur.GetDecoded(start, nil, seekOp(start))
+		if err = set(&a, k, v, err); err != nil {
+			return err
+		}
+		for p.ok || a.ok {
+			c := -1
+			if !p.ok {
+				c = 1
+			} else if a.ok {
+				c = bytes.Compare(p.key, a.key)
+			}
+			if c <= 0 {
+				err = fn(p.key, p.val)
+			} else {
+				err = fn(a.key, a.val)
+			}
+			if err != nil {
+				return err
+			}
+			if c <= 0 {
+				k, v, err = cur.Get(nil, nil, Next)
+				if err = set(&p, k, v, err); err != nil {
+					return err
+				}
+			}
+			if c >= 0 {
+				k, v, err = acur.GetDecoded(nil, nil, Next)
+				if err = set(&a, k, v, err); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// Migrate moves the cold keys of the tiered databases to the archive, and
+// returns the number of keys moved.  Each batch of keys is written to the
+// archive, then deleted from the primary environment unless changed
+// meanwhile, so that an interrupted migration leaves keys in both
+// environments, to be deleted by the next one.
+func (t *Tiering) Migrate() (int, error) {
+	t.migrateMu.Lock()
+	defer t.migrateMu.Unlock()
+	t.mu.Lock()
+	closed := t.closed
+	t.mu.Unlock()
+	if closed {
+		return 0, errTieringClosed
+	}
+	cutoff := time.Now().Add(-t.opts.Window).UnixNano()
+	moved := 0
+	for _, dbi := range t.opts.DBIs {
+		n, err := t.migrateDB(dbi, cutoff)
+		moved += n
+		if err != nil {
+			return moved, err
+		}
+	}
+	t.mu.Lock()
+	t.stats.Passes++
+	// keys accessed before the cutoff are cold either way.
+	for _, access := range t.access {
+		for k, last := range access {
+			if last < cutoff {
+				delete(access, k)
+			}
+		}
+	}
+	t.mu.Unlock()
+	return moved, nil
+}
+
+func (t *Tiering) migrateDB(dbi DBI, cutoff int64) (int, error) {
+	adbi := t.dbis[dbi]
+	// the keys are selected from a copy of the access times, so that
+	// writers, followed with OverflowBlock, are not held up by the scan.
+	// Keys accessed since are spared when deleted from the primary
+	// environment below.
+	t.mu.Lock()
+	access := make(map[string]int64, len(t.access[dbi]))
+	for k, last := range t.access[dbi] {
+		access[k] = last
+	}
+	untracked := t.untracked(dbi)
+	t.mu.Unlock()
+	cold := func(k []byte) bool {
+		last, ok := access[string(k)]
+		if !ok {
+			last = untracked
+		}
+		return last < cutoff
+	}
+	var from []byte
+	moved := 0
+	for {
+		var items []KV
+		var more bool
+		err := t.env.View(func(txn *Txn) error {
+			cur, err := txn.OpenCursor(dbi)
+			if err != nil {
+				return err
+			}
+			defer cur.Close()
+			k, v, err := cur.Get(from, nil, seekOp(from))
+			for ; err == nil; k, v, err = cur.Get(nil, nil, Next) {
+				if len(items) == t.opts.Batch {
+					more = true
+					from = cloneBytes(k)
+					return nil
+				}
+				if cold(k) {
+					items = append(items, KV{Key: cloneBytes(k), Val: cloneBytes(v)})
+				}
+			}
+			if IsNotFound(err) {
+				return nil
+			}
+			return err
+		})
+		if err != nil || len(items) == 0 {
+			return moved, err
+		}
+
+		err = t.archive.Update(func(txn *Txn) error {
+			for _, item := range items {
+				if err := txn.PutEncoded(adbi, item.Key, item.Val, 0); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return moved, err
+		}
+
+		// keys deleted since they were read are deleted from the archive
+		// too, in case their deletion was propagated before they were
+		// archived.
+		var gone [][]byte
+		var bytesMoved uint64
+		n := 0
+		ctx := WithLabels(context.Background(), Labels{tierLabel: "migrate"})
+		err = t.env.UpdateContext(ctx, func(txn *Txn) error {
+			gone, bytesMoved, n = nil, 0, 0
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			for _, item := range items {
+				v, err := txn.Get(dbi, item.Key)
+				if IsNotFound(err) {
+					gone = append(gone, item.Key)
+					continue
+				}
+				if err != nil {
+					return err
+				}
+				if !bytes.Equal(v, item.Val) || !t.cold(dbi, item.Key, cutoff) {
+					continue
+				}
+				if err = txn.Del(dbi, item.Key, nil); err != nil {
+					return err
+				}
+				n++
+				bytesMoved += uint64(len(item.Key) + len(item.Val))
+			}
+			return nil
+		})
+		if err != nil {
+			return moved, err
+		}
+		moved += n
+		t.mu.Lock()
+		t.stats.Moved += uint64(n)
+		t.stats.Bytes += bytesMoved
+		t.mu.Unlock()
+		if len(gone) > 0 {
+			err = t.archive.Update(func(txn *Txn) error {
+				for _, k := range gone {
+					err := txn.Del(adbi, k, nil)
+					if err != nil && !IsNotFound(err) {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				return moved, err
+			}
+		}
+		if !more {
+			return moved, nil
+		}
+	}
+}
+
+func (t *Tiering) migrateEvery(interval time.Duration) {
+	defer close(t.ticker)
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-t.stop:
+			return
+		case <-tick.C:
+			if _, err := t.Migrate(); err != nil && err != errTieringClosed {
+				t.setErr(err)
+			}
+		}
+	}
+}
+
+// shutdown stops the background work of t.
+func (t *Tiering) shutdown() {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return
+	}
+	t.closed = true
+	t.mu.Unlock()
+	close(t.stop)
+	t.sub.Close()
+}
+
+// Close stops the Tiering, after the writes already committed are followed.
+// The archive environment is not closed.
+func (t *Tiering) Close() error {
+	t.shutdown()
+	<-t.done
+	<-t.ticker
+	return t.Err()
+}
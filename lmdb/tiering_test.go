@@ -0,0 +1,289 @@
+package lmdb
+
+import (
+	"fmt"
+	"io/ioutil"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEnv_NewTiering(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+	archive := setup(t)
+	defer clean(archive, t)
+
+	var dbi DBI
+	err := env.Update(func(txn *Txn) (err error) {
+		dbi, err = txn.OpenDBI("tiered", Create)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 10; i++ {
+			if err = txn.Put(dbi, []byte(fmt.Sprint("k", i)), []byte(strings.Repeat("v", 100)), 0); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	window := 20 * time.Millisecond
+	tiering, err := env.NewTiering(archive, &TierOptions{
+		DBIs:          []DBI{dbi},
+		Window:        window,
+		Batch:         3,
+		CompressLevel: 9,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tiering.Close()
+
+	// nothing is cold before a whole window.
+	if n, err := tiering.Migrate(); err != nil || n != 0 {
+		t.Fatalf("early migration moved %d keys: %v", n, err)
+	}
+	time.Sleep(window)
+	err = env.Update(func(txn *Txn) error {
+		if err := txn.Put(dbi, []byte("k1"), []byte("hot"), 0); err != nil {
+			return err
+		}
+		_, err := tiering.Get(txn, dbi, []byte("k2"))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// wait for the write to be followed.
+	for tiering.Stats().Tracked < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	n, err := tiering.Migrate()
+	if err != nil || n != 8 {
+		t.Fatalf("migration moved %d keys: %v", n, err)
+	}
+	if s := tiering.Stats(); s.Moved != 8 || s.Bytes != 8*102 || s.Passes != 2 {
+		t.Errorf("stats %+v", s)
+	}
+
+	var primary []string
+	err = env.View(func(txn *Txn) error {
+		cur, err := txn.OpenCursor(dbi)
+		if err != nil {
+			return err
+		}
+		defer cur.Close()
+		for k, _, err := cur.Get(nil, nil, First); err == nil; k, _, err = cur.Get(nil, nil, Next) {
+			primary = append(primary, string(k))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(primary, " "); got != "k1 k2" {
+		t.Errorf("primary keys %s", got)
+	}
+
+	err = env.Update(func(txn *Txn) error {
+		v, err := tiering.Get(txn, dbi, []byte("k5"))
+		if err != nil || len(v) != 100 {
+			t.Errorf("archived value %q: %v", v, err)
+		}
+		if _, err = tiering.Get(txn, dbi, []byte("k10")); !IsNotFound(err) {
+			t.Errorf("missing key: %v", err)
+		}
+		var keys []string
+		err = tiering.Scan(txn, dbi, []byte("k1"), []byte("k5"), func(k, v []byte) error {
+			keys = append(keys, fmt.Sprintf("%s:%d", k, len(v)))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if got := strings.Join(keys, " "); got != "k1:3 k2:100 k3:100 k4:100" {
+			t.Errorf("scanned %s", got)
+		}
+		return tiering.Del(txn, dbi, []byte("k2"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = env.Update(func(txn *Txn) error {
+		if err := tiering.Del(txn, dbi, []byte("k3")); err != nil {
+			return err
+		}
+		return tiering.Del(txn, dbi, []byte("k10"))
+	})
+	if !IsNotFound(err) {
+		t.Fatalf("deletion of a missing key: %v", err)
+	}
+	err = env.Update(func(txn *Txn) error {
+		return tiering.Del(txn, dbi, []byte("k3"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// deletions reach the archive.
+	if err := tiering.Close(); err != nil {
+		t.Fatal(err)
+	}
+	err = archive.View(func(txn *Txn) error {
+		adbi, err := txn.OpenDBI("tiered", 0)
+		if err != nil {
+			return err
+		}
+		stat, err := txn.Stat(adbi)
+		if err != nil {
+			return err
+		}
+		if stat.Entries != 7 {
+			t.Errorf("%d archived keys", stat.Entries)
+		}
+		_, err = txn.Get(adbi, []byte("k3"))
+		if !IsNotFound(err) {
+			t.Errorf("deleted key archived: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tiering.Migrate(); err != errTieringClosed {
+		t.Errorf("migration after close: %v", err)
+	}
+}
+
+func TestEnv_NewTiering_dupSort(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+	archive := setup(t)
+	defer clean(archive, t)
+
+	var dbi DBI
+	err := env.Update(func(txn *Txn) (err error) {
+		dbi, err = txn.OpenDBI("dups", Create|DupSort)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := env.NewTiering(archive, &TierOptions{DBIs: []DBI{dbi}}); err != errTierDupSort {
+		t.Errorf("tiering of a DupSort database: %v", err)
+	}
+}
+
+func TestEnv_NewTiering_maxKeys(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+	archive := setup(t)
+	defer clean(archive, t)
+
+	var dbi DBI
+	err := env.Update(func(txn *Txn) (err error) {
+		dbi, err = txn.OpenDBI("tiered", Create)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 10; i++ {
+			if err = txn.Put(dbi, []byte(fmt.Sprint("k", i)), []byte("v"), 0); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	window := 20 * time.Millisecond
+	tiering, err := env.NewTiering(archive, &TierOptions{
+		DBIs:    []DBI{dbi},
+		Window:  window,
+		MaxKeys: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tiering.Close()
+
+	time.Sleep(window)
+	err = env.View(func(txn *Txn) error {
+		for i := 0; i < 4; i++ {
+			if _, err := tiering.Get(txn, dbi, []byte(fmt.Sprint("k", i))); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := tiering.Stats(); s.Tracked != 2 || s.Untracked != 2 {
+		t.Errorf("stats %+v", s)
+	}
+	// the accesses not kept spare every key not tracked.
+	if n, err := tiering.Migrate(); err != nil || n != 0 {
+		t.Fatalf("migration moved %d keys: %v", n, err)
+	}
+	time.Sleep(window)
+	if n, err := tiering.Migrate(); err != nil || n != 10 {
+		t.Fatalf("migration moved %d keys: %v", n, err)
+	}
+}
+
+func TestTiering_Del_archiveError(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+	archive, err := NewEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path, err := ioutil.TempDir("", "mdb_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = archive.SetMaxDBs(1); err != nil {
+		t.Fatal(err)
+	}
+	if err = archive.SetMaxReaders(1); err != nil {
+		t.Fatal(err)
+	}
+	if err = archive.Open(path, 0, 0664); err != nil {
+		t.Fatal(err)
+	}
+	defer clean(archive, t)
+
+	var dbi DBI
+	err = env.Update(func(txn *Txn) (err error) {
+		dbi, err = txn.OpenDBI("tiered", Create)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tiering, err := env.NewTiering(archive, &TierOptions{DBIs: []DBI{dbi}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tiering.Close()
+
+	// the only reader slot of the archive is taken.
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	rtxn, err := archive.BeginTxn(nil, Readonly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rtxn.Abort()
+	err = env.Update(func(txn *Txn) error {
+		return tiering.Del(txn, dbi, []byte("k"))
+	})
+	if !IsErrno(err, ReadersFull) {
+		t.Errorf("deletion with a failing archive: %v", err)
+	}
+}
@@ -0,0 +1,340 @@
+package lmdb
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// The trace encoding records the changes committed to an environment so they
+// can be replayed into another one.  The layout is
+//
+//	magic    "LMTR"
+//	version  1 byte
+//	records  until EOF:
+//		type     1 byte
+//		length   uvarint
+//		payload  length bytes
+//
+// A 'd' record declares a database before its first change, its payload
+// being the name (uvarint length + bytes) and the flags (uvarint) of the
+// database.  A 'c' record is a commit, its payload being the commit number
+// (uvarint, starting at 1), the commit time in Unix nanoseconds (uvarint)
+// and the changes as a changeset (uvarint length + bytes).
+const (
+	traceMagic   = "LMTR"
+	traceVersion = 1
+
+	traceDBI    = 'd'
+	traceCommit = 'c'
+)
+
+var (
+	errTraceMagic  = errors.New("trace: bad magic")
+	errTraceRecord = errors.New("trace: unknown record type")
+)
+
+// dbiNames remembers the names and flags of the databases opened in an Env,
+// which traces need because DBI handles are local to the Env.
+type dbiNames struct {
+	mu    sync.Mutex
+	names map[DBI]dbiName
+}
+
+type dbiName struct {
+	name  string
+	flags uint
+}
+
+// noteDBI records the name of a database opened by txn.
+func (txn *Txn) noteDBI(dbi DBI, name string) {
+	flags, err := txn.Flags(dbi)
+	if err != nil {
+		return
+	}
+	d := &txn.env.dbiNames
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.names == nil {
+		d.names = make(map[DBI]dbiName)
+	}
+	d.names[dbi] = dbiName{name: name, flags: flags}
+}
+
+func (env *Env) dbiName(dbi DBI) (dbiName, bool) {
+	d := &env.dbiNames
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	n, ok := d.names[dbi]
+	return n, ok
+}
+
+// Tracer records the changes committed to an environment, see Env.Trace.
+type Tracer struct {
+	env  *Env
+	sub  *Subscription
+	w    *bufio.Writer
+	done chan struct{}
+
+	mu      sync.Mutex
+	commits uint64
+	err     error
+
+	declared map[DBI]bool
+	buf      []byte
+}
+
+// Trace starts recording every change committed to env from now on to w,
+// until the returned Tracer is stopped or env is closed.  Replay re-executes a
+// trace against a fresh environment, which reproduces the state of env
+// commit by commit, so that a trace captured from an application can be
+// taken to a debugger, or replayed up to the commit preceding a problem.
+//
+// Changes are recorded as they are published to subscriptions, see
+// Subscribe, once their transaction commits: a trace holds committed writes
+// made through Txn and Cursor, in commit order, and neither reads nor
+// aborted transactions, which leave no trace in the database.  Transactions
+// already running when Trace is called are not recorded.  Recording is done
+// by a background goroutine, and writers wait for it when it falls behind
+// by more than a buffer of commits, so a trace is complete.
+func (env *Env) Trace(w io.Writer) (*Tracer, error) {
+	t := &Tracer{
+		env:      env,
+		w:        bufio.NewWriter(w),
+		done:     make(chan struct{}),
+		declared: make(map[DBI]bool),
+	}
+	t.w.WriteString(traceMagic)
+	t.w.WriteByte(traceVersion)
+	sub, err := env.Subscribe(&SubscribeOptions{Overflow: OverflowBlock})
+	if err != nil {
+		return nil, err
+	}
+	t.sub = sub
+	done, ok := env.register("tracer", func() { sub.Close() })
+	if !ok {
+		sub.Close()
+		return nil, errGoClosed
+	}
+	go func() {
+		defer done()
+		t.run()
+	}()
+	return t, nil
+}
+
+func (t *Tracer) run() {
+	defer close(t.done)
+	for {
+		ev, err := t.sub.Next(context.Background())
+		if err == ErrSubscriptionClosed {
+			t.setErr(t.w.Flush())
+			return
+		}
+		if err == nil {
+			err = t.record(&ev)
+		}
+		if err != nil {
+			t.setErr(err)
+			t.sub.Close()
+		}
+	}
+}
+
+func (t *Tracer) setErr(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.err == nil {
+		t.err = err
+	}
+}
+
+// record writes the commit ev, declaring the databases it changes first.
+func (t *Tracer) record(ev *Event) error {
+	if t.Err() != nil {
+		return nil
+	}
+	names := make(map[DBI]string)
+	for _, op := range ev.Ops {
+		n, ok := t.env.dbiName(op.DBI)
+		if !ok {
+			return fmt.Errorf("trace: no name for dbi %d", op.DBI)
+		}
+		names[op.DBI] = n.name
+		if t.declared[op.DBI] {
+			continue
+		}
+		t.declared[op.DBI] = true
+		t.buf = appendChunk(t.buf[:0], []byte(n.name))
+		t.buf = appendUvarint(t.buf, uint64(n.flags))
+		err := t.writeRecord(traceDBI, t.buf)
+		if err != nil {
+			return err
+		}
+	}
+	cs, err := ev.Batch().Marshal(names)
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	t.commits++
+	seq := t.commits
+	t.mu.Unlock()
+	t.buf = appendUvarint(t.buf[:0], seq)
+	t.buf = appendUvarint(t.buf, uint64(ev.Time.UnixNano()))
+	t.buf = appendChunk(t.buf, cs)
+	return t.writeRecord(traceCommit, t.buf)
+}
+
+func (t *Tracer) writeRecord(typ byte, payload []byte) error {
+	var hdr [1 + binary.MaxVarintLen64]byte
+	hdr[0] = typ
+	n := binary.PutUvarint(hdr[1:], uint64(len(payload)))
+	_, err := t.w.Write(hdr[:1+n])
+	if err != nil {
+		return err
+	}
+	_, err = t.w.Write(payload)
+	return err
+}
+
+// Commits returns the number of commits recorded.
+func (t *Tracer) Commits() uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.commits
+}
+
+// Err returns the error that stopped the recording, if any.  A trace cut
+// short by an error is valid up to its last complete commit.
+func (t *Tracer) Err() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.err
+}
+
+// Stop stops recording, waits for the commits already published to be
+// written and flushes them to the writer.  It returns the error that stopped
+// the recording, if any.  Stop does not close the writer.
+func (t *Tracer) Stop() error {
+	t.sub.Close()
+	<-t.done
+	return t.Err()
+}
+
+// ReplayOptions controls Replay.
+type ReplayOptions struct {
+	// Until stops the replay after the commit numbered Until, the first
+	// commit of a trace being 1.  If zero the whole trace is replayed.
+	Until uint64
+
+	// OnCommit, if not nil, is called after each commit replayed, with its
+	// number and the time it was recorded.
+	OnCommit func(commit uint64, recorded time.Time)
+}
+
+// Replay re-executes the commits recorded in the trace r by Env.Trace
+// against env, each in its own update transaction, and returns the number
+// of the last commit replayed.  env is normally a fresh environment, with
+// as many named databases as the traced one; databases are created with the
+// flags they had when traced.  A trace truncated in the middle of a record,
+// as left by a process that died, is replayed up to its last complete
+// commit without error.
+func Replay(env *Env, r io.Reader, opts *ReplayOptions) (uint64, error) {
+	var o ReplayOptions
+	if opts != nil {
+		o = *opts
+	}
+	br := bufio.NewReader(r)
+	hdr := make([]byte, len(traceMagic)+1)
+	_, err := io.ReadFull(br, hdr)
+	if err != nil {
+		return 0, err
+	}
+	if string(hdr[:len(traceMagic)]) != traceMagic {
+		return 0, errTraceMagic
+	}
+	if hdr[len(traceMagic)] != traceVersion {
+		return 0, fmt.Errorf("trace: unsupported version %d", hdr[len(traceMagic)])
+	}
+
+	dbis := make(map[string]DBI)
+	var last uint64
+	var payload bytes.Buffer
+	for o.Until == 0 || last < o.Until {
+		typ, err := br.ReadByte()
+		if err == io.EOF {
+			return last, nil
+		}
+		if err != nil {
+			return last, err
+		}
+		size, err := binary.ReadUvarint(br)
+		if err == nil {
+			payload.Reset()
+			var n int64
+			n, err = io.CopyN(&payload, br, int64(size))
+			if err == io.EOF && n < int64(size) {
+				err = io.ErrUnexpectedEOF
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return last, nil
+		}
+		if err != nil {
+			return last, err
+		}
+		rec := changesetReader{data: payload.Bytes()}
+		switch typ {
+		case traceDBI:
+			name := string(rec.chunk())
+			flags := uint(rec.uvarint())
+			if rec.err != nil {
+				return last, rec.err
+			}
+			err = env.Update(func(txn *Txn) (err error) {
+				if name == "" {
+					dbis[name], err = txn.OpenRoot(flags)
+				} else {
+					dbis[name], err = txn.OpenDBI(name, flags|Create)
+				}
+				return err
+			})
+		case traceCommit:
+			seq := rec.uvarint()
+			nanos := rec.uvarint()
+			cs := rec.chunk()
+			if rec.err != nil {
+				return last, rec.err
+			}
+			err = replayCommit(env, cs, dbis)
+			if err == nil {
+				last = seq
+				if o.OnCommit != nil {
+					o.OnCommit(seq, time.Unix(0, int64(nanos)))
+				}
+			}
+		default:
+			err = errTraceRecord
+		}
+		if err != nil {
+			return last, err
+		}
+	}
+	return last, nil
+}
+
+func replayCommit(env *Env, cs []byte, dbis map[string]DBI) error {
+	var b WriteBatch
+	err := b.Unmarshal(cs, dbis)
+	if err != nil {
+		return err
+	}
+	return env.Apply(&b)
+}
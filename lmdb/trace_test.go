@@ -0,0 +1,229 @@
+package lmdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEnv_Trace(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+
+	var trace bytes.Buffer
+	tr, err := env.Trace(&trace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dbi, dups DBI
+	err = env.Update(func(txn *Txn) (err error) {
+		dbi, err = txn.OpenDBI("items", Create)
+		if err != nil {
+			return err
+		}
+		dups, err = txn.OpenDBI("dups", Create|DupSort)
+		if err != nil {
+			return err
+		}
+		for _, k := range []string{"a", "b", "c"} {
+			err = txn.Put(dbi, []byte(k), []byte(k+"1"), 0)
+			if err != nil {
+				return err
+			}
+		}
+		err = txn.Put(dups, []byte("k"), []byte("x"), 0)
+		if err != nil {
+			return err
+		}
+		return txn.Put(dups, []byte("k"), []byte("y"), 0)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = env.Update(func(txn *Txn) error {
+		err := txn.Del(dbi, []byte("b"), nil)
+		if err != nil {
+			return err
+		}
+		return txn.Put(dbi, []byte("a"), []byte("a2"), 0)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// aborted transactions leave no trace.
+	env.Update(func(txn *Txn) error {
+		txn.Put(dbi, []byte("z"), nil, 0)
+		return os.ErrInvalid
+	})
+	err = tr.Stop()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tr.Commits() != 2 {
+		t.Errorf("%d commits", tr.Commits())
+	}
+
+	replay := func(until uint64) map[string]string {
+		dir, err := ioutil.TempDir("", "mdb_test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		fresh, err := OpenEnv(dir, &Options{MaxDBs: 4})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer fresh.Close()
+		last, err := Replay(fresh, bytes.NewReader(trace.Bytes()), &ReplayOptions{Until: until})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if until != 0 && last != until {
+			t.Errorf("replayed up to %d", last)
+		}
+		items := make(map[string]string)
+		err = fresh.View(func(txn *Txn) error {
+			for _, name := range []string{"items", "dups"} {
+				dbi, err := txn.OpenDBI(name, 0)
+				if err != nil {
+					return err
+				}
+				flags, err := txn.Flags(dbi)
+				if err != nil {
+					return err
+				}
+				if (name == "dups") != (flags&DupSort != 0) {
+					t.Errorf("%s: flags %#x", name, flags)
+				}
+				cur, err := txn.OpenCursor(dbi)
+				if err != nil {
+					return err
+				}
+				for k, v, err := cur.Get(nil, nil, First); err == nil; k, v, err = cur.Get(nil, nil, Next) {
+					items[name+"/"+string(k)] += string(v)
+				}
+				cur.Close()
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return items
+	}
+
+	got := replay(0)
+	want := map[string]string{"items/a": "a2", "items/c": "c1", "dups/k": "xy"}
+	if len(got) != len(want) {
+		t.Errorf("replayed %v", got)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s: %q", k, got[k])
+		}
+	}
+	if got := replay(1); got["items/b"] != "b1" || got["items/a"] != "a1" {
+		t.Errorf("replayed up to the first commit: %v", got)
+	}
+
+	// a truncated trace replays its complete commits.
+	trace.Truncate(trace.Len() - 3)
+	if got := replay(0); got["items/b"] != "b1" {
+		t.Errorf("replayed a truncated trace: %v", got)
+	}
+}
+
+func TestEnv_Trace_concurrent(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+
+	dbi, err := openRoot(env, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var trace bytes.Buffer
+	tr, err := env.Trace(&trace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every commit increments a counter, so that a replay in commit order
+	// sees it count the commits.
+	const writers, updates = 8, 250
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < updates; i++ {
+				err := env.Update(func(txn *Txn) error {
+					var n uint64
+					v, err := txn.Get(dbi, []byte("n"))
+					if err == nil {
+						n = binary.BigEndian.Uint64(v)
+					} else if !IsNotFound(err) {
+						return err
+					}
+					var buf [8]byte
+					binary.BigEndian.PutUint64(buf[:], n+1)
+					return txn.Put(dbi, []byte("n"), buf[:], 0)
+				})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	err = tr.Stop()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "mdb_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fresh, err := OpenEnv(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fresh.Close()
+	var bad error
+	last, err := Replay(fresh, bytes.NewReader(trace.Bytes()), &ReplayOptions{
+		OnCommit: func(commit uint64, recorded time.Time) {
+			if bad != nil {
+				return
+			}
+			bad = fresh.View(func(txn *Txn) error {
+				root, err := txn.OpenRoot(0)
+				if err != nil {
+					return err
+				}
+				v, err := txn.Get(root, []byte("n"))
+				if err != nil {
+					return err
+				}
+				if n := binary.BigEndian.Uint64(v); n != commit {
+					t.Errorf("counter %d after replaying commit %d", n, commit)
+					return os.ErrInvalid
+				}
+				return nil
+			})
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last != writers*updates {
+		t.Errorf("replayed %d commits", last)
+	}
+	if bad != nil && bad != os.ErrInvalid {
+		t.Error(bad)
+	}
+}
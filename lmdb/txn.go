@@ -9,9 +9,13 @@ package lmdb
 import "C"
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"runtime"
+	"sync/atomic"
+	"time"
 	"unsafe"
 )
 
@@ -66,6 +70,41 @@ type Txn struct {
 	// Preallocated at process start, the slots are fixed in size.
 	readSlot *ReadSlot
 
+	// arena holds value copies when UseArena has been called.
+	arena *arena
+
+	// syncCommit is set by SetCommitSync.  parent is the parent of a nested
+	// write transaction, which inherits syncCommit when txn commits.
+	syncCommit bool
+	parent     *Txn
+
+	// prof counts the work of txn while profiling is enabled.
+	prof *txnProfile
+
+	// hot holds the writes sampled by TrackHotKeys until txn commits.
+	hot []hotWrite
+
+	// coop records the CoopLock mode held by txn.
+	coop int8
+
+	// live is set for a top-level transaction, counted by env.liveTxns
+	// until it terminates.
+	live bool
+
+	// capture is set while env has subscriptions, see Env.Subscribe.
+	// changes holds the writes of txn until it commits, and reserved the
+	// indexes of those made with PutReserve.
+	capture  bool
+	changes  []BatchOp
+	reserved []int
+
+	// ctx and labels are attached by ViewContext and UpdateContext and
+	// inherited by nested transactions.  start is set while slow
+	// transactions are logged.
+	ctx    context.Context
+	labels Labels
+	start  time.Time
+
 	errLogf func(format string, v ...interface{})
 }
 
@@ -77,6 +116,10 @@ func beginTxn(env *Env, parent *Txn, flags uint) (*Txn, error) {
 
 var ErrViewCannotHaveWriteChild = fmt.Errorf("cannot have child writer txn from read-only parent txn")
 
+// ErrReadonly is returned when a write transaction is begun in an
+// environment opened with the Readonly flag.
+var ErrReadonly = errors.New("cannot write to an environment opened readonly")
+
 func beginTxnWithReadSlot(env *Env, parent *Txn, flags uint, rs *ReadSlot) (txn *Txn, err error) {
 	write := (flags&Readonly == 0)
 	txn = &Txn{
@@ -95,11 +138,15 @@ func beginTxnWithReadSlot(env *Env, parent *Txn, flags uint, rs *ReadSlot) (txn
 			// Just return an error.
 			return nil, ErrViewCannotHaveWriteChild
 		}
+		if env.readonly {
+			return nil, ErrReadonly
+		}
 		// use the one writeSlot, unless we are using parent's slot.
 		if parent == nil {
 			txn.readSlot = env.writeSlot
 		} else {
 			ptxn = parent._txn
+			txn.parent = parent
 			parent.readSlot.mu.Lock()
 			txn.readSlot = parent.readSlot
 			// apparently sub-transactions don't commit/abort. so
@@ -132,10 +179,53 @@ func beginTxnWithReadSlot(env *Env, parent *Txn, flags uint, rs *ReadSlot) (txn
 		}
 	}
 
+	if parent == nil {
+		err = txn.coopAcquire()
+		if err != nil {
+			if txn.readonly && rs == nil {
+				env.ReturnReadSlot(txn.readSlot)
+			}
+			return nil, err
+		}
+	}
 	ret := C.mdb_txn_begin(env._env, ptxn, C.uint(flags), &txn._txn)
 	if ret != success {
+		txn.coopRelease()
+		// the slot must not stay owned, e.g. after MapResized, or closing
+		// env panics.
+		if txn.readonly && rs == nil && parent == nil {
+			env.ReturnReadSlot(txn.readSlot)
+		}
 		return nil, operrno("mdb_txn_begin", ret)
 	}
+	if env.checkMapExtent && parent == nil {
+		err = env.CheckMapExtent()
+		if err != nil {
+			C.mdb_txn_abort(txn._txn)
+			txn._txn = nil
+			txn.coopRelease()
+			if txn.readonly && rs == nil {
+				env.ReturnReadSlot(txn.readSlot)
+			}
+			return nil, err
+		}
+	}
+	if write {
+		if parent != nil {
+			txn.capture = parent.capture
+		} else {
+			txn.capture = env.capturing()
+		}
+	}
+	if parent != nil {
+		txn.ctx, txn.labels = parent.ctx, parent.labels
+	}
+	if parent == nil {
+		txn.live = true
+		atomic.AddInt32(&env.liveTxns, 1)
+	}
+	txn.startProfile()
+	txn.startSlow()
 	return txn, nil
 }
 
@@ -226,9 +316,53 @@ func (txn *Txn) Commit() error {
 }
 
 func (txn *Txn) commit() error {
+	txn.settleReserved()
+	var id uintptr
+	var ticket uint64
+	publish := txn.capture && txn.parent == nil && len(txn.changes) > 0
+	if publish {
+		// the id of the transaction is unknown once it is committed, and
+		// its place among the commits is only known while it holds the
+		// writer lock.
+		id = txn.ID()
+		ticket = txn.env.reservePublication()
+	}
 	ret := C.mdb_txn_commit(txn._txn)
+	var ops []BatchOp
+	if ret == success {
+		txn.commitHotKeys()
+		ops = txn.commitChanges()
+	}
+	if publish {
+		defer txn.env.publishChanges(ticket, id, txn.labels, ops)
+	}
 	txn.clearTxn()
-	return operrno("mdb_txn_commit", ret)
+	if ret != success || !txn.syncCommit {
+		return operrno("mdb_txn_commit", ret)
+	}
+	if txn.parent != nil {
+		txn.parent.syncCommit = true
+		return nil
+	}
+	ret = C.mdb_env_sync(txn.env._env, 1)
+	return operrno("mdb_env_sync", ret)
+}
+
+// SetCommitSync requests that committing txn flush the environment to disk
+// synchronously when force is true, even if the environment was opened with
+// NoSync or MapAsync or txn was begun with NoSync.  This lets individual
+// critical updates be durable without changing the environment flags, which
+// would race with other writers.  SetCommitSync may be called from within
+// Update.  A nested transaction passes the request on to its parent when it
+// commits, and the flush happens when the outermost transaction commits.
+//
+// The flush follows the commit, so if it fails the changes of txn are
+// committed but possibly not durable, and Commit returns the error of
+// mdb_env_sync.
+//
+// See mdb_env_sync.
+func (txn *Txn) SetCommitSync(force bool) {
+	txn.syncCommit = force
 }
 
 // Abort discards pending writes in the transaction and clears the finalizer on
@@ -261,10 +395,22 @@ func (txn *Txn) abort() {
 }
 
 func (txn *Txn) clearTxn() {
+	txn.flushProfile()
+	txn.logSlow()
+	txn.hot = nil
+	txn.changes = nil
+	txn.reserved = nil
+	txn.coopRelease()
+
 	// Clear the C object to prevent any potential future use of the freed
 	// pointer.
 	txn._txn = nil
+	txn.arena = nil
 
+	if txn.live {
+		txn.live = false
+		atomic.AddInt32(&txn.env.liveTxns, -1)
+	}
 	if txn.readonly {
 		//vv("clearTx is returning read slot %v", txn.readSlot.slot)
 		txn.env.ReturnReadSlot(txn.readSlot)
@@ -300,7 +446,11 @@ func (txn *Txn) Reset() {
 }
 
 func (txn *Txn) reset() {
+	txn.flushProfile()
+	txn.logSlow()
 	C.mdb_txn_reset(txn._txn)
+	txn.coopRelease()
+	txn.arena = nil
 }
 
 // Renew reuses a transaction that was previously reset by calling txn.Reset().
@@ -316,7 +466,16 @@ func (txn *Txn) Renew() error {
 }
 
 func (txn *Txn) renew() error {
+	err := txn.coopAcquire()
+	if err != nil {
+		return err
+	}
 	ret := C.mdb_txn_renew(txn._txn)
+	if ret != success {
+		txn.coopRelease()
+	} else {
+		txn.startSlow()
+	}
 
 	// mdb_txn_renew causes txn._txn to pick up a new transaction ID.  It's
 	// slightly confusing in the LMDB docs.  Txn ID corresponds to database
@@ -346,6 +505,9 @@ func (txn *Txn) OpenDBI(name string, flags uint) (DBI, error) {
 	cname := C.CString(name)
 	dbi, err := txn.openDBI(cname, flags)
 	C.free(unsafe.Pointer(cname))
+	if err == nil {
+		txn.noteDBI(dbi, name)
+	}
 	return dbi, err
 }
 
@@ -365,7 +527,11 @@ func (txn *Txn) Flags(dbi DBI) (uint, error) {
 // does not require env.SetMaxDBs() to be called beforehand.  And, OpenRoot can
 // be called without flags in a View transaction.
 func (txn *Txn) OpenRoot(flags uint) (DBI, error) {
-	return txn.openDBI(nil, flags)
+	dbi, err := txn.openDBI(nil, flags)
+	if err == nil {
+		txn.noteDBI(dbi, "")
+	}
+	return dbi, err
 }
 
 // openDBI returns returns whatever DBI value was set by mdb_open_dbi.  In an
@@ -383,18 +549,24 @@ func (txn *Txn) openDBI(cname *C.char, flags uint) (DBI, error) {
 //
 // See mdb_stat.
 func (txn *Txn) Stat(dbi DBI) (*Stat, error) {
-	var _stat C.MDB_stat
-	ret := C.mdb_stat(txn._txn, C.MDB_dbi(dbi), &_stat)
-	if ret != success {
-		return nil, operrno("mdb_stat", ret)
+	stat := new(Stat)
+	err := txn.StatInto(dbi, stat)
+	if err != nil {
+		return nil, err
+	}
+	return stat, nil
+}
+
+// StatInto is like Stat but fills in stat instead of allocating a new Stat.
+//
+// See mdb_stat.
+func (txn *Txn) StatInto(dbi DBI, stat *Stat) error {
+	s := C.lmdbgo_mdb_stat(txn._txn, C.MDB_dbi(dbi))
+	if s.rc != success {
+		return operrno("mdb_stat", s.rc)
 	}
-	stat := Stat{PSize: uint(_stat.ms_psize),
-		Depth:         uint(_stat.ms_depth),
-		BranchPages:   uint64(_stat.ms_branch_pages),
-		LeafPages:     uint64(_stat.ms_leaf_pages),
-		OverflowPages: uint64(_stat.ms_overflow_pages),
-		Entries:       uint64(_stat.ms_entries)}
-	return &stat, nil
+	stat.fill(&s.stat)
+	return nil
 }
 
 // Drop empties the database if del is false.  Drop deletes and closes the
@@ -403,6 +575,9 @@ func (txn *Txn) Stat(dbi DBI) (*Stat, error) {
 // See mdb_drop.
 func (txn *Txn) Drop(dbi DBI, del bool) error {
 	ret := C.mdb_drop(txn._txn, C.MDB_dbi(dbi), cbool(del))
+	if ret == success {
+		txn.captureOp(BatchDropRange, dbi, nil, nil)
+	}
 	return operrno("mdb_drop", ret)
 }
 
@@ -438,12 +613,37 @@ func (txn *Txn) subFlag(flags uint, fn TxnOp) error {
 }
 
 func (txn *Txn) bytes(val *C.MDB_val) []byte {
+	if p := txn.prof; p != nil {
+		if txn.RawRead {
+			p.raw += int64(val.mv_size)
+		} else {
+			p.copied += int64(val.mv_size)
+		}
+	}
 	if txn.RawRead {
 		return getBytes(val)
 	}
+	if txn.arena != nil {
+		return txn.arena.copy(val)
+	}
 	return getBytesCopy(val)
 }
 
+// UseArena makes txn copy values returned by Get and its cursors into a
+// grow-only buffer, with an initial capacity of size bytes, instead of
+// allocating a new slice for every value.  UseArena has no effect when
+// txn.RawRead is true.
+//
+// Values read through the arena remain valid after txn terminates, but the
+// chunk of memory backing them is only released once every value copied
+// into it is unreachable.  Read-heavy transactions touching thousands of
+// values produce far less garbage this way.  The arena is discarded when
+// txn is committed, aborted, or reset, so UseArena must be called again after
+// a Renew.
+func (txn *Txn) UseArena(size int) {
+	txn.arena = newArena(size)
+}
+
 // Get retrieves items from database dbi.  If txn.RawRead is true the slice
 // returned by Get references a readonly section of memory that must not be
 // accessed after txn has terminated.
@@ -456,6 +656,7 @@ func (txn *Txn) Get(dbi DBI, key []byte) ([]byte, error) {
 		(*C.char)(unsafe.Pointer(&kdata[0])), C.size_t(kn),
 		txn.readSlot.sval,
 	)
+	txn.profCall(0)
 	err := operrno("mdb_get", ret)
 	if err != nil {
 		return nil, err
@@ -464,9 +665,75 @@ func (txn *Txn) Get(dbi DBI, key []byte) ([]byte, error) {
 	return b, nil
 }
 
+// ErrShortBuffer is returned by GetInto when the value does not fit in the
+// buffer provided by the caller.
+var ErrShortBuffer = errors.New("buffer too small for value")
+
+// GetInto copies the value of key in database dbi into buf and returns the
+// length of the value.  GetInto lets read loops reuse a single buffer when
+// values must outlive the transaction, which RawRead alone does not allow.
+//
+// If len(buf) is smaller than the value nothing is copied and GetInto returns
+// the length of the value along with ErrShortBuffer, so the caller may grow
+// buf and try again.
+//
+//	n, err := txn.GetInto(dbi, key, buf)
+//	if err == lmdb.ErrShortBuffer {
+//		buf = make([]byte, n)
+//		n, err = txn.GetInto(dbi, key, buf)
+//	}
+//
+// See mdb_get.
+func (txn *Txn) GetInto(dbi DBI, key []byte, buf []byte) (int, error) {
+	kdata, kn := valBytes(key)
+	ret := C.lmdbgo_mdb_get(
+		txn._txn, C.MDB_dbi(dbi),
+		(*C.char)(unsafe.Pointer(&kdata[0])), C.size_t(kn),
+		txn.readSlot.sval,
+	)
+	txn.profCall(0)
+	err := operrno("mdb_get", ret)
+	if err != nil {
+		return 0, err
+	}
+	n := int(txn.readSlot.sval.mv_size)
+	if len(buf) < n {
+		return n, ErrShortBuffer
+	}
+	if txn.prof != nil {
+		txn.prof.copied += int64(n)
+	}
+	copy(buf, getBytes(txn.readSlot.sval))
+	return n, nil
+}
+
+// Has returns true if key is present in database dbi.  Has does not copy the
+// value stored under key, so it is cheaper than Get for membership checks on
+// databases with large values.
+//
+// See mdb_get.
+func (txn *Txn) Has(dbi DBI, key []byte) (bool, error) {
+	kdata, kn := valBytes(key)
+	ret := C.lmdbgo_mdb_get(
+		txn._txn, C.MDB_dbi(dbi),
+		(*C.char)(unsafe.Pointer(&kdata[0])), C.size_t(kn),
+		txn.readSlot.sval,
+	)
+	txn.profCall(0)
+	err := operrno("mdb_get", ret)
+	if IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 func (txn *Txn) putNilKey(dbi DBI, flags uint) error {
 	// mdb_put with an empty key will always fail
 	ret := C.lmdbgo_mdb_put2(txn._txn, C.MDB_dbi(dbi), nil, 0, nil, 0, C.uint(flags))
+	txn.profCall(0)
 	return operrno("mdb_put", ret)
 }
 
@@ -489,13 +756,23 @@ func (txn *Txn) Put(dbi DBI, key []byte, val []byte, flags uint) error {
 		(*C.char)(unsafe.Pointer(&val[0])), C.size_t(vn),
 		C.uint(flags),
 	)
+	txn.profCall(0)
+	if ret == success {
+		txn.sampleWrite(dbi, key)
+		txn.captureOp(BatchPut, dbi, key, val[:vn])
+	}
 	return operrno("mdb_put", ret)
 }
 
 // PutReserve returns a []byte of length n that can be written to, potentially
 // avoiding a memcopy.  The returned byte slice is only valid in txn's thread,
 // before it has terminated.
+//
+// PutReserve returns an error if the package was built with the lmdbsafe tag.
 func (txn *Txn) PutReserve(dbi DBI, key []byte, n int, flags uint) ([]byte, error) {
+	if !unsafeViews {
+		return nil, errReserveUnsafe
+	}
 	if len(key) == 0 {
 		return nil, txn.putNilKey(dbi, flags)
 	}
@@ -506,11 +783,14 @@ func (txn *Txn) PutReserve(dbi DBI, key []byte, n int, flags uint) ([]byte, erro
 		txn.readSlot.sval,
 		C.uint(flags|C.MDB_RESERVE),
 	)
+	txn.profCall(0)
 	err := operrno("mdb_put", ret)
 	if err != nil {
 		return nil, err
 	}
+	txn.sampleWrite(dbi, key)
 	b := getBytes(txn.readSlot.sval)
+	txn.captureReserve(dbi, key, b)
 	return b, nil
 }
 
@@ -526,9 +806,124 @@ func (txn *Txn) Del(dbi DBI, key, val []byte) error {
 		(*C.char)(unsafe.Pointer(&kdata[0])), C.size_t(kn),
 		(*C.char)(unsafe.Pointer(&vdata[0])), C.size_t(vn),
 	)
+	txn.profCall(0)
+	if ret == success {
+		txn.sampleWrite(dbi, key)
+		txn.captureOp(BatchDel, dbi, key, val)
+	}
 	return operrno("mdb_del", ret)
 }
 
+// DelBatch deletes each of keys from database dbi, with all of its values if
+// dbi has the DupSort flag, in a single cgo call.  It is meant for bulk
+// invalidation, such as purging thousands of cache entries, where a call to
+// Del per key is dominated by cgo overhead.  The keys are copied into one
+// buffer and deleted in order by a loop in C.
+//
+// DelBatch returns whether each key existed.  Missing keys are not an
+// error.  On any other error DelBatch stops and the results of the keys
+// before the failing one are returned along with the error.
+//
+// See mdb_del.
+func (txn *Txn) DelBatch(dbi DBI, keys [][]byte) ([]bool, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	size := 0
+	for _, k := range keys {
+		size += len(k)
+	}
+	// the trailing byte keeps &buf[0] valid when every key is empty.
+	buf := make([]byte, 0, size+1)
+	kns := make([]C.size_t, len(keys))
+	for i, k := range keys {
+		buf = append(buf, k...)
+		kns[i] = C.size_t(len(k))
+	}
+	buf = append(buf, 0)
+
+	found := make([]byte, len(keys))
+	var done C.size_t
+	ret := C.lmdbgo_mdb_delbatch(
+		txn._txn, C.MDB_dbi(dbi),
+		(*C.char)(unsafe.Pointer(&buf[0])), &kns[0], C.size_t(len(keys)),
+		(*C.char)(unsafe.Pointer(&found[0])), &done,
+	)
+	existed := make([]bool, int(done))
+	for i := range existed {
+		existed[i] = found[i] != 0
+		if existed[i] {
+			txn.captureOp(BatchDel, dbi, keys[i], nil)
+		}
+	}
+	txn.profCall(0)
+	return existed, operrno("mdb_del", ret)
+}
+
+// GetDupBatch looks up each of keys in database dbi and returns all of their
+// values, in order, keyed by string(key).  Keys that are not found are absent
+// from the map.  It is meant for index fan-out, resolving many index keys of
+// a DupSort database to their posting lists, where a cursor walk per key
+// would be dominated by cgo overhead.  The lookups are done by a loop in C,
+// in a single cgo call unless the values do not fit the buffer GetDupBatch
+// preallocates, in which case the lookup resumes with a larger buffer.
+//
+// The values are copied unless txn.RawRead is set.  In a database without
+// the DupSort flag each key found has a single value.
+func (txn *Txn) GetDupBatch(dbi DBI, keys [][]byte) (map[string][][]byte, error) {
+	res := make(map[string][][]byte, len(keys))
+	if len(keys) == 0 {
+		return res, nil
+	}
+	size := 0
+	for _, k := range keys {
+		size += len(k)
+	}
+	// the trailing byte keeps &buf[off] valid when the keys left are empty.
+	buf := make([]byte, 0, size+1)
+	kns := make([]C.size_t, len(keys))
+	for i, k := range keys {
+		buf = append(buf, k...)
+		kns[i] = C.size_t(len(k))
+	}
+	buf = append(buf, 0)
+
+	vals := make([]C.MDB_val, 4*len(keys)+64)
+	vcounts := make([]C.size_t, len(keys))
+	start, off := 0, 0
+	for start < len(keys) {
+		var done C.size_t
+		ret := C.lmdbgo_mdb_get_dupbatch(
+			txn._txn, C.MDB_dbi(dbi),
+			(*C.char)(unsafe.Pointer(&buf[off])), &kns[start], C.size_t(len(keys)-start),
+			&vals[0], C.size_t(len(vals)), &vcounts[start], &done,
+		)
+		txn.profCall(0)
+		if ret != success {
+			return nil, operrno("mdb_cursor_get", ret)
+		}
+		j := 0
+		for i := start; i < start+int(done); i++ {
+			n := int(vcounts[i])
+			if n > 0 {
+				vs := make([][]byte, n)
+				for v := range vs {
+					vs[v] = txn.bytes(&vals[j])
+					j++
+				}
+				res[string(keys[i])] = vs
+			}
+			off += len(keys[i])
+		}
+		if done == 0 {
+			// the values of keys[start] alone overflow vals.
+			vals = make([]C.MDB_val, 2*len(vals))
+		}
+		start += int(done)
+	}
+	return res, nil
+}
+
 // OpenCursor allocates and initializes a Cursor to database dbi.
 //
 // See mdb_cursor_open.
@@ -5,6 +5,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"os"
+	"reflect"
 	"runtime"
 	"syscall"
 	"testing"
@@ -269,6 +270,214 @@ func TestTxn_Del_dup(t *testing.T) {
 	}
 }
 
+func TestTxn_GetDupBatch(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+
+	db, err := openRoot(env, DupSort)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var big [][]byte
+	err = env.Update(func(txn *Txn) (err error) {
+		for _, kv := range [][2]string{{"a", "1"}, {"a", "2"}, {"a", "3"}, {"b", "1"}} {
+			err = txn.Put(db, []byte(kv[0]), []byte(kv[1]), 0)
+			if err != nil {
+				return err
+			}
+		}
+		// enough values to overflow the initial buffer.
+		for i := 0; i < 200; i++ {
+			v := []byte(fmt.Sprintf("%03d", i))
+			big = append(big, v)
+			err = txn.Put(db, []byte("big"), v, 0)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = env.View(func(txn *Txn) (err error) {
+		keys := [][]byte{[]byte("a"), []byte("x"), []byte("big"), []byte("b")}
+		res, err := txn.GetDupBatch(db, keys)
+		if err != nil {
+			return err
+		}
+		want := map[string][][]byte{
+			"a":   {[]byte("1"), []byte("2"), []byte("3")},
+			"big": big,
+			"b":   {[]byte("1")},
+		}
+		if !reflect.DeepEqual(res, want) {
+			t.Errorf("results: %q", res)
+		}
+
+		_, err = txn.GetDupBatch(db, [][]byte{[]byte("a"), nil})
+		if !IsErrno(err, BadValSize) {
+			t.Errorf("empty key: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTxn_DelBatch(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+
+	db, err := openRoot(env, DupSort)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = env.Update(func(txn *Txn) (err error) {
+		for _, kv := range [][2]string{{"a", "1"}, {"a", "2"}, {"b", "1"}, {"c", "1"}} {
+			err = txn.Put(db, []byte(kv[0]), []byte(kv[1]), 0)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = env.Update(func(txn *Txn) (err error) {
+		found, err := txn.DelBatch(db, [][]byte{[]byte("a"), []byte("x"), []byte("c"), []byte("a")})
+		if err != nil {
+			return err
+		}
+		if !reflect.DeepEqual(found, []bool{true, false, true, false}) {
+			t.Errorf("found: %v", found)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = env.View(func(txn *Txn) (err error) {
+		items, err := dumpItems(txn, db)
+		if err != nil {
+			return err
+		}
+		if !reflect.DeepEqual(items, []string{"b=1"}) {
+			t.Errorf("remaining items: %q", items)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = env.Update(func(txn *Txn) (err error) {
+		found, err := txn.DelBatch(db, [][]byte{[]byte("b"), {}, []byte("b")})
+		if !IsErrno(err, BadValSize) {
+			t.Errorf("empty key: %v", err)
+		}
+		if !reflect.DeepEqual(found, []bool{true}) {
+			t.Errorf("found before failure: %v", found)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTxn_Has(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+
+	db, err := openRoot(env, 0)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = env.Update(func(txn *Txn) (err error) {
+		return txn.Put(db, []byte("k"), []byte("v"), 0)
+	})
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = env.View(func(txn *Txn) (err error) {
+		ok, err := txn.Has(db, []byte("k"))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			t.Errorf("key %q not found", "k")
+		}
+		ok, err = txn.Has(db, []byte("missing"))
+		if err != nil {
+			return err
+		}
+		if ok {
+			t.Errorf("key %q found", "missing")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTxn_GetInto(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+
+	db, err := openRoot(env, 0)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = env.Update(func(txn *Txn) (err error) {
+		return txn.Put(db, []byte("k"), []byte("value"), 0)
+	})
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = env.View(func(txn *Txn) (err error) {
+		buf := make([]byte, 2)
+		n, err := txn.GetInto(db, []byte("k"), buf)
+		if err != ErrShortBuffer {
+			t.Errorf("unexpected error: %v (!= %v)", err, ErrShortBuffer)
+		}
+		if n != 5 {
+			t.Errorf("unexpected length: %d (!= %d)", n, 5)
+		}
+		buf = make([]byte, n+3)
+		n, err = txn.GetInto(db, []byte("k"), buf)
+		if err != nil {
+			return err
+		}
+		if string(buf[:n]) != "value" {
+			t.Errorf("unexpected value: %q (!= %q)", buf[:n], "value")
+		}
+		_, err = txn.GetInto(db, []byte("missing"), buf)
+		if !IsNotFound(err) {
+			t.Errorf("unexpected error: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
 func TestTexn_Put_emptyValue(t *testing.T) {
 	env := setup(t)
 	defer clean(env, t)
@@ -299,6 +508,9 @@ func TestTexn_Put_emptyValue(t *testing.T) {
 }
 
 func TestTxn_PutReserve(t *testing.T) {
+	if !unsafeViews {
+		t.Skip("PutReserve is not supported in lmdbsafe builds")
+	}
 	env := setup(t)
 	defer clean(env, t)
 
@@ -564,6 +776,50 @@ func TestTxn_Update(t *testing.T) {
 	}
 }
 
+func TestTxn_SetCommitSync(t *testing.T) {
+	env := setupFlags(t, NoSync)
+	defer clean(env, t)
+
+	err := env.Update(func(txn *Txn) (err error) {
+		db, err := txn.OpenRoot(Create)
+		if err != nil {
+			return err
+		}
+		err = txn.Sub(func(txn *Txn) error {
+			txn.SetCommitSync(true)
+			return fmt.Errorf("aborted")
+		})
+		if err == nil {
+			return fmt.Errorf("subtransaction not aborted")
+		}
+		if txn.syncCommit {
+			t.Errorf("aborted subtransaction requested sync")
+		}
+		err = txn.Sub(func(txn *Txn) error {
+			txn.SetCommitSync(true)
+			return txn.Put(db, []byte("k"), []byte("v"), 0)
+		})
+		if err != nil {
+			return err
+		}
+		if !txn.syncCommit {
+			t.Errorf("committed subtransaction did not request sync")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	flags, err := env.Flags()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flags&NoSync == 0 {
+		t.Errorf("environment flags changed: %#x", flags)
+	}
+}
+
 func TestTxn_View_noSubTxn(t *testing.T) {
 	env := setup(t)
 	defer clean(env, t)
@@ -748,6 +1004,69 @@ func TestTxn_Flags(t *testing.T) {
 	}
 }
 
+func TestTxn_BeginTxn_mapResized(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+	path, err := env.Path()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a second handle grows the map behind env's back, like another
+	// process would.
+	other, err := NewEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	err = other.SetMapSize(64 << 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = other.Open(path, 0, 0664)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := env.Info()
+	if err != nil {
+		t.Fatal(err)
+	}
+	val := make([]byte, 4096)
+	err = other.Update(func(txn *Txn) (err error) {
+		dbi, err := txn.OpenRoot(0)
+		if err != nil {
+			return err
+		}
+		for i := 0; int64(i*len(val)) < 2*info.MapSize; i++ {
+			err = txn.Put(dbi, []byte(fmt.Sprint(i)), val, 0)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env.rkeyMu.Lock()
+	avail := len(env.rkeyAvail)
+	env.rkeyMu.Unlock()
+	txn, err := env.BeginTxn(nil, Readonly)
+	if !IsMapResized(err) {
+		if err == nil {
+			txn.Abort()
+		}
+		t.Fatalf("begin: %v", err)
+	}
+	env.rkeyMu.Lock()
+	n := len(env.rkeyAvail)
+	env.rkeyMu.Unlock()
+	if n != avail {
+		t.Errorf("%d read slots available after a failed begin; want %d", n, avail)
+	}
+}
+
 func TestTxn_Renew(t *testing.T) {
 	env := setup(t)
 	path, err := env.Path()
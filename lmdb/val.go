@@ -8,6 +8,7 @@ package lmdb
 import "C"
 
 import (
+	"errors"
 	"unsafe"
 	//"github.com/glycerine/lmdb-go/int/lmdbarch"
 )
@@ -102,6 +103,10 @@ func (m *Multi) Page() []byte {
 	return m.page[:len(m.page):len(m.page)]
 }
 
+// errReserveUnsafe is returned by PutReserve when the package is built with
+// the lmdbsafe tag.
+var errReserveUnsafe = errors.New("PutReserve is not supported in lmdbsafe builds")
+
 var eb = []byte{0}
 
 func valBytes(b []byte) ([]byte, int) {
@@ -119,10 +124,6 @@ func wrapVal(b []byte) *C.MDB_val {
 	}
 }
 
-func getBytes(val *C.MDB_val) []byte {
-	return (*[valMaxSize]byte)(unsafe.Pointer(val.mv_data))[:val.mv_size:val.mv_size]
-}
-
 func getBytesCopy(val *C.MDB_val) []byte {
 	return C.GoBytes(val.mv_data, C.int(val.mv_size))
 }
@@ -0,0 +1,39 @@
+//go:build lmdbsafe
+// +build lmdbsafe
+
+package lmdb
+
+/*
+#include "lmdb.h"
+*/
+import "C"
+
+import (
+	"unsafe"
+)
+
+// Building with the lmdbsafe tag selects implementations of the value view
+// helpers which do not reinterpret memory with unsafe pointer casts.  The
+// only remaining uses of package unsafe are the pointers handed across the
+// cgo boundary.  The price is an extra copy: RawRead values and string keys
+// are copied, and PutReserve is unavailable because it can only work by
+// exposing C memory to Go.
+//
+//		go test -tags lmdbsafe ./...
+
+// unsafeViews is false when values are always copied out of C memory.
+const unsafeViews = false
+
+// getBytes returns a copy of the memory described by val.
+func getBytes(val *C.MDB_val) []byte {
+	return C.GoBytes(val.mv_data, C.int(val.mv_size))
+}
+
+// strVal returns a C pointer to a copy of the bytes of s and the length of s.
+func strVal(s string) (*C.char, int) {
+	if len(s) == 0 {
+		return (*C.char)(unsafe.Pointer(&eb[0])), 0
+	}
+	b := []byte(s)
+	return (*C.char)(unsafe.Pointer(&b[0])), len(b)
+}
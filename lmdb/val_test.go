@@ -71,7 +71,7 @@ func TestVal(t *testing.T) {
 	if !bytes.Equal(p, orig) {
 		t.Errorf("getBytes() not the same as original data: %q", p)
 	}
-	if &p[0] != &orig[0] {
+	if unsafeViews && &p[0] != &orig[0] {
 		t.Errorf("getBytes() is not the same slice as original")
 	}
 
@@ -0,0 +1,34 @@
+//go:build !lmdbsafe
+// +build !lmdbsafe
+
+package lmdb
+
+/*
+#include "lmdb.h"
+*/
+import "C"
+
+import (
+	"unsafe"
+)
+
+// unsafeViews is true when values may be viewed in place, without copying
+// them out of C memory.  See val_safe.go for the alternative.
+const unsafeViews = true
+
+// getBytes returns a slice referencing the memory described by val.  The
+// slice aliases C memory (usually the memory map) and must not be retained
+// beyond the life of the transaction that produced val.
+func getBytes(val *C.MDB_val) []byte {
+	return (*[valMaxSize]byte)(unsafe.Pointer(val.mv_data))[:val.mv_size:val.mv_size]
+}
+
+// strVal returns a C pointer to the bytes of s and the length of s.  Like
+// valBytes an empty string is mapped to a valid, zero length, location.  The
+// string header starts with its data pointer, so no copy of s is needed.
+func strVal(s string) (*C.char, int) {
+	if len(s) == 0 {
+		return (*C.char)(unsafe.Pointer(&eb[0])), 0
+	}
+	return (*C.char)(*(*unsafe.Pointer)(unsafe.Pointer(&s))), len(s)
+}
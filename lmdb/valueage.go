@@ -0,0 +1,297 @@
+package lmdb
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultValueAgeBuckets are the upper bounds of the buckets of value age
+// histograms used when ValueAgeOptions.Buckets is empty.
+var DefaultValueAgeBuckets = []time.Duration{
+	time.Minute,
+	10 * time.Minute,
+	time.Hour,
+	6 * time.Hour,
+	24 * time.Hour,
+	7 * 24 * time.Hour,
+	30 * 24 * time.Hour,
+}
+
+// ValueAgeOptions configures a ValueAgeTracker.  The zero value selects the
+// defaults described for each field.
+type ValueAgeOptions struct {
+	// SampleRate makes the tracker follow one key in SampleRate, chosen by
+	// a hash of the key so that a key is always or never followed, 16 if
+	// zero, every key if 1.
+	SampleRate int
+
+	// MaxKeys bounds the number of keys followed per database, 100000 if
+	// zero.  Keys sampled beyond it are counted as untracked.
+	MaxKeys int
+
+	// DBIs restricts the tracker to the given databases, every database
+	// changed if empty.
+	DBIs []DBI
+
+	// Buckets are the upper bounds of the histogram buckets, in increasing
+	// order, DefaultValueAgeBuckets if empty.
+	Buckets []time.Duration
+}
+
+// ValueAgeTracker follows the time of the last write of a sample of the keys
+// of an environment, through a Subscription, to report the distribution of
+// the ages of values per database, see Env.TrackValueAges.
+type ValueAgeTracker struct {
+	env  *Env
+	sub  *Subscription
+	opts ValueAgeOptions
+	done chan struct{}
+
+	mu        sync.Mutex
+	dbs       map[DBI]valueAges
+	gaps      uint64
+	untracked map[DBI]uint64
+}
+
+// valueAges maps the sampled keys of a database to the time of their last
+// write, in Unix nanoseconds.
+type valueAges map[string]int64
+
+// ValueAgeHistogram is the distribution of the ages of the values of the
+// sampled keys of a database, see ValueAgeTracker.Histograms.
+type ValueAgeHistogram struct {
+	DBI  DBI
+	Name string // name the database was opened with
+
+	// Counts[i] is the number of keys last written at most Bounds[i] ago,
+	// cumulative as in Prometheus histograms.  Count includes the keys
+	// older than every bound.
+	Bounds []time.Duration
+	Counts []uint64
+	Count  uint64
+	Sum    time.Duration
+	Oldest time.Duration
+
+	// Untracked is the number of sampled keys not followed because of
+	// ValueAgeOptions.MaxKeys.
+	Untracked uint64
+}
+
+// TrackValueAges starts following the writes committed to env from now on,
+// until the returned tracker is stopped or env is closed, to report how long
+// ago the values of a sample of keys were last written, so that operators
+// see which databases are cold, e.g. candidates for archival or eviction.
+// Only writes made after the call are known: keys not written since are not
+// counted, and the ages reported are bounded by the time since the call.
+//
+// The tracker subscribes to env with OverflowDrop, so that it never slows
+// down writers; changes dropped, counted by Gaps, leave the ages of their
+// keys overestimated.
+func (env *Env) TrackValueAges(opts *ValueAgeOptions) (*ValueAgeTracker, error) {
+	t := &ValueAgeTracker{
+		env:       env,
+		done:      make(chan struct{}),
+		dbs:       make(map[DBI]valueAges),
+		untracked: make(map[DBI]uint64),
+	}
+	if opts != nil {
+		t.opts = *opts
+	}
+	if t.opts.SampleRate <= 0 {
+		t.opts.SampleRate = 16
+	}
+	if t.opts.MaxKeys <= 0 {
+		t.opts.MaxKeys = 100000
+	}
+	if len(t.opts.Buckets) == 0 {
+		t.opts.Buckets = DefaultValueAgeBuckets
+	}
+	sub, err := env.Subscribe(&SubscribeOptions{Overflow: OverflowDrop, DBIs: t.opts.DBIs})
+	if err != nil {
+		return nil, err
+	}
+	t.sub = sub
+	done, ok := env.register("value-age-tracker", func() { sub.Close() })
+	if !ok {
+		sub.Close()
+		return nil, errGoClosed
+	}
+	go func() {
+		defer done()
+		t.run()
+	}()
+	return t, nil
+}
+
+func (t *ValueAgeTracker) run() {
+	defer close(t.done)
+	for {
+		ev, err := t.sub.Next(context.Background())
+		if err != nil {
+			return
+		}
+		t.record(&ev)
+	}
+}
+
+// sampled reports whether key is followed, by its FNV-1a hash.
+func (t *ValueAgeTracker) sampled(key []byte) bool {
+	if t.opts.SampleRate == 1 {
+		return true
+	}
+	h := uint32(2166136261)
+	for _, c := range key {
+		h ^= uint32(c)
+		h *= 16777619
+	}
+	return h%uint32(t.opts.SampleRate) == 0
+}
+
+// record notes the writes of the commit ev.
+func (t *ValueAgeTracker) record(ev *Event) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if ev.Gap != 0 {
+		t.gaps += ev.Gap
+		return
+	}
+	now := ev.Time.UnixNano()
+	for i := range ev.Ops {
+		op := &ev.Ops[i]
+		ages := t.dbs[op.DBI]
+		if ages == nil {
+			ages = make(valueAges)
+			t.dbs[op.DBI] = ages
+		}
+		switch {
+		case op.Type == BatchDropRange:
+			for k := range ages {
+				if k >= string(op.Key) && (op.End == nil || k < string(op.End)) {
+					delete(ages, k)
+				}
+			}
+		case !t.sampled(op.Key):
+		case op.Type == BatchDel && len(op.Val) == 0:
+			delete(ages, string(op.Key))
+		default:
+			// a Put, or the deletion of one of the values of a key.
+			if _, ok := ages[string(op.Key)]; !ok && len(ages) >= t.opts.MaxKeys {
+				t.untracked[op.DBI]++
+				continue
+			}
+			ages[string(op.Key)] = now
+		}
+	}
+}
+
+// Gaps returns the number of commits whose changes the tracker missed.
+func (t *ValueAgeTracker) Gaps() uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.gaps
+}
+
+// Histograms returns the distribution of the ages of the values of the keys
+// followed in each database, as of now, ordered by DBI.
+func (t *ValueAgeTracker) Histograms() []ValueAgeHistogram {
+	now := time.Now().UnixNano()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	hists := make([]ValueAgeHistogram, 0, len(t.dbs))
+	for dbi, ages := range t.dbs {
+		h := ValueAgeHistogram{
+			DBI:       dbi,
+			Bounds:    t.opts.Buckets,
+			Counts:    make([]uint64, len(t.opts.Buckets)),
+			Untracked: t.untracked[dbi],
+		}
+		if n, ok := t.env.dbiName(dbi); ok {
+			h.Name = n.name
+		}
+		for _, written := range ages {
+			age := time.Duration(now - written)
+			if age < 0 {
+				age = 0
+			}
+			i := sort.Search(len(h.Bounds), func(i int) bool { return age <= h.Bounds[i] })
+			for ; i < len(h.Counts); i++ {
+				h.Counts[i]++
+			}
+			h.Count++
+			h.Sum += age
+			if age > h.Oldest {
+				h.Oldest = age
+			}
+		}
+		hists = append(hists, h)
+	}
+	sort.Slice(hists, func(i, j int) bool { return hists[i].DBI < hists[j].DBI })
+	return hists
+}
+
+// WritePrometheus writes the histograms of t to w in the Prometheus text
+// exposition format, as the histogram lmdb_value_age_seconds labeled by
+// database name, along with the counters lmdb_value_age_untracked_keys and
+// lmdb_value_age_gaps_total.  The counts are those of the sampled keys, one
+// in ValueAgeOptions.SampleRate.
+func (t *ValueAgeTracker) WritePrometheus(w io.Writer) error {
+	hists := t.Histograms()
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "# HELP lmdb_value_age_seconds Time since the last write of the values of sampled keys (1 in %d).\n", t.opts.SampleRate)
+	fmt.Fprintf(bw, "# TYPE lmdb_value_age_seconds histogram\n")
+	for _, h := range hists {
+		db := promLabel(h.Name)
+		for i, b := range h.Bounds {
+			fmt.Fprintf(bw, "lmdb_value_age_seconds_bucket{db=%s,le=\"%s\"} %d\n", db, promFloat(b.Seconds()), h.Counts[i])
+		}
+		fmt.Fprintf(bw, "lmdb_value_age_seconds_bucket{db=%s,le=\"+Inf\"} %d\n", db, h.Count)
+		fmt.Fprintf(bw, "lmdb_value_age_seconds_sum{db=%s} %s\n", db, promFloat(h.Sum.Seconds()))
+		fmt.Fprintf(bw, "lmdb_value_age_seconds_count{db=%s} %d\n", db, h.Count)
+	}
+	fmt.Fprintf(bw, "# HELP lmdb_value_age_untracked_keys Sampled keys not followed for lack of room.\n")
+	fmt.Fprintf(bw, "# TYPE lmdb_value_age_untracked_keys counter\n")
+	for _, h := range hists {
+		fmt.Fprintf(bw, "lmdb_value_age_untracked_keys{db=%s} %d\n", promLabel(h.Name), h.Untracked)
+	}
+	fmt.Fprintf(bw, "# HELP lmdb_value_age_gaps_total Commits missed by the value age tracker.\n")
+	fmt.Fprintf(bw, "# TYPE lmdb_value_age_gaps_total counter\n")
+	fmt.Fprintf(bw, "lmdb_value_age_gaps_total %d\n", t.Gaps())
+	return bw.Flush()
+}
+
+// promLabel quotes s as a Prometheus label value.
+func promLabel(s string) string {
+	var b bytes.Buffer
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '\\':
+			b.WriteString(`\\`)
+		case '"':
+			b.WriteString(`\"`)
+		case '\n':
+			b.WriteString(`\n`)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+func promFloat(x float64) string {
+	return strconv.FormatFloat(x, 'g', -1, 64)
+}
+
+// Stop stops following writes.  The histograms remain available.
+func (t *ValueAgeTracker) Stop() {
+	t.sub.Close()
+	<-t.done
+}
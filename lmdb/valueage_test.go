@@ -0,0 +1,114 @@
+package lmdb
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEnv_TrackValueAges(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+
+	var hot, cold DBI
+	err := env.Update(func(txn *Txn) (err error) {
+		hot, err = txn.OpenDBI("hot", Create)
+		if err != nil {
+			return err
+		}
+		cold, err = txn.OpenDBI("cold", Create)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracker, err := env.TrackValueAges(&ValueAgeOptions{
+		SampleRate: 1,
+		MaxKeys:    8,
+		Buckets:    []time.Duration{time.Millisecond, time.Hour},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = env.Update(func(txn *Txn) error {
+		for i := 0; i < 4; i++ {
+			if err := txn.Put(cold, []byte(fmt.Sprint("c", i)), []byte("v"), 0); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	err = env.Update(func(txn *Txn) error {
+		for i := 0; i < 10; i++ {
+			if err := txn.Put(hot, []byte(fmt.Sprint("h", i)), []byte("v"), 0); err != nil {
+				return err
+			}
+		}
+		return txn.Del(cold, []byte("c0"), nil)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracker.Stop()
+
+	hists := tracker.Histograms()
+	if len(hists) != 2 {
+		t.Fatalf("histograms %+v", hists)
+	}
+	for _, h := range hists {
+		switch h.Name {
+		case "hot":
+			if h.Count != 8 || h.Untracked != 2 || h.Counts[1] != 8 {
+				t.Errorf("hot histogram %+v", h)
+			}
+		case "cold":
+			if h.Count != 3 || h.Counts[0] != 0 || h.Counts[1] != 3 || h.Oldest < 5*time.Millisecond {
+				t.Errorf("cold histogram %+v", h)
+			}
+		default:
+			t.Errorf("histogram of %q", h.Name)
+		}
+	}
+
+	var buf bytes.Buffer
+	err = tracker.WritePrometheus(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"# TYPE lmdb_value_age_seconds histogram",
+		`lmdb_value_age_seconds_bucket{db="cold",le="0.001"} 0`,
+		`lmdb_value_age_seconds_bucket{db="cold",le="3600"} 3`,
+		`lmdb_value_age_seconds_bucket{db="cold",le="+Inf"} 3`,
+		`lmdb_value_age_seconds_count{db="hot"} 8`,
+		`lmdb_value_age_untracked_keys{db="hot"} 2`,
+		"lmdb_value_age_gaps_total 0",
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("missing %q in:\n%s", line, buf.String())
+		}
+	}
+}
+
+func TestValueAgeTracker_sampled(t *testing.T) {
+	tracker := &ValueAgeTracker{opts: ValueAgeOptions{SampleRate: 4}}
+	n := 0
+	for i := 0; i < 4000; i++ {
+		key := []byte(fmt.Sprint(i))
+		if tracker.sampled(key) {
+			n++
+		}
+		if tracker.sampled(key) != tracker.sampled(append([]byte(nil), key...)) {
+			t.Fatalf("key %s sampled inconsistently", key)
+		}
+	}
+	if n < 800 || n > 1200 {
+		t.Errorf("%d keys in 4000 sampled at 1 in 4", n)
+	}
+}
@@ -0,0 +1,176 @@
+package lmdb
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sort"
+	"sync"
+)
+
+var errValueReaderClosed = errors.New("value reader is closed")
+var errValueReaderWhence = errors.New("Seek: invalid whence")
+var errValueReaderOffset = errors.New("negative offset")
+
+// ValueReader reads stored bytes in place, through the memory map, as an
+// io.ReadSeeker and io.ReaderAt, e.g. to serve a large stored asset with
+// http.ServeContent, which handles range requests.  The bytes are those of
+// a snapshot pinned by a read transaction that the ValueReader holds until
+// Close: it uses a read slot, and the pages of the snapshot cannot be reused
+// by writers meanwhile, so a ValueReader must be closed promptly.
+//
+// Read and Seek share the offset of the reader and must not be called
+// concurrently; ReadAt may be called concurrently with any method but Close.
+type ValueReader struct {
+	mu    sync.RWMutex
+	txn   *Txn // nil once closed
+	pin   *SnapshotPin
+	parts [][]byte
+	ends  []int64 // offset following each part
+	size  int64
+	off   int64
+}
+
+// OpenValue returns a ValueReader over the value of key in dbi.  In a
+// DupSort database it reads the first value of key.
+func (env *Env) OpenValue(dbi DBI, key []byte) (*ValueReader, error) {
+	return env.openValueReader(func(txn *Txn) ([][]byte, error) {
+		v, err := txn.Get(dbi, key)
+		if err != nil {
+			return nil, err
+		}
+		return [][]byte{v}, nil
+	})
+}
+
+// OpenRange returns a ValueReader over the values of the keys of dbi from
+// start, inclusive, to end, exclusive, concatenated in key order, e.g. the
+// chunks of an asset stored under consecutive keys.  A nil end reads to the
+// last key.  In a DupSort database every value of each key is read.
+func (env *Env) OpenRange(dbi DBI, start, end []byte) (*ValueReader, error) {
+	return env.openValueReader(func(txn *Txn) ([][]byte, error) {
+		cur, err := txn.OpenCursor(dbi)
+		if err != nil {
+			return nil, err
+		}
+		defer cur.Close()
+		var parts [][]byte
+		var k, v []byte
+		if start == nil {
+			k, v, err = cur.Get(nil, nil, First)
+		} else {
+			k, v, err = cur.Get(start, nil, SetRange)
+		}
+		for err == nil && (end == nil || bytes.Compare(k, end) < 0) {
+			parts = append(parts, v)
+			k, v, err = cur.Get(nil, nil, Next)
+		}
+		if err != nil && !IsNotFound(err) {
+			return nil, err
+		}
+		return parts, nil
+	})
+}
+
+// openValueReader begins the read transaction of a ValueReader over the
+// parts read by fn.
+func (env *Env) openValueReader(fn func(txn *Txn) ([][]byte, error)) (*ValueReader, error) {
+	txn, err := env.BeginTxn(nil, Readonly)
+	if err != nil {
+		return nil, err
+	}
+	txn.RawRead = true
+	parts, err := fn(txn)
+	if err != nil {
+		txn.Abort()
+		return nil, err
+	}
+	r := &ValueReader{txn: txn, parts: parts, ends: make([]int64, len(parts))}
+	r.pin = env.PinSnapshot(txn, "value-reader")
+	for i, p := range parts {
+		r.size += int64(len(p))
+		r.ends[i] = r.size
+	}
+	return r, nil
+}
+
+// Size returns the number of bytes read by r.
+func (r *ValueReader) Size() int64 {
+	return r.size
+}
+
+// ReadAt implements io.ReaderAt.
+func (r *ValueReader) ReadAt(p []byte, off int64) (int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.txn == nil {
+		return 0, errValueReaderClosed
+	}
+	if off < 0 {
+		return 0, errValueReaderOffset
+	}
+	n := 0
+	// the first part ending after off.
+	i := sort.Search(len(r.ends), func(i int) bool { return r.ends[i] > off })
+	for ; i < len(r.parts) && n < len(p); i++ {
+		start := r.ends[i] - int64(len(r.parts[i]))
+		c := copy(p[n:], r.parts[i][off-start:])
+		n += c
+		off += int64(c)
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Read implements io.Reader.
+func (r *ValueReader) Read(p []byte) (int, error) {
+	if r.off >= r.size {
+		r.mu.RLock()
+		closed := r.txn == nil
+		r.mu.RUnlock()
+		if closed {
+			return 0, errValueReaderClosed
+		}
+		return 0, io.EOF
+	}
+	n, err := r.ReadAt(p, r.off)
+	r.off += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// Seek implements io.Seeker.
+func (r *ValueReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.off
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, errValueReaderWhence
+	}
+	if offset < 0 {
+		return 0, errValueReaderOffset
+	}
+	r.off = offset
+	return offset, nil
+}
+
+// Close ends the read transaction of r.  The bytes of r must not be used
+// after Close.  Close is idempotent.
+func (r *ValueReader) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.txn != nil {
+		r.pin.Release()
+		r.txn.Abort()
+		r.txn = nil
+		r.parts = nil
+	}
+	return nil
+}
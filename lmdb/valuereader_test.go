@@ -0,0 +1,130 @@
+package lmdb
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestEnv_OpenRange(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+
+	// an asset stored in chunks of varying size, between other keys.
+	var asset []byte
+	var dbi DBI
+	err := env.Update(func(txn *Txn) (err error) {
+		dbi, err = txn.OpenDBI("assets", Create)
+		if err != nil {
+			return err
+		}
+		for _, k := range []string{"a", "c"} {
+			if err := txn.Put(dbi, []byte(k), []byte("other"), 0); err != nil {
+				return err
+			}
+		}
+		for i := 0; i < 10; i++ {
+			chunk := bytes.Repeat([]byte{byte('0' + i)}, i*100)
+			asset = append(asset, chunk...)
+			if err := txn.Put(dbi, []byte(fmt.Sprintf("b/%02d", i)), chunk, 0); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := env.OpenRange(dbi, []byte("b/"), []byte("b0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if r.Size() != int64(len(asset)) {
+		t.Fatalf("size %d, want %d", r.Size(), len(asset))
+	}
+	all, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(all, asset) {
+		t.Error("read bytes differ from the asset")
+	}
+
+	// across chunk boundaries.
+	p := make([]byte, 250)
+	n, err := r.ReadAt(p, 1000)
+	if err != nil || n != len(p) || !bytes.Equal(p, asset[1000:1250]) {
+		t.Errorf("ReadAt = %d, %v", n, err)
+	}
+	n, err = r.ReadAt(p, int64(len(asset))-10)
+	if err != io.EOF || n != 10 {
+		t.Errorf("ReadAt at the end = %d, %v", n, err)
+	}
+	off, err := r.Seek(-5, io.SeekEnd)
+	if err != nil || off != int64(len(asset))-5 {
+		t.Errorf("Seek = %d, %v", off, err)
+	}
+
+	// a range request, the intended use.
+	req := httptest.NewRequest("GET", "/asset", nil)
+	req.Header.Set("Range", "bytes=150-349")
+	rec := httptest.NewRecorder()
+	http.ServeContent(rec, req, "asset", time.Time{}, r)
+	if rec.Code != http.StatusPartialContent {
+		t.Fatalf("status %d", rec.Code)
+	}
+	if !bytes.Equal(rec.Body.Bytes(), asset[150:350]) {
+		t.Error("range response differs from the asset")
+	}
+
+	r.Close()
+	if _, err := r.ReadAt(p, 0); err != errValueReaderClosed {
+		t.Errorf("ReadAt after Close = %v", err)
+	}
+}
+
+func TestEnv_OpenValue(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+
+	var dbi DBI
+	err := env.Update(func(txn *Txn) (err error) {
+		dbi, err = txn.OpenRoot(0)
+		if err != nil {
+			return err
+		}
+		return txn.Put(dbi, []byte("k"), []byte("value"), 0)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := env.OpenValue(dbi, []byte("k"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the snapshot is pinned: a later write is not seen.
+	err = env.Update(func(txn *Txn) error {
+		return txn.Put(dbi, []byte("k"), []byte("changed"), 0)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(r)
+	r.Close()
+	if err != nil || string(b) != "value" {
+		t.Errorf("read %q, %v", b, err)
+	}
+
+	_, err = env.OpenValue(dbi, []byte("missing"))
+	if !IsNotFound(err) {
+		t.Errorf("err = %v, want NotFound", err)
+	}
+}
@@ -0,0 +1,423 @@
+package lmdb
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"sort"
+)
+
+// DefaultVerifyProgressEvery is the number of items between the calls of
+// VerifyOptions.Progress when VerifyOptions.ProgressEvery is zero.
+const DefaultVerifyProgressEvery = 100000
+
+// DefaultVerifyMaxMismatches is the number of mismatches reported by
+// VerifyBackup when VerifyOptions.MaxMismatches is zero.
+const DefaultVerifyMaxMismatches = 100
+
+// ErrBackupMismatch indicates a backup whose contents differ from those of
+// its source.  VerifyBackup then returns a *BackupMismatchError for which
+// errors.Is(err, ErrBackupMismatch) is true.
+var ErrBackupMismatch = errors.New("backup differs from its source")
+
+// BackupMismatchError describes a failed verification of a backup.
+type BackupMismatchError struct {
+	Path       string
+	Mismatches uint64         // number of mismatches found
+	First      BackupMismatch // first mismatch found
+}
+
+func (err *BackupMismatchError) Error() string {
+	return fmt.Sprintf("%v: %s: %d mismatches, first %v", ErrBackupMismatch, err.Path, err.Mismatches, err.First)
+}
+
+// Is allows errors.Is(err, ErrBackupMismatch) to match a
+// *BackupMismatchError.
+func (err *BackupMismatchError) Is(target error) bool {
+	return target == ErrBackupMismatch
+}
+
+// BackupMismatch is a difference between a source environment and its
+// backup.  Kind is DiffRemoved for what the backup lacks, DiffAdded for
+// what only the backup holds, and DiffChanged for values or database flags
+// that differ.  Key is nil for the mismatches of whole databases, described
+// by Detail.
+type BackupMismatch struct {
+	DB     string // name of the database, "" for the main database
+	Kind   DiffKind
+	Key    []byte
+	Source []byte // value in the source, nil if absent
+	Backup []byte // value in the backup, nil if absent
+	Detail string
+}
+
+func (m BackupMismatch) String() string {
+	if m.Key == nil {
+		return fmt.Sprintf("%s in db %q: %s", m.Kind, m.DB, m.Detail)
+	}
+	return fmt.Sprintf("%s key %q in db %q", m.Kind, m.Key, m.DB)
+}
+
+// VerifyOptions controls VerifyBackup.
+type VerifyOptions struct {
+	// DBs names the databases to verify, "" being the main database.  If
+	// empty the main database and every named database of the source or of
+	// the backup are verified.
+	DBs []string
+
+	// MaxDBs is the number of named databases the backup is opened with,
+	// that of the source if zero.
+	MaxDBs int
+
+	// MaxMismatches bounds the mismatches kept in the report,
+	// DefaultVerifyMaxMismatches if zero.  All of them are counted.
+	MaxMismatches int
+
+	// Digest computes a SHA-256 digest of the items of each database, on
+	// both sides, to be recorded e.g. along with the backup.
+	Digest bool
+
+	// Progress, if not nil, is called every ProgressEvery items compared,
+	// DefaultVerifyProgressEvery if zero, and once each database is
+	// verified.
+	Progress      func(VerifyProgress)
+	ProgressEvery int
+}
+
+// VerifyProgress describes the progress of VerifyBackup.
+type VerifyProgress struct {
+	DB         string // database being verified
+	Items      uint64 // items compared in all databases
+	Mismatches uint64 // mismatches found so far
+}
+
+// VerifyReport describes a verification made by VerifyBackup.
+type VerifyReport struct {
+	// SourceTxnID and BackupTxnID are the ids of the snapshots compared.
+	// A backup made by Env.Copy has the id of the snapshot it copied, so
+	// that if they differ, mismatches may be writes committed to the source
+	// since the backup was made.
+	SourceTxnID uintptr
+	BackupTxnID uintptr
+
+	DBs        []VerifyDBReport
+	Items      uint64 // items compared
+	Mismatches uint64
+	Diffs      []BackupMismatch // the first MaxMismatches mismatches
+}
+
+// VerifyDBReport describes the verification of a database.  The entries of
+// the main database exclude the records of the named databases.
+type VerifyDBReport struct {
+	Name          string
+	SourceEntries uint64
+	BackupEntries uint64
+	Mismatches    uint64
+
+	// SourceDigest and BackupDigest are the hexadecimal SHA-256 digests of
+	// the items, with VerifyOptions.Digest.
+	SourceDigest string
+	BackupDigest string
+}
+
+// VerifyBackup checks that the backup environment at backupPath, a
+// directory or a file made by Env.Copy, holds the same databases, with the
+// same flags, keys and values as src, so that operators can rely on the
+// backup being restorable.  The backup is opened read-only and both
+// environments are walked in key order, each within a read transaction,
+// comparing the items of each database and counting them.  Mismatches are
+// reported, and fail the verification with a *BackupMismatchError along
+// with the report; other errors abort it.
+//
+// The snapshot of src compared is the current one, so src should not be
+// written between the backup and its verification, see
+// VerifyReport.SourceTxnID.  Keys and values are ordered bytewise when
+// finding what each side lacks, so mismatches in databases using
+// IntegerKey, ReverseKey or custom comparisons may be reported imprecisely,
+// although they are all detected.
+func VerifyBackup(src *Env, backupPath string, opts *VerifyOptions) (*VerifyReport, error) {
+	var o VerifyOptions
+	if opts != nil {
+		o = *opts
+	}
+	if o.MaxDBs == 0 {
+		o.MaxDBs = src.maxDBs
+	}
+	if o.MaxMismatches <= 0 {
+		o.MaxMismatches = DefaultVerifyMaxMismatches
+	}
+	if o.ProgressEvery <= 0 {
+		o.ProgressEvery = DefaultVerifyProgressEvery
+	}
+	backup, err := openBackup(backupPath, &Options{MaxDBs: o.MaxDBs})
+	if err != nil {
+		return nil, err
+	}
+	defer backup.Close()
+
+	v := &verifier{opts: &o, report: &VerifyReport{}}
+	err = src.View(func(stxn *Txn) error {
+		stxn.RawRead = true
+		return backup.View(func(btxn *Txn) error {
+			btxn.RawRead = true
+			v.report.SourceTxnID, v.report.BackupTxnID = stxn.ID(), btxn.ID()
+			names := o.DBs
+			if len(names) == 0 {
+				snames, err := stxn.dbNames()
+				if err != nil {
+					return err
+				}
+				bnames, err := btxn.dbNames()
+				if err != nil {
+					return err
+				}
+				names = unionNames(snames, bnames)
+			}
+			for _, name := range names {
+				if err := v.verifyDB(stxn, btxn, name); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	if v.report.Mismatches > 0 {
+		return v.report, &BackupMismatchError{Path: backupPath, Mismatches: v.report.Mismatches, First: v.report.Diffs[0]}
+	}
+	return v.report, nil
+}
+
+// dbNames returns the names of the databases of txn, "" for the main
+// database first.
+func (txn *Txn) dbNames() ([]string, error) {
+	names := []string{""}
+	root, err := txn.OpenRoot(0)
+	if err != nil {
+		return nil, err
+	}
+	cur, err := txn.OpenCursor(root)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close()
+	for k, v, err := cur.Get(nil, nil, First); ; k, v, err = cur.Get(nil, nil, Next) {
+		if IsNotFound(err) {
+			return names, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		isDB, err := txn.isDBName(k, v)
+		if err != nil {
+			return nil, err
+		}
+		if isDB {
+			names = append(names, string(k))
+		}
+	}
+}
+
+// unionNames returns the names in a or b, sorted.
+func unionNames(a, b []string) []string {
+	seen := make(map[string]bool, len(a))
+	var names []string
+	for _, list := range [][]string{a, b} {
+		for _, name := range list {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+type verifier struct {
+	opts   *VerifyOptions
+	report *VerifyReport
+}
+
+// verifySide is the walk of a database on one side of a verification.
+type verifySide struct {
+	txn    *Txn
+	cur    *Cursor
+	root   bool // the main database, whose database records are skipped
+	head   mergeHead
+	count  uint64
+	digest hash.Hash
+}
+
+func (s *verifySide) open(name string) (ok bool, err error) {
+	var dbi DBI
+	if name == "" {
+		s.root = true
+		dbi, err = s.txn.OpenRoot(0)
+	} else {
+		dbi, err = s.txn.OpenDBI(name, 0)
+	}
+	if IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	s.cur, err = s.txn.OpenCursor(dbi)
+	return err == nil, err
+}
+
+// next positions s at its next item, the first one if first is true.
+func (s *verifySide) next(first bool) error {
+	op := uint(Next)
+	if first {
+		op = First
+	}
+	for {
+		k, v, err := s.cur.Get(nil, nil, op)
+		op = Next
+		if IsNotFound(err) {
+			s.head = mergeHead{}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if s.root {
+			isDB, err := s.txn.isDBName(k, v)
+			if err != nil {
+				return err
+			}
+			if isDB {
+				continue
+			}
+		}
+		s.head = mergeHead{key: k, val: v, ok: true}
+		s.count++
+		if s.digest != nil {
+			s.digest.Write(appendUvarint(nil, uint64(len(k))))
+			s.digest.Write(k)
+			s.digest.Write(appendUvarint(nil, uint64(len(v))))
+			s.digest.Write(v)
+		}
+		return nil
+	}
+}
+
+func (s *verifySide) sum() string {
+	if s.digest == nil {
+		return ""
+	}
+	return hex.EncodeToString(s.digest.Sum(nil))
+}
+
+func (v *verifier) mismatch(db *VerifyDBReport, m BackupMismatch) {
+	db.Mismatches++
+	v.report.Mismatches++
+	if len(v.report.Diffs) < v.opts.MaxMismatches {
+		m.Key, m.Source, m.Backup = cloneBytes(m.Key), cloneBytes(m.Source), cloneBytes(m.Backup)
+		v.report.Diffs = append(v.report.Diffs, m)
+	}
+}
+
+func (v *verifier) progress(name string) {
+	if v.opts.Progress != nil {
+		v.opts.Progress(VerifyProgress{DB: name, Items: v.report.Items, Mismatches: v.report.Mismatches})
+	}
+}
+
+func (v *verifier) verifyDB(stxn, btxn *Txn, name string) error {
+	db := VerifyDBReport{Name: name}
+	defer func() {
+		v.report.DBs = append(v.report.DBs, db)
+		v.progress(name)
+	}()
+	s, b := &verifySide{txn: stxn}, &verifySide{txn: btxn}
+	if v.opts.Digest {
+		s.digest, b.digest = sha256.New(), sha256.New()
+	}
+	sok, err := s.open(name)
+	if err != nil {
+		return err
+	}
+	if s.cur != nil {
+		defer s.cur.Close()
+	}
+	bok, err := b.open(name)
+	if err != nil {
+		return err
+	}
+	if b.cur != nil {
+		defer b.cur.Close()
+	}
+	switch {
+	case !sok && !bok:
+		return nil
+	case !bok:
+		v.mismatch(&db, BackupMismatch{DB: name, Kind: DiffRemoved, Detail: "database missing from the backup"})
+		return nil
+	case !sok:
+		v.mismatch(&db, BackupMismatch{DB: name, Kind: DiffAdded, Detail: "database missing from the source"})
+		return nil
+	}
+	sflags, err := stxn.Flags(s.cur.DBI())
+	if err != nil {
+		return err
+	}
+	bflags, err := btxn.Flags(b.cur.DBI())
+	if err != nil {
+		return err
+	}
+	if sflags != bflags {
+		v.mismatch(&db, BackupMismatch{DB: name, Kind: DiffChanged, Detail: fmt.Sprintf("flags %#x, %#x in the backup", sflags, bflags)})
+	}
+	dupsort := sflags&bflags&DupSort != 0
+
+	if err = s.next(true); err != nil {
+		return err
+	}
+	if err = b.next(true); err != nil {
+		return err
+	}
+	for s.head.ok || b.head.ok {
+		c := -1
+		if !s.head.ok {
+			c = 1
+		} else if b.head.ok {
+			c = bytes.Compare(s.head.key, b.head.key)
+			if c == 0 && dupsort {
+				c = bytes.Compare(s.head.val, b.head.val)
+			}
+		}
+		switch {
+		case c < 0:
+			v.mismatch(&db, BackupMismatch{DB: name, Kind: DiffRemoved, Key: s.head.key, Source: s.head.val})
+		case c > 0:
+			v.mismatch(&db, BackupMismatch{DB: name, Kind: DiffAdded, Key: b.head.key, Backup: b.head.val})
+		case !bytes.Equal(s.head.val, b.head.val):
+			v.mismatch(&db, BackupMismatch{DB: name, Kind: DiffChanged, Key: s.head.key, Source: s.head.val, Backup: b.head.val})
+		}
+		if c <= 0 {
+			if err = s.next(false); err != nil {
+				return err
+			}
+		}
+		if c >= 0 {
+			if err = b.next(false); err != nil {
+				return err
+			}
+		}
+		v.report.Items++
+		if v.report.Items%uint64(v.opts.ProgressEvery) == 0 {
+			v.progress(name)
+		}
+	}
+	db.SourceEntries, db.BackupEntries = s.count, b.count
+	db.SourceDigest, db.BackupDigest = s.sum(), b.sum()
+	return nil
+}
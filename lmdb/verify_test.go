@@ -0,0 +1,158 @@
+package lmdb
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestVerifyBackup(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+
+	var dbi, dups DBI
+	err := env.Update(func(txn *Txn) (err error) {
+		dbi, err = txn.OpenDBI("plain", Create)
+		if err != nil {
+			return err
+		}
+		dups, err = txn.OpenDBI("dups", Create|DupSort)
+		if err != nil {
+			return err
+		}
+		root, err := txn.OpenRoot(0)
+		if err != nil {
+			return err
+		}
+		if err = txn.Put(root, []byte("meta"), []byte("1"), 0); err != nil {
+			return err
+		}
+		for i := 0; i < 100; i++ {
+			k := []byte(fmt.Sprintf("k%03d", i))
+			if err = txn.Put(dbi, k, []byte(fmt.Sprint(i)), 0); err != nil {
+				return err
+			}
+			for j := 0; j < 2; j++ {
+				if err = txn.Put(dups, k, []byte(fmt.Sprint(j)), 0); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "mdb_backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// a compacted copy rewrites the records of the named databases.
+	if err = env.CopyFlag(dir, CopyCompact); err != nil {
+		t.Fatal(err)
+	}
+
+	var progress []VerifyProgress
+	report, err := VerifyBackup(env, dir, &VerifyOptions{
+		Digest:        true,
+		ProgressEvery: 150,
+		Progress:      func(p VerifyProgress) { progress = append(progress, p) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Items != 301 || report.SourceTxnID != report.BackupTxnID || len(report.DBs) != 3 {
+		t.Errorf("report %+v", report)
+	}
+	entries := map[string]uint64{"": 1, "dups": 200, "plain": 100}
+	for _, db := range report.DBs {
+		if db.SourceEntries != entries[db.Name] || db.BackupEntries != db.SourceEntries {
+			t.Errorf("db %+v", db)
+		}
+		if db.SourceDigest == "" || db.SourceDigest != db.BackupDigest {
+			t.Errorf("db %q digests %s, %s", db.Name, db.SourceDigest, db.BackupDigest)
+		}
+	}
+	// two within dups, and one per database.
+	if len(progress) != 5 || progress[4].Items != 301 {
+		t.Errorf("progress %+v", progress)
+	}
+
+	err = env.Update(func(txn *Txn) error {
+		if err := txn.Put(dbi, []byte("k001"), []byte("changed"), 0); err != nil {
+			return err
+		}
+		if err := txn.Del(dbi, []byte("k002"), nil); err != nil {
+			return err
+		}
+		if err := txn.Put(dups, []byte("k003"), []byte("2"), 0); err != nil {
+			return err
+		}
+		_, err := txn.OpenDBI("new", Create)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err = VerifyBackup(env, dir, &VerifyOptions{MaxMismatches: 3})
+	var merr *BackupMismatchError
+	if !errors.As(err, &merr) || !errors.Is(err, ErrBackupMismatch) || merr.Mismatches != 4 {
+		t.Fatalf("verification of a stale backup: %v", err)
+	}
+	var diffs []string
+	for _, m := range report.Diffs {
+		diffs = append(diffs, m.String())
+	}
+	want := `removed key "k003" in db "dups"; removed in db "new": database missing from the backup; changed key "k001" in db "plain"`
+	if got := strings.Join(diffs, "; "); got != want || report.Mismatches != 4 {
+		t.Errorf("mismatches %s (%d), want %s", got, report.Mismatches, want)
+	}
+
+	// the changed and removed keys of plain.
+	report, err = VerifyBackup(env, dir, &VerifyOptions{DBs: []string{"plain"}})
+	if err == nil || report.Mismatches != 2 || report.Diffs[1].Kind != DiffAdded {
+		t.Errorf("verification of plain: %v", err)
+	}
+}
+
+func TestVerifyBackup_noMaxDBs(t *testing.T) {
+	path, err := ioutil.TempDir("", "mdb_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(path)
+	env, err := OpenEnv(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer env.Close()
+	err = env.Update(func(txn *Txn) error {
+		root, err := txn.OpenRoot(0)
+		if err != nil {
+			return err
+		}
+		return txn.Put(root, []byte("k"), make([]byte, DBRecordSize), 0)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "mdb_backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err = env.Copy(dir); err != nil {
+		t.Fatal(err)
+	}
+	report, err := VerifyBackup(env, dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Items != 1 || len(report.DBs) != 1 {
+		t.Errorf("report %+v", report)
+	}
+}
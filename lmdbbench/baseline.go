@@ -0,0 +1,84 @@
+package lmdbbench
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Result is the measurement of one benchmark.
+type Result struct {
+	Name        string  `json:"name"`
+	NsPerOp     float64 `json:"ns_per_op"`
+	AllocsPerOp int64   `json:"allocs_per_op"`
+	BytesPerOp  int64   `json:"bytes_per_op"`
+}
+
+// WriteResults writes results to w as JSON, the format read by ReadResults.
+func WriteResults(w io.Writer, results []Result) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(results)
+}
+
+// ReadResults reads results written by WriteResults.
+func ReadResults(r io.Reader) ([]Result, error) {
+	var results []Result
+	err := json.NewDecoder(r).Decode(&results)
+	return results, err
+}
+
+// Tolerance bounds the differences from a baseline that Compare accepts.
+type Tolerance struct {
+	// Time is the accepted fractional increase of NsPerOp, 0.2 (20%) if
+	// zero.  Timings are noisy, so a tight bound needs a quiet machine and
+	// a long -test.benchtime.
+	Time float64
+
+	// Allocs is the accepted increase of AllocsPerOp.  Allocation counts
+	// are deterministic, so the default of zero flags any new allocation.
+	Allocs int64
+}
+
+// Regression is a benchmark that got worse than its baseline.
+type Regression struct {
+	Name     string
+	Metric   string // "ns/op" or "allocs/op"
+	Baseline float64
+	Current  float64
+}
+
+func (r Regression) String() string {
+	return fmt.Sprintf("%s: %s %.6g -> %.6g", r.Name, r.Metric, r.Baseline, r.Current)
+}
+
+// Compare returns the regressions of current from baseline beyond tol.  A
+// nil tol uses the default Tolerance.  Benchmarks missing from either list
+// are ignored.
+func Compare(baseline, current []Result, tol *Tolerance) []Regression {
+	var t Tolerance
+	if tol != nil {
+		t = *tol
+	}
+	if t.Time == 0 {
+		t.Time = 0.2
+	}
+	base := make(map[string]Result, len(baseline))
+	for _, r := range baseline {
+		base[r.Name] = r
+	}
+	var regs []Regression
+	for _, cur := range current {
+		old, ok := base[cur.Name]
+		if !ok {
+			continue
+		}
+		if cur.NsPerOp > old.NsPerOp*(1+t.Time) {
+			regs = append(regs, Regression{cur.Name, "ns/op", old.NsPerOp, cur.NsPerOp})
+		}
+		if cur.AllocsPerOp > old.AllocsPerOp+t.Allocs {
+			regs = append(regs, Regression{cur.Name, "allocs/op", float64(old.AllocsPerOp), float64(cur.AllocsPerOp)})
+		}
+	}
+	return regs
+}
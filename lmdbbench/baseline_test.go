@@ -0,0 +1,129 @@
+package lmdbbench
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"reflect"
+	"testing"
+)
+
+var (
+	baselineFile = flag.String("baseline", "", "compare the suite with the results stored in this file")
+	update       = flag.Bool("update", false, "store the results of the suite in the -baseline file")
+)
+
+// TestBaseline runs the suite and compares it with the -baseline file, or
+// stores the results there with -update.
+func TestBaseline(t *testing.T) {
+	if *baselineFile == "" {
+		t.Skip("no -baseline file")
+	}
+	results, err := run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range results {
+		t.Logf("%s: %.1f ns/op, %d allocs/op, %d B/op", r.Name, r.NsPerOp, r.AllocsPerOp, r.BytesPerOp)
+	}
+	if *update {
+		f, err := os.Create(*baselineFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		err = WriteResults(f, results)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	f, err := os.Open(*baselineFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	baseline, err := ReadResults(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range Compare(baseline, results, nil) {
+		t.Errorf("regression: %v", r)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	baseline := []Result{
+		{Name: "a", NsPerOp: 100, AllocsPerOp: 0},
+		{Name: "b", NsPerOp: 100, AllocsPerOp: 2},
+		{Name: "c", NsPerOp: 100, AllocsPerOp: 1},
+	}
+	current := []Result{
+		{Name: "a", NsPerOp: 119, AllocsPerOp: 1},
+		{Name: "b", NsPerOp: 130, AllocsPerOp: 1},
+		{Name: "c", NsPerOp: 50, AllocsPerOp: 1},
+		{Name: "new", NsPerOp: 1000, AllocsPerOp: 10},
+	}
+	regs := Compare(baseline, current, nil)
+	want := []Regression{
+		{"a", "allocs/op", 0, 1},
+		{"b", "ns/op", 100, 130},
+	}
+	if !reflect.DeepEqual(regs, want) {
+		t.Errorf("regressions: %v, want %v", regs, want)
+	}
+
+	regs = Compare(baseline, current, &Tolerance{Time: 0.5, Allocs: 1})
+	if len(regs) != 0 {
+		t.Errorf("regressions within tolerance: %v", regs)
+	}
+
+	var buf bytes.Buffer
+	err := WriteResults(&buf, baseline)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadResults(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read, baseline) {
+		t.Errorf("read %v, want %v", read, baseline)
+	}
+}
+
+func TestDataset(t *testing.T) {
+	env, err := OpenEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path, _ := env.Path()
+	defer os.RemoveAll(path)
+	defer env.Close()
+
+	ds := Dataset{Name: "test", Items: 100, KeySize: 8, ValSize: 10}
+	seen := make(map[int]bool)
+	for i := 0; i < ds.Items; i++ {
+		seen[ds.nth(i)] = true
+	}
+	if len(seen) != ds.Items {
+		t.Errorf("nth is not a permutation: %d distinct items", len(seen))
+	}
+	if !bytes.Equal(ds.Val(3), ds.Val(3)) || bytes.Equal(ds.Val(3), ds.Val(4)) {
+		t.Errorf("values are not deterministic")
+	}
+	// Loading twice leaves the same content.
+	for i := 0; i < 2; i++ {
+		_, err = ds.Load(env)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	stat, err := env.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.Entries != 1 {
+		t.Errorf("main db entries %d", stat.Entries)
+	}
+}
@@ -0,0 +1,30 @@
+package lmdbbench
+
+import (
+	"os"
+	"testing"
+)
+
+func BenchmarkSuite(b *testing.B) {
+	env, err := OpenEnv()
+	if err != nil {
+		b.Fatal(err)
+	}
+	path, _ := env.Path()
+	defer os.RemoveAll(path)
+	defer env.Close()
+
+	for _, ds := range Datasets {
+		dbi, err := ds.Load(env)
+		if err != nil {
+			b.Fatal(err)
+		}
+		for _, bm := range benchmarks {
+			bm, ds := bm, ds
+			b.Run(ds.Name+"/"+bm.Name, func(b *testing.B) {
+				b.ReportAllocs()
+				bm.Run(b, env, dbi, ds)
+			})
+		}
+	}
+}
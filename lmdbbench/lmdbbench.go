@@ -0,0 +1,101 @@
+/*
+Package lmdbbench is a suite of micro-benchmarks of the lmdb binding over
+fixed datasets, and a helper comparing their results with a stored baseline
+so that regressions in cgo overhead or allocations are caught when the
+binding or the Go toolchain is upgraded.
+
+The benchmarks exercise the hot paths of typical applications (Get, Put,
+cursor iteration and seeks, transaction begin and commit) with deterministic
+keys and values, so consecutive runs measure the same work.  A CPU profile of
+the suite is therefore representative input for profile-guided optimization:
+
+	go test -run NONE -bench . -cpuprofile default.pgo ./lmdbbench
+
+To gate an upgrade, record a baseline with the old version and compare the
+new one against it on the same machine:
+
+	go test -run Baseline ./lmdbbench -baseline base.json -update
+	go test -run Baseline ./lmdbbench -baseline base.json
+*/
+package lmdbbench
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"math/rand"
+	"os"
+
+	"github.com/glycerine/lmdb-go/lmdb"
+)
+
+// Dataset describes a fixed database content.  Item i has the key returned
+// by Key(i) and the value returned by Val(i), which depend only on the
+// Dataset.
+type Dataset struct {
+	Name    string
+	Items   int
+	KeySize int // at least 8
+	ValSize int
+}
+
+// Datasets are the datasets benchmarked by the suite.
+var Datasets = []Dataset{
+	{Name: "small", Items: 1000, KeySize: 16, ValSize: 64},
+	{Name: "medium", Items: 100000, KeySize: 16, ValSize: 256},
+}
+
+// Key returns the key of item i.  Keys sort in item order.
+func (ds Dataset) Key(i int) []byte {
+	k := make([]byte, ds.KeySize)
+	binary.BigEndian.PutUint64(k, uint64(i))
+	return k
+}
+
+// Val returns the value of item i.
+func (ds Dataset) Val(i int) []byte {
+	v := make([]byte, ds.ValSize)
+	rand.New(rand.NewSource(int64(i))).Read(v)
+	return v
+}
+
+// Load creates the database named ds.Name in env and fills it with the
+// items of ds.
+func (ds Dataset) Load(env *lmdb.Env) (dbi lmdb.DBI, err error) {
+	err = env.Update(func(txn *lmdb.Txn) (err error) {
+		dbi, err = txn.OpenDBI(ds.Name, lmdb.Create)
+		if err != nil {
+			return err
+		}
+		err = txn.Drop(dbi, false)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < ds.Items; i++ {
+			err = txn.Put(dbi, ds.Key(i), ds.Val(i), lmdb.Append)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return dbi, err
+}
+
+// OpenEnv opens an environment suitable for the suite in a new temporary
+// directory.  The caller must close the environment and remove its path.
+func OpenEnv() (*lmdb.Env, error) {
+	dir, err := ioutil.TempDir("", "lmdbbench-")
+	if err != nil {
+		return nil, err
+	}
+	env, err := lmdb.OpenEnv(dir, &lmdb.Options{
+		MaxDBs:  len(Datasets),
+		MapSize: 1 << 30,
+		Flags:   lmdb.NoSync,
+	})
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	return env, nil
+}
@@ -0,0 +1,213 @@
+package lmdbbench
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/glycerine/lmdb-go/lmdb"
+)
+
+// nth returns the i-th item of a fixed pseudo-random permutation of the
+// items of ds, so that lookups do not walk the database in order.
+func (ds Dataset) nth(i int) int {
+	return int((uint64(i) * 2654435761) % uint64(ds.Items))
+}
+
+// benchmark is a benchmark run against a loaded Dataset.  Each of the b.N
+// operations it times is a single call of the binding unless the name says
+// otherwise.
+type benchmark struct {
+	Name string
+	Run  func(b *testing.B, env *lmdb.Env, dbi lmdb.DBI, ds Dataset)
+}
+
+// benchmarks are the benchmarks of the suite.
+var benchmarks = []benchmark{
+	{"Get", benchGet(false)},
+	{"GetRaw", benchGet(true)},
+	{"Put", benchPut},
+	{"CursorNext", benchCursorNext},
+	{"CursorSetRange", benchCursorSetRange},
+	{"ViewTxn", benchViewTxn},
+	{"UpdateTxn", benchUpdateTxn},
+}
+
+func benchGet(raw bool) func(*testing.B, *lmdb.Env, lmdb.DBI, Dataset) {
+	return func(b *testing.B, env *lmdb.Env, dbi lmdb.DBI, ds Dataset) {
+		keys := make([][]byte, ds.Items)
+		for i := range keys {
+			keys[i] = ds.Key(ds.nth(i))
+		}
+		err := env.View(func(txn *lmdb.Txn) (err error) {
+			txn.RawRead = raw
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_, err = txn.Get(dbi, keys[i%len(keys)])
+				if err != nil {
+					return err
+				}
+			}
+			b.StopTimer()
+			return nil
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchPut(b *testing.B, env *lmdb.Env, dbi lmdb.DBI, ds Dataset) {
+	keys := make([][]byte, ds.Items)
+	for i := range keys {
+		keys[i] = ds.Key(ds.nth(i))
+	}
+	val := ds.Val(0)
+	// The puts are aborted so that the dataset is unchanged for the
+	// benchmarks that follow.
+	err := env.Update(func(txn *lmdb.Txn) (err error) {
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			err = txn.Put(dbi, keys[i%len(keys)], val, 0)
+			if err != nil {
+				return err
+			}
+		}
+		b.StopTimer()
+		return errAbort
+	})
+	if err != errAbort {
+		b.Fatal(err)
+	}
+}
+
+func benchCursorNext(b *testing.B, env *lmdb.Env, dbi lmdb.DBI, ds Dataset) {
+	err := env.View(func(txn *lmdb.Txn) (err error) {
+		txn.RawRead = true
+		cur, err := txn.OpenCursor(dbi)
+		if err != nil {
+			return err
+		}
+		defer cur.Close()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			_, _, err = cur.Get(nil, nil, lmdb.Next)
+			if lmdb.IsNotFound(err) {
+				_, _, err = cur.Get(nil, nil, lmdb.First)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		b.StopTimer()
+		return nil
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+}
+
+func benchCursorSetRange(b *testing.B, env *lmdb.Env, dbi lmdb.DBI, ds Dataset) {
+	keys := make([][]byte, ds.Items)
+	for i := range keys {
+		keys[i] = ds.Key(ds.nth(i))
+	}
+	err := env.View(func(txn *lmdb.Txn) (err error) {
+		txn.RawRead = true
+		cur, err := txn.OpenCursor(dbi)
+		if err != nil {
+			return err
+		}
+		defer cur.Close()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			_, _, err = cur.Get(keys[i%len(keys)], nil, lmdb.SetRange)
+			if err != nil {
+				return err
+			}
+		}
+		b.StopTimer()
+		return nil
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+}
+
+// benchViewTxn times a View transaction doing one Get.
+func benchViewTxn(b *testing.B, env *lmdb.Env, dbi lmdb.DBI, ds Dataset) {
+	key := ds.Key(ds.Items / 2)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err := env.View(func(txn *lmdb.Txn) (err error) {
+			txn.RawRead = true
+			_, err = txn.Get(dbi, key)
+			return err
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// benchUpdateTxn times an Update transaction doing one Put and committing.
+// The environment is opened with NoSync so the commit is not dominated by
+// the disk.
+func benchUpdateTxn(b *testing.B, env *lmdb.Env, dbi lmdb.DBI, ds Dataset) {
+	key := ds.Key(ds.Items / 2)
+	val := ds.Val(ds.Items / 2)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err := env.Update(func(txn *lmdb.Txn) (err error) {
+			return txn.Put(dbi, key, val, 0)
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+var errAbort = errors.New("lmdbbench: abort")
+
+// run runs every benchmark against every dataset, each benchmark for the
+// duration set by the -test.benchtime flag (1s by default), and returns
+// the results named dataset/benchmark.
+func run() ([]Result, error) {
+	env, err := OpenEnv()
+	if err != nil {
+		return nil, err
+	}
+	path, _ := env.Path()
+	defer os.RemoveAll(path)
+	defer env.Close()
+
+	var results []Result
+	for _, ds := range Datasets {
+		dbi, err := ds.Load(env)
+		if err != nil {
+			return nil, err
+		}
+		for _, bm := range benchmarks {
+			bm := bm
+			r := testing.Benchmark(func(b *testing.B) {
+				b.ReportAllocs()
+				bm.Run(b, env, dbi, ds)
+			})
+			results = append(results, newResult(ds.Name+"/"+bm.Name, r))
+		}
+	}
+	return results, nil
+}
+
+func newResult(name string, r testing.BenchmarkResult) Result {
+	var ns float64
+	if r.N > 0 {
+		ns = float64(r.T.Nanoseconds()) / float64(r.N)
+	}
+	return Result{
+		Name:        name,
+		NsPerOp:     ns,
+		AllocsPerOp: r.AllocsPerOp(),
+		BytesPerOp:  r.AllocedBytesPerOp(),
+	}
+}
@@ -0,0 +1,294 @@
+/*
+Package lmdbdoctor inspects an open environment and the host it runs on for
+configurations that are known to cause trouble with LMDB, and describes each
+problem together with what to do about it.  The lmdbdoctor command runs the
+same checks from the command line.
+
+The checks are heuristics.  A finding is not necessarily a bug, but each one
+is worth understanding before running the environment in production.
+*/
+package lmdbdoctor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/glycerine/lmdb-go/int/lmdbarch"
+	"github.com/glycerine/lmdb-go/lmdb"
+)
+
+// Severity ranks findings.
+type Severity int
+
+// Severities of findings, from least to most serious.
+const (
+	Info Severity = iota
+	Warning
+	Error
+)
+
+func (s Severity) String() string {
+	switch s {
+	case Info:
+		return "info"
+	case Warning:
+		return "warning"
+	case Error:
+		return "error"
+	}
+	return fmt.Sprintf("Severity(%d)", int(s))
+}
+
+// Finding is a single diagnostic.
+type Finding struct {
+	Severity Severity
+	Check    string // short name of the check that produced the finding
+	Message  string // what was found
+	Advice   string // what to do about it
+}
+
+func (f Finding) String() string {
+	return fmt.Sprintf("%s: %s: %s (%s)", f.Severity, f.Check, f.Message, f.Advice)
+}
+
+// Options describes how the application uses the environment, which some
+// checks cannot infer.
+type Options struct {
+	// PeriodicSync is true if the application calls Env.Sync on its own,
+	// which makes the NoSync and MapAsync flags safe.
+	PeriodicSync bool
+
+	// MapUsageWarn and MapUsageError are the fractions of the map in use
+	// above which a warning or an error is reported, 0.8 and 0.95 if zero.
+	MapUsageWarn  float64
+	MapUsageError float64
+}
+
+// Check runs every check against env and returns the findings, most serious
+// first.  A nil opts uses the defaults.
+func Check(env *lmdb.Env, opts *Options) ([]Finding, error) {
+	var o Options
+	if opts != nil {
+		o = *opts
+	}
+	if o.MapUsageWarn == 0 {
+		o.MapUsageWarn = 0.8
+	}
+	if o.MapUsageError == 0 {
+		o.MapUsageError = 0.95
+	}
+
+	d := &doctor{env: env, opts: &o}
+	for _, check := range []func() error{
+		d.checkMapUsage,
+		d.checkAddressSpace,
+		d.checkSync,
+		d.checkReaders,
+		d.checkLockFile,
+		d.checkFilesystem,
+		d.checkHotKeys,
+	} {
+		err := check()
+		if err != nil {
+			return nil, err
+		}
+	}
+	sortFindings(d.findings)
+	return d.findings, nil
+}
+
+type doctor struct {
+	env      *lmdb.Env
+	opts     *Options
+	findings []Finding
+}
+
+func (d *doctor) add(sev Severity, check, advice, format string, v ...interface{}) {
+	d.findings = append(d.findings, Finding{
+		Severity: sev,
+		Check:    check,
+		Message:  fmt.Sprintf(format, v...),
+		Advice:   advice,
+	})
+}
+
+func sortFindings(fs []Finding) {
+	// insertion sort keeps findings of equal severity in check order.
+	for i := 1; i < len(fs); i++ {
+		for j := i; j > 0 && fs[j].Severity > fs[j-1].Severity; j-- {
+			fs[j], fs[j-1] = fs[j-1], fs[j]
+		}
+	}
+}
+
+func (d *doctor) checkMapUsage() error {
+	info, err := d.env.Info()
+	if err != nil {
+		return err
+	}
+	stat, err := d.env.Stat()
+	if err != nil {
+		return err
+	}
+	used := (info.LastPNO + 1) * int64(stat.PSize)
+	usage := float64(used) / float64(info.MapSize)
+	advice := "increase the map size with Env.SetMapSize before the map fills up; a larger map costs only address space"
+	switch {
+	case usage >= d.opts.MapUsageError:
+		d.add(Error, "mapsize", advice, "%d of %d map bytes in use (%.0f%%)", used, info.MapSize, 100*usage)
+	case usage >= d.opts.MapUsageWarn:
+		d.add(Warning, "mapsize", advice, "%d of %d map bytes in use (%.0f%%)", used, info.MapSize, 100*usage)
+	}
+	return nil
+}
+
+func (d *doctor) checkAddressSpace() error {
+	if lmdbarch.Width64 == 1 {
+		return nil
+	}
+	info, err := d.env.Info()
+	if err != nil {
+		return err
+	}
+	if info.MapSize > 1<<31 {
+		d.add(Error, "32bit", "use a 64-bit build or keep the map size below 2GB",
+			"map size %d exceeds the 2GB usable address space of a 32-bit process", info.MapSize)
+	} else if info.MapSize > 1<<30 {
+		d.add(Warning, "32bit", "use a 64-bit build for large databases",
+			"map size %d uses much of the address space of a 32-bit process", info.MapSize)
+	}
+	return nil
+}
+
+func (d *doctor) checkSync() error {
+	flags, err := d.env.Flags()
+	if err != nil {
+		return err
+	}
+	if flags&lmdb.Readonly != 0 || d.opts.PeriodicSync {
+		return nil
+	}
+	if flags&lmdb.NoSync != 0 {
+		d.add(Warning, "nosync", "call Env.Sync periodically or accept losing recent commits on a crash",
+			"NoSync is set and no periodic Env.Sync was declared; a system crash loses every commit since the last sync")
+	} else if flags&lmdb.MapAsync != 0 && flags&lmdb.WriteMap != 0 {
+		d.add(Warning, "nosync", "call Env.Sync periodically",
+			"WriteMap|MapAsync is set and no periodic Env.Sync was declared")
+	}
+	if flags&lmdb.NoMetaSync != 0 {
+		d.add(Info, "nosync", "call Env.Sync periodically if the last commit must survive a system crash",
+			"NoMetaSync is set; a system crash may undo the last commit")
+	}
+	return nil
+}
+
+// checkHotKeys reports the keys found by Env.TrackHotKeys.  It finds nothing
+// unless the application enabled tracking.
+func (d *doctor) checkHotKeys() error {
+	for _, k := range d.env.HotKeys() {
+		d.add(Warning, "hotkey", "spread the writes over shard keys with lmdb.Counter, or append them to a DupSort log that is folded periodically",
+			"key %q of dbi %d was written by about %d commits in %v (%.1f/s)", k.Key, k.DBI, k.Commits, k.Window.Round(time.Millisecond), k.Rate)
+	}
+	return nil
+}
+
+func (d *doctor) checkReaders() error {
+	info, err := d.env.Info()
+	if err != nil {
+		return err
+	}
+	procs := runtime.GOMAXPROCS(0)
+	if int(info.MaxReaders) < procs {
+		d.add(Warning, "readers", "raise the limit with Env.SetMaxReaders or NewEnvMaxReaders",
+			"%d reader slots for GOMAXPROCS=%d; concurrent readers will queue for slots", info.MaxReaders, procs)
+	}
+	if info.MaxReaders > 0 && info.NumReaders*10 >= info.MaxReaders*9 {
+		d.add(Warning, "readers", "look for leaked or long-lived read transactions and run Env.ReaderCheck to clear slots of dead processes",
+			"%d of %d reader slots have been used", info.NumReaders, info.MaxReaders)
+	}
+	return nil
+}
+
+// dataAndLockPaths returns the paths of the files of env.
+func dataAndLockPaths(env *lmdb.Env) (data, lock string, err error) {
+	path, err := env.Path()
+	if err != nil {
+		return "", "", err
+	}
+	flags, err := env.Flags()
+	if err != nil {
+		return "", "", err
+	}
+	if flags&lmdb.NoSubdir != 0 {
+		return path, path + "-lock", nil
+	}
+	return filepath.Join(path, "data.mdb"), filepath.Join(path, "lock.mdb"), nil
+}
+
+func (d *doctor) checkLockFile() error {
+	flags, err := d.env.Flags()
+	if err != nil {
+		return err
+	}
+	if flags&lmdb.NoLock != 0 {
+		d.add(Info, "lockfile", "make sure the application serializes writers and readers itself",
+			"NoLock is set; LMDB provides no concurrency control")
+		return nil
+	}
+	data, lock, err := dataAndLockPaths(d.env)
+	if err != nil {
+		return err
+	}
+	lfi, err := os.Stat(lock)
+	if err != nil {
+		d.add(Error, "lockfile", "check that the lock file exists and is accessible", "%v", err)
+		return nil
+	}
+	f, err := os.OpenFile(lock, os.O_RDWR, 0)
+	if err != nil {
+		d.add(Error, "lockfile", "every process opening the environment, readers included, needs write access to the lock file",
+			"lock file is not writable: %v", err)
+	} else {
+		f.Close()
+	}
+	dfi, err := os.Stat(data)
+	if err == nil && lfi.Mode().Perm() != dfi.Mode().Perm() {
+		d.add(Info, "lockfile", "give the lock file the same permissions as the data file so that every user of the data can also lock it",
+			"lock file mode %v differs from data file mode %v", lfi.Mode().Perm(), dfi.Mode().Perm())
+	}
+	users, err := d.env.LockUsers()
+	if err != nil {
+		return err
+	}
+	var pids []int
+	for _, u := range users {
+		if u.PID != os.Getpid() {
+			pids = append(pids, u.PID)
+		}
+	}
+	if len(pids) > 0 {
+		d.add(Info, "lockfile", "make sure every process is meant to share the environment, or open it with lmdb.Options.Exclusive",
+			"the environment is also used by processes %v", pids)
+	}
+	return nil
+}
+
+func (d *doctor) checkFilesystem() error {
+	path, err := d.env.Path()
+	if err != nil {
+		return err
+	}
+	name, err := remoteFilesystem(path)
+	if err != nil {
+		d.add(Info, "filesystem", "check the filesystem manually", "filesystem type unknown: %v", err)
+		return nil
+	}
+	if name != "" {
+		d.add(Error, "filesystem", "move the environment to a local filesystem; LMDB locking and mmap coherence do not work over network filesystems",
+			"environment is on a %s filesystem", name)
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package lmdbdoctor
+
+import (
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/glycerine/lmdb-go/int/lmdbtest"
+	"github.com/glycerine/lmdb-go/lmdb"
+)
+
+func findChecks(fs []Finding) map[string]Severity {
+	checks := make(map[string]Severity)
+	for _, f := range fs {
+		if sev, ok := checks[f.Check]; !ok || f.Severity > sev {
+			checks[f.Check] = f.Severity
+		}
+	}
+	return checks
+}
+
+func TestCheck(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+
+	env, err := lmdbtest.NewEnv(&lmdbtest.EnvOptions{MaxReaders: 2, Flags: lmdb.NoSync})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lmdbtest.Destroy(env)
+
+	findings, err := Check(env, &Options{MapUsageWarn: 1e-9})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checks := findChecks(findings)
+	for check, sev := range map[string]Severity{
+		"mapsize": Warning,
+		"nosync":  Warning,
+		"readers": Warning,
+	} {
+		if checks[check] != sev {
+			t.Errorf("check %s: severity %v, want %v (findings: %v)", check, checks[check], sev, findings)
+		}
+	}
+	if _, ok := checks["lockfile"]; ok {
+		t.Errorf("unexpected lock file finding: %v", findings)
+	}
+	for i := 1; i < len(findings); i++ {
+		if findings[i].Severity > findings[i-1].Severity {
+			t.Errorf("findings not sorted by severity: %v", findings)
+		}
+	}
+
+	findings, err = Check(env, &Options{PeriodicSync: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checks = findChecks(findings)
+	if _, ok := checks["nosync"]; ok {
+		t.Errorf("NoSync reported despite periodic sync: %v", findings)
+	}
+	if _, ok := checks["mapsize"]; ok {
+		t.Errorf("unexpected map size finding: %v", findings)
+	}
+}
+
+func TestCheck_hotKeys(t *testing.T) {
+	env, err := lmdbtest.NewEnv(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lmdbtest.Destroy(env)
+
+	env.TrackHotKeys(&lmdb.HotKeyOptions{SampleRate: 1, Window: 20 * time.Millisecond, Threshold: 100})
+	deadline := time.Now().Add(50 * time.Millisecond)
+	for time.Now().Before(deadline) {
+		err = env.Update(func(txn *lmdb.Txn) (err error) {
+			dbi, err := txn.OpenRoot(0)
+			if err != nil {
+				return err
+			}
+			return txn.Put(dbi, []byte("counter"), []byte("1"), 0)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	findings, err := Check(env, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sev, ok := findChecks(findings)["hotkey"]; !ok || sev != Warning {
+		t.Errorf("hot key not reported: %v", findings)
+	}
+}
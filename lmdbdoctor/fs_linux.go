@@ -0,0 +1,32 @@
+package lmdbdoctor
+
+import (
+	"syscall"
+)
+
+// remoteMagic maps statfs filesystem types to the names of network and
+// userspace filesystems on which LMDB is unreliable.
+var remoteMagic = map[int64]string{
+	0x6969:     "NFS",
+	0x517b:     "SMB",
+	0xff534d42: "CIFS",
+	0xfe534d42: "SMB2",
+	0x65735546: "FUSE",
+	0x564c:     "NCP",
+	0x73757245: "Coda",
+	0x5346414f: "AFS",
+	0x47504653: "GPFS",
+	0x0bd00bd0: "Lustre",
+	0x00c36400: "Ceph",
+}
+
+// remoteFilesystem returns the name of the filesystem containing path if it
+// is a network or userspace filesystem, and the empty string otherwise.
+func remoteFilesystem(path string) (string, error) {
+	var st syscall.Statfs_t
+	err := syscall.Statfs(path, &st)
+	if err != nil {
+		return "", err
+	}
+	return remoteMagic[int64(st.Type)&0xffffffff], nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package lmdbdoctor
+
+import (
+	"errors"
+)
+
+// remoteFilesystem is only implemented on Linux.
+func remoteFilesystem(path string) (string, error) {
+	return "", errors.New("filesystem detection is not supported on this platform")
+}
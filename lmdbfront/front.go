@@ -0,0 +1,348 @@
+/*
+Package lmdbfront stores the keys of write-once databases front coded, for
+datasets whose keys share long prefixes, such as URLs and file paths.
+
+Sorted items are grouped into blocks of up to a fixed number of items.  A
+block is stored as a single LMDB item whose key is the first key of the
+block, in full, and whose value holds the items of the block, each key
+reduced to the length of the prefix it shares with the previous key and the
+remaining suffix.  Only one key per block is stored in the B-tree, and the
+rest of the keys are stored without their common prefixes, so the database
+is smaller and its branch pages fewer.  Every key is reconstructed exactly
+when read.
+
+Lookups seek the block that may hold a key and decode it, so a front coded
+database trades some CPU per read for size.  Blocks are rewritten whole,
+which is why the encoding suits datasets that are built once, with a Writer
+or with Rebuild, and then only read, with Get and Cursor.  A front coded
+database must only be accessed through this package.
+*/
+package lmdbfront
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/glycerine/lmdb-go/lmdb"
+)
+
+// DefaultBlockSize is the number of items per block used when none is
+// given.
+const DefaultBlockSize = 16
+
+// ErrUnsorted is returned by Writer.Put for a key not greater than the
+// previous one.
+var ErrUnsorted = errors.New("lmdbfront: keys not in strictly increasing order")
+
+// ErrCorrupt is returned when a block cannot be decoded, typically because
+// the database is not front coded.
+var ErrCorrupt = errors.New("lmdbfront: malformed block")
+
+var errDupSort = errors.New("lmdbfront: DupSort databases cannot be front coded")
+
+func notFound(op string) error {
+	return &lmdb.OpError{Op: op, Errno: lmdb.NotFound}
+}
+
+// Writer front codes items written in key order into a database.
+type Writer struct {
+	txn       *lmdb.Txn
+	dbi       lmdb.DBI
+	blockSize int
+
+	first []byte // first key of the pending block
+	prev  []byte // last key written
+	body  []byte // encoded items of the pending block
+	n     int    // number of items in the pending block
+	block []byte
+}
+
+// NewWriter returns a Writer adding items to dbi in txn, grouping them in
+// blocks of blockSize items, or DefaultBlockSize if blockSize is not
+// positive.  Blocks are appended with lmdb.Append, so the keys written must
+// follow those already in dbi, which is normally empty.  Flush must be called
+// before txn commits.
+func NewWriter(txn *lmdb.Txn, dbi lmdb.DBI, blockSize int) *Writer {
+	if blockSize <= 0 {
+		blockSize = DefaultBlockSize
+	}
+	return &Writer{txn: txn, dbi: dbi, blockSize: blockSize}
+}
+
+// Put adds an item.  Keys must be put in strictly increasing order.
+func (w *Writer) Put(key, val []byte) error {
+	if w.prev != nil && bytes.Compare(key, w.prev) <= 0 {
+		return ErrUnsorted
+	}
+	if w.n == 0 {
+		w.first = append(w.first[:0], key...)
+	} else {
+		shared := commonPrefix(w.prev, key)
+		w.body = appendUvarint(w.body, uint64(shared))
+		w.body = appendUvarint(w.body, uint64(len(key)-shared))
+		w.body = append(w.body, key[shared:]...)
+	}
+	w.body = appendUvarint(w.body, uint64(len(val)))
+	w.body = append(w.body, val...)
+	w.prev = append(w.prev[:0], key...)
+	w.n++
+	if w.n == w.blockSize {
+		return w.Flush()
+	}
+	return nil
+}
+
+// Flush writes the pending block, if any.
+func (w *Writer) Flush() error {
+	if w.n == 0 {
+		return nil
+	}
+	w.block = appendUvarint(w.block[:0], uint64(w.n))
+	w.block = append(w.block, w.body...)
+	err := w.txn.Put(w.dbi, w.first, w.block, lmdb.Append)
+	if err != nil {
+		return err
+	}
+	w.body = w.body[:0]
+	w.n = 0
+	return nil
+}
+
+func commonPrefix(a, b []byte) int {
+	n := len(a)
+	if len(b) < n {
+		n = len(b)
+	}
+	for i := 0; i < n; i++ {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	return n
+}
+
+func appendUvarint(b []byte, x uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], x)
+	return append(b, tmp[:n]...)
+}
+
+// decodeBlock appends the items of the block stored under first to keys and
+// vals.  Keys are allocated; values refer to block.
+func decodeBlock(first, block []byte, keys, vals [][]byte) ([][]byte, [][]byte, error) {
+	n, block, ok := readUvarint(block)
+	if !ok || n == 0 {
+		return keys, vals, ErrCorrupt
+	}
+	var prev []byte
+	for i := uint64(0); i < n; i++ {
+		var key []byte
+		if i == 0 {
+			key = append([]byte(nil), first...)
+		} else {
+			var shared, size uint64
+			shared, block, ok = readUvarint(block)
+			if !ok || shared > uint64(len(prev)) {
+				return keys, vals, ErrCorrupt
+			}
+			size, block, ok = readUvarint(block)
+			if !ok || size > uint64(len(block)) {
+				return keys, vals, ErrCorrupt
+			}
+			key = make([]byte, int(shared)+int(size))
+			copy(key, prev[:shared])
+			copy(key[shared:], block[:size])
+			block = block[size:]
+		}
+		var size uint64
+		size, block, ok = readUvarint(block)
+		if !ok || size > uint64(len(block)) {
+			return keys, vals, ErrCorrupt
+		}
+		keys = append(keys, key)
+		vals = append(vals, block[:size:size])
+		block = block[size:]
+		prev = key
+	}
+	if len(block) != 0 {
+		return keys, vals, ErrCorrupt
+	}
+	return keys, vals, nil
+}
+
+func readUvarint(b []byte) (uint64, []byte, bool) {
+	x, n := binary.Uvarint(b)
+	if n <= 0 {
+		return 0, b, false
+	}
+	return x, b[n:], true
+}
+
+// Cursor iterates over the items of a front coded database in key order.
+type Cursor struct {
+	cur  *lmdb.Cursor
+	keys [][]byte
+	vals [][]byte
+	i    int
+}
+
+// OpenCursor opens a Cursor on the front coded database dbi.  It must be
+// closed before txn terminates.
+func OpenCursor(txn *lmdb.Txn, dbi lmdb.DBI) (*Cursor, error) {
+	cur, err := txn.OpenCursor(dbi)
+	if err != nil {
+		return nil, err
+	}
+	return &Cursor{cur: cur}, nil
+}
+
+// Close closes the cursor.
+func (c *Cursor) Close() {
+	c.cur.Close()
+}
+
+// load decodes the block at the position of the underlying cursor.
+func (c *Cursor) load(k, v []byte, err error) error {
+	c.keys, c.vals, c.i = c.keys[:0], c.vals[:0], 0
+	if err != nil {
+		return err
+	}
+	c.keys, c.vals, err = decodeBlock(k, v, c.keys, c.vals)
+	if err != nil {
+		c.keys, c.vals = c.keys[:0], c.vals[:0]
+		return fmt.Errorf("block %q: %v", k, err)
+	}
+	return nil
+}
+
+func (c *Cursor) current() ([]byte, []byte, error) {
+	if c.i >= len(c.keys) {
+		return nil, nil, notFound("lmdbfront.Cursor")
+	}
+	return c.keys[c.i], c.vals[c.i], nil
+}
+
+// First moves to the first item.  It returns a NotFound error if the
+// database is empty.
+func (c *Cursor) First() (key, val []byte, err error) {
+	err = c.load(c.cur.Get(nil, nil, lmdb.First))
+	if err != nil {
+		return nil, nil, err
+	}
+	return c.current()
+}
+
+// Next moves to the next item.  It returns a NotFound error past the last
+// item.
+func (c *Cursor) Next() (key, val []byte, err error) {
+	if c.i+1 < len(c.keys) {
+		c.i++
+		return c.current()
+	}
+	err = c.load(c.cur.Get(nil, nil, lmdb.Next))
+	if err != nil {
+		return nil, nil, err
+	}
+	return c.current()
+}
+
+// SetRange moves to the first item whose key is greater than or equal to
+// key.  It returns a NotFound error if there is none.
+func (c *Cursor) SetRange(key []byte) (k, v []byte, err error) {
+	// find the last block whose first key is less than or equal to key.
+	bk, bv, err := c.cur.Get(key, nil, lmdb.SetRange)
+	switch {
+	case lmdb.IsNotFound(err):
+		bk, bv, err = c.cur.Get(nil, nil, lmdb.Last)
+	case err == nil && !bytes.Equal(bk, key):
+		bk, bv, err = c.cur.Get(nil, nil, lmdb.Prev)
+		if lmdb.IsNotFound(err) {
+			// key precedes every item.
+			return c.First()
+		}
+	}
+	err = c.load(bk, bv, err)
+	if err != nil {
+		return nil, nil, err
+	}
+	for c.i < len(c.keys) && bytes.Compare(c.keys[c.i], key) < 0 {
+		c.i++
+	}
+	if c.i == len(c.keys) {
+		return c.Next()
+	}
+	return c.current()
+}
+
+// Get returns the value of key in the front coded database dbi, or a
+// NotFound error.
+func Get(txn *lmdb.Txn, dbi lmdb.DBI, key []byte) ([]byte, error) {
+	c, err := OpenCursor(txn, dbi)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	k, v, err := c.SetRange(key)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(k, key) {
+		return nil, notFound("lmdbfront.Get")
+	}
+	return v, nil
+}
+
+// Rebuild writes the items of the named database src of env front coded
+// into the named database dst, which is created or emptied, in a single
+// update, and returns the number of items written.  src is left unchanged;
+// the application switches to dst, and may drop src, once it is satisfied.
+func Rebuild(env *lmdb.Env, src, dst string, blockSize int) (n int, err error) {
+	if src == dst {
+		return 0, fmt.Errorf("lmdbfront: cannot rebuild %q in place", src)
+	}
+	err = env.Update(func(txn *lmdb.Txn) error {
+		n = 0
+		sdbi, err := txn.OpenDBI(src, 0)
+		if err != nil {
+			return err
+		}
+		flags, err := txn.Flags(sdbi)
+		if err != nil {
+			return err
+		}
+		if flags&lmdb.DupSort != 0 {
+			return errDupSort
+		}
+		ddbi, err := txn.OpenDBI(dst, lmdb.Create)
+		if err != nil {
+			return err
+		}
+		err = txn.Drop(ddbi, false)
+		if err != nil {
+			return err
+		}
+		cur, err := txn.OpenCursor(sdbi)
+		if err != nil {
+			return err
+		}
+		defer cur.Close()
+		w := NewWriter(txn, ddbi, blockSize)
+		for {
+			k, v, err := cur.Get(nil, nil, lmdb.Next)
+			if lmdb.IsNotFound(err) {
+				return w.Flush()
+			}
+			if err != nil {
+				return err
+			}
+			err = w.Put(k, v)
+			if err != nil {
+				return err
+			}
+			n++
+		}
+	})
+	return n, err
+}
@@ -0,0 +1,147 @@
+package lmdbfront
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/glycerine/lmdb-go/int/lmdbtest"
+	"github.com/glycerine/lmdb-go/lmdb"
+)
+
+func TestRebuild(t *testing.T) {
+	env, err := lmdbtest.NewEnv(&lmdbtest.EnvOptions{MaxDBs: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lmdbtest.Destroy(env)
+
+	const n = 100
+	key := func(i int) []byte {
+		return []byte(fmt.Sprintf("https://example.com/assets/images/%04d.png", i*2))
+	}
+	err = env.Update(func(txn *lmdb.Txn) error {
+		dbi, err := txn.OpenDBI("urls", lmdb.Create)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < n; i++ {
+			err = txn.Put(dbi, key(i), []byte(fmt.Sprint(i)), 0)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count, err := Rebuild(env, "urls", "urls.front", 8)
+	if err != nil || count != n {
+		t.Fatalf("rebuild: %d %v", count, err)
+	}
+
+	err = env.View(func(txn *lmdb.Txn) error {
+		dbi, err := txn.OpenDBI("urls.front", 0)
+		if err != nil {
+			return err
+		}
+		stat, err := txn.Stat(dbi)
+		if err != nil {
+			return err
+		}
+		if stat.Entries != (n+7)/8 {
+			t.Errorf("%d blocks", stat.Entries)
+		}
+
+		for _, i := range []int{0, 1, 8, 9, 50, n - 1} {
+			v, err := Get(txn, dbi, key(i))
+			if err != nil || string(v) != fmt.Sprint(i) {
+				t.Errorf("get %s: %q %v", key(i), v, err)
+			}
+		}
+		_, err = Get(txn, dbi, []byte("https://example.com/assets/images/0003.png"))
+		if !lmdb.IsNotFound(err) {
+			t.Errorf("unexpected error: %v", err)
+		}
+
+		c, err := OpenCursor(txn, dbi)
+		if err != nil {
+			return err
+		}
+		defer c.Close()
+		i := 0
+		for k, v, err := c.First(); !lmdb.IsNotFound(err); k, v, err = c.Next() {
+			if err != nil {
+				return err
+			}
+			if string(k) != string(key(i)) || string(v) != fmt.Sprint(i) {
+				t.Fatalf("item %d: %q %q", i, k, v)
+			}
+			i++
+		}
+		if i != n {
+			t.Errorf("%d items", i)
+		}
+
+		// between keys, before the first and after the last.
+		for _, tc := range []struct {
+			seek string
+			want []byte
+		}{
+			{"https://example.com/assets/images/0017.png", key(9)},
+			{"https://example.com/assets/images/0032", key(16)},
+			{"a", key(0)},
+			{"https://example.com/assets/images/0198.png", key(n - 1)},
+		} {
+			k, _, err := c.SetRange([]byte(tc.seek))
+			if err != nil || string(k) != string(tc.want) {
+				t.Errorf("seek %s: %q %v", tc.seek, k, err)
+			}
+		}
+		_, _, err = c.SetRange([]byte("z"))
+		if !lmdb.IsNotFound(err) {
+			t.Errorf("unexpected error: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWriter_unsorted(t *testing.T) {
+	env, err := lmdbtest.NewEnv(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lmdbtest.Destroy(env)
+
+	err = env.Update(func(txn *lmdb.Txn) error {
+		dbi, err := txn.OpenRoot(0)
+		if err != nil {
+			return err
+		}
+		w := NewWriter(txn, dbi, 0)
+		err = w.Put([]byte("b"), nil)
+		if err != nil {
+			return err
+		}
+		if err := w.Put([]byte("a"), nil); err != ErrUnsorted {
+			t.Errorf("unexpected error: %v", err)
+		}
+		return w.Flush()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDecodeBlock_corrupt(t *testing.T) {
+	for _, b := range [][]byte{nil, {0}, {1}, {2, 0, 0, 5}, {1, 1, 'v', 'x'}} {
+		_, _, err := decodeBlock([]byte("k"), b, nil, nil)
+		if err != ErrCorrupt {
+			t.Errorf("%v: unexpected error: %v", b, err)
+		}
+	}
+}
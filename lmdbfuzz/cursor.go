@@ -0,0 +1,266 @@
+package lmdbfuzz
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/glycerine/lmdb-go/lmdb"
+)
+
+// Cursor operations decoded from fuzz input by CheckCursorOps.
+const (
+	opPut = iota
+	opDel
+	opFirst
+	opLast
+	opNext
+	opPrev
+	opSet
+	opSetRange
+	numOps
+)
+
+var opNames = [numOps]string{"put", "del", "first", "last", "next", "prev", "set", "setrange"}
+
+// model is an in-memory sorted map with a cursor, following the cursor
+// semantics of LMDB for a database without duplicates.
+type model struct {
+	keys [][]byte
+	vals map[string][]byte
+
+	// pos is the key under the cursor.  After del the cursor is between
+	// items, just after pos.  After an operation whose effect on the cursor
+	// LMDB leaves unspecified (a failed lookup, moving past either end)
+	// the position is unknown and operations relative to it are skipped.
+	pos     []byte
+	deleted bool
+	known   bool
+}
+
+func (m *model) search(k []byte) int {
+	return sort.Search(len(m.keys), func(i int) bool { return bytes.Compare(m.keys[i], k) >= 0 })
+}
+
+func (m *model) put(k, v []byte) {
+	i := m.search(k)
+	if i == len(m.keys) || !bytes.Equal(m.keys[i], k) {
+		m.keys = append(m.keys, nil)
+		copy(m.keys[i+1:], m.keys[i:])
+		m.keys[i] = k
+	}
+	m.vals[string(k)] = v
+	m.at(i)
+}
+
+func (m *model) at(i int) ([]byte, []byte, bool) {
+	if i < 0 || i >= len(m.keys) {
+		m.known = false
+		return nil, nil, false
+	}
+	m.pos, m.deleted, m.known = m.keys[i], false, true
+	return m.keys[i], m.vals[string(m.keys[i])], true
+}
+
+// CheckCursorOps runs a sequence of cursor operations decoded from data on
+// dbi in txn, which must be a write transaction, and checks every result
+// against an in-memory model of the database.  The database must not use
+// DupSort.  Existing items are loaded into the model first, and the final
+// contents of the database are compared with the model at the end.
+//
+// Each operation is one byte selecting put, del, first, last, next, prev, set
+// or setrange, followed for put, set and setrange by a key of one to three
+// bytes (one length byte and the key bytes, mapped to a small alphabet so
+// that keys collide) and for put by a value (one length byte and up to 15
+// bytes).
+func CheckCursorOps(txn *lmdb.Txn, dbi lmdb.DBI, data []byte) error {
+	flags, err := txn.Flags(dbi)
+	if err != nil {
+		return err
+	}
+	if flags&lmdb.DupSort != 0 {
+		return errors.New("cursor ops: DupSort databases are not supported")
+	}
+	m := &model{vals: make(map[string][]byte)}
+	err = scan(txn, dbi, func(k, v []byte) {
+		m.keys = append(m.keys, k)
+		m.vals[string(k)] = v
+	})
+	if err != nil {
+		return err
+	}
+	m.known = false
+
+	cur, err := txn.OpenCursor(dbi)
+	if err != nil {
+		return err
+	}
+	defer cur.Close()
+
+	// positioned is false until the first lookup.  Next and prev on an
+	// unpositioned cursor act like first and last.
+	positioned := false
+	for step := 0; len(data) > 0; step++ {
+		op := int(data[0]) % numOps
+		data = data[1:]
+		var key, val []byte
+		if op == opPut || op == opSet || op == opSetRange {
+			key, data = genKey(data)
+		}
+		if op == opPut {
+			val, data = genVal(data)
+		}
+
+		var k, v []byte
+		var wantK, wantV []byte
+		var wantOK bool
+		switch op {
+		case opPut:
+			err = cur.Put(key, val, 0)
+			if err != nil {
+				return fmt.Errorf("cursor ops: step %d: put %q: %v", step, key, err)
+			}
+			m.put(key, val)
+			positioned = true
+			continue
+		case opDel:
+			if !positioned || !m.known || m.deleted {
+				continue
+			}
+			err = cur.Del(0)
+			if err != nil {
+				return fmt.Errorf("cursor ops: step %d: del at %q: %v", step, m.pos, err)
+			}
+			i := m.search(m.pos)
+			delete(m.vals, string(m.pos))
+			m.keys = append(m.keys[:i], m.keys[i+1:]...)
+			m.deleted = true
+			continue
+		case opFirst:
+			k, v, err = cur.Get(nil, nil, lmdb.First)
+			wantK, wantV, wantOK = m.at(0)
+		case opLast:
+			k, v, err = cur.Get(nil, nil, lmdb.Last)
+			wantK, wantV, wantOK = m.at(len(m.keys) - 1)
+		case opNext, opPrev:
+			if !m.known && positioned {
+				continue
+			}
+			i := len(m.keys) - 1 // prev of an unpositioned cursor is last
+			if op == opNext {
+				i = 0 // next of an unpositioned cursor is first
+			}
+			if m.known {
+				i = m.search(m.pos)
+				if op == opNext && !m.deleted {
+					i++
+				} else if op == opPrev {
+					i--
+				}
+			}
+			if op == opNext {
+				k, v, err = cur.Get(nil, nil, lmdb.Next)
+			} else {
+				k, v, err = cur.Get(nil, nil, lmdb.Prev)
+			}
+			wantK, wantV, wantOK = m.at(i)
+		case opSet:
+			k, v, err = cur.Get(key, nil, lmdb.Set)
+			i := m.search(key)
+			if i < len(m.keys) && bytes.Equal(m.keys[i], key) {
+				wantK, wantV, wantOK = m.at(i)
+			} else {
+				m.known = false
+			}
+			if err == nil {
+				// Set does not return the key.
+				k = key
+			}
+		case opSetRange:
+			k, v, err = cur.Get(key, nil, lmdb.SetRange)
+			wantK, wantV, wantOK = m.at(m.search(key))
+		}
+		if err != nil && !lmdb.IsNotFound(err) {
+			return fmt.Errorf("cursor ops: step %d: %s: %v", step, opNames[op], err)
+		}
+		positioned = true
+		if ok := err == nil; ok != wantOK {
+			return fmt.Errorf("cursor ops: step %d: %s %q: found %v, model found %v (%q)",
+				step, opNames[op], key, ok, wantOK, wantK)
+		}
+		if !wantOK {
+			continue
+		}
+		if !bytes.Equal(k, wantK) || !bytes.Equal(v, wantV) {
+			return fmt.Errorf("cursor ops: step %d: %s %q: got %q=%q, model has %q=%q",
+				step, opNames[op], key, k, v, wantK, wantV)
+		}
+	}
+
+	i := 0
+	var diff error
+	err = scan(txn, dbi, func(k, v []byte) {
+		if diff == nil && (i >= len(m.keys) || !bytes.Equal(k, m.keys[i]) || !bytes.Equal(v, m.vals[string(k)])) {
+			diff = fmt.Errorf("cursor ops: final contents: item %d is %q=%q, not in model", i, k, v)
+		}
+		i++
+	})
+	if err != nil {
+		return err
+	}
+	if diff == nil && i != len(m.keys) {
+		diff = fmt.Errorf("cursor ops: final contents: %d items, model has %d", i, len(m.keys))
+	}
+	return diff
+}
+
+func genKey(data []byte) (key, rest []byte) {
+	if len(data) == 0 {
+		return []byte{'a'}, data
+	}
+	n := 1 + int(data[0])%3
+	data = data[1:]
+	key = make([]byte, n)
+	for i := range key {
+		if i < len(data) {
+			key[i] = 'a' + data[i]%8
+		} else {
+			key[i] = 'a'
+		}
+	}
+	if n > len(data) {
+		n = len(data)
+	}
+	return key, data[n:]
+}
+
+func genVal(data []byte) (val, rest []byte) {
+	if len(data) == 0 {
+		return []byte{}, data
+	}
+	n := int(data[0]) % 16
+	data = data[1:]
+	if n > len(data) {
+		n = len(data)
+	}
+	return append([]byte{}, data[:n]...), data[n:]
+}
+
+func scan(txn *lmdb.Txn, dbi lmdb.DBI, fn func(k, v []byte)) error {
+	cur, err := txn.OpenCursor(dbi)
+	if err != nil {
+		return err
+	}
+	defer cur.Close()
+	for {
+		k, v, err := cur.Get(nil, nil, lmdb.Next)
+		if lmdb.IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		fn(k, v)
+	}
+}
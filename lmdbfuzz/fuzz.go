@@ -0,0 +1,230 @@
+/*
+Package lmdbfuzz contains fuzz harnesses for the encodings and cursor
+semantics of the lmdb-go packages, written so that applications can reuse
+them for their own codecs.
+
+Each harness takes the raw fuzz input and returns an error describing the
+first property violation it finds, or nil.  Harnesses never fail on input
+that is merely invalid; only on crashes and broken invariants.  This makes
+them usable from native Go fuzz targets
+
+	func FuzzMyCodec(f *testing.F) {
+		f.Fuzz(func(t *testing.T, data []byte) {
+			if err := myCodec.Check(data); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+
+as well as from go-fuzz, see the GoFuzz functions built with the gofuzz tag.
+
+The harnesses provided are
+
+	Codec.Check      round trip and key order of an application codec
+	CheckChangeset   decoding of WriteBatch changesets
+	CheckDump        parsing of the canonical dump of package lmdbtest
+	CheckCursorOps   sequences of cursor operations against an in-memory model
+*/
+package lmdbfuzz
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+
+	"github.com/glycerine/lmdb-go/int/lmdbtest"
+	"github.com/glycerine/lmdb-go/lmdb"
+)
+
+// Codec describes a reversible encoding of values as bytes, typically as
+// database keys or values, for Check.
+type Codec struct {
+	// Name identifies the codec in errors.
+	Name string
+
+	// Generate derives a value from fuzz input and returns the unused rest
+	// of the input.  It returns false if data is too short.
+	Generate func(data []byte) (v interface{}, rest []byte, ok bool)
+
+	// Encode and Decode convert between values and bytes.  Encode may
+	// reject values that the encoding cannot represent.
+	Encode func(v interface{}) ([]byte, error)
+	Decode func(b []byte) (interface{}, error)
+
+	// Compare orders values, if not nil.  The encoding must then order
+	// encoded values like Compare under bytes.Compare, which is the order
+	// of LMDB keys without custom comparison.
+	Compare func(a, b interface{}) int
+
+	// Equal reports whether two values are equal, reflect.DeepEqual if nil.
+	Equal func(a, b interface{}) bool
+}
+
+// Check decodes data as an encoded value, which must not panic, and then
+// checks that two values generated from data survive a round trip through
+// the encoding and, if c.Compare is set, that their encodings are ordered
+// like the values.
+func (c *Codec) Check(data []byte) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("%s: panic: %v", c.Name, e)
+		}
+	}()
+
+	c.Decode(data)
+
+	a, rest, ok := c.Generate(data)
+	if !ok {
+		return nil
+	}
+	b, _, ok := c.Generate(rest)
+	if !ok {
+		b = a
+	}
+	ea, err := c.roundTrip(a)
+	if ea == nil || err != nil {
+		return err
+	}
+	eb, err := c.roundTrip(b)
+	if eb == nil || err != nil {
+		return err
+	}
+	if c.Compare != nil {
+		want := sign(c.Compare(a, b))
+		got := sign(bytes.Compare(ea, eb))
+		if got != want {
+			return fmt.Errorf("%s: values %v and %v compare %d but their encodings %x and %x compare %d",
+				c.Name, a, b, want, ea, eb, got)
+		}
+	}
+	return nil
+}
+
+// roundTrip returns the encoding of v, or nil if v cannot be encoded.
+func (c *Codec) roundTrip(v interface{}) ([]byte, error) {
+	enc, err := c.Encode(v)
+	if err != nil {
+		return nil, nil
+	}
+	dec, err := c.Decode(enc)
+	if err != nil {
+		return nil, fmt.Errorf("%s: decode %x (encoding of %v): %v", c.Name, enc, v, err)
+	}
+	equal := c.Equal
+	if equal == nil {
+		equal = reflect.DeepEqual
+	}
+	if !equal(v, dec) {
+		return nil, fmt.Errorf("%s: %v encoded as %x decodes as %v", c.Name, v, enc, dec)
+	}
+	return enc, nil
+}
+
+func sign(x int) int {
+	switch {
+	case x < 0:
+		return -1
+	case x > 0:
+		return 1
+	}
+	return 0
+}
+
+// changesetDBs are the database names resolved by CheckChangeset.  The
+// handles are arbitrary since changesets are only decoded.
+var changesetDBs = map[string]lmdb.DBI{"": 1, "a": 2, "b": 3}
+
+// CheckChangeset decodes data as a changeset (see lmdb.WriteBatch.Marshal)
+// referring to the databases "", "a" and "b".  Decoding must not panic, and
+// a changeset that decodes must encode to a canonical form that decodes to
+// the same operations.
+func CheckChangeset(data []byte) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("changeset: panic: %v", e)
+		}
+	}()
+
+	var b lmdb.WriteBatch
+	if b.Unmarshal(data, changesetDBs) != nil {
+		return nil
+	}
+	names := make(map[lmdb.DBI]string, len(changesetDBs))
+	for name, dbi := range changesetDBs {
+		names[dbi] = name
+	}
+	enc, err := b.Marshal(names)
+	if err != nil {
+		return fmt.Errorf("changeset: marshal decoded batch: %v", err)
+	}
+	var b2 lmdb.WriteBatch
+	err = b2.Unmarshal(enc, changesetDBs)
+	if err != nil {
+		return fmt.Errorf("changeset: unmarshal %x (re-encoding of %x): %v", enc, data, err)
+	}
+	if !reflect.DeepEqual(normalizeOps(b.Ops()), normalizeOps(b2.Ops())) {
+		return fmt.Errorf("changeset: %x re-encoded as %x decodes differently", data, enc)
+	}
+	return nil
+}
+
+// normalizeOps replaces empty slices with nil, which encode identically.
+func normalizeOps(ops []lmdb.BatchOp) []lmdb.BatchOp {
+	norm := make([]lmdb.BatchOp, len(ops))
+	for i, op := range ops {
+		if len(op.Key) == 0 {
+			op.Key = nil
+		}
+		if len(op.Val) == 0 {
+			op.Val = nil
+		}
+		norm[i] = op
+	}
+	return norm
+}
+
+// CheckDump loads data as a dump in the format of lmdbtest.Dump into a new
+// environment.  Loading must not panic, and a dump that loads must dump to a
+// canonical form that loads into an identical environment.
+func CheckDump(data []byte) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("dump: panic: %v", e)
+		}
+	}()
+
+	first, err := loadDump(data)
+	if first == nil || err != nil {
+		return err
+	}
+	second, err := loadDump(first)
+	if err != nil {
+		return err
+	}
+	if second == nil {
+		return fmt.Errorf("dump: canonical dump does not load:\n%s", first)
+	}
+	if !bytes.Equal(first, second) {
+		return fmt.Errorf("dump: canonical dump changes when reloaded:\n%s\nbecomes\n%s", first, second)
+	}
+	return nil
+}
+
+// loadDump loads data into a new environment and returns its dump, or nil if
+// data does not load.
+func loadDump(data []byte) ([]byte, error) {
+	env, err := lmdbtest.NewEnv(&lmdbtest.EnvOptions{MaxDBs: 16})
+	if err != nil {
+		return nil, err
+	}
+	defer lmdbtest.Destroy(env)
+	if lmdbtest.Load(env, bytes.NewReader(data)) != nil {
+		return nil, nil
+	}
+	var buf bytes.Buffer
+	err = lmdbtest.Dump(&buf, env)
+	if err != nil {
+		return nil, fmt.Errorf("dump: dump loaded environment: %v", err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,209 @@
+package lmdbfuzz
+
+import (
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/glycerine/lmdb-go/int/lmdbtest"
+	"github.com/glycerine/lmdb-go/lmdb"
+	"github.com/glycerine/lmdb-go/lmdbgeo"
+)
+
+func genPoint(data []byte) (interface{}, []byte, bool) {
+	if len(data) < 8 {
+		return nil, nil, false
+	}
+	p := [2]uint32{binary.BigEndian.Uint32(data), binary.BigEndian.Uint32(data[4:])}
+	return p, data[8:], true
+}
+
+var geoKeyCodec = &Codec{
+	Name:     "lmdbgeo.Key",
+	Generate: genPoint,
+	Encode: func(v interface{}) ([]byte, error) {
+		p := v.([2]uint32)
+		return lmdbgeo.Key(p[0], p[1]), nil
+	},
+	Decode: func(b []byte) (interface{}, error) {
+		if len(b) != lmdbgeo.KeyLen {
+			return nil, errors.New("bad key length")
+		}
+		x, y := lmdbgeo.Deinterleave(binary.BigEndian.Uint64(b))
+		return [2]uint32{x, y}, nil
+	},
+	// keys sort by their Z-order value.
+	Compare: func(a, b interface{}) int {
+		pa, pb := a.([2]uint32), b.([2]uint32)
+		za, zb := lmdbgeo.Interleave(pa[0], pa[1]), lmdbgeo.Interleave(pb[0], pb[1])
+		switch {
+		case za < zb:
+			return -1
+		case za > zb:
+			return 1
+		}
+		return 0
+	},
+}
+
+var hilbertCodec = &Codec{
+	Name:     "lmdbgeo.Hilbert",
+	Generate: genPoint,
+	Encode: func(v interface{}) ([]byte, error) {
+		p := v.([2]uint32)
+		b := make([]byte, 8)
+		binary.BigEndian.PutUint64(b, lmdbgeo.Hilbert(p[0], p[1]))
+		return b, nil
+	},
+	Decode: func(b []byte) (interface{}, error) {
+		if len(b) != 8 {
+			return nil, errors.New("bad length")
+		}
+		x, y := lmdbgeo.HilbertPoint(binary.BigEndian.Uint64(b))
+		return [2]uint32{x, y}, nil
+	},
+}
+
+func FuzzGeoKey(f *testing.F) {
+	f.Add([]byte("\x00\x00\x00\x01\x00\x00\x00\x02\xff\xff\xff\xff\x00\x00\x00\x00"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if err := geoKeyCodec.Check(data); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func FuzzHilbert(f *testing.F) {
+	f.Add([]byte("\x12\x34\x56\x78\x9a\xbc\xde\xf0\x00\x00\x00\x00\xff\xff\xff\xff"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if err := hilbertCodec.Check(data); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestCodec_Check(t *testing.T) {
+	// an encoding that does not preserve order is reported.
+	bad := *geoKeyCodec
+	bad.Name = "little endian"
+	bad.Encode = func(v interface{}) ([]byte, error) {
+		p := v.([2]uint32)
+		b := make([]byte, 8)
+		binary.LittleEndian.PutUint64(b, lmdbgeo.Interleave(p[0], p[1]))
+		return b, nil
+	}
+	bad.Decode = func(b []byte) (interface{}, error) {
+		if len(b) != 8 {
+			return nil, errors.New("bad length")
+		}
+		x, y := lmdbgeo.Deinterleave(binary.LittleEndian.Uint64(b))
+		return [2]uint32{x, y}, nil
+	}
+	if err := bad.Check([]byte("\x00\x00\x00\x01\x00\x00\x00\x00\x00\x00\x01\x00\x00\x00\x00\x00")); err == nil {
+		t.Errorf("order violation not reported")
+	}
+
+	panicky := *geoKeyCodec
+	panicky.Decode = func(b []byte) (interface{}, error) { return nil, errors.New(string(b[:1])) }
+	if err := panicky.Check(nil); err == nil {
+		t.Errorf("panic not reported")
+	}
+}
+
+func FuzzChangeset(f *testing.F) {
+	b := lmdb.NewWriteBatch()
+	b.Put(1, []byte("k"), []byte("v"))
+	b.Del(2, []byte("gone"), nil)
+	b.DropRange(3, []byte("a"), []byte("m"))
+	seed, err := b.Marshal(map[lmdb.DBI]string{1: "", 2: "a", 3: "b"})
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(seed)
+	f.Add([]byte("LMCS\x01\x00"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if err := CheckChangeset(data); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func FuzzDump(f *testing.F) {
+	seed, err := ioutil.ReadFile("../int/lmdbtest/testdata/golden.dump")
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(seed)
+	f.Add([]byte("database \"\" flags=none items=1\n\t61 : \n"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if err := CheckDump(data); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+var errAbortTest = errors.New("abort")
+
+func checkCursorOps(t *testing.T, env *lmdb.Env, dbi lmdb.DBI, data []byte) {
+	err := env.Update(func(txn *lmdb.Txn) error {
+		err := CheckCursorOps(txn, dbi, data)
+		if err != nil {
+			t.Fatalf("ops %x: %v", data, err)
+		}
+		return errAbortTest
+	})
+	if err != errAbortTest {
+		t.Fatal(err)
+	}
+}
+
+func cursorEnvSeeded(t testing.TB) (*lmdb.Env, lmdb.DBI) {
+	env, err := lmdbtest.NewEnv(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbi, err := lmdbtest.OpenRoot(env, 0)
+	if err != nil {
+		lmdbtest.Destroy(env)
+		t.Fatal(err)
+	}
+	err = env.Update(func(txn *lmdb.Txn) error {
+		for _, k := range []string{"b", "d", "dd", "f"} {
+			err := txn.Put(dbi, []byte(k), []byte(k), 0)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		lmdbtest.Destroy(env)
+		t.Fatal(err)
+	}
+	return env, dbi
+}
+
+func FuzzCursorOps(f *testing.F) {
+	env, dbi := cursorEnvSeeded(f)
+	defer lmdbtest.Destroy(env)
+
+	f.Add([]byte{opFirst, opNext, opDel, opNext, opPrev, opLast, opDel, opPrev})
+	f.Add([]byte{opPut, 0, 3, 2, 'x', 'y', opSetRange, 1, 3, 0, opDel, opNext, opSet, 0, 5})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		checkCursorOps(t, env, dbi, data)
+	})
+}
+
+func TestCheckCursorOps(t *testing.T) {
+	env, dbi := cursorEnvSeeded(t)
+	defer lmdbtest.Destroy(env)
+
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 500; i++ {
+		data := make([]byte, r.Intn(200))
+		r.Read(data)
+		checkCursorOps(t, env, dbi, data)
+	}
+}
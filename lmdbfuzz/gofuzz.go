@@ -0,0 +1,73 @@
+//go:build gofuzz
+// +build gofuzz
+
+package lmdbfuzz
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/glycerine/lmdb-go/int/lmdbtest"
+	"github.com/glycerine/lmdb-go/lmdb"
+)
+
+// The functions below are go-fuzz entry points for the harnesses of the
+// package, e.g. go-fuzz-build -tags gofuzz and go-fuzz -func GoFuzzDump.
+// They panic on a property violation.
+
+// GoFuzzChangeset runs CheckChangeset.
+func GoFuzzChangeset(data []byte) int {
+	return result(CheckChangeset(data))
+}
+
+// GoFuzzDump runs CheckDump.
+func GoFuzzDump(data []byte) int {
+	return result(CheckDump(data))
+}
+
+// errAbort aborts the transaction of GoFuzzCursorOps.
+var errAbort = errors.New("abort")
+
+var cursorEnv struct {
+	once sync.Once
+	env  *lmdb.Env
+	dbi  lmdb.DBI
+	err  error
+}
+
+// GoFuzzCursorOps runs CheckCursorOps on an initially empty database.
+func GoFuzzCursorOps(data []byte) int {
+	e := &cursorEnv
+	e.once.Do(func() {
+		e.env, e.err = lmdbtest.NewEnv(nil)
+		if e.err == nil {
+			e.dbi, e.err = lmdbtest.OpenRoot(e.env, 0)
+		}
+	})
+	if e.err != nil {
+		panic(e.err)
+	}
+	err := e.env.Update(func(txn *lmdb.Txn) error {
+		err := txn.Drop(e.dbi, false)
+		if err != nil {
+			return err
+		}
+		err = CheckCursorOps(txn, e.dbi, data)
+		if err != nil {
+			panic(err)
+		}
+		// leave the database empty for the next input.
+		return errAbort
+	})
+	if err != errAbort {
+		panic(err)
+	}
+	return 1
+}
+
+func result(err error) int {
+	if err != nil {
+		panic(err)
+	}
+	return 0
+}
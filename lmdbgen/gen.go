@@ -0,0 +1,388 @@
+/*
+Package lmdbgen stores a key-value map in generations: writes go to an
+active database which is periodically frozen and replaced by an empty one,
+reads look at the generations from newest to oldest, and old generations are
+compacted by merging them in the background.
+
+For churn-heavy workloads, where keys are rewritten or deleted soon after
+being written, the active generation stays small and its pages stay hot,
+while frozen generations are only rewritten when compacted.  Compaction
+discards the versions shadowed by newer generations in bulk, which is
+cheaper than rewriting them one by one in a large tree.
+
+A store called name uses a fixed ring of named databases, name+".0" to
+name+".<n-1>", and a database name+".meta" recording which of them hold
+which generation.  LMDB cannot rename a database, so freezing advances the
+active generation to an empty database of the ring instead.  The databases
+are never deleted, so their handles remain valid for every transaction
+using the store.  The environment needs room for n+1 named databases per
+store, see lmdb.Env.SetMaxDBs.
+
+Deletes are recorded as tombstones in the active generation, because an
+older generation may hold a value for the key.  Tombstones are discarded
+once compaction has merged them into the oldest generation.
+*/
+package lmdbgen
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/glycerine/lmdb-go/lmdb"
+)
+
+// Values are stored behind a one byte tag.
+const (
+	tagValue     = 0
+	tagTombstone = 1
+)
+
+var orderKey = []byte("order")
+
+var errCorrupt = errors.New("lmdbgen: invalid generation record")
+
+// Store is a generational key-value map.  Its methods take a transaction so
+// that they compose with other updates; the generations seen are those of
+// the snapshot of the transaction.
+type Store struct {
+	meta  lmdb.DBI
+	slots []lmdb.DBI
+}
+
+// Open opens (creating them if necessary) the databases of the store called
+// name with a ring of n generations, at least 3 (the active one and two
+// frozen ones to compact) and at most 256.  Opening an existing store
+// requires the same n.  Creating a store requires an update transaction.
+func Open(txn *lmdb.Txn, name string, n int) (*Store, error) {
+	if n < 3 {
+		n = 3
+	}
+	if n > 256 {
+		n = 256
+	}
+	meta, err := txn.OpenDBI(name+".meta", lmdb.Create)
+	if err != nil {
+		return nil, err
+	}
+	s := &Store{meta: meta}
+	for i := 0; i < n; i++ {
+		dbi, err := txn.OpenDBI(fmt.Sprintf("%s.%d", name, i), lmdb.Create)
+		if err != nil {
+			return nil, err
+		}
+		s.slots = append(s.slots, dbi)
+	}
+	order, err := s.order(txn)
+	if lmdb.IsNotFound(err) {
+		return s, s.setOrder(txn, []int{0})
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, i := range order {
+		if i >= n {
+			return nil, fmt.Errorf("lmdbgen: store %s has more than %d generations", name, n)
+		}
+	}
+	return s, nil
+}
+
+// order returns the slots holding generations, newest (active) first.
+func (s *Store) order(txn *lmdb.Txn) ([]int, error) {
+	v, err := txn.Get(s.meta, orderKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(v) == 0 {
+		return nil, errCorrupt
+	}
+	order := make([]int, len(v))
+	for i, b := range v {
+		order[i] = int(b)
+	}
+	return order, nil
+}
+
+func (s *Store) setOrder(txn *lmdb.Txn, order []int) error {
+	v := make([]byte, len(order))
+	for i, slot := range order {
+		v[i] = byte(slot)
+	}
+	return txn.Put(s.meta, orderKey, v, 0)
+}
+
+// Generations returns the number of generations, including the active one.
+func (s *Store) Generations(txn *lmdb.Txn) (int, error) {
+	order, err := s.order(txn)
+	return len(order), err
+}
+
+// Put stores val under key in the active generation.
+func (s *Store) Put(txn *lmdb.Txn, key, val []byte) error {
+	return s.put(txn, key, tagValue, val)
+}
+
+// Delete records that key is deleted in the active generation.  Deleting a
+// missing key is not an error.
+func (s *Store) Delete(txn *lmdb.Txn, key []byte) error {
+	return s.put(txn, key, tagTombstone, nil)
+}
+
+func (s *Store) put(txn *lmdb.Txn, key []byte, tag byte, val []byte) error {
+	order, err := s.order(txn)
+	if err != nil {
+		return err
+	}
+	v := make([]byte, 1+len(val))
+	v[0] = tag
+	copy(v[1:], val)
+	return txn.Put(s.slots[order[0]], key, v, 0)
+}
+
+// Get returns the value of key in the newest generation holding it, or a
+// NotFound error if it is missing or deleted.
+func (s *Store) Get(txn *lmdb.Txn, key []byte) ([]byte, error) {
+	order, err := s.order(txn)
+	if err != nil {
+		return nil, err
+	}
+	for _, slot := range order {
+		v, err := txn.Get(s.slots[slot], key)
+		if lmdb.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(v) == 0 {
+			return nil, errCorrupt
+		}
+		if v[0] == tagTombstone {
+			break
+		}
+		return v[1:], nil
+	}
+	return nil, &lmdb.OpError{Op: "mdb_get", Errno: lmdb.NotFound}
+}
+
+// Freeze makes the active generation read-only and starts a new, empty one.
+// If the ring is full the two oldest generations are compacted first.
+func (s *Store) Freeze(txn *lmdb.Txn) error {
+	order, err := s.order(txn)
+	if err != nil {
+		return err
+	}
+	if len(order) == len(s.slots) {
+		order, err = s.compact(txn, order)
+		if err != nil {
+			return err
+		}
+	}
+	used := make([]bool, len(s.slots))
+	for _, slot := range order {
+		used[slot] = true
+	}
+	for slot := range s.slots {
+		if !used[slot] {
+			return s.setOrder(txn, append([]int{slot}, order...))
+		}
+	}
+	panic("lmdbgen: no free generation")
+}
+
+// Compact merges the oldest frozen generation into the next older one,
+// discarding the versions it shadows.  Compact does nothing unless there are
+// at least two frozen generations.  It returns the number of generations
+// left.
+func (s *Store) Compact(txn *lmdb.Txn) (int, error) {
+	order, err := s.order(txn)
+	if err != nil {
+		return 0, err
+	}
+	if len(order) < 3 {
+		return len(order), nil
+	}
+	order, err = s.compact(txn, order)
+	return len(order), err
+}
+
+// compact merges the oldest generation of order into the next one and
+// returns the new order.
+func (s *Store) compact(txn *lmdb.Txn, order []int) ([]int, error) {
+	oldest := s.slots[order[len(order)-1]]
+	into := s.slots[order[len(order)-2]]
+
+	cur, err := txn.OpenCursor(oldest)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		k, v, err := cur.Get(nil, nil, lmdb.Next)
+		if lmdb.IsNotFound(err) {
+			break
+		}
+		if err != nil {
+			cur.Close()
+			return nil, err
+		}
+		if len(v) > 0 && v[0] == tagTombstone {
+			continue
+		}
+		err = txn.Put(into, k, v, lmdb.NoOverwrite)
+		if err != nil && !lmdb.IsErrno(err, lmdb.KeyExist) {
+			cur.Close()
+			return nil, err
+		}
+	}
+	cur.Close()
+	err = txn.Drop(oldest, false)
+	if err != nil {
+		return nil, err
+	}
+
+	// into is now the oldest generation, so its tombstones shadow nothing.
+	err = dropTombstones(txn, into)
+	if err != nil {
+		return nil, err
+	}
+	order = append([]int(nil), order[:len(order)-1]...)
+	return order, s.setOrder(txn, order)
+}
+
+func dropTombstones(txn *lmdb.Txn, dbi lmdb.DBI) error {
+	cur, err := txn.OpenCursor(dbi)
+	if err != nil {
+		return err
+	}
+	defer cur.Close()
+	for {
+		_, v, err := cur.Get(nil, nil, lmdb.Next)
+		if lmdb.IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(v) > 0 && v[0] == tagTombstone {
+			err = cur.Del(0)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Cursor iterates the live items of a Store in key order, merging its
+// generations.
+type Cursor struct {
+	m    *lmdb.MergedCursor
+	last []byte
+	ok   bool
+}
+
+// OpenCursor opens a Cursor over the generations of s seen by txn.  The
+// cursor must be closed before txn terminates.
+func (s *Store) OpenCursor(txn *lmdb.Txn) (*Cursor, error) {
+	order, err := s.order(txn)
+	if err != nil {
+		return nil, err
+	}
+	dbis := make([]lmdb.DBI, len(order))
+	for i, slot := range order {
+		dbis[i] = s.slots[slot]
+	}
+	m, err := txn.OpenMergedCursor(dbis...)
+	if err != nil {
+		return nil, err
+	}
+	return &Cursor{m: m}, nil
+}
+
+// Close closes c.
+func (c *Cursor) Close() {
+	c.m.Close()
+}
+
+// Get moves c with one of the ops First, Next or SetRange, as described for
+// lmdb.MergedCursor, and returns the newest version of the item it points
+// to.  Older versions and deleted keys are skipped.
+func (c *Cursor) Get(setkey []byte, op uint) (key, val []byte, err error) {
+	if op != lmdb.Next {
+		c.ok = false
+	}
+	for {
+		k, v, _, err := c.m.Get(setkey, op)
+		if err != nil {
+			return nil, nil, err
+		}
+		op = lmdb.Next
+		// the merged cursor returns the newest version of a key first.
+		if c.ok && bytes.Equal(k, c.last) {
+			continue
+		}
+		c.last = append(c.last[:0], k...)
+		c.ok = true
+		if len(v) == 0 {
+			return nil, nil, errCorrupt
+		}
+		if v[0] == tagTombstone {
+			continue
+		}
+		return k, v[1:], nil
+	}
+}
+
+// Maintain freezes the active generation of s every interval and compacts
+// the frozen generations while there are more than maxFrozen of them, each
+// step in its own update transaction.  Maintenance stops when stop is
+// called, which returns the first error encountered, if any, or when env is
+// closed.
+func (s *Store) Maintain(env *lmdb.Env, interval time.Duration, maxFrozen int) (stop func() error) {
+	if maxFrozen < 1 {
+		maxFrozen = 1
+	}
+	done := make(chan struct{})
+	var once sync.Once
+	var wg sync.WaitGroup
+	var err error
+	wg.Add(1)
+	goErr := env.Go("lmdbgen.maintain", func(ctx context.Context) {
+		defer wg.Done()
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+			err = env.Update(func(txn *lmdb.Txn) error {
+				return s.Freeze(txn)
+			})
+			for n := maxFrozen + 2; err == nil && n > maxFrozen+1; {
+				err = env.Update(func(txn *lmdb.Txn) (err error) {
+					n, err = s.Compact(txn)
+					return err
+				})
+			}
+			if err != nil {
+				return
+			}
+		}
+	})
+	if goErr != nil {
+		wg.Done()
+		err = goErr
+	}
+	return func() error {
+		once.Do(func() { close(done) })
+		wg.Wait()
+		return err
+	}
+}
@@ -0,0 +1,196 @@
+package lmdbgen
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/glycerine/lmdb-go/int/lmdbtest"
+	"github.com/glycerine/lmdb-go/lmdb"
+)
+
+func items(t *testing.T, txn *lmdb.Txn, s *Store) []string {
+	cur, err := s.OpenCursor(txn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cur.Close()
+	var items []string
+	for op := uint(lmdb.First); ; op = lmdb.Next {
+		k, v, err := cur.Get(nil, op)
+		if lmdb.IsNotFound(err) {
+			return items
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		items = append(items, fmt.Sprintf("%s=%s", k, v))
+	}
+}
+
+func TestStore(t *testing.T) {
+	env, err := lmdbtest.NewEnv(&lmdbtest.EnvOptions{MaxDBs: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lmdbtest.Destroy(env)
+
+	var s *Store
+	update := func(fn func(txn *lmdb.Txn) error) {
+		t.Helper()
+		err := env.Update(fn)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	update(func(txn *lmdb.Txn) (err error) {
+		s, err = Open(txn, "kv", 3)
+		if err != nil {
+			return err
+		}
+		err = s.Put(txn, []byte("a"), []byte("1"))
+		if err != nil {
+			return err
+		}
+		return s.Put(txn, []byte("b"), []byte("1"))
+	})
+	update(func(txn *lmdb.Txn) (err error) {
+		err = s.Freeze(txn)
+		if err != nil {
+			return err
+		}
+		err = s.Put(txn, []byte("a"), []byte("2"))
+		if err != nil {
+			return err
+		}
+		return s.Put(txn, []byte("c"), []byte("2"))
+	})
+	update(func(txn *lmdb.Txn) (err error) {
+		err = s.Freeze(txn)
+		if err != nil {
+			return err
+		}
+		return s.Delete(txn, []byte("b"))
+	})
+
+	check := func(gens int, want []string) {
+		t.Helper()
+		err := env.View(func(txn *lmdb.Txn) (err error) {
+			n, err := s.Generations(txn)
+			if err != nil {
+				return err
+			}
+			if n != gens {
+				t.Errorf("generations: %d, want %d", n, gens)
+			}
+			if got := items(t, txn, s); !reflect.DeepEqual(got, want) {
+				t.Errorf("items: %q, want %q", got, want)
+			}
+			v, err := s.Get(txn, []byte("a"))
+			if err != nil {
+				return err
+			}
+			if string(v) != "2" {
+				t.Errorf("a=%s", v)
+			}
+			_, err = s.Get(txn, []byte("b"))
+			if !lmdb.IsNotFound(err) {
+				t.Errorf("deleted key: %v", err)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []string{"a=2", "c=2"}
+	check(3, want)
+
+	// the ring is full, so freezing compacts the two oldest generations.
+	update(func(txn *lmdb.Txn) error { return s.Freeze(txn) })
+	check(3, want)
+	update(func(txn *lmdb.Txn) error {
+		n, err := s.Compact(txn)
+		if n != 2 {
+			t.Errorf("generations after compaction: %d", n)
+		}
+		return err
+	})
+	check(2, want)
+
+	// the tombstone of b reached the oldest generation and was dropped.
+	err = env.View(func(txn *lmdb.Txn) (err error) {
+		order, err := s.order(txn)
+		if err != nil {
+			return err
+		}
+		stat, err := txn.Stat(s.slots[order[len(order)-1]])
+		if err != nil {
+			return err
+		}
+		if stat.Entries != 2 {
+			t.Errorf("oldest generation has %d entries", stat.Entries)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// reopening finds the generations.
+	update(func(txn *lmdb.Txn) (err error) {
+		s, err = Open(txn, "kv", 3)
+		return err
+	})
+	check(2, want)
+}
+
+func TestStore_Maintain(t *testing.T) {
+	env, err := lmdbtest.NewEnv(&lmdbtest.EnvOptions{MaxDBs: 6})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lmdbtest.Destroy(env)
+
+	var s *Store
+	err = env.Update(func(txn *lmdb.Txn) (err error) {
+		s, err = Open(txn, "kv", 5)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop := s.Maintain(env, time.Millisecond, 2)
+	for i := 0; i < 50; i++ {
+		err = env.Update(func(txn *lmdb.Txn) error {
+			return s.Put(txn, []byte(fmt.Sprint(i%7)), []byte(fmt.Sprint(i)))
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(100 * time.Microsecond)
+	}
+	err = stop()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = env.View(func(txn *lmdb.Txn) (err error) {
+		n, err := s.Generations(txn)
+		if err != nil {
+			return err
+		}
+		if n > 3 {
+			t.Errorf("%d generations", n)
+		}
+		got := items(t, txn, s)
+		want := []string{"0=49", "1=43", "2=44", "3=45", "4=46", "5=47", "6=48"}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("items: %q", got)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
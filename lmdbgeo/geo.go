@@ -0,0 +1,306 @@
+/*
+Package lmdbgeo provides keys and queries for indexing two dimensional points
+in an LMDB database.
+
+A point is stored under a key beginning with the 8 byte big-endian Z-order
+(Morton) code of its coordinates, which interleaves the bits of x and y so
+that points close to each other tend to have close keys.  Any suffix may
+follow, typically an identifier making the key unique when several points
+share a cell.
+
+A bounding box query is answered by decomposing the box into a small number
+of Z-order ranges, scanning each range with a cursor in key order, and
+discarding the points that fall in a range but outside the box.
+
+Latitude and longitude are mapped onto the 32 bit grid by LatLonPoint, giving a
+resolution of about a centimeter.
+*/
+package lmdbgeo
+
+import (
+	"encoding/binary"
+	"sort"
+
+	"github.com/glycerine/lmdb-go/lmdb"
+)
+
+// KeyLen is the length of the Z-order prefix of a key.
+const KeyLen = 8
+
+// DefaultMaxRanges is the number of ranges a box is decomposed into when no
+// limit is given.
+const DefaultMaxRanges = 32
+
+// Interleave returns the Z-order code of the point (x, y).  The bits of x
+// occupy the even bit positions of the result and the bits of y the odd ones.
+func Interleave(x, y uint32) uint64 {
+	return spread(x) | spread(y)<<1
+}
+
+// Deinterleave returns the point with Z-order code z.
+func Deinterleave(z uint64) (x, y uint32) {
+	return compact(z), compact(z >> 1)
+}
+
+// spread moves the bits of v to the even positions of a uint64.
+func spread(v uint32) uint64 {
+	x := uint64(v)
+	x = (x | x<<16) & 0x0000ffff0000ffff
+	x = (x | x<<8) & 0x00ff00ff00ff00ff
+	x = (x | x<<4) & 0x0f0f0f0f0f0f0f0f
+	x = (x | x<<2) & 0x3333333333333333
+	x = (x | x<<1) & 0x5555555555555555
+	return x
+}
+
+// compact is the inverse of spread.
+func compact(x uint64) uint32 {
+	x &= 0x5555555555555555
+	x = (x | x>>1) & 0x3333333333333333
+	x = (x | x>>2) & 0x0f0f0f0f0f0f0f0f
+	x = (x | x>>4) & 0x00ff00ff00ff00ff
+	x = (x | x>>8) & 0x0000ffff0000ffff
+	x = (x | x>>16) & 0x00000000ffffffff
+	return uint32(x)
+}
+
+// Hilbert returns the distance of the point (x, y) along the Hilbert curve
+// filling the 32 bit grid.  Hilbert codes preserve locality better than
+// Z-order codes and may be used as keys by applications that only need
+// ordering, but the box queries of this package require Z-order keys.
+func Hilbert(x, y uint32) uint64 {
+	var d uint64
+	for s := uint32(1) << 31; s > 0; s >>= 1 {
+		var rx, ry uint32
+		if x&s != 0 {
+			rx = 1
+		}
+		if y&s != 0 {
+			ry = 1
+		}
+		d += uint64(s) * uint64(s) * uint64((3*rx)^ry)
+		x, y = hilbertRotate(s, x, y, rx, ry)
+	}
+	return d
+}
+
+// HilbertPoint is the inverse of Hilbert.
+func HilbertPoint(d uint64) (x, y uint32) {
+	for s := uint64(1); s < 1<<32; s <<= 1 {
+		rx := uint32(1 & (d / 2))
+		ry := uint32(1 & (d ^ uint64(rx)))
+		x, y = hilbertRotate(uint32(s), x, y, rx, ry)
+		x += uint32(s) * rx
+		y += uint32(s) * ry
+		d /= 4
+	}
+	return x, y
+}
+
+func hilbertRotate(s, x, y, rx, ry uint32) (uint32, uint32) {
+	if ry == 0 {
+		if rx == 1 {
+			x = s - 1 - x
+			y = s - 1 - y
+		}
+		x, y = y, x
+	}
+	return x, y
+}
+
+// LatLonPoint maps a latitude in [-90, 90] and a longitude in [-180, 180]
+// onto the 32 bit grid, with x derived from the longitude.  Values outside
+// those intervals are clamped.
+func LatLonPoint(lat, lon float64) (x, y uint32) {
+	return scale(lon, 180), scale(lat, 90)
+}
+
+// PointLatLon returns the latitude and longitude of the grid point (x, y),
+// the inverse of LatLonPoint up to its resolution.
+func PointLatLon(x, y uint32) (lat, lon float64) {
+	return unscale(y, 90), unscale(x, 180)
+}
+
+func scale(v, max float64) uint32 {
+	if v <= -max {
+		return 0
+	}
+	if v >= max {
+		return 1<<32 - 1
+	}
+	return uint32((v + max) / (2 * max) * (1<<32 - 1))
+}
+
+func unscale(v uint32, max float64) float64 {
+	return float64(v)/(1<<32-1)*(2*max) - max
+}
+
+// Key returns the key prefix of the point (x, y).
+func Key(x, y uint32) []byte {
+	return AppendKey(nil, x, y)
+}
+
+// AppendKey appends the key prefix of the point (x, y) to dst.
+func AppendKey(dst []byte, x, y uint32) []byte {
+	var b [KeyLen]byte
+	binary.BigEndian.PutUint64(b[:], Interleave(x, y))
+	return append(dst, b[:]...)
+}
+
+// LatLonKey returns the key prefix of a latitude and longitude.
+func LatLonKey(lat, lon float64) []byte {
+	return Key(LatLonPoint(lat, lon))
+}
+
+// Box is an inclusive rectangle of the grid.
+type Box struct {
+	MinX, MinY uint32
+	MaxX, MaxY uint32
+}
+
+// LatLonBox returns the Box covering the given latitude and longitude
+// bounds.  Boxes crossing the antimeridian must be split by the caller.
+func LatLonBox(minLat, minLon, maxLat, maxLon float64) Box {
+	minX, minY := LatLonPoint(minLat, minLon)
+	maxX, maxY := LatLonPoint(maxLat, maxLon)
+	return Box{MinX: minX, MinY: minY, MaxX: maxX, MaxY: maxY}
+}
+
+// Contains returns true if (x, y) lies in b.
+func (b Box) Contains(x, y uint32) bool {
+	return x >= b.MinX && x <= b.MaxX && y >= b.MinY && y <= b.MaxY
+}
+
+// Range is an inclusive interval of Z-order codes.
+type Range struct {
+	Start, End uint64
+}
+
+// Ranges decomposes b into at most maxRanges sorted, disjoint ranges of
+// Z-order codes whose union contains every point of b (DefaultMaxRanges if
+// maxRanges is not positive).  Fewer ranges mean fewer cursor seeks but more
+// points outside b to filter out.
+func (b Box) Ranges(maxRanges int) []Range {
+	if maxRanges <= 0 {
+		maxRanges = DefaultMaxRanges
+	}
+	if b.MinX > b.MaxX || b.MinY > b.MaxY {
+		return nil
+	}
+	var out []Range
+	cells := []cell{{}}
+	for level := uint(0); len(cells) > 0; level++ {
+		var partial []cell
+		for _, c := range cells {
+			switch b.overlap(c, level) {
+			case overlapFull:
+				out = append(out, c.rng(level))
+			case overlapPartial:
+				partial = append(partial, c)
+			}
+		}
+		if level == 32 || len(out)+4*len(partial) > maxRanges {
+			for _, c := range partial {
+				out = append(out, c.rng(level))
+			}
+			break
+		}
+		cells = cells[:0]
+		for _, c := range partial {
+			for q := uint64(0); q < 4; q++ {
+				cells = append(cells, cell{prefix: c.prefix<<2 | q})
+			}
+		}
+	}
+	return mergeRanges(out)
+}
+
+// cell is a square of the grid whose Z-order codes share a prefix of
+// 2*level bits.
+type cell struct {
+	prefix uint64
+}
+
+func (c cell) rng(level uint) Range {
+	if level == 0 {
+		return Range{Start: 0, End: 1<<64 - 1}
+	}
+	shift := 64 - 2*level
+	start := c.prefix << shift
+	return Range{Start: start, End: start | (1<<shift - 1)}
+}
+
+const (
+	overlapNone = iota
+	overlapPartial
+	overlapFull
+)
+
+func (b Box) overlap(c cell, level uint) int {
+	r := c.rng(level)
+	x0, y0 := Deinterleave(r.Start)
+	x1, y1 := Deinterleave(r.End)
+	if x1 < b.MinX || x0 > b.MaxX || y1 < b.MinY || y0 > b.MaxY {
+		return overlapNone
+	}
+	if x0 >= b.MinX && x1 <= b.MaxX && y0 >= b.MinY && y1 <= b.MaxY {
+		return overlapFull
+	}
+	return overlapPartial
+}
+
+func mergeRanges(rs []Range) []Range {
+	if len(rs) == 0 {
+		return rs
+	}
+	sort.Slice(rs, func(i, j int) bool { return rs[i].Start < rs[j].Start })
+	merged := rs[:1]
+	for _, r := range rs[1:] {
+		last := &merged[len(merged)-1]
+		if last.End+1 == r.Start {
+			last.End = r.End
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+// Query calls fn, in key order, with every item of dbi whose key begins with
+// the Z-order code of a point in b.  The ranges of b (see Box.Ranges) are
+// scanned one after another with a single cursor and items outside b are
+// skipped.  Keys shorter than KeyLen are ignored.
+func Query(txn *lmdb.Txn, dbi lmdb.DBI, b Box, maxRanges int, fn func(k, v []byte) error) error {
+	cur, err := txn.OpenCursor(dbi)
+	if err != nil {
+		return err
+	}
+	defer cur.Close()
+
+	var start [KeyLen]byte
+	for _, r := range b.Ranges(maxRanges) {
+		binary.BigEndian.PutUint64(start[:], r.Start)
+		k, v, err := cur.Get(start[:], nil, lmdb.SetRange)
+		for err == nil {
+			if len(k) < KeyLen {
+				k, v, err = cur.Get(nil, nil, lmdb.Next)
+				continue
+			}
+			z := binary.BigEndian.Uint64(k)
+			if z > r.End {
+				break
+			}
+			if b.Contains(Deinterleave(z)) {
+				err = fn(k, v)
+				if err != nil {
+					return err
+				}
+			}
+			k, v, err = cur.Get(nil, nil, lmdb.Next)
+		}
+		if err != nil && !lmdb.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
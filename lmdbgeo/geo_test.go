@@ -0,0 +1,153 @@
+package lmdbgeo
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"testing"
+
+	"github.com/glycerine/lmdb-go/int/lmdbtest"
+	"github.com/glycerine/lmdb-go/lmdb"
+)
+
+func TestInterleave(t *testing.T) {
+	if z := Interleave(1, 0); z != 1 {
+		t.Errorf("Interleave(1, 0) = %#x", z)
+	}
+	if z := Interleave(0, 1); z != 2 {
+		t.Errorf("Interleave(0, 1) = %#x", z)
+	}
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		x, y := r.Uint32(), r.Uint32()
+		x2, y2 := Deinterleave(Interleave(x, y))
+		if x2 != x || y2 != y {
+			t.Fatalf("Deinterleave(Interleave(%d, %d)) = %d, %d", x, y, x2, y2)
+		}
+		x2, y2 = HilbertPoint(Hilbert(x, y))
+		if x2 != x || y2 != y {
+			t.Fatalf("HilbertPoint(Hilbert(%d, %d)) = %d, %d", x, y, x2, y2)
+		}
+	}
+}
+
+func TestHilbert_adjacent(t *testing.T) {
+	// consecutive Hilbert codes are neighboring cells.
+	for d := uint64(1 << 40); d < 1<<40+1000; d++ {
+		x0, y0 := HilbertPoint(d)
+		x1, y1 := HilbertPoint(d + 1)
+		dist := absDiff(x0, x1) + absDiff(y0, y1)
+		if dist != 1 {
+			t.Fatalf("codes %d and %d are %d apart", d, d+1, dist)
+		}
+	}
+}
+
+func absDiff(a, b uint32) uint32 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
+
+func TestBox_Ranges(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	for i := 0; i < 50; i++ {
+		x0, y0 := r.Uint32()>>1, r.Uint32()>>1
+		b := Box{MinX: x0, MinY: y0, MaxX: x0 + r.Uint32()>>8, MaxY: y0 + r.Uint32()>>8}
+		ranges := b.Ranges(16)
+		if len(ranges) == 0 || len(ranges) > 16 {
+			t.Fatalf("box %+v: %d ranges", b, len(ranges))
+		}
+		for j := 1; j < len(ranges); j++ {
+			if ranges[j].Start <= ranges[j-1].End {
+				t.Fatalf("box %+v: ranges not sorted and disjoint: %v", b, ranges)
+			}
+		}
+		// every corner and some interior points must be covered.
+		points := [][2]uint32{{b.MinX, b.MinY}, {b.MaxX, b.MaxY}, {b.MinX, b.MaxY}, {b.MaxX, b.MinY}}
+		for k := 0; k < 20; k++ {
+			points = append(points, [2]uint32{
+				b.MinX + uint32(r.Int63n(int64(b.MaxX-b.MinX)+1)),
+				b.MinY + uint32(r.Int63n(int64(b.MaxY-b.MinY)+1)),
+			})
+		}
+		for _, p := range points {
+			if !covered(ranges, Interleave(p[0], p[1])) {
+				t.Fatalf("box %+v: point %v not covered", b, p)
+			}
+		}
+	}
+}
+
+func covered(ranges []Range, z uint64) bool {
+	for _, r := range ranges {
+		if z >= r.Start && z <= r.End {
+			return true
+		}
+	}
+	return false
+}
+
+func TestQuery(t *testing.T) {
+	env, err := lmdbtest.NewEnv(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lmdbtest.Destroy(env)
+
+	type place struct {
+		name     string
+		lat, lon float64
+	}
+	places := []place{
+		{"paris", 48.8566, 2.3522},
+		{"london", 51.5074, -0.1278},
+		{"brussels", 50.8503, 4.3517},
+		{"madrid", 40.4168, -3.7038},
+		{"tokyo", 35.6762, 139.6503},
+	}
+	var dbi lmdb.DBI
+	err = env.Update(func(txn *lmdb.Txn) (err error) {
+		dbi, err = txn.OpenRoot(0)
+		if err != nil {
+			return err
+		}
+		for i, p := range places {
+			k := LatLonKey(p.lat, p.lon)
+			k = append(k, byte(i))
+			err = txn.Put(dbi, k, []byte(p.name), 0)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	box := LatLonBox(45, -1, 52, 5)
+	var found []string
+	err = env.View(func(txn *lmdb.Txn) error {
+		return Query(txn, dbi, box, 0, func(k, v []byte) error {
+			lat, lon := PointLatLon(Deinterleave(binary.BigEndian.Uint64(k)))
+			if lat < 45 || lat > 52 || lon < -1 || lon > 5 {
+				t.Errorf("%s outside the box: %v, %v", v, lat, lon)
+			}
+			found = append(found, string(v))
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]bool{"paris": true, "london": true, "brussels": true}
+	if len(found) != len(want) {
+		t.Errorf("unexpected result: %v", found)
+	}
+	for _, name := range found {
+		if !want[name] {
+			t.Errorf("unexpected place: %s", name)
+		}
+	}
+}
@@ -0,0 +1,307 @@
+/*
+Package lmdbgraph stores a directed graph in an LMDB environment.
+
+Each edge is stored twice, as a value of its source node in a DupSort database
+of out-edges and as a value of its target node in a DupSort database of
+in-edges, so that neighbors can be listed efficiently in either direction.
+Nodes are arbitrary byte strings; because they are used both as keys and as
+DupSort values they are limited to the maximum key size of the environment
+(see lmdb.Env.MaxKeySize).  A node exists only as long as it has edges.
+
+All operations take a transaction so that graph changes compose with other
+updates.
+*/
+package lmdbgraph
+
+import (
+	"errors"
+
+	"github.com/glycerine/lmdb-go/lmdb"
+)
+
+// ErrStop may be returned by a visiting function to end an iteration or
+// traversal early without causing it to fail.
+var ErrStop = errors.New("stop")
+
+// Graph is a directed graph stored in three databases.
+type Graph struct {
+	out   lmdb.DBI
+	in    lmdb.DBI
+	visit lmdb.DBI
+}
+
+// New returns a Graph using the given databases.  Out and in must be opened
+// with the DupSort flag.  Visit is a scratch database used by BFS and must
+// not be used for anything else.
+func New(out, in, visit lmdb.DBI) *Graph {
+	return &Graph{out: out, in: in, visit: visit}
+}
+
+// Open opens (creating them if necessary) the databases of the graph called
+// name, which are name+".out", name+".in" and name+".visit".  The
+// environment needs room for three named databases per graph, see
+// lmdb.Env.SetMaxDBs.
+func Open(txn *lmdb.Txn, name string) (*Graph, error) {
+	out, err := txn.OpenDBI(name+".out", lmdb.Create|lmdb.DupSort)
+	if err != nil {
+		return nil, err
+	}
+	in, err := txn.OpenDBI(name+".in", lmdb.Create|lmdb.DupSort)
+	if err != nil {
+		return nil, err
+	}
+	visit, err := txn.OpenDBI(name+".visit", lmdb.Create)
+	if err != nil {
+		return nil, err
+	}
+	return New(out, in, visit), nil
+}
+
+// AddEdge adds the edge from -> to.  Adding an edge that already exists is
+// not an error.
+func (g *Graph) AddEdge(txn *lmdb.Txn, from, to []byte) error {
+	err := txn.Put(g.out, from, to, lmdb.NoDupData)
+	if lmdb.IsErrno(err, lmdb.KeyExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return txn.Put(g.in, to, from, lmdb.NoDupData)
+}
+
+// RemoveEdge removes the edge from -> to.  RemoveEdge returns lmdb.NotFound
+// if the edge does not exist.
+func (g *Graph) RemoveEdge(txn *lmdb.Txn, from, to []byte) error {
+	err := txn.Del(g.out, from, to)
+	if err != nil {
+		return err
+	}
+	return txn.Del(g.in, to, from)
+}
+
+// RemoveNode removes every edge entering or leaving node.
+func (g *Graph) RemoveNode(txn *lmdb.Txn, node []byte) error {
+	var targets, sources [][]byte
+	err := g.Out(txn, node, func(to []byte) error {
+		targets = append(targets, cloneBytes(to))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	err = g.In(txn, node, func(from []byte) error {
+		sources = append(sources, cloneBytes(from))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, to := range targets {
+		err = g.RemoveEdge(txn, node, to)
+		if err != nil {
+			return err
+		}
+	}
+	for _, from := range sources {
+		err = g.RemoveEdge(txn, from, node)
+		if lmdb.IsNotFound(err) {
+			// a self-loop was removed with the out-edges.
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HasEdge returns true if the edge from -> to exists.
+func (g *Graph) HasEdge(txn *lmdb.Txn, from, to []byte) (bool, error) {
+	cur, err := txn.OpenCursor(g.out)
+	if err != nil {
+		return false, err
+	}
+	defer cur.Close()
+	_, _, err = cur.Get(from, to, lmdb.GetBoth)
+	if lmdb.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Out calls fn with each node that node has an edge to, in byte order.  The
+// slice passed to fn must not be retained if txn.RawRead is true.  If fn
+// returns ErrStop the iteration ends and Out returns nil.
+func (g *Graph) Out(txn *lmdb.Txn, node []byte, fn func(to []byte) error) error {
+	return neighbors(txn, g.out, node, fn)
+}
+
+// In calls fn with each node that has an edge to node, in byte order.  See
+// Out.
+func (g *Graph) In(txn *lmdb.Txn, node []byte, fn func(from []byte) error) error {
+	return neighbors(txn, g.in, node, fn)
+}
+
+// OutDegree returns the number of edges leaving node.
+func (g *Graph) OutDegree(txn *lmdb.Txn, node []byte) (int, error) {
+	return degree(txn, g.out, node)
+}
+
+// InDegree returns the number of edges entering node.
+func (g *Graph) InDegree(txn *lmdb.Txn, node []byte) (int, error) {
+	return degree(txn, g.in, node)
+}
+
+func neighbors(txn *lmdb.Txn, dbi lmdb.DBI, node []byte, fn func([]byte) error) error {
+	cur, err := txn.OpenCursor(dbi)
+	if err != nil {
+		return err
+	}
+	defer cur.Close()
+
+	_, v, err := cur.Get(node, nil, lmdb.Set)
+	for err == nil {
+		err = fn(v)
+		if err == ErrStop {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		_, v, err = cur.Get(nil, nil, lmdb.NextDup)
+	}
+	if lmdb.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+func degree(txn *lmdb.Txn, dbi lmdb.DBI, node []byte) (int, error) {
+	cur, err := txn.OpenCursor(dbi)
+	if err != nil {
+		return 0, err
+	}
+	defer cur.Close()
+
+	_, _, err = cur.Get(node, nil, lmdb.Set)
+	if lmdb.IsNotFound(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	n, err := cur.Count()
+	return int(n), err
+}
+
+// BFS visits the nodes reachable from start along out-edges in breadth
+// first order, calling fn with each node and its distance from start.  Start
+// itself is visited at depth 0.  Nodes further than maxDepth edges away are
+// not visited unless maxDepth is negative.  If fn returns ErrStop the
+// traversal ends and BFS returns nil.
+//
+// The set of visited nodes is kept in the graph's scratch database rather
+// than in memory, so txn must be an update transaction.  The scratch
+// database is emptied before BFS returns.
+func (g *Graph) BFS(txn *lmdb.Txn, start []byte, maxDepth int, fn func(node []byte, depth int) error) error {
+	err := txn.Drop(g.visit, false)
+	if err != nil {
+		return err
+	}
+	err = g.bfs(txn, start, maxDepth, fn, markVisited(txn, g.visit))
+	if err == ErrStop {
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+	return txn.Drop(g.visit, false)
+}
+
+// BFSTemp is like BFS but keeps the set of visited nodes in the main
+// database of tmp, a temporary environment (see lmdb.OpenTempEnv), so txn
+// may be a read-only transaction.  If tmp is nil BFSTemp opens a temporary
+// environment of its own and removes it before returning.  The main
+// database of tmp is emptied before BFSTemp returns.
+func (g *Graph) BFSTemp(txn *lmdb.Txn, tmp *lmdb.Env, start []byte, maxDepth int, fn func(node []byte, depth int) error) error {
+	if tmp == nil {
+		var err error
+		tmp, err = lmdb.OpenTempEnv(nil)
+		if err != nil {
+			return err
+		}
+		defer tmp.Close()
+	}
+	return tmp.Update(func(ttxn *lmdb.Txn) error {
+		visit, err := ttxn.OpenRoot(0)
+		if err != nil {
+			return err
+		}
+		err = ttxn.Drop(visit, false)
+		if err != nil {
+			return err
+		}
+		err = g.bfs(txn, start, maxDepth, fn, markVisited(ttxn, visit))
+		if err == ErrStop {
+			err = nil
+		}
+		if err != nil {
+			return err
+		}
+		return ttxn.Drop(visit, false)
+	})
+}
+
+// markVisited returns a function adding a node to the visited set stored in
+// dbi and reporting whether it was not visited before.
+func markVisited(txn *lmdb.Txn, dbi lmdb.DBI) func(node []byte) (bool, error) {
+	return func(node []byte) (bool, error) {
+		err := txn.Put(dbi, node, nil, lmdb.NoOverwrite)
+		if lmdb.IsErrno(err, lmdb.KeyExist) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+}
+
+func (g *Graph) bfs(txn *lmdb.Txn, start []byte, maxDepth int, fn func([]byte, int) error, mark func([]byte) (bool, error)) error {
+	_, err := mark(start)
+	if err != nil {
+		return err
+	}
+	frontier := [][]byte{cloneBytes(start)}
+	for depth := 0; len(frontier) > 0; depth++ {
+		var next [][]byte
+		for _, node := range frontier {
+			err = fn(node, depth)
+			if err != nil {
+				return err
+			}
+			if depth == maxDepth {
+				continue
+			}
+			err = g.Out(txn, node, func(to []byte) error {
+				added, err := mark(to)
+				if added {
+					next = append(next, cloneBytes(to))
+				}
+				return err
+			})
+			if err != nil {
+				return err
+			}
+		}
+		frontier = next
+	}
+	return nil
+}
+
+func cloneBytes(b []byte) []byte {
+	p := make([]byte, len(b))
+	copy(p, b)
+	return p
+}
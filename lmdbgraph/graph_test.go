@@ -0,0 +1,172 @@
+package lmdbgraph
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/glycerine/lmdb-go/int/lmdbtest"
+	"github.com/glycerine/lmdb-go/lmdb"
+)
+
+func TestGraph(t *testing.T) {
+	env, err := lmdbtest.NewEnv(&lmdbtest.EnvOptions{MaxDBs: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lmdbtest.Destroy(env)
+
+	// a -> b -> c -> d, a -> c, c -> a, e isolated from a.
+	edges := [][2]string{{"a", "b"}, {"b", "c"}, {"c", "d"}, {"a", "c"}, {"c", "a"}, {"e", "a"}}
+	err = env.Update(func(txn *lmdb.Txn) (err error) {
+		g, err := Open(txn, "g")
+		if err != nil {
+			return err
+		}
+		for _, e := range edges {
+			err = g.AddEdge(txn, []byte(e[0]), []byte(e[1]))
+			if err != nil {
+				return err
+			}
+		}
+		// duplicate edges are ignored.
+		err = g.AddEdge(txn, []byte("a"), []byte("b"))
+		if err != nil {
+			return err
+		}
+
+		n, err := g.OutDegree(txn, []byte("a"))
+		if err != nil {
+			return err
+		}
+		if n != 2 {
+			t.Errorf("out degree of a: %d", n)
+		}
+		n, err = g.InDegree(txn, []byte("a"))
+		if err != nil {
+			return err
+		}
+		if n != 2 {
+			t.Errorf("in degree of a: %d", n)
+		}
+		ok, err := g.HasEdge(txn, []byte("b"), []byte("a"))
+		if err != nil {
+			return err
+		}
+		if ok {
+			t.Errorf("unexpected edge b -> a")
+		}
+
+		var visited []string
+		err = g.BFS(txn, []byte("a"), -1, func(node []byte, depth int) error {
+			visited = append(visited, fmt.Sprintf("%s%d", node, depth))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if fmt.Sprint(visited) != "[a0 b1 c1 d2]" {
+			t.Errorf("unexpected traversal: %v", visited)
+		}
+
+		visited = nil
+		err = g.BFS(txn, []byte("a"), 1, func(node []byte, depth int) error {
+			visited = append(visited, fmt.Sprintf("%s%d", node, depth))
+			if len(visited) == 2 {
+				return ErrStop
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if fmt.Sprint(visited) != "[a0 b1]" {
+			t.Errorf("unexpected stopped traversal: %v", visited)
+		}
+
+		err = g.RemoveNode(txn, []byte("c"))
+		if err != nil {
+			return err
+		}
+		var in []string
+		err = g.In(txn, []byte("a"), func(from []byte) error {
+			in = append(in, string(from))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if fmt.Sprint(in) != "[e]" {
+			t.Errorf("unexpected in-edges of a: %v", in)
+		}
+		n, err = g.InDegree(txn, []byte("d"))
+		if err != nil {
+			return err
+		}
+		if n != 0 {
+			t.Errorf("in degree of d: %d", n)
+		}
+		err = g.RemoveEdge(txn, []byte("a"), []byte("c"))
+		if !lmdb.IsNotFound(err) {
+			t.Errorf("removing a missing edge: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGraph_BFSTemp(t *testing.T) {
+	env, err := lmdbtest.NewEnv(&lmdbtest.EnvOptions{MaxDBs: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lmdbtest.Destroy(env)
+
+	err = env.Update(func(txn *lmdb.Txn) (err error) {
+		g, err := Open(txn, "g")
+		if err != nil {
+			return err
+		}
+		for _, e := range [][2]string{{"a", "b"}, {"b", "c"}, {"c", "d"}, {"a", "c"}, {"c", "a"}} {
+			err = g.AddEdge(txn, []byte(e[0]), []byte(e[1]))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmp, err := lmdb.OpenTempEnv(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tmp.Close()
+
+	err = env.View(func(txn *lmdb.Txn) (err error) {
+		g, err := Open(txn, "g")
+		if err != nil {
+			return err
+		}
+		for _, tenv := range []*lmdb.Env{tmp, nil} {
+			var visited []string
+			err = g.BFSTemp(txn, tenv, []byte("a"), -1, func(node []byte, depth int) error {
+				visited = append(visited, fmt.Sprintf("%s%d", node, depth))
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if fmt.Sprint(visited) != "[a0 b1 c1 d2]" {
+				t.Errorf("unexpected traversal: %v", visited)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,86 @@
+package lmdbhttp
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/glycerine/lmdb-go/lmdb"
+)
+
+// BlobOptions configures a BlobHandler.
+type BlobOptions struct {
+	// Authorize, if not nil, is called for every request.  Requests for
+	// which it returns false are answered with 403 Forbidden.
+	Authorize func(r *http.Request) bool
+
+	// ModTime, if not zero, is sent as Last-Modified and used for
+	// If-Modified-Since, e.g. the time the blobs were loaded.
+	ModTime time.Time
+}
+
+// BlobHandler serves the values of a database as files: the path of a
+// request, without its leading slash, is the key of the value served.  The
+// value is read in place, from a snapshot, with lmdb.Env.OpenValue, and
+// served with http.ServeContent, which handles Range requests and the
+// conditional headers.  The ETag is the SHA-256 of the value, so that a
+// changed value gets a new tag; hashing reads the whole value on every
+// request, which suits small assets.  The Content-Type follows the
+// extension of the key, or the content if the key has none.
+type BlobHandler struct {
+	env  *lmdb.Env
+	dbi  lmdb.DBI
+	opts BlobOptions
+}
+
+// NewBlobHandler returns a BlobHandler serving the values of dbi.  A nil
+// opts uses the defaults.  Mount the handler with http.StripPrefix to serve
+// it below a path.
+func NewBlobHandler(env *lmdb.Env, dbi lmdb.DBI, opts *BlobOptions) *BlobHandler {
+	h := &BlobHandler{env: env, dbi: dbi}
+	if opts != nil {
+		h.opts = *opts
+	}
+	return h
+}
+
+// ServeHTTP implements http.Handler.
+func (h *BlobHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.opts.Authorize != nil && !h.opts.Authorize(r) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	key := r.URL.Path
+	if len(key) > 0 && key[0] == '/' {
+		key = key[1:]
+	}
+	if key == "" {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	v, err := h.env.OpenValue(h.dbi, []byte(key))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer v.Close()
+
+	sum := sha256.New()
+	_, err = io.Copy(sum, v)
+	if err == nil {
+		_, err = v.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum.Sum(nil))+`"`)
+	http.ServeContent(w, r, path.Base(key), h.opts.ModTime, v)
+}
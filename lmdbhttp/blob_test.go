@@ -0,0 +1,100 @@
+package lmdbhttp
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/glycerine/lmdb-go/int/lmdbtest"
+	"github.com/glycerine/lmdb-go/lmdb"
+)
+
+func TestBlobHandler(t *testing.T) {
+	env, err := lmdbtest.NewEnv(&lmdbtest.EnvOptions{MaxDBs: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lmdbtest.Destroy(env)
+
+	css := bytes.Repeat([]byte("body { color: red; }\n"), 100)
+	var dbi lmdb.DBI
+	err = env.Update(func(txn *lmdb.Txn) (err error) {
+		dbi, err = txn.OpenDBI("assets", lmdb.Create)
+		if err != nil {
+			return err
+		}
+		return txn.Put(dbi, []byte("static/site.css"), css, 0)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(NewBlobHandler(env, dbi, nil))
+	defer srv.Close()
+
+	do := func(path string, header map[string]string) (*http.Response, []byte) {
+		req, err := http.NewRequest("GET", srv.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp, body
+	}
+
+	resp, body := do("/static/site.css", nil)
+	if resp.StatusCode != http.StatusOK || !bytes.Equal(body, css) {
+		t.Fatalf("status %d, %d bytes", resp.StatusCode, len(body))
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/css; charset=utf-8" {
+		t.Errorf("content type %q", ct)
+	}
+	etag := resp.Header.Get("ETag")
+	if len(etag) != 66 {
+		t.Errorf("etag %q", etag)
+	}
+
+	resp, body = do("/static/site.css", map[string]string{"Range": "bytes=10-19"})
+	if resp.StatusCode != http.StatusPartialContent || !bytes.Equal(body, css[10:20]) {
+		t.Errorf("range: status %d, body %q", resp.StatusCode, body)
+	}
+
+	resp, _ = do("/static/site.css", map[string]string{"If-None-Match": etag})
+	if resp.StatusCode != http.StatusNotModified {
+		t.Errorf("If-None-Match: status %d", resp.StatusCode)
+	}
+
+	// a stale If-Range gets the whole blob.
+	resp, body = do("/static/site.css", map[string]string{"Range": "bytes=10-19", "If-Range": `"stale"`})
+	if resp.StatusCode != http.StatusOK || len(body) != len(css) {
+		t.Errorf("If-Range: status %d, %d bytes", resp.StatusCode, len(body))
+	}
+
+	// a changed value gets a new tag.
+	err = env.Update(func(txn *lmdb.Txn) error {
+		return txn.Put(dbi, []byte("static/site.css"), []byte("body {}"), 0)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, body = do("/static/site.css", map[string]string{"If-None-Match": etag})
+	if resp.StatusCode != http.StatusOK || string(body) != "body {}" {
+		t.Errorf("after change: status %d, body %q", resp.StatusCode, body)
+	}
+
+	resp, _ = do("/static/missing.css", nil)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("missing: status %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,339 @@
+/*
+Package lmdbhttp provides an http.Handler exposing read-only queries against
+a live environment, so that the data can be inspected without writing a
+program against it.  The handler never writes to the environment.
+
+The handler serves
+
+	GET /get?db=NAME&key=KEY     the value of KEY, as application/octet-stream
+	GET /list?db=NAME&prefix=P   items with keys beginning with P, as JSON
+	GET /stat?db=NAME            statistics of a database, as JSON
+	GET /stat                    statistics of every database and the env
+
+Keys may be given as text with the key, prefix and after parameters, or
+base64 encoded (standard encoding) with key64, prefix64 and after64.  JSON
+responses encode keys and values with base64, as encoding/json does for
+[]byte.  The list endpoint returns at most limit items (default 100) and a
+"next" key to pass back as after64 to continue the listing.
+
+BlobHandler serves the values of a database as files instead, with Range
+requests, ETags and conditional requests, so that a database of assets can
+back a file server.
+
+Data stored in the environment is exposed to anyone who can reach the
+handler, so it must be mounted behind authentication, either by the
+surrounding server or with Options.Authorize.
+*/
+package lmdbhttp
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/glycerine/lmdb-go/lmdb"
+)
+
+// DefaultLimit is the number of items returned by /list when no limit is
+// given.
+const DefaultLimit = 100
+
+// Options configures a Handler.
+type Options struct {
+	// Authorize, if not nil, is called for every request.  Requests for
+	// which it returns false are answered with 403 Forbidden.
+	Authorize func(r *http.Request) bool
+
+	// MaxLimit bounds the limit parameter of /list, 1000 if zero.
+	MaxLimit int
+}
+
+// Handler serves read-only queries against an environment.
+type Handler struct {
+	env  *lmdb.Env
+	dbis map[string]lmdb.DBI
+	opts Options
+	mux  *http.ServeMux
+}
+
+// NewHandler returns a Handler serving the databases in dbis, which maps the
+// names used in requests to open handles.  The root database may be mapped
+// to the empty name, which is the default db parameter.  A nil opts uses the
+// defaults.
+func NewHandler(env *lmdb.Env, dbis map[string]lmdb.DBI, opts *Options) *Handler {
+	h := &Handler{env: env, dbis: dbis, mux: http.NewServeMux()}
+	if opts != nil {
+		h.opts = *opts
+	}
+	if h.opts.MaxLimit <= 0 {
+		h.opts.MaxLimit = 1000
+	}
+	h.mux.HandleFunc("/get", h.get)
+	h.mux.HandleFunc("/list", h.list)
+	h.mux.HandleFunc("/stat", h.stat)
+	return h
+}
+
+// ServeHTTP implements http.Handler.  Mount the handler with
+// http.StripPrefix to serve it below a path.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.opts.Authorize != nil && !h.opts.Authorize(r) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	h.mux.ServeHTTP(w, r)
+}
+
+// badRequest is an error caused by the request parameters.
+type badRequest string
+
+func (err badRequest) Error() string { return string(err) }
+
+func (h *Handler) dbi(r *http.Request) (lmdb.DBI, error) {
+	name := r.FormValue("db")
+	dbi, ok := h.dbis[name]
+	if !ok {
+		return 0, badRequest("unknown database " + strconv.Quote(name))
+	}
+	return dbi, nil
+}
+
+// param returns the text parameter name, or the decoded base64 parameter
+// name+"64".
+func param(r *http.Request, name string) ([]byte, error) {
+	if v := r.FormValue(name + "64"); v != "" {
+		b, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			return nil, badRequest("bad " + name + "64 parameter")
+		}
+		return b, nil
+	}
+	return []byte(r.FormValue(name)), nil
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	switch {
+	case lmdb.IsNotFound(err):
+		http.Error(w, "not found", http.StatusNotFound)
+	default:
+		if _, ok := err.(badRequest); ok {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func (h *Handler) get(w http.ResponseWriter, r *http.Request) {
+	dbi, err := h.dbi(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	key, err := param(r, "key")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if len(key) == 0 {
+		writeError(w, badRequest("missing key"))
+		return
+	}
+	var val []byte
+	err = h.env.View(func(txn *lmdb.Txn) (err error) {
+		val, err = txn.Get(dbi, key)
+		return err
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.Itoa(len(val)))
+	w.Write(val)
+}
+
+// Item is an entry of a /list response.
+type Item struct {
+	Key []byte `json:"key"`
+	Val []byte `json:"val,omitempty"`
+}
+
+// List is the body of a /list response.
+type List struct {
+	Items []Item `json:"items"`
+	Next  []byte `json:"next,omitempty"` // pass as after64 to continue
+}
+
+func (h *Handler) list(w http.ResponseWriter, r *http.Request) {
+	dbi, err := h.dbi(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	prefix, err := param(r, "prefix")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	after, err := param(r, "after")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	limit := DefaultLimit
+	if s := r.FormValue("limit"); s != "" {
+		limit, err = strconv.Atoi(s)
+		if err != nil || limit < 1 {
+			writeError(w, badRequest("bad limit"))
+			return
+		}
+	}
+	if limit > h.opts.MaxLimit {
+		limit = h.opts.MaxLimit
+	}
+	keysOnly := r.FormValue("keys") == "1"
+
+	resp := List{Items: []Item{}}
+	err = h.env.View(func(txn *lmdb.Txn) (err error) {
+		cur, err := txn.OpenCursor(dbi)
+		if err != nil {
+			return err
+		}
+		defer cur.Close()
+
+		var k, v []byte
+		switch {
+		case len(after) > 0:
+			k, v, err = cur.Get(after, nil, lmdb.SetRange)
+			if err == nil && bytes.Equal(k, after) {
+				k, v, err = cur.Get(nil, nil, lmdb.NextNoDup)
+			}
+		case len(prefix) > 0:
+			k, v, err = cur.Get(prefix, nil, lmdb.SetRange)
+		default:
+			k, v, err = cur.Get(nil, nil, lmdb.First)
+		}
+		for ; err == nil; k, v, err = cur.Get(nil, nil, lmdb.NextNoDup) {
+			if !bytes.HasPrefix(k, prefix) {
+				return nil
+			}
+			if len(resp.Items) == limit {
+				resp.Next = resp.Items[limit-1].Key
+				return nil
+			}
+			item := Item{Key: k}
+			if !keysOnly {
+				item.Val = v
+			}
+			resp.Items = append(resp.Items, item)
+		}
+		if lmdb.IsNotFound(err) {
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, resp)
+}
+
+// Stat is the JSON form of lmdb.Stat.
+type Stat struct {
+	PageSize      uint   `json:"page_size"`
+	Depth         uint   `json:"depth"`
+	BranchPages   uint64 `json:"branch_pages"`
+	LeafPages     uint64 `json:"leaf_pages"`
+	OverflowPages uint64 `json:"overflow_pages"`
+	Entries       uint64 `json:"entries"`
+}
+
+func jsonStat(s *lmdb.Stat) Stat {
+	return Stat{
+		PageSize:      s.PSize,
+		Depth:         s.Depth,
+		BranchPages:   s.BranchPages,
+		LeafPages:     s.LeafPages,
+		OverflowPages: s.OverflowPages,
+		Entries:       s.Entries,
+	}
+}
+
+// EnvStat is the body of a /stat response without a db parameter.
+type EnvStat struct {
+	MapSize    int64           `json:"map_size"`
+	LastPage   int64           `json:"last_page"`
+	LastTxnID  int64           `json:"last_txnid"`
+	MaxReaders uint            `json:"max_readers"`
+	NumReaders uint            `json:"num_readers"`
+	DBs        map[string]Stat `json:"dbs"`
+}
+
+func (h *Handler) stat(w http.ResponseWriter, r *http.Request) {
+	if _, ok := r.URL.Query()["db"]; ok {
+		dbi, err := h.dbi(r)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		var stat *lmdb.Stat
+		err = h.env.View(func(txn *lmdb.Txn) (err error) {
+			stat, err = txn.Stat(dbi)
+			return err
+		})
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, jsonStat(stat))
+		return
+	}
+
+	info, err := h.env.Info()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	resp := EnvStat{
+		MapSize:    info.MapSize,
+		LastPage:   info.LastPNO,
+		LastTxnID:  info.LastTxnID,
+		MaxReaders: info.MaxReaders,
+		NumReaders: info.NumReaders,
+		DBs:        make(map[string]Stat, len(h.dbis)),
+	}
+	names := make([]string, 0, len(h.dbis))
+	for name := range h.dbis {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	err = h.env.View(func(txn *lmdb.Txn) error {
+		for _, name := range names {
+			stat, err := txn.Stat(h.dbis[name])
+			if err != nil {
+				return err
+			}
+			resp.DBs[name] = jsonStat(stat)
+		}
+		return nil
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, resp)
+}
@@ -0,0 +1,179 @@
+package lmdbhttp
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/glycerine/lmdb-go/int/lmdbtest"
+	"github.com/glycerine/lmdb-go/lmdb"
+)
+
+func testHandler(t *testing.T, opts *Options) (*lmdb.Env, *httptest.Server) {
+	env, err := lmdbtest.NewEnv(&lmdbtest.EnvOptions{MaxDBs: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dbi lmdb.DBI
+	err = env.Update(func(txn *lmdb.Txn) (err error) {
+		dbi, err = txn.OpenDBI("items", lmdb.Create)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 25; i++ {
+			err = txn.Put(dbi, []byte(fmt.Sprintf("user/%02d", i)), []byte(fmt.Sprint(i)), 0)
+			if err != nil {
+				return err
+			}
+		}
+		return txn.Put(dbi, []byte("zzz"), []byte{0, 1, 2}, 0)
+	})
+	if err != nil {
+		lmdbtest.Destroy(env)
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(NewHandler(env, map[string]lmdb.DBI{"items": dbi}, opts))
+	return env, srv
+}
+
+func get(t *testing.T, srv *httptest.Server, path string, v url.Values) (int, []byte) {
+	resp, err := http.Get(srv.URL + path + "?" + v.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, body
+}
+
+func TestHandler_get(t *testing.T) {
+	env, srv := testHandler(t, nil)
+	defer lmdbtest.Destroy(env)
+	defer srv.Close()
+
+	code, body := get(t, srv, "/get", url.Values{"db": {"items"}, "key": {"user/07"}})
+	if code != http.StatusOK || string(body) != "7" {
+		t.Errorf("get: %d %q", code, body)
+	}
+	key64 := base64.StdEncoding.EncodeToString([]byte("zzz"))
+	code, body = get(t, srv, "/get", url.Values{"db": {"items"}, "key64": {key64}})
+	if code != http.StatusOK || string(body) != "\x00\x01\x02" {
+		t.Errorf("get key64: %d %q", code, body)
+	}
+	code, _ = get(t, srv, "/get", url.Values{"db": {"items"}, "key": {"nope"}})
+	if code != http.StatusNotFound {
+		t.Errorf("missing key: %d", code)
+	}
+	code, _ = get(t, srv, "/get", url.Values{"db": {"other"}, "key": {"user/07"}})
+	if code != http.StatusBadRequest {
+		t.Errorf("unknown db: %d", code)
+	}
+}
+
+func TestHandler_list(t *testing.T) {
+	env, srv := testHandler(t, nil)
+	defer lmdbtest.Destroy(env)
+	defer srv.Close()
+
+	var keys []string
+	v := url.Values{"db": {"items"}, "prefix": {"user/"}, "limit": {"10"}}
+	for pages := 0; ; pages++ {
+		if pages > 3 {
+			t.Fatalf("too many pages")
+		}
+		code, body := get(t, srv, "/list", v)
+		if code != http.StatusOK {
+			t.Fatalf("list: %d %s", code, body)
+		}
+		var list List
+		err := json.Unmarshal(body, &list)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, item := range list.Items {
+			keys = append(keys, string(item.Key))
+		}
+		if list.Next == nil {
+			break
+		}
+		v.Set("after64", base64.StdEncoding.EncodeToString(list.Next))
+	}
+	if len(keys) != 25 {
+		t.Fatalf("listed %d keys: %q", len(keys), keys)
+	}
+	for i, k := range keys {
+		if k != fmt.Sprintf("user/%02d", i) {
+			t.Errorf("key %d: %q", i, k)
+		}
+	}
+
+	code, body := get(t, srv, "/list", url.Values{"db": {"items"}, "prefix": {"z"}, "keys": {"1"}})
+	var list List
+	if code != http.StatusOK || json.Unmarshal(body, &list) != nil {
+		t.Fatalf("list keys: %d %s", code, body)
+	}
+	if len(list.Items) != 1 || string(list.Items[0].Key) != "zzz" || list.Items[0].Val != nil {
+		t.Errorf("list keys: %s", body)
+	}
+}
+
+func TestHandler_stat(t *testing.T) {
+	env, srv := testHandler(t, nil)
+	defer lmdbtest.Destroy(env)
+	defer srv.Close()
+
+	code, body := get(t, srv, "/stat", url.Values{"db": {"items"}})
+	var stat Stat
+	if code != http.StatusOK || json.Unmarshal(body, &stat) != nil {
+		t.Fatalf("stat: %d %s", code, body)
+	}
+	if stat.Entries != 26 {
+		t.Errorf("entries: %d", stat.Entries)
+	}
+
+	code, body = get(t, srv, "/stat", nil)
+	var envStat EnvStat
+	if code != http.StatusOK || json.Unmarshal(body, &envStat) != nil {
+		t.Fatalf("env stat: %d %s", code, body)
+	}
+	if envStat.DBs["items"].Entries != 26 || envStat.MapSize == 0 {
+		t.Errorf("env stat: %s", body)
+	}
+}
+
+func TestHandler_access(t *testing.T) {
+	env, srv := testHandler(t, &Options{
+		Authorize: func(r *http.Request) bool {
+			return r.Header.Get("Authorization") == "Bearer secret"
+		},
+	})
+	defer lmdbtest.Destroy(env)
+	defer srv.Close()
+
+	code, _ := get(t, srv, "/stat", nil)
+	if code != http.StatusForbidden {
+		t.Errorf("unauthorized: %d", code)
+	}
+
+	req, err := http.NewRequest("POST", srv.URL+"/stat", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("post: %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,600 @@
+/*
+Package lmdbpage reads LMDB data files directly, without the LMDB C library
+and without memory mapping them.  It is meant for inspecting and salvaging
+files that LMDB itself refuses to open or that would crash a process mapping
+them, so every page is validated before it is used and damaged pages are
+reported instead of followed.
+
+The reader understands the on-disk format produced by 64-bit little-endian
+builds of LMDB 0.9 (data version 1).  It does not apply custom comparison
+functions; items are returned in the order they are stored.
+*/
+package lmdbpage
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Magic is the value identifying an LMDB meta page.
+const Magic = 0xBEEFC0DE
+
+// DataVersion is the file format version understood by this package.
+const DataVersion = 1
+
+// Page flags.
+const (
+	PageBranch   = 0x01
+	PageLeaf     = 0x02
+	PageOverflow = 0x04
+	PageMeta     = 0x08
+	PageLeaf2    = 0x20
+	PageSub      = 0x40
+)
+
+// Node flags.
+const (
+	nodeBigData = 0x01
+	nodeSubData = 0x02
+	nodeDupData = 0x04
+)
+
+// Database flags stored in DB.Flags, equal to the lmdb package flags of the
+// same names.
+const (
+	ReverseKey = 0x02
+	DupSort    = 0x04
+	IntegerKey = 0x08
+	DupFixed   = 0x10
+	IntegerDup = 0x20
+	ReverseDup = 0x40
+)
+
+const (
+	pageHeaderSize = 16
+	nodeHeaderSize = 8
+	dbSize         = 48
+	metaSize       = 24 + 2*dbSize + 16
+
+	// invalidPage is the root of an empty tree.
+	invalidPage = ^uint64(0)
+)
+
+// ErrNotLMDB is returned when neither meta page of a file is valid.
+var ErrNotLMDB = errors.New("lmdbpage: no valid meta page")
+
+// DB describes a B-tree, as stored in a meta page or in the node of a named
+// database.
+type DB struct {
+	Pad           uint32 // fixed value size for DupFixed databases
+	Flags         uint16
+	Depth         uint16
+	BranchPages   uint64
+	LeafPages     uint64
+	OverflowPages uint64
+	Entries       uint64
+	Root          uint64
+}
+
+// Empty returns true if the tree has no pages.
+func (db *DB) Empty() bool {
+	return db.Root == invalidPage
+}
+
+func parseDB(b []byte) DB {
+	return DB{
+		Pad:           binary.LittleEndian.Uint32(b[0:]),
+		Flags:         binary.LittleEndian.Uint16(b[4:]),
+		Depth:         binary.LittleEndian.Uint16(b[6:]),
+		BranchPages:   binary.LittleEndian.Uint64(b[8:]),
+		LeafPages:     binary.LittleEndian.Uint64(b[16:]),
+		OverflowPages: binary.LittleEndian.Uint64(b[24:]),
+		Entries:       binary.LittleEndian.Uint64(b[32:]),
+		Root:          binary.LittleEndian.Uint64(b[40:]),
+	}
+}
+
+// Meta is the content of a meta page.
+type Meta struct {
+	Page     int // 0 or 1
+	Magic    uint32
+	Version  uint32
+	MapSize  uint64
+	Free     DB
+	Main     DB
+	LastPage uint64
+	TxnID    uint64
+}
+
+// PageSize returns the page size recorded in m.
+func (m *Meta) PageSize() int {
+	return int(m.Free.Pad)
+}
+
+// EnvFlags returns the persistent environment flags recorded in m.
+func (m *Meta) EnvFlags() uint16 {
+	return m.Free.Flags
+}
+
+// Valid returns an error if m does not describe a usable database.
+func (m *Meta) Valid() error {
+	if m.Magic != Magic {
+		return fmt.Errorf("lmdbpage: meta page %d: bad magic %#x", m.Page, m.Magic)
+	}
+	if m.Version != DataVersion {
+		return fmt.Errorf("lmdbpage: meta page %d: unsupported version %d", m.Page, m.Version)
+	}
+	psize := m.PageSize()
+	if psize < 512 || psize > 1<<16 || psize&(psize-1) != 0 {
+		return fmt.Errorf("lmdbpage: meta page %d: bad page size %d", m.Page, psize)
+	}
+	return nil
+}
+
+func parseMeta(b []byte, n int) *Meta {
+	b = b[pageHeaderSize:]
+	return &Meta{
+		Page:     n,
+		Magic:    binary.LittleEndian.Uint32(b[0:]),
+		Version:  binary.LittleEndian.Uint32(b[4:]),
+		MapSize:  binary.LittleEndian.Uint64(b[16:]),
+		Free:     parseDB(b[24:]),
+		Main:     parseDB(b[24+dbSize:]),
+		LastPage: binary.LittleEndian.Uint64(b[24+2*dbSize:]),
+		TxnID:    binary.LittleEndian.Uint64(b[24+2*dbSize+8:]),
+	}
+}
+
+// PageError describes a page that could not be used.
+type PageError struct {
+	Page   uint64
+	Reason string
+}
+
+func (err *PageError) Error() string {
+	return fmt.Sprintf("lmdbpage: page %d: %s", err.Page, err.Reason)
+}
+
+// File is an LMDB data file opened for reading.
+type File struct {
+	r     io.ReaderAt
+	c     io.Closer
+	size  int64
+	psize int
+	metas [2]*Meta
+}
+
+// Open opens the data file at path.  If path is a directory the file
+// data.mdb inside it is opened, otherwise path is the data file of an
+// environment created with the NoSubdir flag.
+func Open(path string) (*File, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		path = filepath.Join(path, "data.mdb")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	fi, err = f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	file, err := NewFile(f, fi.Size())
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	file.c = f
+	return file, nil
+}
+
+// NewFile reads the data file contained in r, which is size bytes long.
+func NewFile(r io.ReaderAt, size int64) (*File, error) {
+	f := &File{r: r, size: size}
+
+	// the page size is not known until a meta page has been read, and the
+	// second meta page is located one page into the file.
+	buf := make([]byte, pageHeaderSize+metaSize)
+	_, err := r.ReadAt(buf, 0)
+	if err != nil {
+		return nil, err
+	}
+	f.metas[0] = parseMeta(buf, 0)
+	psize := f.metas[0].PageSize()
+	if f.metas[0].Valid() != nil {
+		// guess the page size from the second meta page.
+		psize = 0
+		for ps := 512; ps <= 1<<16; ps <<= 1 {
+			_, err = r.ReadAt(buf, int64(ps))
+			if err != nil {
+				break
+			}
+			m := parseMeta(buf, 1)
+			if m.Valid() == nil && m.PageSize() == ps {
+				psize = ps
+				break
+			}
+		}
+		if psize == 0 {
+			return nil, ErrNotLMDB
+		}
+	}
+	f.psize = psize
+	_, err = r.ReadAt(buf, int64(psize))
+	if err == nil {
+		f.metas[1] = parseMeta(buf, 1)
+	}
+	if f.metas[0].Valid() != nil && (f.metas[1] == nil || f.metas[1].Valid() != nil) {
+		return nil, ErrNotLMDB
+	}
+	return f, nil
+}
+
+// Close closes the underlying file if it was opened by Open.
+func (f *File) Close() error {
+	if f.c != nil {
+		return f.c.Close()
+	}
+	return nil
+}
+
+// PageSize returns the page size of the file.
+func (f *File) PageSize() int {
+	return f.psize
+}
+
+// Metas returns both meta pages.  An entry is nil if it could not be read;
+// entries that were read may still fail Meta.Valid.
+func (f *File) Metas() [2]*Meta {
+	return f.metas
+}
+
+// Meta returns the valid meta page with the highest transaction id, which is
+// the one LMDB would use.
+func (f *File) Meta() *Meta {
+	var best *Meta
+	for _, m := range f.metas {
+		if m == nil || m.Valid() != nil || m.PageSize() != f.psize {
+			continue
+		}
+		if best == nil || m.TxnID > best.TxnID {
+			best = m
+		}
+	}
+	return best
+}
+
+// NumPages returns the number of complete pages in the file.
+func (f *File) NumPages() uint64 {
+	return uint64(f.size / int64(f.psize))
+}
+
+// page is a validated page.
+type page struct {
+	pgno  uint64
+	data  []byte
+	flags uint16
+}
+
+// readPage reads and validates the header of page pgno.
+func (f *File) readPage(pgno uint64) (*page, error) {
+	if pgno < 2 || pgno >= f.NumPages() {
+		return nil, &PageError{pgno, "page is outside the file"}
+	}
+	p := &page{pgno: pgno, data: make([]byte, f.psize)}
+	_, err := f.r.ReadAt(p.data, int64(pgno)*int64(f.psize))
+	if err != nil {
+		return nil, &PageError{pgno, err.Error()}
+	}
+	if got := binary.LittleEndian.Uint64(p.data); got != pgno {
+		return nil, &PageError{pgno, fmt.Sprintf("page header claims page %d", got)}
+	}
+	p.flags = binary.LittleEndian.Uint16(p.data[10:])
+	return p, nil
+}
+
+func (p *page) pad() int {
+	return int(binary.LittleEndian.Uint16(p.data[8:]))
+}
+
+func (p *page) lower() int {
+	return int(binary.LittleEndian.Uint16(p.data[12:]))
+}
+
+func (p *page) upper() int {
+	return int(binary.LittleEndian.Uint16(p.data[14:]))
+}
+
+// numKeys validates the free space bounds of p and returns its number of
+// nodes.
+func (p *page) numKeys() (int, error) {
+	lower, upper := p.lower(), p.upper()
+	if lower < pageHeaderSize || lower > upper || upper > len(p.data) || (lower-pageHeaderSize)%2 != 0 {
+		return 0, &PageError{p.pgno, fmt.Sprintf("bad bounds %d..%d", lower, upper)}
+	}
+	return (lower - pageHeaderSize) / 2, nil
+}
+
+// node is a validated node of a branch or leaf page.
+type node struct {
+	flags uint16
+	key   []byte
+	data  []byte // leaf data (or the overflow page number of big data)
+	dsize int    // size of the leaf data
+	child uint64 // child page of a branch node
+}
+
+// node validates and returns node i of the page contained in b.
+func parseNode(b []byte, i int, branch bool, pgno uint64) (*node, error) {
+	off := int(binary.LittleEndian.Uint16(b[pageHeaderSize+2*i:]))
+	if off < pageHeaderSize || off+nodeHeaderSize > len(b) {
+		return nil, &PageError{pgno, fmt.Sprintf("node %d offset %d out of range", i, off)}
+	}
+	lo := uint64(binary.LittleEndian.Uint16(b[off:]))
+	hi := uint64(binary.LittleEndian.Uint16(b[off+2:]))
+	n := &node{flags: binary.LittleEndian.Uint16(b[off+4:])}
+	ksize := int(binary.LittleEndian.Uint16(b[off+6:]))
+	kstart := off + nodeHeaderSize
+	if kstart+ksize > len(b) {
+		return nil, &PageError{pgno, fmt.Sprintf("node %d key overruns the page", i)}
+	}
+	n.key = b[kstart : kstart+ksize]
+	if branch {
+		n.child = lo | hi<<16 | uint64(n.flags)<<32
+		return n, nil
+	}
+	n.dsize = int(lo | hi<<16)
+	dlen := n.dsize
+	if n.flags&nodeBigData != 0 {
+		dlen = 8
+	}
+	if kstart+ksize+dlen > len(b) {
+		return nil, &PageError{pgno, fmt.Sprintf("node %d data overruns the page", i)}
+	}
+	n.data = b[kstart+ksize : kstart+ksize+dlen]
+	return n, nil
+}
+
+// Item is a key and value read from a database.
+type Item struct {
+	Key []byte
+	Val []byte
+}
+
+// Walker visits the items of a tree, tolerating damaged pages.
+type Walker struct {
+	f *File
+
+	// Skip is called for every page that could not be read, together with
+	// the reason.  Items stored under a skipped page are lost.  If Skip is
+	// nil skipped pages are only counted.
+	Skip func(err error)
+
+	// Skipped is the number of pages (and damaged values) skipped so far.
+	Skipped int
+
+	// visited holds the pages of the tree being walked, to detect cycles.
+	visited map[uint64]bool
+}
+
+// NewWalker returns a Walker reading from f.
+func (f *File) NewWalker() *Walker {
+	return &Walker{f: f}
+}
+
+func (w *Walker) skip(err error) {
+	w.Skipped++
+	if w.Skip != nil {
+		w.Skip(err)
+	}
+}
+
+// Walk calls fn with every readable item of db, in storage order.  Nodes of
+// the main database that hold named databases are not reported, see
+// Databases.  Items of DupSort databases are reported once per value.  The
+// slices passed to fn are only valid during the call.  Walk stops and
+// returns the error if fn returns one.
+func (w *Walker) Walk(db DB, fn func(key, val []byte) error) error {
+	if db.Empty() {
+		return nil
+	}
+	w.visited = make(map[uint64]bool)
+	return w.walkTree(db.Root, func(p *page, n *node, key []byte) error {
+		if p.flags&PageLeaf2 != 0 {
+			return fn(key, nil)
+		}
+		return w.leaf(n, db.Flags&DupSort != 0, fn)
+	})
+}
+
+// leaf reports the item(s) of a leaf node of a main tree.
+func (w *Walker) leaf(n *node, dupsort bool, fn func(key, val []byte) error) error {
+	switch {
+	case n.flags&nodeDupData != 0 && n.flags&nodeSubData != 0:
+		// the values are the keys of a separate tree.
+		if len(n.data) != dbSize {
+			w.skip(fmt.Errorf("lmdbpage: key %q: bad sub-database record", n.key))
+			return nil
+		}
+		sub := parseDB(n.data)
+		if sub.Empty() {
+			return nil
+		}
+		return w.walkTree(sub.Root, func(p *page, dn *node, val []byte) error {
+			return fn(n.key, val)
+		})
+	case n.flags&nodeDupData != 0:
+		// the values are the keys of a sub-page embedded in the node.
+		return w.subPage(n, fn)
+	case n.flags&nodeSubData != 0:
+		// a named database, reported by Databases.
+		return nil
+	}
+	val, err := w.value(n)
+	if err != nil {
+		w.skip(err)
+		return nil
+	}
+	return fn(n.key, val)
+}
+
+// value returns the data of a plain leaf node, reading overflow pages.
+func (w *Walker) value(n *node) ([]byte, error) {
+	if n.flags&nodeBigData == 0 {
+		return n.data, nil
+	}
+	pgno := binary.LittleEndian.Uint64(n.data)
+	p, err := w.f.readPage(pgno)
+	if err != nil {
+		return nil, err
+	}
+	if p.flags&PageOverflow == 0 {
+		return nil, &PageError{pgno, "not an overflow page"}
+	}
+	pages := uint64(binary.LittleEndian.Uint32(p.data[12:]))
+	need := pageHeaderSize + n.dsize
+	if pages == 0 || uint64(need) > pages*uint64(w.f.psize) || pgno+pages > w.f.NumPages() {
+		return nil, &PageError{pgno, fmt.Sprintf("bad overflow length for %d bytes", n.dsize)}
+	}
+	val := make([]byte, n.dsize)
+	_, err = w.f.r.ReadAt(val, int64(pgno)*int64(w.f.psize)+pageHeaderSize)
+	if err != nil {
+		return nil, &PageError{pgno, err.Error()}
+	}
+	return val, nil
+}
+
+// subPage reports the values stored in a sub-page embedded in node n.
+func (w *Walker) subPage(n *node, fn func(key, val []byte) error) error {
+	b := n.data
+	if len(b) < pageHeaderSize {
+		w.skip(fmt.Errorf("lmdbpage: key %q: short duplicate sub-page", n.key))
+		return nil
+	}
+	p := &page{data: b, flags: binary.LittleEndian.Uint16(b[10:])}
+	nkeys, err := p.numKeys()
+	if err != nil || p.flags&PageSub == 0 {
+		w.skip(fmt.Errorf("lmdbpage: key %q: bad duplicate sub-page", n.key))
+		return nil
+	}
+	if p.flags&PageLeaf2 != 0 {
+		ks := p.pad()
+		if pageHeaderSize+nkeys*ks > len(b) {
+			w.skip(fmt.Errorf("lmdbpage: key %q: bad fixed size sub-page", n.key))
+			return nil
+		}
+		for i := 0; i < nkeys; i++ {
+			err = fn(n.key, b[pageHeaderSize+i*ks:pageHeaderSize+(i+1)*ks])
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for i := 0; i < nkeys; i++ {
+		dn, err := parseNode(b, i, false, 0)
+		if err != nil {
+			w.skip(fmt.Errorf("lmdbpage: key %q: %v", n.key, err))
+			continue
+		}
+		err = fn(n.key, dn.key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// walkTree calls fn with every leaf node (or LEAF2 key) under root.  The key
+// argument is the node key, or the fixed size key of a LEAF2 page in which
+// case the node is nil.
+func (w *Walker) walkTree(root uint64, fn func(p *page, n *node, key []byte) error) error {
+	if w.visited[root] {
+		w.skip(&PageError{root, "page is referenced twice"})
+		return nil
+	}
+	w.visited[root] = true
+
+	p, err := w.f.readPage(root)
+	if err != nil {
+		w.skip(err)
+		return nil
+	}
+	nkeys, err := p.numKeys()
+	if err != nil {
+		w.skip(err)
+		return nil
+	}
+	switch {
+	case p.flags&PageBranch != 0:
+		for i := 0; i < nkeys; i++ {
+			n, err := parseNode(p.data, i, true, p.pgno)
+			if err != nil {
+				w.skip(err)
+				continue
+			}
+			err = w.walkTree(n.child, fn)
+			if err != nil {
+				return err
+			}
+		}
+	case p.flags&PageLeaf2 != 0:
+		ks := p.pad()
+		if ks == 0 || pageHeaderSize+nkeys*ks > len(p.data) {
+			w.skip(&PageError{p.pgno, "bad fixed key size"})
+			return nil
+		}
+		for i := 0; i < nkeys; i++ {
+			err = fn(p, nil, p.data[pageHeaderSize+i*ks:pageHeaderSize+(i+1)*ks])
+			if err != nil {
+				return err
+			}
+		}
+	case p.flags&PageLeaf != 0:
+		for i := 0; i < nkeys; i++ {
+			n, err := parseNode(p.data, i, false, p.pgno)
+			if err != nil {
+				w.skip(err)
+				continue
+			}
+			err = fn(p, n, n.key)
+			if err != nil {
+				return err
+			}
+		}
+	default:
+		w.skip(&PageError{p.pgno, fmt.Sprintf("unexpected page flags %#x", p.flags)})
+	}
+	return nil
+}
+
+// Databases returns the named databases recorded in main, the main database
+// of a meta page.
+func (w *Walker) Databases(main DB) (map[string]DB, error) {
+	dbs := make(map[string]DB)
+	if main.Empty() {
+		return dbs, nil
+	}
+	w.visited = make(map[uint64]bool)
+	err := w.walkTree(main.Root, func(p *page, n *node, key []byte) error {
+		if n == nil || n.flags&(nodeSubData|nodeDupData) != nodeSubData {
+			return nil
+		}
+		if len(n.data) != dbSize {
+			w.skip(fmt.Errorf("lmdbpage: database %q: bad record", n.key))
+			return nil
+		}
+		dbs[string(n.key)] = parseDB(n.data)
+		return nil
+	})
+	return dbs, err
+}
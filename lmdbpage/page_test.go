@@ -0,0 +1,207 @@
+package lmdbpage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/glycerine/lmdb-go/int/lmdbtest"
+	"github.com/glycerine/lmdb-go/lmdb"
+)
+
+// testDBs lists the databases created by newTestEnv with their flags.
+var testDBs = map[string]uint{
+	"":      0,
+	"plain": 0,
+	"dups":  lmdb.DupSort,
+	"fixed": lmdb.DupSort | lmdb.DupFixed,
+}
+
+// newTestEnv creates an environment exercising every kind of page and
+// returns its path along with the items of each database.
+func newTestEnv(t *testing.T) (string, map[string][]Item) {
+	env, err := lmdbtest.NewEnv(&lmdbtest.EnvOptions{MaxDBs: 4, MapSize: 8 << 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer env.Close()
+	path, err := env.Path()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	items := make(map[string][]Item)
+	err = env.Update(func(txn *lmdb.Txn) (err error) {
+		put := func(name string, dbi lmdb.DBI, k, v []byte) error {
+			items[name] = append(items[name], Item{k, v})
+			return txn.Put(dbi, k, v, 0)
+		}
+		for name, flags := range testDBs {
+			var dbi lmdb.DBI
+			if name == "" {
+				dbi, err = txn.OpenRoot(0)
+			} else {
+				dbi, err = txn.OpenDBI(name, lmdb.Create|flags)
+			}
+			if err != nil {
+				return err
+			}
+			switch name {
+			case "", "plain":
+				for i := 0; i < 300; i++ {
+					err = put(name, dbi, []byte(fmt.Sprintf("%s%04d", name, i)), []byte(fmt.Sprint(i)))
+					if err != nil {
+						return err
+					}
+				}
+				err = put(name, dbi, []byte("~big"), bytes.Repeat([]byte(name+"!"), 5000))
+			case "dups", "fixed":
+				// "a" has few values and fits in a sub-page, "b" needs a
+				// separate tree.
+				for _, n := range []struct {
+					key   string
+					count int
+				}{{"a", 5}, {"b", 1000}} {
+					for i := 0; i < n.count; i++ {
+						v := make([]byte, 8)
+						binary.BigEndian.PutUint64(v, uint64(i))
+						err = put(name, dbi, []byte(n.key), v)
+						if err != nil {
+							return err
+						}
+					}
+				}
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		os.RemoveAll(path)
+		t.Fatal(err)
+	}
+	return path, items
+}
+
+func walkAll(t *testing.T, f *File, w *Walker) map[string][]Item {
+	meta := f.Meta()
+	if meta == nil {
+		t.Fatal("no valid meta page")
+	}
+	dbs, err := w.Databases(meta.Main)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbs[""] = meta.Main
+	got := make(map[string][]Item)
+	for name, db := range dbs {
+		err = w.Walk(db, func(k, v []byte) error {
+			got[name] = append(got[name], Item{append([]byte(nil), k...), append([]byte(nil), v...)})
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return got
+}
+
+func TestWalk(t *testing.T) {
+	path, items := newTestEnv(t)
+	defer os.RemoveAll(path)
+
+	f, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if f.PageSize() != os.Getpagesize() {
+		t.Errorf("unexpected page size: %d", f.PageSize())
+	}
+
+	w := f.NewWalker()
+	got := walkAll(t, f, w)
+	if w.Skipped != 0 {
+		t.Errorf("%d pages skipped in an intact file", w.Skipped)
+	}
+	for name := range testDBs {
+		if !equalItems(got[name], items[name]) {
+			t.Errorf("database %q: got %d items, want %d", name, len(got[name]), len(items[name]))
+		}
+	}
+}
+
+func TestWalk_damaged(t *testing.T) {
+	path, items := newTestEnv(t)
+	defer os.RemoveAll(path)
+
+	f, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbs, err := f.NewWalker().Databases(f.Meta().Main)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// overwrite the root of "plain", losing the whole database but nothing
+	// else.
+	file, err := os.OpenFile(filepath.Join(path, "data.mdb"), os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = file.WriteAt(make([]byte, 64), int64(dbs["plain"].Root)*int64(os.Getpagesize()))
+	file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var skipped []error
+	w := f.NewWalker()
+	w.Skip = func(err error) { skipped = append(skipped, err) }
+	got := walkAll(t, f, w)
+	if len(skipped) != 1 || w.Skipped != 1 {
+		t.Errorf("unexpected skipped pages: %v", skipped)
+	}
+	if len(got["plain"]) != 0 {
+		t.Errorf("items read from a damaged page")
+	}
+	for _, name := range []string{"", "dups", "fixed"} {
+		if !equalItems(got[name], items[name]) {
+			t.Errorf("database %q: got %d items, want %d", name, len(got[name]), len(items[name]))
+		}
+	}
+}
+
+func TestNewFile_notLMDB(t *testing.T) {
+	data := make([]byte, 1<<16)
+	_, err := NewFile(bytes.NewReader(data), int64(len(data)))
+	if err != ErrNotLMDB {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func equalItems(a, b []Item) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	// the test data is inserted in storage order except for "~big", which
+	// sorts last.
+	for i := range a {
+		if !bytes.Equal(a[i].Key, b[i].Key) || !bytes.Equal(a[i].Val, b[i].Val) {
+			return false
+		}
+	}
+	return true
+}
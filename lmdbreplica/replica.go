@@ -0,0 +1,400 @@
+/*
+Package lmdbreplica lets many reader-only processes on a host share the data
+file of an environment written by another process, as a local read replica
+or cache, without each of them tracking what the writer does to the file.
+
+The writer wraps its environment in a Publisher, which records the facts
+readers depend on in an announcement file next to the data file (see
+AnnounceFile): the flags of the environment, its map size, and a generation
+number incremented whenever the data file is replaced, e.g. by a compacted
+copy.  The writer grows the map through Publisher.SetMapSize and reports
+replacing the data file with Publisher.Swapped.
+
+Readers open the environment with Open, which checks the announcement before
+opening the data file read-only.  Reader.View begins read transactions,
+adopting a larger map size when LMDB reports MapResized, and periodically
+checks the announcement (and the identity of the data file) to reopen the
+environment after a swap.
+*/
+package lmdbreplica
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/glycerine/lmdb-go/lmdb"
+)
+
+// AnnounceFile is the name of the announcement file in the directory of an
+// environment.  For an environment opened with NoSubdir the announcement is
+// the path of the data file with the suffix "-" + AnnounceFile.
+const AnnounceFile = "replica.json"
+
+// ErrWriterNoLock is returned by Open when the writer does not use the lock
+// file, without which readers are not safe.
+var ErrWriterNoLock = errors.New("lmdbreplica: writer environment uses NoLock")
+
+// ErrReaderFlags is returned by Open for reader flags other than NoReadahead
+// and NoTLS.
+var ErrReaderFlags = errors.New("lmdbreplica: unsupported reader flags")
+
+// ErrClosed is returned by Reader.View after Close.
+var ErrClosed = errors.New("lmdbreplica: reader is closed")
+
+// Announcement is the content of the announcement file.
+type Announcement struct {
+	// Generation is incremented each time the data file is replaced.
+	Generation uint64 `json:"generation"`
+
+	// Flags are the flags of the writer environment.
+	Flags lmdb.EnvFlags `json:"flags"`
+
+	// MapSize is the map size last set by the writer.
+	MapSize int64 `json:"map_size"`
+
+	// Updated is the time of the last announcement.
+	Updated time.Time `json:"updated"`
+}
+
+// announcePath returns the path of the announcement file of the environment
+// at path.
+func announcePath(path string, flags uint) string {
+	if flags&lmdb.NoSubdir != 0 {
+		return path + "-" + AnnounceFile
+	}
+	return filepath.Join(path, AnnounceFile)
+}
+
+// dataPath returns the path of the data file of the environment at path.
+func dataPath(path string, flags uint) string {
+	if flags&lmdb.NoSubdir != 0 {
+		return path
+	}
+	return filepath.Join(path, "data.mdb")
+}
+
+// ReadAnnouncement reads the announcement of the environment at path, a
+// directory or, if noSubdir is true, a data file.
+func ReadAnnouncement(path string, noSubdir bool) (*Announcement, error) {
+	var flags uint
+	if noSubdir {
+		flags = lmdb.NoSubdir
+	}
+	b, err := ioutil.ReadFile(announcePath(path, flags))
+	if err != nil {
+		return nil, err
+	}
+	a := &Announcement{}
+	err = json.Unmarshal(b, a)
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Publisher maintains the announcement of a writer environment.
+type Publisher struct {
+	mu   sync.Mutex
+	env  *lmdb.Env
+	path string
+	ann  Announcement
+}
+
+// Publish starts announcing env to readers, continuing the generations of a
+// previous announcement if there is one.
+func Publish(env *lmdb.Env) (*Publisher, error) {
+	path, err := env.Path()
+	if err != nil {
+		return nil, err
+	}
+	flags, err := env.Flags()
+	if err != nil {
+		return nil, err
+	}
+	p := &Publisher{env: env, path: announcePath(path, flags)}
+	prev, err := ReadAnnouncement(path, flags&lmdb.NoSubdir != 0)
+	if err == nil {
+		p.ann.Generation = prev.Generation
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	err = p.announce()
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Announcement returns the current announcement.
+func (p *Publisher) Announcement() Announcement {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.ann
+}
+
+// SetMapSize sets the map size of the writer environment, see
+// Env.SetMapSize, and announces it.  As with Env.SetMapSize, no transaction
+// may be active in the writer process.
+func (p *Publisher) SetMapSize(size int64) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	err := p.env.SetMapSize(size)
+	if err != nil {
+		return err
+	}
+	return p.announceLocked()
+}
+
+// Swapped announces that the data file was replaced, e.g. by a compacted
+// copy, and that the writer now uses env, opened on the new file.
+func (p *Publisher) Swapped(env *lmdb.Env) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.env = env
+	p.ann.Generation++
+	return p.announceLocked()
+}
+
+func (p *Publisher) announce() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.announceLocked()
+}
+
+// announceLocked writes the announcement file atomically.
+func (p *Publisher) announceLocked() error {
+	info, err := p.env.Info()
+	if err != nil {
+		return err
+	}
+	flags, err := p.env.Flags()
+	if err != nil {
+		return err
+	}
+	p.ann.Flags = lmdb.EnvFlags(flags)
+	p.ann.MapSize = info.MapSize
+	p.ann.Updated = time.Now()
+	b, err := json.MarshalIndent(&p.ann, "", "\t")
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(p.path+".tmp", append(b, '\n'), 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(p.path+".tmp", p.path)
+}
+
+// ReaderOptions configures a Reader.
+type ReaderOptions struct {
+	// MaxDBs is the maximum number of named databases, see Env.SetMaxDBs.
+	MaxDBs int
+
+	// Flags are added to the Readonly flag.  Only NoReadahead and NoTLS are
+	// allowed.
+	Flags uint
+
+	// NoSubdir must be set if the writer environment uses NoSubdir.
+	NoSubdir bool
+
+	// CheckInterval is the minimum time between checks of the announcement
+	// by View, a second if zero.
+	CheckInterval time.Duration
+
+	// MaxRetry is the number of times View adopts a new map size and
+	// retries a transaction failing with MapResized, 2 if zero.
+	MaxRetry int
+}
+
+// Reader is a read-only view of a published environment.
+type Reader struct {
+	path     string
+	opts     ReaderOptions
+	flags    uint
+	interval time.Duration
+	maxRetry int
+
+	mu     sync.RWMutex // held exclusively to reopen or resize env
+	env    *lmdb.Env    // nil if reopening failed or closed
+	gen    uint64
+	file   os.FileInfo // data file env was opened on
+	closed bool
+
+	checkMu sync.Mutex
+	checked time.Time
+}
+
+// Open opens the published environment at path read-only.
+func Open(path string, opts *ReaderOptions) (*Reader, error) {
+	r := &Reader{path: path}
+	if opts != nil {
+		r.opts = *opts
+	}
+	if r.opts.Flags&^(lmdb.NoReadahead|lmdb.NoTLS) != 0 {
+		return nil, ErrReaderFlags
+	}
+	r.flags = lmdb.Readonly | r.opts.Flags
+	if r.opts.NoSubdir {
+		r.flags |= lmdb.NoSubdir
+	}
+	r.interval = r.opts.CheckInterval
+	if r.interval <= 0 {
+		r.interval = time.Second
+	}
+	r.maxRetry = r.opts.MaxRetry
+	if r.maxRetry <= 0 {
+		r.maxRetry = 2
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	err := r.open()
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// open opens the environment after validating its announcement.
+func (r *Reader) open() error {
+	ann, err := ReadAnnouncement(r.path, r.opts.NoSubdir)
+	if err != nil {
+		return err
+	}
+	if uint(ann.Flags)&lmdb.NoLock != 0 {
+		return ErrWriterNoLock
+	}
+	file, err := os.Stat(dataPath(r.path, r.flags))
+	if err != nil {
+		return err
+	}
+	env, err := lmdb.OpenEnv(r.path, &lmdb.Options{
+		MaxDBs:  r.opts.MaxDBs,
+		MapSize: ann.MapSize,
+		Flags:   r.flags,
+	})
+	if err != nil {
+		return err
+	}
+	r.env, r.gen, r.file = env, ann.Generation, file
+	return nil
+}
+
+// Generation returns the generation of the data file the reader has open.
+func (r *Reader) Generation() uint64 {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.gen
+}
+
+// View runs fn in a read transaction, see Env.View.  The environment is
+// reopened first if the data file was swapped, and the transaction is
+// retried after adopting the new map size if it fails with MapResized.
+// Transactions must not outlive fn.
+func (r *Reader) View(fn lmdb.TxnOp) error {
+	for attempt := 0; ; attempt++ {
+		err := r.check()
+		if err != nil {
+			return err
+		}
+		r.mu.RLock()
+		if r.env == nil {
+			// reopened unsuccessfully by another goroutine, check reopens
+			// it or reports why.
+			r.mu.RUnlock()
+			continue
+		}
+		err = r.env.View(fn)
+		r.mu.RUnlock()
+		if !lmdb.IsMapResized(err) || attempt >= r.maxRetry {
+			return err
+		}
+		err = r.adoptMapSize()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// adoptMapSize sets the map size of the environment to the one of the data
+// file.
+func (r *Reader) adoptMapSize() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.env == nil {
+		return nil
+	}
+	return r.env.SetMapSize(0)
+}
+
+// check reopens the environment if the data file was swapped, at most once
+// per CheckInterval, or if it is not open.
+func (r *Reader) check() error {
+	r.mu.RLock()
+	closed, open := r.closed, r.env != nil
+	r.mu.RUnlock()
+	if closed {
+		return ErrClosed
+	}
+	if !open {
+		return r.reopen()
+	}
+	r.checkMu.Lock()
+	if time.Since(r.checked) < r.interval {
+		r.checkMu.Unlock()
+		return nil
+	}
+	r.checked = time.Now()
+	r.checkMu.Unlock()
+
+	ann, err := ReadAnnouncement(r.path, r.opts.NoSubdir)
+	if err != nil {
+		return err
+	}
+	file, err := os.Stat(dataPath(r.path, r.flags))
+	if err != nil {
+		return err
+	}
+	r.mu.RLock()
+	swapped := ann.Generation != r.gen || !os.SameFile(file, r.file)
+	r.mu.RUnlock()
+	if !swapped {
+		return nil
+	}
+	return r.reopen()
+}
+
+// reopen closes the environment, if open, and opens it again.
+func (r *Reader) reopen() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return ErrClosed
+	}
+	if r.env != nil {
+		r.env.Close()
+		r.env = nil
+	}
+	return r.open()
+}
+
+// Close closes the environment.  Calls to View must have returned.
+func (r *Reader) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	if r.env == nil {
+		return nil
+	}
+	err := r.env.Close()
+	r.env = nil
+	return err
+}
@@ -0,0 +1,141 @@
+package lmdbreplica
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/glycerine/lmdb-go/lmdb"
+)
+
+func put(t *testing.T, env *lmdb.Env, n int, val []byte) {
+	err := env.Update(func(txn *lmdb.Txn) error {
+		dbi, err := txn.OpenRoot(0)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < n; i++ {
+			err = txn.Put(dbi, []byte(fmt.Sprintf("k%05d", i)), val, 0)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func count(r *Reader) (n int, err error) {
+	err = r.View(func(txn *lmdb.Txn) error {
+		dbi, err := txn.OpenRoot(0)
+		if err != nil {
+			return err
+		}
+		stat, err := txn.Stat(dbi)
+		if err != nil {
+			return err
+		}
+		n = int(stat.Entries)
+		return nil
+	})
+	return n, err
+}
+
+func TestReader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lmdbreplica")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	env, err := lmdb.OpenEnv(dir, &lmdb.Options{MapSize: 1 << 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { env.Close() }()
+	put(t, env, 10, []byte("v"))
+
+	_, err = Open(dir, nil)
+	if !os.IsNotExist(err) {
+		t.Fatalf("unannounced environment: %v", err)
+	}
+	p, err := Publish(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = Open(dir, &ReaderOptions{Flags: lmdb.WriteMap})
+	if err != ErrReaderFlags {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	r, err := Open(dir, &ReaderOptions{CheckInterval: time.Nanosecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if n, err := count(r); err != nil || n != 10 {
+		t.Fatalf("%d items: %v", n, err)
+	}
+
+	// the reader adopts the map size grown by the writer.
+	err = p.SetMapSize(16 << 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	put(t, env, 2000, make([]byte, 2000))
+	if n, err := count(r); err != nil || n != 2000 {
+		t.Fatalf("%d items after resize: %v", n, err)
+	}
+	if a := p.Announcement(); a.MapSize != 16<<20 {
+		t.Errorf("announced map size %d", a.MapSize)
+	}
+
+	// the reader reopens a swapped data file.
+	err = env.Update(func(txn *lmdb.Txn) error {
+		dbi, err := txn.OpenRoot(0)
+		if err != nil {
+			return err
+		}
+		return txn.Drop(dbi, false)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	put(t, env, 5, []byte("v"))
+	tmp, err := ioutil.TempDir(dir, "compact")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = env.CopyFlag(tmp, lmdb.CopyCompact)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.Close()
+	err = os.Rename(filepath.Join(tmp, "data.mdb"), filepath.Join(dir, "data.mdb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	env, err = lmdb.OpenEnv(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Swapped(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := count(r); err != nil || n != 5 {
+		t.Fatalf("%d items after swap: %v", n, err)
+	}
+	if g := r.Generation(); g != 1 {
+		t.Errorf("generation %d", g)
+	}
+
+	r.Close()
+	if _, err := count(r); err != ErrClosed {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
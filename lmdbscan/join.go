@@ -0,0 +1,166 @@
+package lmdbscan
+
+import (
+	"bytes"
+
+	"github.com/glycerine/lmdb-go/lmdb"
+)
+
+// Join scans several databases together in shared key order within a single
+// transaction.  Each call to Scan advances to the next smallest key present
+// in any of the databases and exposes the value stored under that key in
+// every database, or nil where it is absent.  Because all cursors belong to
+// the same transaction the scan sees one consistent snapshot, which makes
+// Join suitable for join-like reads between a primary table and the index
+// databases keyed like it.
+//
+// Only the first value of each key is reported for DupSort databases.
+type Join struct {
+	curs []*lmdb.Cursor
+	keys [][]byte // current key of each cursor, nil when exhausted
+	vals [][]byte // current value of each cursor
+	key  []byte
+	out  [][]byte
+	err  error
+	done bool
+	// started is true once the cursors have been positioned.
+	started bool
+}
+
+// NewJoin opens cursors on dbis within txn.  When the Join returned by
+// NewJoin is no longer needed its Close method must be called.
+func NewJoin(txn *lmdb.Txn, dbis ...lmdb.DBI) *Join {
+	j := &Join{
+		keys: make([][]byte, len(dbis)),
+		vals: make([][]byte, len(dbis)),
+		out:  make([][]byte, len(dbis)),
+	}
+	for _, dbi := range dbis {
+		cur, err := txn.OpenCursor(dbi)
+		if err != nil {
+			j.err = err
+			j.Close()
+			return j
+		}
+		j.curs = append(j.curs, cur)
+	}
+	return j
+}
+
+// Set positions every cursor of j on the first key greater than or equal to
+// key.  The following call to Scan reports that key.
+func (j *Join) Set(key []byte) bool {
+	if !j.checkOpen() {
+		return false
+	}
+	for i := range j.curs {
+		if len(key) == 0 {
+			j.move(i, nil, lmdb.First)
+		} else {
+			j.move(i, key, lmdb.SetRange)
+		}
+	}
+	j.key = nil
+	j.started = true
+	j.done = false
+	return j.err == nil
+}
+
+// Scan advances j to the next key.  Scan returns false when every database
+// is exhausted or an error is encountered.
+func (j *Join) Scan() bool {
+	if !j.checkOpen() || j.done {
+		return false
+	}
+	if !j.started {
+		j.Set(nil)
+		if j.err != nil {
+			return false
+		}
+	} else if j.key != nil {
+		// advance every cursor that reported the previous key.
+		for i := range j.curs {
+			if j.keys[i] != nil && bytes.Equal(j.keys[i], j.key) {
+				j.move(i, nil, lmdb.NextNoDup)
+			}
+		}
+		if j.err != nil {
+			return false
+		}
+	}
+
+	j.key = nil
+	for _, k := range j.keys {
+		if k != nil && (j.key == nil || bytes.Compare(k, j.key) < 0) {
+			j.key = k
+		}
+	}
+	if j.key == nil {
+		j.done = true
+		return false
+	}
+	for i, k := range j.keys {
+		if k != nil && bytes.Equal(k, j.key) {
+			j.out[i] = j.vals[i]
+		} else {
+			j.out[i] = nil
+		}
+	}
+	return true
+}
+
+// move moves cursor i with op and records the result.
+func (j *Join) move(i int, key []byte, op uint) {
+	k, v, err := j.curs[i].Get(key, nil, op)
+	if lmdb.IsNotFound(err) {
+		j.keys[i], j.vals[i] = nil, nil
+		return
+	}
+	if err != nil {
+		j.err = err
+		return
+	}
+	j.keys[i], j.vals[i] = k, v
+}
+
+// Key returns the key read during the last call to Scan.
+func (j *Join) Key() []byte {
+	return j.key
+}
+
+// Vals returns the values of the current key, indexed like the dbis passed
+// to NewJoin.  A value is nil if its database does not contain the key.  The
+// returned slice is overwritten by the next call to Scan.
+func (j *Join) Vals() [][]byte {
+	return j.out
+}
+
+// Val returns the value of the current key in the i-th database, or nil.
+func (j *Join) Val(i int) []byte {
+	return j.out[i]
+}
+
+// Err returns a non-nil error if and only if the previous call to Scan
+// resulted in an error other than lmdb.ErrNotFound.
+func (j *Join) Err() error {
+	return j.err
+}
+
+func (j *Join) checkOpen() bool {
+	if j.curs != nil {
+		return true
+	}
+	if j.err == nil {
+		j.err = errClosed
+	}
+	return false
+}
+
+// Close closes the cursors underlying j.  Close does not attempt to terminate
+// the enclosing transaction.
+func (j *Join) Close() {
+	for _, cur := range j.curs {
+		cur.Close()
+	}
+	j.curs = nil
+}
@@ -0,0 +1,73 @@
+package lmdbscan
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/glycerine/lmdb-go/int/lmdbtest"
+	"github.com/glycerine/lmdb-go/lmdb"
+)
+
+func TestJoin(t *testing.T) {
+	env, err := lmdbtest.NewEnv(&lmdbtest.EnvOptions{MaxDBs: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lmdbtest.Destroy(env)
+
+	db1, err := lmdbtest.OpenDBI(env, "db1", lmdb.Create)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db2, err := lmdbtest.OpenDBI(env, "db2", lmdb.Create|lmdb.DupSort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = lmdbtest.Put(env, db1, lmdbtest.SimpleItemList{
+		{K: "a", V: "1a"},
+		{K: "c", V: "1c"},
+		{K: "d", V: "1d"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = lmdbtest.Put(env, db2, lmdbtest.SimpleItemList{
+		{K: "b", V: "2b"},
+		{K: "c", V: "2c"},
+		{K: "c", V: "2cc"},
+		{K: "e", V: "2e"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = env.View(func(txn *lmdb.Txn) (err error) {
+		j := NewJoin(txn, db1, db2)
+		defer j.Close()
+
+		var rows []string
+		for j.Scan() {
+			rows = append(rows, fmt.Sprintf("%s:%s,%s", j.Key(), j.Val(0), j.Val(1)))
+		}
+		if j.Err() != nil {
+			return j.Err()
+		}
+		expect := []string{"a:1a,", "b:,2b", "c:1c,2c", "d:1d,", "e:,2e"}
+		if fmt.Sprint(rows) != fmt.Sprint(expect) {
+			t.Errorf("unexpected rows: %q (!= %q)", rows, expect)
+		}
+
+		rows = nil
+		j.Set([]byte("c1"))
+		for j.Scan() {
+			rows = append(rows, string(j.Key()))
+		}
+		if fmt.Sprint(rows) != "[d e]" {
+			t.Errorf("unexpected rows after Set: %q", rows)
+		}
+		return j.Err()
+	})
+	if err != nil {
+		t.Error(err)
+	}
+}
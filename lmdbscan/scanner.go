@@ -23,6 +23,8 @@ type Scanner struct {
 	val []byte
 	err error
 	set bool
+
+	keysOnly bool
 }
 
 // New allocates and intializes a Scanner for dbi within txn.  When the Scanner
@@ -37,6 +39,14 @@ func New(txn *lmdb.Txn, dbi lmdb.DBI) *Scanner {
 	return s
 }
 
+// NewKeys is like New but returns a Scanner that reads only keys, see
+// lmdb.Cursor.GetKey.  Its Val method always returns nil.
+func NewKeys(txn *lmdb.Txn, dbi lmdb.DBI) *Scanner {
+	s := New(txn, dbi)
+	s.keysOnly = true
+	return s
+}
+
 // Cursor returns the lmdb.Cursor underlying s.  Cursor returns nil if s is
 // closed.
 func (s *Scanner) Cursor() *lmdb.Cursor {
@@ -71,7 +81,7 @@ func (s *Scanner) Set(k, v []byte, opset uint) bool {
 		return false
 	}
 	s.set = true
-	s.key, s.val, s.err = s.cur.Get(k, v, opset)
+	s.get(k, v, opset)
 	return s.err == nil
 }
 
@@ -97,11 +107,19 @@ func (s *Scanner) Scan() bool {
 	if s.set {
 		s.set = false
 	} else {
-		s.key, s.val, s.err = s.cur.Get(nil, nil, s.op)
+		s.get(nil, nil, s.op)
 	}
 	return s.err == nil
 }
 
+func (s *Scanner) get(k, v []byte, op uint) {
+	if s.keysOnly {
+		s.key, s.err = s.cur.GetKey(k, v, op)
+		return
+	}
+	s.key, s.val, s.err = s.cur.Get(k, v, op)
+}
+
 func (s *Scanner) checkOpen() bool {
 	if s.cur != nil {
 		return true
@@ -105,6 +105,51 @@ func TestScanner_Scan(t *testing.T) {
 	}
 }
 
+func TestScanner_keys(t *testing.T) {
+	env, err := lmdbtest.NewEnv(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lmdbtest.Destroy(env)
+
+	dbi, err := lmdbtest.OpenRoot(env, 0)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	items := lmdbtest.SimpleItemList{
+		{"k0", "v0"},
+		{"k1", "v1"},
+		{"k2", "v2"},
+	}
+	err = lmdbtest.Put(env, dbi, items)
+	if err != nil {
+		t.Error(err)
+	}
+
+	var keys []string
+	err = env.View(func(txn *lmdb.Txn) (err error) {
+		s := NewKeys(txn, dbi)
+		defer s.Close()
+
+		s.Set([]byte("k1"), nil, lmdb.SetRange)
+		for s.Scan() {
+			if s.Val() != nil {
+				t.Errorf("key %q: value %q", s.Key(), s.Val())
+			}
+			keys = append(keys, string(s.Key()))
+		}
+		return s.Err()
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual(keys, []string{"k1", "k2"}) {
+		t.Errorf("keys: %q", keys)
+	}
+}
+
 func TestScanner_Set(t *testing.T) {
 	env, err := lmdbtest.NewEnv(nil)
 	if err != nil {
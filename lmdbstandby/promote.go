@@ -0,0 +1,176 @@
+package lmdbstandby
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/glycerine/lmdb-go/lmdb"
+)
+
+// FenceFile is the name of the file left in the directory of a promoted
+// standby, see Promote.
+const FenceFile = "promoted.json"
+
+// ErrBehind indicates that a standby does not hold the source transactions
+// required to promote it.  Such failures return a *BehindError for which
+// errors.Is(err, ErrBehind) is true.
+var ErrBehind = errors.New("lmdbstandby: standby behind the target watermark")
+
+// BehindError describes a standby that did not reach a target watermark.
+type BehindError struct {
+	TxnID  uint64 // watermark of the standby, see Status.TxnID
+	Target uint64
+}
+
+func (err *BehindError) Error() string {
+	return fmt.Sprintf("%v: holds transactions up to %d, not %d", ErrBehind, err.TxnID, err.Target)
+}
+
+// Is allows errors.Is(err, ErrBehind) to match a *BehindError.
+func (err *BehindError) Is(target error) bool {
+	return target == ErrBehind
+}
+
+// ErrFenced indicates that a standby was promoted, so that its Standby may
+// no longer write to it.  Such failures return a *FencedError for which
+// errors.Is(err, ErrFenced) is true.
+var ErrFenced = errors.New("lmdbstandby: standby promoted")
+
+// FencedError describes the promotion of a standby that fenced a Standby.
+type FencedError struct {
+	Fence Fence
+}
+
+func (err *FencedError) Error() string {
+	return fmt.Sprintf("%v at %v, holding transactions up to %d", ErrFenced, err.Fence.Time.Format(time.RFC3339), err.Fence.TxnID)
+}
+
+// Is allows errors.Is(err, ErrFenced) to match a *FencedError.
+func (err *FencedError) Is(target error) bool {
+	return target == ErrFenced
+}
+
+// Fence records the promotion of a standby, in its FenceFile.
+type Fence struct {
+	Time time.Time `json:"time"`
+
+	// TxnID is the watermark of the standby when promoted.  The
+	// transactions committed to the old primary after it are lost to the
+	// new one.
+	TxnID uint64 `json:"txn_id"`
+
+	// Host and PID identify the process that promoted the standby.
+	Host string `json:"host,omitempty"`
+	PID  int    `json:"pid"`
+}
+
+// Fenced returns the fence of the standby in dir, or nil if it was not
+// promoted.  An old primary should check it before accepting writes, e.g.
+// when it restarts after a failover, as a Standby refuses to start on a
+// promoted standby.
+func Fenced(dir string) (*Fence, error) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, FenceFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	fence := &Fence{}
+	err = json.Unmarshal(b, fence)
+	if err != nil {
+		return nil, err
+	}
+	return fence, nil
+}
+
+// Promote makes the standby in dir a primary, when failing over: it checks
+// that the standby holds the source transactions up to target (none if
+// zero), see Status.TxnID, fences the standby and opens it writable with
+// opts.  It fails with a *BehindError if the watermark of the standby is
+// below target, leaving the standby untouched.
+//
+// The Standby maintaining dir must be stopped or cut off from it first.  A
+// Standby still running, e.g. in an old primary isolated from its clients,
+// stops before writing to the standby again when it finds the fence, see
+// Options.OnFenced.  Promoting a standby promoted already only opens it.
+func Promote(dir string, target uint64, opts *lmdb.Options) (*lmdb.Env, error) {
+	fence, err := Fenced(dir)
+	if err != nil {
+		return nil, err
+	}
+	if fence == nil {
+		st, err := ReadStatus(dir)
+		if err != nil {
+			return nil, err
+		}
+		fence = &Fence{Time: time.Now(), TxnID: st.TxnID, PID: os.Getpid()}
+		fence.Host, _ = os.Hostname()
+		if fence.TxnID < target {
+			return nil, &BehindError{TxnID: fence.TxnID, Target: target}
+		}
+		err = writeJSON(filepath.Join(dir, FenceFile), fence)
+		if err != nil {
+			return nil, err
+		}
+	} else if fence.TxnID < target {
+		return nil, &BehindError{TxnID: fence.TxnID, Target: target}
+	}
+	return lmdb.OpenEnv(dir, opts)
+}
+
+// CatchUp waits until the watermark of the standby reaches target, see
+// Status.TxnID.  It fails with a *BehindError if the Standby stops first,
+// and with the error of ctx if it is done first.
+func (s *Standby) CatchUp(ctx context.Context, target uint64) error {
+	for {
+		s.mu.Lock()
+		id, progress := s.status.TxnID, s.progress
+		s.mu.Unlock()
+		if id >= target {
+			return nil
+		}
+		select {
+		case <-progress:
+		case <-s.done:
+			return &BehindError{TxnID: id, Target: target}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Promote completes a planned switchover to the standby: it waits for the
+// standby to catch up to target, typically the id of the last transaction
+// of the source once writes to it are stopped (see lmdb.Env.Info), stops
+// the Standby and promotes the standby, see Promote.  If opts is nil the
+// standby is opened with the map size and the maximum number of databases
+// of the source, and room for the databases of Options.DBs.
+func (s *Standby) Promote(ctx context.Context, target uint64, opts *lmdb.Options) (*lmdb.Env, error) {
+	err := s.CatchUp(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+	// the watermark checked by Promote, not the errors met meanwhile,
+	// decides whether the standby is current.
+	s.Stop()
+	if opts == nil {
+		// a full copy holds every database of the source.
+		cfg, err := s.env.Config()
+		if err != nil {
+			return nil, err
+		}
+		maxDBs := cfg.MaxDBs
+		if maxDBs < len(s.opts.DBs) {
+			maxDBs = len(s.opts.DBs)
+		}
+		opts = &lmdb.Options{MaxDBs: maxDBs, MapSize: cfg.MapSize}
+	}
+	return Promote(s.opts.Dir, target, opts)
+}
@@ -0,0 +1,169 @@
+package lmdbstandby
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/glycerine/lmdb-go/int/lmdbtest"
+	"github.com/glycerine/lmdb-go/lmdb"
+)
+
+func TestStandby_Promote(t *testing.T) {
+	env, dbi, dir := setup(t)
+	defer lmdbtest.Destroy(env)
+	defer os.RemoveAll(dir)
+	standby := filepath.Join(dir, "standby")
+
+	s, err := Start(env, &Options{
+		Dir:      standby,
+		Interval: 20 * time.Millisecond,
+		DBs:      map[string]lmdb.DBI{"data": dbi},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		put(t, env, dbi, "k", string(rune('0'+i)))
+	}
+	info, err := env.Info()
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := uint64(info.LastTxnID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	dst, err := s.Promote(ctx, target, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = dst.Update(func(txn *lmdb.Txn) error {
+		dbi, err := txn.OpenDBI("data", 0)
+		if err != nil {
+			return err
+		}
+		v, err := txn.Get(dbi, []byte("k"))
+		if err != nil {
+			return err
+		}
+		if string(v) != "9" {
+			t.Errorf("k=%q", v)
+		}
+		return txn.Put(dbi, []byte("k"), []byte("promoted"), 0)
+	})
+	dst.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fence, err := Fenced(standby)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fence == nil || fence.TxnID < target || fence.PID != os.Getpid() {
+		t.Errorf("fence %+v", fence)
+	}
+	_, err = Start(env, &Options{Dir: standby})
+	if !errors.Is(err, ErrFenced) {
+		t.Errorf("restarted on a promoted standby: %v", err)
+	}
+}
+
+func TestStandby_Promote_copy(t *testing.T) {
+	env, dbi, dir := setup(t)
+	defer lmdbtest.Destroy(env)
+	defer os.RemoveAll(dir)
+	standby := filepath.Join(dir, "standby")
+
+	s, err := Start(env, &Options{Dir: standby, Interval: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	put(t, env, dbi, "k", "v")
+	info, err := env.Info()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	dst, err := s.Promote(ctx, uint64(info.LastTxnID), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+	// the copy holds the named databases of the source.
+	err = dst.View(func(txn *lmdb.Txn) error {
+		dbi, err := txn.OpenDBI("data", 0)
+		if err != nil {
+			return err
+		}
+		v, err := txn.Get(dbi, []byte("k"))
+		if err != nil {
+			return err
+		}
+		if string(v) != "v" {
+			t.Errorf("k=%q", v)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPromote_fence(t *testing.T) {
+	env, dbi, dir := setup(t)
+	defer lmdbtest.Destroy(env)
+	defer os.RemoveAll(dir)
+	standby := filepath.Join(dir, "standby")
+
+	put(t, env, dbi, "k", "1")
+	fenced := make(chan *Fence, 1)
+	s, err := Start(env, &Options{
+		Dir:      standby,
+		Interval: 20 * time.Millisecond,
+		OnFenced: func(f *Fence) { fenced <- f },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, err := ReadStatus(standby)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.TxnID == 0 {
+		t.Fatalf("status %+v", st)
+	}
+
+	_, err = Promote(standby, st.TxnID+100, nil)
+	var behind *BehindError
+	if !errors.As(err, &behind) || behind.TxnID < st.TxnID || !errors.Is(err, ErrBehind) {
+		t.Fatalf("promoted a stale standby: %v", err)
+	}
+	if f, _ := Fenced(standby); f != nil {
+		t.Fatalf("fenced by a failed promotion: %+v", f)
+	}
+
+	dst, err := Promote(standby, st.TxnID, &lmdb.Options{MaxDBs: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+	select {
+	case f := <-fenced:
+		if f.TxnID < st.TxnID {
+			t.Errorf("fence %+v", f)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("standby not fenced")
+	}
+	err = s.Stop()
+	if !errors.Is(err, ErrFenced) {
+		t.Errorf("stop: %v", err)
+	}
+}
@@ -0,0 +1,496 @@
+/*
+Package lmdbstandby keeps a warm standby copy of an LMDB environment in
+another directory, typically on another disk or a network mount, without a
+separate replication server.
+
+A Standby runs in the process owning the source environment, in one of two
+modes.  Without Options.DBs it writes a full copy of the environment (compacted
+unless Options.NoCompact is set) every Options.Interval.  With Options.DBs it
+writes a full copy once and then applies the changes committed to the listed
+databases, received through an lmdb.Subscription, to the standby as they
+happen.  If changes are lost because the standby falls too far behind, a new
+full copy is made.
+
+Copies are written to a temporary directory next to the standby and renamed
+over its data file, so the standby directory always holds a complete
+environment, though in changeset mode it is only consistent between the
+transactions applying changes.  The standby must not be opened by other
+processes while the Standby is running.
+
+The freshness of the standby is recorded in the file standby.json of its
+directory, see Status and ReadStatus, from which the recovery point of a
+failover can be measured.
+
+A failover makes the standby the new primary with Promote, which checks that
+it holds the source transactions up to a watermark and leaves a fence file in
+its directory.  A Standby finding the fence stops writing to the standby, and
+the old primary can learn that it was replaced, see Options.OnFenced and
+Fenced.
+*/
+package lmdbstandby
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/glycerine/lmdb-go/lmdb"
+)
+
+// StatusFile is the name of the freshness metadata file of a standby.
+const StatusFile = "standby.json"
+
+// Options configures a Standby.
+type Options struct {
+	// Dir is the standby environment directory, created if missing.
+	Dir string
+
+	// Interval is the time between full copies, or in changeset mode the
+	// longest time between updates of the status file.  It defaults to a
+	// minute.
+	Interval time.Duration
+
+	// NoCompact disables the compaction of full copies.
+	NoCompact bool
+
+	// DBs selects changeset mode and names the databases whose changes
+	// are applied to the standby, by the names they were opened with (the
+	// empty name for the root database).  Changes to other databases are
+	// only carried over by full copies.
+	DBs map[string]lmdb.DBI
+
+	// Buffer is the number of changes buffered before a full copy is
+	// needed, see lmdb.SubscribeOptions.
+	Buffer int
+
+	// OnFenced, if not nil, is called once when the Standby stops because
+	// the standby was promoted, see Promote, so that the application can
+	// stop accepting writes to the old primary.
+	OnFenced func(*Fence)
+}
+
+// Status describes the freshness of a standby.
+type Status struct {
+	// Mode is "copy" or "changesets".
+	Mode string `json:"mode"`
+
+	// Copies is the number of full copies made and LastCopy the start of
+	// the last one.
+	Copies   int       `json:"copies"`
+	LastCopy time.Time `json:"last_copy"`
+
+	// SyncedAt is the time up to which the changes committed to the source
+	// are held by the standby, at the granularity of the copies or of the
+	// changes applied.
+	SyncedAt time.Time `json:"synced_at"`
+
+	// Changes is the number of transactions applied since the last copy.
+	Changes int64 `json:"changes"`
+
+	// TxnID is the watermark of the standby: the id of a source
+	// transaction up to which the changes committed to the source are held
+	// by the standby, see lmdb.Txn.ID.  The ids of the standby environment
+	// itself are unrelated.
+	TxnID uint64 `json:"txn_id"`
+
+	// Error is the last error encountered, if any.
+	Error string `json:"error,omitempty"`
+}
+
+// Lag returns how far behind the source the standby was at now, the
+// recovery point of a failover at that time.
+func (st *Status) Lag(now time.Time) time.Duration {
+	return now.Sub(st.SyncedAt)
+}
+
+// ReadStatus reads the status file of the standby in dir.
+func ReadStatus(dir string) (*Status, error) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, StatusFile))
+	if err != nil {
+		return nil, err
+	}
+	st := &Status{}
+	err = json.Unmarshal(b, st)
+	if err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// Standby maintains a standby copy of an environment.
+type Standby struct {
+	env  *lmdb.Env
+	opts Options
+	sub  *lmdb.Subscription
+	dst  *lmdb.Env
+	dbis map[string]lmdb.DBI // standby databases in changeset mode
+	last uint64              // id of the last event applied since the last copy
+
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu       sync.Mutex
+	status   Status
+	err      error
+	written  time.Time     // last write of the status file
+	progress chan struct{} // closed when the watermark advances
+	fenced   bool
+}
+
+var errNoDir = errors.New("lmdbstandby: no standby directory")
+
+// Start makes a first full copy of env to the standby and starts
+// maintaining it in the background.
+func Start(env *lmdb.Env, opts *Options) (*Standby, error) {
+	s := &Standby{env: env, done: make(chan struct{}), progress: make(chan struct{})}
+	if opts != nil {
+		s.opts = *opts
+	}
+	if s.opts.Dir == "" {
+		return nil, errNoDir
+	}
+	if s.opts.Interval <= 0 {
+		s.opts.Interval = time.Minute
+	}
+	s.status.Mode = "copy"
+	err := os.MkdirAll(s.opts.Dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	fence, err := Fenced(s.opts.Dir)
+	if err != nil {
+		return nil, err
+	}
+	if fence != nil {
+		return nil, &FencedError{Fence: *fence}
+	}
+
+	if s.opts.DBs != nil {
+		s.status.Mode = "changesets"
+		dbis := make([]lmdb.DBI, 0, len(s.opts.DBs))
+		for _, dbi := range s.opts.DBs {
+			dbis = append(dbis, dbi)
+		}
+		// subscribing first lets the changes committed during the copy be
+		// replayed over it, which is harmless as each change sets the state
+		// it describes.
+		s.sub, err = env.Subscribe(&lmdb.SubscribeOptions{
+			Buffer:   s.opts.Buffer,
+			Overflow: lmdb.OverflowDrop,
+			DBIs:     dbis,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = s.copy()
+	if err != nil {
+		s.close()
+		return nil, err
+	}
+
+	started := make(chan struct{})
+	err = env.Go("lmdbstandby", func(ctx context.Context) {
+		ctx, s.cancel = context.WithCancel(ctx)
+		close(started)
+		s.loop(ctx)
+	})
+	if err != nil {
+		s.close()
+		return nil, err
+	}
+	<-started
+	return s, nil
+}
+
+// Status returns the freshness of the standby.
+func (s *Standby) Status() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status
+}
+
+// Stop stops maintaining the standby, after recording its status one last
+// time, and returns the last error encountered, if any.
+func (s *Standby) Stop() error {
+	s.cancel()
+	<-s.done
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+func (s *Standby) close() {
+	if s.sub != nil {
+		s.sub.Close()
+	}
+	if s.dst != nil {
+		s.dst.Close()
+		s.dst = nil
+	}
+}
+
+func (s *Standby) loop(ctx context.Context) {
+	defer close(s.done)
+	defer s.close()
+	if s.sub == nil {
+		t := time.NewTicker(s.opts.Interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				s.fail(s.writeStatus())
+				return
+			case <-t.C:
+			}
+			if s.checkFence() {
+				return
+			}
+			s.fail(s.copy())
+		}
+	}
+
+	for ctx.Err() == nil {
+		if s.checkFence() {
+			return
+		}
+		info, err := s.env.Info()
+		if err != nil {
+			s.fail(err)
+			return
+		}
+		wait, cancel := context.WithTimeout(ctx, s.opts.Interval)
+		ev, err := s.sub.Next(wait)
+		cancel()
+		switch {
+		case err == context.DeadlineExceeded:
+			// every change published so far has been applied, including
+			// those of the transactions committed before waiting.
+			s.mu.Lock()
+			s.status.SyncedAt = time.Now()
+			s.advance(uint64(info.LastTxnID))
+			s.mu.Unlock()
+			s.fail(s.writeStatus())
+		case err != nil:
+			if ctx.Err() == nil {
+				s.fail(err)
+			}
+		case ev.Gap > 0, uint64(ev.TxnID) <= s.last:
+			// the events skip the commits filtered out, so the watermark
+			// only holds every commit below an event if events come in
+			// commit order.
+			s.fail(s.copy())
+		default:
+			s.fail(s.apply(&ev))
+		}
+	}
+	s.fail(s.writeStatus())
+}
+
+// fail records err, if not nil, in the status.
+func (s *Standby) fail(err error) {
+	if err == nil {
+		return
+	}
+	s.mu.Lock()
+	s.err = err
+	s.status.Error = err.Error()
+	s.mu.Unlock()
+}
+
+// checkFence reports whether the standby was promoted, in which case the
+// Standby stops and calls Options.OnFenced.
+func (s *Standby) checkFence() bool {
+	fence, err := Fenced(s.opts.Dir)
+	if err != nil {
+		s.fail(err)
+		return false
+	}
+	if fence == nil {
+		return false
+	}
+	s.mu.Lock()
+	s.err = &FencedError{Fence: *fence}
+	s.status.Error = s.err.Error()
+	s.fenced = true
+	s.mu.Unlock()
+	if s.opts.OnFenced != nil {
+		s.opts.OnFenced(fence)
+	}
+	return true
+}
+
+// advance raises the watermark to id, with s.mu held.
+func (s *Standby) advance(id uint64) {
+	if id <= s.status.TxnID {
+		return
+	}
+	s.status.TxnID = id
+	close(s.progress)
+	s.progress = make(chan struct{})
+}
+
+// copy replaces the standby with a full copy of the source.
+func (s *Standby) copy() error {
+	start := time.Now()
+	// the copy holds at least the transactions committed before it starts.
+	info, err := s.env.Info()
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempDir(filepath.Dir(filepath.Clean(s.opts.Dir)), ".standby-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	var flags uint
+	if !s.opts.NoCompact {
+		flags = lmdb.CopyCompact
+	}
+	err = s.env.CopyFlag(tmp, flags)
+	if err != nil {
+		return err
+	}
+
+	if s.dst != nil {
+		s.dst.Close()
+		s.dst = nil
+	}
+	err = os.Rename(filepath.Join(tmp, "data.mdb"), filepath.Join(s.opts.Dir, "data.mdb"))
+	if err != nil {
+		return err
+	}
+	if s.sub != nil {
+		err = s.openStandby()
+		if err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	s.status.Copies++
+	s.status.LastCopy = start
+	s.status.SyncedAt = start
+	s.status.Changes = 0
+	s.status.Error = ""
+	s.advance(uint64(info.LastTxnID))
+	s.mu.Unlock()
+	s.last = 0
+	return s.writeStatus()
+}
+
+// openStandby opens the standby environment and its databases for applying
+// changes.
+func (s *Standby) openStandby() error {
+	info, err := s.env.Info()
+	if err != nil {
+		return err
+	}
+	dst, err := lmdb.OpenEnv(s.opts.Dir, &lmdb.Options{
+		MaxDBs:  len(s.opts.DBs),
+		MapSize: info.MapSize,
+	})
+	if err != nil {
+		return err
+	}
+	dbis := make(map[string]lmdb.DBI, len(s.opts.DBs))
+	err = dst.Update(func(txn *lmdb.Txn) error {
+		for name := range s.opts.DBs {
+			var dbi lmdb.DBI
+			var err error
+			if name == "" {
+				dbi, err = txn.OpenRoot(0)
+			} else {
+				dbi, err = txn.OpenDBI(name, lmdb.Create)
+			}
+			if err != nil {
+				return err
+			}
+			dbis[name] = dbi
+		}
+		return nil
+	})
+	if err != nil {
+		dst.Close()
+		return err
+	}
+	s.dst, s.dbis = dst, dbis
+	return nil
+}
+
+// apply applies the changes of ev to the standby.
+func (s *Standby) apply(ev *lmdb.Event) error {
+	var b lmdb.WriteBatch
+	for _, op := range ev.Ops {
+		dbi, ok := s.standbyDBI(op.DBI)
+		if !ok {
+			continue
+		}
+		switch op.Type {
+		case lmdb.BatchPut:
+			b.PutFlags(dbi, op.Key, op.Val, op.Flags)
+		case lmdb.BatchDel:
+			b.Del(dbi, op.Key, op.Val)
+		case lmdb.BatchDropRange:
+			b.DropRange(dbi, op.Key, op.End)
+		}
+	}
+	err := s.dst.Apply(&b)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.status.SyncedAt = ev.Time
+	s.status.Changes++
+	s.last = uint64(ev.TxnID)
+	s.advance(s.last)
+	write := time.Since(s.written) >= s.opts.Interval
+	s.mu.Unlock()
+	if write {
+		return s.writeStatus()
+	}
+	return nil
+}
+
+// standbyDBI returns the standby database matching the source database dbi.
+func (s *Standby) standbyDBI(dbi lmdb.DBI) (lmdb.DBI, bool) {
+	for name, src := range s.opts.DBs {
+		if src == dbi {
+			return s.dbis[name], true
+		}
+	}
+	return 0, false
+}
+
+// writeStatus writes the status file atomically, unless the standby was
+// promoted.
+func (s *Standby) writeStatus() error {
+	s.mu.Lock()
+	if s.fenced {
+		s.mu.Unlock()
+		return nil
+	}
+	status := s.status
+	s.written = time.Now()
+	s.mu.Unlock()
+	return writeJSON(filepath.Join(s.opts.Dir, StatusFile), &status)
+}
+
+// writeJSON writes v to the file at path atomically.
+func writeJSON(path string, v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(path+".tmp", append(b, '\n'), 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
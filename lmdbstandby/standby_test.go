@@ -0,0 +1,145 @@
+package lmdbstandby
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/glycerine/lmdb-go/int/lmdbtest"
+	"github.com/glycerine/lmdb-go/lmdb"
+)
+
+func put(t *testing.T, env *lmdb.Env, dbi lmdb.DBI, k, v string) {
+	t.Helper()
+	err := env.Update(func(txn *lmdb.Txn) error {
+		return txn.Put(dbi, []byte(k), []byte(v), 0)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// waitFor waits until key has the value want in the standby in dir.
+func waitFor(t *testing.T, dir, name, key, want string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got, err := read(dir, name, key)
+		if err == nil && got == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("standby %s=%q (%v), want %q", key, got, err, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func read(dir, name, key string) (val string, err error) {
+	env, err := lmdb.OpenEnv(dir, &lmdb.Options{MaxDBs: 1, Flags: lmdb.Readonly | lmdb.NoLock})
+	if err != nil {
+		return "", err
+	}
+	defer env.Close()
+	err = env.View(func(txn *lmdb.Txn) error {
+		dbi, err := txn.OpenDBI(name, 0)
+		if err != nil {
+			return err
+		}
+		v, err := txn.Get(dbi, []byte(key))
+		val = string(v)
+		return err
+	})
+	return val, err
+}
+
+func setup(t *testing.T) (*lmdb.Env, lmdb.DBI, string) {
+	env, err := lmdbtest.NewEnv(&lmdbtest.EnvOptions{MaxDBs: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dbi lmdb.DBI
+	err = env.Update(func(txn *lmdb.Txn) (err error) {
+		dbi, err = txn.OpenDBI("data", lmdb.Create)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "lmdbstandby-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return env, dbi, dir
+}
+
+func TestStandby_copy(t *testing.T) {
+	env, dbi, dir := setup(t)
+	defer lmdbtest.Destroy(env)
+	defer os.RemoveAll(dir)
+	standby := filepath.Join(dir, "standby")
+
+	put(t, env, dbi, "k", "1")
+	s, err := Start(env, &Options{Dir: standby, Interval: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, standby, "data", "k", "1")
+	put(t, env, dbi, "k", "2")
+	waitFor(t, standby, "data", "k", "2")
+	err = s.Stop()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	st, err := ReadStatus(standby)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Mode != "copy" || st.Copies < 2 || st.Lag(st.LastCopy) != 0 {
+		t.Errorf("status %+v", st)
+	}
+}
+
+func TestStandby_changesets(t *testing.T) {
+	env, dbi, dir := setup(t)
+	defer lmdbtest.Destroy(env)
+	defer os.RemoveAll(dir)
+	standby := filepath.Join(dir, "standby")
+
+	put(t, env, dbi, "a", "1")
+	s, err := Start(env, &Options{
+		Dir:      standby,
+		Interval: 20 * time.Millisecond,
+		DBs:      map[string]lmdb.DBI{"data": dbi},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := time.Now()
+	put(t, env, dbi, "b", "2")
+	err = env.Update(func(txn *lmdb.Txn) error {
+		return txn.Del(dbi, []byte("a"), nil)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	err = s.Stop()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	st := s.Status()
+	if st.Mode != "changesets" || st.Copies != 1 || st.Changes != 2 || st.SyncedAt.Before(before) {
+		t.Errorf("status %+v", st)
+	}
+	if v, err := read(standby, "data", "b"); err != nil || v != "2" {
+		t.Errorf("b=%q (%v)", v, err)
+	}
+	if _, err := read(standby, "data", "a"); !lmdb.IsNotFound(err) {
+		t.Errorf("a not deleted: %v", err)
+	}
+}
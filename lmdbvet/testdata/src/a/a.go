@@ -0,0 +1,77 @@
+package a
+
+import "github.com/glycerine/lmdb-go/lmdb"
+
+var cache [][]byte
+
+func rawRead(env *lmdb.Env, dbi lmdb.DBI) ([]byte, error) {
+	var kept, copied []byte
+	ch := make(chan []byte, 1)
+	err := env.View(func(txn *lmdb.Txn) error {
+		txn.RawRead = true
+		v, err := txn.Get(dbi, []byte("k"))
+		if err != nil {
+			return err
+		}
+		kept = v                 // want "RawRead is retained"
+		cache = append(cache, v) // want "RawRead is retained"
+		ch <- v[1:]              // want "RawRead is retained"
+		copied = append([]byte(nil), v...)
+		s := string(v)
+		_ = s
+		kept, err = txn.Get(dbi, nil) // want "RawRead is retained"
+		return err
+	})
+	_ = kept
+	return copied, err
+}
+
+func copyRead(env *lmdb.Env, dbi lmdb.DBI) (kept []byte, err error) {
+	err = env.View(func(txn *lmdb.Txn) error {
+		kept, err = txn.Get(dbi, nil)
+		return err
+	})
+	return kept, err
+}
+
+func nested(env *lmdb.Env) error {
+	return env.View(func(txn *lmdb.Txn) error {
+		go env.Update(func(txn *lmdb.Txn) error { return nil })
+		return env.Update(func(txn *lmdb.Txn) error { return nil }) // want "Update called inside View"
+	})
+}
+
+func goroutines(env *lmdb.Env, dbi lmdb.DBI) error {
+	return env.Update(func(txn *lmdb.Txn) error {
+		cur, err := txn.OpenCursor(dbi)
+		if err != nil {
+			return err
+		}
+		defer cur.Close()
+		done := make(chan struct{})
+		go func() {
+			txn.Get(dbi, nil) // want "txn used by a goroutine"
+			close(done)
+		}()
+		go cur.Get(nil, nil, 0) // want "cur used by a goroutine"
+		<-done
+		return nil
+	})
+}
+
+func cursors(txn *lmdb.Txn, dbi lmdb.DBI) (*lmdb.Cursor, error) {
+	leaked, err := txn.OpenCursor(dbi) // want "cursor leaked is never closed"
+	if err != nil || leaked == nil {
+		return nil, err
+	}
+	leaked.Get(nil, nil, 0)
+
+	closed, err := txn.OpenCursor(dbi)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { closed.Close() }()
+
+	returned, err := txn.OpenCursor(dbi)
+	return returned, err
+}
@@ -0,0 +1,24 @@
+// Package lmdb declares the parts of the lmdb API the analyzer looks at.
+package lmdb
+
+type DBI uint
+
+type Env struct{}
+
+type TxnOp func(txn *Txn) error
+
+func (env *Env) View(fn TxnOp) error         { return nil }
+func (env *Env) Update(fn TxnOp) error       { return nil }
+func (env *Env) UpdateLocked(fn TxnOp) error { return nil }
+
+type Txn struct {
+	RawRead bool
+}
+
+func (txn *Txn) Get(dbi DBI, key []byte) ([]byte, error) { return nil, nil }
+func (txn *Txn) OpenCursor(dbi DBI) (*Cursor, error)     { return nil, nil }
+
+type Cursor struct{}
+
+func (c *Cursor) Get(k, v []byte, op uint) ([]byte, []byte, error) { return nil, nil, nil }
+func (c *Cursor) Close()                                           {}
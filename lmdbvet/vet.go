@@ -0,0 +1,394 @@
+/*
+Package lmdbvet defines an analyzer reporting common misuses of package lmdb,
+the ones behind most support issues.  The lmdbvet command runs it with
+go vet.
+
+The analyzer reports
+
+  - values read with RawRead set that are stored in variables outliving the
+    transaction, where they refer to memory that LMDB reuses once the
+    transaction ends;
+  - write transactions started inside View, which hold the write lock while
+    the goroutine holds a read transaction;
+  - transactions and cursors used from a goroutine other than the one that
+    runs the transaction, which LMDB does not allow;
+  - cursors that are never closed, which leak until their transaction ends
+    and, in read-only transactions, after it.
+
+The checks are syntactic heuristics on typed code.  They do not follow values
+through function calls, so a report is a strong hint rather than a proof, and
+the absence of reports is no guarantee.
+*/
+package lmdbvet
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+	"strings"
+
+	"golang.org/x/tools/go/analysis"
+)
+
+const lmdbPath = "github.com/glycerine/lmdb-go/lmdb"
+
+// Analyzer reports misuses of transactions and cursors.
+var Analyzer = &analysis.Analyzer{
+	Name: "lmdbvet",
+	Doc:  "report misuses of lmdb transactions and cursors",
+	Run:  run,
+}
+
+func run(pass *analysis.Pass) (interface{}, error) {
+	for _, f := range pass.Files {
+		ast.Inspect(f, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.CallExpr:
+				checkTxnOp(pass, n)
+			case *ast.GoStmt:
+				checkGo(pass, n)
+			case *ast.FuncDecl:
+				if n.Body != nil {
+					checkCursors(pass, n.Body)
+				}
+			case *ast.FuncLit:
+				checkCursors(pass, n.Body)
+			}
+			return true
+		})
+	}
+	return nil, nil
+}
+
+// method returns the receiver type and name of the lmdb method called by
+// call, or empty strings.
+func method(pass *analysis.Pass, call *ast.CallExpr) (typ, name string) {
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok {
+		return "", ""
+	}
+	fn, ok := pass.TypesInfo.Uses[sel.Sel].(*types.Func)
+	if !ok {
+		return "", ""
+	}
+	recv := fn.Type().(*types.Signature).Recv()
+	if recv == nil {
+		return "", ""
+	}
+	typ = lmdbType(recv.Type())
+	if typ == "" {
+		return "", ""
+	}
+	return typ, fn.Name()
+}
+
+// lmdbType returns the name of t, or of the type t points to, if it is
+// declared in package lmdb.
+func lmdbType(t types.Type) string {
+	if p, ok := t.(*types.Pointer); ok {
+		t = p.Elem()
+	}
+	named, ok := t.(*types.Named)
+	if !ok {
+		return ""
+	}
+	obj := named.Obj()
+	if obj.Pkg() == nil || obj.Pkg().Path() != lmdbPath {
+		return ""
+	}
+	return obj.Name()
+}
+
+func isUpdate(name string) bool {
+	return strings.HasPrefix(name, "Update")
+}
+
+func isView(name string) bool {
+	return strings.HasPrefix(name, "View")
+}
+
+// checkTxnOp checks the function literal passed to a method of Env running
+// a transaction.
+func checkTxnOp(pass *analysis.Pass, call *ast.CallExpr) {
+	typ, name := method(pass, call)
+	if typ != "Env" || !(isView(name) || isUpdate(name) || name == "RunTxn") || len(call.Args) == 0 {
+		return
+	}
+	lit, ok := call.Args[len(call.Args)-1].(*ast.FuncLit)
+	if !ok {
+		return
+	}
+	if isView(name) {
+		checkNestedUpdate(pass, lit)
+	}
+	checkRawRead(pass, lit)
+}
+
+// checkNestedUpdate reports write transactions started by the goroutine
+// running the read transaction lit.
+func checkNestedUpdate(pass *analysis.Pass, lit *ast.FuncLit) {
+	ast.Inspect(lit.Body, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.GoStmt:
+			return false
+		case *ast.CallExpr:
+			typ, name := method(pass, n)
+			if typ == "Env" && isUpdate(name) {
+				pass.Reportf(n.Pos(), "%s called inside View: the goroutine holds a read transaction while waiting for the write lock", name)
+			}
+		}
+		return true
+	})
+}
+
+// checkRawRead reports values read by Get in the transaction lit, when it
+// sets RawRead, that are stored in variables declared outside lit.
+func checkRawRead(pass *analysis.Pass, lit *ast.FuncLit) {
+	raw := false
+	ast.Inspect(lit.Body, func(n ast.Node) bool {
+		as, ok := n.(*ast.AssignStmt)
+		if !ok || len(as.Lhs) != 1 {
+			return true
+		}
+		sel, ok := as.Lhs[0].(*ast.SelectorExpr)
+		if ok && sel.Sel.Name == "RawRead" && lmdbType(pass.TypesInfo.TypeOf(sel.X)) == "Txn" {
+			if v, ok := as.Rhs[0].(*ast.Ident); !ok || v.Name != "false" {
+				raw = true
+			}
+		}
+		return true
+	})
+	if !raw {
+		return
+	}
+
+	outside := func(obj types.Object) bool {
+		return obj != nil && (obj.Pos() < lit.Pos() || obj.Pos() >= lit.End())
+	}
+	report := func(pos token.Pos) {
+		pass.Reportf(pos, "value read with RawRead is retained beyond the transaction; copy it")
+	}
+
+	// raw values are the results of Get held in variables of lit.
+	rawVars := make(map[types.Object]bool)
+	ast.Inspect(lit.Body, func(n ast.Node) bool {
+		as, ok := n.(*ast.AssignStmt)
+		if !ok || len(as.Rhs) != 1 {
+			return true
+		}
+		call, ok := as.Rhs[0].(*ast.CallExpr)
+		if !ok {
+			return true
+		}
+		typ, name := method(pass, call)
+		if name != "Get" || (typ != "Txn" && typ != "Cursor") {
+			return true
+		}
+		for i, lhs := range as.Lhs {
+			if i == len(as.Lhs)-1 {
+				break // the error
+			}
+			if obj := rootObject(pass, lhs); outside(obj) {
+				report(lhs.Pos())
+			} else if obj != nil {
+				rawVars[obj] = true
+			}
+		}
+		return true
+	})
+
+	isRaw := func(e ast.Expr) bool {
+		if s, ok := e.(*ast.SliceExpr); ok {
+			e = s.X
+		}
+		id, ok := e.(*ast.Ident)
+		return ok && rawVars[pass.TypesInfo.Uses[id]]
+	}
+	ast.Inspect(lit.Body, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.AssignStmt:
+			if len(n.Lhs) != len(n.Rhs) {
+				return true
+			}
+			for i, rhs := range n.Rhs {
+				if !outside(rootObject(pass, n.Lhs[i])) {
+					continue
+				}
+				if isRaw(rhs) {
+					report(rhs.Pos())
+				}
+				if call, ok := rhs.(*ast.CallExpr); ok && isBuiltin(pass, call.Fun, "append") && !call.Ellipsis.IsValid() {
+					for _, arg := range call.Args[1:] {
+						if isRaw(arg) {
+							report(arg.Pos())
+						}
+					}
+				}
+			}
+		case *ast.SendStmt:
+			if isRaw(n.Value) {
+				report(n.Value.Pos())
+			}
+		}
+		return true
+	})
+}
+
+// rootObject returns the variable that e, an assignable expression, is
+// part of.
+func rootObject(pass *analysis.Pass, e ast.Expr) types.Object {
+	for {
+		switch x := e.(type) {
+		case *ast.Ident:
+			if x.Name == "_" {
+				return nil
+			}
+			return pass.TypesInfo.ObjectOf(x)
+		case *ast.IndexExpr:
+			e = x.X
+		case *ast.SelectorExpr:
+			e = x.X
+		case *ast.StarExpr:
+			e = x.X
+		case *ast.ParenExpr:
+			e = x.X
+		default:
+			return nil
+		}
+	}
+}
+
+func isBuiltin(pass *analysis.Pass, fun ast.Expr, name string) bool {
+	id, ok := fun.(*ast.Ident)
+	if !ok {
+		return false
+	}
+	b, ok := pass.TypesInfo.Uses[id].(*types.Builtin)
+	return ok && b.Name() == name
+}
+
+// checkGo reports transactions and cursors captured by, or passed to, a new
+// goroutine.  Cursors the goroutine renews into a transaction of its own are
+// allowed.
+func checkGo(pass *analysis.Pass, stmt *ast.GoStmt) {
+	outside := func(id *ast.Ident) *types.Var {
+		obj, ok := pass.TypesInfo.Uses[id].(*types.Var)
+		if !ok || obj.Pos() >= stmt.Pos() && obj.Pos() < stmt.End() {
+			return nil
+		}
+		if _, ptr := obj.Type().(*types.Pointer); !ptr {
+			return nil
+		}
+		return obj
+	}
+	renewed := make(map[*types.Var]bool)
+	ast.Inspect(stmt.Call, func(n ast.Node) bool {
+		if sel, ok := n.(*ast.SelectorExpr); ok && sel.Sel.Name == "Renew" {
+			if id, ok := sel.X.(*ast.Ident); ok {
+				if obj := outside(id); obj != nil {
+					renewed[obj] = true
+				}
+			}
+		}
+		return true
+	})
+	ast.Inspect(stmt.Call, func(n ast.Node) bool {
+		id, ok := n.(*ast.Ident)
+		if !ok {
+			return true
+		}
+		obj := outside(id)
+		if obj == nil {
+			return true
+		}
+		typ := lmdbType(obj.Type())
+		if typ == "Txn" || typ == "Cursor" && !renewed[obj] {
+			pass.Reportf(id.Pos(), "%s used by a goroutine other than the one running its transaction", id.Name)
+			return false
+		}
+		return true
+	})
+}
+
+// checkCursors reports cursors opened in body, outside nested functions,
+// that are neither closed nor handed to other code.
+func checkCursors(pass *analysis.Pass, body *ast.BlockStmt) {
+	type opened struct {
+		call *ast.CallExpr
+		name string
+	}
+	cursors := make(map[types.Object]opened)
+	ast.Inspect(body, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FuncLit:
+			return false
+		case *ast.AssignStmt:
+			if len(n.Rhs) != 1 {
+				return true
+			}
+			call, ok := n.Rhs[0].(*ast.CallExpr)
+			if !ok {
+				return true
+			}
+			typ, name := method(pass, call)
+			if typ != "Txn" || (name != "OpenCursor" && name != "OpenIndexCursor") {
+				return true
+			}
+			id, ok := n.Lhs[0].(*ast.Ident)
+			if !ok || id.Name == "_" {
+				return true
+			}
+			// cursors assigned to variables of enclosing functions are
+			// checked there.
+			obj := pass.TypesInfo.ObjectOf(id)
+			if obj != nil && obj.Pos() >= body.Pos() && obj.Pos() < body.End() {
+				cursors[obj] = opened{call: call, name: id.Name}
+			}
+		}
+		return true
+	})
+	if len(cursors) == 0 {
+		return
+	}
+
+	// a cursor is accounted for once closed or used other than as the
+	// receiver of a method or in a comparison.
+	handled := make(map[types.Object]bool)
+	var stack []ast.Node
+	ast.Inspect(body, func(n ast.Node) bool {
+		if n == nil {
+			stack = stack[:len(stack)-1]
+			return true
+		}
+		stack = append(stack, n)
+		id, ok := n.(*ast.Ident)
+		if !ok || len(stack) < 2 {
+			return true
+		}
+		obj := pass.TypesInfo.Uses[id]
+		if _, ok := cursors[obj]; !ok {
+			return true
+		}
+		switch parent := stack[len(stack)-2].(type) {
+		case *ast.SelectorExpr:
+			if parent.Sel.Name == "Close" {
+				handled[obj] = true
+			}
+		case *ast.AssignStmt:
+			for _, rhs := range parent.Rhs {
+				if rhs == id {
+					handled[obj] = true
+				}
+			}
+		case *ast.BinaryExpr:
+		default:
+			handled[obj] = true
+		}
+		return true
+	})
+	for obj, c := range cursors {
+		if !handled[obj] {
+			pass.Reportf(c.call.Pos(), "cursor %s is never closed", c.name)
+		}
+	}
+}
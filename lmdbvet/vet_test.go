@@ -0,0 +1,118 @@
+package lmdbvet
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"golang.org/x/tools/go/analysis"
+)
+
+// testImporter type checks the packages of testdata/src, standing in for
+// their import paths, so that the tests do not depend on cgo.
+type testImporter struct {
+	fset *token.FileSet
+	pkgs map[string]*types.Package
+}
+
+func (imp *testImporter) Import(path string) (*types.Package, error) {
+	if pkg, ok := imp.pkgs[path]; ok {
+		return pkg, nil
+	}
+	if path != lmdbPath {
+		return nil, fmt.Errorf("unexpected import %q", path)
+	}
+	pkg, _, _, err := imp.check(path, filepath.Join("testdata", "src", "lmdb"))
+	return pkg, err
+}
+
+func (imp *testImporter) check(path, dir string) (*types.Package, []*ast.File, *types.Info, error) {
+	names, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var files []*ast.File
+	for _, name := range names {
+		f, err := parser.ParseFile(imp.fset, name, nil, parser.ParseComments)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		files = append(files, f)
+	}
+	info := &types.Info{
+		Types: make(map[ast.Expr]types.TypeAndValue),
+		Defs:  make(map[*ast.Ident]types.Object),
+		Uses:  make(map[*ast.Ident]types.Object),
+	}
+	conf := types.Config{Importer: imp}
+	pkg, err := conf.Check(path, imp.fset, files, info)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	imp.pkgs[path] = pkg
+	return pkg, files, info, nil
+}
+
+var wantRE = regexp.MustCompile(`// want "([^"]*)"`)
+
+func TestAnalyzer(t *testing.T) {
+	imp := &testImporter{fset: token.NewFileSet(), pkgs: make(map[string]*types.Package)}
+	pkg, files, info, err := imp.check("a", filepath.Join("testdata", "src", "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// expected diagnostics, by line.
+	want := make(map[int]*regexp.Regexp)
+	for _, f := range files {
+		for _, group := range f.Comments {
+			for _, c := range group.List {
+				m := wantRE.FindStringSubmatch(c.Text)
+				if m != nil {
+					want[imp.fset.Position(c.Pos()).Line] = regexp.MustCompile(m[1])
+				}
+			}
+		}
+	}
+
+	var got []analysis.Diagnostic
+	pass := &analysis.Pass{
+		Analyzer:  Analyzer,
+		Fset:      imp.fset,
+		Files:     files,
+		Pkg:       pkg,
+		TypesInfo: info,
+		Report:    func(d analysis.Diagnostic) { got = append(got, d) },
+	}
+	_, err = Analyzer.Run(pass)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, d := range got {
+		line := imp.fset.Position(d.Pos).Line
+		re, ok := want[line]
+		if !ok {
+			t.Errorf("line %d: unexpected diagnostic %q", line, d.Message)
+			continue
+		}
+		if !re.MatchString(d.Message) {
+			t.Errorf("line %d: diagnostic %q does not match %q", line, d.Message, re)
+		}
+		delete(want, line)
+	}
+	var missing []string
+	for line, re := range want {
+		missing = append(missing, strconv.Itoa(line)+": "+re.String())
+	}
+	if len(missing) > 0 {
+		t.Errorf("missing diagnostics: %s", strings.Join(missing, ", "))
+	}
+}
@@ -0,0 +1,402 @@
+/*
+Package lmdbview maintains materialized views: databases derived from a source
+database by a user function and kept up to date incrementally as the source
+changes.
+
+A View maps every item of its source database to zero or more derived items
+of its target database.  Writes to the source go through a Views value, which
+looks up the items a write replaces, performs the write, and then retracts
+the derived items of the replaced source items and adds those of the new
+ones, all within the caller's transaction, so views never lag behind their
+sources.  Changes arrive either as single Put and Del calls or as
+lmdb.WriteBatch values, which makes it possible to maintain views on a
+replica applying changesets decoded with lmdb.WriteBatch.Unmarshal.
+
+Two kinds of views are supported.  A plain view stores the derived items as
+is; when several source items may derive the same key the target should be a
+DupSort database, otherwise the last write wins and retracting an item only
+removes it if its value is unchanged.  An aggregate view, for the "keep a
+summary table in sync" pattern, treats derived values as int64 deltas
+(encoded with Delta) and stores their sum per key, removing keys whose sum
+drops to zero.  Counts and sums grouped by any function of the source items
+are aggregate views.
+
+Writes made to a source without going through its Views leave the views
+stale until they are rebuilt with Views.Rebuild.
+*/
+package lmdbview
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+
+	"github.com/glycerine/lmdb-go/lmdb"
+)
+
+// ErrDelta is returned when an aggregate view derives, or stores, a value
+// that is not an 8 byte delta.
+var ErrDelta = errors.New("lmdbview: aggregate value is not 8 bytes")
+
+var errOpType = errors.New("lmdbview: unknown batch operation type")
+
+// MapFunc derives the items of a view from the source item key, val by
+// calling emit for each of them.  It must be deterministic, since the items
+// derived from a source item are derived again to retract them when the item
+// is replaced or deleted.  The arguments must not be retained; emit copies
+// its arguments.
+type MapFunc func(key, val []byte, emit func(key, val []byte))
+
+// View describes a materialized view of Source in Target.
+type View struct {
+	Source lmdb.DBI
+	Target lmdb.DBI
+	Map    MapFunc
+
+	// Aggregate makes the view store the sum of the deltas derived for each
+	// key instead of the derived items.
+	Aggregate bool
+}
+
+// Delta encodes n as a value derived for an aggregate view.
+func Delta(n int64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(n))
+	return b
+}
+
+// Sum decodes a value of an aggregate view.
+func Sum(val []byte) (int64, error) {
+	if len(val) != 8 {
+		return 0, ErrDelta
+	}
+	return int64(binary.BigEndian.Uint64(val)), nil
+}
+
+// Views routes writes of source databases to the views derived from them.
+// The zero value has no views.  Views is safe for concurrent use once every
+// view has been registered.
+type Views struct {
+	bySource map[lmdb.DBI][]*View
+}
+
+// New returns a Views maintaining the given views.
+func New(views ...*View) *Views {
+	vs := &Views{}
+	for _, v := range views {
+		vs.Register(v)
+	}
+	return vs
+}
+
+// Register adds v to the views maintained by vs.  A view registered over a
+// non-empty source should be built with Rebuild.
+func (vs *Views) Register(v *View) {
+	if vs.bySource == nil {
+		vs.bySource = make(map[lmdb.DBI][]*View)
+	}
+	vs.bySource[v.Source] = append(vs.bySource[v.Source], v)
+}
+
+// item is a source item whose derived items are added or retracted.
+type item struct {
+	key, val []byte
+}
+
+// Put performs txn.Put(dbi, key, val, flags) and updates the views of dbi.
+func (vs *Views) Put(txn *lmdb.Txn, dbi lmdb.DBI, key, val []byte, flags uint) error {
+	views := vs.bySource[dbi]
+	if len(views) == 0 {
+		return txn.Put(dbi, key, val, flags)
+	}
+	dup, err := isDupSort(txn, dbi)
+	if err != nil {
+		return err
+	}
+
+	var old []item
+	if dup {
+		ok, err := hasPair(txn, dbi, key, val)
+		if err != nil {
+			return err
+		}
+		if ok {
+			// a DupSort database stores each pair once.
+			return txn.Put(dbi, key, val, flags)
+		}
+	} else {
+		v, err := txn.Get(dbi, key)
+		if err == nil {
+			old = append(old, item{key, clone(v)})
+		} else if !lmdb.IsNotFound(err) {
+			return err
+		}
+	}
+
+	err = txn.Put(dbi, key, val, flags)
+	if err != nil {
+		return err
+	}
+	return update(txn, views, old, []item{{key, val}})
+}
+
+// Del performs txn.Del(dbi, key, val) and updates the views of dbi.
+func (vs *Views) Del(txn *lmdb.Txn, dbi lmdb.DBI, key, val []byte) error {
+	views := vs.bySource[dbi]
+	if len(views) == 0 {
+		return txn.Del(dbi, key, val)
+	}
+	dup, err := isDupSort(txn, dbi)
+	if err != nil {
+		return err
+	}
+
+	var old []item
+	switch {
+	case dup && val != nil:
+		ok, err := hasPair(txn, dbi, key, val)
+		if err != nil {
+			return err
+		}
+		if ok {
+			old = append(old, item{key, val})
+		}
+	case dup:
+		old, err = dups(txn, dbi, key)
+		if err != nil {
+			return err
+		}
+	default:
+		v, err := txn.Get(dbi, key)
+		if err == nil {
+			old = append(old, item{key, clone(v)})
+		} else if !lmdb.IsNotFound(err) {
+			return err
+		}
+	}
+
+	err = txn.Del(dbi, key, val)
+	if err != nil {
+		return err
+	}
+	return update(txn, views, old, nil)
+}
+
+// Apply performs the operations of b within txn, as txn.Apply does, and
+// updates the views of the databases they write.
+func (vs *Views) Apply(txn *lmdb.Txn, b *lmdb.WriteBatch) error {
+	for _, op := range b.Ops() {
+		var err error
+		switch op.Type {
+		case lmdb.BatchPut:
+			err = vs.Put(txn, op.DBI, op.Key, op.Val, op.Flags)
+		case lmdb.BatchDel:
+			err = vs.Del(txn, op.DBI, op.Key, op.Val)
+			if lmdb.IsNotFound(err) {
+				err = nil
+			}
+		case lmdb.BatchDropRange:
+			err = vs.dropRange(txn, op.DBI, op.Key, op.End)
+		default:
+			err = errOpType
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dropRange deletes the items of dbi with keys in [start, end), retracting
+// their derived items.
+func (vs *Views) dropRange(txn *lmdb.Txn, dbi lmdb.DBI, start, end []byte) error {
+	views := vs.bySource[dbi]
+	cur, err := txn.OpenCursor(dbi)
+	if err != nil {
+		return err
+	}
+	defer cur.Close()
+	var k, v []byte
+	if len(start) == 0 {
+		k, v, err = cur.Get(nil, nil, lmdb.First)
+	} else {
+		k, v, err = cur.Get(start, nil, lmdb.SetRange)
+	}
+	for {
+		if lmdb.IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if end != nil && bytes.Compare(k, end) >= 0 {
+			return nil
+		}
+		err = update(txn, views, []item{{k, v}}, nil)
+		if err != nil {
+			return err
+		}
+		err = cur.Del(0)
+		if err != nil {
+			return err
+		}
+		k, v, err = cur.Get(nil, nil, lmdb.Next)
+	}
+}
+
+// Rebuild empties the target of v and derives it again from every item of
+// its source.
+func (vs *Views) Rebuild(txn *lmdb.Txn, v *View) error {
+	err := txn.Drop(v.Target, false)
+	if err != nil {
+		return err
+	}
+	cur, err := txn.OpenCursor(v.Source)
+	if err != nil {
+		return err
+	}
+	defer cur.Close()
+	views := []*View{v}
+	for {
+		k, val, err := cur.Get(nil, nil, lmdb.Next)
+		if lmdb.IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		err = update(txn, views, nil, []item{{k, val}})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// update retracts the items derived from old and adds those derived from
+// added in each of views.
+func update(txn *lmdb.Txn, views []*View, old, added []item) error {
+	for _, v := range views {
+		var derived []item
+		emit := func(key, val []byte) {
+			derived = append(derived, item{clone(key), clone(val)})
+		}
+		for _, it := range old {
+			v.Map(it.key, it.val, emit)
+		}
+		nold := len(derived)
+		for _, it := range added {
+			v.Map(it.key, it.val, emit)
+		}
+		for i, d := range derived {
+			var err error
+			if v.Aggregate {
+				err = v.add(txn, d, i >= nold)
+			} else if i < nold {
+				err = v.retract(txn, d)
+			} else {
+				err = txn.Put(v.Target, d.key, d.val, 0)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// retract removes the derived item d from the target of a plain view.
+func (v *View) retract(txn *lmdb.Txn, d item) error {
+	dup, err := isDupSort(txn, v.Target)
+	if err != nil {
+		return err
+	}
+	if dup {
+		err = txn.Del(v.Target, d.key, d.val)
+	} else {
+		var cur []byte
+		cur, err = txn.Get(v.Target, d.key)
+		if err == nil && bytes.Equal(cur, d.val) {
+			err = txn.Del(v.Target, d.key, nil)
+		}
+	}
+	if lmdb.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// add adds (or, if !sign, subtracts) the delta d to the sum stored for its
+// key in the target of an aggregate view.
+func (v *View) add(txn *lmdb.Txn, d item, sign bool) error {
+	delta, err := Sum(d.val)
+	if err != nil {
+		return err
+	}
+	if !sign {
+		delta = -delta
+	}
+	var sum int64
+	cur, err := txn.Get(v.Target, d.key)
+	if err == nil {
+		sum, err = Sum(cur)
+	}
+	if err != nil && !lmdb.IsNotFound(err) {
+		return err
+	}
+	sum += delta
+	if sum == 0 {
+		err = txn.Del(v.Target, d.key, nil)
+		if lmdb.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	return txn.Put(v.Target, d.key, Delta(sum), 0)
+}
+
+// clone copies a value read from the source before it is overwritten, in
+// case txn.RawRead is set.
+func clone(b []byte) []byte {
+	return append([]byte(nil), b...)
+}
+
+func isDupSort(txn *lmdb.Txn, dbi lmdb.DBI) (bool, error) {
+	flags, err := txn.Flags(dbi)
+	if err != nil {
+		return false, err
+	}
+	return flags&lmdb.DupSort != 0, nil
+}
+
+// hasPair reports whether the DupSort database dbi holds val under key.
+func hasPair(txn *lmdb.Txn, dbi lmdb.DBI, key, val []byte) (bool, error) {
+	cur, err := txn.OpenCursor(dbi)
+	if err != nil {
+		return false, err
+	}
+	defer cur.Close()
+	_, _, err = cur.Get(key, val, lmdb.GetBoth)
+	if lmdb.IsNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// dups returns the items stored under key in the DupSort database dbi.
+func dups(txn *lmdb.Txn, dbi lmdb.DBI, key []byte) ([]item, error) {
+	cur, err := txn.OpenCursor(dbi)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close()
+	var items []item
+	_, v, err := cur.Get(key, nil, lmdb.SetKey)
+	for err == nil {
+		items = append(items, item{key, clone(v)})
+		_, v, err = cur.Get(nil, nil, lmdb.NextDup)
+	}
+	if lmdb.IsNotFound(err) {
+		return items, nil
+	}
+	return nil, err
+}
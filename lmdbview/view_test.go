@@ -0,0 +1,154 @@
+package lmdbview
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/glycerine/lmdb-go/int/lmdbtest"
+	"github.com/glycerine/lmdb-go/lmdb"
+)
+
+func dump(t *testing.T, txn *lmdb.Txn, dbi lmdb.DBI, agg bool) []string {
+	cur, err := txn.OpenCursor(dbi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cur.Close()
+	var items []string
+	for {
+		k, v, err := cur.Get(nil, nil, lmdb.Next)
+		if lmdb.IsNotFound(err) {
+			return items
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if agg {
+			n, err := Sum(v)
+			if err != nil {
+				t.Fatal(err)
+			}
+			items = append(items, fmt.Sprintf("%s=%d", k, n))
+		} else {
+			items = append(items, fmt.Sprintf("%s=%s", k, v))
+		}
+	}
+}
+
+// users maps user ids to "city:name" values.
+func byCity(key, val []byte, emit func(key, val []byte)) {
+	i := bytes.IndexByte(val, ':')
+	if i < 0 {
+		return
+	}
+	emit(val[:i], key)
+}
+
+func countByCity(key, val []byte, emit func(key, val []byte)) {
+	i := bytes.IndexByte(val, ':')
+	if i < 0 {
+		return
+	}
+	emit(val[:i], Delta(1))
+}
+
+func TestViews(t *testing.T) {
+	env, err := lmdbtest.NewEnv(&lmdbtest.EnvOptions{MaxDBs: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lmdbtest.Destroy(env)
+
+	var users, index, counts lmdb.DBI
+	err = env.Update(func(txn *lmdb.Txn) (err error) {
+		users, err = txn.OpenDBI("users", lmdb.Create)
+		if err != nil {
+			return err
+		}
+		index, err = txn.OpenDBI("by-city", lmdb.Create|lmdb.DupSort)
+		if err != nil {
+			return err
+		}
+		counts, err = txn.OpenDBI("city-counts", lmdb.Create)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx := &View{Source: users, Target: index, Map: byCity}
+	cnt := &View{Source: users, Target: counts, Map: countByCity, Aggregate: true}
+	vs := New(idx, cnt)
+
+	check := func(wantIndex, wantCounts []string) {
+		t.Helper()
+		err := env.View(func(txn *lmdb.Txn) error {
+			if got := dump(t, txn, index, false); !reflect.DeepEqual(got, wantIndex) {
+				t.Errorf("index: %q, want %q", got, wantIndex)
+			}
+			if got := dump(t, txn, counts, true); !reflect.DeepEqual(got, wantCounts) {
+				t.Errorf("counts: %q, want %q", got, wantCounts)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = env.Update(func(txn *lmdb.Txn) error {
+		for _, kv := range [][2]string{{"u1", "paris:ann"}, {"u2", "oslo:bob"}, {"u3", "paris:cid"}} {
+			err := vs.Put(txn, users, []byte(kv[0]), []byte(kv[1]), 0)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	check([]string{"oslo=u2", "paris=u1", "paris=u3"}, []string{"oslo=1", "paris=2"})
+
+	// replacing and deleting items retracts what they derived.
+	var b lmdb.WriteBatch
+	b.Put(users, []byte("u1"), []byte("oslo:ann"))
+	b.Del(users, []byte("u2"), nil)
+	b.Del(users, []byte("missing"), nil)
+	b.Put(users, []byte("u4"), []byte("rome:dan"))
+	err = env.Update(func(txn *lmdb.Txn) error {
+		return vs.Apply(txn, &b)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	check([]string{"oslo=u1", "paris=u3", "rome=u4"}, []string{"oslo=1", "paris=1", "rome=1"})
+
+	b.Reset()
+	b.DropRange(users, []byte("u3"), nil)
+	err = env.Update(func(txn *lmdb.Txn) error {
+		return vs.Apply(txn, &b)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	check([]string{"oslo=u1"}, []string{"oslo=1"})
+
+	// writes bypassing the views are picked up by a rebuild.
+	err = env.Update(func(txn *lmdb.Txn) error {
+		err := txn.Put(users, []byte("u5"), []byte("oslo:eve"), 0)
+		if err != nil {
+			return err
+		}
+		err = vs.Rebuild(txn, idx)
+		if err != nil {
+			return err
+		}
+		return vs.Rebuild(txn, cnt)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	check([]string{"oslo=u1", "oslo=u5"}, []string{"oslo=2"})
+}